@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gollaborate/messages"
+	"gollaborate/users"
+)
+
+// adminNode is the node ID admin commands are sent as, as they edit nothing
+const adminNode = 0
+
+// adminCommands describes the commands 'gollaborate admin' runs
+const adminCommands = `Commands:
+  list-clients          List the connected clients
+  kick USER-ID          Disconnect every connection of a user
+  broadcast-notice TEXT Show a notice to everyone connected
+  save-now              Save the document to the server's store
+  export [FORMAT]       Write the document to standard output as text, json or archive
+  import FORMAT FILE    Restore a document exported as text, json or archive into
+                        the new room given with --room (FILE - reads standard input)
+  set-readonly on|off   Turn the document's read-only mode on or off
+  freeze                Freeze the document as final, refusing every further edit
+  unfreeze              Take edits to a frozen document again
+  audit [N]             Show who edited the document, when and how much (the last N changes)
+  invite [ROLE] [FOR]   Mint a join code for the document, for a viewer, editor or admin,
+                        lasting FOR, such as 1h (15m when not given)
+  forget USER-ID        Remove a user's profile and presence, and their name from the
+                        audit trails, which keep their edits under an anonymous ID
+  stats                 Show the server's statistics`
+
+// runAdmin runs a command on a running server over its admin channel
+func runAdmin(args []string) {
+	fs := flag.NewFlagSet("admin", flag.ExitOnError)
+	socket := fs.String("socket", "", "Unix socket the server takes admin commands on, as given to serve --admin-socket")
+	addr := fs.String("addr", "", "Address the server takes admin commands on, as given to serve --admin-addr")
+	token := fs.String("token", "", "Token the server asks admins for, as given to serve --admin-token")
+	room := fs.String("room", "", "Room to save, export, audit, freeze, invite to or set read-only (the server's own document when empty), or to import into")
+	publicAddr := fs.String("public-addr", "", "Address clients join the server at, to print an invite link rather than a join code with invite")
+	timeout := fs.Duration("timeout", 10*time.Second, "How long to wait for the server")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gollaborate admin [flags] COMMAND [ARGS]")
+		fmt.Fprintln(fs.Output(), adminCommands)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	msg, err := adminRequest(fs.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fs.Usage()
+		os.Exit(2)
+	}
+	msg.Room = *room
+	if msg.Command == messages.AdminCommandImport && *room == "" {
+		fmt.Fprintln(os.Stderr, "Give the new room to import into with --room")
+		os.Exit(2)
+	}
+
+	network, address := "unix", *socket
+	if *addr != "" {
+		network, address = "tcp", *addr
+	}
+	if address == "" {
+		fmt.Fprintln(os.Stderr, "Give the server's admin channel with --socket or --addr")
+		os.Exit(2)
+	}
+	reply, err := sendAdminCommand(network, address, *token, msg, *timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to run %s: %v\n", msg.Command, err)
+		os.Exit(1)
+	}
+	if msg.Command == messages.AdminCommandExport {
+		// Written as it is, to be imported again
+		_, _ = os.Stdout.WriteString(reply.Text)
+	} else if msg.Command == messages.AdminCommandInvite && *publicAddr != "" {
+		fmt.Println(inviteLink(*publicAddr, *room, reply.Text))
+	} else if reply.Text != "" {
+		fmt.Print(strings.TrimSuffix(reply.Text, "\n") + "\n")
+	} else {
+		fmt.Printf("The server ran %s\n", msg.Command)
+	}
+}
+
+// adminRequest makes the admin message a command line asks for
+func adminRequest(args []string) (*messages.Message, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no command given")
+	}
+	command, rest := args[0], args[1:]
+	switch {
+	case command == "list-clients" && len(rest) == 0:
+		return messages.NewAdminMessage(messages.AdminCommandClients, 0, adminNode), nil
+	case command == "kick" && len(rest) == 1:
+		id, err := strconv.Atoi(strings.TrimPrefix(rest[0], "User-"))
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("invalid user ID %q", rest[0])
+		}
+		return messages.NewAdminMessage(messages.AdminCommandKick, id, adminNode), nil
+	case command == "forget" && len(rest) == 1:
+		id, err := strconv.Atoi(strings.TrimPrefix(rest[0], "User-"))
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("invalid user ID %q", rest[0])
+		}
+		return messages.NewAdminMessage(messages.AdminCommandForget, id, adminNode), nil
+	case command == "broadcast-notice" && len(rest) > 0:
+		return messages.NewNoticeMessage(strings.Join(rest, " "), adminNode), nil
+	case command == "save-now" && len(rest) == 0:
+		return messages.NewAdminMessage(messages.AdminCommandSave, 0, adminNode), nil
+	case command == "export" && len(rest) <= 1:
+		format := messages.ExportFormatText
+		if len(rest) == 1 {
+			format = messages.ExportFormat(rest[0])
+		}
+		if !validExportFormat(format) {
+			return nil, fmt.Errorf("unknown format %q, expected one of %v", format, messages.ExportFormats())
+		}
+		return messages.NewExportMessage(format, adminNode), nil
+	case command == "import" && len(rest) == 2:
+		format := messages.ExportFormat(rest[0])
+		if !validExportFormat(format) {
+			return nil, fmt.Errorf("unknown format %q, expected one of %v", format, messages.ExportFormats())
+		}
+		data, err := readImport(rest[1])
+		if err != nil {
+			return nil, err
+		}
+		return messages.NewImportMessage("", format, string(data), adminNode), nil
+	case command == "set-readonly" && len(rest) == 1 && (rest[0] == "on" || rest[0] == "off"):
+		if rest[0] == "on" {
+			return messages.NewAdminMessage(messages.AdminCommandLock, 0, adminNode), nil
+		}
+		return messages.NewAdminMessage(messages.AdminCommandUnlock, 0, adminNode), nil
+	case command == "freeze" && len(rest) == 0:
+		return messages.NewAdminMessage(messages.AdminCommandFreeze, 0, adminNode), nil
+	case command == "unfreeze" && len(rest) == 0:
+		return messages.NewAdminMessage(messages.AdminCommandUnfreeze, 0, adminNode), nil
+	case command == "audit" && len(rest) <= 1:
+		last := 0
+		if len(rest) == 1 {
+			n, err := strconv.Atoi(rest[0])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid number of changes %q", rest[0])
+			}
+			last = n
+		}
+		return messages.NewAdminMessage(messages.AdminCommandAudit, last, adminNode), nil
+	case command == "invite" && len(rest) <= 2:
+		role, lifetime := "", 0
+		for _, arg := range rest {
+			if d, err := time.ParseDuration(arg); err == nil && d >= time.Second {
+				lifetime = int(d / time.Second)
+			} else if _, err := users.ParseRole(arg); err == nil && role == "" {
+				role = arg
+			} else {
+				return nil, fmt.Errorf("invalid role or lifetime %q", arg)
+			}
+		}
+		return messages.NewInviteMessage(role, lifetime, adminNode), nil
+	case command == "stats" && len(rest) == 0:
+		return messages.NewAdminMessage(messages.AdminCommandStats, 0, adminNode), nil
+	}
+	return nil, fmt.Errorf("unknown command or wrong arguments: %s", strings.Join(args, " "))
+}
+
+// validExportFormat reports whether documents can be exported in a format
+func validExportFormat(format messages.ExportFormat) bool {
+	for _, known := range messages.ExportFormats() {
+		if format == known {
+			return true
+		}
+	}
+	return false
+}
+
+// readImport reads the document to import from a file, or standard input for -
+func readImport(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// sendAdminCommand sends an admin command over a server's admin channel,
+// presenting the token first if there is one, and waits for the answer
+func sendAdminCommand(network, address, token string, msg *messages.Message, timeout time.Duration) (*messages.Message, error) {
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	if token != "" {
+		if err := messages.SendMessage(conn, messages.NewAuthMessage(token, "", adminNode)); err != nil {
+			return nil, err
+		}
+	}
+	if err := messages.SendMessage(conn, msg); err != nil {
+		return nil, err
+	}
+	reply, err := messages.NewReader(conn).Receive()
+	if err != nil {
+		return nil, err
+	}
+	if reply.Type == messages.MessageTypeError {
+		return nil, errors.New(reply.Error)
+	}
+	return reply, nil
+}
+
+// inviteScheme starts the invite links 'gollaborate admin invite' prints
+const inviteScheme = "gollaborate"
+
+// inviteLink makes a link inviting someone to join a room of the server at
+// addr with a join code, which --join takes in place of an address
+func inviteLink(addr, room, code string) string {
+	link := url.URL{Scheme: inviteScheme, Host: joinableAddr(addr), Path: "/" + room, RawQuery: url.Values{"code": {code}}.Encode()}
+	return link.String()
+}
+
+// parseInviteLink reads the server address, room and join code of an invite
+// link, reporting whether it is one
+func parseInviteLink(link string) (addr, room, code string, ok bool) {
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != inviteScheme || u.Host == "" {
+		return "", "", "", false
+	}
+	return joinableAddr(u.Host), strings.TrimPrefix(u.Path, "/"), u.Query().Get("code"), true
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"gollaborate/autosave"
+	"gollaborate/messages"
+	"gollaborate/shared"
+	"gollaborate/storage"
+)
+
+// autosaveFlags are the command line settings for saving documents while they
+// are edited
+type autosaveFlags struct {
+	interval *time.Duration
+	ops      *int
+	onLeave  *bool
+	keep     *int
+}
+
+// addAutosaveFlags defines the autosave flags on a flag set, saying in
+// leaveUsage who leaving saves the document
+func addAutosaveFlags(flags *flag.FlagSet, leaveUsage string) autosaveFlags {
+	return autosaveFlags{
+		interval: flags.Duration("autosave-interval", 0, "Save the document this often while it changes, such as 5m (0 never does)"),
+		ops:      flags.Int("autosave-ops", 0, "Save the document after this many edits (0 never does)"),
+		onLeave:  flags.Bool("autosave-on-leave", false, leaveUsage),
+		keep:     flags.Int("autosave-keep", 0, "Keep only this many of the latest snapshots saving leaves of each document (0 keeps all)"),
+	}
+}
+
+// policy is the autosave policy the flags give
+func (a autosaveFlags) policy() autosave.Policy {
+	return autosave.Policy{Interval: *a.interval, Ops: *a.ops, OnLastLeave: *a.onLeave, Keep: *a.keep}
+}
+
+// startAutosave saves the editor's document by a policy while it is edited:
+// to its file, if it has one, and as a snapshot in a file store in dir, of
+// which the policy's latest are kept. It returns nil if the policy never saves.
+func startAutosave(editorState *shared.EditorState, policy autosave.Policy, file, dir string, logger *slog.Logger) (*autosave.Saver, error) {
+	if !policy.Enabled() {
+		return nil, nil
+	}
+	store, err := storage.OpenFileStore(dir)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", dir, err)
+	}
+	name := "untitled"
+	if file != "" {
+		name = filepath.Base(file)
+	}
+
+	save := func() error {
+		// A copy, as the editor carries on changing the document
+		doc, err := editorState.Fork()
+		if err != nil {
+			return err
+		}
+		if file != "" {
+			if err := saveDocument(file, doc); err != nil {
+				return err
+			}
+		}
+		if err := store.SaveSnapshot(name, time.Now(), doc); err != nil {
+			return err
+		}
+		if err := storage.PruneSnapshots(store, name, policy.Keep); err != nil {
+			return err
+		}
+		logger.Info("Document autosaved", "file", file, "snapshots", dir)
+		return nil
+	}
+	saver := autosave.New(policy, save, func(err error) {
+		logger.Warn("Failed to autosave the document", "err", err)
+	})
+	editorState.RecordOps(func(ops []*messages.Operation) {
+		saver.Edited(len(ops))
+	})
+	editorState.AddCloseListener(func(messages.Transport) {
+		if len(editorState.Connections()) == 0 {
+			saver.LastLeft()
+		}
+	})
+	return saver, nil
+}
@@ -0,0 +1,130 @@
+// Package autosave saves documents while they are edited: on an interval,
+// every so many operations and once the last of their editors leaves.
+package autosave
+
+import (
+	"sync"
+	"time"
+)
+
+// Policy says when a document is saved automatically, and how many of the
+// snapshots saving it leaves are kept. Documents are only saved if they
+// changed since last time. The zero Policy never saves.
+type Policy struct {
+	Interval    time.Duration // Save this often, zero never
+	Ops         int           // Save after this many operations, zero never
+	OnLastLeave bool          // Save when the last editor leaves
+	Keep        int           // Snapshots to keep, the latest; zero keeps every one
+}
+
+// Enabled reports whether the policy ever saves
+func (p Policy) Enabled() bool {
+	return p.Interval > 0 || p.Ops > 0 || p.OnLastLeave
+}
+
+// Saver saves one document by a policy. Saves run one at a time on a
+// goroutine of the Saver's own, so Edited and LastLeft may be called with
+// locks held that the save takes.
+type Saver struct {
+	policy  Policy
+	save    func() error
+	onError func(error)
+
+	mutex   sync.Mutex
+	ops     int  // Since the last save
+	changed bool // Since the last save
+
+	trigger chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// New starts saving a document by a policy, with save, which tells onError
+// why it failed if it does
+func New(policy Policy, save func() error, onError func(error)) *Saver {
+	s := &Saver{
+		policy:  policy,
+		save:    save,
+		onError: onError,
+		trigger: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Edited tells the saver that operations were applied to the document
+func (s *Saver) Edited(ops int) {
+	s.mutex.Lock()
+	s.ops += ops
+	s.changed = true
+	due := s.policy.Ops > 0 && s.ops >= s.policy.Ops
+	s.mutex.Unlock()
+	if due {
+		s.Trigger()
+	}
+}
+
+// LastLeft tells the saver that the last editor of the document left
+func (s *Saver) LastLeft() {
+	if s.policy.OnLastLeave {
+		s.Trigger()
+	}
+}
+
+// Trigger saves the document soon, if it changed
+func (s *Saver) Trigger() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+		// A save is already due
+	}
+}
+
+// Close stops saving, waiting for a save under way to finish
+func (s *Saver) Close() {
+	s.once.Do(func() { close(s.done) })
+	<-s.stopped
+}
+
+// run saves the document whenever a save is due, until closed
+func (s *Saver) run() {
+	defer close(s.stopped)
+	var tick <-chan time.Time
+	if s.policy.Interval > 0 {
+		ticker := time.NewTicker(s.policy.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-tick:
+		case <-s.trigger:
+		}
+		s.saveIfChanged()
+	}
+}
+
+// saveIfChanged saves the document unless it is as last saved. Changes made
+// while saving are saved next time; a failed save is tried again then.
+func (s *Saver) saveIfChanged() {
+	s.mutex.Lock()
+	changed := s.changed
+	s.ops, s.changed = 0, false
+	s.mutex.Unlock()
+	if !changed {
+		return
+	}
+	if err := s.save(); err != nil {
+		s.mutex.Lock()
+		s.changed = true
+		s.mutex.Unlock()
+		if s.onError != nil {
+			s.onError(err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package autosave
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countSaves returns a save function telling a channel of each save, failing
+// the first so many times
+func countSaves(failures int) (func() error, chan struct{}) {
+	var mutex sync.Mutex
+	saved := make(chan struct{}, 16)
+	return func() error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if failures > 0 {
+			failures--
+			return errors.New("disk full")
+		}
+		saved <- struct{}{}
+		return nil
+	}, saved
+}
+
+// expectSaves waits for so many saves, and no more
+func expectSaves(t *testing.T, saved chan struct{}, n int) {
+	t.Helper()
+
+	for i := range n {
+		select {
+		case <-saved:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected %d saves, got %d", n, i)
+		}
+	}
+	select {
+	case <-saved:
+		t.Fatalf("Expected only %d saves", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSaverOps(t *testing.T) {
+	save, saved := countSaves(0)
+	s := New(Policy{Ops: 3, OnLastLeave: true}, save, nil)
+	defer s.Close()
+
+	s.Edited(2)
+	expectSaves(t, saved, 0)
+	s.Edited(1)
+	expectSaves(t, saved, 1)
+
+	// Only a changed document is saved when the last editor leaves
+	s.LastLeft()
+	expectSaves(t, saved, 0)
+	s.Edited(1)
+	s.LastLeft()
+	expectSaves(t, saved, 1)
+}
+
+func TestSaverInterval(t *testing.T) {
+	save, saved := countSaves(1)
+	failed := make(chan error, 16)
+	s := New(Policy{Interval: 10 * time.Millisecond}, save, func(err error) { failed <- err })
+	defer s.Close()
+
+	expectSaves(t, saved, 0)
+	s.Edited(1)
+	select {
+	case <-failed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the failed save reported")
+	}
+	// Tried again, as the document is still not saved
+	expectSaves(t, saved, 1)
+
+	if (Policy{Keep: 5}).Enabled() {
+		t.Error("Expected a policy that never saves disabled")
+	}
+}
@@ -11,7 +11,7 @@ if exist %APP_NAME% del %APP_NAME%
 
 REM Build main executable
 echo Building executable...
-go build -o %APP_NAME% main.go
+go build -o %APP_NAME% .
 
 if errorlevel 1 (
     echo Build failed!
@@ -28,9 +28,32 @@ echo.
 echo To connect to other instances:
 echo   %APP_NAME% --port 8081 --join 127.0.0.1:8080
 echo.
+echo To host a document on a headless server with a live dashboard:
+echo   %APP_NAME% serve --port 8080 --file document.txt --admin-tui
+echo.
+echo To run admin commands on a server that is already running:
+echo   %APP_NAME% serve --port 8080 --admin-addr 127.0.0.1:8090 --admin-token s3cret
+echo   %APP_NAME% admin --addr 127.0.0.1:8090 --token s3cret list-clients
+echo.
+echo To back a document up and restore it into a new room:
+echo   %APP_NAME% admin --addr 127.0.0.1:8090 --token s3cret export archive ^> backup.json
+echo   %APP_NAME% admin --addr 127.0.0.1:8090 --token s3cret --room restored import archive backup.json
+echo.
+echo To share documents between servers behind a load balancer:
+echo   %APP_NAME% serve --port 8080 --node 1 --store file --store-path \\fileserver\docs --pubsub nats://127.0.0.1:4222
+echo   %APP_NAME% serve --port 8081 --node 2 --store file --store-path \\fileserver\docs --pubsub nats://127.0.0.1:4222
+echo.
 echo To load a file:
 echo   %APP_NAME% --port 8080 --file document.txt
 echo.
+echo To record a session and export it as an asciinema replay:
+echo   %APP_NAME% --port 8080 --file document.txt --record session.json
+echo   %APP_NAME% replay --in session.json --out session.cast
+echo.
+echo To list recent files and peers, then reopen the most recent one:
+echo   %APP_NAME% recent
+echo   %APP_NAME% --resume 1
+echo.
 echo You can open multiple terminals and run on different ports, connecting them as desired.
 echo.
 echo To run all integration/unit tests:
@@ -12,7 +12,7 @@ rm -f $APP_NAME
 
 # Build main executable
 echo "Building executable..."
-go build -o $APP_NAME ./main.go
+go build -o $APP_NAME .
 
 echo "Build complete!"
 echo "Executable: ./$APP_NAME"
@@ -27,9 +27,32 @@ echo ""
 echo "To connect to other instances:"
 echo "  ./$APP_NAME --port 8081 --join 127.0.0.1:8080"
 echo ""
+echo "To host a document on a headless server with a live dashboard:"
+echo "  ./$APP_NAME serve --port 8080 --file document.txt --admin-tui"
+echo ""
+echo "To run admin commands on a server that is already running:"
+echo "  ./$APP_NAME serve --port 8080 --admin-socket /tmp/gollaborate.sock"
+echo "  ./$APP_NAME admin --socket /tmp/gollaborate.sock list-clients"
+echo ""
+echo "To back a document up and restore it into a new room:"
+echo "  ./$APP_NAME admin --socket /tmp/gollaborate.sock export archive > backup.json"
+echo "  ./$APP_NAME admin --socket /tmp/gollaborate.sock --room restored import archive backup.json"
+echo ""
+echo "To share documents between servers behind a load balancer:"
+echo "  ./$APP_NAME serve --port 8080 --node 1 --store file --store-path /shared/docs --pubsub redis://127.0.0.1:6379"
+echo "  ./$APP_NAME serve --port 8081 --node 2 --store file --store-path /shared/docs --pubsub redis://127.0.0.1:6379"
+echo ""
 echo "To load a file:"
 echo "  ./$APP_NAME --port 8080 --file document.txt"
 echo ""
+echo "To record a session and export it as an asciinema replay:"
+echo "  ./$APP_NAME --port 8080 --file document.txt --record session.json"
+echo "  ./$APP_NAME replay --in session.json --out session.cast"
+echo ""
+echo "To list recent files and peers, then reopen the most recent one:"
+echo "  ./$APP_NAME recent"
+echo "  ./$APP_NAME --resume 1"
+echo ""
 echo "You can open multiple terminals and run on different ports, connecting them as desired."
 echo ""
 echo "To run all integration/unit tests:"
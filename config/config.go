@@ -0,0 +1,191 @@
+// Package config reads settings from a file, for commands that would otherwise
+// need a long line of flags. Settings are named as the flags are, so that each
+// flag can be given either way.
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Values are the settings read from a file, by flag name. Nested settings are
+// named by joining their keys with dashes, so that tls: {cert: FILE} sets
+// tls-cert, and lists are joined with commas.
+type Values map[string]string
+
+// Formats returns the file extensions Load understands
+func Formats() []string {
+	return []string{".yaml", ".yml", ".toml", ".json"}
+}
+
+// Load reads the settings in a file, in the format its extension names
+func Load(path string) (Values, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values, err := Parse(data, filepath.Ext(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+// Parse reads settings in the format a file extension names. YAML and TOML
+// are read as far as settings need: tables or mappings of strings, numbers,
+// booleans and lists of these.
+func Parse(data []byte, format string) (Values, error) {
+	switch strings.ToLower(format) {
+	case ".yaml", ".yml":
+		return parseYAML(data)
+	case ".toml":
+		return parseTOML(data)
+	case ".json":
+		return parseJSON(data)
+	}
+	return nil, fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(Formats(), ", "))
+}
+
+// Apply sets the flags the values name, except those given on the command
+// line, which take precedence over the file. A setting naming no flag is an
+// error, as it is most likely misspelled.
+func (v Values) Apply(fs *flag.FlagSet) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, name := range slices.Sorted(maps.Keys(v)) {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown setting %q", name)
+		}
+		if given[name] {
+			continue
+		}
+		if err := fs.Set(name, v[name]); err != nil {
+			return fmt.Errorf("setting %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// set records a setting, refusing one set twice
+func (v Values) set(name, value string) error {
+	if _, ok := v[name]; ok {
+		return fmt.Errorf("%s set twice", name)
+	}
+	v[name] = value
+	return nil
+}
+
+// parseJSON reads settings from a JSON object
+func parseJSON(data []byte) (Values, error) {
+	var object map[string]any
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+	values := make(Values)
+	return values, values.flatten("", object)
+}
+
+// flatten records the settings in a decoded JSON object, their names prefixed
+func (v Values) flatten(prefix string, object map[string]any) error {
+	for key, value := range object {
+		var err error
+		switch value := value.(type) {
+		case map[string]any:
+			err = v.flatten(prefix+key+"-", value)
+		case []any:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			err = v.set(prefix+key, strings.Join(items, ","))
+		case nil:
+		default:
+			err = v.set(prefix+key, fmt.Sprint(value))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scalar reads a value as YAML and TOML write them: a quoted string, a list
+// in brackets or anything else as it is
+func scalar(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("malformed string %s", s)
+		}
+		return unquoted, nil
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return "", fmt.Errorf("unterminated list %s", s)
+		}
+		var items []string
+		for _, item := range split(s[1:len(s)-1], ',') {
+			if strings.TrimSpace(item) == "" {
+				continue
+			}
+			value, err := scalar(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, value)
+		}
+		return strings.Join(items, ","), nil
+	case strings.HasPrefix(s, "{"):
+		return "", fmt.Errorf("inline tables are not supported: %s", s)
+	case strings.HasPrefix(s, "\"") || strings.HasPrefix(s, "'"):
+		return "", fmt.Errorf("unterminated string %s", s)
+	}
+	return s, nil
+}
+
+// split splits s at each separator outside quotes
+func split(s string, separator byte) []string {
+	var parts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0 && c == '\\' && quote == '"':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == separator:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// stripComment removes a comment from a line: from a # outside quotes that
+// starts the line or follows a space, so that one in a URL is kept
+func stripComment(line string) string {
+	parts := split(line, '#')
+	kept := parts[0]
+	for _, part := range parts[1:] {
+		if strings.TrimSpace(kept) == "" || strings.HasSuffix(kept, " ") || strings.HasSuffix(kept, "\t") {
+			break
+		}
+		kept += "#" + part
+	}
+	return kept
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"flag"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// want is what each example below reads as
+var want = Values{
+	"port":          "9000",
+	"store":         "bolt",
+	"store-path":    "/var/lib/gollaborate # kept",
+	"tls":           "true",
+	"tls-cert":      "server.pem",
+	"max-clients":   "200",
+	"session-grace": "5m",
+	"pubsub":        "redis://cache:6379/#0",
+	"peers":         "a:1,b:2",
+}
+
+func TestParse(t *testing.T) {
+	for format, data := range map[string]string{
+		".yaml": `
+# The server's settings
+port: 9000
+store: bolt
+store-path: "/var/lib/gollaborate # kept"
+tls: true
+tls-cert: 'server.pem'
+max:
+  clients: 200 # over every document
+session-grace: 5m
+pubsub: redis://cache:6379/#0
+peers:
+- a:1
+- "b:2"
+`,
+		".toml": `
+port = 9000
+store = "bolt"
+store-path = "/var/lib/gollaborate # kept"
+tls = true
+tls-cert = 'server.pem'
+session-grace = "5m"
+pubsub = "redis://cache:6379/#0"
+peers = ["a:1", "b:2"]
+
+[max]
+clients = 200 # over every document
+`,
+		".json": `{"port": 9000, "store": "bolt", "store-path": "/var/lib/gollaborate # kept", "tls": true, "tls-cert": "server.pem",
+			"max": {"clients": 200}, "session-grace": "5m", "pubsub": "redis://cache:6379/#0", "peers": ["a:1", "b:2"], "unset": null}`,
+	} {
+		values, err := Parse([]byte(data), format)
+		if err != nil {
+			t.Errorf("Failed to parse %s: %v", format, err)
+			continue
+		}
+		if !maps.Equal(values, want) {
+			t.Errorf("Expected %v from %s, got %v", want, format, values)
+		}
+	}
+
+	// Nested blocks and lists indented under their key
+	values, err := Parse([]byte("tls:\n  cert: a.pem\n  key: a.key\nauth:\n  users:\n    - ann\n    - bob\nport: 1\n"), ".yml")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if nested := (Values{"tls-cert": "a.pem", "tls-key": "a.key", "auth-users": "ann,bob", "port": "1"}); !maps.Equal(values, nested) {
+		t.Errorf("Expected %v, got %v", nested, values)
+	}
+
+	for format, data := range map[string]string{
+		".yaml": "port: 1\nport: 2",
+		".yml":  "port:1",
+		".toml": "port",
+		".json": "[1]",
+		".ini":  "port=1",
+	} {
+		if _, err := Parse([]byte(data), format); err == nil {
+			t.Errorf("Expected %q refused as %s", data, format)
+		}
+	}
+	for _, data := range []string{"[[servers]]", `motd = """`, "port = 'open", "[tls", "tls = {cert = 'a'}"} {
+		if _, err := Parse([]byte(data), ".toml"); err == nil {
+			t.Errorf("Expected %q refused", data)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	if err := os.WriteFile(path, []byte("port: 9000\nmax-wait: 1m\nstore: bolt\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	port := fs.Int("port", 8080, "")
+	maxWait := fs.Duration("max-wait", 30*time.Second, "")
+	store := fs.String("store", "", "")
+	if err := fs.Parse([]string{"--port", "7000"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	values, err := Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if err := values.Apply(fs); err != nil {
+		t.Fatalf("Failed to apply config: %v", err)
+	}
+	if *port != 7000 || *maxWait != time.Minute || *store != "bolt" {
+		t.Errorf("Expected the flag given to win over the file, got %d %s %q", *port, *maxWait, *store)
+	}
+
+	if err := (Values{"prot": "1"}).Apply(fs); err == nil || !strings.Contains(err.Error(), "prot") {
+		t.Errorf("Expected a misspelled setting refused, got %v", err)
+	}
+	fs = flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.Duration("max-wait", 30*time.Second, "")
+	if err := (Values{"max-wait": "soon"}).Apply(fs); err == nil {
+		t.Error("Expected a malformed value refused")
+	}
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseTOML reads settings from TOML tables of keys whose values are strings,
+// numbers, booleans or arrays of these on one line
+func parseTOML(data []byte) (Values, error) {
+	values := make(Values)
+	prefix := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		text := strings.TrimSpace(stripComment(line))
+		switch {
+		case text == "":
+			continue
+		case strings.HasPrefix(text, "[["):
+			return nil, fmt.Errorf("line %d: arrays of tables are not supported", i+1)
+		case strings.HasPrefix(text, "["):
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("line %d: unterminated table header", i+1)
+			}
+			table, err := tomlKey(text[1 : len(text)-1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			prefix = table + "-"
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", i+1)
+		}
+		if strings.HasPrefix(strings.TrimSpace(value), `"""`) || strings.HasPrefix(strings.TrimSpace(value), "'''") {
+			return nil, fmt.Errorf("line %d: multi-line strings are not supported", i+1)
+		}
+		name, err := tomlKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if value, err = scalar(value); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if err := values.set(prefix+name, value); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+	}
+	return values, nil
+}
+
+// tomlKey names the setting a key, dotted or quoted, sets
+func tomlKey(key string) (string, error) {
+	var parts []string
+	for _, part := range split(key, '.') {
+		part, err := scalar(part)
+		if err != nil {
+			return "", err
+		}
+		if part == "" {
+			return "", fmt.Errorf("empty key in %q", strings.TrimSpace(key))
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "-"), nil
+}
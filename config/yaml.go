@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// yamlParent is a key whose value is the block indented below it: a mapping
+// of further settings or a list
+type yamlParent struct {
+	indent int
+	name   string
+	list   bool
+}
+
+// parseYAML reads settings from YAML block mappings, nested by indentation,
+// whose values are scalars, lists in brackets or lists of "- " items
+func parseYAML(data []byte) (Values, error) {
+	values := make(Values)
+	var parents []yamlParent
+	for i, line := range strings.Split(string(data), "\n") {
+		text := strings.TrimRight(stripComment(line), " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: indented with a tab", i+1)
+		}
+		indent := len(text) - len(trimmed)
+
+		// A list item belongs to the key above it, which may be as indented
+		if trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			for len(parents) > 0 && parents[len(parents)-1].indent > indent {
+				parents = parents[:len(parents)-1]
+			}
+			if len(parents) == 0 {
+				return nil, fmt.Errorf("line %d: list item outside a setting", i+1)
+			}
+			parent := &parents[len(parents)-1]
+			item, err := scalar(strings.TrimPrefix(trimmed, "-"))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			if parent.list {
+				item = values[parent.name] + "," + item
+			}
+			parent.list = true
+			values[parent.name] = item
+			continue
+		}
+
+		for len(parents) > 0 && parents[len(parents)-1].indent >= indent {
+			parents = parents[:len(parents)-1]
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok || (value != "" && value[0] != ' ') {
+			return nil, fmt.Errorf("line %d: expected key: value", i+1)
+		}
+		key, err := scalar(key)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		name := key
+		if len(parents) > 0 {
+			parent := parents[len(parents)-1]
+			if parent.list {
+				return nil, fmt.Errorf("line %d: %s is a list", i+1, parent.name)
+			}
+			// The parent is a mapping, not a setting of its own
+			delete(values, parent.name)
+			name = parent.name + "-" + key
+		}
+		if value, err = scalar(value); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if err := values.set(name, value); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if value == "" {
+			parents = append(parents, yamlParent{indent: indent, name: name})
+		}
+	}
+	return values, nil
+}
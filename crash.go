@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"gollaborate/crash"
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/shared"
+)
+
+// crashTailLength is how many of the latest edits a crash report lists
+const crashTailLength = 50
+
+// newCrashReporter makes panics in a session write a crash report to dir, save
+// the document next to it and warn peers, and sets it on the editor state
+func newCrashReporter(dir string, editorState *shared.EditorState) *crash.Reporter {
+	reporter := crash.New(dir)
+	reporter.SetAutosave(func(path string) error {
+		doc := editorState.Document()
+		if doc == nil {
+			return errors.New("no document loaded")
+		}
+		return saveDocument(path, doc)
+	})
+	reporter.SetTail(func() []string {
+		return recentEdits(editorState.Document(), crashTailLength)
+	})
+	reporter.SetNotify(func(p *crash.Panic) {
+		moduleLogger("crash").Error("Recovered from a panic", "panic", p)
+		nodeID := editorState.NodeID()
+		warning := fmt.Sprintf("User-%d hit an internal error and may be out of sync", nodeID)
+		editorState.BroadcastMessage(messages.NewErrorMessage(warning, nodeID))
+	})
+	editorState.SetCrashReporter(reporter)
+	return reporter
+}
+
+// recentEdits describes the last n edits in a document's history, oldest first
+func recentEdits(doc *crdt.Document, n int) []string {
+	if doc == nil || doc.History() == nil {
+		return nil
+	}
+	entries := doc.History().Entries
+	entries = entries[max(0, len(entries)-n):]
+
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		action := fmt.Sprintf("insert %q", entry.Char.Value)
+		if entry.Delete {
+			action = "delete"
+		}
+		lines[i] = fmt.Sprintf("%s %s at %v", entry.Time.Format("15:04:05.000"), action, entry.Char.Pos)
+	}
+	return lines
+}
@@ -0,0 +1,142 @@
+// Package crash turns panics into crash reports. A Reporter recovers a panic,
+// saves what it can of the document, writes a report with the stack and the
+// most recent edits, and tells whoever should know, so one bad message or
+// keystroke does not take a session down with it.
+package crash
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Panic is a recovered panic and where its report was written
+type Panic struct {
+	Where  string // What was running, such as "message handler"
+	Value  any    // The value passed to panic
+	Report string // Path of the crash report, empty if it could not be written
+}
+
+func (p *Panic) Error() string {
+	if p.Report == "" {
+		return fmt.Sprintf("panic in %s: %v", p.Where, p.Value)
+	}
+	return fmt.Sprintf("panic in %s: %v (report in %s)", p.Where, p.Value, p.Report)
+}
+
+// Reporter writes crash reports to a directory. A nil Reporter recovers
+// nothing, so panics behave as usual.
+type Reporter struct {
+	dir string
+
+	mutex    sync.Mutex
+	autosave func(path string) error
+	tail     func() []string
+	notify   func(*Panic)
+	now      func() time.Time
+}
+
+// New creates a Reporter that writes reports to dir
+func New(dir string) *Reporter {
+	return &Reporter{dir: dir, now: time.Now}
+}
+
+// SetAutosave sets the function that saves the document to the given path when
+// a panic is recovered, so the work survives even if the session does not
+func (r *Reporter) SetAutosave(autosave func(path string) error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.autosave = autosave
+}
+
+// SetTail sets the function that lists the most recent edits for reports
+func (r *Reporter) SetTail(tail func() []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.tail = tail
+}
+
+// SetNotify sets the function told about every recovered panic, such as one
+// that warns peers
+func (r *Reporter) SetNotify(notify func(*Panic)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.notify = notify
+}
+
+// Recover reports a panic in progress. Defer it directly:
+//
+//	defer reporter.Recover("heartbeat")
+func (r *Reporter) Recover(where string) {
+	if r == nil {
+		return
+	}
+	r.Handle(where, recover())
+}
+
+// Handle reports value if it came from recover, and reports whether there was a
+// panic. Call it from a deferred function that needs to clean up after one:
+//
+//	defer func() {
+//		if reporter.Handle("message handler", recover()) {
+//			conn.Close()
+//		}
+//	}()
+//
+// A nil Reporter panics again with the value.
+func (r *Reporter) Handle(where string, value any) bool {
+	if value == nil {
+		return false
+	}
+	if r == nil {
+		panic(value)
+	}
+	r.report(where, value, debug.Stack())
+	return true
+}
+
+// report saves the document, writes the report and notifies
+func (r *Reporter) report(where string, value any, stack []byte) {
+	r.mutex.Lock()
+	autosave, tail, notify := r.autosave, r.tail, r.notify
+	stamp := r.now().Format("20060102-150405.000000")
+	r.mutex.Unlock()
+
+	base := filepath.Join(r.dir, "gollaborate-crash-"+stamp)
+	saved := "not configured"
+	if autosave != nil {
+		if err := autosave(base + ".txt"); err != nil {
+			saved = "failed: " + err.Error()
+		} else {
+			saved = base + ".txt"
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Gollaborate crash report\n\n")
+	fmt.Fprintf(&b, "Time:     %s\n", r.now().Format(time.RFC3339))
+	fmt.Fprintf(&b, "Where:    %s\n", where)
+	fmt.Fprintf(&b, "Panic:    %v\n", value)
+	fmt.Fprintf(&b, "Autosave: %s\n", saved)
+	if tail != nil {
+		fmt.Fprintf(&b, "\nRecent edits, oldest first:\n")
+		for _, line := range tail() {
+			fmt.Fprintf(&b, "  %s\n", line)
+		}
+	}
+	fmt.Fprintf(&b, "\nStack:\n%s", stack)
+
+	p := &Panic{Where: where, Value: value}
+	if err := os.MkdirAll(r.dir, 0o755); err == nil {
+		if err := os.WriteFile(base+".log", []byte(b.String()), 0o644); err == nil {
+			p.Report = base + ".log"
+		}
+	}
+	if notify != nil {
+		notify(p)
+	}
+}
@@ -0,0 +1,101 @@
+package crash
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecoverWritesReport(t *testing.T) {
+	dir := t.TempDir()
+	r := New(dir)
+	r.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+
+	var savedTo string
+	r.SetAutosave(func(path string) error {
+		savedTo = path
+		return os.WriteFile(path, []byte("hello"), 0o644)
+	})
+	r.SetTail(func() []string { return []string{"insert 'h'", "insert 'i'"} })
+	notified := make(chan *Panic, 1)
+	r.SetNotify(func(p *Panic) { notified <- p })
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer r.Recover("message handler")
+		panic("bad position")
+	}()
+	<-done
+
+	var p *Panic
+	select {
+	case p = <-notified:
+	default:
+		t.Fatal("Expected the panic to be reported")
+	}
+	if p.Where != "message handler" || p.Value != "bad position" || p.Report == "" {
+		t.Fatalf("Expected a report of the panic, got %+v", p)
+	}
+	if !strings.Contains(p.Error(), p.Report) {
+		t.Errorf("Expected the error to name the report, got %q", p.Error())
+	}
+
+	data, err := os.ReadFile(p.Report)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	report := string(data)
+	for _, want := range []string{"Where:    message handler", "Panic:    bad position", "Autosave: " + savedTo, "insert 'i'", "crash_test.go"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("Expected the report to contain %q, got:\n%s", want, report)
+		}
+	}
+	if text, err := os.ReadFile(savedTo); err != nil || string(text) != "hello" {
+		t.Errorf("Expected the document to be autosaved, got %q (%v)", text, err)
+	}
+}
+
+func TestHandleReportsFailedAutosave(t *testing.T) {
+	r := New(t.TempDir())
+	r.SetAutosave(func(string) error { return errors.New("disk full") })
+	var p *Panic
+	r.SetNotify(func(got *Panic) { p = got })
+
+	cleaned := false
+	func() {
+		defer func() {
+			if r.Handle("editor update", recover()) {
+				cleaned = true
+			}
+		}()
+		panic(errors.New("index out of range"))
+	}()
+
+	if !cleaned || p == nil {
+		t.Fatal("Expected Handle to report the panic")
+	}
+	data, _ := os.ReadFile(p.Report)
+	if !strings.Contains(string(data), "Autosave: failed: disk full") {
+		t.Errorf("Expected the failed autosave in the report, got:\n%s", data)
+	}
+	if r.Handle("editor update", nil) {
+		t.Error("Expected no panic without a recovered value")
+	}
+}
+
+func TestNilReporterPanics(t *testing.T) {
+	var r *Reporter
+	defer func() {
+		if v := recover(); v != "boom" {
+			t.Errorf("Expected the panic to go on, got %v", v)
+		}
+	}()
+	func() {
+		defer r.Recover("anything")
+		panic("boom")
+	}()
+	t.Error("Expected the panic to reach the caller")
+}
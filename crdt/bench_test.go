@@ -0,0 +1,116 @@
+package crdt
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// typeAt inserts a character at text coordinates the way an editor does
+func typeAt(b *testing.B, doc *Document, line, column int, char rune, node, clock int) {
+	pos, err := doc.GeneratePositionAt(line, column, node)
+	if err != nil {
+		b.Fatalf("Failed to generate position: %v", err)
+	}
+	if err := doc.InsertCharacter(char, pos, clock); err != nil {
+		b.Fatalf("Failed to insert: %v", err)
+	}
+}
+
+// reportCounters adds the document's counters to the benchmark output
+func reportCounters(b *testing.B, doc *Document) {
+	s := doc.Counters().Snapshot()
+	b.ReportMetric(s.AvgIdentifierLength, "idents/char")
+	b.ReportMetric(float64(s.OpsApplied)/float64(b.N), "ops/op")
+}
+
+// BenchmarkSequentialTyping types one character at a time at the end of the
+// document, starting a new line every 80 characters
+func BenchmarkSequentialTyping(b *testing.B) {
+	doc := FromText("", 1)
+	doc.EnableCounters()
+	b.ReportAllocs()
+
+	line, column := 1, 1
+	for i := 0; i < b.N; i++ {
+		char := rune('a' + i%26)
+		if column > 80 {
+			char = '\n'
+		}
+		typeAt(b, doc, line, column, char, 1, i+1)
+		if char == '\n' {
+			line, column = line+1, 1
+		} else {
+			column++
+		}
+	}
+	reportCounters(b, doc)
+}
+
+// BenchmarkRandomInserts inserts characters at random places in a single line
+func BenchmarkRandomInserts(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	doc := FromText("", 1)
+	doc.EnableCounters()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		column := 1 + rng.Intn(len(doc.Lines[0].Characters)+1)
+		typeAt(b, doc, 1, column, rune('a'+i%26), 1, i+1)
+	}
+	reportCounters(b, doc)
+}
+
+// BenchmarkHugePaste pastes 100,000 characters into the middle of a document
+func BenchmarkHugePaste(b *testing.B) {
+	paste := []rune(strings.Repeat("The quick brown fox jumps over the lazy dog.\n", 100000/45))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		doc := FromText("before\nafter", 1)
+		doc.EnableCounters()
+		b.StartTimer()
+
+		line, column := 1, 7
+		for j, char := range paste {
+			typeAt(b, doc, line, column, char, 2, j+100)
+			if char == '\n' {
+				line, column = line+1, 1
+			} else {
+				column++
+			}
+		}
+		if i == b.N-1 {
+			b.ReportMetric(doc.Counters().Snapshot().AvgIdentifierLength, "idents/char")
+		}
+	}
+}
+
+// BenchmarkManyPeers has eight peers take turns inserting at random places and
+// applies every insert to a second replica, as a relay would
+func BenchmarkManyPeers(b *testing.B) {
+	const peers = 8
+	rng := rand.New(rand.NewSource(1))
+	doc := FromText("", 1)
+	replica := FromText("", 1)
+	doc.EnableCounters()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		node := 1 + i%peers
+		column := 1 + rng.Intn(len(doc.Lines[0].Characters)+1)
+		pos, err := doc.GeneratePositionAt(1, column, node)
+		if err != nil {
+			b.Fatalf("Failed to generate position: %v", err)
+		}
+		char := rune('a' + i%26)
+		if err := doc.InsertCharacter(char, pos, i+1); err != nil {
+			b.Fatalf("Failed to insert: %v", err)
+		}
+		if err := replica.InsertCharacter(char, pos, i+1); err != nil {
+			b.Fatalf("Failed to apply to replica: %v", err)
+		}
+	}
+	reportCounters(b, doc)
+}
@@ -0,0 +1,71 @@
+package crdt
+
+// Change is a character inserted into or deleted from a document. Line and
+// Column (1-based) are where the character was inserted, or where it was before
+// it was deleted.
+type Change struct {
+	Delete bool
+	Char   Character
+	Line   int
+	Column int
+}
+
+// ChangeListener is told about every character inserted or deleted, right after
+// the document changes
+type ChangeListener func(Change)
+
+// AddChangeListener registers a listener for changes made by InsertCharacter and
+// DeleteCharacter. Merge replaces the text wholesale and does not report changes;
+// use AnchorAt and Locate to follow text across a merge.
+func (d *Document) AddChangeListener(listener ChangeListener) {
+	d.listeners = append(d.listeners, listener)
+}
+
+// Transform returns where text coordinates from before the change point to after
+// it, so that a cursor stays on the same text. A cursor at an insertion point
+// stays in front of the character it was on, ending up after the inserted one.
+func (c Change) Transform(line, column int) (int, int) {
+	switch {
+	case !c.Delete && c.Char.Value != '\n':
+		if line == c.Line && column >= c.Column {
+			column++
+		}
+	case !c.Delete:
+		// A new line break carries the rest of the line down
+		if line == c.Line && column >= c.Column {
+			line, column = line+1, column-c.Column+1
+		} else if line > c.Line {
+			line++
+		}
+	case c.Char.Value != '\n':
+		if line == c.Line && column > c.Column {
+			column--
+		}
+	default:
+		// Removing a line break joins the next line onto this one
+		if line == c.Line+1 {
+			line, column = c.Line, c.Column+column-1
+		} else if line > c.Line+1 {
+			line--
+		}
+	}
+	return line, column
+}
+
+// changeAt describes a change to the character at index in the sequence. For a
+// deletion it must be called before Lines is updated. Without listeners there is
+// no one to tell, so the coordinates are not worked out.
+func (d *Document) changeAt(deleted bool, index int, char Character) Change {
+	if len(d.listeners) == 0 {
+		return Change{}
+	}
+	lineIndex, column := d.lineOf(index)
+	return Change{Delete: deleted, Char: char, Line: lineIndex + 1, Column: column + 1}
+}
+
+// notify tells the listeners about a change once the document has been updated
+func (d *Document) notify(change Change) {
+	for _, listener := range d.listeners {
+		listener(change)
+	}
+}
@@ -0,0 +1,83 @@
+package crdt
+
+import "testing"
+
+func TestChangeListener(t *testing.T) {
+	doc := FromText("ab\ncd", 1)
+	var changes []Change
+	doc.AddChangeListener(func(c Change) {
+		changes = append(changes, c)
+	})
+
+	pos, _ := doc.GeneratePositionAt(2, 2, 2)
+	_ = doc.InsertCharacter('x', pos, 10)
+	_ = doc.DeleteCharacter(doc.Lines[0].Characters[2].Pos) // The newline
+
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %d", len(changes))
+	}
+	if c := changes[0]; c.Delete || c.Char.Value != 'x' || c.Line != 2 || c.Column != 2 {
+		t.Errorf("Unexpected insert change: %+v", c)
+	}
+	if c := changes[1]; !c.Delete || c.Char.Value != '\n' || c.Line != 1 || c.Column != 3 {
+		t.Errorf("Unexpected delete change: %+v", c)
+	}
+
+	// Rejected edits are not reported
+	_ = doc.InsertCharacter('x', pos, 10)
+	if len(changes) != 2 {
+		t.Errorf("Expected a duplicate insert not to be reported, got %d changes", len(changes))
+	}
+}
+
+func TestChangeTransform(t *testing.T) {
+	tests := []struct {
+		name         string
+		change       Change
+		line, column int
+		wantLine     int
+		wantColumn   int
+	}{
+		{"insert before on line", Change{Char: Character{Value: 'x'}, Line: 1, Column: 2}, 1, 5, 1, 6},
+		{"insert at cursor", Change{Char: Character{Value: 'x'}, Line: 1, Column: 5}, 1, 5, 1, 6},
+		{"insert after", Change{Char: Character{Value: 'x'}, Line: 1, Column: 6}, 1, 5, 1, 5},
+		{"insert other line", Change{Char: Character{Value: 'x'}, Line: 1, Column: 1}, 2, 3, 2, 3},
+		{"newline before on line", Change{Char: Character{Value: '\n'}, Line: 1, Column: 3}, 1, 5, 2, 3},
+		{"newline on earlier line", Change{Char: Character{Value: '\n'}, Line: 1, Column: 3}, 3, 4, 4, 4},
+		{"newline after", Change{Char: Character{Value: '\n'}, Line: 1, Column: 6}, 1, 5, 1, 5},
+		{"delete before", Change{Delete: true, Char: Character{Value: 'x'}, Line: 1, Column: 2}, 1, 5, 1, 4},
+		{"delete at cursor", Change{Delete: true, Char: Character{Value: 'x'}, Line: 1, Column: 5}, 1, 5, 1, 5},
+		{"delete newline above", Change{Delete: true, Char: Character{Value: '\n'}, Line: 1, Column: 4}, 2, 3, 1, 6},
+		{"delete newline further up", Change{Delete: true, Char: Character{Value: '\n'}, Line: 1, Column: 4}, 5, 3, 4, 3},
+	}
+	for _, tt := range tests {
+		line, column := tt.change.Transform(tt.line, tt.column)
+		if line != tt.wantLine || column != tt.wantColumn {
+			t.Errorf("%s: expected (%d, %d), got (%d, %d)", tt.name, tt.wantLine, tt.wantColumn, line, column)
+		}
+	}
+}
+
+func TestChangeTransformFollowsText(t *testing.T) {
+	doc := FromText("hello\nworld", 1)
+	line, column := 2, 3 // On the 'r'
+	doc.AddChangeListener(func(c Change) {
+		line, column = c.Transform(line, column)
+	})
+
+	edits := []struct {
+		line, column int
+		char         rune
+	}{{1, 1, '>'}, {2, 1, '\n'}, {1, 3, '\n'}, {3, 2, 'z'}}
+	for i, e := range edits {
+		pos, _ := doc.GeneratePositionAt(e.line, e.column, 2)
+		_ = doc.InsertCharacter(e.char, pos, 100+i)
+	}
+	_ = doc.DeleteCharacter(doc.Lines[0].Characters[0].Pos)
+	_ = doc.DeleteCharacter(doc.Lines[0].Characters[1].Pos) // The newline after "h"
+
+	if got := doc.Lines[line-1].Characters[column-1].Value; got != 'r' {
+		t.Errorf("Expected the transformed coordinates (%d, %d) to stay on 'r', found %q (text %q)",
+			line, column, got, doc.ToText())
+	}
+}
@@ -0,0 +1,93 @@
+package crdt
+
+import (
+	"encoding/json"
+	"sync/atomic"
+)
+
+// Counters tracks the work a document does, for spotting performance
+// regressions. Counting is atomic, so a snapshot can be taken from another
+// goroutine while the document is being edited. String returns the counters as
+// JSON, so a *Counters can be published with expvar.Publish.
+type Counters struct {
+	inserts     atomic.Int64
+	deletes     atomic.Int64
+	duplicates  atomic.Int64
+	identifiers atomic.Int64
+	positions   atomic.Int64
+	allocated   atomic.Int64
+}
+
+// CounterSnapshot is the value of a document's Counters at one moment
+type CounterSnapshot struct {
+	OpsApplied           int64   `json:"ops_applied"`
+	Inserts              int64   `json:"inserts"`
+	Deletes              int64   `json:"deletes"`
+	Duplicates           int64   `json:"duplicates"`            // Inserts ignored with ErrAlreadyApplied
+	AvgIdentifierLength  float64 `json:"avg_identifier_length"` // Over every inserted character
+	PositionsAllocated   int64   `json:"positions_allocated"`   // By GeneratePositionAt
+	IdentifiersAllocated int64   `json:"identifiers_allocated"` // In those positions
+}
+
+// EnableCounters starts counting the operations applied to the document and the
+// positions it allocates, and returns the counters. Calling it again resets them.
+func (d *Document) EnableCounters() *Counters {
+	d.counters = &Counters{}
+	return d.counters
+}
+
+// Counters returns the document's counters, or nil if EnableCounters was not called
+func (d *Document) Counters() *Counters {
+	return d.counters
+}
+
+// Snapshot returns the current value of every counter
+func (c *Counters) Snapshot() CounterSnapshot {
+	s := CounterSnapshot{
+		Inserts:              c.inserts.Load(),
+		Deletes:              c.deletes.Load(),
+		Duplicates:           c.duplicates.Load(),
+		PositionsAllocated:   c.positions.Load(),
+		IdentifiersAllocated: c.allocated.Load(),
+	}
+	s.OpsApplied = s.Inserts + s.Deletes
+	if s.Inserts > 0 {
+		s.AvgIdentifierLength = float64(c.identifiers.Load()) / float64(s.Inserts)
+	}
+	return s
+}
+
+// String returns a snapshot of the counters as JSON
+func (c *Counters) String() string {
+	data, _ := json.Marshal(c.Snapshot())
+	return string(data)
+}
+
+// applied counts an inserted or deleted character. It does nothing on a nil
+// Counters, so documents without counters pay only for the call.
+func (c *Counters) applied(deleted bool, char Character) {
+	if c == nil {
+		return
+	}
+	if deleted {
+		c.deletes.Add(1)
+		return
+	}
+	c.inserts.Add(1)
+	c.identifiers.Add(int64(len(char.Pos)))
+}
+
+// duplicate counts an insert ignored because it was already applied
+func (c *Counters) duplicate() {
+	if c != nil {
+		c.duplicates.Add(1)
+	}
+}
+
+// allocate counts a position generated for a new character
+func (c *Counters) allocate(pos []Identifier) {
+	if c != nil {
+		c.positions.Add(1)
+		c.allocated.Add(int64(len(pos)))
+	}
+}
@@ -0,0 +1,56 @@
+package crdt
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+)
+
+func TestCounters(t *testing.T) {
+	doc := FromText("ab", 1)
+	if doc.Counters() != nil {
+		t.Fatal("Expected no counters before EnableCounters")
+	}
+	counters := doc.EnableCounters()
+
+	pos, _ := doc.GeneratePositionAt(1, 2, 2)
+	if err := doc.InsertCharacter('x', pos, 10); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	_ = doc.InsertCharacter('x', pos, 10)
+	_ = doc.DeleteCharacter(doc.Lines[0].Characters[0].Pos)
+
+	s := counters.Snapshot()
+	if s.Inserts != 1 || s.Deletes != 1 || s.OpsApplied != 2 || s.Duplicates != 1 {
+		t.Errorf("Unexpected operation counts: %+v", s)
+	}
+	if s.PositionsAllocated != 1 || s.IdentifiersAllocated != int64(len(pos)) {
+		t.Errorf("Unexpected allocation counts: %+v", s)
+	}
+	if s.AvgIdentifierLength != float64(len(pos)) {
+		t.Errorf("Expected average identifier length %d, got %v", len(pos), s.AvgIdentifierLength)
+	}
+
+	// Counters can be published as an expvar
+	var v expvar.Var = counters
+	var decoded CounterSnapshot
+	if err := json.Unmarshal([]byte(v.String()), &decoded); err != nil {
+		t.Fatalf("Counters are not valid JSON: %v", err)
+	}
+	if decoded != s {
+		t.Errorf("Expected %+v from String, got %+v", s, decoded)
+	}
+}
+
+func TestCountersMerge(t *testing.T) {
+	doc := FromText("ab", 1)
+	other := FromText("ab", 1)
+	pos, _ := other.GeneratePositionAt(1, 3, 2)
+	_ = other.InsertCharacter('c', pos, 10)
+
+	counters := doc.EnableCounters()
+	doc.Merge(other)
+	if s := counters.Snapshot(); s.Inserts != 1 {
+		t.Errorf("Expected the merged character to be counted, got %+v", s)
+	}
+}
@@ -1,7 +1,28 @@
 package crdt
 
+// Document is a sequence of characters ordered by position. Lines is an index of
+// that sequence split after each newline, kept up to date by InsertCharacter and
+// DeleteCharacter; set it only when constructing a document, never edit it in place.
 type Document struct {
-	Lines []Line `json:"lines"`
+	Lines      []Line                  `json:"lines"`
+	Metadata   Metadata                `json:"metadata"`
+	Markers    map[string][]Identifier `json:"markers,omitempty"`    // Named anchors, see SetMarker
+	Tombstones []Tombstone             `json:"tombstones,omitempty"` // Deleted characters, see Merge
+
+	chars     []Character      // Authoritative character sequence, see sequence
+	deleted   map[string]bool  // Index of Tombstones, see isDeleted
+	history   *History         // Edit log, see EnableHistory
+	words     *WordIndex       // Word counts, see EnableWords
+	counters  *Counters        // Performance counters, see EnableCounters
+	limits    Limits           // Caps on history and tombstones, see SetLimits
+	listeners []ChangeListener // Told about each edit, see AddChangeListener
+}
+
+// Metadata holds document properties shared by every participant
+type Metadata struct {
+	Language  string   `json:"language,omitempty"`  // Language name as understood by the language package
+	Protected []Region `json:"protected,omitempty"` // Ranges editors must not change, see Protect
+	Frozen    bool     `json:"frozen,omitempty"`    // Whether the document is final and takes no more edits
 }
 
 type Line struct {
@@ -61,9 +82,10 @@ func SubtractGreaterThan(n1 []int, n2 []int) []int {
 	carry := 0
 	diff := make([]int, max(len(n1), len(n2)))
 	for i := len(diff) - 1; i >= 0; i-- {
-		d1 := 0
+		// Missing digits are zero, but still owe the borrow
+		d1 := -carry
 		if i < len(n1) {
-			d1 = n1[i] - carry
+			d1 += n1[i]
 		}
 		d2 := 0
 		if i < len(n2) {
@@ -123,6 +145,12 @@ func generatePositionBetween(position1 []Identifier, position2 []Identifier, nod
 		head2 = Identifier{Digit: BASE, Node: node}
 	}
 
+	// With position1 used up, anything starting with position2's head and sorting
+	// before the rest of position2 also sorts between the two
+	if len(position1) == 0 && head1.Digit == head2.Digit {
+		return append([]Identifier{head2}, generatePositionBetween(nil, position2[1:], node)...)
+	}
+
 	if head1.Digit != head2.Digit {
 		// Case 1: Head digits are different
 		n1 := FromIdentifierList(position1)
@@ -1,84 +1,105 @@
 package crdt
 
 import (
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
 )
 
-// InsertCharacter inserts a character at the specified position in the document
-func (d *Document) InsertCharacter(char rune, position []Identifier, clock int) error {
-	if len(d.Lines) == 0 {
-		d.Lines = append(d.Lines, Line{Characters: []Character{}})
-	}
+// ErrAlreadyApplied is returned when inserting a character the document already
+// contains, such as a retransmitted operation or one already included by a sync
+var ErrAlreadyApplied = errors.New("operation already applied")
 
+// InsertCharacter inserts a character at the specified position in the document.
+// Characters are identified by their position and clock (the position includes the
+// node that created it), so inserting the same character twice, or again after it was
+// deleted, returns ErrAlreadyApplied and leaves the document unchanged.
+func (d *Document) InsertCharacter(char rune, position []Identifier, clock int) error {
 	newChar := Character{
 		Pos:   position,
 		Clock: clock,
 		Value: char,
 	}
 
-	// Handle newline characters
-	if char == '\n' {
-		// Find the line where this character should be inserted
-		lineIndex, charIndex := d.findInsertionPoint(position)
-		
-		// Split the line at the insertion point
-		currentLine := d.Lines[lineIndex]
-		
-		// Create new line with characters after the insertion point
-		newLine := Line{Characters: make([]Character, len(currentLine.Characters)-charIndex)}
-		copy(newLine.Characters, currentLine.Characters[charIndex:])
-		
-		// Truncate current line and add newline character
-		d.Lines[lineIndex].Characters = append(currentLine.Characters[:charIndex], newChar)
-		
-		// Insert the new line
-		d.Lines = append(d.Lines[:lineIndex+1], append([]Line{newLine}, d.Lines[lineIndex+1:]...)...)
-	} else {
-		// Regular character insertion
-		lineIndex, charIndex := d.findInsertionPoint(position)
-		line := &d.Lines[lineIndex]
-		
-		// Insert character at the correct position
-		line.Characters = append(line.Characters[:charIndex], append([]Character{newChar}, line.Characters[charIndex:]...)...)
+	// Characters with equal positions keep their arrival order
+	chars := d.sequence()
+	index := sort.Search(len(chars), func(i int) bool {
+		return comparePositions(position, chars[i].Pos) < 0
+	})
+	for i := index - 1; i >= 0 && comparePositions(chars[i].Pos, position) == 0; i-- {
+		if chars[i].Clock == clock {
+			d.counters.duplicate()
+			return ErrAlreadyApplied
+		}
+	}
+	if d.isDeleted(position, clock) {
+		d.counters.duplicate()
+		return ErrAlreadyApplied
 	}
 
+	if d.words != nil {
+		d.words.remove(wordsIn(chars, index-1, index+1))
+	}
+	d.chars = append(chars[:index], append([]Character{newChar}, chars[index:]...)...)
+	d.indexInsert(index, newChar)
+	if d.words != nil {
+		d.words.add(wordsIn(d.chars, index-1, index+2))
+	}
+	d.logEdit(false, newChar)
+	d.counters.applied(false, newChar)
+	d.enforceLimits()
+	d.notify(d.changeAt(false, index, newChar))
 	return nil
 }
 
+// HasCharacter reports whether the character inserted at a position at a
+// clock is in the document or was deleted from it, so that inserting it again
+// would change nothing
+func (d *Document) HasCharacter(position []Identifier, clock int) bool {
+	_, ok := d.characterAt(position, clock)
+	return ok || d.isDeleted(position, clock)
+}
+
+// characterAt returns the character inserted at a position at a clock, if it
+// is in the document
+func (d *Document) characterAt(position []Identifier, clock int) (Character, bool) {
+	chars := d.sequence()
+	index := sort.Search(len(chars), func(i int) bool {
+		return comparePositions(position, chars[i].Pos) < 0
+	})
+	for i := index - 1; i >= 0 && comparePositions(chars[i].Pos, position) == 0; i-- {
+		if chars[i].Clock == clock {
+			return chars[i], true
+		}
+	}
+	return Character{}, false
+}
+
 // DeleteCharacter removes a character at the specified position
 func (d *Document) DeleteCharacter(position []Identifier) error {
-	lineIndex, charIndex, found := d.findCharacter(position)
-	if !found {
+	chars := d.sequence()
+	index := sort.Search(len(chars), func(i int) bool {
+		return comparePositions(chars[i].Pos, position) >= 0
+	})
+	if index == len(chars) || comparePositions(chars[index].Pos, position) != 0 {
 		return fmt.Errorf("character not found at position")
 	}
 
-	char := d.Lines[lineIndex].Characters[charIndex]
-	
-	// Handle newline deletion
-	if char.Value == '\n' {
-		// Merge the next line with current line
-		if lineIndex+1 < len(d.Lines) {
-			// Remove the newline character
-			d.Lines[lineIndex].Characters = append(d.Lines[lineIndex].Characters[:charIndex], d.Lines[lineIndex].Characters[charIndex+1:]...)
-			
-			// Merge next line's characters
-			if lineIndex+1 < len(d.Lines) {
-				d.Lines[lineIndex].Characters = append(d.Lines[lineIndex].Characters, d.Lines[lineIndex+1].Characters...)
-				// Remove the merged line
-				d.Lines = append(d.Lines[:lineIndex+1], d.Lines[lineIndex+2:]...)
-			}
-		} else {
-			// Just remove the newline character if it's the last line
-			d.Lines[lineIndex].Characters = append(d.Lines[lineIndex].Characters[:charIndex], d.Lines[lineIndex].Characters[charIndex+1:]...)
-		}
-	} else {
-		// Regular character deletion
-		line := &d.Lines[lineIndex]
-		line.Characters = append(line.Characters[:charIndex], line.Characters[charIndex+1:]...)
+	change := d.changeAt(true, index, chars[index])
+	d.addTombstone(chars[index].Pos, chars[index].Clock)
+	d.logEdit(true, chars[index])
+	d.counters.applied(true, chars[index])
+	if d.words != nil {
+		d.words.remove(wordsIn(chars, index-1, index+2))
 	}
-
+	d.chars = append(chars[:index], chars[index+1:]...)
+	d.indexDelete(index)
+	if d.words != nil {
+		d.words.add(wordsIn(d.chars, index-1, index+1))
+	}
+	d.enforceLimits()
+	d.notify(change)
 	return nil
 }
 
@@ -141,6 +162,7 @@ func FromText(text string, nodeID int) *Document {
 		doc.Lines = append(doc.Lines, Line{Characters: characters})
 	}
 	
+	doc.rebuildSequence()
 	return doc
 }
 
@@ -161,7 +183,7 @@ func (d *Document) GeneratePositionAt(textLine, textColumn, nodeID int) ([]Ident
 	}
 	
 	// Get all characters in document order
-	allChars := d.getAllCharacters()
+	allChars := d.sequence()
 	
 	// If no characters exist, return a simple position
 	if len(allChars) == 0 {
@@ -182,7 +204,9 @@ func (d *Document) GeneratePositionAt(textLine, textColumn, nodeID int) ([]Ident
 		nextPos = allChars[charIndex].Pos
 	}
 	
-	return generatePositionBetween(prevPos, nextPos, nodeID), nil
+	pos := generatePositionBetween(prevPos, nextPos, nodeID)
+	d.counters.allocate(pos)
+	return pos, nil
 }
 
 // FindPositionAt finds the CRDT position at the given text coordinates
@@ -208,68 +232,6 @@ func (d *Document) FindPositionAt(textLine, textColumn int) ([]Identifier, error
 	return []Identifier{}, nil
 }
 
-// findInsertionPoint finds where to insert a character with the given position
-func (d *Document) findInsertionPoint(position []Identifier) (lineIndex, charIndex int) {
-	allChars := d.getAllCharacters()
-	
-	// Find insertion point using position comparison
-	for i, char := range allChars {
-		if comparePositions(position, char.Pos) < 0 {
-			return d.getLineAndCharIndex(i)
-		}
-	}
-	
-	// Insert at end
-	if len(d.Lines) == 0 {
-		return 0, 0
-	}
-	return len(d.Lines) - 1, len(d.Lines[len(d.Lines)-1].Characters)
-}
-
-// findCharacter finds a character with the given position
-func (d *Document) findCharacter(position []Identifier) (lineIndex, charIndex int, found bool) {
-	for lineIdx, line := range d.Lines {
-		for charIdx, char := range line.Characters {
-			if comparePositions(position, char.Pos) == 0 {
-				return lineIdx, charIdx, true
-			}
-		}
-	}
-	return 0, 0, false
-}
-
-// getAllCharacters returns all characters in document order
-func (d *Document) getAllCharacters() []Character {
-	var allChars []Character
-	for _, line := range d.Lines {
-		allChars = append(allChars, line.Characters...)
-	}
-	
-	// Sort by position
-	sort.Slice(allChars, func(i, j int) bool {
-		return comparePositions(allChars[i].Pos, allChars[j].Pos) < 0
-	})
-	
-	return allChars
-}
-
-// getLineAndCharIndex converts a character index to line and character indices
-func (d *Document) getLineAndCharIndex(charIndex int) (lineIndex, charIndexInLine int) {
-	currentIndex := 0
-	for lineIdx, line := range d.Lines {
-		if currentIndex+len(line.Characters) > charIndex {
-			return lineIdx, charIndex - currentIndex
-		}
-		currentIndex += len(line.Characters)
-	}
-	
-	// If we get here, insert at the end
-	if len(d.Lines) == 0 {
-		return 0, 0
-	}
-	return len(d.Lines) - 1, len(d.Lines[len(d.Lines)-1].Characters)
-}
-
 // comparePositions compares two positions lexicographically
 func comparePositions(pos1, pos2 []Identifier) int {
 	minLen := min(len(pos1), len(pos2))
@@ -1,6 +1,9 @@
 package crdt
 
 import (
+	"encoding/json"
+	"errors"
+	"math/rand"
 	"testing"
 )
 
@@ -107,6 +110,30 @@ func TestInsertNewline(t *testing.T) {
 	}
 }
 
+func TestInsertCharacterTwice(t *testing.T) {
+	doc := FromText("ac", 1)
+	pos := generatePositionBetween(doc.Lines[0].Characters[0].Pos, doc.Lines[0].Characters[1].Pos, 2)
+
+	if err := doc.InsertCharacter('b', pos, 7); err != nil {
+		t.Fatalf("First insert failed: %v", err)
+	}
+	err := doc.InsertCharacter('b', pos, 7)
+	if !errors.Is(err, ErrAlreadyApplied) {
+		t.Errorf("Expected ErrAlreadyApplied on duplicate insert, got %v", err)
+	}
+	if doc.ToText() != "abc" {
+		t.Errorf("Expected 'abc' after duplicate insert, got '%s'", doc.ToText())
+	}
+
+	// A different clock at the same position is a different character
+	if err := doc.InsertCharacter('x', pos, 8); err != nil {
+		t.Errorf("Insert with a new clock failed: %v", err)
+	}
+	if doc.ToText() != "abxc" {
+		t.Errorf("Expected 'abxc', got '%s'", doc.ToText())
+	}
+}
+
 func TestDeleteCharacter(t *testing.T) {
 	doc := FromText("Hello", 1)
 	
@@ -261,4 +288,98 @@ func TestComplexOperations(t *testing.T) {
 			t.Errorf("Expected length 12 after deletion, got %d", len(newText))
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestMetadataSurvivesSerialization(t *testing.T) {
+	doc := FromText("package main", 1)
+	doc.Metadata.Language = "go"
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Failed to marshal document: %v", err)
+	}
+
+	var decoded Document
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal document: %v", err)
+	}
+	if decoded.Metadata.Language != "go" {
+		t.Errorf("Expected language 'go', got '%s'", decoded.Metadata.Language)
+	}
+}
+
+func TestGeneratePositionAtRandomInserts(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	doc := FromText("", 1)
+
+	// Inserting anywhere, including before characters several identifiers deep,
+	// always yields a position strictly between the neighbours
+	var expected []rune
+	for i := 0; i < 5000; i++ {
+		column := 1 + rng.Intn(len(expected)+1)
+		node := 1 + rng.Intn(4)
+		pos, err := doc.GeneratePositionAt(1, column, node)
+		if err != nil {
+			t.Fatalf("Insert %d: failed to generate position: %v", i, err)
+		}
+		char := rune('a' + rng.Intn(26))
+		if err := doc.InsertCharacter(char, pos, i+1); err != nil {
+			t.Fatalf("Insert %d: failed to insert: %v", i, err)
+		}
+		expected = append(expected[:column-1], append([]rune{char}, expected[column-1:]...)...)
+	}
+
+	if doc.ToText() != string(expected) {
+		t.Errorf("Document text does not match the insertions")
+	}
+	chars := doc.Lines[0].Characters
+	for i := 1; i < len(chars); i++ {
+		if comparePositions(chars[i-1].Pos, chars[i].Pos) >= 0 {
+			t.Fatalf("Positions not strictly increasing at %d: %v, %v", i, chars[i-1].Pos, chars[i].Pos)
+		}
+	}
+}
+
+func TestGeneratePositionAtLongAppend(t *testing.T) {
+	doc := FromText("", 1)
+
+	// Appending fills every digit at one level before going a level deeper
+	for i := 0; i < 70000; i++ {
+		pos, err := doc.GeneratePositionAt(1, i+1, 1)
+		if err != nil {
+			t.Fatalf("Append %d: failed to generate position: %v", i, err)
+		}
+		if err := doc.InsertCharacter('a', pos, i+1); err != nil {
+			t.Fatalf("Append %d: failed to insert: %v", i, err)
+		}
+	}
+
+	chars := doc.Lines[0].Characters
+	for i := 1; i < len(chars); i++ {
+		if comparePositions(chars[i-1].Pos, chars[i].Pos) >= 0 {
+			t.Fatalf("Positions not strictly increasing at %d: %v, %v", i, chars[i-1].Pos, chars[i].Pos)
+		}
+	}
+}
+
+func TestHasCharacter(t *testing.T) {
+	doc := FromText("", 1)
+	pos := []Identifier{{Digit: 5, Node: 2}}
+	if doc.HasCharacter(pos, 1) {
+		t.Error("Expected no character before inserting it")
+	}
+	if err := doc.InsertCharacter('a', pos, 1); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	if !doc.HasCharacter(pos, 1) || doc.HasCharacter(pos, 2) {
+		t.Error("Expected the character inserted at clock 1 only")
+	}
+
+	// A deleted character still counts as had
+	if err := doc.DeleteCharacter(pos); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if !doc.HasCharacter(pos, 1) {
+		t.Error("Expected the deleted character still had")
+	}
+}
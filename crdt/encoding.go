@@ -0,0 +1,58 @@
+package crdt
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrInvalidPosition is returned when a binary position cannot be decoded
+var ErrInvalidPosition = errors.New("invalid binary position encoding")
+
+// AppendPosition appends the compact binary encoding of an identifier path to buf.
+// The encoding is the identifier count followed by each digit and node as varints,
+// so typical positions take a few bytes instead of dozens of bytes of JSON.
+func AppendPosition(buf []byte, pos []Identifier) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(pos)))
+	for _, ident := range pos {
+		buf = binary.AppendUvarint(buf, uint64(ident.Digit))
+		buf = binary.AppendVarint(buf, int64(ident.Node))
+	}
+	return buf
+}
+
+// EncodePosition returns the compact binary encoding of an identifier path
+func EncodePosition(pos []Identifier) []byte {
+	return AppendPosition(nil, pos)
+}
+
+// DecodePosition decodes an identifier path from the start of data, returning
+// the path and the number of bytes consumed
+func DecodePosition(data []byte) ([]Identifier, int, error) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, 0, ErrInvalidPosition
+	}
+	// Every identifier needs at least two bytes, which bounds the allocation
+	if count > uint64(len(data)-n)/2 {
+		return nil, 0, ErrInvalidPosition
+	}
+	offset := n
+
+	pos := make([]Identifier, count)
+	for i := range pos {
+		digit, n := binary.Uvarint(data[offset:])
+		if n <= 0 {
+			return nil, 0, ErrInvalidPosition
+		}
+		offset += n
+
+		node, n := binary.Varint(data[offset:])
+		if n <= 0 {
+			return nil, 0, ErrInvalidPosition
+		}
+		offset += n
+
+		pos[i] = Identifier{Digit: int(digit), Node: int(node)}
+	}
+	return pos, offset, nil
+}
@@ -0,0 +1,82 @@
+package crdt
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestPositionEncodingRoundTrip(t *testing.T) {
+	positions := [][]Identifier{
+		{},
+		{{Digit: 1, Node: 1}},
+		{{Digit: 255, Node: 999}, {Digit: 0, Node: 3}, {Digit: 128, Node: 42}},
+		{{Digit: 7, Node: -5}},
+	}
+
+	for _, pos := range positions {
+		data := EncodePosition(pos)
+		decoded, n, err := DecodePosition(data)
+		if err != nil {
+			t.Fatalf("Failed to decode %v: %v", pos, err)
+		}
+		if n != len(data) {
+			t.Errorf("Expected %d bytes consumed, got %d", len(data), n)
+		}
+		if comparePositions(pos, decoded) != 0 || len(pos) != len(decoded) {
+			t.Errorf("Round trip mismatch: %v became %v", pos, decoded)
+		}
+	}
+}
+
+func TestAppendPositionSequence(t *testing.T) {
+	first := []Identifier{{Digit: 10, Node: 1}}
+	second := []Identifier{{Digit: 20, Node: 2}, {Digit: 30, Node: 2}}
+
+	data := AppendPosition(EncodePosition(first), second)
+
+	decoded, n, err := DecodePosition(data)
+	if err != nil {
+		t.Fatalf("Failed to decode first position: %v", err)
+	}
+	if comparePositions(decoded, first) != 0 {
+		t.Errorf("Expected %v, got %v", first, decoded)
+	}
+
+	decoded, _, err = DecodePosition(data[n:])
+	if err != nil {
+		t.Fatalf("Failed to decode second position: %v", err)
+	}
+	if comparePositions(decoded, second) != 0 {
+		t.Errorf("Expected %v, got %v", second, decoded)
+	}
+}
+
+func TestPositionEncodingIsCompact(t *testing.T) {
+	pos := []Identifier{{Digit: 12, Node: 101}, {Digit: 200, Node: 7}, {Digit: 3, Node: 101}}
+
+	jsonData, err := json.Marshal(pos)
+	if err != nil {
+		t.Fatalf("Failed to marshal position: %v", err)
+	}
+	binaryData := EncodePosition(pos)
+
+	if len(binaryData)*5 > len(jsonData) {
+		t.Errorf("Expected binary encoding to be much smaller: %d bytes vs %d bytes of JSON", len(binaryData), len(jsonData))
+	}
+}
+
+func TestDecodeInvalidPosition(t *testing.T) {
+	invalid := [][]byte{
+		nil,
+		{0x02, 0x01}, // Claims two identifiers, has half of one
+		{0x01, 0x05}, // Digit without node
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, // Overflowing count
+	}
+
+	for _, data := range invalid {
+		if _, _, err := DecodePosition(data); !errors.Is(err, ErrInvalidPosition) {
+			t.Errorf("Expected ErrInvalidPosition for %v, got %v", data, err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// FormatVersion is the version of the serialized Document format written by this build.
+//
+// Version history:
+//
+//	1: {"lines": [...]} with no version field
+//	2: adds "version", "compat" and "metadata"
+//	3: adds optional "markers"
+//	4: adds optional "tombstones"
+//	5: adds optional "protected" to "metadata"
+const FormatVersion = 5
+
+// compatVersion is the oldest reader version that can load documents written by this build.
+// Bump it only when a format change cannot be safely ignored by older readers.
+const compatVersion = 1
+
+// UnsupportedVersionError is returned when a document needs a newer reader
+type UnsupportedVersionError struct {
+	Version int // Version the document was written with
+	Compat  int // Oldest reader version able to load it
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("document format version %d requires a reader of at least version %d (this build reads version %d)",
+		e.Version, e.Compat, FormatVersion)
+}
+
+// migrations upgrade a raw document from the keyed version to the next one
+var migrations = map[int]func(map[string]json.RawMessage) error{
+	1: migrateV1ToV2,
+	2: migrateV2ToV3,
+	3: migrateV3ToV4,
+	4: migrateV4ToV5,
+}
+
+// documentFields is the on-the-wire shape of a Document
+type documentFields struct {
+	Version    int                     `json:"version"`
+	Compat     int                     `json:"compat"`
+	Lines      []Line                  `json:"lines"`
+	Metadata   Metadata                `json:"metadata"`
+	Markers    map[string][]Identifier `json:"markers,omitempty"`
+	Tombstones []Tombstone             `json:"tombstones,omitempty"`
+}
+
+// MarshalJSON writes the document tagged with the current format version
+func (d *Document) MarshalJSON() ([]byte, error) {
+	return json.Marshal(documentFields{
+		Version:    FormatVersion,
+		Compat:     compatVersion,
+		Lines:      d.Lines,
+		Metadata:   d.Metadata,
+		Markers:    d.Markers,
+		Tombstones: d.Tombstones,
+	})
+}
+
+// UnmarshalJSON reads a document of any supported format version, migrating older
+// versions forward. Documents from newer builds are loaded on a best-effort basis
+// when they declare themselves compatible, with unknown fields ignored.
+func (d *Document) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	version, err := rawInt(raw, "version", 1)
+	if err != nil {
+		return err
+	}
+	compat, err := rawInt(raw, "compat", version)
+	if err != nil {
+		return err
+	}
+	if version > FormatVersion && compat > FormatVersion {
+		return &UnsupportedVersionError{Version: version, Compat: compat}
+	}
+
+	for v := version; v < FormatVersion; v++ {
+		migrate, ok := migrations[v]
+		if !ok {
+			return fmt.Errorf("no migration from document format version %d", v)
+		}
+		if err := migrate(raw); err != nil {
+			return fmt.Errorf("migrating document from version %d: %w", v, err)
+		}
+	}
+
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	var fields documentFields
+	if err := json.Unmarshal(migrated, &fields); err != nil {
+		return err
+	}
+
+	d.Lines = fields.Lines
+	d.Metadata = fields.Metadata
+	d.Markers = fields.Markers
+	d.Tombstones = fields.Tombstones
+	d.deleted = nil
+	d.rebuildSequence()
+	if d.words != nil {
+		d.words.rebuild(d.chars)
+	}
+	return nil
+}
+
+// rawInt reads an integer field, returning the fallback when it is absent
+func rawInt(raw map[string]json.RawMessage, key string, fallback int) (int, error) {
+	value, ok := raw[key]
+	if !ok {
+		return fallback, nil
+	}
+	var n int
+	if err := json.Unmarshal(value, &n); err != nil {
+		return 0, fmt.Errorf("invalid document %s: %w", key, err)
+	}
+	return n, nil
+}
+
+// migrateV1ToV2 adds the metadata object introduced in version 2
+func migrateV1ToV2(raw map[string]json.RawMessage) error {
+	if _, ok := raw["metadata"]; !ok {
+		raw["metadata"] = json.RawMessage(`{}`)
+	}
+	if _, ok := raw["lines"]; !ok {
+		raw["lines"] = json.RawMessage(`[]`)
+	}
+	return nil
+}
+
+// migrateV2ToV3 has nothing to do: markers are optional and older documents have none
+func migrateV2ToV3(raw map[string]json.RawMessage) error {
+	return nil
+}
+
+// migrateV3ToV4 has nothing to do: older documents simply have no tombstones
+func migrateV3ToV4(raw map[string]json.RawMessage) error {
+	return nil
+}
+
+// migrateV4ToV5 has nothing to do: older documents have no protected regions
+func migrateV4ToV5(raw map[string]json.RawMessage) error {
+	return nil
+}
@@ -0,0 +1,99 @@
+package crdt
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMarshalIncludesVersion(t *testing.T) {
+	doc := FromText("Hi", 1)
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Failed to marshal document: %v", err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("Failed to decode document JSON: %v", err)
+	}
+	if version, ok := fields["version"].(float64); !ok || int(version) != FormatVersion {
+		t.Errorf("Expected version %d, got %v", FormatVersion, fields["version"])
+	}
+}
+
+func TestUnmarshalVersion1Document(t *testing.T) {
+	// Written by builds that predate the version field
+	data := `{"lines":[{"characters":[{"pos":[{"digit":1,"node":1}],"clock":1,"value":72},{"pos":[{"digit":2,"node":1}],"clock":2,"value":105}]}]}`
+
+	var doc Document
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		t.Fatalf("Failed to load version 1 document: %v", err)
+	}
+	if doc.ToText() != "Hi" {
+		t.Errorf("Expected 'Hi', got '%s'", doc.ToText())
+	}
+	if doc.Metadata.Language != "" {
+		t.Errorf("Expected empty metadata after migration, got %+v", doc.Metadata)
+	}
+}
+
+func TestUnmarshalNewerCompatibleDocument(t *testing.T) {
+	// A future build that only added fields older readers can ignore
+	data := `{"version":99,"compat":1,"lines":[{"characters":[{"pos":[{"digit":1,"node":1}],"clock":1,"value":65}]}],"metadata":{"language":"go"},"future_field":true}`
+
+	var doc Document
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		t.Fatalf("Failed to load compatible newer document: %v", err)
+	}
+	if doc.ToText() != "A" {
+		t.Errorf("Expected 'A', got '%s'", doc.ToText())
+	}
+	if doc.Metadata.Language != "go" {
+		t.Errorf("Expected language 'go', got '%s'", doc.Metadata.Language)
+	}
+}
+
+func TestUnmarshalNewerIncompatibleDocument(t *testing.T) {
+	data := `{"version":99,"compat":99,"lines":[]}`
+
+	var doc Document
+	err := json.Unmarshal([]byte(data), &doc)
+	if err == nil {
+		t.Fatal("Expected error loading incompatible document")
+	}
+
+	var versionErr *UnsupportedVersionError
+	if !errors.As(err, &versionErr) {
+		t.Fatalf("Expected UnsupportedVersionError, got %T: %v", err, err)
+	}
+	if versionErr.Version != 99 || versionErr.Compat != 99 {
+		t.Errorf("Unexpected error details: %+v", versionErr)
+	}
+	if !strings.Contains(err.Error(), "99") {
+		t.Errorf("Expected error to mention the version, got '%v'", err)
+	}
+}
+
+func TestVersionedRoundTrip(t *testing.T) {
+	doc := FromText("Hello\nWorld", 1)
+	doc.Metadata.Language = "markdown"
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Failed to marshal document: %v", err)
+	}
+
+	var decoded Document
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal document: %v", err)
+	}
+	if decoded.ToText() != "Hello\nWorld" {
+		t.Errorf("Expected 'Hello\\nWorld', got '%s'", decoded.ToText())
+	}
+	if decoded.Metadata.Language != "markdown" {
+		t.Errorf("Expected language 'markdown', got '%s'", decoded.Metadata.Language)
+	}
+}
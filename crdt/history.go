@@ -0,0 +1,142 @@
+package crdt
+
+import (
+	"sort"
+	"time"
+)
+
+// HistoryEntry is one edit recorded in a History
+type HistoryEntry struct {
+	Time   time.Time `json:"time"`
+	Delete bool      `json:"delete,omitempty"`
+	Char   Character `json:"char"`
+}
+
+// History is an append-only log of the edits made to a document since a base
+// snapshot, from which earlier states of the document can be rebuilt
+type History struct {
+	Entries    []HistoryEntry
+	Checkpoint time.Time // Time of the last edit folded into the base snapshot, zero if none; see Limits
+
+	base *Document
+	now  func() time.Time
+}
+
+// EnableHistory starts logging edits to the document, starting from its current
+// state, and returns the log. Calling it again restarts the log.
+func (d *Document) EnableHistory() *History {
+	d.history = &History{base: d.snapshot(), now: time.Now}
+	return d.history
+}
+
+// History returns the document's edit log, or nil if EnableHistory was not called
+func (d *Document) History() *History {
+	return d.history
+}
+
+// Len returns the number of edits in the log
+func (h *History) Len() int {
+	return len(h.Entries)
+}
+
+// ReplayFirst rebuilds the document as it was after the first n edits in the log
+func (h *History) ReplayFirst(n int) *Document {
+	n = max(0, min(n, len(h.Entries)))
+	doc := h.base.snapshot()
+	for _, entry := range h.Entries[:n] {
+		if entry.Delete {
+			_ = doc.DeleteCharacter(entry.Char.Pos)
+		} else {
+			_ = doc.InsertCharacter(entry.Char.Value, entry.Char.Pos, entry.Char.Clock)
+		}
+	}
+	return doc
+}
+
+// ReplayTo rebuilds the document as it was at the given time, including every
+// edit made at or before it
+func (h *History) ReplayTo(t time.Time) *Document {
+	n := sort.Search(len(h.Entries), func(i int) bool {
+		return h.Entries[i].Time.After(t)
+	})
+	return h.ReplayFirst(n)
+}
+
+// record appends an edit to the log
+func (h *History) record(deleted bool, char Character) {
+	h.Entries = append(h.Entries, HistoryEntry{Time: h.now(), Delete: deleted, Char: char})
+}
+
+// checkpoint folds the first n entries into the base snapshot and drops them
+func (h *History) checkpoint(n int) {
+	n = min(n, len(h.Entries))
+	if n <= 0 {
+		return
+	}
+	h.base = h.ReplayFirst(n)
+	h.Checkpoint = h.Entries[n-1].Time
+
+	// Entries after the checkpoint never bring back a character deleted before it,
+	// so the base does not need its tombstones
+	h.base.Tombstones = nil
+	h.base.deleted = nil
+	h.Entries = append([]HistoryEntry(nil), h.Entries[n:]...)
+}
+
+// logEdit records an edit if the document keeps a history
+func (d *Document) logEdit(deleted bool, char Character) {
+	if d.history != nil {
+		d.history.record(deleted, char)
+	}
+}
+
+// RevertTo edits the document to match an earlier state of it, such as one
+// rebuilt by History.ReplayTo, and returns the characters it inserted and deleted
+// so the change can be sent to peers. Characters deleted since the earlier state
+// are inserted again with a new clock, as their original identity is tombstoned.
+func (d *Document) RevertTo(past *Document) (inserted, deleted []Character) {
+	// New clocks must not collide with any character this document has seen
+	clock := 0
+	for _, char := range d.sequence() {
+		clock = max(clock, char.Clock)
+	}
+	for _, t := range d.Tombstones {
+		clock = max(clock, t.Clock)
+	}
+
+	kept := make(map[string]bool)
+	for _, char := range past.sequence() {
+		kept[tombstoneKey(char.Pos, char.Clock)] = true
+	}
+	current := make(map[string]bool)
+	for _, char := range append([]Character(nil), d.sequence()...) {
+		key := tombstoneKey(char.Pos, char.Clock)
+		current[key] = true
+		if !kept[key] && d.DeleteCharacter(char.Pos) == nil {
+			deleted = append(deleted, char)
+		}
+	}
+
+	for _, char := range past.sequence() {
+		if current[tombstoneKey(char.Pos, char.Clock)] {
+			continue
+		}
+		clock++
+		if d.InsertCharacter(char.Value, char.Pos, clock) == nil {
+			inserted = append(inserted, Character{Pos: char.Pos, Clock: clock, Value: char.Value})
+		}
+	}
+	return inserted, deleted
+}
+
+// snapshot returns a copy of the document's characters, tombstones and metadata
+// that later edits to either document do not affect
+func (d *Document) snapshot() *Document {
+	doc := &Document{
+		Metadata:   d.Metadata,
+		Tombstones: append([]Tombstone(nil), d.Tombstones...),
+		chars:      append([]Character{}, d.sequence()...),
+	}
+	doc.rebuildLines()
+	return doc
+}
@@ -0,0 +1,82 @@
+package crdt
+
+import (
+	"testing"
+	"time"
+)
+
+// typeText appends text to the end of a document one character at a time
+func typeText(doc *Document, text string, node int) {
+	for _, r := range text {
+		chars := doc.sequence()
+		var last []Identifier
+		if len(chars) > 0 {
+			last = chars[len(chars)-1].Pos
+		}
+		_ = doc.InsertCharacter(r, generatePositionBetween(last, nil, node), len(chars)+100)
+	}
+}
+
+func TestHistoryReplay(t *testing.T) {
+	doc := FromText("ab", 1)
+	h := doc.EnableHistory()
+	clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	h.now = func() time.Time { return clock }
+
+	typeText(doc, "cd", 2)
+	clock = clock.Add(time.Minute)
+	_ = doc.DeleteCharacter(doc.Lines[0].Characters[0].Pos)
+
+	if h.Len() != 3 {
+		t.Fatalf("Expected 3 edits in history, got %d", h.Len())
+	}
+
+	expected := []string{"ab", "abc", "abcd", "bcd"}
+	for n, text := range expected {
+		if got := h.ReplayFirst(n).ToText(); got != text {
+			t.Errorf("ReplayFirst(%d): expected %q, got %q", n, text, got)
+		}
+	}
+
+	if got := h.ReplayTo(clock.Add(-time.Second)).ToText(); got != "abcd" {
+		t.Errorf("ReplayTo before the delete: expected 'abcd', got %q", got)
+	}
+	if got := h.ReplayTo(clock).ToText(); got != "bcd" {
+		t.Errorf("ReplayTo at the delete: expected 'bcd', got %q", got)
+	}
+
+	// Replaying does not touch the live document
+	if doc.ToText() != "bcd" {
+		t.Errorf("Expected live document 'bcd', got %q", doc.ToText())
+	}
+}
+
+func TestRevertTo(t *testing.T) {
+	doc := FromText("hello", 1)
+	h := doc.EnableHistory()
+
+	_ = doc.DeleteCharacter(doc.Lines[0].Characters[0].Pos)
+	typeText(doc, "!", 2)
+	past := h.ReplayFirst(0)
+
+	inserted, deleted := doc.RevertTo(past)
+	if doc.ToText() != "hello" {
+		t.Errorf("Expected 'hello' after revert, got %q", doc.ToText())
+	}
+	if len(inserted) != 1 || inserted[0].Value != 'h' {
+		t.Errorf("Expected 'h' to be inserted again, got %+v", inserted)
+	}
+	if len(deleted) != 1 || deleted[0].Value != '!' {
+		t.Errorf("Expected '!' to be deleted, got %+v", deleted)
+	}
+
+	// The restored character is new, so a replica can apply it over its tombstone
+	replica := FromText("hello", 1)
+	_ = replica.DeleteCharacter(replica.Lines[0].Characters[0].Pos)
+	if err := replica.InsertCharacter(inserted[0].Value, inserted[0].Pos, inserted[0].Clock); err != nil {
+		t.Errorf("Replica rejected restored character: %v", err)
+	}
+	if replica.ToText() != "hello" {
+		t.Errorf("Expected replica 'hello', got %q", replica.ToText())
+	}
+}
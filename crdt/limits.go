@@ -0,0 +1,39 @@
+package crdt
+
+// Limits bounds how much a long-lived document remembers about past edits. Zero
+// means no limit.
+//
+// Forgetting has a cost: edits older than the oldest kept history entry can no
+// longer be replayed individually, and a replica that still holds a character whose
+// tombstone was dropped brings it back when merged. Keep MaxTombstones well above
+// the number of deletions a peer may miss while disconnected.
+type Limits struct {
+	MaxHistory    int // History entries kept; older ones are folded into a checkpoint
+	MaxTombstones int // Tombstones kept; the oldest are dropped first
+}
+
+// SetLimits caps the document's history and tombstones, compacting them right
+// away if they are already over the limits
+func (d *Document) SetLimits(limits Limits) {
+	d.limits = limits
+	d.enforceLimits()
+}
+
+// Limits returns the limits set with SetLimits
+func (d *Document) Limits() Limits {
+	return d.limits
+}
+
+// enforceLimits compacts the history and tombstones once they grow past the
+// limits. Each compaction goes down to three quarters of the limit, so its cost is
+// spread over many edits rather than paid on every one.
+func (d *Document) enforceLimits() {
+	if limit := d.limits.MaxHistory; limit > 0 && d.history != nil && len(d.history.Entries) > limit {
+		d.history.checkpoint(len(d.history.Entries) - limit*3/4)
+	}
+	if limit := d.limits.MaxTombstones; limit > 0 && len(d.Tombstones) > limit {
+		drop := len(d.Tombstones) - limit*3/4
+		d.Tombstones = append([]Tombstone(nil), d.Tombstones[drop:]...)
+		d.deleted = nil // Rebuilt from the remaining tombstones by isDeleted
+	}
+}
@@ -0,0 +1,83 @@
+package crdt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryLimitCheckpoints(t *testing.T) {
+	doc := FromText(">", 1)
+	h := doc.EnableHistory()
+	clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	h.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	doc.SetLimits(Limits{MaxHistory: 8})
+
+	typeText(doc, "abcdefghijklmnopqrst", 2)
+	text := ">abcdefghijklmnopqrst"
+
+	if h.Len() > 8 {
+		t.Fatalf("Expected at most 8 history entries, got %d", h.Len())
+	}
+	if h.Checkpoint.IsZero() {
+		t.Fatal("Expected a checkpoint after compaction")
+	}
+
+	// The oldest kept state is the checkpoint, and every later edit still replays
+	folded := len(text) - h.Len() // Includes the starting '>'
+	for n := 0; n <= h.Len(); n++ {
+		if got := h.ReplayFirst(n).ToText(); got != text[:folded+n] {
+			t.Errorf("ReplayFirst(%d): expected %q, got %q", n, text[:folded+n], got)
+		}
+	}
+	if got := h.ReplayTo(h.Checkpoint).ToText(); got != text[:folded] {
+		t.Errorf("ReplayTo(checkpoint): expected %q, got %q", text[:folded], got)
+	}
+}
+
+func TestTombstoneLimitDropsOldest(t *testing.T) {
+	doc := FromText(strings.Repeat("x", 20), 1)
+	doc.SetLimits(Limits{MaxTombstones: 4})
+
+	deleted := append([]Character(nil), doc.Lines[0].Characters...)
+	for _, char := range deleted {
+		if err := doc.DeleteCharacter(char.Pos); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+	}
+
+	if len(doc.Tombstones) > 4 {
+		t.Fatalf("Expected at most 4 tombstones, got %d", len(doc.Tombstones))
+	}
+
+	// The most recent deletion is still remembered, the first one is not
+	last := deleted[len(deleted)-1]
+	if err := doc.InsertCharacter(last.Value, last.Pos, last.Clock); !errors.Is(err, ErrAlreadyApplied) {
+		t.Errorf("Expected the latest deletion to be remembered, got %v", err)
+	}
+	first := deleted[0]
+	if err := doc.InsertCharacter(first.Value, first.Pos, first.Clock); err != nil {
+		t.Errorf("Expected the oldest tombstone to be forgotten, got %v", err)
+	}
+}
+
+func TestSetLimitsCompactsImmediately(t *testing.T) {
+	doc := FromText("abcdef", 1)
+	doc.EnableHistory()
+	for _, char := range append([]Character(nil), doc.Lines[0].Characters...) {
+		_ = doc.DeleteCharacter(char.Pos)
+	}
+
+	doc.SetLimits(Limits{MaxHistory: 2, MaxTombstones: 2})
+	if doc.History().Len() > 2 || len(doc.Tombstones) > 2 {
+		t.Errorf("Expected compaction to the limits, got %d history entries and %d tombstones",
+			doc.History().Len(), len(doc.Tombstones))
+	}
+	if doc.Limits().MaxHistory != 2 {
+		t.Errorf("Expected Limits to return the limits set, got %+v", doc.Limits())
+	}
+}
@@ -0,0 +1,87 @@
+package crdt
+
+import "sort"
+
+// SetMarker anchors a named marker to the character at pos, replacing any marker
+// with the same name. The marker follows that character as text is inserted or
+// deleted around it; if the character itself is deleted the marker moves to the
+// next surviving character. An empty pos anchors the marker to the end of the document.
+func (d *Document) SetMarker(name string, pos []Identifier) {
+	if d.Markers == nil {
+		d.Markers = make(map[string][]Identifier)
+	}
+	d.Markers[name] = append([]Identifier(nil), pos...)
+}
+
+// Marker returns the position of the character a marker currently points to. A
+// nil position means the end of the document. ok is false if there is no such marker.
+func (d *Document) Marker(name string) (pos []Identifier, ok bool) {
+	anchor, ok := d.Markers[name]
+	if !ok {
+		return nil, false
+	}
+	chars := d.sequence()
+	index := d.resolve(anchor)
+	if index == len(chars) {
+		return nil, true
+	}
+	return chars[index].Pos, true
+}
+
+// AnchorAt returns an anchor for the character at the given text coordinates
+// (1-based), which Locate can find again after later edits. Coordinates past the
+// end of a line anchor to the start of the next one; nil anchors the end of the document.
+func (d *Document) AnchorAt(line, column int) []Identifier {
+	index := 0
+	for i := 0; i < line-1 && i < len(d.Lines); i++ {
+		index += len(d.Lines[i].Characters)
+	}
+	if line >= 1 && line <= len(d.Lines) {
+		index += min(max(column-1, 0), len(d.Lines[line-1].Characters))
+	}
+
+	chars := d.sequence()
+	if index >= len(chars) {
+		return nil
+	}
+	return chars[index].Pos
+}
+
+// Locate returns the text coordinates (1-based) of an anchor from AnchorAt. If the
+// anchored character was deleted, the next surviving character is used.
+func (d *Document) Locate(anchor []Identifier) (line, column int) {
+	if len(d.Lines) == 0 {
+		return 1, 1
+	}
+	lineIndex, columnIndex := d.lineOf(d.resolve(anchor))
+	return lineIndex + 1, columnIndex + 1
+}
+
+// resolve returns the index in the sequence of the first character at or after
+// an anchor, or the sequence length for an empty anchor or one past the last character
+func (d *Document) resolve(anchor []Identifier) int {
+	chars := d.sequence()
+	if len(anchor) == 0 {
+		return len(chars)
+	}
+
+	// Positions are dense, so a deleted anchor still has a place in the ordering
+	return sort.Search(len(chars), func(i int) bool {
+		return comparePositions(chars[i].Pos, anchor) >= 0
+	})
+}
+
+// RemoveMarker deletes a marker. Removing a marker that does not exist is a no-op.
+func (d *Document) RemoveMarker(name string) {
+	delete(d.Markers, name)
+}
+
+// MarkerNames returns the names of every marker, sorted
+func (d *Document) MarkerNames() []string {
+	names := make([]string, 0, len(d.Markers))
+	for name := range d.Markers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,143 @@
+package crdt
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMarkerFollowsInsertions(t *testing.T) {
+	doc := FromText("Hello World", 1)
+	w := doc.Lines[0].Characters[6].Pos
+	doc.SetMarker("bookmark", w)
+
+	// Insert text before the marked character
+	pos, _ := doc.GeneratePositionAt(1, 1, 2)
+	if err := doc.InsertCharacter('>', pos, 100); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	pos, _ = doc.GeneratePositionAt(1, 7, 2)
+	if err := doc.InsertCharacter('\n', pos, 101); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	marked, ok := doc.Marker("bookmark")
+	if !ok {
+		t.Fatal("Expected marker to exist")
+	}
+	if comparePositions(marked, w) != 0 {
+		t.Errorf("Expected marker to stay on 'W', got %v", marked)
+	}
+	text, _ := doc.TextBetween(marked, nil)
+	if text != "World" {
+		t.Errorf("Expected text from marker to be 'World', got %q", text)
+	}
+}
+
+func TestMarkerSurvivesDeletion(t *testing.T) {
+	doc := FromText("abcdef", 1)
+	doc.SetMarker("breakpoint", doc.Lines[0].Characters[2].Pos)
+
+	// Delete the marked character and the one after it
+	c := doc.Lines[0].Characters[2].Pos
+	d := doc.Lines[0].Characters[3].Pos
+	_ = doc.DeleteCharacter(c)
+	_ = doc.DeleteCharacter(d)
+
+	marked, ok := doc.Marker("breakpoint")
+	if !ok {
+		t.Fatal("Expected marker to exist")
+	}
+	text, _ := doc.TextBetween(marked, nil)
+	if text != "ef" {
+		t.Errorf("Expected marker to move to 'e', text from marker is %q", text)
+	}
+
+	// Deleting everything after the marker leaves it at the end of the document
+	for _, char := range append([]Character(nil), doc.Lines[0].Characters[2:]...) {
+		_ = doc.DeleteCharacter(char.Pos)
+	}
+	marked, ok = doc.Marker("breakpoint")
+	if !ok || marked != nil {
+		t.Errorf("Expected marker at end of document, got %v (ok=%v)", marked, ok)
+	}
+}
+
+func TestMarkerManagement(t *testing.T) {
+	doc := FromText("abc", 1)
+	if _, ok := doc.Marker("missing"); ok {
+		t.Error("Expected missing marker to report not found")
+	}
+
+	doc.SetMarker("b", doc.Lines[0].Characters[1].Pos)
+	doc.SetMarker("a", doc.Lines[0].Characters[0].Pos)
+	doc.SetMarker("end", nil)
+
+	names := doc.MarkerNames()
+	if len(names) != 3 || names[0] != "a" || names[1] != "b" || names[2] != "end" {
+		t.Errorf("Expected sorted marker names [a b end], got %v", names)
+	}
+	if pos, ok := doc.Marker("end"); !ok || pos != nil {
+		t.Errorf("Expected end marker to resolve to the end, got %v", pos)
+	}
+
+	doc.RemoveMarker("b")
+	if _, ok := doc.Marker("b"); ok {
+		t.Error("Expected removed marker to be gone")
+	}
+}
+
+func TestMarkersSurviveSerialization(t *testing.T) {
+	doc := FromText("Hello", 1)
+	doc.SetMarker("start", doc.Lines[0].Characters[0].Pos)
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Failed to marshal document: %v", err)
+	}
+
+	var decoded Document
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal document: %v", err)
+	}
+	pos, ok := decoded.Marker("start")
+	if !ok || comparePositions(pos, doc.Lines[0].Characters[0].Pos) != 0 {
+		t.Errorf("Expected marker to survive serialization, got %v (ok=%v)", pos, ok)
+	}
+}
+
+func TestAnchorAtAndLocate(t *testing.T) {
+	doc := FromText("ab\ncd", 1)
+
+	tests := []struct {
+		line, column int
+		expected     []Identifier // nil is the end of the document
+	}{
+		{1, 1, doc.Lines[0].Characters[0].Pos},
+		{2, 2, doc.Lines[1].Characters[1].Pos},
+		{1, 4, doc.Lines[1].Characters[0].Pos}, // Past the newline
+		{2, 3, nil},
+	}
+	for _, tt := range tests {
+		anchor := doc.AnchorAt(tt.line, tt.column)
+		if comparePositions(anchor, tt.expected) != 0 || (anchor == nil) != (tt.expected == nil) {
+			t.Errorf("AnchorAt(%d, %d): expected %v, got %v", tt.line, tt.column, tt.expected, anchor)
+		}
+	}
+
+	// The anchor follows its character onto a new line
+	anchor := doc.AnchorAt(2, 2)
+	pos, _ := doc.GeneratePositionAt(2, 1, 2)
+	_ = doc.InsertCharacter('\n', pos, 50)
+	if line, column := doc.Locate(anchor); line != 3 || column != 2 {
+		t.Errorf("Expected 'd' at (3, 2), got (%d, %d)", line, column)
+	}
+
+	// A deleted anchor moves to the next character, or the end of the document
+	_ = doc.DeleteCharacter(anchor)
+	if line, column := doc.Locate(anchor); line != 3 || column != 2 {
+		t.Errorf("Expected end of document at (3, 2), got (%d, %d)", line, column)
+	}
+	if line, column := doc.Locate(nil); line != 3 || column != 2 {
+		t.Errorf("Expected nil anchor at end of document (3, 2), got (%d, %d)", line, column)
+	}
+}
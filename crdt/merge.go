@@ -0,0 +1,153 @@
+package crdt
+
+import "encoding/binary"
+
+// Tombstone records a deleted character so that merging with a replica that still
+// has it does not bring it back
+type Tombstone struct {
+	Pos   []Identifier `json:"pos"`
+	Clock int          `json:"clock"`
+}
+
+// Merge folds another replica into this document: the result holds every
+// character either replica has that neither has deleted, in position order, and
+// the tombstones of both. Merging A into B leaves the same text and tombstones as
+// merging B into A. Markers and protected regions from other are added only where
+// this document has none of the same name, and its language only if this document has none.
+func (d *Document) Merge(other *Document) {
+	if other == nil || other == d {
+		return
+	}
+
+	for _, t := range other.Tombstones {
+		d.addTombstone(t.Pos, t.Clock)
+	}
+
+	ours, theirs := d.sequence(), other.sequence()
+	merged := make([]Character, 0, max(len(ours), len(theirs)))
+	i, j := 0, 0
+	for i < len(ours) || j < len(theirs) {
+		var next Character
+		mine := true
+		switch {
+		case j == len(theirs):
+			next, i = ours[i], i+1
+		case i == len(ours):
+			next, j, mine = theirs[j], j+1, false
+		default:
+			c := compareCharacters(ours[i], theirs[j])
+			if c == 0 {
+				j++ // Both replicas have this character
+			}
+			if c <= 0 {
+				next, i = ours[i], i+1
+			} else {
+				next, j, mine = theirs[j], j+1, false
+			}
+		}
+
+		switch {
+		case !d.isDeleted(next.Pos, next.Clock):
+			merged = append(merged, next)
+			if !mine {
+				d.logEdit(false, next)
+				d.counters.applied(false, next)
+			}
+		case mine:
+			d.logEdit(true, next)
+			d.counters.applied(true, next)
+		}
+	}
+
+	d.chars = merged
+	d.rebuildLines()
+	if d.words != nil {
+		d.words.rebuild(d.chars)
+	}
+	d.enforceLimits()
+
+	for name, pos := range other.Markers {
+		if _, ok := d.Markers[name]; !ok {
+			d.SetMarker(name, pos)
+		}
+	}
+
+	// Metadata works the same way: ours wins, theirs fills the gaps
+	if d.Metadata.Language == "" {
+		d.Metadata.Language = other.Metadata.Language
+	}
+	for _, r := range other.Metadata.Protected {
+		if !d.hasRegion(r.Name) {
+			d.Metadata.Protected = append(d.Metadata.Protected, r)
+		}
+	}
+}
+
+// MergeChanges returns what merging another replica would change in this
+// document: the characters it has that this one neither has nor deleted, and
+// those this one has that it deleted
+func (d *Document) MergeChanges(other *Document) (inserted, deleted []Character) {
+	if other == nil || other == d {
+		return nil, nil
+	}
+	for _, char := range other.sequence() {
+		if !d.HasCharacter(char.Pos, char.Clock) && !other.isDeleted(char.Pos, char.Clock) {
+			inserted = append(inserted, char)
+		}
+	}
+	for _, t := range other.Tombstones {
+		if char, ok := d.characterAt(t.Pos, t.Clock); ok {
+			deleted = append(deleted, char)
+		}
+	}
+	return inserted, deleted
+}
+
+// hasRegion reports whether the document has a protected region with the given name
+func (d *Document) hasRegion(name string) bool {
+	for _, r := range d.Metadata.Protected {
+		if r.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// compareCharacters orders characters by position, then by clock
+func compareCharacters(a, b Character) int {
+	if c := comparePositions(a.Pos, b.Pos); c != 0 {
+		return c
+	}
+	return a.Clock - b.Clock
+}
+
+// isDeleted reports whether the character with the given position and clock has a tombstone
+func (d *Document) isDeleted(pos []Identifier, clock int) bool {
+	if len(d.Tombstones) == 0 {
+		return false
+	}
+	if d.deleted == nil {
+		d.deleted = make(map[string]bool, len(d.Tombstones))
+		for _, t := range d.Tombstones {
+			d.deleted[tombstoneKey(t.Pos, t.Clock)] = true
+		}
+	}
+	return d.deleted[tombstoneKey(pos, clock)]
+}
+
+// addTombstone records that a character was deleted
+func (d *Document) addTombstone(pos []Identifier, clock int) {
+	if d.isDeleted(pos, clock) {
+		return
+	}
+	if d.deleted == nil {
+		d.deleted = make(map[string]bool)
+	}
+	d.Tombstones = append(d.Tombstones, Tombstone{Pos: pos, Clock: clock})
+	d.deleted[tombstoneKey(pos, clock)] = true
+}
+
+// tombstoneKey identifies a character by its clock and position
+func tombstoneKey(pos []Identifier, clock int) string {
+	return string(AppendPosition(binary.AppendVarint(nil, int64(clock)), pos))
+}
@@ -0,0 +1,116 @@
+package crdt
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// replicate returns an independent copy of a document, as a peer would receive it
+func replicate(t *testing.T, doc *Document) *Document {
+	t.Helper()
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Failed to marshal document: %v", err)
+	}
+	var copied Document
+	if err := json.Unmarshal(data, &copied); err != nil {
+		t.Fatalf("Failed to unmarshal document: %v", err)
+	}
+	return &copied
+}
+
+func TestMergeConcurrentEdits(t *testing.T) {
+	base := FromText("Hello World", 1)
+	a := replicate(t, base)
+	b := replicate(t, base)
+
+	// Replica A appends and deletes the space
+	pos, _ := a.GeneratePositionAt(1, 12, 2)
+	_ = a.InsertCharacter('!', pos, 100)
+	_ = a.DeleteCharacter(a.Lines[0].Characters[5].Pos)
+
+	// Replica B starts a new line and deletes the 'H'
+	pos, _ = b.GeneratePositionAt(1, 12, 3)
+	_ = b.InsertCharacter('\n', pos, 100)
+	_ = b.DeleteCharacter(b.Lines[0].Characters[0].Pos)
+
+	ab := replicate(t, a)
+	ab.Merge(b)
+	ba := replicate(t, b)
+	ba.Merge(a)
+
+	if ab.ToText() != ba.ToText() {
+		t.Fatalf("Merge is not symmetric: %q vs %q", ab.ToText(), ba.ToText())
+	}
+	// Both new characters sort after the 'd'; their order depends on the node IDs
+	if ab.ToText() != "elloWorld!\n" {
+		t.Errorf("Expected 'elloWorld!\\n', got %q", ab.ToText())
+	}
+	if len(ab.Lines) != 2 {
+		t.Errorf("Expected the merged newline to start a second line, got %d lines", len(ab.Lines))
+	}
+	if len(ab.Tombstones) != 2 {
+		t.Errorf("Expected both tombstones after merge, got %d", len(ab.Tombstones))
+	}
+
+	// Merging again changes nothing
+	ab.Merge(b)
+	if ab.ToText() != ba.ToText() {
+		t.Errorf("Repeated merge changed the text to %q", ab.ToText())
+	}
+}
+
+func TestMergeKeepsLocalEdits(t *testing.T) {
+	local := FromText("abc", 1)
+	remote := replicate(t, local)
+
+	// An edit that has not been broadcast yet
+	pos, _ := local.GeneratePositionAt(1, 4, 2)
+	_ = local.InsertCharacter('d', pos, 50)
+
+	local.Merge(remote)
+	if local.ToText() != "abcd" {
+		t.Errorf("Expected local edit to survive sync, got %q", local.ToText())
+	}
+}
+
+func TestMergeChanges(t *testing.T) {
+	local := FromText("abc", 1)
+	remote := replicate(t, local)
+
+	// The remote replica adds a 'd' and deletes the 'a', the local one deletes the 'c'
+	pos, _ := remote.GeneratePositionAt(1, 4, 2)
+	_ = remote.InsertCharacter('d', pos, 50)
+	_ = remote.DeleteCharacter(remote.Lines[0].Characters[0].Pos)
+	_ = local.DeleteCharacter(local.Lines[0].Characters[2].Pos)
+
+	inserted, deleted := local.MergeChanges(remote)
+	if len(inserted) != 1 || inserted[0].Value != 'd' || len(deleted) != 1 || deleted[0].Value != 'a' {
+		t.Errorf("Expected the 'd' inserted and the 'a' deleted, got %v and %v", inserted, deleted)
+	}
+	local.Merge(remote)
+	if inserted, deleted := local.MergeChanges(remote); len(inserted) != 0 || len(deleted) != 0 {
+		t.Errorf("Expected nothing left to merge, got %v and %v", inserted, deleted)
+	}
+}
+
+func TestInsertAfterDeleteIgnored(t *testing.T) {
+	doc := FromText("ab", 1)
+	char := doc.Lines[0].Characters[1]
+	_ = doc.DeleteCharacter(char.Pos)
+
+	err := doc.InsertCharacter(char.Value, char.Pos, char.Clock)
+	if !errors.Is(err, ErrAlreadyApplied) {
+		t.Errorf("Expected ErrAlreadyApplied re-inserting a deleted character, got %v", err)
+	}
+	if doc.ToText() != "a" {
+		t.Errorf("Expected 'a', got %q", doc.ToText())
+	}
+
+	// Tombstones survive serialization
+	copied := replicate(t, doc)
+	if err := copied.InsertCharacter(char.Value, char.Pos, char.Clock); !errors.Is(err, ErrAlreadyApplied) {
+		t.Errorf("Expected tombstone to survive serialization, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package crdt
+
+import (
+	"errors"
+	"sort"
+)
+
+// ErrOffsetOutOfRange is returned for an offset outside the document
+var ErrOffsetOutOfRange = errors.New("offset out of range")
+
+// Len returns the number of characters in the document
+func (d *Document) Len() int {
+	return len(d.sequence())
+}
+
+// PositionAtOffset returns the position of the character at the given offset.
+// Offsets count the characters (runes, newlines included) before a point in the
+// document, from 0. They suit integrations that address text as one string, such
+// as language servers and web editors, where lines and columns are awkward.
+func (d *Document) PositionAtOffset(offset int) ([]Identifier, error) {
+	chars := d.sequence()
+	if offset < 0 || offset >= len(chars) {
+		return nil, ErrOffsetOutOfRange
+	}
+	return chars[offset].Pos, nil
+}
+
+// OffsetOfPosition returns the offset of the character at the given position
+func (d *Document) OffsetOfPosition(pos []Identifier) (int, error) {
+	chars := d.sequence()
+	index := sort.Search(len(chars), func(i int) bool {
+		return comparePositions(chars[i].Pos, pos) >= 0
+	})
+	if index == len(chars) || comparePositions(chars[index].Pos, pos) != 0 {
+		return 0, errors.New("character not found at position")
+	}
+	return index, nil
+}
+
+// GeneratePositionAtOffset generates a position for a character inserted at the
+// given offset, between the characters before and after it. The offset may be
+// the document length, to append.
+func (d *Document) GeneratePositionAtOffset(offset, nodeID int) ([]Identifier, error) {
+	chars := d.sequence()
+	if offset < 0 || offset > len(chars) {
+		return nil, ErrOffsetOutOfRange
+	}
+	if len(chars) == 0 {
+		return []Identifier{{Digit: 1, Node: nodeID}}, nil
+	}
+	var prevPos, nextPos []Identifier
+	if offset > 0 {
+		prevPos = chars[offset-1].Pos
+	}
+	if offset < len(chars) {
+		nextPos = chars[offset].Pos
+	}
+	pos := generatePositionBetween(prevPos, nextPos, nodeID)
+	d.counters.allocate(pos)
+	return pos, nil
+}
+
+// InsertAtOffset inserts a character at the given offset, returning the position
+// it was given so the insertion can be sent to peers
+func (d *Document) InsertAtOffset(offset int, char rune, nodeID, clock int) ([]Identifier, error) {
+	pos, err := d.GeneratePositionAtOffset(offset, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.InsertCharacter(char, pos, clock); err != nil {
+		return nil, err
+	}
+	return pos, nil
+}
+
+// DeleteAtOffset deletes the character at the given offset, returning it so the
+// deletion can be sent to peers
+func (d *Document) DeleteAtOffset(offset int) (Character, error) {
+	chars := d.sequence()
+	if offset < 0 || offset >= len(chars) {
+		return Character{}, ErrOffsetOutOfRange
+	}
+	char := chars[offset]
+	if err := d.DeleteCharacter(char.Pos); err != nil {
+		return Character{}, err
+	}
+	return char, nil
+}
@@ -0,0 +1,83 @@
+package crdt
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOffsetsRoundTrip(t *testing.T) {
+	doc := FromText("héllo\nwörld", 1)
+	for offset := 0; offset < doc.Len(); offset++ {
+		pos, err := doc.PositionAtOffset(offset)
+		if err != nil {
+			t.Fatalf("PositionAtOffset(%d): %v", offset, err)
+		}
+		got, err := doc.OffsetOfPosition(pos)
+		if err != nil || got != offset {
+			t.Errorf("OffsetOfPosition(PositionAtOffset(%d)) = %d, %v", offset, got, err)
+		}
+	}
+	if doc.Len() != 11 {
+		t.Errorf("Expected 11 characters, got %d", doc.Len())
+	}
+
+	if _, err := doc.PositionAtOffset(doc.Len()); !errors.Is(err, ErrOffsetOutOfRange) {
+		t.Errorf("Expected an offset past the end to be out of range, got %v", err)
+	}
+	if _, err := doc.PositionAtOffset(-1); !errors.Is(err, ErrOffsetOutOfRange) {
+		t.Errorf("Expected a negative offset to be out of range, got %v", err)
+	}
+	if _, err := doc.OffsetOfPosition([]Identifier{{Digit: 999, Node: 999}}); err == nil {
+		t.Error("Expected an error for an unknown position")
+	}
+}
+
+func TestEditAtOffset(t *testing.T) {
+	doc := FromText("hello\nworld", 1)
+
+	for i, c := range []struct {
+		offset int
+		char   rune
+	}{{0, '>'}, {6, ','}, {13, '!'}} {
+		pos, err := doc.InsertAtOffset(c.offset, c.char, 2, 100+i)
+		if err != nil {
+			t.Fatalf("InsertAtOffset(%d): %v", c.offset, err)
+		}
+		if offset, _ := doc.OffsetOfPosition(pos); offset != c.offset {
+			t.Errorf("Expected %q at offset %d, found it at %d", c.char, c.offset, offset)
+		}
+	}
+	if text := doc.ToText(); text != ">hello,\nworld!" {
+		t.Fatalf("Expected inserts at their offsets, got %q", text)
+	}
+
+	char, err := doc.DeleteAtOffset(7)
+	if err != nil || char.Value != '\n' {
+		t.Fatalf("Expected to delete the newline, got %q, %v", char.Value, err)
+	}
+	if text := doc.ToText(); text != ">hello,world!" {
+		t.Errorf("Expected the lines joined, got %q", text)
+	}
+	if len(doc.Lines) != 1 {
+		t.Errorf("Expected 1 line, got %d", len(doc.Lines))
+	}
+
+	if _, err := doc.InsertAtOffset(doc.Len()+1, 'x', 2, 200); !errors.Is(err, ErrOffsetOutOfRange) {
+		t.Errorf("Expected an insert past the end to be out of range, got %v", err)
+	}
+	if _, err := doc.DeleteAtOffset(doc.Len()); !errors.Is(err, ErrOffsetOutOfRange) {
+		t.Errorf("Expected a delete past the end to be out of range, got %v", err)
+	}
+}
+
+func TestInsertAtOffsetEmptyDocument(t *testing.T) {
+	doc := &Document{}
+	for i, r := range "abc" {
+		if _, err := doc.InsertAtOffset(i, r, 1, i+1); err != nil {
+			t.Fatalf("InsertAtOffset(%d): %v", i, err)
+		}
+	}
+	if text := doc.ToText(); text != "abc" {
+		t.Errorf("Expected %q, got %q", "abc", text)
+	}
+}
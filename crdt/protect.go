@@ -0,0 +1,53 @@
+package crdt
+
+// Region is a named protected range of a document, such as a license header,
+// from the character at First to the character at Last inclusive. The range is
+// anchored to those characters, so it follows them as text is edited elsewhere.
+type Region struct {
+	Name  string       `json:"name"`
+	First []Identifier `json:"first"`
+	Last  []Identifier `json:"last"`
+}
+
+// Contains reports whether a character at pos, or one inserted there, falls
+// inside the region
+func (r Region) Contains(pos []Identifier) bool {
+	return comparePositions(pos, r.First) >= 0 && comparePositions(pos, r.Last) <= 0
+}
+
+// Protect marks the characters from first to last, inclusive, as a protected
+// region, replacing any region with the same name. Protection is advisory: the
+// document itself still accepts every edit, and editors enforce it.
+func (d *Document) Protect(name string, first, last []Identifier) {
+	if comparePositions(first, last) > 0 {
+		first, last = last, first
+	}
+	d.Unprotect(name)
+	d.Metadata.Protected = append(d.Metadata.Protected, Region{
+		Name:  name,
+		First: append([]Identifier(nil), first...),
+		Last:  append([]Identifier(nil), last...),
+	})
+}
+
+// Unprotect removes a protected region. Removing a region that does not exist is a no-op.
+func (d *Document) Unprotect(name string) {
+	// Build a new slice, as copies of the metadata may share the old one
+	var regions []Region
+	for _, r := range d.Metadata.Protected {
+		if r.Name != name {
+			regions = append(regions, r)
+		}
+	}
+	d.Metadata.Protected = regions
+}
+
+// ProtectedAt returns the protected region containing pos, if any
+func (d *Document) ProtectedAt(pos []Identifier) (Region, bool) {
+	for _, r := range d.Metadata.Protected {
+		if r.Contains(pos) {
+			return r, true
+		}
+	}
+	return Region{}, false
+}
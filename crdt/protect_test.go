@@ -0,0 +1,72 @@
+package crdt
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestProtectedRegion(t *testing.T) {
+	doc := FromText("// License\ncode", 1)
+	header := doc.Lines[0].Characters
+	doc.Protect("license", header[len(header)-1].Pos, header[0].Pos) // Reversed on purpose
+
+	if _, ok := doc.ProtectedAt(header[3].Pos); !ok {
+		t.Error("Expected a character inside the region to be protected")
+	}
+	if _, ok := doc.ProtectedAt(doc.Lines[1].Characters[0].Pos); ok {
+		t.Error("Expected a character after the region not to be protected")
+	}
+
+	// Inserts between protected characters are inside; before or after are not
+	inside, _ := doc.GeneratePositionAt(1, 4, 2)
+	if r, ok := doc.ProtectedAt(inside); !ok || r.Name != "license" {
+		t.Errorf("Expected an insert inside the header to be protected, got %+v (%v)", r, ok)
+	}
+	before, _ := doc.GeneratePositionAt(1, 1, 2)
+	after, _ := doc.GeneratePositionAt(2, 1, 2)
+	if _, ok := doc.ProtectedAt(before); ok {
+		t.Error("Expected an insert before the region not to be protected")
+	}
+	if _, ok := doc.ProtectedAt(after); ok {
+		t.Error("Expected an insert after the region not to be protected")
+	}
+
+	// The region follows its characters when text is added before it
+	_ = doc.InsertCharacter('\n', before, 50)
+	if _, ok := doc.ProtectedAt(doc.Lines[1].Characters[2].Pos); !ok {
+		t.Error("Expected the moved header to stay protected")
+	}
+
+	doc.Unprotect("license")
+	if _, ok := doc.ProtectedAt(header[3].Pos); ok {
+		t.Error("Expected no protection after Unprotect")
+	}
+}
+
+func TestProtectedRegionsSyncAndMerge(t *testing.T) {
+	doc := FromText("abc", 1)
+	doc.Protect("all", doc.Lines[0].Characters[0].Pos, doc.Lines[0].Characters[2].Pos)
+	doc.Metadata.Language = "go"
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Failed to marshal document: %v", err)
+	}
+	var decoded Document
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal document: %v", err)
+	}
+	if _, ok := decoded.ProtectedAt(doc.Lines[0].Characters[1].Pos); !ok {
+		t.Error("Expected protected regions to survive serialization")
+	}
+
+	// A joiner with no metadata of its own picks it up when merging
+	joiner := FromText("", 2)
+	joiner.Merge(doc)
+	if joiner.Metadata.Language != "go" || len(joiner.Metadata.Protected) != 1 {
+		t.Errorf("Expected merged metadata, got %+v", joiner.Metadata)
+	}
+	if _, ok := joiner.ProtectedAt(doc.Lines[0].Characters[1].Pos); !ok {
+		t.Error("Expected the merged region to protect the same characters")
+	}
+}
@@ -0,0 +1,70 @@
+package crdt
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Range is a span of text identified by the positions of its first and last
+// characters, so it stays meaningful while other text is edited around it
+type Range struct {
+	Start []Identifier `json:"start"`
+	End   []Identifier `json:"end"` // Position of the last character in the range (inclusive)
+}
+
+// Find returns the ranges of every non-overlapping occurrence of query, in document order
+func (d *Document) Find(query string) []Range {
+	if query == "" {
+		return nil
+	}
+
+	text, positions := d.flatten()
+	var ranges []Range
+	for offset := 0; offset < len(text); {
+		index := strings.Index(text[offset:], query)
+		if index < 0 {
+			break
+		}
+		start := offset + index
+		end := start + len(query)
+		ranges = append(ranges, rangeOf(text, positions, start, end))
+		offset = end
+	}
+	return ranges
+}
+
+// FindRegexp returns the ranges of every non-empty match of a regular expression, in document order
+func (d *Document) FindRegexp(re *regexp.Regexp) []Range {
+	text, positions := d.flatten()
+
+	var ranges []Range
+	for _, match := range re.FindAllStringIndex(text, -1) {
+		if match[0] == match[1] {
+			continue
+		}
+		ranges = append(ranges, rangeOf(text, positions, match[0], match[1]))
+	}
+	return ranges
+}
+
+// flatten returns the document text, including line breaks, and the position of
+// the character starting at each byte offset of that text
+func (d *Document) flatten() (string, map[int][]Identifier) {
+	var b strings.Builder
+	positions := make(map[int][]Identifier)
+	for _, char := range d.sequence() {
+		positions[b.Len()] = char.Pos
+		b.WriteRune(char.Value)
+	}
+	return b.String(), positions
+}
+
+// rangeOf converts the byte span [start, end) of flattened text to a Range
+func rangeOf(text string, positions map[int][]Identifier, start, end int) Range {
+	_, size := utf8.DecodeLastRuneInString(text[:end])
+	return Range{
+		Start: positions[start],
+		End:   positions[end-size],
+	}
+}
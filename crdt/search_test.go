@@ -0,0 +1,105 @@
+package crdt
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	doc := FromText("the cat\nthe hat", 1)
+
+	ranges := doc.Find("the")
+	if len(ranges) != 2 {
+		t.Fatalf("Expected 2 matches, got %d", len(ranges))
+	}
+
+	// First match starts at 't' on line 1 and ends at 'e'
+	if comparePositions(ranges[0].Start, doc.Lines[0].Characters[0].Pos) != 0 {
+		t.Errorf("First match starts at the wrong position: %v", ranges[0].Start)
+	}
+	if comparePositions(ranges[0].End, doc.Lines[0].Characters[2].Pos) != 0 {
+		t.Errorf("First match ends at the wrong position: %v", ranges[0].End)
+	}
+
+	// Second match is on line 2
+	if comparePositions(ranges[1].Start, doc.Lines[1].Characters[0].Pos) != 0 {
+		t.Errorf("Second match starts at the wrong position: %v", ranges[1].Start)
+	}
+
+	if len(doc.Find("dog")) != 0 {
+		t.Error("Expected no matches for missing text")
+	}
+	if len(doc.Find("")) != 0 {
+		t.Error("Expected no matches for an empty query")
+	}
+}
+
+func TestFindAcrossLines(t *testing.T) {
+	doc := FromText("end\nstart", 1)
+
+	ranges := doc.Find("d\ns")
+	if len(ranges) != 1 {
+		t.Fatalf("Expected 1 match spanning lines, got %d", len(ranges))
+	}
+	if comparePositions(ranges[0].Start, doc.Lines[0].Characters[2].Pos) != 0 {
+		t.Errorf("Match starts at the wrong position: %v", ranges[0].Start)
+	}
+	if comparePositions(ranges[0].End, doc.Lines[1].Characters[0].Pos) != 0 {
+		t.Errorf("Match ends at the wrong position: %v", ranges[0].End)
+	}
+}
+
+func TestFindNonOverlapping(t *testing.T) {
+	doc := FromText("aaaa", 1)
+	if ranges := doc.Find("aa"); len(ranges) != 2 {
+		t.Errorf("Expected 2 non-overlapping matches, got %d", len(ranges))
+	}
+}
+
+func TestFindSurvivesEdits(t *testing.T) {
+	doc := FromText("find me", 1)
+	ranges := doc.Find("me")
+
+	// Insert text before the match; the range still names the same characters
+	pos, _ := doc.GeneratePositionAt(1, 1, 2)
+	_ = doc.InsertCharacter('>', pos, 10)
+
+	again := doc.Find("me")
+	if len(again) != 1 || comparePositions(again[0].Start, ranges[0].Start) != 0 {
+		t.Errorf("Expected match to keep its position after an edit, got %v", again)
+	}
+}
+
+func TestFindRegexp(t *testing.T) {
+	doc := FromText("x1 = 10\ny22 = 5", 1)
+
+	ranges := doc.FindRegexp(regexp.MustCompile(`[0-9]+`))
+	if len(ranges) != 4 {
+		t.Fatalf("Expected 4 numeric matches, got %d", len(ranges))
+	}
+
+	// "22" on line 2
+	if comparePositions(ranges[2].Start, doc.Lines[1].Characters[1].Pos) != 0 {
+		t.Errorf("Third match starts at the wrong position: %v", ranges[2].Start)
+	}
+	if comparePositions(ranges[2].End, doc.Lines[1].Characters[2].Pos) != 0 {
+		t.Errorf("Third match ends at the wrong position: %v", ranges[2].End)
+	}
+
+	// Empty matches are skipped
+	if len(doc.FindRegexp(regexp.MustCompile(`z*`))) != 0 {
+		t.Error("Expected empty matches to be skipped")
+	}
+}
+
+func TestFindMultibyte(t *testing.T) {
+	doc := FromText("héllo wörld", 1)
+
+	ranges := doc.Find("wö")
+	if len(ranges) != 1 {
+		t.Fatalf("Expected 1 match, got %d", len(ranges))
+	}
+	if comparePositions(ranges[0].End, doc.Lines[0].Characters[7].Pos) != 0 {
+		t.Errorf("Match ends at the wrong position: %v", ranges[0].End)
+	}
+}
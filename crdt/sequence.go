@@ -0,0 +1,82 @@
+package crdt
+
+// sequence returns the document's characters in position order. This is the
+// authoritative state; Lines is derived from it.
+func (d *Document) sequence() []Character {
+	if d.chars == nil {
+		d.rebuildSequence()
+	}
+	return d.chars
+}
+
+// rebuildSequence derives the character sequence from Lines, for documents that
+// were constructed with a Lines literal or decoded from JSON
+func (d *Document) rebuildSequence() {
+	total := 0
+	for _, line := range d.Lines {
+		total += len(line.Characters)
+	}
+	d.chars = make([]Character, 0, total)
+	for _, line := range d.Lines {
+		d.chars = append(d.chars, line.Characters...)
+	}
+}
+
+// rebuildLines derives Lines from the character sequence
+func (d *Document) rebuildLines() {
+	d.Lines = []Line{{Characters: []Character{}}}
+	for _, char := range d.chars {
+		last := &d.Lines[len(d.Lines)-1]
+		last.Characters = append(last.Characters, char)
+		if char.Value == '\n' {
+			d.Lines = append(d.Lines, Line{Characters: []Character{}})
+		}
+	}
+}
+
+// indexInsert updates Lines after a character was inserted at index in the sequence
+func (d *Document) indexInsert(index int, char Character) {
+	if len(d.Lines) == 0 {
+		d.Lines = append(d.Lines, Line{Characters: []Character{}})
+	}
+
+	lineIndex, column := d.lineOf(index)
+	line := d.Lines[lineIndex].Characters
+	if char.Value != '\n' {
+		d.Lines[lineIndex].Characters = append(line[:column], append([]Character{char}, line[column:]...)...)
+		return
+	}
+
+	// A newline ends its line and the characters after it start the next one
+	rest := append([]Character{}, line[column:]...)
+	d.Lines[lineIndex].Characters = append(line[:column], char)
+	d.Lines = append(d.Lines[:lineIndex+1], append([]Line{{Characters: rest}}, d.Lines[lineIndex+1:]...)...)
+}
+
+// indexDelete updates Lines after the character at index was removed from the sequence
+func (d *Document) indexDelete(index int) {
+	lineIndex, column := d.lineOf(index)
+	line := d.Lines[lineIndex].Characters
+	char := line[column]
+	d.Lines[lineIndex].Characters = append(line[:column], line[column+1:]...)
+
+	// Removing a newline joins the following line onto this one
+	if char.Value == '\n' && lineIndex+1 < len(d.Lines) {
+		d.Lines[lineIndex].Characters = append(d.Lines[lineIndex].Characters, d.Lines[lineIndex+1].Characters...)
+		d.Lines = append(d.Lines[:lineIndex+1], d.Lines[lineIndex+2:]...)
+	}
+}
+
+// lineOf converts an index in the sequence to a line and column in Lines. An
+// index one past the end of a line that ends with a newline belongs to the next line.
+func (d *Document) lineOf(index int) (lineIndex, column int) {
+	start := 0
+	for i, line := range d.Lines {
+		if index < start+len(line.Characters) {
+			return i, index - start
+		}
+		start += len(line.Characters)
+	}
+	last := len(d.Lines) - 1
+	return last, len(d.Lines[last].Characters)
+}
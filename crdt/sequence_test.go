@@ -0,0 +1,102 @@
+package crdt
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// checkIndex verifies that Lines is exactly the sequence split after each newline
+func checkIndex(t *testing.T, doc *Document) {
+	t.Helper()
+
+	var fromLines []Character
+	for i, line := range doc.Lines {
+		for j, char := range line.Characters {
+			if char.Value == '\n' && j != len(line.Characters)-1 {
+				t.Fatalf("Line %d has a newline before its end", i)
+			}
+		}
+		if i < len(doc.Lines)-1 {
+			n := len(line.Characters)
+			if n == 0 || line.Characters[n-1].Value != '\n' {
+				t.Fatalf("Line %d does not end with a newline", i)
+			}
+		}
+		fromLines = append(fromLines, line.Characters...)
+	}
+
+	chars := doc.sequence()
+	if len(fromLines) != len(chars) {
+		t.Fatalf("Lines hold %d characters, sequence holds %d", len(fromLines), len(chars))
+	}
+	for i := range chars {
+		if comparePositions(chars[i].Pos, fromLines[i].Pos) != 0 {
+			t.Fatalf("Lines and sequence differ at index %d", i)
+		}
+		if i > 0 && comparePositions(chars[i-1].Pos, chars[i].Pos) > 0 {
+			t.Fatalf("Sequence out of order at index %d", i)
+		}
+	}
+}
+
+func TestLineIndexFollowsSequence(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	doc := FromText("", 1)
+
+	// Insert and delete characters at known positions in random order
+	present := make(map[int]rune)
+	for step := 0; step < 500; step++ {
+		digit := 1 + rng.Intn(100)
+		pos := []Identifier{{Digit: digit, Node: 2}}
+		if _, ok := present[digit]; ok {
+			if err := doc.DeleteCharacter(pos); err != nil {
+				t.Fatalf("Step %d: delete failed: %v", step, err)
+			}
+			delete(present, digit)
+		} else {
+			char := rune('a' + rng.Intn(26))
+			if rng.Intn(4) == 0 {
+				char = '\n'
+			}
+			if err := doc.InsertCharacter(char, pos, step); err != nil {
+				t.Fatalf("Step %d: insert failed: %v", step, err)
+			}
+			present[digit] = char
+		}
+
+		var expected strings.Builder
+		for digit := 1; digit <= 100; digit++ {
+			if char, ok := present[digit]; ok {
+				expected.WriteRune(char)
+			}
+		}
+
+		checkIndex(t, doc)
+		if doc.ToText() != expected.String() {
+			t.Fatalf("Step %d: expected %q, got %q", step, expected.String(), doc.ToText())
+		}
+	}
+}
+
+func TestSequenceFromLinesLiteral(t *testing.T) {
+	doc := &Document{
+		Lines: []Line{
+			{Characters: []Character{
+				{Pos: []Identifier{{Digit: 1, Node: 1}}, Clock: 1, Value: 'a'},
+				{Pos: []Identifier{{Digit: 2, Node: 1}}, Clock: 2, Value: '\n'},
+			}},
+			{Characters: []Character{
+				{Pos: []Identifier{{Digit: 3, Node: 1}}, Clock: 3, Value: 'b'},
+			}},
+		},
+	}
+
+	if err := doc.DeleteCharacter([]Identifier{{Digit: 2, Node: 1}}); err != nil {
+		t.Fatalf("Failed to delete newline: %v", err)
+	}
+	checkIndex(t, doc)
+	if len(doc.Lines) != 1 || doc.ToText() != "ab" {
+		t.Errorf("Expected single line 'ab', got %d lines %q", len(doc.Lines), doc.ToText())
+	}
+}
@@ -0,0 +1,45 @@
+package crdt
+
+import (
+	"bufio"
+	"io"
+)
+
+// FromReader creates a CRDT document from text read from r, like FromText but
+// without holding the whole text in memory alongside the document
+func FromReader(r io.Reader, nodeID int) (*Document, error) {
+	br := bufio.NewReader(r)
+	doc := &Document{chars: []Character{}}
+	for clock := 1; ; clock++ {
+		char, _, err := br.ReadRune()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		doc.chars = append(doc.chars, Character{
+			Pos:   []Identifier{{Digit: clock, Node: nodeID}},
+			Clock: clock,
+			Value: char,
+		})
+	}
+
+	doc.rebuildLines()
+	return doc, nil
+}
+
+// WriteTo writes the document's text to w, as returned by ToText, without
+// building it as a single string first. It implements io.WriterTo.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var n int64
+	for _, char := range d.sequence() {
+		size, err := bw.WriteRune(char.Value)
+		n += int64(size)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, bw.Flush()
+}
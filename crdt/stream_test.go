@@ -0,0 +1,70 @@
+package crdt
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestFromReaderMatchesFromText(t *testing.T) {
+	texts := []string{"", "Hello", "Hello\nWorld", "trailing\n", "\n\n", "héllo wörld ✓\nçà"}
+	for _, text := range texts {
+		// One byte at a time splits multi-byte characters across reads
+		doc, err := FromReader(iotest.OneByteReader(strings.NewReader(text)), 3)
+		if err != nil {
+			t.Fatalf("FromReader(%q) failed: %v", text, err)
+		}
+		expected := FromText(text, 3)
+
+		if doc.ToText() != text {
+			t.Errorf("FromReader(%q): got text %q", text, doc.ToText())
+		}
+		if len(doc.Lines) != len(expected.Lines) {
+			t.Errorf("FromReader(%q): expected %d lines, got %d", text, len(expected.Lines), len(doc.Lines))
+		}
+		got, want := doc.sequence(), expected.sequence()
+		for i := range want {
+			if i >= len(got) || comparePositions(got[i].Pos, want[i].Pos) != 0 || got[i].Clock != want[i].Clock {
+				t.Errorf("FromReader(%q): character %d differs from FromText", text, i)
+				break
+			}
+		}
+	}
+}
+
+func TestFromReaderError(t *testing.T) {
+	failure := errors.New("disk on fire")
+	if _, err := FromReader(iotest.ErrReader(failure), 1); !errors.Is(err, failure) {
+		t.Errorf("Expected the read error, got %v", err)
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	doc := FromText("line one\nline twö\n", 1)
+	pos, _ := doc.GeneratePositionAt(2, 1, 2)
+	_ = doc.InsertCharacter('>', pos, 100)
+
+	var buf bytes.Buffer
+	n, err := doc.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if buf.String() != doc.ToText() {
+		t.Errorf("Expected %q, got %q", doc.ToText(), buf.String())
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("Expected %d bytes written, got %d", buf.Len(), n)
+	}
+
+	failure := errors.New("disk full")
+	if _, err := doc.WriteTo(failingWriter{failure}); !errors.Is(err, failure) {
+		t.Errorf("Expected the write error, got %v", err)
+	}
+}
+
+// failingWriter fails every write
+type failingWriter struct{ err error }
+
+func (w failingWriter) Write(p []byte) (int, error) { return 0, w.err }
@@ -0,0 +1,50 @@
+package crdt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TextBetween returns the text from the character at startPos up to, but not
+// including, the character at endPos. An empty startPos means the beginning of
+// the document and an empty endPos means its end. If endPos comes before
+// startPos the two are swapped.
+func (d *Document) TextBetween(startPos, endPos []Identifier) (string, error) {
+	chars := d.sequence()
+
+	start := 0
+	if len(startPos) > 0 {
+		start = indexOfPosition(chars, startPos)
+		if start < 0 {
+			return "", fmt.Errorf("start position not found")
+		}
+	}
+
+	end := len(chars)
+	if len(endPos) > 0 {
+		end = indexOfPosition(chars, endPos)
+		if end < 0 {
+			return "", fmt.Errorf("end position not found")
+		}
+	}
+
+	if start > end {
+		start, end = end, start
+	}
+
+	var result strings.Builder
+	for _, char := range chars[start:end] {
+		result.WriteRune(char.Value)
+	}
+	return result.String(), nil
+}
+
+// indexOfPosition returns the index of the character with the given position, or -1
+func indexOfPosition(chars []Character, pos []Identifier) int {
+	for i, char := range chars {
+		if comparePositions(char.Pos, pos) == 0 {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,48 @@
+package crdt
+
+import "testing"
+
+func TestTextBetween(t *testing.T) {
+	doc := FromText("Hello\nWorld", 1)
+	h := doc.Lines[0].Characters[0].Pos
+	l := doc.Lines[0].Characters[2].Pos
+	r := doc.Lines[1].Characters[2].Pos
+
+	tests := []struct {
+		name     string
+		start    []Identifier
+		end      []Identifier
+		expected string
+	}{
+		{"within a line", h, l, "He"},
+		{"across lines", l, r, "llo\nWo"},
+		{"reversed", r, l, "llo\nWo"},
+		{"to end of document", r, nil, "rld"},
+		{"from start of document", nil, l, "He"},
+		{"whole document", nil, nil, "Hello\nWorld"},
+		{"empty range", l, l, ""},
+	}
+
+	for _, tt := range tests {
+		text, err := doc.TextBetween(tt.start, tt.end)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if text != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, text)
+		}
+	}
+}
+
+func TestTextBetweenUnknownPosition(t *testing.T) {
+	doc := FromText("Hello", 1)
+	missing := []Identifier{{Digit: 999, Node: 999}}
+
+	if _, err := doc.TextBetween(missing, nil); err == nil {
+		t.Error("Expected error for unknown start position")
+	}
+	if _, err := doc.TextBetween(nil, missing); err == nil {
+		t.Error("Expected error for unknown end position")
+	}
+}
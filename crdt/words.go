@@ -0,0 +1,104 @@
+package crdt
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// WordIndex counts the words in a document, kept up to date as characters are
+// inserted and deleted by re-reading only the words next to each edit
+type WordIndex struct {
+	counts map[string]int
+}
+
+// EnableWords starts indexing the words in the document for Completions
+func (d *Document) EnableWords() *WordIndex {
+	d.words = &WordIndex{}
+	d.words.rebuild(d.sequence())
+	return d.words
+}
+
+// Completions returns up to limit words in the document that start with prefix
+// and are longer than it, most frequent first. It returns nil unless EnableWords was called.
+func (d *Document) Completions(prefix string, limit int) []string {
+	if d.words == nil || prefix == "" {
+		return nil
+	}
+
+	var matches []string
+	for word := range d.words.counts {
+		if len(word) > len(prefix) && strings.HasPrefix(word, prefix) {
+			matches = append(matches, word)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		ci, cj := d.words.counts[matches[i]], d.words.counts[matches[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return matches[i] < matches[j]
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// Count returns how many times a word appears in the document
+func (w *WordIndex) Count(word string) int {
+	return w.counts[word]
+}
+
+// rebuild indexes every word in the sequence from scratch
+func (w *WordIndex) rebuild(chars []Character) {
+	w.counts = make(map[string]int)
+	w.add(wordsIn(chars, 0, len(chars)))
+}
+
+func (w *WordIndex) add(words []string) {
+	for _, word := range words {
+		w.counts[word]++
+	}
+}
+
+func (w *WordIndex) remove(words []string) {
+	for _, word := range words {
+		if w.counts[word]--; w.counts[word] <= 0 {
+			delete(w.counts, word)
+		}
+	}
+}
+
+// wordsIn returns every whole word that overlaps chars[from:to]
+func wordsIn(chars []Character, from, to int) []string {
+	from, to = max(from, 0), min(to, len(chars))
+	for from > 0 && from < len(chars) && isWordRune(chars[from].Value) && isWordRune(chars[from-1].Value) {
+		from--
+	}
+	for to > 0 && to < len(chars) && isWordRune(chars[to-1].Value) && isWordRune(chars[to].Value) {
+		to++
+	}
+
+	var words []string
+	var word []rune
+	for i := from; i < to; i++ {
+		if isWordRune(chars[i].Value) {
+			word = append(word, chars[i].Value)
+			continue
+		}
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = word[:0]
+		}
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	return words
+}
+
+// isWordRune reports whether a rune can be part of a word or identifier
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
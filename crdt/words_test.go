@@ -0,0 +1,68 @@
+package crdt
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestCompletions(t *testing.T) {
+	doc := FromText("func handleMessage(msg) {\n\thandleMessage(msg)\n\thandler := 1\n}", 1)
+	doc.EnableWords()
+
+	got := doc.Completions("hand", 10)
+	expected := []string{"handleMessage", "handler"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	if got := doc.Completions("handler", 10); len(got) != 0 {
+		t.Errorf("A complete word should not complete to itself, got %v", got)
+	}
+	if got := doc.Completions("hand", 1); len(got) != 1 || got[0] != "handleMessage" {
+		t.Errorf("Expected the limit to keep the most frequent word, got %v", got)
+	}
+}
+
+func TestWordIndexFollowsEdits(t *testing.T) {
+	doc := FromText("foo bar", 1)
+	words := doc.EnableWords()
+
+	// Joining two words by deleting the space between them
+	_ = doc.DeleteCharacter(doc.Lines[0].Characters[3].Pos)
+	if words.Count("foobar") != 1 || words.Count("foo") != 0 || words.Count("bar") != 0 {
+		t.Errorf("Expected only 'foobar' after joining, got %v", words.counts)
+	}
+
+	// Splitting it again with a newline
+	pos, _ := doc.GeneratePositionAt(1, 4, 2)
+	_ = doc.InsertCharacter('\n', pos, 50)
+	if words.Count("foo") != 1 || words.Count("bar") != 1 || words.Count("foobar") != 0 {
+		t.Errorf("Expected 'foo' and 'bar' after splitting, got %v", words.counts)
+	}
+}
+
+func TestWordIndexMatchesRebuild(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	doc := FromText("", 1)
+	words := doc.EnableWords()
+
+	alphabet := []rune("ab _\n")
+	present := make(map[int]bool)
+	for step := 0; step < 1000; step++ {
+		digit := 1 + rng.Intn(60)
+		pos := []Identifier{{Digit: digit, Node: 2}}
+		if present[digit] {
+			_ = doc.DeleteCharacter(pos)
+			delete(present, digit)
+		} else {
+			_ = doc.InsertCharacter(alphabet[rng.Intn(len(alphabet))], pos, step)
+			present[digit] = true
+		}
+
+		var fresh WordIndex
+		fresh.rebuild(doc.sequence())
+		if !reflect.DeepEqual(words.counts, fresh.counts) {
+			t.Fatalf("Step %d: incremental index %v differs from rebuilt %v", step, words.counts, fresh.counts)
+		}
+	}
+}
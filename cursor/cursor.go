@@ -3,7 +3,6 @@ package cursor
 import (
 	"fmt"
 	"gollaborate/crdt"
-	"strings"
 )
 
 // Manager handles cursor and selection tracking for collaborative editing
@@ -138,58 +137,10 @@ func (m *Manager) GetTextSelectionFromCRDTPositions(startPos, endPos []crdt.Iden
 
 // ExtractTextFromSelection returns the text content within a selection range
 func (m *Manager) ExtractTextFromSelection(startPos, endPos []crdt.Identifier) (string, error) {
-	startCoords, err := m.GetTextCoordsFromCRDTPosition(startPos)
-	if err != nil {
-		return "", err
-	}
-
-	endCoords, err := m.GetTextCoordsFromCRDTPosition(endPos)
-	if err != nil {
-		return "", err
-	}
-
-	return m.extractTextBetweenCoords(startCoords, endCoords), nil
-}
-
-// extractTextBetweenCoords extracts text between two text coordinates
-func (m *Manager) extractTextBetweenCoords(start, end TextPosition) string {
-	if m.document == nil || len(m.document.Lines) == 0 {
-		return ""
-	}
-
-	var result strings.Builder
-
-	// Ensure start comes before end
-	if start.Line > end.Line || (start.Line == end.Line && start.Column > end.Column) {
-		start, end = end, start
-	}
-
-	for lineNum := start.Line; lineNum <= end.Line && lineNum <= len(m.document.Lines); lineNum++ {
-		lineIndex := lineNum - 1
-		line := m.document.Lines[lineIndex]
-
-		startCol := 1
-		endCol := len(line.Characters)
-
-		if lineNum == start.Line {
-			startCol = start.Column
-		}
-		if lineNum == end.Line {
-			endCol = end.Column - 1 // Exclusive end
-		}
-
-		for col := startCol; col <= endCol && col <= len(line.Characters); col++ {
-			charIndex := col - 1
-			result.WriteRune(line.Characters[charIndex].Value)
-		}
-
-		// Add newline if not the last line in selection
-		if lineNum < end.Line {
-			result.WriteRune('\n')
-		}
+	if m.document == nil {
+		return "", fmt.Errorf("document is nil")
 	}
-
-	return result.String()
+	return m.document.TextBetween(startPos, endPos)
 }
 
 // identifiersEqual compares two identifier slices for equality
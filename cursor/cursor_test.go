@@ -196,4 +196,23 @@ func TestEmptyDocument(t *testing.T) {
 	if coords.Line != 1 || coords.Column != 1 {
 		t.Errorf("Expected (1,1) for empty position, got (%d,%d)", coords.Line, coords.Column)
 	}
-}
\ No newline at end of file
+}
+
+func TestExtractTextAcrossLines(t *testing.T) {
+	doc := crdt.FromText("Hello\nWorld", 1)
+	manager := NewManager(doc, 1, "User 1", "#FF0000")
+
+	// From 'l' in "Hello" up to (not including) 'r' in "World"
+	startPos := doc.Lines[0].Characters[2].Pos
+	endPos := doc.Lines[1].Characters[2].Pos
+
+	text, err := manager.ExtractTextFromSelection(startPos, endPos)
+	if err != nil {
+		t.Fatalf("Failed to extract text: %v", err)
+	}
+
+	expected := "llo\nWo"
+	if text != expected {
+		t.Errorf("Expected text %q, got %q", expected, text)
+	}
+}
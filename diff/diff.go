@@ -0,0 +1,87 @@
+// Package diff computes the edits that turn one text into another, for front ends
+// that only see whole snapshots of the text and need CRDT operations.
+package diff
+
+// Op is the kind of change an Edit makes
+type Op int
+
+const (
+	Insert Op = iota
+	Delete
+)
+
+func (op Op) String() string {
+	if op == Insert {
+		return "insert"
+	}
+	return "delete"
+}
+
+// Edit is a change to a run of text. Offset is in runes of the old text: a
+// Delete removes the runes of Text starting there, an Insert adds Text before
+// the rune there.
+type Edit struct {
+	Op     Op
+	Offset int
+	Text   string
+}
+
+// Differ computes edits, ordered by offset, that turn old into new
+type Differ interface {
+	Diff(old, new string) []Edit
+}
+
+// Default is the differ front ends should use unless they have a reason not to
+var Default Differ = Myers{}
+
+// Apply applies edits produced by a Differ to the text they were computed from
+func Apply(old string, edits []Edit) string {
+	src := []rune(old)
+	var out []rune
+	next := 0
+	for _, e := range edits {
+		out = append(out, src[next:e.Offset]...)
+		next = e.Offset
+		if e.Op == Delete {
+			next += len([]rune(e.Text))
+		} else {
+			out = append(out, []rune(e.Text)...)
+		}
+	}
+	return string(append(out, src[next:]...))
+}
+
+// trim returns the length of the common prefix and suffix of a and b, which never overlap
+func trim(a, b []rune) (prefix, suffix int) {
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	return prefix, suffix
+}
+
+// builder accumulates single-rune changes into runs
+type builder struct {
+	edits []Edit
+}
+
+func (b *builder) add(op Op, offset int, r rune) {
+	if n := len(b.edits); n > 0 {
+		last := &b.edits[n-1]
+		if last.Op == op {
+			// Deletes continue at the next old rune; inserts all share one offset
+			end := last.Offset
+			if op == Delete {
+				end += len([]rune(last.Text))
+			}
+			if end == offset {
+				last.Text += string(r)
+				return
+			}
+		}
+	}
+	b.edits = append(b.edits, Edit{Op: op, Offset: offset, Text: string(r)})
+}
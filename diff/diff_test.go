@@ -0,0 +1,92 @@
+package diff
+
+import (
+	"math/rand"
+	"testing"
+)
+
+var differs = map[string]Differ{
+	"myers":         Myers{},
+	"prefix-suffix": PrefixSuffix{},
+}
+
+func TestDiffRoundTrip(t *testing.T) {
+	tests := []struct{ old, new string }{
+		{"", ""},
+		{"", "hello"},
+		{"hello", ""},
+		{"hello", "hello"},
+		{"hello", "help"},
+		{"abc", "xabcx"},
+		{"the quick brown fox", "the slow brown dog"},
+		{"line one\nline two\n", "line one\ninserted\nline two\n"},
+		{"héllo wörld", "hello world"},
+	}
+
+	for name, differ := range differs {
+		for _, tt := range tests {
+			edits := differ.Diff(tt.old, tt.new)
+			if got := Apply(tt.old, edits); got != tt.new {
+				t.Errorf("%s: %q -> %q: applying %+v gave %q", name, tt.old, tt.new, edits, got)
+			}
+		}
+	}
+}
+
+func TestDiffRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomText := func() string {
+		runes := make([]rune, rng.Intn(30))
+		for i := range runes {
+			runes[i] = rune('a' + rng.Intn(4))
+		}
+		return string(runes)
+	}
+
+	for i := 0; i < 500; i++ {
+		old, new := randomText(), randomText()
+		for name, differ := range differs {
+			if got := Apply(old, differ.Diff(old, new)); got != new {
+				t.Fatalf("%s: %q -> %q gave %q", name, old, new, got)
+			}
+		}
+	}
+}
+
+func TestMyersSeparateChanges(t *testing.T) {
+	old := "the quick brown fox"
+	new := "the slow brown dog"
+
+	myers := Myers{}.Diff(old, new)
+	naive := PrefixSuffix{}.Diff(old, new)
+	if changed(myers) >= changed(naive) {
+		t.Errorf("Expected Myers to change fewer characters than prefix/suffix, got %d and %d",
+			changed(myers), changed(naive))
+	}
+
+	// The unchanged " brown " in the middle is not touched
+	for _, e := range myers {
+		if e.Offset > 9 && e.Offset < 16 {
+			t.Errorf("Unexpected edit inside the unchanged middle: %+v", e)
+		}
+	}
+}
+
+func TestMyersCoalescesRuns(t *testing.T) {
+	edits := Myers{}.Diff("hello world", "hello there world")
+	if len(edits) != 1 {
+		t.Fatalf("Expected a single edit, got %+v", edits)
+	}
+	if edits[0].Op != Insert || edits[0].Text != "there " && edits[0].Text != " there" {
+		t.Errorf("Expected one insert of the new word, got %+v", edits[0])
+	}
+}
+
+// changed counts the runes an edit script deletes and inserts
+func changed(edits []Edit) int {
+	n := 0
+	for _, e := range edits {
+		n += len([]rune(e.Text))
+	}
+	return n
+}
@@ -0,0 +1,97 @@
+package diff
+
+// Myers finds a shortest edit script with Myers' O(ND) algorithm, so a replaced
+// word is reported as that word deleted and the new one inserted rather than a
+// long run of unrelated changes. Only the region between the common prefix and
+// suffix is compared.
+type Myers struct{}
+
+// Diff implements Differ
+func (Myers) Diff(old, new string) []Edit {
+	a, b := []rune(old), []rune(new)
+	prefix, suffix := trim(a, b)
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	var out builder
+	for _, step := range shortestPath(a, b) {
+		if step.op == Delete {
+			out.add(Delete, prefix+step.x, a[step.x])
+		} else {
+			out.add(Insert, prefix+step.x, b[step.y])
+		}
+	}
+	return out.edits
+}
+
+// step is one rune deleted from a at x, or inserted from b at y before a[x]
+type step struct {
+	op   Op
+	x, y int
+}
+
+// shortestPath returns the deletions and insertions of a shortest edit script from a to b
+func shortestPath(a, b []rune) []step {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+
+	// v[k+offset] is the furthest x reached on diagonal k; trace keeps v for each d
+	offset := n + m
+	v := make([]int, 2*offset+2)
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[k-1+offset] < v[k+1+offset]) {
+				x = v[k+1+offset] // Down: insert from b
+			} else {
+				x = v[k-1+offset] + 1 // Right: delete from a
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[k+offset] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b, d, offset)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrack walks the saved frontiers back from the end to recover the edit script
+func backtrack(trace [][]int, a, b []rune, d, offset int) []step {
+	x, y := len(a), len(b)
+	steps := make([]step, 0, d)
+	for ; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[k-1+offset] < v[k+1+offset]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[prevK+offset]
+		prevY := prevX - prevK
+
+		// Skip the matching diagonal run
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+		}
+		if x == prevX {
+			steps = append(steps, step{op: Insert, x: x, y: prevY})
+		} else {
+			steps = append(steps, step{op: Delete, x: prevX, y: y})
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
+		steps[i], steps[j] = steps[j], steps[i]
+	}
+	return steps
+}
@@ -0,0 +1,21 @@
+package diff
+
+// PrefixSuffix replaces everything between the common prefix and suffix of the
+// two texts. It is cheap and exact for a single contiguous edit, but reports
+// several separate changes as one large replacement.
+type PrefixSuffix struct{}
+
+// Diff implements Differ
+func (PrefixSuffix) Diff(old, new string) []Edit {
+	a, b := []rune(old), []rune(new)
+	prefix, suffix := trim(a, b)
+
+	var edits []Edit
+	if removed := a[prefix : len(a)-suffix]; len(removed) > 0 {
+		edits = append(edits, Edit{Op: Delete, Offset: prefix, Text: string(removed)})
+	}
+	if added := b[prefix : len(b)-suffix]; len(added) > 0 {
+		edits = append(edits, Edit{Op: Insert, Offset: len(a) - suffix, Text: string(added)})
+	}
+	return edits
+}
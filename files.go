@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"gollaborate/crdt"
+	"gollaborate/users"
+)
+
+// loadDocument reads a text file into a document a chunk at a time
+func loadDocument(path string, nodeID int) (*crdt.Document, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return crdt.FromReader(f, nodeID)
+}
+
+// saveDocument writes a document's text to a file without building it as one string
+func saveDocument(path string, doc *crdt.Document) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := doc.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadAuthUsers reads the users a server admits, one name:token per line, or
+// name:token:role to give the user a role. Blank lines and those starting
+// with # are skipped.
+func loadAuthUsers(path string) (map[string]string, map[string]users.Role, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	tokens := make(map[string]string)
+	roles := make(map[string]users.Role)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 2 || len(fields) > 3 || fields[0] == "" || fields[1] == "" {
+			return nil, nil, fmt.Errorf("%s:%d: expected name:token or name:token:role", path, n)
+		}
+		tokens[fields[0]] = fields[1]
+		if len(fields) == 3 {
+			role, err := users.ParseRole(fields[2])
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s:%d: %w", path, n, err)
+			}
+			roles[fields[0]] = role
+		}
+	}
+	return tokens, roles, scanner.Err()
+}
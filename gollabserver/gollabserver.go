@@ -0,0 +1,146 @@
+// Package gollabserver embeds a collaboration server in another Go program:
+// describe it with a Config, create it with New, Serve it on as many listeners
+// as it should accept clients on, and Shutdown when done. Clients connect to
+// it as they would to 'gollaborate serve', which is built on this package.
+// The server package underneath has the rest of the server's API, such as
+// the admin channel, gRPC and WebSockets, reachable through Server.
+package gollabserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"gollaborate/autosave"
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/pubsub"
+	"gollaborate/server"
+	"gollaborate/shared"
+	"gollaborate/storage"
+	"gollaborate/webhook"
+)
+
+// DefaultName names the server's own document unless the config does
+const DefaultName = "untitled"
+
+// ErrAutosaveWithoutStore is returned for a config that autosaves documents
+// but gives no store to save them to
+var ErrAutosaveWithoutStore = errors.New("autosaving needs a store to save to")
+
+// Config describes a server. The zero Config is a server hosting an empty
+// document, admitting anyone, with the server package's defaults.
+type Config struct {
+	Name     string         // Of the server's own document, which clients naming none join; DefaultName if empty
+	NodeID   int            // Marks the server's own edits; a random one if zero
+	Document *crdt.Document // The server's own document, an empty one if nil
+	Logger   *slog.Logger   // Told of clients coming and going, and of errors; nothing is logged if nil
+	Codec    messages.Codec // Spoken with clients that support it, JSON if nil
+
+	Limits   crdt.Limits     // Caps on the history and tombstones documents keep
+	Store    storage.Store   // Where documents are kept between runs, if anywhere
+	Autosave autosave.Policy // When documents are saved to the store while edited
+	Auth     server.Auth     // Which clients are admitted, and with which role
+	Quotas   server.Quotas   // What each user may do per minute
+	Capacity server.Capacity // How many clients are taken at once
+	// Called as each room opens, the server's own document's included, to set
+	// up its editor state further
+	ConfigureRoom func(name string, state *shared.EditorState)
+
+	SessionGrace   time.Duration // How long a client that lost its connection may resume its session, if at all
+	DigestInterval time.Duration // How often clients are sent digests of the document; shared.DefaultDigestInterval if zero, none if negative
+	IdleAfter      time.Duration // Clients doing nothing for so long are marked idle, if set
+	EvictAfter     time.Duration // Clients doing nothing for so long are disconnected, if set
+	TemplateDir    string        // Files new documents may start from, besides the built-in templates
+
+	Webhooks    []webhook.Hook // Posted document events
+	WebhookIdle time.Duration  // How long documents go without edits before webhooks are told they are idle, if at all
+
+	Broker pubsub.Broker // Shares the server's documents with every other server using it, if set
+	Topic  string        // Servers sharing documents through the broker publish on it; server.DefaultClusterTopic if empty
+}
+
+// Server is a collaboration server embedded in a program
+type Server struct {
+	*server.Server
+}
+
+// New creates a server as the config describes, ready to Serve. The store and
+// broker it is given stay the caller's to close.
+func New(config Config) (*Server, error) {
+	if config.Autosave.Enabled() && config.Store == nil {
+		return nil, ErrAutosaveWithoutStore
+	}
+	if config.Name == "" {
+		config.Name = DefaultName
+	}
+	if config.NodeID == 0 {
+		config.NodeID = rand.Intn(999) + 1
+	}
+	if config.Document == nil {
+		config.Document = crdt.FromText("", config.NodeID)
+	}
+	if config.Topic == "" {
+		config.Topic = server.DefaultClusterTopic
+	}
+
+	srv := server.New(config.Document, config.NodeID, config.Name)
+	if config.Logger != nil {
+		srv.SetLogger(config.Logger)
+	}
+	srv.SetLimits(config.Limits)
+	if config.Store != nil {
+		srv.SetStore(config.Store)
+		srv.SetAutosave(config.Autosave)
+	}
+	srv.ConfigureRooms(func(name string, state *shared.EditorState) {
+		if config.Codec != nil {
+			state.SetCodec(config.Codec)
+		}
+		if config.ConfigureRoom != nil {
+			config.ConfigureRoom(name, state)
+		}
+	})
+	srv.SetAuth(config.Auth)
+	srv.SetQuotas(config.Quotas)
+	srv.SetCapacity(config.Capacity)
+	srv.SetSessionGrace(config.SessionGrace)
+	switch {
+	case config.DigestInterval > 0:
+		srv.SetDigestInterval(config.DigestInterval)
+	case config.DigestInterval < 0:
+		srv.SetDigestInterval(0)
+	}
+	srv.SetIdleTimeouts(config.IdleAfter, config.EvictAfter)
+	if config.TemplateDir != "" {
+		srv.SetTemplateDir(config.TemplateDir)
+	}
+	if len(config.Webhooks) > 0 {
+		srv.SetWebhooks(config.Webhooks, config.WebhookIdle)
+	}
+	if config.Broker != nil {
+		if err := srv.SetBroker(config.Broker, config.Topic); err != nil {
+			_ = srv.Close()
+			return nil, fmt.Errorf("sharing documents through the broker: %w", err)
+		}
+	}
+	return &Server{Server: srv}, nil
+}
+
+// Shutdown stops accepting clients, disconnects those connected and saves
+// every document to the store, if there is one, waiting until done or the
+// context is, whichever comes first. Shutting down carries on in the
+// background if the context ends first.
+func (s *Server) Shutdown(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() { done <- s.Close() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
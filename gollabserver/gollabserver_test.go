@@ -0,0 +1,88 @@
+package gollabserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"gollaborate/autosave"
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/storage"
+)
+
+// dial connects to the server and waits for the document it is sent
+func dial(t *testing.T, addr string) *crdt.Document {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader := messages.NewReader(conn)
+	for {
+		msg, err := reader.Receive()
+		if err != nil {
+			t.Fatalf("Expected the document: %v", err)
+		}
+		if msg.Type == messages.MessageTypeSync {
+			return msg.Document
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	store, err := storage.OpenFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	srv, err := New(Config{Name: "notes", Document: crdt.FromText("hello", 1), Store: store})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(listener) }()
+
+	if doc := dial(t, listener.Addr().String()); doc == nil || doc.ToText() != "hello" {
+		t.Errorf("Expected to be sent 'hello', got %v", doc)
+	}
+
+	// Shutting down stops serving and saves the document
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to shut down: %v", err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Expected serving to stop cleanly, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected serving to stop")
+	}
+	if doc, err := store.LoadDocument("notes"); err != nil || doc.ToText() != "hello" {
+		t.Errorf("Expected the document saved, got %v (%v)", doc, err)
+	}
+}
+
+func TestNewDefaults(t *testing.T) {
+	srv, err := New(Config{})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Close()
+	if stats := srv.Stats(); stats.Name != DefaultName || stats.Characters != 0 {
+		t.Errorf("Expected an empty %s, got %+v", DefaultName, stats)
+	}
+
+	if _, err := New(Config{Autosave: autosave.Policy{Ops: 10}}); !errors.Is(err, ErrAutosaveWithoutStore) {
+		t.Errorf("Expected autosaving without a store refused, got %v", err)
+	}
+}
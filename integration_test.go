@@ -3,11 +3,26 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"gollaborate/crash"
 	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/presence"
+	"gollaborate/replay"
+	"gollaborate/server"
 	"gollaborate/shared"
 	core "gollaborate/tui"
 )
@@ -132,6 +147,2701 @@ func TestTUIDocumentSync(t *testing.T) {
 	}
 }
 
+// Test that a retransmitted operation is applied and passed on only once
+func TestDuplicateOperationIgnored(t *testing.T) {
+	doc := crdt.FromText("ac", 1)
+	editorState := shared.NewEditorState(doc, 1)
+
+	received := make(chan *messages.Message, 2)
+	editorState.AddMessageListener(func(msg *messages.Message) {
+		received <- msg
+	})
+
+	conn, remote := net.Pipe()
+	editorState.AddConn(conn)
+
+	pos, _ := doc.GeneratePositionAt(1, 2, 2)
+	op := messages.NewInsertOperation(pos, 'b', 2, 5)
+	go func() {
+		_ = messages.SendOperation(remote, op)
+		_ = messages.SendOperation(remote, op)
+	}()
+
+	select {
+	case <-received:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for operation")
+	}
+	select {
+	case <-received:
+		t.Error("Duplicate operation should not reach listeners")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if doc.ToText() != "abc" {
+		t.Errorf("Expected 'abc', got %q", doc.ToText())
+	}
+}
+
+// Test that a broadcast reaching a peer by several paths through a mesh is
+// handled there once, and does not circle the mesh
+func TestDuplicateMessagesSuppressed(t *testing.T) {
+	var states []*shared.EditorState
+	for nodeID := 1; nodeID <= 3; nodeID++ {
+		state := shared.NewEditorState(crdt.FromText("abc", nodeID), nodeID)
+		state.SetRelay(true)
+		states = append(states, state)
+	}
+	for i, j := range []int{1, 2, 0} {
+		conn1, conn2 := net.Pipe()
+		states[i].AddConn(conn1)
+		states[j].AddConn(conn2)
+	}
+
+	if err := states[0].SendChat("hello"); err != nil {
+		t.Fatalf("SendChat: %v", err)
+	}
+	for deadline := time.Now().Add(2 * time.Second); len(states[1].Chat()) == 0 || len(states[2].Chat()) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the chat message")
+		}
+	}
+	// Give the copies relayed the long way round time to arrive
+	time.Sleep(100 * time.Millisecond)
+	for i, state := range states {
+		if chat := state.Chat(); len(chat) != 1 {
+			t.Errorf("Expected node %d to have the chat message once, got %d", i+1, len(chat))
+		}
+	}
+
+	// A peer sending the same message twice is heard once
+	conn, remote := net.Pipe()
+	states[0].AddConn(conn)
+	go func() { _, _ = io.Copy(io.Discard, remote) }()
+	msg := messages.NewChatMessage("again", time.Now(), 4, "Dave")
+	msg.Origin, msg.OriginSeq = 4, 1
+	_ = messages.SendMessage(remote, msg)
+	_ = messages.SendMessage(remote, msg)
+	for deadline := time.Now().Add(2 * time.Second); len(states[0].Chat()) < 2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the repeated message")
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if chat := states[0].Chat(); len(chat) != 2 {
+		t.Errorf("Expected the repeated message once, got %d messages", len(chat))
+	}
+}
+
+// Test that a sync merges with, rather than overwrites, local edits
+func TestSyncKeepsLocalEdits(t *testing.T) {
+	doc1 := crdt.FromText("shared", 1)
+	editorState1 := shared.NewEditorState(doc1, 1)
+
+	docBytes, _ := json.Marshal(doc1)
+	var doc2 crdt.Document
+	_ = json.Unmarshal(docBytes, &doc2)
+	editorState2 := shared.NewEditorState(&doc2, 2)
+	model2 := core.InitializeModelForTesting(editorState2, 2, "red")
+
+	// A local edit that never reaches the other node
+	model2.SetCursorPosition(7, 1)
+	model2.SimulateKeyPress("!")
+
+	received := make(chan *messages.Message, 1)
+	editorState2.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypeSync {
+			received <- msg
+		}
+	})
+
+	conn1, conn2 := net.Pipe()
+	editorState1.AddConn(conn1)
+	editorState2.AddConn(conn2)
+	go func() { _ = messages.SendSync(conn1, doc1, 1) }()
+
+	select {
+	case msg := <-received:
+		model2.SimulateNetworkMessage(msg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for sync")
+	}
+
+	if model2.GetDocumentText() != "shared!" {
+		t.Errorf("Expected local edit to survive sync, got %q", model2.GetDocumentText())
+	}
+}
+
+// Test that a sync keeps the cursor on the same text when text is added before it
+func TestSyncKeepsCursorOnText(t *testing.T) {
+	doc1 := crdt.FromText("world", 1)
+	editorState1 := shared.NewEditorState(doc1, 1)
+
+	docBytes, _ := json.Marshal(doc1)
+	var doc2 crdt.Document
+	_ = json.Unmarshal(docBytes, &doc2)
+	editorState2 := shared.NewEditorState(&doc2, 2)
+	model2 := core.InitializeModelForTesting(editorState2, 2, "red")
+
+	// Put the cursor on the 'r'
+	model2.SetCursorPosition(2, 1)
+	model2.SimulateKeyPress("right")
+
+	// Another node adds a line above without the edits reaching this one
+	for i, r := range "hi\n" {
+		pos, _ := doc1.GeneratePositionAt(1, i+1, 1)
+		_ = doc1.InsertCharacter(r, pos, 100+i)
+	}
+
+	received := make(chan *messages.Message, 1)
+	editorState2.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypeSync {
+			received <- msg
+		}
+	})
+
+	conn1, conn2 := net.Pipe()
+	editorState1.AddConn(conn1)
+	editorState2.AddConn(conn2)
+	go func() { _ = messages.SendSync(conn1, doc1, 1) }()
+
+	select {
+	case msg := <-received:
+		model2.SimulateNetworkMessage(msg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for sync")
+	}
+
+	if model2.GetDocumentText() != "hi\nworld" {
+		t.Fatalf("Expected synced text, got %q", model2.GetDocumentText())
+	}
+	if x, y := model2.GetCursorPosition(); x != 3 || y != 2 {
+		t.Errorf("Expected cursor to follow the 'r' to (3, 2), got (%d, %d)", x, y)
+	}
+}
+
+// Test that Tab indents according to the document language
+func TestTUITabIndentsByLanguage(t *testing.T) {
+	doc := crdt.FromText("", 1)
+	doc.Metadata.Language = "go"
+	editorState := shared.NewEditorState(doc, 1)
+	model := core.InitializeModelForTesting(editorState, 1, "blue")
+
+	model.SimulateKeyPress("tab")
+	model.SimulateKeyPress("x")
+	if model.GetDocumentText() != "\tx" {
+		t.Errorf("Go indent incorrect: got %q, want %q", model.GetDocumentText(), "\tx")
+	}
+
+	doc = crdt.FromText("", 1)
+	doc.Metadata.Language = "python"
+	editorState = shared.NewEditorState(doc, 1)
+	model = core.InitializeModelForTesting(editorState, 1, "blue")
+
+	model.SimulateKeyPress("tab")
+	if model.GetDocumentText() != "    " {
+		t.Errorf("Python indent incorrect: got %q, want %q", model.GetDocumentText(), "    ")
+	}
+	if x, _ := model.GetCursorPosition(); x != 5 {
+		t.Errorf("Cursor after indent incorrect: got %d, want 5", x)
+	}
+}
+
+// Test toggling line comments over a selection
+func TestTUIToggleComment(t *testing.T) {
+	doc := crdt.FromText("a\n  b", 1)
+	doc.Metadata.Language = "go"
+	editorState := shared.NewEditorState(doc, 1)
+	model := core.InitializeModelForTesting(editorState, 1, "blue")
+
+	// Select both lines and comment them
+	model.SimulateKeyPress("shift+down")
+	model.SimulateKeyPress("ctrl+/")
+	if model.GetDocumentText() != "// a\n  // b" {
+		t.Errorf("Commented text incorrect: got %q", model.GetDocumentText())
+	}
+
+	// Toggling again removes the comments
+	model.SimulateKeyPress("ctrl+/")
+	if model.GetDocumentText() != "a\n  b" {
+		t.Errorf("Uncommented text incorrect: got %q", model.GetDocumentText())
+	}
+
+	// Plain text has no line comments, so nothing changes
+	doc = crdt.FromText("notes", 1)
+	editorState = shared.NewEditorState(doc, 1)
+	model = core.InitializeModelForTesting(editorState, 1, "blue")
+	model.SimulateKeyPress("ctrl+/")
+	if model.GetDocumentText() != "notes" {
+		t.Errorf("Plain text should not be commented: got %q", model.GetDocumentText())
+	}
+}
+
+// Test cutting a selection and pasting it back
+func TestTUICutAndPaste(t *testing.T) {
+	doc := crdt.FromText("Hello\nWorld", 1)
+	editorState := shared.NewEditorState(doc, 1)
+	model := core.InitializeModelForTesting(editorState, 1, "blue")
+
+	// Select "llo\nWo" and cut it
+	model.SetCursorPosition(3, 1)
+	model.SimulateKeyPress("shift+down")
+	if model.GetDocumentText() != "Hello\nWorld" {
+		t.Fatalf("Selecting should not change text: got %q", model.GetDocumentText())
+	}
+	model.SimulateKeyPress("ctrl+x")
+	if model.GetDocumentText() != "Herld" {
+		t.Errorf("Text after cut incorrect: got %q, want %q", model.GetDocumentText(), "Herld")
+	}
+	if x, y := model.GetCursorPosition(); x != 3 || y != 1 {
+		t.Errorf("Cursor after cut incorrect: got (%d,%d), want (3,1)", x, y)
+	}
+
+	// Paste restores the cut text
+	model.SimulateKeyPress("ctrl+v")
+	if model.GetDocumentText() != "Hello\nWorld" {
+		t.Errorf("Text after paste incorrect: got %q, want %q", model.GetDocumentText(), "Hello\nWorld")
+	}
+}
+
+// Test cutting to the end of the document
+func TestTUICutToEnd(t *testing.T) {
+	doc := crdt.FromText("abc", 1)
+	editorState := shared.NewEditorState(doc, 1)
+	model := core.InitializeModelForTesting(editorState, 1, "blue")
+
+	model.SetCursorPosition(2, 1)
+	model.SimulateKeyPress("shift+right")
+	model.SimulateKeyPress("shift+right")
+	model.SimulateKeyPress("ctrl+x")
+	if model.GetDocumentText() != "a" {
+		t.Errorf("Text after cut incorrect: got %q, want %q", model.GetDocumentText(), "a")
+	}
+
+	model.SimulateKeyPress("ctrl+v")
+	model.SimulateKeyPress("ctrl+v")
+	if model.GetDocumentText() != "abcbc" {
+		t.Errorf("Text after paste incorrect: got %q, want %q", model.GetDocumentText(), "abcbc")
+	}
+}
+
+// Test that a large remote paste is announced with a banner
+func TestTUIRemotePasteBanner(t *testing.T) {
+	doc1 := crdt.FromText("top", 1)
+	editorState1 := shared.NewEditorState(doc1, 1)
+	model1 := core.InitializeModelForTesting(editorState1, 1, "blue")
+
+	docBytes, _ := json.Marshal(doc1)
+	var doc2 crdt.Document
+	_ = json.Unmarshal(docBytes, &doc2)
+	editorState2 := shared.NewEditorState(&doc2, 2)
+	model2 := core.InitializeModelForTesting(editorState2, 2, "red")
+
+	received := make(chan *messages.Message, 1)
+	editorState2.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypeTransaction {
+			received <- msg
+		}
+	})
+
+	conn1, conn2 := net.Pipe()
+	editorState1.AddConn(conn1)
+	editorState2.AddConn(conn2)
+
+	model1.SetCursorPosition(4, 1)
+	model1.SimulatePaste("\none\ntwo\nthree")
+
+	select {
+	case msg := <-received:
+		model2.SimulateNetworkMessage(msg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for pasted transaction")
+	}
+
+	if model2.GetDocumentText() != "top\none\ntwo\nthree" {
+		t.Errorf("Remote document incorrect: got %q", model2.GetDocumentText())
+	}
+	if banner := model2.GetBanner(); banner != "User-1 pasted 4 lines at line 1" {
+		t.Errorf("Banner incorrect: got %q", banner)
+	}
+
+	// The paster does not see their own banner
+	if model1.GetBanner() != "" {
+		t.Errorf("Local paste should not show a banner, got %q", model1.GetBanner())
+	}
+}
+
+// Test that a snippet shared by one participant can be pasted by another
+func TestSharedClipboard(t *testing.T) {
+	doc1 := crdt.FromText("hello world", 1)
+	editorState1 := shared.NewEditorState(doc1, 1)
+	editorState1.SetPresence(func(s *presence.State) { s.UserName = "Alice" })
+	model1 := core.InitializeModelForTesting(editorState1, 1, "blue")
+
+	editorState2 := shared.NewEditorState(crdt.FromText("", 2), 2)
+	model2 := core.InitializeModelForTesting(editorState2, 2, "red")
+	received := make(chan *messages.Message, 1)
+	editorState2.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypeClip {
+			received <- msg
+		}
+	})
+
+	conn1, conn2 := net.Pipe()
+	editorState1.AddConn(conn1)
+	editorState2.AddConn(conn2)
+
+	// Nothing is shared without a selection
+	model1.SimulateKeyPress("ctrl+y")
+	if len(editorState1.Clips()) != 0 {
+		t.Fatal("Expected nothing to be shared without a selection")
+	}
+	model1.SetCursorPosition(1, 1)
+	for range "hello" {
+		model1.SimulateKeyPress("shift+right")
+	}
+	model1.SimulateKeyPress("ctrl+y")
+
+	select {
+	case msg := <-received:
+		model2.SimulateNetworkMessage(msg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the shared snippet")
+	}
+	if banner := model2.GetBanner(); banner != "Alice shared 5 characters: Ctrl+B to paste" {
+		t.Errorf("Banner incorrect: got %q", banner)
+	}
+	if model1.GetDocumentText() != "hello world" || model2.GetDocumentText() != "" {
+		t.Errorf("Expected sharing to leave the documents alone, got %q and %q", model1.GetDocumentText(), model2.GetDocumentText())
+	}
+
+	// The snippet is pasted from the session clipboard
+	model2.SimulateKeyPress("ctrl+b")
+	if view := model2.View(); !strings.Contains(view, "Alice: hello (5 characters)") {
+		t.Errorf("Expected the snippet in the session clipboard, got:\n%s", view)
+	}
+	model2.SimulateKeyPress("enter")
+	if model2.GetDocumentText() != "hello" {
+		t.Errorf("Expected the snippet to be pasted, got %q", model2.GetDocumentText())
+	}
+
+	if err := editorState2.ShareClip(strings.Repeat("x", shared.MaxClipSize+1)); !errors.Is(err, shared.ErrClipTooLarge) {
+		t.Errorf("Expected an oversized snippet to be refused, got %v", err)
+	}
+}
+
+// Test that chat reaches the other participants, through a hub too, without
+// touching the document
+func TestChat(t *testing.T) {
+	hub := shared.NewEditorState(crdt.FromText("", 0), 0)
+	hub.SetRelay(true)
+
+	editorState1 := shared.NewEditorState(crdt.FromText("", 1), 1)
+	editorState1.SetPresence(func(s *presence.State) { s.UserName = "Alice" })
+	model1 := core.InitializeModelForTesting(editorState1, 1, "blue")
+
+	editorState2 := shared.NewEditorState(crdt.FromText("", 2), 2)
+	model2 := core.InitializeModelForTesting(editorState2, 2, "red")
+	received := make(chan *messages.Message, 1)
+	editorState2.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypeChat {
+			received <- msg
+		}
+	})
+
+	hubConn1, conn1 := net.Pipe()
+	hubConn2, conn2 := net.Pipe()
+	hub.AddConn(hubConn1)
+	hub.AddConn(hubConn2)
+	editorState1.AddConn(conn1)
+	editorState2.AddConn(conn2)
+
+	// Keys typed in the chat do not edit the document
+	model1.SimulateKeyPress("ctrl+e")
+	model1.SimulateKeyPress("lunch")
+	model1.SimulateKeyPress("?")
+	model1.SimulateKeyPress("enter")
+	if model1.GetDocumentText() != "" {
+		t.Errorf("Expected chatting to leave the document alone, got %q", model1.GetDocumentText())
+	}
+	if view := model1.View(); !strings.Contains(view, "Alice: lunch?") {
+		t.Errorf("Expected the sent message in the chat, got:\n%s", view)
+	}
+	model1.SimulateKeyPress("esc")
+
+	select {
+	case msg := <-received:
+		if msg.UserID != 1 || msg.UserName != "Alice" || msg.SentAt == 0 {
+			t.Errorf("Chat message incorrect: %+v", msg)
+		}
+		model2.SimulateNetworkMessage(msg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the chat message")
+	}
+	if banner := model2.GetBanner(); banner != "Alice: lunch?   Ctrl+E to reply" {
+		t.Errorf("Banner incorrect: got %q", banner)
+	}
+	chat := editorState2.Chat()
+	if len(chat) != 1 || chat[0].Text != "lunch?" || chat[0].UserName != "Alice" {
+		t.Errorf("Expected the message in the chat, got %+v", chat)
+	}
+
+	if err := editorState2.SendChat("  "); !errors.Is(err, shared.ErrEmptyChat) {
+		t.Errorf("Expected a blank message to be refused, got %v", err)
+	}
+	if err := editorState2.SendChat(strings.Repeat("x", shared.MaxChatLength+1)); !errors.Is(err, shared.ErrChatTooLong) {
+		t.Errorf("Expected an overlong message to be refused, got %v", err)
+	}
+}
+
+func TestAttachments(t *testing.T) {
+	hub := shared.NewEditorState(crdt.FromText("", 0), 0)
+	hub.SetRelay(true)
+
+	editorState1 := shared.NewEditorState(crdt.FromText("", 1), 1)
+	editorState1.SetPresence(func(s *presence.State) { s.UserName = "Alice" })
+	model1 := core.InitializeModelForTesting(editorState1, 1, "blue")
+
+	editorState2 := shared.NewEditorState(crdt.FromText("", 2), 2)
+	model2 := core.InitializeModelForTesting(editorState2, 2, "red")
+	chunks := make(chan *messages.Message, 16)
+	editorState2.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypeAttachmentChunk {
+			chunks <- msg
+		}
+	})
+
+	hubConn1, conn1 := net.Pipe()
+	hubConn2, conn2 := net.Pipe()
+	hub.AddConn(hubConn1)
+	hub.AddConn(hubConn2)
+	editorState1.AddConn(conn1)
+	editorState2.AddConn(conn2)
+
+	// Three and a bit chunks
+	data := bytes.Repeat([]byte("0123456789abcdef"), 3*shared.AttachmentChunkSize/16+100)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "diagram.png")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	model1.SimulateKeyPress("ctrl+e")
+	model1.SimulateKeyPress("/attach " + path)
+	model1.SimulateKeyPress("enter")
+	if view := model1.View(); !strings.Contains(view, "Shared diagram.png (97.6 KB)") {
+		t.Errorf("Expected the file shared in the status, got:\n%s", view)
+	}
+
+	var last *messages.Message
+	for i := 0; i < 4; i++ {
+		select {
+		case last = <-chunks:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for chunk %d", i)
+		}
+	}
+	files := editorState2.Attachments()
+	if len(files) != 1 || !files[0].Complete() || files[0].Name != "diagram.png" || files[0].UserName != "Alice" {
+		t.Fatalf("Expected the whole file, got %+v", files)
+	}
+	if got, err := editorState2.AttachmentData(files[0].ID); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Expected the file intact, got %d bytes (%v)", len(got), err)
+	}
+	model2.SimulateNetworkMessage(last)
+	if view := model2.View(); !strings.Contains(view, "Alice shared diagram.png: /save diagram.png to keep it") {
+		t.Errorf("Expected the file arriving in the status, got:\n%s", view)
+	}
+
+	// Saving never overwrites a file
+	saved := filepath.Join(dir, "saved.png")
+	for _, want := range []string{"Saved diagram.png to " + saved, "Save failed"} {
+		model2.SimulateKeyPress("ctrl+e")
+		model2.SimulateKeyPress("/save diagram.png " + saved)
+		model2.SimulateKeyPress("enter")
+		if view := model2.View(); !strings.Contains(view, want) {
+			t.Errorf("Expected %q in the status, got:\n%s", want, view)
+		}
+		model2.SimulateKeyPress("esc")
+	}
+	if got, err := os.ReadFile(saved); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Expected the saved file intact, got %d bytes (%v)", len(got), err)
+	}
+
+	// A peer cut off midway fetches the rest from whoever has it
+	editorState3 := shared.NewEditorState(crdt.FromText("", 3), 3)
+	sender, receiver := messages.NewPipe()
+	editorState3.AddTransport(receiver)
+	info := files[0].AttachmentInfo
+	_ = sender.Send(messages.NewAttachmentMessage(info, 1, "Alice"))
+	_ = sender.Send(messages.NewAttachmentChunkMessage(info.ID, 0, data[:info.ChunkSize], 1))
+	sender.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		files := editorState3.Attachments()
+		if len(files) == 1 && files[0].Received == int64(info.ChunkSize) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the first chunk, got %+v", files)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := editorState3.AttachmentData(info.ID); !errors.Is(err, shared.ErrAttachmentIncomplete) {
+		t.Errorf("Expected the file to be incomplete, got %v", err)
+	}
+
+	conn3, conn4 := net.Pipe()
+	editorState1.AddConn(conn3)
+	editorState3.AddConn(conn4)
+	if err := editorState3.ResumeAttachment(info.ID); err != nil {
+		t.Fatalf("ResumeAttachment: %v", err)
+	}
+	deadline = time.Now().Add(2 * time.Second)
+	for {
+		if got, err := editorState3.AttachmentData(info.ID); err == nil {
+			if !bytes.Equal(got, data) {
+				t.Errorf("Expected the resumed file intact, got %d bytes", len(got))
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out resuming, got %+v", editorState3.Attachments())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := editorState1.ShareAttachment("big", make([]byte, shared.MaxAttachmentSize+1)); !errors.Is(err, shared.ErrAttachmentTooLarge) {
+		t.Errorf("Expected an oversized file to be refused, got %v", err)
+	}
+	if err := editorState1.ResumeAttachment("missing"); !errors.Is(err, shared.ErrNoAttachment) {
+		t.Errorf("Expected an unknown file to be refused, got %v", err)
+	}
+}
+
+func TestDocMeta(t *testing.T) {
+	hostDoc := crdt.FromText("# Notes\n", 1)
+	hostDoc.Metadata.Language = "markdown"
+	host := shared.NewEditorState(hostDoc, 1)
+	host.SetTitle("notes.md")
+
+	joiner := shared.NewEditorState(crdt.FromText("", 2), 2)
+	model := core.InitializeModelForTesting(joiner, 2, "red")
+	described := make(chan *messages.Message, 8)
+	joiner.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypeDocMeta {
+			described <- msg
+		}
+	})
+	next := func() *messages.Message {
+		t.Helper()
+		select {
+		case msg := <-described:
+			model.SimulateNetworkMessage(msg)
+			return msg
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for the document description")
+			return nil
+		}
+	}
+
+	// Joiners are told what they are editing, and highlight it alike
+	conn1, conn2 := net.Pipe()
+	hostPeer := host.AddConn(conn1)
+	joiner.AddConn(conn2)
+	if err := host.SendDocMeta(hostPeer); err != nil {
+		t.Fatalf("SendDocMeta: %v", err)
+	}
+	next()
+	if meta := joiner.DocMeta(); meta.Title != "notes.md" || meta.Language != "markdown" || meta.ReadOnly || meta.SavedAt != 0 {
+		t.Errorf("Description incorrect: %+v", meta)
+	}
+	if view := model.View(); !strings.HasPrefix(view, "notes.md") || !strings.Contains(view, "markdown") {
+		t.Errorf("Expected the title bar, got:\n%s", view)
+	}
+
+	// And about changes
+	saved := time.Date(2024, 5, 1, 14, 30, 0, 0, time.Local)
+	host.MarkSaved(saved)
+	if msg := next(); msg.DocMeta.SavedAt != saved.UnixMilli() {
+		t.Errorf("Expected the save time, got %+v", msg.DocMeta)
+	}
+	host.SetReadOnly(true)
+	next()
+	if meta := joiner.DocMeta(); !meta.ReadOnly || meta.SavedAt != saved.UnixMilli() {
+		t.Errorf("Expected a locked document, got %+v", meta)
+	}
+	if view := model.View(); !strings.Contains(view, "markdown · read-only · saved 14:30") || !strings.Contains(view, "The document is now read-only") {
+		t.Errorf("Expected the lock in the title bar, got:\n%s", view)
+	}
+
+	// Unchanged descriptions are not passed on
+	host.SetReadOnly(true)
+	host.SetReadOnly(false)
+	if msg := next(); msg.DocMeta.ReadOnly || joiner.DocMeta().ReadOnly {
+		t.Errorf("Expected an unlocked document, got %+v", msg.DocMeta)
+	}
+}
+
+func TestEditHighlights(t *testing.T) {
+	doc1 := crdt.FromText("ab", 1)
+	editorState1 := shared.NewEditorState(doc1, 1)
+	editorState1.SetBatching(0, 0)
+	docBytes, _ := json.Marshal(doc1)
+	var doc2 crdt.Document
+	_ = json.Unmarshal(docBytes, &doc2)
+	editorState2 := shared.NewEditorState(&doc2, 2)
+	model2 := core.InitializeModelForTesting(editorState2, 2, "red")
+	received := make(chan *messages.Message, 4)
+	editorState2.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypeOperation {
+			received <- msg
+		}
+	})
+	conn1, conn2 := net.Pipe()
+	editorState1.AddConn(conn1)
+	editorState2.AddConn(conn2)
+	insert := func(offset int, char rune) {
+		t.Helper()
+		if err := editorState1.InsertAtOffset(offset, char); err != nil {
+			t.Fatalf("InsertAtOffset: %v", err)
+		}
+		select {
+		case msg := <-received:
+			model2.SimulateNetworkMessage(msg)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for the edit")
+		}
+	}
+
+	// A peer's insert is highlighted, the user's own typing is not
+	insert(1, 'x')
+	model2.SetCursorPosition(4, 1)
+	model2.SimulateKeyPress("y")
+	if text, highlighted := model2.GetDocumentText(), model2.GetEditHighlights(); text != "axby" || highlighted != "x" {
+		t.Errorf("Expected x highlighted in axby, got %q in %q", highlighted, text)
+	}
+
+	// Highlights can be turned off
+	model2.SimulateKeyPress("ctrl+w")
+	insert(0, 'z')
+	if highlighted := model2.GetEditHighlights(); highlighted != "" {
+		t.Errorf("Expected no highlights when turned off, got %q", highlighted)
+	}
+	model2.SimulateKeyPress("ctrl+w")
+
+	// And fade after a second
+	insert(0, '>')
+	if highlighted := model2.GetEditHighlights(); highlighted != ">" {
+		t.Errorf("Expected > highlighted, got %q", highlighted)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if highlighted := model2.GetEditHighlights(); highlighted != "" {
+		t.Errorf("Expected highlights to fade, got %q", highlighted)
+	}
+}
+
+func TestClassroom(t *testing.T) {
+	doc1 := crdt.FromText("x = 1", 1)
+	presenter := shared.NewEditorState(doc1, 1)
+	presenter.SetBatching(0, 0)
+	presenter.Present()
+	model1 := core.InitializeModelForTesting(presenter, 1, "blue")
+	submitted := make(chan *messages.Message, 1)
+	presenter.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypeSubmission {
+			submitted <- msg
+		}
+	})
+
+	docBytes, _ := json.Marshal(doc1)
+	var doc2 crdt.Document
+	_ = json.Unmarshal(docBytes, &doc2)
+	student := shared.NewEditorState(&doc2, 2)
+	student.SetPresence(func(s *presence.State) { s.UserName = "Bob" })
+	model2 := core.InitializeModelForTesting(student, 2, "red")
+	received := make(chan *messages.Message, 4)
+	student.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypeRoles || msg.Type == messages.MessageTypeTransaction {
+			received <- msg
+		}
+	})
+	wait := func(ch chan *messages.Message) *messages.Message {
+		t.Helper()
+		select {
+		case msg := <-ch:
+			return msg
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for a message")
+			return nil
+		}
+	}
+
+	conn1, conn2 := net.Pipe()
+	presenter.AddConn(conn1)
+	student.AddConn(conn2)
+	go func() { _ = messages.SendInit(conn2, nil, 2) }()
+	model2.SimulateNetworkMessage(wait(received))
+	if student.CanEdit() {
+		t.Fatal("Expected students to only watch")
+	}
+
+	// The student edits a private fork, which peers never see
+	model2.SetCursorPosition(6, 1)
+	model2.SimulateKeyPress("2")
+	if model2.GetDocumentText() != "x = 1" {
+		t.Fatalf("Expected the live document to be read-only, got %q", model2.GetDocumentText())
+	}
+	model2.SimulateKeyPress("ctrl+f")
+	model2.SimulateKeyPress("2")
+	if model2.GetDocumentText() != "x = 12" || student.Document().ToText() != "x = 1" {
+		t.Errorf("Expected only the fork to change, got %q and %q", model2.GetDocumentText(), student.Document().ToText())
+	}
+
+	// Submitting hands the fork in to the presenter
+	model2.SimulateKeyPress("ctrl+k")
+	msg := wait(submitted)
+	if msg.Text != "x = 12" || msg.UserName != "Bob" {
+		t.Errorf("Submission incorrect: %+v", msg)
+	}
+	if presenter.Document().ToText() != "x = 1" {
+		t.Errorf("Expected the fork's edits not to reach the presenter, got %q", presenter.Document().ToText())
+	}
+	model1.SimulateNetworkMessage(msg)
+	if banner := model1.GetBanner(); banner != "Bob handed in their work: Ctrl+K to review" {
+		t.Errorf("Banner incorrect: got %q", banner)
+	}
+	submissions := presenter.Submissions()
+	if len(submissions) != 1 || submissions[0].UserID != 2 {
+		t.Fatalf("Expected the submission to be kept, got %+v", submissions)
+	}
+
+	// The presenter reviews it and shows it to the class
+	model1.SetCursorPosition(6, 1)
+	model1.SimulateKeyPress("ctrl+k")
+	if view := model1.View(); !strings.Contains(view, "Bob (1 line(s))") || !strings.Contains(view, "x = 12") {
+		t.Errorf("Expected the submission in the list, got:\n%s", view)
+	}
+	model1.SimulateKeyPress("enter")
+	if text := model1.GetDocumentText(); text != "x = 1x = 12" {
+		t.Errorf("Expected the submission pasted, got %q", text)
+	}
+	wait(received)
+
+	// Going back to the live document shows the presenter's edits; the fork is kept
+	if model2.GetDocumentText() != "x = 12" {
+		t.Errorf("Expected the fork to be unaffected, got %q", model2.GetDocumentText())
+	}
+	model2.SimulateKeyPress("ctrl+f")
+	if model2.GetDocumentText() != "x = 1x = 12" {
+		t.Errorf("Expected the live document, got %q", model2.GetDocumentText())
+	}
+	model2.SimulateKeyPress("ctrl+f")
+	if model2.GetDocumentText() != "x = 12" {
+		t.Errorf("Expected the fork back, got %q", model2.GetDocumentText())
+	}
+}
+
+// Test that a participant renaming themselves mid-session is seen by everyone
+func TestUserInfo(t *testing.T) {
+	hub := shared.NewEditorState(crdt.FromText("", 0), 0)
+	hub.SetRelay(true)
+
+	editorState1 := shared.NewEditorState(crdt.FromText("", 1), 1)
+	editorState1.SetPresence(func(s *presence.State) { s.UserName = "Alice"; s.Color = "#0000FF" })
+	model1 := core.InitializeModelForTesting(editorState1, 1, "#0000FF")
+
+	editorState2 := shared.NewEditorState(crdt.FromText("", 2), 2)
+	model2 := core.InitializeModelForTesting(editorState2, 2, "#FF0000")
+	received := make(chan *messages.Message, 2)
+	editorState2.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypeUserInfo {
+			received <- msg
+		}
+	})
+
+	hubConn1, conn1 := net.Pipe()
+	hubConn2, conn2 := net.Pipe()
+	hubPeer1 := hub.AddConn(hubConn1)
+	hub.AddConn(hubConn2)
+	peer1 := editorState1.AddConn(conn1)
+	editorState2.AddConn(conn2)
+	editorState1.Hello(peer1)
+	waitForHello(t, hub, hubPeer1)
+
+	model1.SimulateKeyPress("ctrl+e")
+	model1.SimulateKeyPress("/name Alicia")
+	model1.SimulateKeyPress("enter")
+	model1.SimulateKeyPress("/color #00FF00")
+	model1.SimulateKeyPress("enter")
+	if len(editorState1.Chat()) != 0 {
+		t.Error("Expected commands not to be sent as chat")
+	}
+	if local := editorState1.LocalPresence(); local.UserName != "Alicia" || local.Color != "#00FF00" {
+		t.Errorf("Expected the new name and color, got %+v", local)
+	}
+
+	select {
+	case msg := <-received:
+		if msg.UserID != 1 || msg.UserName != "Alicia" {
+			t.Errorf("User info incorrect: %+v", msg)
+		}
+		model2.SimulateNetworkMessage(msg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the user info")
+	}
+	if !strings.Contains(model2.View(), "User-1 is now Alicia") {
+		t.Errorf("Expected the rename in the status line, got:\n%s", model2.View())
+	}
+
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(time.Millisecond) {
+		info, _ := hub.Peer(hubPeer1)
+		var color string
+		for _, state := range editorState2.Presence() {
+			if state.UserID == 1 {
+				color = state.Color
+			}
+		}
+		if info.UserName == "Alicia" && info.Color == "#00FF00" && color == "#00FF00" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the hub and peer to know the new name and color, got %+v and %q", info, color)
+		}
+	}
+
+	if err := editorState2.SetUserInfo(" ", ""); !errors.Is(err, shared.ErrInvalidUserName) {
+		t.Errorf("Expected a blank name to be refused, got %v", err)
+	}
+}
+
+// Test that a peer marked read-only by the originator cannot edit
+func TestReadOnlyJoiner(t *testing.T) {
+	doc1 := crdt.FromText("shared", 1)
+	editorState1 := shared.NewEditorState(doc1, 1)
+	editorState1.SetJoinerRole(messages.RoleReadOnly)
+
+	docBytes, _ := json.Marshal(doc1)
+	var doc2 crdt.Document
+	_ = json.Unmarshal(docBytes, &doc2)
+	editorState2 := shared.NewEditorState(&doc2, 2)
+	model2 := core.InitializeModelForTesting(editorState2, 2, "red")
+
+	received := make(chan *messages.Message, 1)
+	editorState2.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypeRoles {
+			received <- msg
+		}
+	})
+
+	conn1, conn2 := net.Pipe()
+	editorState1.AddConn(conn1)
+	editorState2.AddConn(conn2)
+
+	// Joining announces the peer, which gets the joiner role
+	go func() { _ = messages.SendInit(conn2, nil, 2) }()
+
+	select {
+	case msg := <-received:
+		model2.SimulateNetworkMessage(msg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for roles")
+	}
+
+	if editorState1.Role(2) != messages.RoleReadOnly {
+		t.Errorf("Originator should see user 2 as read-only, got %s", editorState1.Role(2))
+	}
+	if editorState2.CanEdit() {
+		t.Fatal("Read-only joiner should not be able to edit")
+	}
+
+	// Sender-side filtering: key presses do not change the document
+	model2.SetCursorPosition(6, 0)
+	model2.SimulateKeyPress("!")
+	if model2.GetDocumentText() != "shared" {
+		t.Errorf("Read-only edit should be ignored, got %q", model2.GetDocumentText())
+	}
+
+	// Receiver-side rejection: ops sent anyway are dropped by the originator
+	rogue, rogueRemote := net.Pipe()
+	editorState1.AddConn(rogue)
+	op := messages.NewInsertOperation([]crdt.Identifier{{Digit: 1, Node: 2}}, 'x', 2, 99)
+	go func() { _ = messages.SendOperation(rogueRemote, op) }()
+
+	_ = rogueRemote.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var reply messages.Message
+	if err := json.NewDecoder(rogueRemote).Decode(&reply); err != nil {
+		t.Fatalf("Expected an error reply: %v", err)
+	}
+	if reply.Type != messages.MessageTypeError {
+		t.Errorf("Expected an error reply, got %s", reply.Type)
+	}
+	if editorState1.Document().ToText() != "shared" {
+		t.Errorf("Originator should reject read-only ops, got %q", editorState1.Document().ToText())
+	}
+}
+
+func TestRevokeWriteAccess(t *testing.T) {
+	editorState1 := shared.NewEditorState(crdt.FromText("shared", 1), 1)
+	model1 := core.InitializeModelForTesting(editorState1, 1, "blue")
+	editorState2 := shared.NewEditorState(crdt.FromText("", 2), 2)
+	model2 := core.InitializeModelForTesting(editorState2, 2, "red")
+	changed := make(chan *messages.Message, 4)
+	editorState2.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypePermission {
+			changed <- msg
+		}
+	})
+
+	conn1, conn2 := net.Pipe()
+	editorState1.AddConn(conn1)
+	editorState2.AddConn(conn2)
+	editorState2.SetPresence(func(s *presence.State) { s.UserName = "Bob" })
+	deadline := time.Now().Add(2 * time.Second)
+	for !slices.ContainsFunc(editorState1.Presence(), func(s presence.State) bool { return s.UserName == "Bob" }) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for Bob's presence")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The host revokes Bob's write access from the chat
+	model1.SimulateKeyPress("ctrl+e")
+	model1.SimulateKeyPress("/readonly bob")
+	model1.SimulateKeyPress("enter")
+	if view := model1.View(); !strings.Contains(view, "Bob is now read-only") {
+		t.Errorf("Expected the revocation in the status, got:\n%s", view)
+	}
+	select {
+	case msg := <-changed:
+		model2.SimulateNetworkMessage(msg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the permission change")
+	}
+	if view := model2.View(); !strings.Contains(view, "User-1 revoked your write access") {
+		t.Errorf("Expected Bob told, got:\n%s", view)
+	}
+	if editorState2.CanEdit() {
+		t.Error("Expected Bob unable to edit")
+	}
+	if err := editorState2.SetPermission(2, true); !errors.Is(err, shared.ErrReadOnly) {
+		t.Errorf("Expected Bob unable to grant himself access, got %v", err)
+	}
+
+	// And grants it again
+	if err := editorState1.SetPermission(2, true); err != nil {
+		t.Fatalf("SetPermission: %v", err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the permission change")
+	}
+	if !editorState2.CanEdit() {
+		t.Error("Expected Bob able to edit again")
+	}
+}
+
+// Test stepping through the document's history in the TUI
+func TestTUIHistoryView(t *testing.T) {
+	doc := crdt.FromText("", 1)
+	doc.EnableHistory()
+	editorState := shared.NewEditorState(doc, 1)
+	model := core.InitializeModelForTesting(editorState, 1, "blue")
+
+	model.SimulateKeyPress("a")
+	model.SimulateKeyPress("b")
+	model.SimulateKeyPress("c")
+
+	model.SimulateKeyPress("ctrl+t")
+	if model.GetHistoryText() != "abc" {
+		t.Fatalf("History view should open at the latest edit, got %q", model.GetHistoryText())
+	}
+
+	model.SimulateKeyPress("left")
+	model.SimulateKeyPress("left")
+	if model.GetHistoryText() != "a" {
+		t.Errorf("Expected 'a' two edits back, got %q", model.GetHistoryText())
+	}
+
+	// Typing does not edit while viewing history
+	model.SimulateKeyPress("x")
+	if model.GetDocumentText() != "abc" {
+		t.Errorf("History view should not edit the document, got %q", model.GetDocumentText())
+	}
+
+	model.SimulateKeyPress("esc")
+	if model.GetHistoryText() != "" {
+		t.Error("Expected Esc to close the history view")
+	}
+	model.SimulateKeyPress("d")
+	if model.GetDocumentText() != "abcd" {
+		t.Errorf("Expected editing to resume, got %q", model.GetDocumentText())
+	}
+}
+
+// Test completing a word from the document and cycling through suggestions
+func TestTUIWordCompletion(t *testing.T) {
+	doc := crdt.FromText("hello help helium\n", 1)
+	doc.EnableWords()
+	editorState := shared.NewEditorState(doc, 1)
+	model := core.InitializeModelForTesting(editorState, 1, "blue")
+	model.SetCursorPosition(1, 2)
+
+	model.SimulateKeyPress("h")
+	model.SimulateKeyPress("e")
+	model.SimulateKeyPress("ctrl+space")
+	if model.GetDocumentText() != "hello help helium\nhelium" {
+		t.Fatalf("Expected first completion 'helium', got %q", model.GetDocumentText())
+	}
+
+	// Tab moves on to the next suggestion in place
+	model.SimulateKeyPress("tab")
+	if model.GetDocumentText() != "hello help helium\nhello" {
+		t.Errorf("Expected second completion 'hello', got %q", model.GetDocumentText())
+	}
+	if x, y := model.GetCursorPosition(); x != 6 || y != 2 {
+		t.Errorf("Cursor after completion incorrect: got (%d,%d), want (6,2)", x, y)
+	}
+
+	// Once the cursor moves on, Tab indents again
+	model.SimulateKeyPress(" ")
+	model.SimulateKeyPress("tab")
+	if model.GetDocumentText() != "hello help helium\nhello     " {
+		t.Errorf("Expected Tab to indent after completing, got %q", model.GetDocumentText())
+	}
+}
+
+// Test that validators can veto and transform local and remote operations
+func TestOperationValidators(t *testing.T) {
+	doc := crdt.FromText("abc", 1)
+	editorState := shared.NewEditorState(doc, 1)
+
+	// Lines may be at most five characters long, and '!' is reserved
+	editorState.AddValidator(func(op *messages.Operation) error {
+		if op.Type == messages.OperationTypeInsert && op.Character != '\n' {
+			if line, _ := doc.Locate(op.Position); len(doc.Lines[line-1].Characters) >= 5 {
+				return errors.New("line too long")
+			}
+		}
+		return nil
+	})
+	editorState.AddValidator(func(op *messages.Operation) error {
+		if op.Character == '!' {
+			op.Character = '.'
+		}
+		return nil
+	})
+
+	model := core.InitializeModelForTesting(editorState, 1, "blue")
+	model.SetCursorPosition(4, 1)
+	for _, key := range []string{"d", "!", "e", "f"} {
+		model.SimulateKeyPress(key)
+	}
+	if model.GetDocumentText() != "abcd." {
+		t.Errorf("Expected local edits to be validated to 'abcd.', got %q", model.GetDocumentText())
+	}
+	if x, _ := model.GetCursorPosition(); x != 6 {
+		t.Errorf("Rejected inserts should not move the cursor, got column %d", x)
+	}
+
+	pos, _ := doc.GeneratePositionAt(1, 6, 1)
+	if err := editorState.InsertCharacter('g', pos); !errors.Is(err, shared.ErrRejected) {
+		t.Errorf("Expected ErrRejected from InsertCharacter, got %v", err)
+	}
+
+	// A remote transaction with one rejected operation is rejected as a whole
+	conn, remote := net.Pipe()
+	editorState.AddConn(conn)
+	newline, _ := doc.GeneratePositionAt(1, 6, 2)
+	ops := []*messages.Operation{
+		messages.NewInsertOperation(newline, '\n', 2, 10), // Allowed
+		messages.NewInsertOperation(pos, 'x', 2, 11),      // Line too long
+	}
+	go func() { _ = messages.SendTransaction(remote, ops, messages.TransactionActionEdit, 2, "") }()
+
+	_ = remote.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var reply messages.Message
+	if err := json.NewDecoder(remote).Decode(&reply); err != nil {
+		t.Fatalf("Expected an error reply: %v", err)
+	}
+	if reply.Type != messages.MessageTypeError {
+		t.Errorf("Expected an error reply, got %s", reply.Type)
+	}
+	if doc.ToText() != "abcd." {
+		t.Errorf("Rejected transaction should not be applied, got %q", doc.ToText())
+	}
+}
+
+// Test that malformed and invalid messages are refused with a coded error,
+// without dropping the peer
+func TestInvalidMessagesRefused(t *testing.T) {
+	doc := crdt.FromText("abc", 1)
+	editorState := shared.NewEditorState(doc, 1)
+	errs := make(chan error, 2)
+	editorState.SetErrorHandler(func(conn messages.Transport, err error) { errs <- err })
+	received := make(chan *messages.Message, 1)
+	editorState.AddMessageListener(func(msg *messages.Message) {
+		received <- msg
+	})
+	conn, remote := net.Pipe()
+	editorState.AddConn(conn)
+
+	pos, _ := doc.GeneratePositionAt(1, 4, 2)
+	go func() {
+		_, _ = remote.Write([]byte("{not json\n"))
+		_ = messages.SendOperation(remote, messages.NewInsertOperation(nil, 'x', 2, 1))
+		_ = messages.SendOperation(remote, messages.NewInsertOperation(pos, 'd', 2, 2))
+	}()
+
+	_ = remote.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader := messages.NewReader(remote)
+	for _, want := range []messages.ErrorCode{messages.ErrorCodeMalformed, messages.ErrorCodeInvalid} {
+		reply, err := reader.Receive()
+		if err != nil {
+			t.Fatalf("Expected an error reply: %v", err)
+		}
+		if reply.Type != messages.MessageTypeError || reply.Code != want {
+			t.Errorf("Expected a %s error, got %+v", want, reply)
+		}
+	}
+	var validationErr *messages.ValidationError
+	if err := <-errs; err == nil {
+		t.Error("Expected the malformed message to be reported")
+	}
+	if err := <-errs; !errors.As(err, &validationErr) || validationErr.Field != "operation.position" {
+		t.Errorf("Expected the missing position to be reported, got %v", err)
+	}
+
+	// The peer is still connected, and its valid edit applied
+	select {
+	case <-received:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the valid edit")
+	}
+	if doc.ToText() != "abcd" {
+		t.Errorf("Expected the valid edit to be applied, got %q", doc.ToText())
+	}
+}
+
+// Test that the cursor stays on its text when a peer edits earlier in the document
+func TestRemoteEditsMoveCursor(t *testing.T) {
+	doc := crdt.FromText("hello world", 1)
+	editorState := shared.NewEditorState(doc, 1)
+	model := core.InitializeModelForTesting(editorState, 1, "blue")
+	model.SetCursorPosition(7, 1) // On the 'w'
+
+	received := make(chan *messages.Message, 1)
+	editorState.AddMessageListener(func(msg *messages.Message) {
+		received <- msg
+	})
+	conn, remote := net.Pipe()
+	editorState.AddConn(conn)
+
+	// The peer types "ab" and a new line before the first character, at {1, 1}
+	a := []crdt.Identifier{{Digit: 0, Node: 2}, {Digit: 1, Node: 2}}
+	b := []crdt.Identifier{{Digit: 0, Node: 2}, {Digit: 2, Node: 2}}
+	newline := []crdt.Identifier{{Digit: 0, Node: 2}, {Digit: 3, Node: 2}}
+	ops := []*messages.Operation{
+		messages.NewInsertOperation(a, 'a', 2, 10),
+		messages.NewInsertOperation(b, 'b', 2, 11),
+		messages.NewInsertOperation(newline, '\n', 2, 12),
+	}
+	go func() {
+		_ = messages.SendTransaction(remote, ops, messages.TransactionActionEdit, 2, "")
+		_, _ = io.Copy(io.Discard, remote) // Take whatever the editor sends back
+	}()
+
+	select {
+	case msg := <-received:
+		model.SimulateNetworkMessage(msg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the peer's edit")
+	}
+
+	if x, y := model.GetCursorPosition(); x != 7 || y != 2 {
+		t.Errorf("Expected the cursor to follow 'w' to (7,2), got (%d,%d)", x, y)
+	}
+	model.SimulateKeyPress("X")
+	if model.GetDocumentText() != "ab\nhello Xworld" {
+		t.Errorf("Expected typing to land before 'w', got %q", model.GetDocumentText())
+	}
+}
+
+// Test that protected regions refuse local edits, flag peers' edits and reach peers
+func TestProtectedRegion(t *testing.T) {
+	doc := crdt.FromText("LICENSE\ncode", 1)
+	editorState := shared.NewEditorState(doc, 1)
+	editorState.Protect("header", doc.Lines[0].Characters[0].Pos, doc.Lines[0].Characters[7].Pos)
+
+	model := core.InitializeModelForTesting(editorState, 1, "blue")
+	model.SetCursorPosition(3, 1)
+	model.SimulateKeyPress("X")
+	model.SetCursorPosition(2, 2)
+	model.SimulateKeyPress("X")
+	if model.GetDocumentText() != "LICENSE\ncXode" {
+		t.Errorf("Expected only the unprotected edit to apply, got %q", model.GetDocumentText())
+	}
+
+	pos, _ := doc.GeneratePositionAt(1, 3, 1)
+	if err := editorState.InsertCharacter('Y', pos); !errors.Is(err, shared.ErrProtected) {
+		t.Errorf("Expected ErrProtected from InsertCharacter, got %v", err)
+	}
+
+	// A peer's edit inside the region is applied, but reported
+	flagged := make(chan error, 1)
+	editorState.SetErrorHandler(func(conn messages.Transport, err error) {
+		flagged <- err
+	})
+	conn, remote := net.Pipe()
+	editorState.AddConn(conn)
+	go func() {
+		_ = messages.SendTransaction(remote, []*messages.Operation{
+			messages.NewInsertOperation(pos, 'Z', 2, 10),
+		}, messages.TransactionActionEdit, 2, "")
+		_, _ = io.Copy(io.Discard, remote)
+	}()
+	select {
+	case err := <-flagged:
+		if !strings.Contains(err.Error(), "header") {
+			t.Errorf("Expected the region to be named in the report, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the protected edit to be reported")
+	}
+	if doc.ToText() != "LIZCENSE\ncXode" {
+		t.Errorf("Expected the peer's edit to apply, got %q", doc.ToText())
+	}
+
+	// A joiner picks the region up from the originator's metadata
+	joined := crdt.FromText("LICENSE\ncode", 2)
+	joinerState := shared.NewEditorState(joined, 2)
+	received := make(chan *messages.Message, 1)
+	joinerState.AddMessageListener(func(msg *messages.Message) {
+		received <- msg
+	})
+	joinerConn, originator := net.Pipe()
+	joinerState.AddConn(joinerConn)
+	go func() {
+		_ = messages.SendMessage(originator, messages.NewMetadataMessage(doc.Metadata, 1))
+		_, _ = io.Copy(io.Discard, originator)
+	}()
+	select {
+	case <-received:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the metadata")
+	}
+	if _, ok := joined.ProtectedAt(joined.Lines[0].Characters[0].Pos); !ok {
+		t.Error("Expected the joiner to learn the protected region")
+	}
+}
+
+// Test that peers' presence reaches the TUI and local cursor moves reach peers
+func TestPresence(t *testing.T) {
+	doc := crdt.FromText("hello world", 1)
+	editorState := shared.NewEditorState(doc, 1)
+	model := core.InitializeModelForTesting(editorState, 1, "#0000FF")
+
+	received := make(chan *messages.Message, 4)
+	editorState.AddMessageListener(func(msg *messages.Message) {
+		received <- msg
+	})
+	nextMessage := func() *messages.Message {
+		t.Helper()
+		select {
+		case msg := <-received:
+			return msg
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for presence")
+			return nil
+		}
+	}
+	conn, remote := net.Pipe()
+	editorState.AddConn(conn)
+
+	// Alice's cursor is on the 'w'
+	alice := presence.State{UserID: 2, UserName: "Alice", Color: "#00FF00", Cursor: doc.Lines[0].Characters[6].Pos, Clock: 1}
+	go func() { _ = messages.SendAwareness(remote, []presence.State{alice}, 2) }()
+	model.SimulateNetworkMessage(nextMessage())
+	if states := editorState.Presence(); len(states) != 1 || states[0].UserName != "Alice" {
+		t.Fatalf("Expected Alice to be present, got %v", states)
+	}
+	if !strings.Contains(model.View(), "Online: Alice") {
+		t.Error("Expected Alice to be listed as online")
+	}
+
+	// Moving the cursor tells peers where it is
+	moved := make(chan struct{})
+	go func() {
+		model.SimulateKeyPress("right")
+		close(moved)
+	}()
+	_ = remote.SetReadDeadline(time.Now().Add(2 * time.Second))
+	update, err := messages.NewReader(remote).Receive()
+	if err != nil {
+		t.Fatalf("Expected a presence update: %v", err)
+	}
+	<-moved
+	if update.Type != messages.MessageTypeAwareness || len(update.Presence) != 1 {
+		t.Fatalf("Expected a presence update, got %+v", update)
+	}
+	if line, column := doc.Locate(update.Presence[0].Cursor); line != 1 || column != 2 {
+		t.Errorf("Expected the cursor at (1,2), got (%d,%d)", line, column)
+	}
+
+	// Alice goes offline when her connection closes
+	_ = remote.Close()
+	model.SimulateNetworkMessage(nextMessage())
+	if states := editorState.Presence(); len(states) != 1 || states[0].UserID != 1 {
+		t.Errorf("Expected only the local participant to be present, got %v", states)
+	}
+	if view := model.View(); strings.Contains(view, "Online:") || !strings.Contains(view, "Alice left") {
+		t.Error("Expected Alice to be shown as having left")
+	}
+}
+
+// Test that themes without color tell peers apart by markers
+func TestNoColorTheme(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	if core.DefaultTheme() != core.ThemeNoColor {
+		t.Error("Expected NO_COLOR to select the no-color theme")
+	}
+	core.SetTheme(core.ThemeNoColor)
+	defer core.SetTheme(core.ThemeColor)
+
+	doc := crdt.FromText("hello world", 1)
+	editorState := shared.NewEditorState(doc, 1)
+	model := core.InitializeModelForTesting(editorState, 1, "#0000FF")
+
+	received := make(chan *messages.Message, 1)
+	editorState.AddMessageListener(func(msg *messages.Message) {
+		received <- msg
+	})
+	conn, remote := net.Pipe()
+	editorState.AddConn(conn)
+
+	// Alice is on the 'w' and Anna, whose initial is taken, at the end of the line
+	states := []presence.State{
+		{UserID: 2, UserName: "Alice", Cursor: doc.Lines[0].Characters[6].Pos, Clock: 1},
+		{UserID: 3, UserName: "Anna", Clock: 1},
+	}
+	go func() {
+		_ = messages.SendAwareness(remote, states, 2)
+		_, _ = io.Copy(io.Discard, remote)
+	}()
+	select {
+	case msg := <-received:
+		model.SimulateNetworkMessage(msg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for presence")
+	}
+
+	view := model.View()
+	if !strings.Contains(view, "hello Aworld*") {
+		t.Errorf("Expected markers at both cursors, got:\n%s", view)
+	}
+	if !strings.Contains(view, "Online: Alice (A), Anna (*)") {
+		t.Errorf("Expected the markers to be listed with the names, got:\n%s", view)
+	}
+}
+
+// Test that edits overtake presence waiting to be sent to a slow peer, and that
+// presence waiting is merged down to the latest
+func TestEditsOvertakePresence(t *testing.T) {
+	doc := crdt.FromText("abc", 1)
+	editorState := shared.NewEditorState(doc, 1)
+	// Queue the edit at once rather than after the presence it should overtake
+	editorState.SetBatching(0, 0)
+	conn, remote := net.Pipe()
+	editorState.AddConn(conn)
+
+	// The peer is not reading yet, so everything queues up
+	for i := 0; i < 100; i++ {
+		editorState.SetPresence(func(s *presence.State) {
+			s.UserName = fmt.Sprintf("move %d", i)
+		})
+	}
+	pos, _ := doc.GeneratePositionAt(1, 4, 1)
+	if err := editorState.InsertCharacter('d', pos); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	_ = remote.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader := messages.NewReader(remote)
+	var order []messages.MessageType
+	var last presence.State
+	for last.UserName != "move 99" || !slices.Contains(order, messages.MessageTypeOperation) {
+		msg, err := reader.Receive()
+		if err != nil {
+			t.Fatalf("Failed to receive message %d: %v", len(order)+1, err)
+		}
+		order = append(order, msg.Type)
+		if msg.Type == messages.MessageTypeAwareness {
+			last = msg.Presence[0]
+		}
+	}
+
+	// At most the presence update already being written goes before the edit
+	if order[0] != messages.MessageTypeOperation && order[1] != messages.MessageTypeOperation {
+		t.Errorf("Expected the edit to overtake queued presence, got %v", order)
+	}
+	// The presence being written when the peer stalled, the edit, and the latest
+	if len(order) > 3 {
+		t.Errorf("Expected queued presence updates to be merged, got %v", order)
+	}
+}
+
+// Test that a peer not reading holds up no one else, and that edits queued
+// while a peer is written to go out together
+func TestSlowPeer(t *testing.T) {
+	doc := crdt.FromText("", 1)
+	editorState1 := shared.NewEditorState(doc, 1)
+	editorState1.SetBatching(0, 0)
+	editorState2 := shared.NewEditorState(crdt.FromText("", 2), 2)
+	const edits = 50
+	received := make(chan struct{}, edits)
+	editorState2.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypeOperation {
+			received <- struct{}{}
+		}
+	})
+
+	// Nobody reads from the stalled peer
+	stalled, stalledRemote := net.Pipe()
+	defer stalledRemote.Close()
+	editorState1.AddConn(stalled)
+	conn1, conn2 := net.Pipe()
+	gate := &gatedConn{Conn: conn1, gate: make(chan struct{})}
+	editorState1.AddConn(gate)
+	editorState2.AddConn(conn2)
+
+	for i := 0; i < edits; i++ {
+		if err := editorState1.InsertAtOffset(i, 'a'); err != nil {
+			t.Fatalf("InsertAtOffset: %v", err)
+		}
+	}
+	close(gate.gate)
+
+	for i := 0; i < edits; i++ {
+		select {
+		case <-received:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected the healthy peer to get every edit, got %d", i)
+		}
+	}
+	if text := editorState2.Document().ToText(); text != strings.Repeat("a", edits) {
+		t.Errorf("Expected the healthy peer to see the edits, got %q", text)
+	}
+	// The first edit was being written when the rest were queued
+	if n := gate.writes.Load(); n > 3 {
+		t.Errorf("Expected the queued edits to be written together, got %d writes", n)
+	}
+}
+
+// Test that a peer too slow for its edits is dropped, or its edits are, and
+// that a stalled write gives up after the write timeout
+func TestBoundedSendQueue(t *testing.T) {
+	stall := func(editorState *shared.EditorState) chan error {
+		errs := make(chan error, 16)
+		editorState.SetErrorHandler(func(conn messages.Transport, err error) { errs <- err })
+		conn, remote := net.Pipe()
+		t.Cleanup(func() { remote.Close() })
+		editorState.AddConn(conn)
+		return errs
+	}
+	edit := func(editorState *shared.EditorState, n int) {
+		for i := 0; i < n; i++ {
+			if err := editorState.InsertAtOffset(0, 'a'); err != nil {
+				t.Fatalf("InsertAtOffset: %v", err)
+			}
+		}
+	}
+
+	// Past the limit the peer is dropped
+	editorState := shared.NewEditorState(crdt.FromText("", 1), 1)
+	editorState.SetBatching(0, 0)
+	editorState.SetWriteTimeout(0)
+	editorState.SetSendQueueLimit(5, shared.OverflowDisconnect)
+	errs := stall(editorState)
+	edit(editorState, 10)
+	select {
+	case err := <-errs:
+		if !errors.Is(err, shared.ErrSlowPeer) {
+			t.Errorf("Expected the peer to be too slow, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the slow peer to be dropped")
+	}
+	for deadline := time.Now().Add(2 * time.Second); len(editorState.Connections()) > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the slow peer to be disconnected")
+		}
+	}
+
+	// Or its edits are
+	editorState = shared.NewEditorState(crdt.FromText("", 1), 1)
+	editorState.SetBatching(0, 0)
+	editorState.SetWriteTimeout(0)
+	editorState.SetSendQueueLimit(5, shared.OverflowDrop)
+	errs = stall(editorState)
+	edit(editorState, 10)
+	time.Sleep(50 * time.Millisecond)
+	if n := len(editorState.Connections()); n != 1 {
+		t.Errorf("Expected the slow peer to stay, got %d connections", n)
+	}
+	if len(errs) > 0 {
+		t.Errorf("Expected no errors dropping edits, got %v", <-errs)
+	}
+
+	// A write the peer never takes times out
+	editorState = shared.NewEditorState(crdt.FromText("", 1), 1)
+	editorState.SetBatching(0, 0)
+	editorState.SetWriteTimeout(50 * time.Millisecond)
+	errs = stall(editorState)
+	edit(editorState, 1)
+	select {
+	case err := <-errs:
+		if !errors.Is(err, shared.ErrSlowPeer) {
+			t.Errorf("Expected the stalled write to time out, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the stalled write to time out")
+	}
+}
+
+// Test that presence is sent to a peer at the set rate, latest first
+func TestPresenceRate(t *testing.T) {
+	editorState := shared.NewEditorState(crdt.FromText("abc", 1), 1)
+	editorState.SetPresenceRate(10)
+	conn, remote := net.Pipe()
+	editorState.AddConn(conn)
+	received := make(chan presence.State, 100)
+	go func() {
+		reader := messages.NewReader(remote)
+		for {
+			msg, err := reader.Receive()
+			if err != nil {
+				return
+			}
+			if msg.Type == messages.MessageTypeAwareness {
+				received <- msg.Presence[0]
+			}
+		}
+	}()
+
+	// A cursor moving every couple of milliseconds for a third of a second
+	start := time.Now()
+	moves := 0
+	for time.Since(start) < 300*time.Millisecond {
+		editorState.SetPresence(func(s *presence.State) { s.UserName = fmt.Sprintf("move %d", moves) })
+		moves++
+		time.Sleep(2 * time.Millisecond)
+	}
+	latest := fmt.Sprintf("move %d", moves-1)
+
+	sent := 0
+	timeout := time.After(2 * time.Second)
+	for state := (presence.State{}); state.UserName != latest; sent++ {
+		select {
+		case state = <-received:
+		case <-timeout:
+			t.Fatalf("Timed out waiting for %q", latest)
+		}
+	}
+	// One at once, then one every 100ms, and the latest once the cursor stops
+	if sent > 6 {
+		t.Errorf("Expected at most 6 presence messages for %d moves, got %d", moves, sent)
+	}
+}
+
+// Test that probes measure the round trip to each peer
+func TestLatencyProbe(t *testing.T) {
+	editorState1 := shared.NewEditorState(crdt.FromText("abc", 1), 1)
+	editorState2 := shared.NewEditorState(crdt.FromText("abc", 2), 2)
+	conn1, conn2 := net.Pipe()
+	editorState1.AddConn(conn1)
+	editorState2.AddConn(conn2)
+
+	// A peer that reads but never answers
+	silent, remote := net.Pipe()
+	editorState1.AddConn(silent)
+	go func() { _, _ = io.Copy(io.Discard, remote) }()
+
+	results := editorState1.Probe(200 * time.Millisecond)
+	if len(results) != 2 {
+		t.Fatalf("Expected a result per connection, got %d", len(results))
+	}
+	if r := results[0]; r.Err != nil || r.UserID != 2 || r.RTT <= 0 {
+		t.Errorf("Expected user 2 to answer, got %+v", r)
+	}
+	if r := results[1]; !errors.Is(r.Err, shared.ErrNoAnswer) || r.UserID != 0 {
+		t.Errorf("Expected no answer from the silent peer, got %+v", r)
+	}
+}
+
+// Test that peers preferring protobuf agree on it and edits still arrive
+func TestNegotiatedCodec(t *testing.T) {
+	editorState1 := shared.NewEditorState(crdt.FromText("abc", 1), 1)
+	editorState2 := shared.NewEditorState(crdt.FromText("abc", 2), 2)
+	editorState1.SetCodec(messages.Protobuf)
+	editorState2.SetCodec(messages.Protobuf)
+
+	received := make(chan *messages.Message, 1)
+	editorState2.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypeOperation {
+			received <- msg
+		}
+	})
+	conn1, conn2 := net.Pipe()
+	recorder := &recordingConn{Conn: conn1}
+	peer1 := editorState1.AddConn(recorder)
+	editorState2.AddConn(conn2)
+	editorState1.Hello(peer1)
+
+	// The peer's hello arrives before its answer to the probe
+	if r := editorState1.Probe(2 * time.Second); r[0].Err != nil {
+		t.Fatalf("Expected the peer to answer, got %v", r[0].Err)
+	}
+
+	pos, _ := editorState1.Document().GeneratePositionAt(1, 4, 1)
+	if err := editorState1.InsertCharacter('d', pos); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	select {
+	case msg := <-received:
+		if msg.Operation.Character != 'd' {
+			t.Errorf("Expected to receive 'd', got %q", msg.Operation.Character)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the edit")
+	}
+
+	frames := recorder.Frames()
+	if first := frames[0]; first[0] != '{' {
+		t.Errorf("Expected the hello to be sent as JSON, got %q", first)
+	}
+	if last := frames[len(frames)-1]; last[0] != messages.Protobuf.Tag() {
+		t.Errorf("Expected the edit to be sent as protobuf, got %q", last)
+	}
+}
+
+// Test that two documents are edited together over a single connection, each
+// on its own channel
+func TestMultiplexedDocuments(t *testing.T) {
+	conn1, conn2 := net.Pipe()
+	mux1 := messages.NewMux(messages.NewConnTransport(conn1))
+	mux2 := messages.NewMux(messages.NewConnTransport(conn2))
+	defer mux1.Close()
+
+	var received []chan struct{}
+	var remotes []*shared.EditorState
+	for channel, char := range []rune{'a', 'b'} {
+		local := shared.NewEditorState(crdt.FromText("", 1), 1)
+		remote := shared.NewEditorState(crdt.FromText("", 2), 2)
+		ch := make(chan struct{}, 1)
+		remote.AddMessageListener(func(msg *messages.Message) {
+			if msg.Type == messages.MessageTypeOperation {
+				ch <- struct{}{}
+			}
+		})
+		local.AddTransport(mux1.Channel(channel))
+		remote.AddTransport(mux2.Channel(channel))
+		if err := local.InsertAtOffset(0, char); err != nil {
+			t.Fatalf("InsertAtOffset: %v", err)
+		}
+		received = append(received, ch)
+		remotes = append(remotes, remote)
+	}
+
+	for i, want := range []string{"a", "b"} {
+		select {
+		case <-received[i]:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for the edit to %q", want)
+		}
+		if text := remotes[i].Document().ToText(); text != want {
+			t.Errorf("Expected %q on channel %d, got %q", want, i, text)
+		}
+	}
+}
+
+// Test that peers introduce themselves and refuse peers with no version in common
+func TestHello(t *testing.T) {
+	editorState1 := shared.NewEditorState(crdt.FromText("abc", 1), 1)
+	editorState2 := shared.NewEditorState(crdt.FromText("abc", 2), 2)
+	editorState1.SetPresence(func(s *presence.State) { s.UserName = "Alice" })
+	editorState2.SetPresence(func(s *presence.State) { s.UserName = "Bob"; s.Color = "32" })
+	conn1, conn2 := net.Pipe()
+	peer1 := editorState1.AddConn(conn1)
+	peer2 := editorState2.AddConn(conn2)
+	editorState1.Hello(peer1)
+
+	// Bob answers Alice's hello before her probe
+	if r := editorState1.Probe(2 * time.Second); r[0].Err != nil {
+		t.Fatalf("Expected the peer to answer, got %v", r[0].Err)
+	}
+	bob, ok := editorState1.Peer(peer1)
+	if !ok || bob.Version != messages.ProtocolVersion || bob.UserID != 2 || bob.UserName != "Bob" || bob.Color != "32" {
+		t.Errorf("Expected Bob's hello, got %+v (%v)", bob, ok)
+	}
+	if alice, ok := editorState2.Peer(peer2); !ok || alice.UserName != "Alice" {
+		t.Errorf("Expected Alice's hello, got %+v (%v)", alice, ok)
+	}
+
+	// An older peer is spoken to in its version
+	older, remote := net.Pipe()
+	carolPeer := editorState1.AddConn(older)
+	go func() { _, _ = io.Copy(io.Discard, remote) }()
+	hello := messages.NewHelloMessage(3, "Carol", "")
+	hello.Version, hello.MinVersion = 1, 1
+	helloSeen := make(chan struct{})
+	editorState1.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypeHello && msg.UserID == 3 {
+			close(helloSeen)
+		}
+	})
+	if err := messages.SendMessage(remote, hello); err != nil {
+		t.Fatalf("Failed to say hello: %v", err)
+	}
+	select {
+	case <-helloSeen:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the hello")
+	}
+	if carol, _ := editorState1.Peer(carolPeer); carol.Version != 1 {
+		t.Errorf("Expected to agree on version 1, got %+v", carol)
+	}
+
+	// A peer too new to talk to is told why and disconnected
+	errs := make(chan error, 1)
+	editorState1.SetErrorHandler(func(conn messages.Transport, err error) { errs <- err })
+	newer, newerRemote := net.Pipe()
+	editorState1.AddConn(newer)
+	hello = messages.NewHelloMessage(4, "Dave", "")
+	hello.Version, hello.MinVersion = messages.ProtocolVersion+2, messages.ProtocolVersion+1
+	go func() { _ = messages.SendMessage(newerRemote, hello) }()
+
+	_ = newerRemote.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader := messages.NewReader(newerRemote)
+	var types []messages.MessageType
+	for {
+		msg, err := reader.Receive()
+		if err != nil {
+			break
+		}
+		types = append(types, msg.Type)
+	}
+	if len(types) != 2 || types[0] != messages.MessageTypeHello || types[1] != messages.MessageTypeError {
+		t.Errorf("Expected a hello and an error before the connection closed, got %v", types)
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, shared.ErrIncompatible) {
+			t.Errorf("Expected an incompatible version, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the refusal to be reported")
+	}
+}
+
+// Test that peers which stop answering pings are dropped
+func TestHeartbeat(t *testing.T) {
+	editorState1 := shared.NewEditorState(crdt.FromText("abc", 1), 1)
+	editorState2 := shared.NewEditorState(crdt.FromText("abc", 2), 2)
+	errs := make(chan error, 4)
+	editorState1.SetErrorHandler(func(conn messages.Transport, err error) { errs <- err })
+	conn1, conn2 := net.Pipe()
+	peer1 := editorState1.AddConn(conn1)
+	peer2 := editorState2.AddConn(conn2)
+	editorState2.Hello(peer2)
+
+	// A peer that said hello, and so should answer pings, but went quiet
+	dead, remote := net.Pipe()
+	deadPeer := editorState1.AddConn(dead)
+	go func() {
+		_ = messages.SendMessage(remote, messages.NewHelloMessage(3, "Carol", ""))
+		_, _ = io.Copy(io.Discard, remote)
+	}()
+
+	start := time.Now()
+	editorState1.EnableHeartbeat(20*time.Millisecond, 3)
+	defer editorState1.DisableHeartbeat()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, shared.ErrPeerTimedOut) {
+			t.Fatalf("Expected the silent peer to time out, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the silent peer to be dropped")
+	}
+	if conns := editorState1.Connections(); len(conns) != 1 || conns[0] != peer1 {
+		t.Errorf("Expected only the live peer to stay connected, got %d connections", len(conns))
+	}
+	if seen, ok := editorState1.LastSeen(peer1); !ok || !seen.After(start) {
+		t.Errorf("Expected the live peer's pongs to be seen, got %v (%v)", seen, ok)
+	}
+	if _, ok := editorState1.LastSeen(deadPeer); ok {
+		t.Error("Expected the dropped peer to be forgotten")
+	}
+}
+
+// Test that edits are numbered, retransmitted until acknowledged and delivered
+// once, in order
+func TestSequencedEdits(t *testing.T) {
+	editorState := shared.NewEditorState(crdt.FromText("ac", 1), 1)
+	editorState.SetRetransmitTimeout(40 * time.Millisecond)
+	conn, remote := net.Pipe()
+	peer := editorState.AddConn(conn)
+
+	received := make(chan *messages.Message, 16)
+	go func() {
+		reader := messages.NewReader(remote)
+		for {
+			msg, err := reader.Receive()
+			if err != nil {
+				return
+			}
+			received <- msg
+		}
+	}()
+	// Sends from the peer, which must not block on the pipe
+	send := func(msg *messages.Message) {
+		go func() { _ = messages.SendMessage(remote, msg) }()
+	}
+	next := func(msgType messages.MessageType) *messages.Message {
+		t.Helper()
+		for {
+			select {
+			case msg := <-received:
+				if msg.Type == msgType {
+					return msg
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("Timed out waiting for a %s message", msgType)
+			}
+		}
+	}
+
+	send(messages.NewHelloMessage(2, "Bob", ""))
+	next(messages.MessageTypeHello)
+	waitForHello(t, editorState, peer)
+
+	// An edit the peer does not acknowledge is sent again with the same number
+	pos, _ := editorState.Document().GeneratePositionAt(1, 3, 1)
+	if err := editorState.InsertCharacter('d', pos); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	first := next(messages.MessageTypeOperation)
+	again := next(messages.MessageTypeOperation)
+	if first.Seq != 1 || again.Seq != 1 || again.Operation.Character != 'd' {
+		t.Fatalf("Expected edit 1 to be retransmitted, got %d then %d", first.Seq, again.Seq)
+	}
+	send(messages.NewSeqAckMessage(1, 2))
+	time.Sleep(100 * time.Millisecond)
+	for len(received) > 0 {
+		if msg := <-received; msg.Type == messages.MessageTypeOperation {
+			t.Fatalf("Expected no retransmission once acknowledged, got edit %d", msg.Seq)
+		}
+	}
+
+	// Edits from the peer arrive out of order and twice, and are applied once, in order
+	b := messages.NewOperationMessage(messages.NewInsertOperation(
+		[]crdt.Identifier{{Digit: first.Operation.Position[0].Digit / 2, Node: 2}}, 'b', 2, 1))
+	b.Seq = 1
+	x := messages.NewOperationMessage(messages.NewInsertOperation(
+		[]crdt.Identifier{{Digit: first.Operation.Position[0].Digit / 2, Node: 2}, {Digit: 1, Node: 2}}, 'x', 2, 2))
+	x.Seq = 2
+	// Validators see operations as they are handled, in order
+	applied := make(chan rune, 4)
+	editorState.AddValidator(func(op *messages.Operation) error {
+		if op.UserID == 2 {
+			applied <- op.Character
+		}
+		return nil
+	})
+	for _, msg := range []*messages.Message{x, b, b} {
+		send(msg)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ack := next(messages.MessageTypeAck); ack.Seq != 2 {
+		t.Errorf("Expected both edits to be acknowledged at once, got %d", ack.Seq)
+	}
+	if ack := next(messages.MessageTypeAck); ack.Seq != 2 {
+		t.Errorf("Expected the duplicate to be acknowledged again, got %d", ack.Seq)
+	}
+	for _, want := range []rune{'b', 'x'} {
+		select {
+		case got := <-applied:
+			if got != want {
+				t.Errorf("Expected %q to be applied next, got %q", want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for %q", want)
+		}
+	}
+	select {
+	case got := <-applied:
+		t.Errorf("Expected the duplicate to be dropped, got %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Test that a panic while applying a peer's edit is reported and only drops that peer
+func TestCrashRecovery(t *testing.T) {
+	dir := t.TempDir()
+	editorState := shared.NewEditorState(crdt.FromText("abc", 1), 1)
+	reporter := crash.New(dir)
+	crashed := make(chan *crash.Panic, 1)
+	reporter.SetNotify(func(p *crash.Panic) { crashed <- p })
+	editorState.SetCrashReporter(reporter)
+	editorState.AddValidator(func(op *messages.Operation) error {
+		if op.Character == '!' {
+			panic("cannot handle '!'")
+		}
+		return nil
+	})
+
+	bad, remote := net.Pipe()
+	editorState.AddConn(bad)
+	other, otherRemote := net.Pipe()
+	otherPeer := editorState.AddConn(other)
+	go func() { _, _ = io.Copy(io.Discard, otherRemote) }()
+	go func() {
+		op := messages.NewInsertOperation([]crdt.Identifier{{Digit: 5, Node: 2}}, '!', 2, 1)
+		_ = messages.SendOperation(remote, op)
+		_, _ = io.Copy(io.Discard, remote)
+	}()
+
+	var p *crash.Panic
+	select {
+	case p = <-crashed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the crash report")
+	}
+	if p.Value != "cannot handle '!'" || !strings.HasPrefix(p.Report, dir) {
+		t.Errorf("Expected a report of the panic in %s, got %+v", dir, p)
+	}
+	if report, err := os.ReadFile(p.Report); err != nil || !strings.Contains(string(report), "handling operation message from user 2") {
+		t.Errorf("Expected the report to say what was being handled, got %q (%v)", report, err)
+	}
+
+	// The peer whose edit crashed is dropped, the others stay, and editing goes on
+	if conns := editorState.Connections(); len(conns) != 1 || conns[0] != otherPeer {
+		t.Errorf("Expected only the other peer to stay connected, got %d connections", len(conns))
+	}
+	pos, _ := editorState.Document().GeneratePositionAt(1, 4, 1)
+	if err := editorState.InsertCharacter('d', pos); err != nil || editorState.Document().ToText() != "abcd" {
+		t.Errorf("Expected editing to go on, got %q (%v)", editorState.Document().ToText(), err)
+	}
+}
+
+func TestLogging(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gollaborate.log")
+	logs, err := setupLogging(logOptions{file: path, level: "info", format: "text"})
+	if err != nil {
+		t.Fatalf("Failed to set up logging: %v", err)
+	}
+	defer core.SetLogPane(nil)
+	defer logs.Close()
+	var terminal bytes.Buffer
+	logs.terminal = &terminal
+
+	log.Printf("Listening on port 8080")
+	logs.SetTUI(true)
+	log.Printf("New connection from 127.0.0.1:5000")
+	moduleLogger("server").Debug("Synced", "peer", "127.0.0.1:5000")
+	moduleLogger("server").Warn("Client dropped", "room", "notes")
+	logs.SetTUI(false)
+
+	// Nothing reaches the terminal while the TUI is up, but the file gets everything
+	if out := terminal.String(); !strings.Contains(out, "Listening") || strings.Contains(out, "New connection") {
+		t.Errorf("Expected only the line logged before the TUI on the terminal, got %q", out)
+	}
+	if data, err := os.ReadFile(path); err != nil || !strings.Contains(string(data), "Listening") || !strings.Contains(string(data), "New connection") {
+		t.Errorf("Expected both lines in the log file, got %q (%v)", data, err)
+	}
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), `level=WARN msg="Client dropped" module=server room=notes`) || strings.Contains(string(data), "Synced") {
+		t.Errorf("Expected records at info and above, with their fields, got %q", data)
+	}
+	if _, err := setupLogging(logOptions{level: "loud"}); err == nil {
+		t.Error("Expected an unknown log level refused")
+	}
+
+	// The editor shows the lines in its log view
+	editorState := shared.NewEditorState(crdt.FromText("hello", 1), 1)
+	model := core.InitializeModelForTesting(editorState, 1, "#0000FF")
+	model.SimulateKeyPress("ctrl+l")
+	if view := model.View(); !strings.Contains(view, "New connection from 127.0.0.1:5000") {
+		t.Errorf("Expected the log view to show the diagnostics, got:\n%s", view)
+	}
+	model.SimulateKeyPress("esc")
+	if view := model.View(); !strings.Contains(view, "hello") || strings.Contains(view, "New connection") {
+		t.Errorf("Expected Esc to go back to the document, got:\n%s", view)
+	}
+
+	pane := core.NewLogPane(2)
+	fmt.Fprint(pane, "one\ntwo\nthr")
+	fmt.Fprint(pane, "ee\n")
+	if lines := pane.Lines(); len(lines) != 2 || lines[0] != "two" || lines[1] != "three" {
+		t.Errorf("Expected the pane to keep the latest two lines, got %q", lines)
+	}
+}
+
+// Test that fast typing is sent in batches to peers that read them, and one
+// operation at a time to those that do not
+func TestOperationBatching(t *testing.T) {
+	editorState := shared.NewEditorState(crdt.FromText("", 1), 1)
+	editorState.SetBatching(time.Hour, 32)
+
+	receive := func(conn net.Conn, want int) (msgs, ops int) {
+		t.Helper()
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		reader := messages.NewReader(conn)
+		for ops < want {
+			msg, err := reader.Receive()
+			if err != nil {
+				t.Fatalf("Failed to receive operations after %d: %v", ops, err)
+			}
+			switch msg.Type {
+			case messages.MessageTypeOperation:
+				msgs, ops = msgs+1, ops+1
+			case messages.MessageTypeBatch:
+				msgs, ops = msgs+1, ops+len(msg.Operations)
+			}
+		}
+		return msgs, ops
+	}
+
+	current, currentRemote := net.Pipe()
+	currentPeer := editorState.AddConn(current)
+	go func() { _ = messages.SendMessage(currentRemote, messages.NewHelloMessage(2, "Bob", "")) }()
+	waitForHello(t, editorState, currentPeer)
+	old, oldRemote := net.Pipe()
+	editorState.AddConn(old)
+
+	results := make(chan [2]int, 1)
+	go func() {
+		msgs, ops := receive(oldRemote, 40)
+		results <- [2]int{msgs, ops}
+		_, _ = io.Copy(io.Discard, oldRemote)
+	}()
+	for i := 0; i < 40; i++ {
+		pos, _ := editorState.Document().GeneratePositionAt(1, i+1, 1)
+		if err := editorState.InsertCharacter('a', pos); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+	// A full batch goes at once; anything else flushes the rest
+	editorState.BroadcastMessage(messages.NewErrorMessage("flush", 1))
+
+	if msgs, ops := receive(currentRemote, 40); msgs != 2 || ops != 40 {
+		t.Errorf("Expected 40 operations in 2 batches, got %d in %d messages", ops, msgs)
+	}
+	if got := <-results; got[0] != 40 {
+		t.Errorf("Expected an old peer to get one message per operation, got %d for %d", got[0], got[1])
+	}
+	go func() { _, _ = io.Copy(io.Discard, currentRemote) }()
+
+	// Two participants keep in sync through batches
+	other := shared.NewEditorState(crdt.FromText("", 2), 2)
+	batches := make(chan *messages.Message, 1)
+	other.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypeBatch {
+			batches <- msg
+		}
+	})
+	conn1, conn2 := net.Pipe()
+	peer1 := editorState.AddConn(conn1)
+	peer2 := other.AddConn(conn2)
+	other.Hello(peer2)
+	waitForHello(t, editorState, peer1)
+	editorState.SetBatching(20*time.Millisecond, 32)
+	for i := 0; i < 5; i++ {
+		pos, _ := editorState.Document().GeneratePositionAt(1, 41+i, 1)
+		_ = editorState.InsertCharacter('b', pos)
+	}
+	select {
+	case msg := <-batches:
+		if len(msg.Operations) != 5 || other.Document().ToText() != "bbbbb" {
+			t.Errorf("Expected one batch of the 5 edits to be applied, got %d edits and %q", len(msg.Operations), other.Document().ToText())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the batch")
+	}
+}
+
+// Test that peers announce joining, going idle, coming back and leaving
+func TestPresenceEvents(t *testing.T) {
+	alice := shared.NewEditorState(crdt.FromText("abc", 1), 1)
+	alice.SetPresence(func(s *presence.State) { s.UserName = "Alice" })
+	model := core.InitializeModelForTesting(alice, 1, "#0000FF")
+	bob := shared.NewEditorState(crdt.FromText("abc", 2), 2)
+	bob.SetPresence(func(s *presence.State) { s.UserName = "Bob" })
+	bob.SetIdleTimeout(time.Millisecond)
+
+	events := make(chan *messages.Message, 8)
+	alice.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypePresence {
+			events <- msg
+		}
+	})
+	next := func(want messages.PresenceEvent) {
+		t.Helper()
+		select {
+		case msg := <-events:
+			if msg.Event != want || msg.Presence[0].UserID != 2 {
+				t.Fatalf("Expected Bob's %s event, got %s for user %d", want, msg.Event, msg.Presence[0].UserID)
+			}
+			model.SimulateNetworkMessage(msg)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for Bob's %s event", want)
+		}
+	}
+
+	conn1, conn2 := net.Pipe()
+	alice.AddConn(conn1)
+	peer2 := bob.AddConn(conn2)
+	bob.Hello(peer2)
+	next(messages.PresenceJoin)
+	if !strings.Contains(model.View(), "Status: Bob joined") {
+		t.Errorf("Expected Bob's arrival in the status, got:\n%s", model.View())
+	}
+	// Bob's presence puts him in Alice's online list
+	bob.SetPresence(func(*presence.State) {})
+
+	time.Sleep(5 * time.Millisecond)
+	bob.RenewPresence()
+	next(messages.PresenceIdle)
+	if view := model.View(); !strings.Contains(view, "Bob is idle") || !strings.Contains(view, "Bob (idle)") {
+		t.Errorf("Expected Bob to be shown idle, got:\n%s", view)
+	}
+	bob.Active()
+	next(messages.PresenceActive)
+
+	// Leaving says so once, not again when the connection closes
+	bob.LeavePresence()
+	next(messages.PresenceLeave)
+	_ = conn2.Close()
+	select {
+	case msg := <-events:
+		t.Errorf("Expected one leave event, got another %s", msg.Event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Test that restoring an old version waits for the other editors' approval
+func TestRestoreNeedsApproval(t *testing.T) {
+	doc := crdt.FromText("hi", 1)
+	doc.EnableHistory()
+	alice := shared.NewEditorState(doc, 1)
+	alice.SetPresence(func(s *presence.State) { s.UserName = "Alice" })
+	alice.RequireApproval(messages.TransactionActionRestore, 1)
+	before := time.Now()
+	pos, _ := doc.GeneratePositionAt(1, 3, 1)
+	_ = alice.InsertCharacter('!', pos)
+
+	// Alone, Alice needs no one's approval
+	alice.SetApprovalTimeout(time.Millisecond)
+	if _, err := alice.RestoreTo(time.Now()); err != nil {
+		t.Fatalf("Expected a lone editor to restore at once, got %v", err)
+	}
+
+	bob := shared.NewEditorState(crdt.FromText("", 2), 2)
+	model := core.InitializeModelForTesting(bob, 2, "#FF0000")
+	proposals := make(chan *messages.Message, 2)
+	bob.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypeProposal {
+			proposals <- msg
+		}
+	})
+	conn1, conn2 := net.Pipe()
+	peer1 := alice.AddConn(conn1)
+	peer2 := bob.AddConn(conn2)
+	bob.Hello(peer2)
+	waitForHello(t, alice, peer1)
+	bob.SetPresence(func(s *presence.State) { s.UserName = "Bob" })
+	for deadline := time.Now().Add(2 * time.Second); len(alice.Presence()) < 2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for Bob's presence")
+		}
+	}
+
+	// No vote in time is no approval
+	if _, err := alice.RestoreTo(before); !errors.Is(err, shared.ErrNotApproved) {
+		t.Fatalf("Expected the restore to time out unapproved, got %v", err)
+	}
+	<-proposals
+	alice.SetApprovalTimeout(2 * time.Second)
+
+	for _, c := range []struct {
+		key  string
+		text string
+	}{{"ctrl+n", "hi!"}, {"ctrl+o", "hi"}} {
+		result := make(chan error, 1)
+		go func() {
+			_, err := alice.RestoreTo(before)
+			result <- err
+		}()
+		select {
+		case msg := <-proposals:
+			model.SimulateNetworkMessage(msg)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for the proposal")
+		}
+		if view := model.View(); !strings.Contains(view, "Vote: Alice asks to restore the document to how it was at") {
+			t.Errorf("Expected Bob to be asked to vote, got:\n%s", view)
+		}
+		model.SimulateKeyPress(c.key)
+		if strings.Contains(model.View(), "Vote:") {
+			t.Error("Expected the vote to be answered")
+		}
+
+		err := <-result
+		if approved := c.key == "ctrl+o"; approved != (err == nil) || (!approved && !errors.Is(err, shared.ErrNotApproved)) {
+			t.Errorf("Expected %s to decide the restore, got %v", c.key, err)
+		}
+		if text := alice.Document().ToText(); text != c.text {
+			t.Errorf("Expected %q after %s, got %q", c.text, c.key, text)
+		}
+	}
+}
+
+// Test that a replayed macro reaches peers as one transaction and is undone as one
+func TestKeyboardMacros(t *testing.T) {
+	doc1 := crdt.FromText("a\nb\nc", 1)
+	editorState1 := shared.NewEditorState(doc1, 1)
+	model := core.InitializeModelForTesting(editorState1, 1, "blue")
+
+	docBytes, _ := json.Marshal(doc1)
+	var doc2 crdt.Document
+	_ = json.Unmarshal(docBytes, &doc2)
+	editorState2 := shared.NewEditorState(&doc2, 2)
+	received := make(chan *messages.Message, 16)
+	editorState2.AddMessageListener(func(msg *messages.Message) {
+		received <- msg
+	})
+	conn1, conn2 := net.Pipe()
+	editorState1.AddConn(conn1)
+	editorState2.AddConn(conn2)
+	expect := func(action messages.TransactionAction, text string) {
+		t.Helper()
+		for {
+			select {
+			case msg := <-received:
+				if msg.Type != messages.MessageTypeTransaction || msg.Action != action {
+					continue
+				}
+				if got := editorState2.Document().ToText(); got != text {
+					t.Errorf("Expected the peer to have %q after the %s, got %q", text, action, got)
+				}
+				return
+			case <-time.After(2 * time.Second):
+				t.Fatalf("Timed out waiting for the %s transaction", action)
+			}
+		}
+	}
+
+	// Record turning the first line into a list item
+	model.SetCursorPosition(1, 1)
+	model.SimulateKeyPress("ctrl+r")
+	for _, key := range []string{"-", " ", "down", "left", "left"} {
+		model.SimulateKeyPress(key)
+	}
+	model.SimulateKeyPress("ctrl+r")
+	if text := model.GetDocumentText(); text != "- a\nb\nc" {
+		t.Fatalf("Expected the recorded keys to edit as usual, got %q", text)
+	}
+
+	// Replaying twice does the rest in one transaction
+	model.SimulateKeyPress("ctrl+g")
+	model.SimulateKeyPress("2")
+	model.SimulateKeyPress("enter")
+	if text := model.GetDocumentText(); text != "- a\n- b\n- c" {
+		t.Errorf("Expected the macro replayed twice, got %q", text)
+	}
+	expect(messages.TransactionActionMacro, "- a\n- b\n- c")
+
+	model.SimulateKeyPress("ctrl+u")
+	if text := model.GetDocumentText(); text != "- a\nb\nc" {
+		t.Errorf("Expected the replay undone, got %q", text)
+	}
+	expect(messages.TransactionActionUndo, "- a\nb\nc")
+}
+
+// Test that edits addressed by offset reach peers like any other
+func TestOffsetEditing(t *testing.T) {
+	doc1 := crdt.FromText("hello world", 1)
+	editorState1 := shared.NewEditorState(doc1, 1)
+	docBytes, _ := json.Marshal(doc1)
+	var doc2 crdt.Document
+	_ = json.Unmarshal(docBytes, &doc2)
+	editorState2 := shared.NewEditorState(&doc2, 2)
+	editorState2.SetBatching(0, 0)
+	received := make(chan struct{}, 16)
+	editorState1.AddMessageListener(func(msg *messages.Message) {
+		received <- struct{}{}
+	})
+	conn1, conn2 := net.Pipe()
+	editorState1.AddConn(conn1)
+	editorState2.AddConn(conn2)
+
+	if err := editorState2.InsertAtOffset(5, ','); err != nil {
+		t.Fatalf("InsertAtOffset: %v", err)
+	}
+	if err := editorState2.DeleteAtOffset(0); err != nil {
+		t.Fatalf("DeleteAtOffset: %v", err)
+	}
+	if err := editorState2.DeleteAtOffset(100); !errors.Is(err, crdt.ErrOffsetOutOfRange) {
+		t.Errorf("Expected an offset past the end to be refused, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for the edits")
+		}
+	}
+	if text := editorState1.Document().ToText(); text != "ello, world" {
+		t.Errorf("Expected the peer to see the edits, got %q", text)
+	}
+}
+
+// Test that peers reconnecting after a brief outage exchange only the edits
+// they missed, rather than the whole document
+func TestCatchUp(t *testing.T) {
+	doc1 := crdt.FromText("hello", 1)
+	editorState1 := shared.NewEditorState(doc1, 1)
+	docBytes, _ := json.Marshal(doc1)
+	var doc2 crdt.Document
+	_ = json.Unmarshal(docBytes, &doc2)
+	editorState2 := shared.NewEditorState(&doc2, 2)
+	editorState2.SetBatching(0, 0)
+	received := make(chan *messages.Message, 64)
+	editorState1.AddMessageListener(func(msg *messages.Message) {
+		received <- msg
+	})
+	caughtUp := make(chan struct{}, 1)
+	editorState2.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypeCatchUp {
+			caughtUp <- struct{}{}
+		}
+	})
+	wait := func(msgType messages.MessageType) *messages.Message {
+		t.Helper()
+		for {
+			select {
+			case msg := <-received:
+				if msg.Type == messages.MessageTypeSync {
+					t.Fatal("Expected no full sync")
+				}
+				if msg.Type == msgType {
+					return msg
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("Timed out waiting for a %s message", msgType)
+			}
+		}
+	}
+
+	// Edits before the outage arrive as usual
+	conn1, conn2 := net.Pipe()
+	editorState1.AddConn(conn1)
+	editorState2.AddConn(conn2)
+	pos, _ := editorState2.Document().GeneratePositionAt(1, 6, 2)
+	_ = editorState2.InsertCharacter('!', pos)
+	wait(messages.MessageTypeOperation)
+	conn2.Close()
+
+	// Both sides edit while apart
+	pos, _ = editorState1.Document().GeneratePositionAt(1, 1, 1)
+	_ = editorState1.InsertCharacter('>', pos)
+	for _, r := range " world" {
+		_ = editorState2.InsertAtOffset(editorState2.Document().Len()-1, r)
+	}
+	_ = editorState2.DeleteAtOffset(0)
+
+	conn1, conn2 = net.Pipe()
+	peer1 := editorState1.AddConn(conn1)
+	peer2 := editorState2.AddConn(conn2)
+	if err := editorState1.RequestCatchUp(peer1); err != nil {
+		t.Fatalf("RequestCatchUp: %v", err)
+	}
+	// The 7 missed edits, and the last seen again as a delete may share its clock
+	if msg := wait(messages.MessageTypeCatchUp); len(msg.Operations) != 8 {
+		t.Errorf("Expected 8 edits, got %d", len(msg.Operations))
+	}
+	if err := editorState2.RequestCatchUp(peer2); err != nil {
+		t.Fatalf("RequestCatchUp: %v", err)
+	}
+	select {
+	case <-caughtUp:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the second peer to catch up")
+	}
+	if text := editorState2.Document().ToText(); text != ">ello world!" {
+		t.Errorf("Expected the second peer to catch up, got %q", text)
+	}
+	if text := editorState1.Document().ToText(); text != ">ello world!" {
+		t.Errorf("Expected the first peer to catch up, got %q", text)
+	}
+}
+
+// Test that joining peers are synced as the coordinator chooses from their hello
+func TestSyncStrategy(t *testing.T) {
+	coordinator := shared.SyncCoordinator{FullSyncSize: 10}
+	all := []messages.SyncStrategy{messages.SyncMerge, messages.SyncDelta, messages.SyncFull}
+	tests := []struct {
+		name string
+		req  shared.SyncRequest
+		want messages.SyncStrategy
+	}{
+		{"small document", shared.SyncRequest{Characters: 5, Strategies: all, PeerClocks: map[int]int{1: 3}, Clocks: map[int]int{1: 4}, HaveDelta: true}, messages.SyncFull},
+		{"new peer", shared.SyncRequest{Characters: 50, Strategies: all, Clocks: map[int]int{1: 4}, HaveDelta: true}, messages.SyncFull},
+		{"no common history", shared.SyncRequest{Characters: 50, Strategies: all, PeerClocks: map[int]int{2: 3}, Clocks: map[int]int{1: 4}, HaveDelta: true}, messages.SyncFull},
+		{"forgotten operations", shared.SyncRequest{Characters: 50, Strategies: all, PeerClocks: map[int]int{1: 3}, Clocks: map[int]int{1: 4}}, messages.SyncFull},
+		{"behind", shared.SyncRequest{Characters: 50, Strategies: all, PeerClocks: map[int]int{1: 3}, Clocks: map[int]int{1: 4}, HaveDelta: true}, messages.SyncDelta},
+		{"edited while apart", shared.SyncRequest{Characters: 50, Strategies: all, PeerClocks: map[int]int{1: 4, 2: 2}, Clocks: map[int]int{1: 4}, HaveDelta: true}, messages.SyncMerge},
+		{"old peer", shared.SyncRequest{Characters: 50, PeerClocks: map[int]int{1: 3}, Clocks: map[int]int{1: 4}, HaveDelta: true}, messages.SyncFull},
+	}
+	for _, test := range tests {
+		if got := coordinator.Choose(test.req); got != test.want {
+			t.Errorf("%s: expected %s, got %s", test.name, test.want, got)
+		}
+	}
+
+	host := shared.NewEditorState(crdt.FromText("hello", 1), 1)
+	host.SetSyncCoordinator(shared.SyncCoordinator{})
+	peer := shared.NewEditorState(crdt.FromText("", 2), 2)
+	peer.SetBatching(0, 0)
+	hostHeard := make(chan messages.MessageType, 64)
+	host.AddMessageListener(func(msg *messages.Message) { hostHeard <- msg.Type })
+	peerHeard := make(chan messages.MessageType, 64)
+	peer.AddMessageListener(func(msg *messages.Message) { peerHeard <- msg.Type })
+	wait := func(heard chan messages.MessageType, want messages.MessageType) {
+		t.Helper()
+		for {
+			select {
+			case msgType := <-heard:
+				if msgType == want {
+					return
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("Timed out waiting for a %s message", want)
+			}
+		}
+	}
+	join := func() messages.Transport {
+		t.Helper()
+		conn1, conn2 := net.Pipe()
+		hostPeer := host.AddConn(conn1)
+		peer.Hello(peer.AddConn(conn2))
+		return hostPeer
+	}
+
+	// A new peer gets the whole document
+	hostPeer := join()
+	if strategy, err := host.SyncPeer(hostPeer); err != nil || strategy != messages.SyncFull {
+		t.Fatalf("Expected a full sync, got %s (%v)", strategy, err)
+	}
+	wait(peerHeard, messages.MessageTypeSync)
+	_ = peer.InsertAtOffset(0, 'x')
+	wait(hostHeard, messages.MessageTypeOperation)
+	host.RemoveConn(hostPeer)
+
+	// Both edit while apart, and are merged when the peer joins again
+	_ = peer.InsertAtOffset(0, '!')
+	_ = host.InsertAtOffset(0, '>')
+	hostPeer = join()
+	if strategy, err := host.SyncPeer(hostPeer); err != nil || strategy != messages.SyncMerge {
+		t.Fatalf("Expected a merge, got %s (%v)", strategy, err)
+	}
+	wait(peerHeard, messages.MessageTypeCatchUp)
+	wait(hostHeard, messages.MessageTypeCatchUp)
+	if hostText, peerText := host.Document().ToText(), peer.Document().ToText(); hostText != peerText || len(hostText) != 8 {
+		t.Errorf("Expected both to have every edit, got %q and %q", hostText, peerText)
+	}
+}
+
+func TestOpLogDivergence(t *testing.T) {
+	doc1 := crdt.FromText("hello", 1)
+	editorState1 := shared.NewEditorState(doc1, 1)
+	editorState2 := shared.NewEditorState(crdt.FromText("", 2), 2)
+	editorState1.SetBatching(0, 0)
+	editorState2.SetBatching(0, 0)
+	var log1, log2 bytes.Buffer
+	editorState1.LogChanges(replay.NewOpLogger(&log1))
+	editorState2.LogChanges(replay.NewOpLogger(&log2))
+	applied := func(es *shared.EditorState) chan *messages.Message {
+		ch := make(chan *messages.Message, 4)
+		es.AddMessageListener(func(msg *messages.Message) {
+			if msg.Type == messages.MessageTypeSync || msg.Type == messages.MessageTypeOperation {
+				ch <- msg
+			}
+		})
+		return ch
+	}
+	applied1, applied2 := applied(editorState1), applied(editorState2)
+	wait := func(ch chan *messages.Message) {
+		t.Helper()
+		select {
+		case <-ch:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for a change")
+		}
+	}
+
+	// The second replica joins with a sync, then both edit
+	docBytes, _ := json.Marshal(doc1)
+	var synced crdt.Document
+	_ = json.Unmarshal(docBytes, &synced)
+	sync1, sync2 := net.Pipe()
+	editorState2.AddConn(sync2)
+	go func() { _ = messages.SendMessage(sync1, messages.NewSyncMessage(&synced, 1)) }()
+	wait(applied2)
+	sync1.Close()
+
+	conn1, conn2 := net.Pipe()
+	editorState1.AddConn(conn1)
+	editorState2.AddConn(conn2)
+	_ = editorState1.InsertAtOffset(5, '!')
+	wait(applied2)
+	_ = editorState2.InsertAtOffset(0, '>')
+	wait(applied1)
+
+	entries1, err := replay.ReadOpLog(&log1)
+	if err != nil {
+		t.Fatalf("Failed to read the first log: %v", err)
+	}
+	entries2, err := replay.ReadOpLog(&log2)
+	if err != nil {
+		t.Fatalf("Failed to read the second log: %v", err)
+	}
+	d, err := replay.CompareOpLogs(entries1, entries2)
+	if err != nil {
+		t.Fatalf("Failed to compare the logs: %v", err)
+	}
+	if d.Diverged || d.AgreedA != 3 || d.AgreedB != 4 {
+		t.Errorf("Expected the replicas to agree through both logs, got %+v", d)
+	}
+
+	// A replica that applied an edit wrongly is caught at that edit
+	entries2[len(entries2)-1].Hash = "corrupt"
+	d, _ = replay.CompareOpLogs(entries1, entries2)
+	if !d.Diverged || len(d.SuspectsB) != 1 || d.SuspectsB[0].Char != ">" {
+		t.Errorf("Expected divergence at the last edit, got %+v", d)
+	}
+}
+
+// Test that a peer whose copy of the document diverged, through edits lost
+// either way, resyncs from the host's digests
+func TestDigestResync(t *testing.T) {
+	original := crdt.FromText("hello", 1)
+	copyOf := func() *crdt.Document {
+		docBytes, _ := json.Marshal(original)
+		var doc crdt.Document
+		_ = json.Unmarshal(docBytes, &doc)
+		return &doc
+	}
+	host := shared.NewEditorState(copyOf(), 1)
+	peer := shared.NewEditorState(copyOf(), 2)
+	peer.SetBatching(0, 0)
+	// Lost edits are not sent again, so only the digests heal them
+	peer.SetRetransmitTimeout(time.Hour)
+	conn1, conn2 := net.Pipe()
+	lossy := &lossyConn{Conn: conn2}
+	hostPeer := host.AddConn(conn1)
+	peer.Hello(peer.AddConn(lossy))
+	waitForHello(t, host, hostPeer)
+	host.EnableDigests(20 * time.Millisecond)
+	defer host.DisableDigests()
+	waitForText := func(es *shared.EditorState, want string) {
+		t.Helper()
+		for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+			doc, err := es.Fork()
+			if err == nil && doc.ToText() == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %q, got %v (%v)", want, doc, err)
+			}
+		}
+	}
+
+	// The peer never heard of an edit the host made
+	diverged := copyOf()
+	pos, _ := diverged.GeneratePositionAt(1, 6, 1)
+	_ = diverged.InsertCharacter('!', pos, 9)
+	host.SetDocument(diverged)
+	waitForText(peer, "hello!")
+
+	// The host never heard of an edit the peer made
+	lossy.dropping.Store(true)
+	_ = peer.InsertAtOffset(0, '>')
+	time.Sleep(50 * time.Millisecond)
+	lossy.dropping.Store(false)
+	waitForText(host, ">hello!")
+	waitForText(peer, ">hello!")
+}
+
+// waitForHello waits until the peer has said hello and been answered
+func waitForHello(t *testing.T, editorState *shared.EditorState, peer messages.Transport) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(time.Millisecond) {
+		if _, ok := editorState.Peer(peer); ok {
+			return
+		} else if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the hello to be handled")
+		}
+	}
+}
+
+// gatedConn holds every write until its gate is closed, and counts them
+type gatedConn struct {
+	net.Conn
+	gate   chan struct{}
+	writes atomic.Int32
+}
+
+func (c *gatedConn) Write(b []byte) (int, error) {
+	<-c.gate
+	c.writes.Add(1)
+	return c.Conn.Write(b)
+}
+
+// lossyConn drops every write while dropping is set, as a network losing
+// messages would
+type lossyConn struct {
+	net.Conn
+	dropping atomic.Bool
+}
+
+func (c *lossyConn) Write(b []byte) (int, error) {
+	if c.dropping.Load() {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+// recordingConn keeps a copy of every write
+type recordingConn struct {
+	net.Conn
+	mutex  sync.Mutex
+	frames [][]byte
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	c.frames = append(c.frames, append([]byte(nil), b...))
+	c.mutex.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *recordingConn) Frames() [][]byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.frames
+}
+
 // Helper: checks if two CRDT documents are equivalent (by text content)
 func crdtDocsEquivalent(a, b *crdt.Document) bool {
 	return a.ToText() == b.ToText()
@@ -152,3 +2862,195 @@ func (m *MockConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
 func (m *MockConn) SetDeadline(t time.Time) error      { return nil }
 func (m *MockConn) SetReadDeadline(t time.Time) error  { return nil }
 func (m *MockConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// Test that the outline panel lists a document's headings as peers edit it and
+// jumps to the one chosen
+func TestOutlinePanel(t *testing.T) {
+	doc1 := crdt.FromText("# One\ntext\n## Two\nmore", 1)
+	doc1.Metadata.Language = "markdown"
+	editorState1 := shared.NewEditorState(doc1, 1)
+	editorState1.SetBatching(0, 0)
+	docBytes, _ := json.Marshal(doc1)
+	var doc2 crdt.Document
+	_ = json.Unmarshal(docBytes, &doc2)
+	editorState2 := shared.NewEditorState(&doc2, 2)
+	model2 := core.InitializeModelForTesting(editorState2, 2, "red")
+	received := make(chan *messages.Message, 4)
+	editorState2.AddMessageListener(func(msg *messages.Message) {
+		if msg.Type == messages.MessageTypeOperation {
+			received <- msg
+		}
+	})
+	conn1, conn2 := net.Pipe()
+	editorState1.AddConn(conn1)
+	editorState2.AddConn(conn2)
+
+	// Opened under the second heading, it starts there
+	model2.SetCursorPosition(2, 4)
+	model2.SimulateKeyPress("ctrl+d")
+	if view := model2.View(); !strings.Contains(view, "One") || !strings.Contains(view, "  Two") {
+		t.Fatalf("Expected both headings in the outline, got:\n%s", view)
+	}
+
+	// A heading a peer adds shows up while the panel is open
+	for i, char := range "# " {
+		if err := editorState1.InsertAtOffset(6+i, char); err != nil {
+			t.Fatalf("InsertAtOffset: %v", err)
+		}
+		select {
+		case msg := <-received:
+			model2.SimulateNetworkMessage(msg)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for the edit")
+		}
+	}
+	if text := model2.GetDocumentText(); text != "# One\n# text\n## Two\nmore" {
+		t.Fatalf("Unexpected text %q", text)
+	}
+	model2.SimulateKeyPress("up")
+	model2.SimulateKeyPress("up")
+	model2.SimulateKeyPress("down")
+	model2.SimulateKeyPress("enter")
+	if x, y := model2.GetCursorPosition(); x != 1 || y != 2 {
+		t.Errorf("Expected to jump to line 2, got line %d column %d", y, x)
+	}
+
+	// Typing goes to the document again once it is closed
+	model2.SimulateKeyPress("x")
+	if text := model2.GetDocumentText(); text != "# One\nx# text\n## Two\nmore" {
+		t.Errorf("Expected typing to edit the document after jumping, got %q", text)
+	}
+}
+
+func TestReconnectResumesSession(t *testing.T) {
+	srv := server.New(crdt.FromText("shared", 100), 100, "test")
+	srv.SetSessionGrace(time.Minute)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	editorState := shared.NewEditorState(crdt.FromText("", 7), 7)
+	link := newServerLink(editorState, messages.TCP, listener.Addr().String())
+	peer, err := link.connect()
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	go link.stayConnected(peer)
+	t.Cleanup(func() { link.setSession("") })
+
+	waitFor := func(what string, done func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !done() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("a session", func() bool { return link.currentSession() != "" })
+	session := link.currentSession()
+	dropped := srv.State().Connections()[0]
+
+	// The server losing the connection is as good as the network dropping it
+	srv.State().RemoveConn(dropped)
+	waitFor("the editor to reconnect", func() bool {
+		conns := srv.State().Connections()
+		return len(conns) == 1 && conns[0] != dropped && len(srv.Stats().Clients) == 1
+	})
+	if current := link.currentSession(); current != session {
+		t.Errorf("Expected the session resumed, got %q for %q", current, session)
+	}
+	if text := editorState.Document().ToText(); text != "shared" {
+		t.Errorf("Expected the document kept, got %q", text)
+	}
+}
+
+// Test that a client following a hub's order asks for the edits it missed and
+// logs them in the hub's order once they arrive
+func TestOrderGapRequested(t *testing.T) {
+	doc := crdt.FromText("ac", 1)
+	editorState := shared.NewEditorState(doc, 1)
+	conn, hub := net.Pipe()
+	editorState.AddConn(conn)
+	reader := messages.NewReader(hub)
+	_ = hub.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// The sync says the hub's next edit is the first
+	sync := messages.NewSyncMessage(crdt.FromText("ac", 1), 9)
+	sync.Order = 1
+	if err := messages.SendMessage(hub, sync); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	// Positions come from a copy, as the sync is merged into doc meanwhile
+	base := crdt.FromText("ac", 1)
+	first, _ := base.GeneratePositionAt(1, 2, 2)
+	second, _ := base.GeneratePositionAt(2, 3, 3)
+	edits := make([]*messages.Message, 2)
+	for i, edit := range []*messages.Operation{
+		messages.NewInsertOperation(first, 'b', 2, 5),
+		messages.NewInsertOperation(second, 'd', 3, 5),
+	} {
+		edits[i] = messages.NewOperationMessage(edit)
+		edits[i].Order = int64(i + 1)
+	}
+
+	// The second edit arriving first shows the first went missing
+	if err := messages.SendMessage(hub, edits[1]); err != nil {
+		t.Fatalf("Failed to send edit: %v", err)
+	}
+	msg, err := reader.Receive()
+	if err != nil || msg.Type != messages.MessageTypeOrderRequest || msg.Order != 1 {
+		t.Fatalf("Expected the first edit asked for, got %+v (%v)", msg, err)
+	}
+	if editorState.LastOrder() != 0 {
+		t.Errorf("Expected nothing logged past the gap, got up to %d", editorState.LastOrder())
+	}
+
+	if err := messages.SendMessage(hub, edits[0]); err != nil {
+		t.Fatalf("Failed to send edit: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for editorState.LastOrder() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected both edits logged, got up to %d", editorState.LastOrder())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ops := editorState.OrderedOps(1); len(ops) != 2 || ops[0].Character != 'b' || ops[1].Character != 'd' {
+		t.Errorf("Expected the edits logged in the hub's order, got %+v", ops)
+	}
+	if text := editorState.Document().ToText(); text != "abcd" {
+		t.Errorf("Expected 'abcd', got %q", text)
+	}
+}
+
+// Test that invite links carry the server, room and join code to --join
+func TestInviteLink(t *testing.T) {
+	link := inviteLink("docs.example.com:8080", "team notes", "K7QH-3MZP")
+	addr, room, code, ok := parseInviteLink(link)
+	if !ok || addr != "docs.example.com:8080" || room != "team notes" || code != "K7QH-3MZP" {
+		t.Errorf("Expected the invite read back from %s, got %q %q %q (%v)", link, addr, room, code, ok)
+	}
+	if _, _, _, ok := parseInviteLink("localhost:8080"); ok {
+		t.Error("Expected a plain address not taken for an invite link")
+	}
+
+	// IPv6 addresses are bracketed, and addresses without a port get the default
+	for public, want := range map[string]string{
+		"::1":                "[::1]:8080",
+		"[2001:db8::7]:9000": "[2001:db8::7]:9000",
+		"docs.example.com":   "docs.example.com:8080",
+	} {
+		link := inviteLink(public, "notes", "K7QH-3MZP")
+		if addr, _, _, ok := parseInviteLink(link); !ok || addr != want {
+			t.Errorf("Expected %s to be joined at %s, got %q from %s", public, want, addr, link)
+		}
+	}
+	if addr, _, _, _ := parseInviteLink("gollaborate://[::1]/notes?code=K7QH-3MZP"); addr != "[::1]:8080" {
+		t.Errorf("Expected the default port added, got %q", addr)
+	}
+}
@@ -0,0 +1,74 @@
+package language
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Language describes how a file type is edited
+type Language struct {
+	Name        string
+	Extensions  []string
+	LineComment string // Prefix used to comment out a line, empty if unsupported
+	Indent      string // Text inserted for one level of indentation
+}
+
+// Plain is the language used when nothing more specific is known
+var Plain = Language{Name: "text", Indent: "    "}
+
+// languages lists every known language
+var languages = []Language{
+	{Name: "go", Extensions: []string{".go"}, LineComment: "//", Indent: "\t"},
+	{Name: "python", Extensions: []string{".py"}, LineComment: "#", Indent: "    "},
+	{Name: "javascript", Extensions: []string{".js", ".mjs", ".cjs"}, LineComment: "//", Indent: "  "},
+	{Name: "typescript", Extensions: []string{".ts", ".tsx"}, LineComment: "//", Indent: "  "},
+	{Name: "rust", Extensions: []string{".rs"}, LineComment: "//", Indent: "    "},
+	{Name: "c", Extensions: []string{".c", ".h"}, LineComment: "//", Indent: "    "},
+	{Name: "cpp", Extensions: []string{".cpp", ".cc", ".hpp"}, LineComment: "//", Indent: "    "},
+	{Name: "java", Extensions: []string{".java"}, LineComment: "//", Indent: "    "},
+	{Name: "shell", Extensions: []string{".sh", ".bash"}, LineComment: "#", Indent: "  "},
+	{Name: "yaml", Extensions: []string{".yaml", ".yml"}, LineComment: "#", Indent: "  "},
+	{Name: "toml", Extensions: []string{".toml"}, LineComment: "#", Indent: "  "},
+	{Name: "sql", Extensions: []string{".sql"}, LineComment: "--", Indent: "  "},
+	{Name: "lua", Extensions: []string{".lua"}, LineComment: "--", Indent: "  "},
+	{Name: "markdown", Extensions: []string{".md", ".markdown"}, Indent: "  "},
+	{Name: "text", Extensions: []string{".txt"}, Indent: "    "},
+}
+
+// Detect returns the name of the language for a file, based on its extension
+func Detect(filename string) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == "" {
+		return Plain.Name
+	}
+	for _, lang := range languages {
+		for _, e := range lang.Extensions {
+			if e == ext {
+				return lang.Name
+			}
+		}
+	}
+	return Plain.Name
+}
+
+// Lookup returns the language with the given name, falling back to Plain
+func Lookup(name string) Language {
+	name = strings.ToLower(name)
+	for _, lang := range languages {
+		if lang.Name == name {
+			return lang
+		}
+	}
+	return Plain
+}
+
+// Known reports whether a language name is recognised
+func Known(name string) bool {
+	name = strings.ToLower(name)
+	for _, lang := range languages {
+		if lang.Name == name {
+			return true
+		}
+	}
+	return false
+}
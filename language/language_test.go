@@ -0,0 +1,55 @@
+package language
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	tests := map[string]string{
+		"main.go":          "go",
+		"script.PY":        "python",
+		"notes/README.md":  "markdown",
+		"Makefile":         "text",
+		"data.unknownext":  "text",
+		"config/app.yml":   "yaml",
+		"/tmp/query.sql":   "sql",
+		"component.tsx":    "typescript",
+		"document.txt":     "text",
+		"src/lib/mod.rs":   "rust",
+		"include/vector.h": "c",
+	}
+
+	for filename, expected := range tests {
+		if got := Detect(filename); got != expected {
+			t.Errorf("Detect(%q): expected '%s', got '%s'", filename, expected, got)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	goLang := Lookup("Go")
+	if goLang.Name != "go" {
+		t.Errorf("Expected 'go', got '%s'", goLang.Name)
+	}
+	if goLang.LineComment != "//" {
+		t.Errorf("Expected Go line comment '//', got '%s'", goLang.LineComment)
+	}
+	if goLang.Indent != "\t" {
+		t.Errorf("Expected Go to indent with a tab, got %q", goLang.Indent)
+	}
+
+	unknown := Lookup("cobol")
+	if unknown.Name != Plain.Name {
+		t.Errorf("Expected unknown language to fall back to '%s', got '%s'", Plain.Name, unknown.Name)
+	}
+	if unknown.LineComment != "" {
+		t.Errorf("Expected plain text to have no line comment, got '%s'", unknown.LineComment)
+	}
+}
+
+func TestKnown(t *testing.T) {
+	if !Known("python") {
+		t.Error("Expected python to be known")
+	}
+	if Known("cobol") {
+		t.Error("Expected cobol to be unknown")
+	}
+}
@@ -0,0 +1,125 @@
+package language
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Heading is an entry in a document's outline: a Markdown heading, or a
+// declaration such as a top-level function in code
+type Heading struct {
+	Line  int // 1-based
+	Level int // 1 for the top level
+	Title string
+}
+
+// outlineRule recognises the lines of a language that belong in its outline.
+// The pattern's "title" group is the heading's title; with a "level" group the
+// level is that group's length, as with Markdown's #s.
+type outlineRule struct {
+	pattern *regexp.Regexp
+	level   int
+}
+
+// outlineRules lists the rules for each language that has an outline. They are
+// simple patterns rather than a parser, so they only find declarations written
+// the usual way, at the start of a line.
+var outlineRules = map[string][]outlineRule{
+	"markdown": {
+		{pattern: regexp.MustCompile(`^(?P<level>#{1,6})\s+(?P<title>.*?)[\s#]*$`)},
+	},
+	"go": {
+		{pattern: regexp.MustCompile(`^func\s+(?P<title>(\([^)]*\)\s*)?\w+)`), level: 1},
+		{pattern: regexp.MustCompile(`^type\s+(?P<title>\w+)`), level: 1},
+	},
+	"python": {
+		{pattern: regexp.MustCompile(`^(async\s+)?(def|class)\s+(?P<title>\w+)`), level: 1},
+		{pattern: regexp.MustCompile(`^(    |\t)(async\s+)?def\s+(?P<title>\w+)`), level: 2},
+	},
+	"javascript": jsOutlineRules,
+	"typescript": jsOutlineRules,
+	"rust": {
+		{pattern: regexp.MustCompile(`^(pub(\([^)]*\))?\s+)?(async\s+)?(fn|struct|enum|trait|mod)\s+(?P<title>\w+)`), level: 1},
+		{pattern: regexp.MustCompile(`^impl(<[^>]*>)?\s+(?P<title>[^{]*?)\s*\{?$`), level: 1},
+		{pattern: regexp.MustCompile(`^    (pub(\([^)]*\))?\s+)?(async\s+)?fn\s+(?P<title>\w+)`), level: 2},
+	},
+	"shell": {
+		{pattern: regexp.MustCompile(`^(function\s+)?(?P<title>[\w-]+)\s*\(\)`), level: 1},
+	},
+	"lua": {
+		{pattern: regexp.MustCompile(`^(local\s+)?function\s+(?P<title>[\w.:]+)`), level: 1},
+	},
+}
+
+var jsOutlineRules = []outlineRule{
+	{pattern: regexp.MustCompile(`^(export\s+)?(default\s+)?(async\s+)?function\*?\s+(?P<title>\w+)`), level: 1},
+	{pattern: regexp.MustCompile(`^(export\s+)?(default\s+)?(abstract\s+)?(class|interface)\s+(?P<title>\w+)`), level: 1},
+}
+
+// HasOutline reports whether documents in a language have an outline
+func HasOutline(lang Language) bool {
+	return len(outlineRules[lang.Name]) > 0
+}
+
+// Outline returns the headings of a document, given its lines
+func Outline(lang Language, lines []string) []Heading {
+	return NewOutliner(lang).Outline(lines)
+}
+
+// Outliner keeps a document's outline up to date as it is edited. Each line is
+// matched against the language's rules once; lines left as they were since the
+// last outline are not read again.
+type Outliner struct {
+	rules []outlineRule
+	// What each line's text is in the outline, if anything
+	lines map[string]*Heading
+}
+
+// NewOutliner creates an Outliner for documents in a language
+func NewOutliner(lang Language) *Outliner {
+	return &Outliner{rules: outlineRules[lang.Name], lines: make(map[string]*Heading)}
+}
+
+// Outline returns the headings of the document as it is now, given its lines
+func (o *Outliner) Outline(lines []string) []Heading {
+	var headings []Heading
+	seen := make(map[string]*Heading, len(lines))
+	for i, line := range lines {
+		heading, ok := o.lines[line]
+		if !ok {
+			heading = o.match(line)
+		}
+		seen[line] = heading
+		if heading != nil {
+			h := *heading
+			h.Line = i + 1
+			headings = append(headings, h)
+		}
+	}
+	// Forget lines that were edited away, so the cache stays the document's size
+	o.lines = seen
+	return headings
+}
+
+// match returns the heading a line is, or nil
+func (o *Outliner) match(line string) *Heading {
+	for _, rule := range o.rules {
+		groups := rule.pattern.FindStringSubmatch(line)
+		if groups == nil {
+			continue
+		}
+		heading := &Heading{Level: rule.level}
+		for i, name := range rule.pattern.SubexpNames() {
+			switch name {
+			case "title":
+				heading.Title = strings.TrimSpace(groups[i])
+			case "level":
+				heading.Level = len(groups[i])
+			}
+		}
+		if heading.Title != "" {
+			return heading
+		}
+	}
+	return nil
+}
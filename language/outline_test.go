@@ -0,0 +1,80 @@
+package language
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOutline(t *testing.T) {
+	markdown := []string{
+		"# Guide",
+		"Some text",
+		"## Install ##",
+		"#not a heading",
+		"### Build",
+	}
+	expected := []Heading{
+		{Line: 1, Level: 1, Title: "Guide"},
+		{Line: 3, Level: 2, Title: "Install"},
+		{Line: 5, Level: 3, Title: "Build"},
+	}
+	if got := Outline(Lookup("markdown"), markdown); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Markdown outline: expected %v, got %v", expected, got)
+	}
+
+	code := []string{
+		"package main",
+		"type Server struct {",
+		"}",
+		"func (s *Server) Start() error {",
+		"\tfunc() {}()",
+		"}",
+		"func main() {",
+	}
+	expected = []Heading{
+		{Line: 2, Level: 1, Title: "Server"},
+		{Line: 4, Level: 1, Title: "(s *Server) Start"},
+		{Line: 7, Level: 1, Title: "main"},
+	}
+	if got := Outline(Lookup("go"), code); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Go outline: expected %v, got %v", expected, got)
+	}
+
+	python := []string{"class Shape:", "    def area(self):", "        pass", "def main():"}
+	expected = []Heading{
+		{Line: 1, Level: 1, Title: "Shape"},
+		{Line: 2, Level: 2, Title: "area"},
+		{Line: 4, Level: 1, Title: "main"},
+	}
+	if got := Outline(Lookup("python"), python); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Python outline: expected %v, got %v", expected, got)
+	}
+
+	if HasOutline(Plain) || Outline(Plain, markdown) != nil {
+		t.Error("Expected plain text to have no outline")
+	}
+}
+
+func TestOutlinerFollowsEdits(t *testing.T) {
+	o := NewOutliner(Lookup("markdown"))
+	o.Outline([]string{"# One", "text", "# Two"})
+
+	// A line inserted above moves the headings after it
+	got := o.Outline([]string{"## Zero", "# One", "text", "# Two"})
+	expected := []Heading{
+		{Line: 1, Level: 2, Title: "Zero"},
+		{Line: 2, Level: 1, Title: "One"},
+		{Line: 4, Level: 1, Title: "Two"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	if len(o.lines) != 4 {
+		t.Errorf("Expected only the current lines to be kept, got %d", len(o.lines))
+	}
+
+	got = o.Outline([]string{"# One", "text", "Two"})
+	if len(got) != 1 || got[0].Title != "One" {
+		t.Errorf("Expected a heading edited away to leave the outline, got %v", got)
+	}
+}
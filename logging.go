@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"gollaborate/logging"
+	core "gollaborate/tui"
+)
+
+// logPaneLines is how many lines of diagnostics the editor's log view keeps
+const logPaneLines = 500
+
+// logOptions are the command line settings for diagnostics
+type logOptions struct {
+	file     string
+	level    string
+	format   string
+	maxSize  int // In megabytes, zero never rotating the file
+	maxFiles int
+}
+
+// addLogFlags defines the flags for diagnostics on a flag set, their defaults
+// taken from the GOLLABORATE_LOG_* environment variables when set
+func addLogFlags(flags *flag.FlagSet, fileUsage string) *logOptions {
+	o := &logOptions{}
+	flags.StringVar(&o.file, "log-file", os.Getenv("GOLLABORATE_LOG_FILE"), fileUsage+" (or GOLLABORATE_LOG_FILE)")
+	flags.StringVar(&o.level, "log-level", envOr("GOLLABORATE_LOG_LEVEL", "info"), "Log this and more severe: debug, info, warn or error (or GOLLABORATE_LOG_LEVEL)")
+	flags.StringVar(&o.format, "log-format", envOr("GOLLABORATE_LOG_FORMAT", "text"), "Write diagnostics as "+strings.Join(logging.Formats(), " or ")+" (or GOLLABORATE_LOG_FORMAT)")
+	flags.IntVar(&o.maxSize, "log-max-size", 0, "Rotate the log file once it reaches this many megabytes (0 never does)")
+	flags.IntVar(&o.maxFiles, "log-max-files", 5, "Rotated log files to keep, with --log-max-size")
+	return o
+}
+
+// envOr returns an environment variable, or fallback if it is unset or empty
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// moduleLogger returns the default logger, naming a module in each record
+func moduleLogger(name string) *slog.Logger {
+	return logging.Module(slog.Default(), name)
+}
+
+// fatal logs an error that stops the command, then exits
+func fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+// logSink is where diagnostics are written. They go to stderr until a TUI
+// takes over the terminal, and to the log pane from then on, so they never
+// write over the editor. A log file, when given, gets everything.
+type logSink struct {
+	mutex    sync.Mutex
+	pane     *core.LogPane
+	file     io.WriteCloser
+	terminal io.Writer
+	inTUI    bool
+	previous *slog.Logger // The default logger before, restored on Close
+}
+
+// setupLogging makes the default logger, and with it the standard one, write
+// records through a new logSink, which also appends to the log file if the
+// options name one
+func setupLogging(o logOptions) (*logSink, error) {
+	level, err := logging.ParseLevel(o.level)
+	if err != nil {
+		return nil, err
+	}
+	s := &logSink{pane: core.NewLogPane(logPaneLines), terminal: os.Stderr, previous: slog.Default()}
+	handler, err := logging.NewHandler(s, o.format, level)
+	if err != nil {
+		return nil, err
+	}
+	if o.file != "" {
+		file, err := logging.OpenFile(o.file, int64(o.maxSize)<<20, o.maxFiles)
+		if err != nil {
+			return nil, err
+		}
+		s.file = file
+	}
+	slog.SetDefault(slog.New(handler))
+	core.SetLogPane(s.pane)
+	return s, nil
+}
+
+// Write sends a log line to the file and to the terminal or the pane
+func (s *logSink) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file != nil {
+		_, _ = s.file.Write(p)
+	}
+	_, _ = s.pane.Write(p)
+	if !s.inTUI {
+		_, _ = s.terminal.Write(p)
+	}
+	return len(p), nil
+}
+
+// SetTUI keeps log output off the terminal while a TUI owns it
+func (s *logSink) SetTUI(inTUI bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.inTUI = inTUI
+}
+
+// Close restores the default and standard loggers and closes the log file
+func (s *logSink) Close() error {
+	slog.SetDefault(s.previous)
+	log.SetOutput(os.Stderr)
+	log.SetFlags(log.LstdFlags)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,48 @@
+// Package logging sets up the structured, leveled logs of gollaborate's
+// commands: records with a level, a message and fields, written as text or
+// JSON, to a file rotated as it grows if wanted.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// ModuleKey is the field naming the part of gollaborate a record comes from,
+// such as server or peer
+const ModuleKey = "module"
+
+// Formats returns the output formats NewHandler takes
+func Formats() []string {
+	return []string{"text", "json"}
+}
+
+// ParseLevel reads a level by name: debug, info, warn or error, or one of
+// these with an offset such as warn+2
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", name)
+	}
+	return level, nil
+}
+
+// NewHandler writes records at or above a level to w, as key=value text or as
+// one JSON object per line
+func NewHandler(w io.Writer, format string, level slog.Leveler) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, expected one of %s", format, strings.Join(Formats(), ", "))
+}
+
+// Module returns a logger adding the module's name to each record
+func Module(logger *slog.Logger, name string) *slog.Logger {
+	return logger.With(ModuleKey, name)
+}
@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	level, err := ParseLevel("WARN")
+	if err != nil || level != slog.LevelWarn {
+		t.Fatalf("Expected warn, got %v (%v)", level, err)
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("Expected an unknown level refused")
+	}
+	if _, err := NewHandler(nil, "xml", level); err == nil {
+		t.Error("Expected an unknown format refused")
+	}
+
+	var out bytes.Buffer
+	handler, err := NewHandler(&out, "json", level)
+	if err != nil {
+		t.Fatalf("Failed to make a handler: %v", err)
+	}
+	logger := Module(slog.New(handler), "server")
+	logger.Info("Client joined", "room", "notes")
+	logger.Warn("Client dropped", "room", "notes")
+
+	var record map[string]any
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("Expected one JSON record, got %q (%v)", out.String(), err)
+	}
+	if record["msg"] != "Client dropped" || record["level"] != "WARN" || record[ModuleKey] != "server" || record["room"] != "notes" {
+		t.Errorf("Expected the warning with its fields, got %v", record)
+	}
+
+	out.Reset()
+	if handler, err = NewHandler(&out, "text", slog.LevelDebug); err != nil {
+		t.Fatalf("Failed to make a handler: %v", err)
+	}
+	Module(slog.New(handler), "peer").Debug("Synced", "peer", "a:1")
+	if line := out.String(); !strings.Contains(line, "level=DEBUG") || !strings.Contains(line, "module=peer") || !strings.Contains(line, "peer=a:1") {
+		t.Errorf("Expected a text record, got %q", line)
+	}
+}
+
+func TestFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	if err := os.WriteFile(path, []byte("earlier\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	f, err := OpenFile(path, 16, 2)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer f.Close()
+
+	// Each line but the first takes the file past 16 bytes, so starts a new one
+	for _, line := range []string{"first\n", "second line\n", "third line\n", "fourth line\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+	for name, want := range map[string]string{
+		path:        "fourth line\n",
+		path + ".1": "third line\n",
+		path + ".2": "second line\n",
+	} {
+		if data, err := os.ReadFile(name); err != nil || string(data) != want {
+			t.Errorf("Expected %q in %s, got %q (%v)", want, name, data, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only two rotated files kept, got %v", err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Errorf("Failed to close: %v", err)
+	}
+	if _, err := f.Write([]byte("late\n")); err == nil {
+		t.Error("Expected writing after closing refused")
+	}
+}
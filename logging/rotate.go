@@ -0,0 +1,102 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// File is a log file rotated as it grows: once a write would take it past its
+// maximum size, it is renamed PATH.1, an earlier PATH.1 becomes PATH.2 and so
+// on, the oldest beyond those kept are removed, and a new file is started.
+type File struct {
+	path    string
+	maxSize int64 // Zero never rotates
+	keep    int   // How many rotated files are kept
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+// OpenFile appends to the log file at path, rotating it whenever it would grow
+// past maxSize bytes and keeping that many rotated files. A maxSize of zero
+// lets the file grow forever.
+func OpenFile(path string, maxSize int64, keep int) (*File, error) {
+	f := &File{path: path, maxSize: maxSize, keep: keep}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file for appending, noting its size
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("opening log file: %w", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write appends to the file, first rotating it if it would grow too large. A
+// record larger than the maximum size still goes into a file of its own.
+func (f *File) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file aside, shifting the older ones along, and starts a
+// new one. The caller must hold f.mutex.
+func (f *File) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	_ = os.Remove(f.rotated(f.keep))
+	for i := f.keep - 1; i >= 1; i-- {
+		_ = os.Rename(f.rotated(i), f.rotated(i+1))
+	}
+	if f.keep > 0 {
+		if err := os.Rename(f.path, f.rotated(1)); err != nil {
+			return fmt.Errorf("rotating log file: %w", err)
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return fmt.Errorf("rotating log file: %w", err)
+	}
+	return f.open()
+}
+
+// rotated names the nth file rotated away, the first being the latest
+func (f *File) rotated(n int) string {
+	return fmt.Sprintf("%s.%d", f.path, n)
+}
+
+// Close closes the file
+func (f *File) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -4,26 +4,57 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"math/rand"
-	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"gollaborate/crdt"
+	"gollaborate/language"
 	"gollaborate/messages"
+	"gollaborate/presence"
+	"gollaborate/recent"
+	"gollaborate/replay"
 	"gollaborate/shared"
 	core "gollaborate/tui"
 )
 
 var (
-	port      = flag.Int("port", 8080, "Port to listen on")
-	nodeID    = flag.Int("node", 0, "Node ID (0 for random)")
-	join      = flag.String("join", "", "Address of node to join (host:port)")
-	textFile  = flag.String("file", "", "Text file to load (optional)")
-	username  = flag.String("user", "", "Username (optional)")
-	colorName = flag.String("color", "blue", "User color (blue, green, red, yellow, cyan, magenta)")
+	port            = flag.Int("port", defaultPort, "Port to listen on")
+	nodeID          = flag.Int("node", 0, "Node ID (0 for random)")
+	join            = flag.String("join", "", "Address of node to join (host:port), or an invite link from 'gollaborate admin invite'")
+	roomName        = flag.String("room", "", "Document to open on a server hosting several, with --join (the server's own when empty)")
+	templateName    = flag.String("template", "", "Start the --room from this template of the server's, such as meeting-notes or scratchpad, if the server does not have it yet")
+	spectate        = flag.Bool("spectate", false, "Watch the document with --join without editing it: the server refuses a spectator's edits")
+	textFile        = flag.String("file", "", "Text file to load (optional)")
+	username        = flag.String("user", "", "Username (optional)")
+	token           = flag.String("token", "", "Token to present to a server that asks for one, with --join")
+	joinCode        = flag.String("join-code", "", "Join code a server's admin gave you, to join with --join without a token")
+	colorName       = flag.String("color", "blue", "User color (blue, green, red, yellow, cyan, magenta)")
+	langName        = flag.String("lang", "", "Document language (detected from --file when empty)")
+	readOnlyJoiners = flag.Bool("readonly-joiners", false, "Give peers that join this session read-only access (session originator only)")
+	classroom       = flag.Bool("classroom", false, "Present to a class: peers who join can only watch, fork a private copy with Ctrl+F and hand it in (session originator only)")
+	recordFile      = flag.String("record", "", "Record the document's changes to this file (export with 'replay')")
+	resume          = flag.Int("resume", 0, "Reopen the nth entry listed by 'recent' (a file or a peer to join)")
+	maxHistory      = flag.Int("max-history", 0, "Keep at most this many edits in the history (0 keeps all)")
+	maxTombstones   = flag.Int("max-tombstones", 0, "Remember at most this many deleted characters for merging (0 keeps all)")
+	protectLines    = flag.String("protect", "", "Protect lines FIRST-LAST, such as a license header, from edits (session originator only)")
+	codecName       = flag.String("codec", "json", "Message encoding to use with peers that support it (json, protobuf, msgpack)")
+	transportName   = flag.String("transport", "tcp", "How to connect to peers (tcp or ws)")
+	tlsSettings     = addTLSFlags(flag.CommandLine)
+	sendSettings    = addSendFlags(flag.CommandLine)
+	themeName       = flag.String("theme", "", "Color theme: color, high-contrast or no-color (no-color when NO_COLOR is set)")
+	noColor         = flag.Bool("no-color", false, "Use no colors, same as --theme no-color")
+	crashDir        = flag.String("crash-dir", os.TempDir(), "Directory for crash reports and the document saved with them")
+	restoreQuorum   = flag.Float64("restore-quorum", 0, "Fraction of the other editors who must approve restoring an old version (0 needs no approval)")
+	logSettings     = addLogFlags(flag.CommandLine, "Also append diagnostics to this file (they are in the log view, Ctrl+L, while editing)")
+	opLogFile       = flag.String("oplog", "", "Log the order operations are applied in to this file, to compare with 'oplog-diff'")
+	trace           = flag.Bool("trace", false, "Log every message sent to and received from peers (in the log view, Ctrl+L)")
+	saveSettings    = addAutosaveFlags(flag.CommandLine, "Save the document once the last peer connected to this editor leaves")
+	autosaveDir     = flag.String("autosave-dir", filepath.Join(os.TempDir(), "gollaborate-autosave"), "Directory autosaving keeps snapshots of the document in, besides saving it to --file")
 )
 
 // Available colors for users
@@ -37,7 +68,47 @@ var colors = map[string]string{
 }
 
 func main() {
+	// Run as a headless server when asked to
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		runServe(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		runAdmin(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		runReplay(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "recent" {
+		runRecent(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "oplog-diff" {
+		runOpLogDiff(os.Args[2:])
+		return
+	}
+
 	flag.Parse()
+	logs, err := setupLogging(*logSettings)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	defer logs.Close()
+	logger := moduleLogger("peer")
+	if *trace {
+		messages.Use(messages.DebugLog(nil))
+	}
+	if *resume > 0 {
+		resumeRecent(*resume)
+	}
+	if addr, room, code, ok := parseInviteLink(*join); ok {
+		*join, *joinCode = addr, code
+		if *roomName == "" {
+			*roomName = room
+		}
+	}
 
 	// Generate random node ID if not specified
 	userNodeID := *nodeID
@@ -58,98 +129,288 @@ func main() {
 		color = colors["blue"]
 	}
 
+	codec, ok := messages.CodecByName(*codecName)
+	if !ok {
+		fatal(logger, "Unknown codec", "codec", *codecName, "codecs", messages.Codecs())
+	}
+	network, err := chooseNetwork(*transportName, tlsSettings)
+	if err != nil {
+		fatal(logger, "Failed to set up the network", "err", err)
+	}
+
 	// Initialize document
 	var doc *crdt.Document
 	if *textFile != "" {
 		// Try to load document from file
-		content, err := os.ReadFile(*textFile)
+		loaded, err := loadDocument(*textFile, userNodeID)
 		if err != nil {
-			log.Printf("Failed to load file %s: %v, starting with empty document", *textFile, err)
+			logger.Warn("Failed to load file, starting with empty document", "file", *textFile, "err", err)
 			doc = crdt.FromText("", userNodeID)
 		} else {
-			doc = crdt.FromText(string(content), userNodeID)
-			log.Printf("Loaded document from %s", *textFile)
+			doc = loaded
+			logger.Info("Loaded document", "file", *textFile)
+			rememberRecent(recent.File, *textFile)
 		}
 	} else {
 		// Start with empty document
 		doc = crdt.FromText("", userNodeID)
-		log.Printf("Starting with empty document")
+		logger.Info("Starting with empty document")
+	}
+
+	// Record the document language so every participant edits it the same way.
+	// Joiners take it from the session they join unless told otherwise.
+	if *join == "" || *langName != "" {
+		doc.Metadata.Language = documentLanguage(*langName, *textFile)
 	}
 
+	// Keep the edits for the TUI's history view and the words for completion
+	doc.EnableHistory()
+	doc.EnableWords()
+	doc.SetLimits(crdt.Limits{MaxHistory: *maxHistory, MaxTombstones: *maxTombstones})
+
 	// Create editor state
 	editorState := shared.NewEditorState(doc, userNodeID)
+	editorState.SetCodec(codec)
+	if err := applySendFlags(editorState, sendSettings); err != nil {
+		fatal(logger, "Invalid send settings", "err", err)
+	}
+	editorState.EnableHeartbeat(shared.DefaultHeartbeatInterval, shared.DefaultHeartbeatMisses)
+	editorState.RequireApproval(messages.TransactionActionRestore, *restoreQuorum)
+	// Joiners take the title from the session they join
+	if *textFile != "" && *join == "" {
+		editorState.SetTitle(filepath.Base(*textFile))
+	}
+	if *opLogFile != "" {
+		f, err := os.Create(*opLogFile)
+		if err != nil {
+			fatal(logger, "Failed to create the operation log", "file", *opLogFile, "err", err)
+		}
+		defer f.Close()
+		editorState.LogChanges(replay.NewOpLogger(f))
+	}
+	editorState.SetErrorHandler(func(conn messages.Transport, err error) {
+		logger.Warn("Connection error", "peer", conn.RemoteID(), "err", err)
+	})
+	core.SetCrashReporter(newCrashReporter(*crashDir, editorState))
+	editorState.SetPresence(func(s *presence.State) {
+		s.UserName = user
+		s.Color = color
+	})
+	if *readOnlyJoiners {
+		if *join != "" {
+			logger.Warn("Only the session originator can assign roles, ignoring --readonly-joiners")
+		} else {
+			editorState.SetJoinerRole(messages.RoleReadOnly)
+		}
+	}
+	if *classroom {
+		if *join != "" {
+			logger.Warn("Only the session originator can present, ignoring --classroom")
+		} else {
+			editorState.Present()
+		}
+	}
+	if *protectLines != "" {
+		if *join != "" {
+			logger.Warn("Only the session originator can protect text, ignoring --protect")
+		} else if err := protectLineRange(editorState, *protectLines); err != nil {
+			logger.Warn("Cannot protect lines", "err", err)
+		}
+	}
+	if *spectate {
+		if *join == "" {
+			logger.Warn("Only those joining can spectate, ignoring --spectate")
+		} else {
+			editorState.Spectate()
+		}
+	}
+	saver, err := startAutosave(editorState, saveSettings.policy(), *textFile, *autosaveDir, moduleLogger("autosave"))
+	if err != nil {
+		logger.Warn("Cannot autosave", "err", err)
+	}
+	stopAutosave := func() {
+		if saver != nil {
+			saver.Close()
+		}
+	}
 
 	// Setup network listener
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+	listener, err := network.Listen(fmt.Sprintf(":%d", *port))
 	if err != nil {
-		log.Fatalf("Failed to start listener: %v", err)
+		fatal(logger, "Failed to start listener", "err", err)
 	}
 	defer listener.Close()
-	log.Printf("Listening on port %d", *port)
+	logger.Info("Listening", "port", *port)
 
 	// Handle incoming connections in a goroutine
 	go func() {
 		for {
 			conn, err := listener.Accept()
 			if err != nil {
-				log.Printf("Error accepting connection: %v", err)
+				logger.Warn("Error accepting connection", "err", err)
 				continue
 			}
-			log.Printf("New connection from %s", conn.RemoteAddr())
+			logger.Info("New connection", "addr", conn.RemoteAddr())
 
 			// Add connection to editor state
-			editorState.AddConn(conn)
+			peer := editorState.AddConn(conn)
 
-			// Send current document state to new peer
-			err = messages.SendSync(conn, editorState.Document(), userNodeID)
-			if err != nil {
-				log.Printf("Error sending document sync: %v", err)
-			}
+			// Bring the new peer up to date, which may wait for its hello
+			go func() {
+				if strategy, err := editorState.SyncPeer(peer); err != nil {
+					logger.Warn("Error sending document sync", "peer", peer.RemoteID(), "err", err)
+				} else {
+					logger.Info("Synced", "peer", peer.RemoteID(), "strategy", strategy)
+				}
+
+				// Tell the new peer who may edit, and what it is editing
+				if err := editorState.SendRoles(peer); err != nil {
+					logger.Warn("Error sending roles", "peer", peer.RemoteID(), "err", err)
+				}
+				if err := editorState.SendDocMeta(peer); err != nil {
+					logger.Warn("Error sending document description", "peer", peer.RemoteID(), "err", err)
+				}
+
+				// And who is here
+				if err := editorState.SendPresence(peer); err != nil {
+					logger.Warn("Error sending presence", "peer", peer.RemoteID(), "err", err)
+				}
+			}()
 		}
 	}()
 
 	// Join existing network if specified
 	if *join != "" {
-		log.Printf("Attempting to join %s...", *join)
-		conn, err := net.Dial("tcp", *join)
+		logger.Info("Attempting to join", "addr", *join)
+		link := newServerLink(editorState, network, *join)
+		peer, err := link.connect()
 		if err != nil {
-			log.Printf("Failed to connect to %s: %v", *join, err)
+			logger.Error("Failed to connect", "addr", *join, "err", err)
 		} else {
-			log.Printf("Connected to %s", *join)
-			editorState.AddConn(conn)
+			logger.Info("Connected", "addr", *join)
+			rememberRecent(recent.Peer, *join)
+			go link.stayConnected(peer)
 
 			// Request document sync
-			err = messages.SendInit(conn, nil, userNodeID)
+			err = peer.Send(messages.NewInitMessage(nil, userNodeID))
 			if err != nil {
-				log.Printf("Error requesting document sync: %v", err)
+				logger.Warn("Error requesting document sync", "err", err)
 			}
 		}
 	}
 
+	// Record the session when asked to
+	var recorder *replay.Recorder
+	if *recordFile != "" {
+		name := "untitled"
+		if *textFile != "" {
+			name = filepath.Base(*textFile)
+		}
+		recorder = replay.NewRecorder(name)
+	}
+	saveRecording := func() {
+		if recorder == nil {
+			return
+		}
+		if err := recorder.Save(*recordFile); err != nil {
+			logger.Error("Error saving recording", "file", *recordFile, "err", err)
+		} else {
+			logger.Info("Recording saved", "file", *recordFile)
+		}
+	}
+
 	// Handle signals for graceful shutdown
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
-		log.Println("Shutting down...")
+		logger.Info("Shutting down")
+		stopAutosave()
 
 		// Save document if file was specified
 		if *textFile != "" {
-			text := editorState.Document().ToText()
-			err := os.WriteFile(*textFile, []byte(text), 0644)
+			err := saveDocument(*textFile, editorState.Document())
 			if err != nil {
-				log.Printf("Error saving document: %v", err)
+				logger.Error("Error saving document", "file", *textFile, "err", err)
 			} else {
-				log.Printf("Document saved to %s", *textFile)
+				logger.Info("Document saved", "file", *textFile)
 			}
 		}
 
+		saveRecording()
+		editorState.LeavePresence()
 		os.Exit(0)
 	}()
 
 	// Start TUI
-	log.Printf("Starting Gollaborate TUI as node %d", userNodeID)
-	if err := core.StartTUI(editorState, userNodeID, color); err != nil {
-		log.Fatalf("Error running TUI: %v", err)
+	core.SetTheme(chooseTheme(*themeName, *noColor))
+	logger.Info("Starting Gollaborate TUI", "node", userNodeID)
+	logs.SetTUI(true)
+	err = core.StartRecordedTUI(editorState, userNodeID, color, recorder)
+	logs.SetTUI(false)
+	stopAutosave()
+	if err != nil {
+		fatal(logger, "Error running TUI", "err", err)
+	}
+	saveRecording()
+	editorState.LeavePresence()
+}
+
+// chooseTheme returns the theme named on the command line, falling back to the
+// default for the terminal
+func chooseTheme(name string, noColor bool) core.Theme {
+	if noColor {
+		return core.ThemeNoColor
+	}
+	if name == "" {
+		return core.DefaultTheme()
+	}
+	theme, ok := core.ThemeByName(name)
+	if !ok {
+		slog.Warn("Unknown theme, using the default", "theme", name)
+		return core.DefaultTheme()
+	}
+	return theme
+}
+
+// protectLineRange protects a range of lines given as "FIRST-LAST", or a single line
+func protectLineRange(editorState *shared.EditorState, lines string) error {
+	var first, last int
+	if _, err := fmt.Sscanf(lines, "%d-%d", &first, &last); err != nil {
+		if _, err := fmt.Sscanf(lines, "%d", &first); err != nil {
+			return fmt.Errorf("expected FIRST-LAST, got %q", lines)
+		}
+		last = first
+	}
+
+	doc := editorState.Document()
+	if first < 1 || last < first || last > len(doc.Lines) {
+		return fmt.Errorf("lines %s are not in the document, which has %d lines", lines, len(doc.Lines))
+	}
+	var chars []crdt.Character
+	for _, line := range doc.Lines[first-1 : last] {
+		chars = append(chars, line.Characters...)
+	}
+	if len(chars) == 0 {
+		return fmt.Errorf("lines %s are empty", lines)
+	}
+
+	editorState.Protect(fmt.Sprintf("lines %s", lines), chars[0].Pos, chars[len(chars)-1].Pos)
+	slog.Info("Protected lines", "lines", lines)
+	return nil
+}
+
+// documentLanguage picks the language for a document from an explicit name or the file it came from
+func documentLanguage(name, filename string) string {
+	if name != "" {
+		if !language.Known(name) {
+			slog.Warn("Unknown language, treating document as plain text", "lang", name)
+			return language.Plain.Name
+		}
+		return language.Lookup(name).Name
+	}
+	if filename != "" {
+		return language.Detect(filename)
 	}
+	return language.Plain.Name
 }
@@ -0,0 +1,136 @@
+package messages
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Codec turns messages into bytes and back. Peers always understand JSON; other
+// codecs are used once a peer has said it can read them, see NewHelloMessage.
+type Codec interface {
+	// Name identifies the codec when peers negotiate
+	Name() string
+	// Tag is the byte that starts each frame written with the codec. JSON frames
+	// have no tag: they are a JSON object followed by a newline.
+	Tag() byte
+	Marshal(msg *Message) ([]byte, error)
+	Unmarshal(data []byte) (*Message, error)
+}
+
+var (
+	// JSON is the text encoding every peer understands
+	JSON Codec = jsonCodec{}
+	// Protobuf is the binary encoding described by messages.proto
+	Protobuf Codec = protobufCodec{}
+	// MessagePack is a binary encoding with the structure of the JSON one
+	MessagePack Codec = msgpackCodec{}
+)
+
+// codecs is every codec this build can read, in order of preference
+var codecs = []Codec{Protobuf, MessagePack, JSON}
+
+// maxFrameSize bounds the payload of a binary frame, so a corrupt length does
+// not allocate without limit
+const maxFrameSize = 256 << 20
+
+// ErrFrameTooLarge is returned for a binary frame longer than any real message
+var ErrFrameTooLarge = errors.New("message frame too large")
+
+// Codecs returns the names of every codec this build can read, most preferred first
+func Codecs() []string {
+	names := make([]string, len(codecs))
+	for i, c := range codecs {
+		names[i] = c.Name()
+	}
+	return names
+}
+
+// CodecByName returns the codec with the given name
+func CodecByName(name string) (Codec, bool) {
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// Negotiate returns preferred if it is among the codecs a peer can read, and
+// JSON otherwise
+func Negotiate(preferred Codec, peerCodecs []string) Codec {
+	for _, name := range peerCodecs {
+		if name == preferred.Name() {
+			return preferred
+		}
+	}
+	return JSON
+}
+
+// AppendFrame appends a message encoded with a codec, framed for the wire, to buf
+func AppendFrame(buf []byte, msg *Message, codec Codec) ([]byte, error) {
+	data, err := codec.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize message: %w", err)
+	}
+	if codec.Tag() == 0 {
+		buf = append(buf, data...)
+		return append(buf, '\n'), nil
+	}
+	buf = append(buf, codec.Tag())
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...), nil
+}
+
+// WriteMessage sends a message over a network connection encoded with a codec
+func WriteMessage(conn Conn, msg *Message, codec Codec) error {
+	data, err := AppendFrame(nil, msg, codec)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(data); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return nil
+}
+
+// readBinaryFrame reads the payload of a binary frame whose tag was already read
+func (r *Reader) readBinaryFrame() ([]byte, error) {
+	length, err := binary.ReadUvarint(r.reader)
+	if err != nil {
+		return nil, err
+	}
+	if length > maxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// codecByTag returns the codec whose frames start with a tag byte
+func codecByTag(tag byte) (Codec, bool) {
+	for _, c := range codecs {
+		if c.Tag() != 0 && c.Tag() == tag {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// jsonCodec is the original newline-delimited JSON encoding
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+func (jsonCodec) Tag() byte    { return 0 }
+
+func (jsonCodec) Marshal(msg *Message) ([]byte, error) {
+	return msg.Serialize()
+}
+
+func (jsonCodec) Unmarshal(data []byte) (*Message, error) {
+	return Deserialize(data)
+}
@@ -0,0 +1,225 @@
+package messages
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/presence"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	doc := crdt.FromText("hello\nworld", 1)
+	doc.Metadata.Language = "go"
+	pos := []crdt.Identifier{{Digit: 10, Node: 1}, {Digit: 20, Node: 2}}
+	msgs := []*Message{
+		NewOperationMessage(NewInsertOperation(pos, 'é', 1, 5)),
+		NewOperationMessage(NewDeleteOperation(pos, 2, 6)),
+		NewTransactionMessage([]*Operation{
+			NewInsertOperation(pos, '/', 3, 7),
+			NewDeleteOperation([]crdt.Identifier{{Digit: 9, Node: 1}}, 3, 8),
+		}, TransactionActionComment, 3, "Carol"),
+		NewBatchMessage([]*Operation{
+			NewInsertOperation(pos, 'a', 3, 9),
+			NewInsertOperation([]crdt.Identifier{{Digit: 11, Node: 3}}, 'b', 3, 10),
+		}, 3),
+		NewSyncMessage(doc, 1),
+		NewInitMessage(doc, 1),
+		NewRolesMessage(map[int]Role{2: RoleReadOnly, 3: RoleEditor}, 1),
+		NewMetadataMessage(doc.Metadata, 1),
+		NewAwarenessMessage([]presence.State{
+			{UserID: 2, UserName: "Alice", Color: "#00FF00", Cursor: pos, Clock: 3},
+			{UserID: 3, SelectionStart: pos, Selecting: true, Clock: 1},
+			{UserID: 4, Clock: 7, Offline: true},
+		}, 2),
+		NewProbeMessage(42, 1),
+		NewProbeAckMessage(42, 2),
+		NewHelloMessage(1, "Alice", "#00FF00"),
+		NewSeqAckMessage(1<<40, 2),
+		NewCatchUpRequestMessage(map[int]int{0: 3, 1: 12, 4: 0}, 2),
+		NewCatchUpMessage([]*Operation{NewDeleteOperation(pos, 1, 12)}, 0),
+		NewResyncRequestMessage(2),
+		NewDigestMessage("9f86d081884c7d65", map[int]int{1: 12, 2: 40}, 100),
+		NewUserInfoMessage(2, "Bobby", "#00FF00"),
+		NewChatMessage("lunch? 🍜", time.UnixMilli(1700000000123), 2, "Bob"),
+		NewSubmissionMessage("my answer\n", 3, "Carol"),
+		NewLimitErrorMessage("slow down", ErrorCodeThrottled, 1500*time.Millisecond, 0),
+		NewProposalMessage(3<<32|1, TransactionActionRestore, "Restore the version of 15:04:05", 3, "Carol"),
+		NewVoteMessage(3<<32|1, true, 2),
+		NewPresenceMessage(PresenceIdle, presence.State{UserID: 2, UserName: "Bob", Color: "#FF0000"}, 1),
+		NewClipMessage("func main() {\n\tfmt.Println(\"héllo\")\n}\n", 2, "Bob"),
+		NewErrorMessage("boom", 1),
+		{Type: MessageTypeChat, Text: "relayed", UserID: 2, Origin: 2, OriginSeq: 1 << 50},
+		{Type: MessageTypeHello, Version: ProtocolVersion, UserID: 2, Strategies: []SyncStrategy{SyncMerge, SyncFull}, Clocks: map[int]int{2: 40}},
+		NewAttachmentMessage(AttachmentInfo{ID: "3f2a", Name: "plot.png", Size: 70000, ChunkSize: 32768, SHA256: "ab12"}, 2, "Bob"),
+		NewAttachmentChunkMessage("3f2a", 2, bytes.Repeat([]byte{0, 0xff, 'x'}, 100), 2),
+		NewAttachmentRequestMessage("3f2a", []int{0, 2}, 3),
+		NewPermissionMessage(3, RoleReadOnly, 1),
+		NewDocMetaMessage(DocMeta{Title: "notes.md", Language: "Markdown", ReadOnly: true, SavedAt: 1700000000123}, 1),
+		{Type: MessageTypeClip, Text: "on a channel", UserID: 2, Channel: 3},
+		NewJoinRoomMessage("lecture-2", 4),
+		NewCreateRoomMessage("standup", "meeting-notes", 4),
+		NewSpectateMessage("standup", 4),
+		NewAuthMessage("s3cret", "ada", 4),
+		NewJoinCodeMessage("K7QH-3MZP", "grace", 5),
+		NewInviteMessage("viewer", 3600, 4),
+		NewAdminMessage(AdminCommandKick, 9, 4),
+		NewAdminReplyMessage(AdminCommandExport, "the text", 100),
+		NewExportMessage(ExportFormatArchive, 4),
+		NewImportMessage("restored", ExportFormatJSON, `{"characters":[]}`, 4),
+		NewNoticeMessage("back in five", 100),
+		NewDocumentAtMessage(time.UnixMilli(1700000000123), map[int]int{1: 4, 2: 9}, 4),
+		NewDocumentAtReplyMessage(crdt.FromText("as it was", 100), 1700000000123, 100),
+		NewSessionMessage("0123abcd", 100),
+		NewResumeMessage("0123abcd", map[int]int{1: 4, 100: 2}, 4),
+		NewOrderRequestMessage(1<<40, 4),
+		{Type: MessageTypeBatch, Operations: []*Operation{NewDeleteOperation([]crdt.Identifier{{Digit: 3, Node: 1}}, 1, 8)}, Order: 17, Origin: 1, OriginSeq: 9},
+		NewRoomListMessage([]RoomInfo{{Name: "main", Users: 3}, {Name: "lecture-2"}}, 100),
+		NewRosterMessage([]RosterEntry{{UserID: 3, UserName: "ada", Color: "#ff0000", Role: "admin"}, {UserID: 4, Role: "viewer", Idle: true}}, 100),
+	}
+
+	for _, codec := range []Codec{Protobuf, MessagePack} {
+		for _, msg := range msgs {
+			data, err := codec.Marshal(msg)
+			if err != nil {
+				t.Fatalf("Failed to encode %s message as %s: %v", msg.Type, codec.Name(), err)
+			}
+			decoded, err := codec.Unmarshal(data)
+			if err != nil {
+				t.Fatalf("Failed to decode %s message as %s: %v", msg.Type, codec.Name(), err)
+			}
+
+			// Every codec must give back what JSON does
+			jsonData, _ := JSON.Marshal(msg)
+			expected, _ := JSON.Unmarshal(jsonData)
+			if !reflect.DeepEqual(decoded, expected) {
+				t.Errorf("Expected %s message to round-trip through %s as %+v, got %+v", msg.Type, codec.Name(), expected, decoded)
+			}
+		}
+	}
+}
+
+func TestBinaryCodecsAreSmaller(t *testing.T) {
+	pos := []crdt.Identifier{{Digit: 12345, Node: 17}, {Digit: 678, Node: 42}}
+	keystroke := NewOperationMessage(NewInsertOperation(pos, 'a', 42, 1234))
+	sync := NewSyncMessage(crdt.FromText("package main\n\nfunc main() {}\n", 42), 42)
+
+	size := func(msg *Message, codec Codec) int {
+		t.Helper()
+		frame, err := AppendFrame(nil, msg, codec)
+		if err != nil {
+			t.Fatalf("Failed to encode as %s: %v", codec.Name(), err)
+		}
+		return len(frame)
+	}
+	// MessagePack keeps the field names, protobuf numbers them
+	for _, c := range []struct {
+		codec   Codec
+		percent int
+	}{{Protobuf, 50}, {MessagePack, 70}} {
+		if got, json := size(keystroke, c.codec), size(keystroke, JSON); got*100 > json*c.percent {
+			t.Errorf("Expected a keystroke to take at most %d%% of its JSON size as %s, got %d bytes against %d", c.percent, c.codec.Name(), got, json)
+		}
+	}
+	if got, limit := size(sync, MessagePack), size(sync, JSON)*3/4; got > limit {
+		t.Errorf("Expected a sync to shrink as msgpack, got %d bytes against %d", got, size(sync, JSON))
+	}
+}
+
+func TestReaderDetectsCodec(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	// Peers switch codecs mid-stream, so frames of both kinds can follow each other
+	var data []byte
+	frames := []struct {
+		msg   *Message
+		codec Codec
+	}{
+		{NewHelloMessage(2, "Bob", ""), JSON},
+		{NewProbeMessage(1, 2), Protobuf},
+		{NewErrorMessage("boom", 2), JSON},
+		{NewProbeAckMessage(1, 2), Protobuf},
+		{NewAckMessage(2), MessagePack},
+	}
+	for _, f := range frames {
+		var err error
+		if data, err = AppendFrame(data, f.msg, f.codec); err != nil {
+			t.Fatalf("Failed to encode %s message: %v", f.msg.Type, err)
+		}
+	}
+	go func() { _, _ = remote.Write(data) }()
+
+	reader := NewReader(local)
+	for _, f := range frames {
+		msg, err := reader.Receive()
+		if err != nil {
+			t.Fatalf("Failed to receive %s message: %v", f.msg.Type, err)
+		}
+		if msg.Type != f.msg.Type || msg.UserID != 2 {
+			t.Errorf("Expected %s message from user 2, got %+v", f.msg.Type, msg)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	if codec := Negotiate(Protobuf, []string{"msgpack", "protobuf", "json"}); codec != Protobuf {
+		t.Errorf("Expected protobuf when the peer reads it, got %s", codec.Name())
+	}
+	if codec := Negotiate(Protobuf, []string{"json"}); codec != JSON {
+		t.Errorf("Expected JSON when the peer only reads JSON, got %s", codec.Name())
+	}
+	if codec := Negotiate(MessagePack, []string{"protobuf", "msgpack", "json"}); codec != MessagePack {
+		t.Errorf("Expected msgpack when the peer reads it, got %s", codec.Name())
+	}
+	if codec := Negotiate(JSON, Codecs()); codec != JSON {
+		t.Errorf("Expected JSON when it is preferred, got %s", codec.Name())
+	}
+	if _, ok := CodecByName("xml"); ok {
+		t.Error("Expected no codec named xml")
+	}
+}
+
+func TestInvalidProtobuf(t *testing.T) {
+	for _, data := range [][]byte{
+		{0x0a, 0x05, 'a'},  // Field longer than the message
+		{0x08},             // Varint cut short
+		{0x00, 0x01},       // Field number 0
+		{0x0b, 0x00, 0x00}, // Group wire type
+		{0x08, 0x01},       // Type as a varint
+	} {
+		if _, err := Protobuf.Unmarshal(data); !errors.Is(err, ErrInvalidProtobuf) {
+			t.Errorf("Expected %x to be invalid, got %v", data, err)
+		}
+	}
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	go func() { _, _ = remote.Write(append([]byte{Protobuf.Tag()}, 0xff, 0xff, 0xff, 0xff, 0x0f)) }()
+	if _, err := NewReader(local).Receive(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("Expected an oversized frame to be refused, got %v", err)
+	}
+}
+
+func TestInvalidMsgpack(t *testing.T) {
+	for _, data := range [][]byte{
+		{},                                     // Nothing
+		{0x81, 0xa4, 't', 'y'},                 // String cut short
+		{0x81, 0x01, 0x02},                     // Integer key
+		{0x91, 0x01},                           // Not a map
+		{0x81, 0xa4, 't', 'y', 'p', 'e', 0x01}, // Type as an integer
+		{0x80, 0x00},                           // Bytes after the message
+		{0xdd, 0xff, 0xff, 0xff, 0xff},         // Array longer than the message
+		{0xc1},                                 // Reserved byte
+	} {
+		if _, err := MessagePack.Unmarshal(data); !errors.Is(err, ErrInvalidMsgpack) {
+			t.Errorf("Expected %x to be invalid, got %v", data, err)
+		}
+	}
+}
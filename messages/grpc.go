@@ -0,0 +1,154 @@
+package messages
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// GRPCService is the full name of the Collaboration gRPC service in
+// messages.proto. Its methods are served at /GRPCService/Method.
+const GRPCService = "gollaborate.messages.Collaboration"
+
+// ErrGRPCCompressed is returned for a gRPC message sent compressed, which is
+// never negotiated
+var ErrGRPCCompressed = errors.New("compressed gRPC messages are not supported")
+
+// WriteGRPCFrame writes an encoded message as gRPC frames it: a zero byte for
+// no compression, then its length as four bytes
+func WriteGRPCFrame(w io.Writer, data []byte) error {
+	frame := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	_, err := w.Write(append(frame, data...))
+	return err
+}
+
+// ReadGRPCFrame reads the next gRPC framed message, returning io.EOF once the
+// peer has sent all it will
+func ReadGRPCFrame(r io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, ErrGRPCCompressed
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > maxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// DocumentInfo is the hosted document as the GetDocument and SetLocked RPCs
+// answer with it
+type DocumentInfo struct {
+	Text       string
+	Language   string
+	Characters int
+	Locked     bool
+}
+
+// Marshal encodes the info as messages.proto describes
+func (d DocumentInfo) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, d.Text)
+	b = appendString(b, 2, d.Language)
+	b = appendInt(b, 3, int64(d.Characters))
+	return appendBool(b, 4, d.Locked)
+}
+
+// UnmarshalDocumentInfo decodes the answer to GetDocument or SetLocked
+func UnmarshalDocumentInfo(data []byte) (DocumentInfo, error) {
+	var d DocumentInfo
+	err := decodeFields(data, func(field int, v protoValue) error {
+		var err error
+		switch field {
+		case 1:
+			d.Text, err = v.string()
+		case 2:
+			d.Language, err = v.string()
+		case 3:
+			d.Characters, err = v.int()
+		case 4:
+			d.Locked, err = v.bool()
+		}
+		return err
+	})
+	return d, err
+}
+
+// RestoreRequest asks to return the document to how it was at a time
+type RestoreRequest struct {
+	Time time.Time
+}
+
+// Marshal encodes the request as messages.proto describes
+func (r RestoreRequest) Marshal() []byte {
+	return appendInt(nil, 1, r.Time.UnixMilli())
+}
+
+// UnmarshalRestoreRequest decodes a RestoreDocument request
+func UnmarshalRestoreRequest(data []byte) (RestoreRequest, error) {
+	var r RestoreRequest
+	err := decodeFields(data, func(field int, v protoValue) error {
+		if field != 1 {
+			return nil
+		}
+		ms, err := v.int()
+		r.Time = time.UnixMilli(int64(ms))
+		return err
+	})
+	return r, err
+}
+
+// RestoreReply says how many characters restoring changed
+type RestoreReply struct {
+	Changed int
+}
+
+// Marshal encodes the reply as messages.proto describes
+func (r RestoreReply) Marshal() []byte {
+	return appendInt(nil, 1, int64(r.Changed))
+}
+
+// UnmarshalRestoreReply decodes the answer to RestoreDocument
+func UnmarshalRestoreReply(data []byte) (RestoreReply, error) {
+	var r RestoreReply
+	err := decodeFields(data, func(field int, v protoValue) error {
+		var err error
+		if field == 1 {
+			r.Changed, err = v.int()
+		}
+		return err
+	})
+	return r, err
+}
+
+// SetLockedRequest asks to lock the document against edits, or unlock it
+type SetLockedRequest struct {
+	Locked bool
+}
+
+// Marshal encodes the request as messages.proto describes
+func (r SetLockedRequest) Marshal() []byte {
+	return appendBool(nil, 1, r.Locked)
+}
+
+// UnmarshalSetLockedRequest decodes a SetLocked request
+func UnmarshalSetLockedRequest(data []byte) (SetLockedRequest, error) {
+	var r SetLockedRequest
+	err := decodeFields(data, func(field int, v protoValue) error {
+		var err error
+		if field == 1 {
+			r.Locked, err = v.bool()
+		}
+		return err
+	})
+	return r, err
+}
@@ -5,7 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"gollaborate/crdt"
-	"net"
+	"gollaborate/presence"
+	"time"
 )
 
 // MessageType represents the type of message being sent
@@ -17,10 +18,99 @@ const (
 	MessageTypeInit      MessageType = "init"
 	MessageTypeAck       MessageType = "ack"
 	MessageTypeError     MessageType = "error"
-	MessageTypeCursor    MessageType = "cursor"
-	MessageTypeSelection MessageType = "selection"
+	// MessageTypeAwareness carries participants' presence: cursors, selections, names and colors
+	MessageTypeAwareness MessageType = "awareness"
+	// MessageTypeTransaction carries several operations that must be applied together
+	MessageTypeTransaction MessageType = "transaction"
+	// MessageTypeRoles carries the session originator's view of every participant's role
+	MessageTypeRoles MessageType = "roles"
+	// MessageTypeMetadata carries the session originator's document metadata, such as protected regions
+	MessageTypeMetadata MessageType = "metadata"
+	// MessageTypeProbe asks the receiving peer to echo an ack at once, to measure latency
+	MessageTypeProbe MessageType = "probe"
+	// MessageTypeHello introduces a peer: its protocol version, codecs, node ID, name and color
+	MessageTypeHello MessageType = "hello"
+	// MessageTypePing asks the receiving peer to answer with a pong, to show the connection is alive
+	MessageTypePing MessageType = "ping"
+	MessageTypePong MessageType = "pong"
+	// MessageTypeBatch carries several operations sent together to save on overhead.
+	// Unlike a transaction's, they need not be applied together.
+	MessageTypeBatch MessageType = "batch"
+	// MessageTypePresence announces a participant joining, leaving, going idle or coming back
+	MessageTypePresence MessageType = "presence"
+	// MessageTypeProposal asks the other editors to approve a destructive action
+	MessageTypeProposal MessageType = "proposal"
+	// MessageTypeVote approves or rejects a proposal
+	MessageTypeVote MessageType = "vote"
+	// MessageTypeChat carries a short message from one participant to the others
+	MessageTypeChat MessageType = "chat"
+	// MessageTypeUserInfo announces a participant's new name or color
+	MessageTypeUserInfo MessageType = "user_info"
+	// MessageTypeCatchUpRequest asks a peer for the operations missed while disconnected
+	MessageTypeCatchUpRequest MessageType = "catch_up_request"
+	// MessageTypeCatchUp answers a catch-up request with the missed operations
+	MessageTypeCatchUp MessageType = "catch_up"
+	// MessageTypeClip carries a snippet a participant shared to everyone's session clipboard
+	MessageTypeClip MessageType = "clip"
+	// MessageTypeSubmission carries a student's work, from their private fork, to the presenter
+	MessageTypeSubmission MessageType = "submission"
+	// MessageTypeAttachment offers a file shared alongside the document; its chunks follow
+	MessageTypeAttachment MessageType = "attachment"
+	// MessageTypeAttachmentChunk carries one chunk of a shared file
+	MessageTypeAttachmentChunk MessageType = "attachment_chunk"
+	// MessageTypeAttachmentRequest asks peers for chunks of a shared file that never arrived
+	MessageTypeAttachmentRequest MessageType = "attachment_request"
+	// MessageTypeDocMeta describes the document for title bars: its title,
+	// language, whether it is locked and when it was last saved
+	MessageTypeDocMeta MessageType = "doc_meta"
+	// MessageTypePermission grants or revokes a participant's write access mid-session
+	MessageTypePermission MessageType = "permission"
+	// MessageTypeJoinRoom names the document a client wants, as the first
+	// message on its connection to a server hosting several
+	MessageTypeJoinRoom MessageType = "join_room"
+	// MessageTypeListRooms asks a server which documents it hosts; the server
+	// answers with the same type, listing them
+	MessageTypeListRooms MessageType = "list_rooms"
+	// MessageTypeAuth presents a client's credentials to a server that asks
+	// for them, or a join code it minted, before the client joins a room
+	MessageTypeAuth MessageType = "auth"
+	// MessageTypeAdmin asks a server to do what only admins may, such as kick a
+	// user or lock the document; the server answers with the same type once done
+	MessageTypeAdmin MessageType = "admin"
+	// MessageTypeDocumentAt asks a server for the document as it was at a time,
+	// or after the edits up to given clocks; the server answers with the same
+	// type, carrying the document
+	MessageTypeDocumentAt MessageType = "document_at"
+	// MessageTypeSession tells a client the ID of its session on a server, to
+	// take the session up again with a resume message after reconnecting
+	MessageTypeSession MessageType = "session"
+	// MessageTypeResume takes up a session on a server again, sent first by a
+	// client reconnecting, with the latest clock it saw from each user
+	MessageTypeResume MessageType = "resume"
+	// MessageTypeOrderRequest asks a server for the edits it ordered from a
+	// given order on, which a client missed
+	MessageTypeOrderRequest MessageType = "order_request"
+	// MessageTypeRoster is a server's list of everyone in a room, sent to each
+	// client as it joins and to them all whenever the list changes
+	MessageTypeRoster MessageType = "roster"
+	// MessageTypeDigest carries a hash of a server's document and the latest
+	// clock it saw from each user, sent now and then so that clients whose
+	// copy differs can tell and resync
+	MessageTypeDigest MessageType = "digest"
 )
 
+// ProtocolVersion is the version of the protocol this build speaks. Peers that
+// send no hello are taken to speak version 1; version 2 added hellos, version 3
+// pings, which peers of that version must answer, version 4 sequence numbers
+// on edits, which peers of that version must acknowledge, version 5
+// batches of operations, version 6 the order a server puts edits in, which
+// it echoes to the peers that sent them, version 7 rosters of everyone in
+// a room, and version 8 digests of the document.
+const ProtocolVersion = 8
+
+// MinProtocolVersion is the oldest protocol version this build can talk to
+const MinProtocolVersion = 1
+
 // OperationType represents the type of CRDT operation
 type OperationType string
 
@@ -29,23 +119,150 @@ const (
 	OperationTypeDelete OperationType = "delete"
 )
 
-// CursorPosition represents a cursor position using CRDT identifiers
-type CursorPosition struct {
-	Position []crdt.Identifier `json:"position"`
-	UserID   int               `json:"user_id"`
-	UserName string            `json:"user_name,omitempty"`
-	Color    string            `json:"color,omitempty"` // Hex color for cursor display
-}
+// TransactionAction names the user action that produced a transaction
+type TransactionAction string
+
+const (
+	TransactionActionEdit    TransactionAction = "edit"
+	TransactionActionDelete  TransactionAction = "delete"
+	TransactionActionCut     TransactionAction = "cut"
+	TransactionActionPaste   TransactionAction = "paste"
+	TransactionActionComment TransactionAction = "comment"
+	TransactionActionRestore TransactionAction = "restore"
+	TransactionActionMacro   TransactionAction = "macro"
+	TransactionActionUndo    TransactionAction = "undo"
+	TransactionActionReload  TransactionAction = "reload"
+)
+
+// AdminCommand names what an admin message asks a server to do
+type AdminCommand string
+
+const (
+	// AdminCommandKick disconnects every connection of the target user
+	AdminCommandKick AdminCommand = "kick"
+	// AdminCommandLock and AdminCommandUnlock turn the room's read-only mode on and off
+	AdminCommandLock   AdminCommand = "lock"
+	AdminCommandUnlock AdminCommand = "unlock"
+	// AdminCommandSave saves the room's document to the server's store
+	AdminCommandSave AdminCommand = "save"
+	// AdminCommandExport asks for the room's document in the message's format,
+	// which the answer carries as its text
+	AdminCommandExport AdminCommand = "export"
+	// AdminCommandImport restores a document the message's text holds, in its
+	// format, into the new room the message names
+	AdminCommandImport AdminCommand = "import"
+	// AdminCommandNotice shows the message's text to everyone on the server
+	AdminCommandNotice AdminCommand = "notice"
+	// AdminCommandClients and AdminCommandStats ask for the connected clients
+	// and the server's statistics, which the answer carries as text
+	AdminCommandClients AdminCommand = "clients"
+	AdminCommandStats   AdminCommand = "stats"
+	// AdminCommandFreeze and AdminCommandUnfreeze freeze the room's document
+	// as final, refusing every edit, and thaw it again
+	AdminCommandFreeze   AdminCommand = "freeze"
+	AdminCommandUnfreeze AdminCommand = "unfreeze"
+	// AdminCommandAudit asks for the room's audit trail: who edited it, when
+	// and how much, the last Target entries if Target is set
+	AdminCommandAudit AdminCommand = "audit"
+	// AdminCommandInvite mints a join code for the room, giving whoever joins
+	// with it the role the text names, editor if empty, for Target seconds,
+	// or a default lifetime if Target is 0. The answer carries the code.
+	AdminCommandInvite AdminCommand = "invite"
+	// AdminCommandForget removes what the server keeps of the target user,
+	// for data-removal requests. The answer carries the anonymous ID their
+	// edits are audited under from then on.
+	AdminCommandForget AdminCommand = "forget"
+)
+
+// ExportFormat is how an exported document is written
+type ExportFormat string
+
+const (
+	// ExportFormatText is the document's plain text, which is all an import
+	// of it has to start from
+	ExportFormatText ExportFormat = "text"
+	// ExportFormatJSON is a snapshot of the document as JSON, identifiers and
+	// all, as stores keep it
+	ExportFormatJSON ExportFormat = "json"
+	// ExportFormatArchive is the document's operation log: the document as the
+	// log starts and every edit since, with when it was made, so an import of
+	// it can rebuild the document as it was at any of those times
+	ExportFormatArchive ExportFormat = "archive"
+)
 
-// Selection represents a text selection range
-type Selection struct {
-	StartPosition []crdt.Identifier `json:"start_position"`
-	EndPosition   []crdt.Identifier `json:"end_position"`
-	UserID        int               `json:"user_id"`
-	UserName      string            `json:"user_name,omitempty"`
-	Color         string            `json:"color,omitempty"` // Hex color for selection display
+// ExportFormats returns the formats documents can be exported in
+func ExportFormats() []ExportFormat {
+	return []ExportFormat{ExportFormatText, ExportFormatJSON, ExportFormatArchive}
 }
 
+// SyncStrategy is how a peer joining a session is brought up to date
+type SyncStrategy string
+
+const (
+	// SyncFull sends the peer the whole document
+	SyncFull SyncStrategy = "full"
+	// SyncDelta sends the peer only the operations it lacks, as a catch-up
+	SyncDelta SyncStrategy = "delta"
+	// SyncMerge sends the peer the operations it lacks and asks it for the
+	// ones it has that we lack, for a peer that edited while apart
+	SyncMerge SyncStrategy = "merge"
+)
+
+// PresenceEvent is a change in whether a participant is in the session
+type PresenceEvent string
+
+const (
+	PresenceJoin  PresenceEvent = "join"
+	PresenceLeave PresenceEvent = "leave"
+	PresenceIdle  PresenceEvent = "idle"
+	// PresenceActive is a participant coming back from idle
+	PresenceActive PresenceEvent = "active"
+)
+
+// ErrorCode says what kind of error an error message reports, for clients to
+// act on. Errors without one are only for showing to the user.
+type ErrorCode string
+
+const (
+	// ErrorCodeThrottled refuses edits beyond the sender's rate quota; they may
+	// be sent again after the message's retry delay
+	ErrorCodeThrottled ErrorCode = "throttled"
+	// ErrorCodeTooLarge refuses a single edit beyond the sender's size quota,
+	// such as a paste; it would be refused again
+	ErrorCodeTooLarge ErrorCode = "too_large"
+	// ErrorCodeMalformed refuses a message that could not be decoded
+	ErrorCodeMalformed ErrorCode = "malformed"
+	// ErrorCodeInvalid refuses a message missing a field its type needs
+	ErrorCodeInvalid ErrorCode = "invalid"
+	// ErrorCodeUnauthorized refuses a client that presented no credentials, or
+	// wrong ones, to a server that asks for them; the connection is closed
+	ErrorCodeUnauthorized ErrorCode = "unauthorized"
+	// ErrorCodeForbidden refuses what the sender's role does not allow, such as
+	// a viewer's edits or an editor's admin command
+	ErrorCodeForbidden ErrorCode = "forbidden"
+	// ErrorCodeSessionExpired refuses a client resuming a session the server no
+	// longer has, such as after too long away or being kicked; the connection
+	// is closed, and the client must join afresh
+	ErrorCodeSessionExpired ErrorCode = "session_expired"
+	// ErrorCodeIdle tells a client it was disconnected for having been silent
+	// longer than the server lets clients be; it may join again
+	ErrorCodeIdle ErrorCode = "idle"
+	// ErrorCodeFull refuses a client a server or document has no place for;
+	// the connection is closed, and the client may try again after the
+	// message's retry delay
+	ErrorCodeFull ErrorCode = "full"
+	// ErrorCodeFrozen refuses an edit to a document frozen as final
+	ErrorCodeFrozen ErrorCode = "frozen"
+)
+
+// Role describes what a participant is allowed to do
+type Role string
+
+const (
+	RoleEditor   Role = "editor"
+	RoleReadOnly Role = "read-only"
+)
+
 // Operation represents a single CRDT operation
 type Operation struct {
 	Type      OperationType     `json:"type"`
@@ -57,13 +274,109 @@ type Operation struct {
 
 // Message represents a network message between client and server
 type Message struct {
-	Type      MessageType     `json:"type"`
-	Operation *Operation      `json:"operation,omitempty"`
-	Document  *crdt.Document  `json:"document,omitempty"`
-	Cursor    *CursorPosition `json:"cursor,omitempty"`
-	Selection *Selection      `json:"selection,omitempty"`
-	UserID    int             `json:"user_id,omitempty"`
-	Error     string          `json:"error,omitempty"`
+	Type       MessageType       `json:"type"`
+	Operation  *Operation        `json:"operation,omitempty"`
+	Operations []*Operation      `json:"operations,omitempty"` // Set for transactions and batches
+	Action     TransactionAction `json:"action,omitempty"`     // What produced a transaction, or what a proposal would do
+	UserName   string            `json:"user_name,omitempty"`
+	Document   *crdt.Document    `json:"document,omitempty"`
+	Presence   []presence.State  `json:"presence,omitempty"` // Set for awareness updates and presence events
+	Roles      map[int]Role      `json:"roles,omitempty"`    // Set for role updates and permission changes, keyed by user ID
+	Metadata   *crdt.Metadata    `json:"metadata,omitempty"` // Set for metadata updates
+	UserID     int               `json:"user_id,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	ProbeID    int64             `json:"probe_id,omitempty"` // Set for probes and the acks echoing them
+	Codecs     []string          `json:"codecs,omitempty"`   // Set for hellos, most preferred first
+	Version    int               `json:"version,omitempty"`  // Set for hellos
+	MinVersion int               `json:"min_version,omitempty"`
+	Color      string            `json:"color,omitempty"`       // Set for hellos and user info
+	Seq        int64             `json:"seq,omitempty"`         // Set for sequenced edits and the acks acknowledging them
+	Text       string            `json:"text,omitempty"`        // Set for clips and chat, and proposals to describe them
+	Event      PresenceEvent     `json:"event,omitempty"`       // Set for presence events
+	ProposalID int64             `json:"proposal_id,omitempty"` // Set for proposals and votes
+	Approve    bool              `json:"approve,omitempty"`     // Set for votes in favor
+	SentAt     int64             `json:"sent_at,omitempty"`     // Set for chat, in Unix milliseconds
+	Clocks     map[int]int       `json:"clocks,omitempty"`      // Set for catch-up requests and hellos: the latest operation clock seen from each user
+	Code       ErrorCode         `json:"code,omitempty"`        // Set for errors clients can act on
+	RetryAfter int64             `json:"retry_after,omitempty"` // Set for throttled errors, in milliseconds
+	Origin     int               `json:"origin,omitempty"`      // Set for broadcasts: the node that first sent the message
+	OriginSeq  int64             `json:"origin_seq,omitempty"`  // Numbers the broadcasts of the origin, see ID
+	Strategies []SyncStrategy    `json:"strategies,omitempty"`  // Set for hellos: how the peer may be synced, most preferred first; and for catch-up requests wanting the whole document
+	Attachment *AttachmentInfo   `json:"attachment,omitempty"`  // Set for attachment messages; chunks and requests carry only its ID
+	Chunk      int               `json:"chunk,omitempty"`       // Set for attachment chunks: which chunk of the file
+	Data       []byte            `json:"data,omitempty"`        // Set for attachment chunks
+	Chunks     []int             `json:"chunks,omitempty"`      // Set for attachment requests: the chunks wanted
+	DocMeta    *DocMeta          `json:"doc_meta,omitempty"`    // Set for document descriptions
+	Channel    int               `json:"channel,omitempty"`     // The logical channel carrying the message over a shared connection, see Mux
+	Room       string            `json:"room,omitempty"`        // Set for room joins
+	Rooms      []RoomInfo        `json:"rooms,omitempty"`       // Set for a server's answer to a room listing
+	Token      string            `json:"token,omitempty"`       // Set for auth messages
+	Command    AdminCommand      `json:"command,omitempty"`     // Set for admin messages
+	Target     int               `json:"target,omitempty"`      // Set for admin messages kicking a user: whom
+	At         int64             `json:"at,omitempty"`          // Set for document requests by time, in Unix milliseconds
+	Session    string            `json:"session,omitempty"`     // Set for session and resume messages
+	Order      int64             `json:"order,omitempty"`       // Set for edits a server ordered, syncs it sent, and requests for edits missed
+	Format     ExportFormat      `json:"format,omitempty"`      // Set for exports and imports: how the document is written; text when empty
+	Roster     []RosterEntry     `json:"roster,omitempty"`      // Set for rosters
+	Template   string            `json:"template,omitempty"`    // Set for room joins: what a document the server does not have yet starts as
+	Spectate   bool              `json:"spectate,omitempty"`    // Set for room joins by spectators, who watch without editing
+	Digest     string            `json:"digest,omitempty"`      // Set for digests: the hash of the document
+	JoinCode   string            `json:"join_code,omitempty"`   // Set for auth messages redeeming a join code rather than presenting a token
+}
+
+// DocMeta describes a document, so every participant shows the same title bar
+// and highlights its syntax alike
+type DocMeta struct {
+	Title    string `json:"title,omitempty"`
+	Language string `json:"language,omitempty"`  // A hint, by name as the language package knows it
+	ReadOnly bool   `json:"read_only,omitempty"` // Whether the host refuses edits
+	SavedAt  int64  `json:"saved_at,omitempty"`  // When the document was last saved, in Unix milliseconds; 0 if never
+}
+
+// RoomInfo describes one of the documents a server hosts
+type RoomInfo struct {
+	Name  string `json:"name"`
+	Users int    `json:"users,omitempty"` // Connections in the room
+}
+
+// RosterEntry describes one of the users in a room, however many connections
+// they have to it
+type RosterEntry struct {
+	UserID   int    `json:"user_id"`
+	UserName string `json:"user_name,omitempty"`
+	Color    string `json:"color,omitempty"`
+	Role     string `json:"role,omitempty"` // viewer, editor or admin
+	Idle     bool   `json:"idle,omitempty"`
+}
+
+// AttachmentInfo describes a file shared in a session alongside the document
+type AttachmentInfo struct {
+	ID        string `json:"id"`
+	Name      string `json:"name,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	ChunkSize int    `json:"chunk_size,omitempty"`
+	SHA256    string `json:"sha256,omitempty"` // Hex digest of the whole file, to check it arrived intact
+}
+
+// ChunkCount returns how many chunks the file is sent in
+func (a *AttachmentInfo) ChunkCount() int {
+	if a.ChunkSize <= 0 {
+		return 0
+	}
+	return int((a.Size + int64(a.ChunkSize) - 1) / int64(a.ChunkSize))
+}
+
+// MessageID identifies a broadcast however it travels: the node that sent it
+// first and the number it gave it
+type MessageID struct {
+	Node int
+	Seq  int64
+}
+
+// ID returns the message's ID, and false if it has none, as for messages meant
+// for one peer and those from peers too old to number them
+func (m *Message) ID() (MessageID, bool) {
+	return MessageID{Node: m.Origin, Seq: m.OriginSeq}, m.OriginSeq != 0
 }
 
 // Serialize converts a Message to JSON bytes
@@ -90,6 +403,256 @@ func NewOperationMessage(op *Operation) *Message {
 	}
 }
 
+// NewTransactionMessage creates a message whose operations are applied atomically, in order.
+// The action and user name let receivers describe the change to their users.
+func NewTransactionMessage(ops []*Operation, action TransactionAction, userID int, userName string) *Message {
+	return &Message{
+		Type:       MessageTypeTransaction,
+		Operations: ops,
+		Action:     action,
+		UserID:     userID,
+		UserName:   userName,
+	}
+}
+
+// NewBatchMessage creates a message carrying several operations at once
+func NewBatchMessage(ops []*Operation, userID int) *Message {
+	return &Message{
+		Type:       MessageTypeBatch,
+		Operations: ops,
+		UserID:     userID,
+	}
+}
+
+// NewRolesMessage creates a message announcing every participant's role
+func NewRolesMessage(roles map[int]Role, userID int) *Message {
+	return &Message{
+		Type:   MessageTypeRoles,
+		Roles:  roles,
+		UserID: userID,
+	}
+}
+
+// NewJoinRoomMessage creates a message asking a server for one of the
+// documents it hosts, by name
+func NewJoinRoomMessage(room string, userID int) *Message {
+	return &Message{
+		Type:   MessageTypeJoinRoom,
+		Room:   room,
+		UserID: userID,
+	}
+}
+
+// NewCreateRoomMessage creates a message asking a server for one of the
+// documents it hosts, by name, to be started from one of the server's
+// templates if it has no such document yet
+func NewCreateRoomMessage(room, template string, userID int) *Message {
+	msg := NewJoinRoomMessage(room, userID)
+	msg.Template = template
+	return msg
+}
+
+// NewSpectateMessage creates a message asking a server to watch one of the
+// documents it hosts, by name, as a spectator: one sent the document, its
+// edits and who is there, but whose own edits are refused
+func NewSpectateMessage(room string, userID int) *Message {
+	msg := NewJoinRoomMessage(room, userID)
+	msg.Spectate = true
+	return msg
+}
+
+// NewAuthMessage creates a message presenting a token to a server, as the
+// named user if the server gives each user a token of their own
+func NewAuthMessage(token, userName string, userID int) *Message {
+	return &Message{
+		Type:     MessageTypeAuth,
+		Token:    token,
+		UserName: userName,
+		UserID:   userID,
+	}
+}
+
+// NewJoinCodeMessage creates a message redeeming a join code a server minted,
+// which admits the named user to one document with the role it was minted for
+func NewJoinCodeMessage(code, userName string, userID int) *Message {
+	return &Message{
+		Type:     MessageTypeAuth,
+		JoinCode: code,
+		UserName: userName,
+		UserID:   userID,
+	}
+}
+
+// NewInviteMessage creates a message asking a server to mint a join code for
+// a room, for the given role and lifetime in seconds
+func NewInviteMessage(role string, lifetime int, userID int) *Message {
+	return &Message{
+		Type:    MessageTypeAdmin,
+		Command: AdminCommandInvite,
+		Text:    role,
+		Target:  lifetime,
+		UserID:  userID,
+	}
+}
+
+// NewAdminMessage creates a message asking a server to run an admin command.
+// The target is the user to kick, for kicks.
+func NewAdminMessage(command AdminCommand, target int, userID int) *Message {
+	return &Message{
+		Type:    MessageTypeAdmin,
+		Command: command,
+		Target:  target,
+		UserID:  userID,
+	}
+}
+
+// NewNoticeMessage creates a message asking a server to show a notice to
+// everyone connected to it
+func NewNoticeMessage(text string, userID int) *Message {
+	return &Message{
+		Type:    MessageTypeAdmin,
+		Command: AdminCommandNotice,
+		Text:    text,
+		UserID:  userID,
+	}
+}
+
+// NewExportMessage creates a message asking a server for a room's document in
+// the given format
+func NewExportMessage(format ExportFormat, userID int) *Message {
+	return &Message{
+		Type:    MessageTypeAdmin,
+		Command: AdminCommandExport,
+		Format:  format,
+		UserID:  userID,
+	}
+}
+
+// NewImportMessage creates a message asking a server to restore a document,
+// exported in the given format, into a new room
+func NewImportMessage(room string, format ExportFormat, data string, userID int) *Message {
+	return &Message{
+		Type:    MessageTypeAdmin,
+		Command: AdminCommandImport,
+		Room:    room,
+		Format:  format,
+		Text:    data,
+		UserID:  userID,
+	}
+}
+
+// NewAdminReplyMessage creates a server's answer to an admin command, carrying
+// the document's text for exports and the report asked for
+func NewAdminReplyMessage(command AdminCommand, text string, userID int) *Message {
+	return &Message{
+		Type:    MessageTypeAdmin,
+		Command: command,
+		Text:    text,
+		UserID:  userID,
+	}
+}
+
+// NewRosterMessage creates a message listing everyone in a room
+func NewRosterMessage(roster []RosterEntry, userID int) *Message {
+	return &Message{
+		Type:   MessageTypeRoster,
+		Roster: roster,
+		UserID: userID,
+	}
+}
+
+// NewSessionMessage creates a message telling a client the ID of its session
+func NewSessionMessage(session string, userID int) *Message {
+	return &Message{
+		Type:    MessageTypeSession,
+		Session: session,
+		UserID:  userID,
+	}
+}
+
+// NewOrderRequestMessage creates a message asking for the edits a server
+// ordered from the given order on
+func NewOrderRequestMessage(order int64, userID int) *Message {
+	return &Message{
+		Type:   MessageTypeOrderRequest,
+		Order:  order,
+		UserID: userID,
+	}
+}
+
+// NewResumeMessage creates a message taking up a session again, saying the
+// latest clock seen from each user so only what was missed is sent
+func NewResumeMessage(session string, clocks map[int]int, userID int) *Message {
+	return &Message{
+		Type:    MessageTypeResume,
+		Session: session,
+		Clocks:  clocks,
+		UserID:  userID,
+	}
+}
+
+// NewDocumentAtMessage creates a message asking a server for the document as
+// it was at a time, counting only each user's edits up to their clock in
+// clocks unless it is nil. A zero time asks for the latest edits.
+func NewDocumentAtMessage(at time.Time, clocks map[int]int, userID int) *Message {
+	msg := &Message{
+		Type:   MessageTypeDocumentAt,
+		Clocks: clocks,
+		UserID: userID,
+	}
+	if !at.IsZero() {
+		msg.At = at.UnixMilli()
+	}
+	return msg
+}
+
+// NewDocumentAtReplyMessage creates a server's answer to a document request,
+// with the document as it was
+func NewDocumentAtReplyMessage(doc *crdt.Document, at int64, userID int) *Message {
+	return &Message{
+		Type:     MessageTypeDocumentAt,
+		Document: doc,
+		At:       at,
+		UserID:   userID,
+	}
+}
+
+// NewListRoomsMessage creates a message asking a server which rooms it hosts
+func NewListRoomsMessage(userID int) *Message {
+	return &Message{
+		Type:   MessageTypeListRooms,
+		UserID: userID,
+	}
+}
+
+// NewRoomListMessage creates a server's answer to a room listing
+func NewRoomListMessage(rooms []RoomInfo, userID int) *Message {
+	return &Message{
+		Type:   MessageTypeListRooms,
+		Rooms:  rooms,
+		UserID: userID,
+	}
+}
+
+// NewPermissionMessage creates a message giving one participant a role: write
+// access as an editor, or none as read-only
+func NewPermissionMessage(target int, role Role, userID int) *Message {
+	return &Message{
+		Type:   MessageTypePermission,
+		Roles:  map[int]Role{target: role},
+		UserID: userID,
+	}
+}
+
+// NewMetadataMessage creates a message announcing the document metadata
+func NewMetadataMessage(metadata crdt.Metadata, userID int) *Message {
+	return &Message{
+		Type:     MessageTypeMetadata,
+		Metadata: &metadata,
+		UserID:   userID,
+	}
+}
+
 // NewSyncMessage creates a new sync message with the full document
 func NewSyncMessage(doc *crdt.Document, userID int) *Message {
 	return &Message{
@@ -116,6 +679,225 @@ func NewAckMessage(userID int) *Message {
 	}
 }
 
+// NewProbeMessage creates a latency probe, which the receiver echoes with NewProbeAckMessage
+func NewProbeMessage(probeID int64, userID int) *Message {
+	return &Message{
+		Type:    MessageTypeProbe,
+		ProbeID: probeID,
+		UserID:  userID,
+	}
+}
+
+// NewProbeAckMessage creates the acknowledgment echoing a probe
+func NewProbeAckMessage(probeID int64, userID int) *Message {
+	return &Message{
+		Type:    MessageTypeAck,
+		ProbeID: probeID,
+		UserID:  userID,
+	}
+}
+
+// NewSeqAckMessage creates the acknowledgment of every sequenced message up to
+// and including seq
+func NewSeqAckMessage(seq int64, userID int) *Message {
+	return &Message{
+		Type:   MessageTypeAck,
+		Seq:    seq,
+		UserID: userID,
+	}
+}
+
+// NewHelloMessage creates a message introducing this build and its user to a peer
+func NewHelloMessage(userID int, userName, color string) *Message {
+	return &Message{
+		Type:       MessageTypeHello,
+		Version:    ProtocolVersion,
+		MinVersion: MinProtocolVersion,
+		Codecs:     Codecs(),
+		UserID:     userID,
+		UserName:   userName,
+		Color:      color,
+	}
+}
+
+// AgreeVersion returns the protocol version to use with a peer that said hello
+// with the given versions, and whether there is one both sides speak
+func AgreeVersion(version, minVersion int) (int, bool) {
+	agreed := min(max(version, 1), ProtocolVersion)
+	return agreed, agreed >= MinProtocolVersion && agreed >= minVersion
+}
+
+// NewPingMessage creates a heartbeat message
+func NewPingMessage(userID int) *Message {
+	return &Message{
+		Type:   MessageTypePing,
+		UserID: userID,
+	}
+}
+
+// NewPongMessage creates the answer to a ping
+func NewPongMessage(userID int) *Message {
+	return &Message{
+		Type:   MessageTypePong,
+		UserID: userID,
+	}
+}
+
+// NewPresenceMessage creates a message announcing a presence event of the
+// participant described by state
+func NewPresenceMessage(event PresenceEvent, state presence.State, userID int) *Message {
+	return &Message{
+		Type:     MessageTypePresence,
+		Event:    event,
+		Presence: []presence.State{state},
+		UserID:   userID,
+	}
+}
+
+// NewProposalMessage creates a message asking the other editors to approve an
+// action, described for them by description
+func NewProposalMessage(proposalID int64, action TransactionAction, description string, userID int, userName string) *Message {
+	return &Message{
+		Type:       MessageTypeProposal,
+		ProposalID: proposalID,
+		Action:     action,
+		Text:       description,
+		UserID:     userID,
+		UserName:   userName,
+	}
+}
+
+// NewVoteMessage creates a vote for or against a proposal
+func NewVoteMessage(proposalID int64, approve bool, userID int) *Message {
+	return &Message{
+		Type:       MessageTypeVote,
+		ProposalID: proposalID,
+		Approve:    approve,
+		UserID:     userID,
+	}
+}
+
+// NewUserInfoMessage creates a message announcing a participant's name and color
+func NewUserInfoMessage(userID int, userName string, color string) *Message {
+	return &Message{
+		Type:     MessageTypeUserInfo,
+		UserID:   userID,
+		UserName: userName,
+		Color:    color,
+	}
+}
+
+// NewCatchUpRequestMessage creates a request for the operations a peer has that
+// are newer than the latest clock seen from each user
+func NewCatchUpRequestMessage(clocks map[int]int, userID int) *Message {
+	return &Message{
+		Type:   MessageTypeCatchUpRequest,
+		Clocks: clocks,
+		UserID: userID,
+	}
+}
+
+// NewResyncRequestMessage creates a request for the whole of a peer's
+// document, by a peer whose copy diverged from it
+func NewResyncRequestMessage(userID int) *Message {
+	msg := NewCatchUpRequestMessage(nil, userID)
+	msg.Strategies = []SyncStrategy{SyncFull}
+	return msg
+}
+
+// NewDigestMessage creates a digest of a document: its hash, and the latest
+// clock seen from each user in the edits that made it
+func NewDigestMessage(digest string, clocks map[int]int, userID int) *Message {
+	return &Message{
+		Type:   MessageTypeDigest,
+		Digest: digest,
+		Clocks: clocks,
+		UserID: userID,
+	}
+}
+
+// NewCatchUpMessage creates the answer to a catch-up request
+func NewCatchUpMessage(ops []*Operation, userID int) *Message {
+	return &Message{
+		Type:       MessageTypeCatchUp,
+		Operations: ops,
+		UserID:     userID,
+	}
+}
+
+// NewChatMessage creates a chat message sent at the given time
+func NewChatMessage(text string, sentAt time.Time, userID int, userName string) *Message {
+	return &Message{
+		Type:     MessageTypeChat,
+		Text:     text,
+		SentAt:   sentAt.UnixMilli(),
+		UserID:   userID,
+		UserName: userName,
+	}
+}
+
+// NewClipMessage creates a message sharing a snippet with every participant
+func NewClipMessage(text string, userID int, userName string) *Message {
+	return &Message{
+		Type:     MessageTypeClip,
+		Text:     text,
+		UserID:   userID,
+		UserName: userName,
+	}
+}
+
+// NewSubmissionMessage creates a message handing in work to the presenter
+func NewSubmissionMessage(text string, userID int, userName string) *Message {
+	return &Message{
+		Type:     MessageTypeSubmission,
+		Text:     text,
+		UserID:   userID,
+		UserName: userName,
+	}
+}
+
+// NewAttachmentMessage creates a message offering a shared file, whose chunks
+// are sent after it
+func NewAttachmentMessage(info AttachmentInfo, userID int, userName string) *Message {
+	return &Message{
+		Type:       MessageTypeAttachment,
+		Attachment: &info,
+		UserID:     userID,
+		UserName:   userName,
+	}
+}
+
+// NewAttachmentChunkMessage creates a message carrying one chunk of a shared file
+func NewAttachmentChunkMessage(id string, chunk int, data []byte, userID int) *Message {
+	return &Message{
+		Type:       MessageTypeAttachmentChunk,
+		Attachment: &AttachmentInfo{ID: id},
+		Chunk:      chunk,
+		Data:       data,
+		UserID:     userID,
+	}
+}
+
+// NewAttachmentRequestMessage creates a request for chunks of a shared file,
+// such as those that did not arrive before a disconnection
+func NewAttachmentRequestMessage(id string, chunks []int, userID int) *Message {
+	return &Message{
+		Type:       MessageTypeAttachmentRequest,
+		Attachment: &AttachmentInfo{ID: id},
+		Chunks:     chunks,
+		UserID:     userID,
+	}
+}
+
+// NewDocMetaMessage creates a message describing the document
+func NewDocMetaMessage(meta DocMeta, userID int) *Message {
+	return &Message{
+		Type:    MessageTypeDocMeta,
+		DocMeta: &meta,
+		UserID:  userID,
+	}
+}
+
 // NewErrorMessage creates a new error message
 func NewErrorMessage(errorMsg string, userID int) *Message {
 	return &Message{
@@ -125,32 +907,35 @@ func NewErrorMessage(errorMsg string, userID int) *Message {
 	}
 }
 
-// NewCursorMessage creates a new cursor position message
-func NewCursorMessage(position []crdt.Identifier, userID int, userName, color string) *Message {
+// NewCodedErrorMessage creates an error message with a code saying what kind of
+// error it is
+func NewCodedErrorMessage(errorMsg string, code ErrorCode, userID int) *Message {
 	return &Message{
-		Type: MessageTypeCursor,
-		Cursor: &CursorPosition{
-			Position: position,
-			UserID:   userID,
-			UserName: userName,
-			Color:    color,
-		},
+		Type:   MessageTypeError,
+		Error:  errorMsg,
+		Code:   code,
 		UserID: userID,
 	}
 }
 
-// NewSelectionMessage creates a new selection message
-func NewSelectionMessage(startPos, endPos []crdt.Identifier, userID int, userName, color string) *Message {
+// NewLimitErrorMessage creates an error message refusing edits beyond a quota,
+// saying when they may be sent again if retrying can help
+func NewLimitErrorMessage(errorMsg string, code ErrorCode, retryAfter time.Duration, userID int) *Message {
 	return &Message{
-		Type: MessageTypeSelection,
-		Selection: &Selection{
-			StartPosition: startPos,
-			EndPosition:   endPos,
-			UserID:        userID,
-			UserName:      userName,
-			Color:         color,
-		},
-		UserID: userID,
+		Type:       MessageTypeError,
+		Error:      errorMsg,
+		Code:       code,
+		RetryAfter: retryAfter.Milliseconds(),
+		UserID:     userID,
+	}
+}
+
+// NewAwarenessMessage creates a message carrying presence states
+func NewAwarenessMessage(states []presence.State, userID int) *Message {
+	return &Message{
+		Type:     MessageTypeAwareness,
+		Presence: states,
+		UserID:   userID,
 	}
 }
 
@@ -175,30 +960,47 @@ func NewDeleteOperation(position []crdt.Identifier, userID int, clock int) *Oper
 	}
 }
 
-// SendMessage sends a message over a network connection
-func SendMessage(conn net.Conn, msg *Message) error {
-	data, err := msg.Serialize()
+// SendMessage sends a message over a network connection as JSON, which every
+// peer understands. WriteMessage sends with another codec.
+func SendMessage(conn Conn, msg *Message) error {
+	return WriteMessage(conn, msg, JSON)
+}
+
+// Reader receives messages from a connection one after another, keeping data
+// that arrived with one message for the next. Read each connection through a
+// single Reader for as long as it is open: a second would miss whatever the
+// first had buffered. ConnTransport keeps one for its connection.
+type Reader struct {
+	reader *bufio.Reader
+}
+
+// NewReader creates a Reader for a connection
+func NewReader(conn Conn) *Reader {
+	return &Reader{reader: bufio.NewReader(conn)}
+}
+
+// Receive receives the next message
+func (r *Reader) Receive() (*Message, error) {
+	// Binary frames start with their codec's tag; anything else is JSON
+	tag, err := r.reader.Peek(1)
 	if err != nil {
-		return fmt.Errorf("failed to serialize message: %w", err)
+		return nil, fmt.Errorf("failed to read message: %w", err)
 	}
-	
-	// Add newline delimiter for easier parsing
-	data = append(data, '\n')
-	
-	_, err = conn.Write(data)
-	if err != nil {
-		return fmt.Errorf("failed to send message: %w", err)
+	if codec, ok := codecByTag(tag[0]); ok {
+		_, _ = r.reader.ReadByte()
+		data, err := r.readBinaryFrame()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read message: %w", err)
+		}
+		msg, err := codec.Unmarshal(data)
+		if err != nil {
+			return nil, &DecodeError{Err: err}
+		}
+		return msg, nil
 	}
-	
-	return nil
-}
 
-// ReceiveMessage receives a message from a network connection
-func ReceiveMessage(conn net.Conn) (*Message, error) {
-	reader := bufio.NewReader(conn)
-	
 	// Read until newline delimiter
-	data, err := reader.ReadBytes('\n')
+	data, err := r.reader.ReadBytes('\n')
 	if err != nil {
 		return nil, fmt.Errorf("failed to read message: %w", err)
 	}
@@ -210,50 +1012,50 @@ func ReceiveMessage(conn net.Conn) (*Message, error) {
 	
 	msg, err := Deserialize(data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to deserialize message: %w", err)
+		return nil, &DecodeError{Err: err}
 	}
 	
 	return msg, nil
 }
 
 // SendOperation is a convenience function to send an operation message
-func SendOperation(conn net.Conn, op *Operation) error {
+func SendOperation(conn Conn, op *Operation) error {
 	msg := NewOperationMessage(op)
 	return SendMessage(conn, msg)
 }
 
-// SendSync is a convenience function to send a sync message
-func SendSync(conn net.Conn, doc *crdt.Document, userID int) error {
-	msg := NewSyncMessage(doc, userID)
+// SendTransaction is a convenience function to send a group of operations as one transaction
+func SendTransaction(conn Conn, ops []*Operation, action TransactionAction, userID int, userName string) error {
+	msg := NewTransactionMessage(ops, action, userID, userName)
 	return SendMessage(conn, msg)
 }
 
-// SendInit is a convenience function to send an init message
-func SendInit(conn net.Conn, doc *crdt.Document, userID int) error {
-	msg := NewInitMessage(doc, userID)
+// SendRoles is a convenience function to send a roles message
+func SendRoles(conn Conn, roles map[int]Role, userID int) error {
+	msg := NewRolesMessage(roles, userID)
 	return SendMessage(conn, msg)
 }
 
-// SendError is a convenience function to send an error message
-func SendError(conn net.Conn, errorMsg string, userID int) error {
-	msg := NewErrorMessage(errorMsg, userID)
+// SendSync is a convenience function to send a sync message
+func SendSync(conn Conn, doc *crdt.Document, userID int) error {
+	msg := NewSyncMessage(doc, userID)
 	return SendMessage(conn, msg)
 }
 
-// SendCursor is a convenience function to send a cursor position message
-func SendCursor(conn net.Conn, position []crdt.Identifier, userID int, userName, color string) error {
-	msg := NewCursorMessage(position, userID, userName, color)
+// SendInit is a convenience function to send an init message
+func SendInit(conn Conn, doc *crdt.Document, userID int) error {
+	msg := NewInitMessage(doc, userID)
 	return SendMessage(conn, msg)
 }
 
-// SendSelection is a convenience function to send a selection message
-func SendSelection(conn net.Conn, startPos, endPos []crdt.Identifier, userID int, userName, color string) error {
-	msg := NewSelectionMessage(startPos, endPos, userID, userName, color)
+// SendError is a convenience function to send an error message
+func SendError(conn Conn, errorMsg string, userID int) error {
+	msg := NewErrorMessage(errorMsg, userID)
 	return SendMessage(conn, msg)
 }
 
-// SendClearSelection sends an empty selection to clear a user's selection
-func SendClearSelection(conn net.Conn, userID int, userName, color string) error {
-	msg := NewSelectionMessage(nil, nil, userID, userName, color)
+// SendAwareness is a convenience function to send presence states
+func SendAwareness(conn Conn, states []presence.State, userID int) error {
+	msg := NewAwarenessMessage(states, userID)
 	return SendMessage(conn, msg)
 }
\ No newline at end of file
@@ -2,6 +2,8 @@ package messages
 
 import (
 	"gollaborate/crdt"
+	"gollaborate/presence"
+	"net"
 	"testing"
 )
 
@@ -102,134 +104,184 @@ func TestDocumentMessage(t *testing.T) {
 	}
 }
 
-func TestCursorMessage(t *testing.T) {
-	position := []crdt.Identifier{
-		{Digit: 5, Node: 2},
-		{Digit: 10, Node: 2},
+func TestAwarenessMessage(t *testing.T) {
+	states := []presence.State{
+		{UserID: 2, UserName: "Alice", Color: "#00FF00", Cursor: []crdt.Identifier{{Digit: 5, Node: 2}, {Digit: 10, Node: 2}}, Clock: 3},
+		{UserID: 3, UserName: "Bob", SelectionStart: []crdt.Identifier{{Digit: 1, Node: 1}}, Selecting: true, Clock: 1},
+		{UserID: 4, Clock: 7, Offline: true},
 	}
-	
-	msg := NewCursorMessage(position, 2, "Alice", "#00FF00")
-	
-	// Serialize
+	msg := NewAwarenessMessage(states, 2)
+
 	data, err := msg.Serialize()
 	if err != nil {
-		t.Fatalf("Failed to serialize cursor message: %v", err)
+		t.Fatalf("Failed to serialize awareness message: %v", err)
 	}
-	
-	// Deserialize
+
 	deserializedMsg, err := Deserialize(data)
 	if err != nil {
-		t.Fatalf("Failed to deserialize cursor message: %v", err)
+		t.Fatalf("Failed to deserialize awareness message: %v", err)
 	}
-	
-	// Verify
-	if deserializedMsg.Type != MessageTypeCursor {
-		t.Errorf("Expected type %s, got %s", MessageTypeCursor, deserializedMsg.Type)
+
+	if deserializedMsg.Type != MessageTypeAwareness {
+		t.Errorf("Expected type %s, got %s", MessageTypeAwareness, deserializedMsg.Type)
 	}
-	
-	if deserializedMsg.Cursor.UserID != 2 {
-		t.Errorf("Expected user ID 2, got %d", deserializedMsg.Cursor.UserID)
+	if len(deserializedMsg.Presence) != 3 {
+		t.Fatalf("Expected 3 states, got %d", len(deserializedMsg.Presence))
 	}
-	
-	if deserializedMsg.Cursor.UserName != "Alice" {
-		t.Errorf("Expected user name 'Alice', got '%s'", deserializedMsg.Cursor.UserName)
+
+	alice := deserializedMsg.Presence[0]
+	if alice.UserName != "Alice" || alice.Color != "#00FF00" || alice.Clock != 3 {
+		t.Errorf("Expected Alice's state to round-trip, got %+v", alice)
 	}
-	
-	if deserializedMsg.Cursor.Color != "#00FF00" {
-		t.Errorf("Expected color '#00FF00', got '%s'", deserializedMsg.Cursor.Color)
+	if len(alice.Cursor) != 2 || alice.Cursor[0].Digit != 5 || alice.Cursor[0].Node != 2 {
+		t.Errorf("Expected Alice's cursor to round-trip, got %v", alice.Cursor)
 	}
-	
-	if len(deserializedMsg.Cursor.Position) != 2 {
-		t.Errorf("Expected position length 2, got %d", len(deserializedMsg.Cursor.Position))
+	if bob := deserializedMsg.Presence[1]; !bob.Selecting || len(bob.SelectionStart) != 1 {
+		t.Errorf("Expected Bob's selection to round-trip, got %+v", bob)
 	}
-	
-	if deserializedMsg.Cursor.Position[0].Digit != 5 || deserializedMsg.Cursor.Position[0].Node != 2 {
-		t.Errorf("Expected first position {5 2}, got {%d %d}", 
-			deserializedMsg.Cursor.Position[0].Digit, deserializedMsg.Cursor.Position[0].Node)
+	if !deserializedMsg.Presence[2].Offline {
+		t.Error("Expected user 4 to be offline")
 	}
 }
 
-func TestSelectionMessage(t *testing.T) {
-	startPos := []crdt.Identifier{{Digit: 1, Node: 1}}
-	endPos := []crdt.Identifier{{Digit: 5, Node: 1}}
-	
-	msg := NewSelectionMessage(startPos, endPos, 3, "Bob", "#0000FF")
-	
-	// Serialize
+func TestTransactionMessage(t *testing.T) {
+	ops := []*Operation{
+		NewInsertOperation([]crdt.Identifier{{Digit: 1, Node: 3}}, '/', 3, 7),
+		NewInsertOperation([]crdt.Identifier{{Digit: 2, Node: 3}}, '/', 3, 8),
+		NewDeleteOperation([]crdt.Identifier{{Digit: 9, Node: 1}}, 3, 8),
+	}
+	msg := NewTransactionMessage(ops, TransactionActionComment, 3, "Carol")
+
 	data, err := msg.Serialize()
 	if err != nil {
-		t.Fatalf("Failed to serialize selection message: %v", err)
+		t.Fatalf("Failed to serialize transaction message: %v", err)
 	}
-	
-	// Deserialize
+
 	deserializedMsg, err := Deserialize(data)
 	if err != nil {
-		t.Fatalf("Failed to deserialize selection message: %v", err)
+		t.Fatalf("Failed to deserialize transaction message: %v", err)
 	}
-	
-	// Verify
-	if deserializedMsg.Type != MessageTypeSelection {
-		t.Errorf("Expected type %s, got %s", MessageTypeSelection, deserializedMsg.Type)
+
+	if deserializedMsg.Type != MessageTypeTransaction {
+		t.Errorf("Expected type %s, got %s", MessageTypeTransaction, deserializedMsg.Type)
 	}
-	
-	if deserializedMsg.Selection.UserID != 3 {
-		t.Errorf("Expected user ID 3, got %d", deserializedMsg.Selection.UserID)
+	if len(deserializedMsg.Operations) != 3 {
+		t.Fatalf("Expected 3 operations, got %d", len(deserializedMsg.Operations))
 	}
-	
-	if deserializedMsg.Selection.UserName != "Bob" {
-		t.Errorf("Expected user name 'Bob', got '%s'", deserializedMsg.Selection.UserName)
+	if deserializedMsg.Operations[2].Type != OperationTypeDelete {
+		t.Errorf("Expected last operation to be a delete, got %s", deserializedMsg.Operations[2].Type)
 	}
-	
-	if deserializedMsg.Selection.Color != "#0000FF" {
-		t.Errorf("Expected color '#0000FF', got '%s'", deserializedMsg.Selection.Color)
+	if deserializedMsg.UserID != 3 {
+		t.Errorf("Expected user ID 3, got %d", deserializedMsg.UserID)
 	}
-	
-	if len(deserializedMsg.Selection.StartPosition) != 1 {
-		t.Errorf("Expected start position length 1, got %d", len(deserializedMsg.Selection.StartPosition))
+	if deserializedMsg.Action != TransactionActionComment {
+		t.Errorf("Expected action %s, got %s", TransactionActionComment, deserializedMsg.Action)
 	}
-	
-	if len(deserializedMsg.Selection.EndPosition) != 1 {
-		t.Errorf("Expected end position length 1, got %d", len(deserializedMsg.Selection.EndPosition))
+	if deserializedMsg.UserName != "Carol" {
+		t.Errorf("Expected user name 'Carol', got '%s'", deserializedMsg.UserName)
 	}
-	
-	if deserializedMsg.Selection.StartPosition[0].Digit != 1 {
-		t.Errorf("Expected start position digit 1, got %d", deserializedMsg.Selection.StartPosition[0].Digit)
+}
+
+func TestRolesMessage(t *testing.T) {
+	roles := map[int]Role{2: RoleReadOnly, 3: RoleEditor}
+	msg := NewRolesMessage(roles, 1)
+
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Failed to serialize roles message: %v", err)
 	}
-	
-	if deserializedMsg.Selection.EndPosition[0].Digit != 5 {
-		t.Errorf("Expected end position digit 5, got %d", deserializedMsg.Selection.EndPosition[0].Digit)
+
+	deserializedMsg, err := Deserialize(data)
+	if err != nil {
+		t.Fatalf("Failed to deserialize roles message: %v", err)
+	}
+
+	if deserializedMsg.Type != MessageTypeRoles {
+		t.Errorf("Expected type %s, got %s", MessageTypeRoles, deserializedMsg.Type)
+	}
+	if len(deserializedMsg.Roles) != 2 {
+		t.Fatalf("Expected 2 roles, got %d", len(deserializedMsg.Roles))
+	}
+	if deserializedMsg.Roles[2] != RoleReadOnly {
+		t.Errorf("Expected user 2 to be %s, got %s", RoleReadOnly, deserializedMsg.Roles[2])
+	}
+	if deserializedMsg.Roles[3] != RoleEditor {
+		t.Errorf("Expected user 3 to be %s, got %s", RoleEditor, deserializedMsg.Roles[3])
 	}
 }
 
-func TestClearSelectionMessage(t *testing.T) {
-	msg := NewSelectionMessage(nil, nil, 4, "Carol", "#FF00FF")
-	
-	// Serialize
+func TestMetadataMessage(t *testing.T) {
+	doc := crdt.FromText("abc", 1)
+	doc.Metadata.Language = "go"
+	doc.Protect("header", doc.Lines[0].Characters[0].Pos, doc.Lines[0].Characters[1].Pos)
+	msg := NewMetadataMessage(doc.Metadata, 1)
+
 	data, err := msg.Serialize()
 	if err != nil {
-		t.Fatalf("Failed to serialize clear selection message: %v", err)
+		t.Fatalf("Failed to serialize metadata message: %v", err)
 	}
-	
-	// Deserialize
+
 	deserializedMsg, err := Deserialize(data)
 	if err != nil {
-		t.Fatalf("Failed to deserialize clear selection message: %v", err)
+		t.Fatalf("Failed to deserialize metadata message: %v", err)
 	}
-	
-	// Verify
-	if deserializedMsg.Type != MessageTypeSelection {
-		t.Errorf("Expected type %s, got %s", MessageTypeSelection, deserializedMsg.Type)
+
+	if deserializedMsg.Type != MessageTypeMetadata {
+		t.Errorf("Expected type %s, got %s", MessageTypeMetadata, deserializedMsg.Type)
 	}
-	
-	if deserializedMsg.Selection.StartPosition != nil {
-		t.Errorf("Expected nil start position for clear selection, got %v", deserializedMsg.Selection.StartPosition)
+	if deserializedMsg.Metadata == nil {
+		t.Fatal("Expected metadata in message")
 	}
-	
-	if deserializedMsg.Selection.EndPosition != nil {
-		t.Errorf("Expected nil end position for clear selection, got %v", deserializedMsg.Selection.EndPosition)
+	if deserializedMsg.Metadata.Language != "go" {
+		t.Errorf("Expected language go, got %q", deserializedMsg.Metadata.Language)
 	}
-	
-	if deserializedMsg.Selection.UserID != 4 {
-		t.Errorf("Expected user ID 4, got %d", deserializedMsg.Selection.UserID)
+	if len(deserializedMsg.Metadata.Protected) != 1 || deserializedMsg.Metadata.Protected[0].Name != "header" {
+		t.Errorf("Expected the header region, got %v", deserializedMsg.Metadata.Protected)
 	}
-}
\ No newline at end of file
+}
+
+func TestReaderKeepsBufferedMessages(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	// Two messages arriving in a single read must both be received
+	var data []byte
+	for _, msg := range []*Message{NewInitMessage(nil, 1), NewErrorMessage("boom", 1)} {
+		serialized, err := msg.Serialize()
+		if err != nil {
+			t.Fatalf("Failed to serialize message: %v", err)
+		}
+		data = append(append(data, serialized...), '\n')
+	}
+	go func() { _, _ = remote.Write(data) }()
+
+	reader := NewReader(local)
+	first, err := reader.Receive()
+	if err != nil || first.Type != MessageTypeInit {
+		t.Fatalf("Expected init message, got %+v (%v)", first, err)
+	}
+	second, err := reader.Receive()
+	if err != nil || second.Type != MessageTypeError || second.Error != "boom" {
+		t.Fatalf("Expected error message, got %+v (%v)", second, err)
+	}
+}
+
+func TestProbeMessages(t *testing.T) {
+	for _, msg := range []*Message{NewProbeMessage(42, 1), NewProbeAckMessage(42, 2)} {
+		data, err := msg.Serialize()
+		if err != nil {
+			t.Fatalf("Failed to serialize %s message: %v", msg.Type, err)
+		}
+		deserializedMsg, err := Deserialize(data)
+		if err != nil {
+			t.Fatalf("Failed to deserialize %s message: %v", msg.Type, err)
+		}
+		if deserializedMsg.Type != msg.Type || deserializedMsg.ProbeID != 42 || deserializedMsg.UserID != msg.UserID {
+			t.Errorf("Expected %+v to round-trip, got %+v", msg, deserializedMsg)
+		}
+	}
+	if ack := NewProbeAckMessage(42, 2); ack.Type != MessageTypeAck {
+		t.Errorf("Expected probes to be answered with an ack, got %s", ack.Type)
+	}
+}
@@ -0,0 +1,198 @@
+package messages
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Direction says whether a message is on its way out to a peer or in from one
+type Direction int
+
+const (
+	// Outbound is a message being sent to a peer
+	Outbound Direction = iota
+	// Inbound is a message received from a peer
+	Inbound
+)
+
+func (d Direction) String() string {
+	if d == Inbound {
+		return "received"
+	}
+	return "sent"
+}
+
+// Middleware sees every message a Transport sends or receives, with the peer's
+// RemoteID, and returns the message to carry on with: the same one, a changed
+// copy, or nil to drop it. Outbound messages may be on their way to other peers
+// too, so change a copy rather than the message itself.
+type Middleware func(dir Direction, msg *Message, peer string) *Message
+
+var (
+	middlewareMutex sync.RWMutex
+	middlewares     []*Middleware
+)
+
+// Use adds middleware for every transport in the process, after any added
+// before, and returns a function removing it again
+func Use(mw Middleware) (remove func()) {
+	entry := &mw
+	middlewareMutex.Lock()
+	middlewares = append(middlewares, entry)
+	middlewareMutex.Unlock()
+
+	return func() {
+		middlewareMutex.Lock()
+		defer middlewareMutex.Unlock()
+		for i, m := range middlewares {
+			if m == entry {
+				middlewares = append(middlewares[:i:i], middlewares[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Intercept passes a message through the middleware in the order it was added,
+// returning nil if one dropped it. Transports call it with each message they
+// send and receive; those in this package already do.
+func Intercept(dir Direction, msg *Message, peer Transport) *Message {
+	middlewareMutex.RLock()
+	chain := middlewares
+	middlewareMutex.RUnlock()
+	if len(chain) == 0 {
+		return msg
+	}
+
+	id := peer.RemoteID()
+	for _, mw := range chain {
+		if msg = (*mw)(dir, msg, id); msg == nil {
+			return nil
+		}
+	}
+	return msg
+}
+
+// DebugLog returns middleware logging every message to logger, or the standard
+// logger if nil
+func DebugLog(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(dir Direction, msg *Message, peer string) *Message {
+		switch {
+		case msg.Seq != 0:
+			logger.Printf("%s %s %s from user %d, seq %d", peer, dir, msg.Type, msg.UserID, msg.Seq)
+		case msg.ProbeID != 0:
+			logger.Printf("%s %s %s from user %d, probe %d", peer, dir, msg.Type, msg.UserID, msg.ProbeID)
+		default:
+			logger.Printf("%s %s %s from user %d", peer, dir, msg.Type, msg.UserID)
+		}
+		return msg
+	}
+}
+
+// maxPendingLatency is how many unanswered messages a LatencyMeter times for
+// each peer; past it the oldest are forgotten
+const maxPendingLatency = 1024
+
+// LatencyStats summarizes how long a peer took to answer
+type LatencyStats struct {
+	Samples int
+	Last    time.Duration
+	Mean    time.Duration
+	Max     time.Duration
+}
+
+// LatencyMeter measures how long each peer takes to answer probes and to
+// acknowledge sequenced edits. Add its Middleware with Use.
+type LatencyMeter struct {
+	mutex   sync.Mutex
+	pending map[string][]pendingLatency
+	stats   map[string]*LatencyStats
+}
+
+// pendingLatency is a message timed until the peer answers it
+type pendingLatency struct {
+	probe  bool
+	id     int64 // The probe ID, or the sequence number
+	sentAt time.Time
+}
+
+// NewLatencyMeter creates a LatencyMeter with nothing measured yet
+func NewLatencyMeter() *LatencyMeter {
+	return &LatencyMeter{pending: make(map[string][]pendingLatency), stats: make(map[string]*LatencyStats)}
+}
+
+// Middleware returns the middleware timing messages for the meter
+func (m *LatencyMeter) Middleware() Middleware {
+	return func(dir Direction, msg *Message, peer string) *Message {
+		switch {
+		case dir == Outbound && msg.Type == MessageTypeProbe:
+			m.sent(peer, pendingLatency{probe: true, id: msg.ProbeID, sentAt: time.Now()})
+		case dir == Outbound && msg.Seq != 0 && msg.Type != MessageTypeAck:
+			m.sent(peer, pendingLatency{id: msg.Seq, sentAt: time.Now()})
+		case dir == Inbound && msg.Type == MessageTypeAck:
+			m.answered(peer, msg)
+		}
+		return msg
+	}
+}
+
+// Latency returns what was measured for a peer, by its RemoteID
+func (m *LatencyMeter) Latency(peer string) (LatencyStats, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stats, ok := m.stats[peer]
+	if !ok {
+		return LatencyStats{}, false
+	}
+	return *stats, true
+}
+
+// sent starts timing a message. A retransmission keeps the time first sent.
+func (m *LatencyMeter) sent(peer string, p pendingLatency) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	pending := m.pending[peer]
+	for _, old := range pending {
+		if old.probe == p.probe && old.id == p.id {
+			return
+		}
+	}
+	if len(pending) >= maxPendingLatency {
+		pending = pending[1:]
+	}
+	m.pending[peer] = append(pending, p)
+}
+
+// answered records how long the messages an ack answers took. A sequence ack
+// answers every edit up to its number.
+func (m *LatencyMeter) answered(peer string, ack *Message) {
+	now := time.Now()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	pending := m.pending[peer][:0]
+	for _, p := range m.pending[peer] {
+		if (p.probe && ack.ProbeID != 0 && p.id == ack.ProbeID) || (!p.probe && ack.Seq != 0 && p.id <= ack.Seq) {
+			m.record(peer, now.Sub(p.sentAt))
+			continue
+		}
+		pending = append(pending, p)
+	}
+	m.pending[peer] = pending
+}
+
+// record adds a sample to a peer's stats. The caller must hold m.mutex.
+func (m *LatencyMeter) record(peer string, d time.Duration) {
+	stats, ok := m.stats[peer]
+	if !ok {
+		stats = &LatencyStats{}
+		m.stats[peer] = stats
+	}
+	stats.Samples++
+	stats.Mean += (d - stats.Mean) / time.Duration(stats.Samples)
+	stats.Last = d
+	stats.Max = max(stats.Max, d)
+}
@@ -0,0 +1,113 @@
+package messages
+
+import (
+	"bytes"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	var mutex sync.Mutex
+	var seen []string
+	removeTrace := Use(func(dir Direction, msg *Message, peer string) *Message {
+		mutex.Lock()
+		defer mutex.Unlock()
+		seen = append(seen, dir.String()+" "+msg.Text)
+		return msg
+	})
+	defer removeTrace()
+	// Secrets never leave, and what arrives is shouted
+	removeFilter := Use(func(dir Direction, msg *Message, peer string) *Message {
+		switch {
+		case dir == Outbound && msg.Text == "secret":
+			return nil
+		case dir == Inbound:
+			loud := *msg
+			loud.Text = strings.ToUpper(msg.Text)
+			return &loud
+		}
+		return msg
+	})
+
+	a, b := NewPipe()
+	defer a.Close()
+	go func() {
+		for _, text := range []string{"hi", "secret", "bye"} {
+			_ = a.Send(NewClipMessage(text, 1, "Alice"))
+		}
+	}()
+	for _, want := range []string{"HI", "BYE"} {
+		msg, err := b.Receive()
+		if err != nil {
+			t.Fatalf("Receive: %v", err)
+		}
+		if msg.Text != want {
+			t.Errorf("Expected %q, got %q", want, msg.Text)
+		}
+	}
+	// Middleware added first sees messages first: what arrives before it is shouted
+	mutex.Lock()
+	slices.Sort(seen)
+	if want := "received bye,received hi,sent bye,sent hi,sent secret"; strings.Join(seen, ",") != want {
+		t.Errorf("Expected middleware to see %q, got %q", want, strings.Join(seen, ","))
+	}
+	mutex.Unlock()
+
+	// Removed middleware sees nothing more
+	removeFilter()
+	go func() { _ = a.Send(NewClipMessage("secret", 1, "Alice")) }()
+	if msg, err := b.Receive(); err != nil || msg.Text != "secret" {
+		t.Errorf("Expected the message untouched, got %+v (%v)", msg, err)
+	}
+}
+
+func TestDebugLog(t *testing.T) {
+	var buf bytes.Buffer
+	mw := DebugLog(log.New(&buf, "", 0))
+	msg := NewProbeMessage(7, 1)
+	if mw(Outbound, msg, "peer:1") != msg {
+		t.Error("Expected the message passed on unchanged")
+	}
+	if got := buf.String(); got != "peer:1 sent probe from user 1, probe 7\n" {
+		t.Errorf("Unexpected log: %q", got)
+	}
+}
+
+func TestLatencyMeter(t *testing.T) {
+	meter := NewLatencyMeter()
+	mw := meter.Middleware()
+	if _, ok := meter.Latency("peer"); ok {
+		t.Error("Expected nothing measured yet")
+	}
+
+	// A probe is answered by its ack
+	mw(Outbound, NewProbeMessage(7, 1), "peer")
+	time.Sleep(5 * time.Millisecond)
+	mw(Inbound, NewProbeAckMessage(7, 2), "peer")
+	stats, ok := meter.Latency("peer")
+	if !ok || stats.Samples != 1 || stats.Last < 5*time.Millisecond || stats.Max != stats.Last || stats.Mean != stats.Last {
+		t.Errorf("Expected the probe timed, got %+v (%v)", stats, ok)
+	}
+
+	// A sequence ack answers every edit up to it
+	for seq := int64(1); seq <= 3; seq++ {
+		edit := &Message{Type: MessageTypeOperation, Seq: seq}
+		mw(Outbound, edit, "peer")
+		mw(Outbound, edit, "peer") // Retransmitted
+	}
+	mw(Inbound, NewSeqAckMessage(2, 2), "peer")
+	if stats, _ = meter.Latency("peer"); stats.Samples != 3 {
+		t.Errorf("Expected two edits timed, got %+v", stats)
+	}
+	mw(Inbound, NewSeqAckMessage(3, 2), "peer")
+	if stats, _ = meter.Latency("peer"); stats.Samples != 4 {
+		t.Errorf("Expected the last edit timed, got %+v", stats)
+	}
+	if _, ok := meter.Latency("other"); ok {
+		t.Error("Expected peers measured apart")
+	}
+}
@@ -0,0 +1,860 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+
+	"gollaborate/crdt"
+	"gollaborate/presence"
+)
+
+// ErrInvalidMsgpack is returned when a MessagePack message cannot be decoded
+var ErrInvalidMsgpack = errors.New("invalid msgpack message")
+
+// maxMsgpackDepth bounds the nesting of decoded values, so a corrupt message
+// cannot exhaust the stack
+const maxMsgpackDepth = 64
+
+// msgpackCodec encodes messages as MessagePack maps keyed like their JSON. Fields
+// at their zero value are left out, and identifiers are [digit, node] pairs, so
+// positions take a few bytes each. Documents and metadata keep the shape of their
+// JSON, and with it the document format version, in MessagePack form.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+func (msgpackCodec) Tag() byte    { return 0x02 }
+
+func (msgpackCodec) Marshal(msg *Message) ([]byte, error) {
+	var w mpMapWriter
+	w.string("type", string(msg.Type))
+	if msg.Operation != nil {
+		w.key("operation")
+		w.b = mpAppendOperation(w.b, msg.Operation)
+	}
+	if len(msg.Operations) > 0 {
+		w.key("operations")
+		w.b = mpAppendArrayHeader(w.b, len(msg.Operations))
+		for _, op := range msg.Operations {
+			w.b = mpAppendOperation(w.b, op)
+		}
+	}
+	w.string("action", string(msg.Action))
+	w.string("user_name", msg.UserName)
+	if msg.Document != nil {
+		w.key("document")
+		if err := w.json(msg.Document); err != nil {
+			return nil, err
+		}
+	}
+	if len(msg.Presence) > 0 {
+		w.key("presence")
+		w.b = mpAppendArrayHeader(w.b, len(msg.Presence))
+		for _, state := range msg.Presence {
+			w.b = mpAppendPresence(w.b, state)
+		}
+	}
+	if len(msg.Roles) > 0 {
+		userIDs := make([]int, 0, len(msg.Roles))
+		for userID := range msg.Roles {
+			userIDs = append(userIDs, userID)
+		}
+		sort.Ints(userIDs)
+		w.key("roles")
+		w.b = mpAppendMapHeader(w.b, len(userIDs))
+		for _, userID := range userIDs {
+			w.b = mpAppendString(w.b, strconv.Itoa(userID))
+			w.b = mpAppendString(w.b, string(msg.Roles[userID]))
+		}
+	}
+	if msg.Metadata != nil {
+		w.key("metadata")
+		if err := w.json(msg.Metadata); err != nil {
+			return nil, err
+		}
+	}
+	w.int("user_id", int64(msg.UserID))
+	w.string("error", msg.Error)
+	w.int("probe_id", msg.ProbeID)
+	if len(msg.Codecs) > 0 {
+		w.key("codecs")
+		w.b = mpAppendArrayHeader(w.b, len(msg.Codecs))
+		for _, name := range msg.Codecs {
+			w.b = mpAppendString(w.b, name)
+		}
+	}
+	w.int("version", int64(msg.Version))
+	w.int("min_version", int64(msg.MinVersion))
+	w.string("color", msg.Color)
+	w.int("seq", msg.Seq)
+	w.string("text", msg.Text)
+	w.string("event", string(msg.Event))
+	w.int("proposal_id", msg.ProposalID)
+	w.bool("approve", msg.Approve)
+	w.int("sent_at", msg.SentAt)
+	if len(msg.Clocks) > 0 {
+		userIDs := make([]int, 0, len(msg.Clocks))
+		for userID := range msg.Clocks {
+			userIDs = append(userIDs, userID)
+		}
+		sort.Ints(userIDs)
+		w.key("clocks")
+		w.b = mpAppendMapHeader(w.b, len(userIDs))
+		for _, userID := range userIDs {
+			w.b = mpAppendString(w.b, strconv.Itoa(userID))
+			w.b = mpAppendInt(w.b, int64(msg.Clocks[userID]))
+		}
+	}
+	w.string("code", string(msg.Code))
+	w.int("retry_after", msg.RetryAfter)
+	w.int("origin", int64(msg.Origin))
+	w.int("origin_seq", msg.OriginSeq)
+	if len(msg.Strategies) > 0 {
+		w.key("strategies")
+		w.b = mpAppendArrayHeader(w.b, len(msg.Strategies))
+		for _, strategy := range msg.Strategies {
+			w.b = mpAppendString(w.b, string(strategy))
+		}
+	}
+	if msg.Attachment != nil {
+		w.key("attachment")
+		if err := w.json(msg.Attachment); err != nil {
+			return nil, err
+		}
+	}
+	if msg.DocMeta != nil {
+		w.key("doc_meta")
+		if err := w.json(msg.DocMeta); err != nil {
+			return nil, err
+		}
+	}
+	w.int("chunk", int64(msg.Chunk))
+	w.int("channel", int64(msg.Channel))
+	w.string("room", msg.Room)
+	w.string("token", msg.Token)
+	w.string("command", string(msg.Command))
+	w.int("target", int64(msg.Target))
+	w.int("at", msg.At)
+	w.string("session", msg.Session)
+	w.int("order", msg.Order)
+	w.string("format", string(msg.Format))
+	w.string("template", msg.Template)
+	w.bool("spectate", msg.Spectate)
+	w.string("digest", msg.Digest)
+	w.string("join_code", msg.JoinCode)
+	if len(msg.Rooms) > 0 {
+		w.key("rooms")
+		if err := w.json(msg.Rooms); err != nil {
+			return nil, err
+		}
+	}
+	if len(msg.Roster) > 0 {
+		w.key("roster")
+		if err := w.json(msg.Roster); err != nil {
+			return nil, err
+		}
+	}
+	if len(msg.Data) > 0 {
+		w.key("data")
+		w.b = mpAppendBinary(w.b, msg.Data)
+	}
+	if len(msg.Chunks) > 0 {
+		w.key("chunks")
+		w.b = mpAppendArrayHeader(w.b, len(msg.Chunks))
+		for _, chunk := range msg.Chunks {
+			w.b = mpAppendInt(w.b, int64(chunk))
+		}
+	}
+	return w.appendTo(nil), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte) (*Message, error) {
+	v, rest, err := mpRead(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, ErrInvalidMsgpack
+	}
+	fields, err := mpMap(v)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &Message{}
+	for key, value := range fields {
+		var s string
+		var n int64
+		switch key {
+		case "type":
+			s, err = mpString(value)
+			msg.Type = MessageType(s)
+		case "operation":
+			msg.Operation, err = mpOperation(value)
+		case "operations":
+			err = mpEach(value, func(v any) error {
+				op, err := mpOperation(v)
+				msg.Operations = append(msg.Operations, op)
+				return err
+			})
+		case "action":
+			s, err = mpString(value)
+			msg.Action = TransactionAction(s)
+		case "user_name":
+			msg.UserName, err = mpString(value)
+		case "document":
+			msg.Document = &crdt.Document{}
+			err = mpJSON(value, msg.Document)
+		case "presence":
+			err = mpEach(value, func(v any) error {
+				state, err := mpPresence(v)
+				msg.Presence = append(msg.Presence, state)
+				return err
+			})
+		case "roles":
+			msg.Roles, err = mpRoles(value)
+		case "metadata":
+			msg.Metadata = &crdt.Metadata{}
+			err = mpJSON(value, msg.Metadata)
+		case "user_id":
+			n, err = mpInt(value)
+			msg.UserID = int(n)
+		case "error":
+			msg.Error, err = mpString(value)
+		case "probe_id":
+			msg.ProbeID, err = mpInt(value)
+		case "codecs":
+			err = mpEach(value, func(v any) error {
+				name, err := mpString(v)
+				msg.Codecs = append(msg.Codecs, name)
+				return err
+			})
+		case "version":
+			n, err = mpInt(value)
+			msg.Version = int(n)
+		case "min_version":
+			n, err = mpInt(value)
+			msg.MinVersion = int(n)
+		case "color":
+			msg.Color, err = mpString(value)
+		case "seq":
+			msg.Seq, err = mpInt(value)
+		case "text":
+			msg.Text, err = mpString(value)
+		case "event":
+			var s string
+			s, err = mpString(value)
+			msg.Event = PresenceEvent(s)
+		case "proposal_id":
+			msg.ProposalID, err = mpInt(value)
+		case "approve":
+			msg.Approve, err = mpBool(value)
+		case "sent_at":
+			msg.SentAt, err = mpInt(value)
+		case "clocks":
+			msg.Clocks, err = mpClocks(value)
+		case "code":
+			var s string
+			s, err = mpString(value)
+			msg.Code = ErrorCode(s)
+		case "retry_after":
+			msg.RetryAfter, err = mpInt(value)
+		case "origin":
+			n, err = mpInt(value)
+			msg.Origin = int(n)
+		case "origin_seq":
+			msg.OriginSeq, err = mpInt(value)
+		case "strategies":
+			err = mpEach(value, func(v any) error {
+				name, err := mpString(v)
+				msg.Strategies = append(msg.Strategies, SyncStrategy(name))
+				return err
+			})
+		case "attachment":
+			msg.Attachment = &AttachmentInfo{}
+			err = mpJSON(value, msg.Attachment)
+		case "doc_meta":
+			msg.DocMeta = &DocMeta{}
+			err = mpJSON(value, msg.DocMeta)
+		case "chunk":
+			n, err = mpInt(value)
+			msg.Chunk = int(n)
+		case "channel":
+			n, err = mpInt(value)
+			msg.Channel = int(n)
+		case "room":
+			msg.Room, err = mpString(value)
+		case "token":
+			msg.Token, err = mpString(value)
+		case "command":
+			var s string
+			s, err = mpString(value)
+			msg.Command = AdminCommand(s)
+		case "target":
+			n, err = mpInt(value)
+			msg.Target = int(n)
+		case "at":
+			msg.At, err = mpInt(value)
+		case "session":
+			msg.Session, err = mpString(value)
+		case "order":
+			msg.Order, err = mpInt(value)
+		case "format":
+			var s string
+			s, err = mpString(value)
+			msg.Format = ExportFormat(s)
+		case "template":
+			msg.Template, err = mpString(value)
+		case "spectate":
+			msg.Spectate, err = mpBool(value)
+		case "digest":
+			msg.Digest, err = mpString(value)
+		case "join_code":
+			msg.JoinCode, err = mpString(value)
+		case "rooms":
+			err = mpJSON(value, &msg.Rooms)
+		case "roster":
+			err = mpJSON(value, &msg.Roster)
+		case "data":
+			data, ok := value.([]byte)
+			if !ok {
+				return nil, ErrInvalidMsgpack
+			}
+			msg.Data = data
+		case "chunks":
+			err = mpEach(value, func(v any) error {
+				chunk, err := mpInt(v)
+				msg.Chunks = append(msg.Chunks, int(chunk))
+				return err
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+func mpAppendOperation(b []byte, op *Operation) []byte {
+	var w mpMapWriter
+	w.string("type", string(op.Type))
+	w.position("position", op.Position)
+	w.int("character", int64(op.Character))
+	w.int("user_id", int64(op.UserID))
+	w.int("clock", int64(op.Clock))
+	return w.appendTo(b)
+}
+
+func mpOperation(v any) (*Operation, error) {
+	fields, err := mpMap(v)
+	if err != nil {
+		return nil, err
+	}
+	op := &Operation{}
+	for key, value := range fields {
+		var s string
+		var n int64
+		switch key {
+		case "type":
+			s, err = mpString(value)
+			op.Type = OperationType(s)
+		case "position":
+			op.Position, err = mpPosition(value)
+		case "character":
+			n, err = mpInt(value)
+			op.Character = rune(n)
+		case "user_id":
+			n, err = mpInt(value)
+			op.UserID = int(n)
+		case "clock":
+			n, err = mpInt(value)
+			op.Clock = int(n)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return op, nil
+}
+
+func mpAppendPresence(b []byte, state presence.State) []byte {
+	var w mpMapWriter
+	w.int("user_id", int64(state.UserID))
+	w.string("user_name", state.UserName)
+	w.string("color", state.Color)
+	w.position("cursor", state.Cursor)
+	w.position("selection_start", state.SelectionStart)
+	w.bool("selecting", state.Selecting)
+	w.int("clock", int64(state.Clock))
+	w.bool("offline", state.Offline)
+	return w.appendTo(b)
+}
+
+func mpPresence(v any) (presence.State, error) {
+	var state presence.State
+	fields, err := mpMap(v)
+	if err != nil {
+		return state, err
+	}
+	for key, value := range fields {
+		var n int64
+		switch key {
+		case "user_id":
+			n, err = mpInt(value)
+			state.UserID = int(n)
+		case "user_name":
+			state.UserName, err = mpString(value)
+		case "color":
+			state.Color, err = mpString(value)
+		case "cursor":
+			state.Cursor, err = mpPosition(value)
+		case "selection_start":
+			state.SelectionStart, err = mpPosition(value)
+		case "selecting":
+			state.Selecting, err = mpBool(value)
+		case "clock":
+			n, err = mpInt(value)
+			state.Clock = int(n)
+		case "offline":
+			state.Offline, err = mpBool(value)
+		}
+		if err != nil {
+			return state, err
+		}
+	}
+	return state, nil
+}
+
+func mpClocks(v any) (map[int]int, error) {
+	fields, err := mpMap(v)
+	if err != nil {
+		return nil, err
+	}
+	clocks := make(map[int]int, len(fields))
+	for key, value := range fields {
+		userID, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, ErrInvalidMsgpack
+		}
+		clock, err := mpInt(value)
+		if err != nil {
+			return nil, err
+		}
+		clocks[userID] = int(clock)
+	}
+	return clocks, nil
+}
+
+func mpRoles(v any) (map[int]Role, error) {
+	fields, err := mpMap(v)
+	if err != nil {
+		return nil, err
+	}
+	roles := make(map[int]Role, len(fields))
+	for key, value := range fields {
+		userID, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, ErrInvalidMsgpack
+		}
+		role, err := mpString(value)
+		if err != nil {
+			return nil, err
+		}
+		roles[userID] = Role(role)
+	}
+	return roles, nil
+}
+
+func mpPosition(v any) ([]crdt.Identifier, error) {
+	var pos []crdt.Identifier
+	err := mpEach(v, func(v any) error {
+		pair, ok := v.([]any)
+		if !ok || len(pair) != 2 {
+			return ErrInvalidMsgpack
+		}
+		digit, err := mpInt(pair[0])
+		if err != nil {
+			return err
+		}
+		node, err := mpInt(pair[1])
+		if err != nil {
+			return err
+		}
+		pos = append(pos, crdt.Identifier{Digit: int(digit), Node: int(node)})
+		return nil
+	})
+	return pos, err
+}
+
+// mpJSON decodes a value that was encoded from JSON into out
+func mpJSON(v any, out any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ErrInvalidMsgpack
+	}
+	return json.Unmarshal(data, out)
+}
+
+// mpMapWriter builds a map, counting its entries so the header can go first
+type mpMapWriter struct {
+	b []byte
+	n int
+}
+
+func (w *mpMapWriter) key(key string) {
+	w.b = mpAppendString(w.b, key)
+	w.n++
+}
+
+// int adds an integer entry, leaving it out when zero
+func (w *mpMapWriter) int(key string, n int64) {
+	if n != 0 {
+		w.key(key)
+		w.b = mpAppendInt(w.b, n)
+	}
+}
+
+// bool adds a boolean entry, leaving it out when false
+func (w *mpMapWriter) bool(key string, v bool) {
+	if v {
+		w.key(key)
+		w.b = append(w.b, 0xc3)
+	}
+}
+
+// string adds a string entry, leaving it out when empty
+func (w *mpMapWriter) string(key string, s string) {
+	if s != "" {
+		w.key(key)
+		w.b = mpAppendString(w.b, s)
+	}
+}
+
+// position adds a position as an array of [digit, node] pairs, leaving it out when empty
+func (w *mpMapWriter) position(key string, pos []crdt.Identifier) {
+	if len(pos) == 0 {
+		return
+	}
+	w.key(key)
+	w.b = mpAppendArrayHeader(w.b, len(pos))
+	for _, ident := range pos {
+		w.b = mpAppendArrayHeader(w.b, 2)
+		w.b = mpAppendInt(w.b, int64(ident.Digit))
+		w.b = mpAppendInt(w.b, int64(ident.Node))
+	}
+}
+
+// json adds the value v has as JSON
+func (w *mpMapWriter) json(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var tree any
+	if err := decoder.Decode(&tree); err != nil {
+		return err
+	}
+	w.b = mpAppendValue(w.b, tree)
+	return nil
+}
+
+func (w *mpMapWriter) appendTo(b []byte) []byte {
+	b = mpAppendMapHeader(b, w.n)
+	return append(b, w.b...)
+}
+
+// mpAppendValue appends a value decoded from JSON
+func mpAppendValue(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0)
+	case bool:
+		if v {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return mpAppendInt(b, n)
+		}
+		f, _ := v.Float64()
+		b = append(b, 0xcb)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(f))
+	case string:
+		return mpAppendString(b, v)
+	case []any:
+		b = mpAppendArrayHeader(b, len(v))
+		for _, item := range v {
+			b = mpAppendValue(b, item)
+		}
+		return b
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b = mpAppendMapHeader(b, len(keys))
+		for _, key := range keys {
+			b = mpAppendString(b, key)
+			b = mpAppendValue(b, v[key])
+		}
+		return b
+	}
+	panic("msgpack: unexpected JSON value")
+}
+
+// mpAppendInt appends an integer in its shortest form
+func mpAppendInt(b []byte, n int64) []byte {
+	switch {
+	case n >= 0 && n <= math.MaxInt8:
+		return append(b, byte(n))
+	case n < 0 && n >= -32:
+		return append(b, byte(n))
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		return append(b, 0xd0, byte(n))
+	case n >= 0 && n <= math.MaxUint8:
+		return append(b, 0xcc, byte(n))
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(n))
+	case n >= 0 && n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(n))
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(n))
+	case n >= 0 && n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(n))
+}
+
+func mpAppendString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func mpAppendBinary(b []byte, data []byte) []byte {
+	switch n := len(data); {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xc5), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xc6), uint32(n))
+	}
+	return append(b, data...)
+}
+
+func mpAppendArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xdc), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdd), uint32(n))
+}
+
+func mpAppendMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdf), uint32(n))
+}
+
+// mpRead decodes the value at the start of data into nil, a bool, an int64, a
+// float64, a string, []any or map[string]any, and returns the bytes after it
+func mpRead(data []byte, depth int) (any, []byte, error) {
+	if len(data) == 0 || depth > maxMsgpackDepth {
+		return nil, nil, ErrInvalidMsgpack
+	}
+	b, data := data[0], data[1:]
+	switch {
+	case b <= 0x7f:
+		return int64(b), data, nil
+	case b >= 0xe0:
+		return int64(int8(b)), data, nil
+	case b&0xe0 == 0xa0:
+		return mpReadString(data, int(b&0x1f))
+	case b&0xf0 == 0x90:
+		return mpReadArray(data, int(b&0x0f), depth)
+	case b&0xf0 == 0x80:
+		return mpReadMap(data, int(b&0x0f), depth)
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, data, nil
+	case 0xc2:
+		return false, data, nil
+	case 0xc3:
+		return true, data, nil
+	case 0xcb:
+		n, rest, err := mpReadUint(data, 8)
+		return math.Float64frombits(n), rest, err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, rest, err := mpReadUint(data, 1<<(b-0xcc))
+		if n > math.MaxInt64 {
+			return nil, nil, ErrInvalidMsgpack
+		}
+		return int64(n), rest, err
+	case 0xd0:
+		n, rest, err := mpReadUint(data, 1)
+		return int64(int8(n)), rest, err
+	case 0xd1:
+		n, rest, err := mpReadUint(data, 2)
+		return int64(int16(n)), rest, err
+	case 0xd2:
+		n, rest, err := mpReadUint(data, 4)
+		return int64(int32(n)), rest, err
+	case 0xd3:
+		n, rest, err := mpReadUint(data, 8)
+		return int64(n), rest, err
+	case 0xd9, 0xda, 0xdb:
+		n, rest, err := mpReadUint(data, 1<<(b-0xd9))
+		if err != nil {
+			return nil, nil, err
+		}
+		return mpReadString(rest, int(n))
+	case 0xc4, 0xc5, 0xc6:
+		n, rest, err := mpReadUint(data, 1<<(b-0xc4))
+		if err != nil {
+			return nil, nil, err
+		}
+		if int(n) > len(rest) {
+			return nil, nil, ErrInvalidMsgpack
+		}
+		return bytes.Clone(rest[:n]), rest[n:], nil
+	case 0xdc, 0xdd:
+		n, rest, err := mpReadUint(data, 2<<(b-0xdc))
+		if err != nil {
+			return nil, nil, err
+		}
+		return mpReadArray(rest, int(n), depth)
+	case 0xde, 0xdf:
+		n, rest, err := mpReadUint(data, 2<<(b-0xde))
+		if err != nil {
+			return nil, nil, err
+		}
+		return mpReadMap(rest, int(n), depth)
+	}
+	return nil, nil, ErrInvalidMsgpack
+}
+
+// mpReadUint reads a big-endian unsigned integer of size bytes
+func mpReadUint(data []byte, size int) (uint64, []byte, error) {
+	if len(data) < size {
+		return 0, nil, ErrInvalidMsgpack
+	}
+	var n uint64
+	for _, b := range data[:size] {
+		n = n<<8 | uint64(b)
+	}
+	return n, data[size:], nil
+}
+
+func mpReadString(data []byte, n int) (any, []byte, error) {
+	if n < 0 || n > len(data) {
+		return nil, nil, ErrInvalidMsgpack
+	}
+	return string(data[:n]), data[n:], nil
+}
+
+func mpReadArray(data []byte, n int, depth int) (any, []byte, error) {
+	// Every element takes at least a byte
+	if n < 0 || n > len(data) {
+		return nil, nil, ErrInvalidMsgpack
+	}
+	items := make([]any, n)
+	for i := range items {
+		var err error
+		if items[i], data, err = mpRead(data, depth+1); err != nil {
+			return nil, nil, err
+		}
+	}
+	return items, data, nil
+}
+
+func mpReadMap(data []byte, n int, depth int) (any, []byte, error) {
+	if n < 0 || 2*n > len(data) {
+		return nil, nil, ErrInvalidMsgpack
+	}
+	fields := make(map[string]any, n)
+	for i := 0; i < n; i++ {
+		key, rest, err := mpRead(data, depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, nil, ErrInvalidMsgpack
+		}
+		if fields[name], data, err = mpRead(rest, depth+1); err != nil {
+			return nil, nil, err
+		}
+	}
+	return fields, data, nil
+}
+
+func mpMap(v any) (map[string]any, error) {
+	fields, ok := v.(map[string]any)
+	if !ok {
+		return nil, ErrInvalidMsgpack
+	}
+	return fields, nil
+}
+
+// mpEach calls fn with every element of an array
+func mpEach(v any, fn func(any) error) error {
+	items, ok := v.([]any)
+	if !ok {
+		return ErrInvalidMsgpack
+	}
+	for _, item := range items {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mpInt(v any) (int64, error) {
+	n, ok := v.(int64)
+	if !ok {
+		return 0, ErrInvalidMsgpack
+	}
+	return n, nil
+}
+
+func mpBool(v any) (bool, error) {
+	b, ok := v.(bool)
+	if !ok {
+		return false, ErrInvalidMsgpack
+	}
+	return b, nil
+}
+
+func mpString(v any) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", ErrInvalidMsgpack
+	}
+	return s, nil
+}
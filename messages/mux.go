@@ -0,0 +1,232 @@
+package messages
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Mux carries several logical channels over one transport, such as one per
+// document a client has open, or a control channel beside a data channel.
+// Every message names its channel; those from peers that know nothing of
+// channels are on channel 0. Each channel is a Transport of its own, so an
+// EditorState or Server can take it like any connection.
+type Mux struct {
+	transport Transport
+
+	mutex    sync.Mutex
+	channels map[int]*muxChannel
+	opened   chan *muxChannel // Channels the peer opened, for Accept
+	err      error            // Why the transport stopped, once it has
+	done     chan struct{}
+}
+
+// NewMux starts multiplexing a transport. Nothing else should receive from it.
+func NewMux(t Transport) *Mux {
+	m := &Mux{
+		transport: t,
+		channels:  make(map[int]*muxChannel),
+		opened:    make(chan *muxChannel, 16),
+		done:      make(chan struct{}),
+	}
+	go m.receive()
+	return m
+}
+
+// Channel returns the channel with the given ID, opening it if need be. The
+// peer learns of it with the first message sent on it.
+func (m *Mux) Channel(id int) Transport {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	c, _ := m.channel(id)
+	return c
+}
+
+// Accept waits for the peer to open a channel this side has not, returning it
+// with its ID. It fails once the transport has.
+func (m *Mux) Accept() (int, Transport, error) {
+	select {
+	case c := <-m.opened:
+		return c.id, c, nil
+	case <-m.done:
+		return 0, nil, m.failure()
+	}
+}
+
+// Close closes every channel and the transport under them
+func (m *Mux) Close() error {
+	return m.transport.Close()
+}
+
+// channel returns the channel with the given ID, opening it if need be, and
+// whether it is new. The caller must hold m.mutex.
+func (m *Mux) channel(id int) (*muxChannel, bool) {
+	if c, ok := m.channels[id]; ok {
+		return c, false
+	}
+	c := &muxChannel{mux: m, id: id, ready: make(chan struct{}, 1), err: m.err}
+	m.channels[id] = c
+	return c, true
+}
+
+// receive hands each message from the transport to its channel until the
+// transport fails. Channels queue what they are sent, so one channel not
+// being read never holds up the others.
+func (m *Mux) receive() {
+	for {
+		msg, err := m.transport.Receive()
+		if err != nil {
+			m.stop(err)
+			return
+		}
+		m.mutex.Lock()
+		c, opened := m.channel(msg.Channel)
+		m.mutex.Unlock()
+		if opened {
+			select {
+			case m.opened <- c:
+			default:
+				// Nobody is accepting: the channel waits for Channel
+			}
+		}
+		c.deliver(msg)
+	}
+}
+
+// stop fails every channel with the transport's error
+func (m *Mux) stop(err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.err = err
+	for _, c := range m.channels {
+		c.fail(err)
+	}
+	close(m.done)
+}
+
+func (m *Mux) failure() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.err
+}
+
+// muxChannel is one channel of a Mux
+type muxChannel struct {
+	mux *Mux
+	id  int
+
+	mutex  sync.Mutex
+	inbox  []*Message
+	ready  chan struct{} // Signalled when the inbox fills or the channel fails
+	err    error
+	closed bool
+}
+
+// stamp returns a copy of the message marked with the channel, leaving the
+// original to others it may be on its way to
+func (c *muxChannel) stamp(msg *Message) *Message {
+	stamped := *msg
+	stamped.Channel = c.id
+	return &stamped
+}
+
+func (c *muxChannel) Send(msg *Message) error {
+	return c.SendWith(msg, JSON)
+}
+
+func (c *muxChannel) SendWith(msg *Message, codec Codec) error {
+	if err := c.sendable(); err != nil {
+		return err
+	}
+	return SendWith(c.mux.transport, c.stamp(msg), codec)
+}
+
+func (c *muxChannel) SendBatch(msgs []*Message, codec Codec) error {
+	if err := c.sendable(); err != nil {
+		return err
+	}
+	stamped := make([]*Message, len(msgs))
+	for i, msg := range msgs {
+		stamped[i] = c.stamp(msg)
+	}
+	return SendBatch(c.mux.transport, stamped, codec)
+}
+
+// sendable returns why nothing can be sent on the channel, if anything
+func (c *muxChannel) sendable() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	return c.err
+}
+
+func (c *muxChannel) Receive() (*Message, error) {
+	for {
+		c.mutex.Lock()
+		if c.closed {
+			c.mutex.Unlock()
+			return nil, net.ErrClosed
+		}
+		if len(c.inbox) > 0 {
+			msg := c.inbox[0]
+			c.inbox = c.inbox[1:]
+			c.mutex.Unlock()
+			return msg, nil
+		}
+		if c.err != nil {
+			c.mutex.Unlock()
+			return nil, c.err
+		}
+		c.mutex.Unlock()
+		<-c.ready
+	}
+}
+
+// Close closes the channel on this side; the transport and other channels
+// stay open. Messages the peer sends on it afterwards open it again.
+func (c *muxChannel) Close() error {
+	c.mux.mutex.Lock()
+	if c.mux.channels[c.id] == c {
+		delete(c.mux.channels, c.id)
+	}
+	c.mux.mutex.Unlock()
+
+	c.mutex.Lock()
+	c.closed = true
+	c.inbox = nil
+	c.mutex.Unlock()
+	c.signal()
+	return nil
+}
+
+func (c *muxChannel) RemoteID() string {
+	return fmt.Sprintf("%s#%d", c.mux.transport.RemoteID(), c.id)
+}
+
+// deliver queues a message for Receive
+func (c *muxChannel) deliver(msg *Message) {
+	c.mutex.Lock()
+	if !c.closed {
+		c.inbox = append(c.inbox, msg)
+	}
+	c.mutex.Unlock()
+	c.signal()
+}
+
+// fail makes Receive fail with err once the inbox is empty
+func (c *muxChannel) fail(err error) {
+	c.mutex.Lock()
+	c.err = err
+	c.mutex.Unlock()
+	c.signal()
+}
+
+// signal wakes Receive, if it waits
+func (c *muxChannel) signal() {
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+}
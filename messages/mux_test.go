@@ -0,0 +1,76 @@
+package messages
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestMux(t *testing.T) {
+	a, b := NewPipe()
+	muxA, muxB := NewMux(a), NewMux(b)
+	defer muxA.Close()
+
+	// Channels the peer opens are accepted, each with its own messages
+	go func() {
+		_ = muxA.Channel(1).Send(NewClipMessage("one", 1, "Alice"))
+		_ = muxA.Channel(2).Send(NewClipMessage("two", 1, "Alice"))
+	}()
+	for _, want := range []struct {
+		id   int
+		text string
+	}{{1, "one"}, {2, "two"}} {
+		id, ch, err := muxB.Accept()
+		if err != nil {
+			t.Fatalf("Accept: %v", err)
+		}
+		if id != want.id {
+			t.Errorf("Expected channel %d, got %d", want.id, id)
+		}
+		msg, err := ch.Receive()
+		if err != nil || msg.Text != want.text || msg.Channel != want.id {
+			t.Errorf("Expected %q on channel %d, got %+v (%v)", want.text, want.id, msg, err)
+		}
+	}
+
+	// A channel nobody reads holds up no other
+	go func() {
+		for i := 0; i < 5; i++ {
+			_ = muxA.Channel(1).Send(NewClipMessage("unread", 1, "Alice"))
+		}
+		_ = muxA.Channel(2).Send(NewClipMessage("read", 1, "Alice"))
+	}()
+	if msg, err := muxB.Channel(2).Receive(); err != nil || msg.Text != "read" {
+		t.Errorf("Expected to read past the unread channel, got %+v (%v)", msg, err)
+	}
+
+	// Messages naming no channel, from peers that know none, are on channel 0
+	go func() { _ = a.Send(NewClipMessage("plain", 1, "Alice")) }()
+	if msg, err := muxB.Channel(0).Receive(); err != nil || msg.Text != "plain" {
+		t.Errorf("Expected a plain message on channel 0, got %+v (%v)", msg, err)
+	}
+	if id := muxB.Channel(1).RemoteID(); id != b.RemoteID()+"#1" {
+		t.Errorf("Expected the channel named after its transport, got %q", id)
+	}
+
+	// Closing a channel leaves the others open; closing the transport ends them all
+	_ = muxB.Channel(2).Close()
+	if err := muxB.Channel(3).Send(NewClipMessage("still", 2, "Bob")); err != nil {
+		t.Errorf("Expected other channels to stay open, got %v", err)
+	}
+	_ = a.Close()
+	if _, err := muxB.Channel(1).Receive(); err != nil {
+		t.Errorf("Expected messages already received to be read first, got %v", err)
+	}
+	for {
+		if _, err := muxB.Channel(1).Receive(); err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				t.Errorf("Expected the channel closed with the transport, got %v", err)
+			}
+			break
+		}
+	}
+	if _, _, err := muxB.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Expected Accept to fail once the transport has, got %v", err)
+	}
+}
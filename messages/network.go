@@ -0,0 +1,200 @@
+package messages
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Conn is a connection between two nodes that messages are sent and received
+// over. Every network's connections are byte streams, whatever carries them.
+type Conn = net.Conn
+
+// Listener accepts the connections other nodes make to this one
+type Listener interface {
+	Accept() (Conn, error)
+	Close() error
+	Addr() net.Addr
+}
+
+// Network makes connections between nodes. Editors, servers and tests use it
+// rather than a particular one, so new ones plug in without changes elsewhere.
+type Network interface {
+	// Name identifies the network in flags and logs
+	Name() string
+	Dial(addr string) (Conn, error)
+	Listen(addr string) (Listener, error)
+}
+
+// TCP is the network nodes use unless told otherwise
+var TCP Network = tcpNetwork{}
+
+// networks are the networks that can be chosen by name
+var networks = []Network{TCP, WebSocket}
+
+// Networks returns the names of the networks that can be chosen by name
+func Networks() []string {
+	names := make([]string, len(networks))
+	for i, t := range networks {
+		names[i] = t.Name()
+	}
+	return names
+}
+
+// NetworkByName returns the network with the given name
+func NetworkByName(name string) (Network, bool) {
+	for _, t := range networks {
+		if t.Name() == name {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// UnixPrefix starts the addresses of Unix sockets, such as
+// unix:/run/gollaborate.sock, which the networks over TCP listen on, and TCP
+// dials, in place of a host and port
+const UnixPrefix = "unix:"
+
+// socketAddr returns the network and address of the socket an address names:
+// a Unix socket, or TCP over IPv4 or IPv6 alone when its host is an address
+// of either, so that [::]:8080 and 0.0.0.0:8080 may both be listened on
+func socketAddr(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, UnixPrefix); ok {
+		return "unix", path
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "tcp", addr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil {
+			return "tcp4", addr
+		}
+		return "tcp6", addr
+	}
+	return "tcp", addr
+}
+
+// listen listens on the socket an address names
+func listen(addr string) (net.Listener, error) {
+	network, address := socketAddr(addr)
+	return net.Listen(network, address)
+}
+
+type tcpNetwork struct{}
+
+func (tcpNetwork) Name() string { return "tcp" }
+
+func (tcpNetwork) Dial(addr string) (Conn, error) {
+	network, address := socketAddr(addr)
+	return net.Dial(network, address)
+}
+
+func (tcpNetwork) Listen(addr string) (Listener, error) {
+	l, err := listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	return tcpListener{l}, nil
+}
+
+// tcpListener returns its connections as Conns
+type tcpListener struct {
+	net.Listener
+}
+
+func (l tcpListener) Accept() (Conn, error) {
+	return l.Listener.Accept()
+}
+
+// ErrNoListener is returned when dialing an in-memory address nothing listens on
+var ErrNoListener = errors.New("nothing is listening on that address")
+
+// MemoryNetwork connects nodes in the same process, such as in tests, over
+// in-memory pipes. Addresses are any names listeners choose.
+type MemoryNetwork struct {
+	mutex     sync.Mutex
+	listeners map[string]*memoryListener
+}
+
+// NewMemoryNetwork creates an in-memory network with nothing listening
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{listeners: make(map[string]*memoryListener)}
+}
+
+func (t *MemoryNetwork) Name() string { return "memory" }
+
+// Dial connects to the listener on addr, waiting until it accepts
+func (t *MemoryNetwork) Dial(addr string) (Conn, error) {
+	t.mutex.Lock()
+	l, ok := t.listeners[addr]
+	t.mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("dial %s: %w", addr, ErrNoListener)
+	}
+
+	local, remote := net.Pipe()
+	select {
+	case l.conns <- remote:
+		return local, nil
+	case <-l.done:
+		return nil, fmt.Errorf("dial %s: %w", addr, ErrNoListener)
+	}
+}
+
+// Listen starts accepting connections dialed to addr
+func (t *MemoryNetwork) Listen(addr string) (Listener, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if _, taken := t.listeners[addr]; taken {
+		return nil, fmt.Errorf("listen %s: address already in use", addr)
+	}
+	l := &memoryListener{
+		network: t,
+		addr:    memoryAddr(addr),
+		conns:   make(chan Conn),
+		done:    make(chan struct{}),
+	}
+	t.listeners[addr] = l
+	return l, nil
+}
+
+type memoryListener struct {
+	network   *MemoryNetwork
+	addr      memoryAddr
+	conns     chan Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *memoryListener) Accept() (Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memoryListener) Close() error {
+	l.closeOnce.Do(func() {
+		l.network.mutex.Lock()
+		delete(l.network.listeners, string(l.addr))
+		l.network.mutex.Unlock()
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr {
+	return l.addr
+}
+
+// memoryAddr is the address of an in-memory listener
+type memoryAddr string
+
+func (a memoryAddr) Network() string { return "memory" }
+func (a memoryAddr) String() string  { return string(a) }
@@ -0,0 +1,119 @@
+package messages
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// exchange sends a message over a dialed connection and receives it on the accepted one
+func exchange(t *testing.T, network Network, listener Listener, addr string) {
+	t.Helper()
+
+	// Received while dialing: some networks shake hands before Dial returns
+	received := make(chan *Message, 1)
+	go func() {
+		defer close(received)
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+			return
+		}
+		defer conn.Close()
+		msg, err := NewReader(conn).Receive()
+		if err != nil {
+			t.Errorf("Receive: %v", err)
+			return
+		}
+		received <- msg
+	}()
+
+	conn, err := network.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	if err := SendMessage(conn, NewClipMessage("hi", 1, "Alice")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	msg := <-received
+	if msg == nil {
+		t.FailNow()
+	}
+	if msg.Type != MessageTypeClip || msg.Text != "hi" {
+		t.Errorf("Unexpected message: %+v", msg)
+	}
+}
+
+func TestMemoryNetwork(t *testing.T) {
+	network := NewMemoryNetwork()
+	if _, err := network.Dial("hub"); !errors.Is(err, ErrNoListener) {
+		t.Errorf("Expected dialing before listening to fail, got %v", err)
+	}
+
+	listener, err := network.Listen("hub")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	if _, err := network.Listen("hub"); err == nil {
+		t.Error("Expected a second listener on the same address to fail")
+	}
+	if listener.Addr().String() != "hub" || listener.Addr().Network() != "memory" {
+		t.Errorf("Unexpected address %v", listener.Addr())
+	}
+	exchange(t, network, listener, "hub")
+
+	_ = listener.Close()
+	if _, err := listener.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Expected accepting on a closed listener to fail, got %v", err)
+	}
+	if _, err := network.Dial("hub"); !errors.Is(err, ErrNoListener) {
+		t.Errorf("Expected dialing a closed listener to fail, got %v", err)
+	}
+	if _, err := network.Listen("hub"); err != nil {
+		t.Errorf("Expected the address to be free again, got %v", err)
+	}
+}
+
+func TestTCPNetwork(t *testing.T) {
+	network, ok := NetworkByName("tcp")
+	if !ok || network != TCP {
+		t.Fatalf("Expected TCP by name, got %v", network)
+	}
+	listener, err := network.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+	exchange(t, network, listener, listener.Addr().String())
+}
+
+func TestTCPNetworkAddresses(t *testing.T) {
+	// IPv4 and IPv6 addresses are listened on apart, so both may take a port
+	v4, err := TCP.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer v4.Close()
+	_, port, _ := net.SplitHostPort(v4.Addr().String())
+	v6, err := TCP.Listen(net.JoinHostPort("::1", port))
+	if err != nil {
+		t.Skipf("No IPv6 loopback: %v", err)
+	}
+	defer v6.Close()
+	exchange(t, TCP, v4, v4.Addr().String())
+	exchange(t, TCP, v6, v6.Addr().String())
+
+	// As are Unix sockets
+	addr := UnixPrefix + filepath.Join(t.TempDir(), "gollaborate.sock")
+	unix, err := TCP.Listen(addr)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer unix.Close()
+	if unix.Addr().Network() != "unix" {
+		t.Errorf("Expected a Unix socket, got %v", unix.Addr())
+	}
+	exchange(t, TCP, unix, addr)
+}
@@ -0,0 +1,713 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"gollaborate/crdt"
+	"gollaborate/presence"
+)
+
+// ErrInvalidProtobuf is returned when a protobuf message cannot be decoded
+var ErrInvalidProtobuf = errors.New("invalid protobuf message")
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// protobufCodec encodes messages as described by messages.proto. Fields at their
+// zero value are left out, as proto3 does, so a keystroke takes a few dozen bytes.
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string { return "protobuf" }
+func (protobufCodec) Tag() byte    { return 0x01 }
+
+func (protobufCodec) Marshal(msg *Message) ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, string(msg.Type))
+	if msg.Operation != nil {
+		b = appendMessage(b, 2, appendOperation(nil, msg.Operation))
+	}
+	for _, op := range msg.Operations {
+		b = appendMessage(b, 3, appendOperation(nil, op))
+	}
+	b = appendString(b, 4, string(msg.Action))
+	b = appendString(b, 5, msg.UserName)
+	if msg.Document != nil {
+		data, err := json.Marshal(msg.Document)
+		if err != nil {
+			return nil, err
+		}
+		b = appendMessage(b, 6, data)
+	}
+	for _, state := range msg.Presence {
+		b = appendMessage(b, 7, appendPresence(nil, state))
+	}
+	userIDs := make([]int, 0, len(msg.Roles))
+	for userID := range msg.Roles {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Ints(userIDs)
+	for _, userID := range userIDs {
+		entry := appendInt(nil, 1, int64(userID))
+		entry = appendString(entry, 2, string(msg.Roles[userID]))
+		b = appendMessage(b, 8, entry)
+	}
+	if msg.Metadata != nil {
+		data, err := json.Marshal(msg.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		b = appendMessage(b, 9, data)
+	}
+	b = appendInt(b, 10, int64(msg.UserID))
+	b = appendString(b, 11, msg.Error)
+	b = appendInt(b, 12, msg.ProbeID)
+	for _, name := range msg.Codecs {
+		b = appendMessage(b, 13, []byte(name))
+	}
+	b = appendInt(b, 14, int64(msg.Version))
+	b = appendInt(b, 15, int64(msg.MinVersion))
+	b = appendString(b, 16, msg.Color)
+	b = appendInt(b, 17, msg.Seq)
+	b = appendString(b, 18, msg.Text)
+	b = appendString(b, 19, string(msg.Event))
+	b = appendInt(b, 20, msg.ProposalID)
+	b = appendBool(b, 21, msg.Approve)
+	b = appendInt(b, 22, msg.SentAt)
+	userIDs = userIDs[:0]
+	for userID := range msg.Clocks {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Ints(userIDs)
+	for _, userID := range userIDs {
+		entry := appendInt(nil, 1, int64(userID))
+		entry = appendInt(entry, 2, int64(msg.Clocks[userID]))
+		b = appendMessage(b, 23, entry)
+	}
+	b = appendString(b, 24, string(msg.Code))
+	b = appendInt(b, 25, msg.RetryAfter)
+	b = appendInt(b, 26, int64(msg.Origin))
+	b = appendInt(b, 27, msg.OriginSeq)
+	for _, strategy := range msg.Strategies {
+		b = appendMessage(b, 28, []byte(strategy))
+	}
+	if a := msg.Attachment; a != nil {
+		var info []byte
+		info = appendString(info, 1, a.ID)
+		info = appendString(info, 2, a.Name)
+		info = appendInt(info, 3, a.Size)
+		info = appendInt(info, 4, int64(a.ChunkSize))
+		info = appendString(info, 5, a.SHA256)
+		b = appendMessage(b, 29, info)
+	}
+	b = appendInt(b, 30, int64(msg.Chunk))
+	if len(msg.Data) > 0 {
+		b = appendMessage(b, 31, msg.Data)
+	}
+	for _, chunk := range msg.Chunks {
+		b = appendTag(b, 32, wireVarint)
+		b = binary.AppendUvarint(b, uint64(chunk))
+	}
+	if d := msg.DocMeta; d != nil {
+		var meta []byte
+		meta = appendString(meta, 1, d.Title)
+		meta = appendString(meta, 2, d.Language)
+		meta = appendBool(meta, 3, d.ReadOnly)
+		meta = appendInt(meta, 4, d.SavedAt)
+		b = appendMessage(b, 33, meta)
+	}
+	b = appendInt(b, 34, int64(msg.Channel))
+	b = appendString(b, 35, msg.Room)
+	for _, room := range msg.Rooms {
+		var info []byte
+		info = appendString(info, 1, room.Name)
+		info = appendInt(info, 2, int64(room.Users))
+		b = appendMessage(b, 36, info)
+	}
+	b = appendString(b, 37, msg.Token)
+	b = appendString(b, 38, string(msg.Command))
+	b = appendInt(b, 39, int64(msg.Target))
+	b = appendInt(b, 40, msg.At)
+	b = appendString(b, 41, msg.Session)
+	b = appendInt(b, 42, msg.Order)
+	b = appendString(b, 43, string(msg.Format))
+	for _, entry := range msg.Roster {
+		var info []byte
+		info = appendInt(info, 1, int64(entry.UserID))
+		info = appendString(info, 2, entry.UserName)
+		info = appendString(info, 3, entry.Color)
+		info = appendString(info, 4, entry.Role)
+		info = appendBool(info, 5, entry.Idle)
+		b = appendMessage(b, 44, info)
+	}
+	b = appendString(b, 45, msg.Template)
+	b = appendBool(b, 46, msg.Spectate)
+	b = appendString(b, 47, msg.Digest)
+	b = appendString(b, 48, msg.JoinCode)
+	return b, nil
+}
+
+func (protobufCodec) Unmarshal(data []byte) (*Message, error) {
+	msg := &Message{}
+	err := decodeFields(data, func(field int, v protoValue) error {
+		var err error
+		switch field {
+		case 1:
+			var s string
+			s, err = v.string()
+			msg.Type = MessageType(s)
+		case 2:
+			msg.Operation, err = decodeOperation(v)
+		case 3:
+			var op *Operation
+			if op, err = decodeOperation(v); err == nil {
+				msg.Operations = append(msg.Operations, op)
+			}
+		case 4:
+			var s string
+			s, err = v.string()
+			msg.Action = TransactionAction(s)
+		case 5:
+			msg.UserName, err = v.string()
+		case 6:
+			var data []byte
+			if data, err = v.bytes(); err == nil {
+				msg.Document = &crdt.Document{}
+				err = json.Unmarshal(data, msg.Document)
+			}
+		case 7:
+			var state presence.State
+			if state, err = decodePresence(v); err == nil {
+				msg.Presence = append(msg.Presence, state)
+			}
+		case 8:
+			var userID int
+			var role Role
+			if userID, role, err = decodeRole(v); err == nil {
+				if msg.Roles == nil {
+					msg.Roles = make(map[int]Role)
+				}
+				msg.Roles[userID] = role
+			}
+		case 9:
+			var data []byte
+			if data, err = v.bytes(); err == nil {
+				msg.Metadata = &crdt.Metadata{}
+				err = json.Unmarshal(data, msg.Metadata)
+			}
+		case 10:
+			msg.UserID, err = v.int()
+		case 11:
+			msg.Error, err = v.string()
+		case 12:
+			var n uint64
+			n, err = v.varint()
+			msg.ProbeID = int64(n)
+		case 13:
+			var s string
+			if s, err = v.string(); err == nil {
+				msg.Codecs = append(msg.Codecs, s)
+			}
+		case 14:
+			msg.Version, err = v.int()
+		case 15:
+			msg.MinVersion, err = v.int()
+		case 16:
+			msg.Color, err = v.string()
+		case 17:
+			var n uint64
+			n, err = v.varint()
+			msg.Seq = int64(n)
+		case 18:
+			msg.Text, err = v.string()
+		case 19:
+			var s string
+			s, err = v.string()
+			msg.Event = PresenceEvent(s)
+		case 20:
+			var n uint64
+			n, err = v.varint()
+			msg.ProposalID = int64(n)
+		case 21:
+			msg.Approve, err = v.bool()
+		case 22:
+			var n uint64
+			n, err = v.varint()
+			msg.SentAt = int64(n)
+		case 23:
+			var userID, clock int
+			if userID, clock, err = decodeClock(v); err == nil {
+				if msg.Clocks == nil {
+					msg.Clocks = make(map[int]int)
+				}
+				msg.Clocks[userID] = clock
+			}
+		case 24:
+			var s string
+			s, err = v.string()
+			msg.Code = ErrorCode(s)
+		case 25:
+			var n uint64
+			n, err = v.varint()
+			msg.RetryAfter = int64(n)
+		case 26:
+			msg.Origin, err = v.int()
+		case 27:
+			var n uint64
+			n, err = v.varint()
+			msg.OriginSeq = int64(n)
+		case 28:
+			var s string
+			if s, err = v.string(); err == nil {
+				msg.Strategies = append(msg.Strategies, SyncStrategy(s))
+			}
+		case 29:
+			msg.Attachment, err = decodeAttachment(v)
+		case 30:
+			msg.Chunk, err = v.int()
+		case 31:
+			var data []byte
+			if data, err = v.bytes(); err == nil {
+				msg.Data = bytes.Clone(data)
+			}
+		case 32:
+			var chunk int
+			if chunk, err = v.int(); err == nil {
+				msg.Chunks = append(msg.Chunks, chunk)
+			}
+		case 33:
+			msg.DocMeta, err = decodeDocMeta(v)
+		case 34:
+			msg.Channel, err = v.int()
+		case 35:
+			msg.Room, err = v.string()
+		case 36:
+			var room RoomInfo
+			if room, err = decodeRoomInfo(v); err == nil {
+				msg.Rooms = append(msg.Rooms, room)
+			}
+		case 37:
+			msg.Token, err = v.string()
+		case 38:
+			var s string
+			s, err = v.string()
+			msg.Command = AdminCommand(s)
+		case 39:
+			msg.Target, err = v.int()
+		case 40:
+			var n uint64
+			n, err = v.varint()
+			msg.At = int64(n)
+		case 41:
+			msg.Session, err = v.string()
+		case 42:
+			var n uint64
+			n, err = v.varint()
+			msg.Order = int64(n)
+		case 43:
+			var s string
+			s, err = v.string()
+			msg.Format = ExportFormat(s)
+		case 44:
+			var entry RosterEntry
+			if entry, err = decodeRosterEntry(v); err == nil {
+				msg.Roster = append(msg.Roster, entry)
+			}
+		case 45:
+			msg.Template, err = v.string()
+		case 46:
+			msg.Spectate, err = v.bool()
+		case 47:
+			msg.Digest, err = v.string()
+		case 48:
+			msg.JoinCode, err = v.string()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func decodeAttachment(v protoValue) (*AttachmentInfo, error) {
+	data, err := v.bytes()
+	if err != nil {
+		return nil, err
+	}
+	a := &AttachmentInfo{}
+	err = decodeFields(data, func(field int, v protoValue) error {
+		var err error
+		switch field {
+		case 1:
+			a.ID, err = v.string()
+		case 2:
+			a.Name, err = v.string()
+		case 3:
+			var n uint64
+			n, err = v.varint()
+			a.Size = int64(n)
+		case 4:
+			a.ChunkSize, err = v.int()
+		case 5:
+			a.SHA256, err = v.string()
+		}
+		return err
+	})
+	return a, err
+}
+
+func decodeDocMeta(v protoValue) (*DocMeta, error) {
+	data, err := v.bytes()
+	if err != nil {
+		return nil, err
+	}
+	d := &DocMeta{}
+	err = decodeFields(data, func(field int, v protoValue) error {
+		var err error
+		switch field {
+		case 1:
+			d.Title, err = v.string()
+		case 2:
+			d.Language, err = v.string()
+		case 3:
+			d.ReadOnly, err = v.bool()
+		case 4:
+			var n uint64
+			n, err = v.varint()
+			d.SavedAt = int64(n)
+		}
+		return err
+	})
+	return d, err
+}
+
+func decodeRoomInfo(v protoValue) (RoomInfo, error) {
+	data, err := v.bytes()
+	if err != nil {
+		return RoomInfo{}, err
+	}
+	var r RoomInfo
+	err = decodeFields(data, func(field int, v protoValue) error {
+		var err error
+		switch field {
+		case 1:
+			r.Name, err = v.string()
+		case 2:
+			r.Users, err = v.int()
+		}
+		return err
+	})
+	return r, err
+}
+
+func decodeRosterEntry(v protoValue) (RosterEntry, error) {
+	data, err := v.bytes()
+	if err != nil {
+		return RosterEntry{}, err
+	}
+	var e RosterEntry
+	err = decodeFields(data, func(field int, v protoValue) error {
+		var err error
+		switch field {
+		case 1:
+			e.UserID, err = v.int()
+		case 2:
+			e.UserName, err = v.string()
+		case 3:
+			e.Color, err = v.string()
+		case 4:
+			e.Role, err = v.string()
+		case 5:
+			e.Idle, err = v.bool()
+		}
+		return err
+	})
+	return e, err
+}
+
+func appendOperation(b []byte, op *Operation) []byte {
+	b = appendString(b, 1, string(op.Type))
+	b = appendPosition(b, 2, op.Position)
+	b = appendInt(b, 3, int64(op.Character))
+	b = appendInt(b, 4, int64(op.UserID))
+	return appendInt(b, 5, int64(op.Clock))
+}
+
+func decodeOperation(v protoValue) (*Operation, error) {
+	data, err := v.bytes()
+	if err != nil {
+		return nil, err
+	}
+	op := &Operation{}
+	err = decodeFields(data, func(field int, v protoValue) error {
+		var err error
+		switch field {
+		case 1:
+			var s string
+			s, err = v.string()
+			op.Type = OperationType(s)
+		case 2:
+			var ident crdt.Identifier
+			if ident, err = decodeIdentifier(v); err == nil {
+				op.Position = append(op.Position, ident)
+			}
+		case 3:
+			var n int
+			n, err = v.int()
+			op.Character = rune(n)
+		case 4:
+			op.UserID, err = v.int()
+		case 5:
+			op.Clock, err = v.int()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func appendPresence(b []byte, state presence.State) []byte {
+	b = appendInt(b, 1, int64(state.UserID))
+	b = appendString(b, 2, state.UserName)
+	b = appendString(b, 3, state.Color)
+	b = appendPosition(b, 4, state.Cursor)
+	b = appendPosition(b, 5, state.SelectionStart)
+	b = appendBool(b, 6, state.Selecting)
+	b = appendInt(b, 7, int64(state.Clock))
+	return appendBool(b, 8, state.Offline)
+}
+
+func decodePresence(v protoValue) (presence.State, error) {
+	var state presence.State
+	data, err := v.bytes()
+	if err != nil {
+		return state, err
+	}
+	err = decodeFields(data, func(field int, v protoValue) error {
+		var err error
+		var ident crdt.Identifier
+		switch field {
+		case 1:
+			state.UserID, err = v.int()
+		case 2:
+			state.UserName, err = v.string()
+		case 3:
+			state.Color, err = v.string()
+		case 4:
+			if ident, err = decodeIdentifier(v); err == nil {
+				state.Cursor = append(state.Cursor, ident)
+			}
+		case 5:
+			if ident, err = decodeIdentifier(v); err == nil {
+				state.SelectionStart = append(state.SelectionStart, ident)
+			}
+		case 6:
+			state.Selecting, err = v.bool()
+		case 7:
+			state.Clock, err = v.int()
+		case 8:
+			state.Offline, err = v.bool()
+		}
+		return err
+	})
+	return state, err
+}
+
+func decodeRole(v protoValue) (userID int, role Role, err error) {
+	data, err := v.bytes()
+	if err != nil {
+		return 0, "", err
+	}
+	err = decodeFields(data, func(field int, v protoValue) error {
+		var err error
+		switch field {
+		case 1:
+			userID, err = v.int()
+		case 2:
+			var s string
+			s, err = v.string()
+			role = Role(s)
+		}
+		return err
+	})
+	return userID, role, err
+}
+
+func decodeClock(v protoValue) (userID int, clock int, err error) {
+	data, err := v.bytes()
+	if err != nil {
+		return 0, 0, err
+	}
+	err = decodeFields(data, func(field int, v protoValue) error {
+		var err error
+		switch field {
+		case 1:
+			userID, err = v.int()
+		case 2:
+			clock, err = v.int()
+		}
+		return err
+	})
+	return userID, clock, err
+}
+
+// appendPosition appends each identifier of a position as a repeated field
+func appendPosition(b []byte, field int, pos []crdt.Identifier) []byte {
+	for _, ident := range pos {
+		entry := appendInt(nil, 1, int64(ident.Digit))
+		entry = appendInt(entry, 2, int64(ident.Node))
+		b = appendMessage(b, field, entry)
+	}
+	return b
+}
+
+func decodeIdentifier(v protoValue) (crdt.Identifier, error) {
+	var ident crdt.Identifier
+	data, err := v.bytes()
+	if err != nil {
+		return ident, err
+	}
+	err = decodeFields(data, func(field int, v protoValue) error {
+		var err error
+		switch field {
+		case 1:
+			ident.Digit, err = v.int()
+		case 2:
+			ident.Node, err = v.int()
+		}
+		return err
+	})
+	return ident, err
+}
+
+func appendTag(b []byte, field int, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wire))
+}
+
+// appendInt appends an integer field, leaving it out when zero
+func appendInt(b []byte, field int, n int64) []byte {
+	if n == 0 {
+		return b
+	}
+	b = appendTag(b, field, wireVarint)
+	return binary.AppendUvarint(b, uint64(n))
+}
+
+// appendBool appends a boolean field, leaving it out when false
+func appendBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendInt(b, field, 1)
+}
+
+// appendString appends a string field, leaving it out when empty
+func appendString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return appendMessage(b, field, []byte(s))
+}
+
+// appendMessage appends a length-delimited field: an embedded message, bytes,
+// or an element of a repeated field, which is kept even when empty
+func appendMessage(b []byte, field int, data []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// protoValue is the value of one field as read from the wire
+type protoValue struct {
+	wire int
+	n    uint64
+	data []byte
+}
+
+func (v protoValue) varint() (uint64, error) {
+	if v.wire != wireVarint {
+		return 0, ErrInvalidProtobuf
+	}
+	return v.n, nil
+}
+
+func (v protoValue) int() (int, error) {
+	n, err := v.varint()
+	return int(int64(n)), err
+}
+
+func (v protoValue) bool() (bool, error) {
+	n, err := v.varint()
+	return n != 0, err
+}
+
+func (v protoValue) bytes() ([]byte, error) {
+	if v.wire != wireBytes {
+		return nil, ErrInvalidProtobuf
+	}
+	return v.data, nil
+}
+
+func (v protoValue) string() (string, error) {
+	data, err := v.bytes()
+	return string(data), err
+}
+
+// decodeFields calls fn with every field of an encoded message, in order.
+// Fields fn does not know about are passed too, and should be ignored, so newer
+// peers can add fields.
+func decodeFields(data []byte, fn func(field int, v protoValue) error) error {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 || tag>>3 == 0 {
+			return ErrInvalidProtobuf
+		}
+		data = data[n:]
+
+		v := protoValue{wire: int(tag & 7)}
+		switch v.wire {
+		case wireVarint:
+			if v.n, n = binary.Uvarint(data); n <= 0 {
+				return ErrInvalidProtobuf
+			}
+			data = data[n:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return ErrInvalidProtobuf
+			}
+			v.data = data[n : n+int(length)]
+			data = data[n+int(length):]
+		case wireFixed64:
+			if len(data) < 8 {
+				return ErrInvalidProtobuf
+			}
+			v.n = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return ErrInvalidProtobuf
+			}
+			v.n = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		default:
+			return ErrInvalidProtobuf
+		}
+
+		if err := fn(int(tag>>3), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package messages
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// selfSignedValidity is how long generated certificates are valid for
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// DialTLS connects to a node over TLS
+func DialTLS(addr string, config *tls.Config) (Conn, error) {
+	return tls.Dial("tcp", addr, config)
+}
+
+// ListenTLS accepts connections from other nodes over TLS. The config must
+// hold the certificate to present.
+func ListenTLS(addr string, config *tls.Config) (Listener, error) {
+	if config == nil || len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil {
+		return nil, fmt.Errorf("listening over TLS needs a certificate to present")
+	}
+	l, err := listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	return tcpListener{tls.NewListener(l, config)}, nil
+}
+
+// NewTLSNetwork creates a network connecting nodes over TLS. A node that
+// both listens and dials, like an editor, needs a config with a certificate to
+// present and the certificates of the peers it trusts; see TLSOptions.
+func NewTLSNetwork(config *tls.Config) Network {
+	return tlsNetwork{config: config}
+}
+
+type tlsNetwork struct {
+	config *tls.Config
+}
+
+func (tlsNetwork) Name() string { return "tls" }
+
+func (t tlsNetwork) Dial(addr string) (Conn, error) {
+	return DialTLS(addr, t.config)
+}
+
+func (t tlsNetwork) Listen(addr string) (Listener, error) {
+	return ListenTLS(addr, t.config)
+}
+
+// TLSOptions say how a node secures its connections with TLS
+type TLSOptions struct {
+	// The certificate and key to present, in PEM files. Without them a
+	// self-signed certificate is generated.
+	CertFile, KeyFile string
+	// Certificates to trust when connecting, in a PEM file, as well as the
+	// system's: a private CA, or a peer's self-signed certificate
+	CAFile string
+	// Accept any certificate when connecting, such as a generated one. The
+	// connection is encrypted but the peer is not authenticated.
+	Insecure bool
+	// Hosts a generated certificate is for, besides localhost
+	Hosts []string
+}
+
+// Config builds the TLS config for the options
+func (o TLSOptions) Config() (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case o.CertFile != "" && o.KeyFile != "":
+		cert, err = tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	case o.CertFile != "" || o.KeyFile != "":
+		return nil, fmt.Errorf("a TLS certificate needs both a certificate and a key file")
+	default:
+		var certPEM, keyPEM []byte
+		if certPEM, keyPEM, err = GenerateSelfSigned(o.Hosts...); err == nil {
+			cert, err = tls.X509KeyPair(certPEM, keyPEM)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: o.Insecure,
+	}
+	if o.CAFile != "" {
+		data, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading TLS CA: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("loading TLS CA: no certificates in %s", o.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// GenerateSelfSigned generates a self-signed certificate for localhost and the
+// given hosts (names or IP addresses), returning it and its key in PEM form
+func GenerateSelfSigned(hosts ...string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Gollaborate"}, CommonName: "localhost"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range append([]string{"localhost", "127.0.0.1", "::1"}, hosts...) {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
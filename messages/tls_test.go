@@ -0,0 +1,61 @@
+package messages
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTLSNetwork(t *testing.T) {
+	dir := t.TempDir()
+	certPEM, keyPEM, err := GenerateSelfSigned("editor.example")
+	if err != nil {
+		t.Fatalf("GenerateSelfSigned: %v", err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	_ = os.WriteFile(certFile, certPEM, 0o644)
+	_ = os.WriteFile(keyFile, keyPEM, 0o600)
+
+	serverConfig, err := TLSOptions{CertFile: certFile, KeyFile: keyFile}.Config()
+	if err != nil {
+		t.Fatalf("Config: %v", err)
+	}
+	listener, err := ListenTLS("127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatalf("ListenTLS: %v", err)
+	}
+	defer listener.Close()
+	addr := listener.Addr().String()
+
+	// A peer trusting the certificate, and one accepting any
+	for _, opts := range []TLSOptions{{CAFile: certFile}, {Insecure: true}} {
+		config, err := opts.Config()
+		if err != nil {
+			t.Fatalf("Config: %v", err)
+		}
+		exchange(t, NewTLSNetwork(config), listener, addr)
+	}
+
+	// A peer trusting neither is refused
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			_, _ = NewReader(conn).Receive()
+			conn.Close()
+		}
+	}()
+	config, err := TLSOptions{}.Config()
+	if err != nil {
+		t.Fatalf("Config: %v", err)
+	}
+	if conn, err := DialTLS(addr, config); err == nil {
+		conn.Close()
+		t.Error("Expected an untrusted certificate to be refused")
+	}
+
+	if _, err := (TLSOptions{CertFile: certFile}).Config(); err == nil {
+		t.Error("Expected a certificate without a key to fail")
+	}
+	if _, err := (TLSOptions{CAFile: keyFile}).Config(); err == nil {
+		t.Error("Expected a CA file without certificates to fail")
+	}
+}
@@ -0,0 +1,212 @@
+package messages
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Transport carries messages between this node and one peer. Editor state and
+// servers only send and receive through it, so they work the same whatever
+// carries the messages: a connection made over a Network, a gRPC call, or an
+// in-memory pipe in a test.
+type Transport interface {
+	// Send sends a message to the peer. It is safe to call from several goroutines.
+	Send(msg *Message) error
+	// Receive waits for the next message from the peer
+	Receive() (*Message, error)
+	// Close ends the connection; Receive then fails
+	Close() error
+	// RemoteID identifies the peer in logs and dashboards, such as by its address
+	RemoteID() string
+}
+
+// CodecTransport is a Transport that encodes messages itself, so each peer can
+// be sent them in the codec it reads best
+type CodecTransport interface {
+	Transport
+	SendWith(msg *Message, codec Codec) error
+}
+
+// SendWith sends a message encoded with the given codec, or as the transport
+// sends messages if it does not encode them itself
+func SendWith(t Transport, msg *Message, codec Codec) error {
+	if ct, ok := t.(CodecTransport); ok {
+		return ct.SendWith(msg, codec)
+	}
+	return t.Send(msg)
+}
+
+// BatchTransport is a Transport that can send several messages at once, such as
+// in a single write, which costs far less than sending them one by one
+type BatchTransport interface {
+	Transport
+	SendBatch(msgs []*Message, codec Codec) error
+}
+
+// SendBatch sends messages in order, encoded with the given codec, at once if
+// the transport can and one by one if not. Sending stops at the first error.
+func SendBatch(t Transport, msgs []*Message, codec Codec) error {
+	if bt, ok := t.(BatchTransport); ok {
+		return bt.SendBatch(msgs, codec)
+	}
+	for _, msg := range msgs {
+		if err := SendWith(t, msg, codec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConnTransport carries messages over a byte stream connection, as frames
+// written by WriteMessage
+type ConnTransport struct {
+	conn   Conn
+	reader *Reader
+}
+
+// NewConnTransport creates a transport over a connection, such as one a
+// Network made. Nothing else should read from the connection.
+func NewConnTransport(conn Conn) *ConnTransport {
+	return &ConnTransport{conn: conn, reader: NewReader(conn)}
+}
+
+// Send sends a message as JSON, which every peer reads
+func (t *ConnTransport) Send(msg *Message) error {
+	return t.SendWith(msg, JSON)
+}
+
+// SendWith sends a message encoded with the given codec. Every frame is a
+// single write, so sends from several goroutines do not interleave.
+func (t *ConnTransport) SendWith(msg *Message, codec Codec) error {
+	if msg = Intercept(Outbound, msg, t); msg == nil {
+		return nil
+	}
+	return WriteMessage(t.conn, msg, codec)
+}
+
+// SendBatch sends messages encoded with the given codec as one write, so a
+// burst of edits costs a single system call
+func (t *ConnTransport) SendBatch(msgs []*Message, codec Codec) error {
+	var data []byte
+	for _, msg := range msgs {
+		if msg = Intercept(Outbound, msg, t); msg == nil {
+			continue
+		}
+		var err error
+		if data, err = AppendFrame(data, msg, codec); err != nil {
+			return err
+		}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if _, err := t.conn.Write(data); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return nil
+}
+
+// Receive waits for the next message, in whichever codec it was sent
+func (t *ConnTransport) Receive() (*Message, error) {
+	for {
+		msg, err := t.reader.Receive()
+		if err != nil {
+			return nil, err
+		}
+		if msg = Intercept(Inbound, msg, t); msg != nil {
+			return msg, nil
+		}
+	}
+}
+
+// Close closes the connection
+func (t *ConnTransport) Close() error {
+	return t.conn.Close()
+}
+
+// RemoteID returns the peer's address
+func (t *ConnTransport) RemoteID() string {
+	if addr := t.conn.RemoteAddr(); addr != nil {
+		return addr.String()
+	}
+	return "unknown"
+}
+
+// SetWriteDeadline sets when sends stop waiting for the peer and fail, zero
+// for never
+func (t *ConnTransport) SetWriteDeadline(deadline time.Time) error {
+	return t.conn.SetWriteDeadline(deadline)
+}
+
+// Conn returns the connection messages are carried over
+func (t *ConnTransport) Conn() Conn {
+	return t.conn
+}
+
+// NewPipe returns the two ends of an in-memory transport, for tests and for
+// nodes in the same process. Messages are copied, as they would be over a
+// network, and a send waits until the other end receives it.
+func NewPipe() (Transport, Transport) {
+	aToB, bToA := make(chan []byte), make(chan []byte)
+	done := &pipeDone{ch: make(chan struct{})}
+	return &pipeTransport{in: bToA, out: aToB, done: done, remote: "pipe:b"},
+		&pipeTransport{in: aToB, out: bToA, done: done, remote: "pipe:a"}
+}
+
+// pipeDone is closed when either end of a pipe is
+type pipeDone struct {
+	ch   chan struct{}
+	once sync.Once
+}
+
+type pipeTransport struct {
+	in   <-chan []byte
+	out  chan<- []byte
+	done *pipeDone
+	// Names the other end
+	remote string
+}
+
+func (p *pipeTransport) Send(msg *Message) error {
+	if msg = Intercept(Outbound, msg, p); msg == nil {
+		return nil
+	}
+	data, err := JSON.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	select {
+	case p.out <- data:
+		return nil
+	case <-p.done.ch:
+		return net.ErrClosed
+	}
+}
+
+func (p *pipeTransport) Receive() (*Message, error) {
+	for {
+		select {
+		case data := <-p.in:
+			msg, err := JSON.Unmarshal(data)
+			if err != nil {
+				return nil, err
+			}
+			if msg = Intercept(Inbound, msg, p); msg != nil {
+				return msg, nil
+			}
+		case <-p.done.ch:
+			return nil, net.ErrClosed
+		}
+	}
+}
+
+func (p *pipeTransport) Close() error {
+	p.done.once.Do(func() { close(p.done.ch) })
+	return nil
+}
+
+func (p *pipeTransport) RemoteID() string {
+	return p.remote
+}
@@ -0,0 +1,105 @@
+package messages
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPipe(t *testing.T) {
+	a, b := NewPipe()
+	go func() { _ = a.Send(NewClipMessage("hi", 1, "Alice")) }()
+	msg, err := b.Receive()
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if msg.Type != MessageTypeClip || msg.Text != "hi" {
+		t.Errorf("Unexpected message: %+v", msg)
+	}
+	if a.RemoteID() == b.RemoteID() {
+		t.Errorf("Expected each end to name the other, got %q for both", a.RemoteID())
+	}
+
+	// Closing either end ends both
+	b.Close()
+	if _, err := a.Receive(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Expected a closed pipe, got %v", err)
+	}
+	if err := a.Send(NewClipMessage("bye", 1, "Alice")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Expected sending on a closed pipe to fail, got %v", err)
+	}
+}
+
+func TestConnTransport(t *testing.T) {
+	conn1, conn2 := net.Pipe()
+	a, b := NewConnTransport(conn1), NewConnTransport(conn2)
+	defer a.Close()
+	defer b.Close()
+
+	// Each message is read in whichever codec it was sent
+	go func() {
+		_ = a.Send(NewClipMessage("json", 1, "Alice"))
+		_ = SendWith(a, NewClipMessage("protobuf", 1, "Alice"), Protobuf)
+	}()
+	for _, want := range []string{"json", "protobuf"} {
+		msg, err := b.Receive()
+		if err != nil {
+			t.Fatalf("Receive: %v", err)
+		}
+		if msg.Text != want {
+			t.Errorf("Expected %q, got %+v", want, msg)
+		}
+	}
+	if a.RemoteID() == "" || a.Conn() != conn1 {
+		t.Errorf("Expected the transport to describe its connection, got %q", a.RemoteID())
+	}
+}
+
+// writeCounter counts the writes made to a connection
+type writeCounter struct {
+	net.Conn
+	writes atomic.Int32
+}
+
+func (c *writeCounter) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(b)
+}
+
+func TestSendBatch(t *testing.T) {
+	conn1, conn2 := net.Pipe()
+	counter := &writeCounter{Conn: conn1}
+	a, b := NewConnTransport(counter), NewConnTransport(conn2)
+	defer a.Close()
+	defer b.Close()
+
+	// A connection gets every message in one write
+	batch := []*Message{NewClipMessage("one", 1, "Alice"), NewClipMessage("two", 1, "Alice"), NewClipMessage("three", 1, "Alice")}
+	go func() { _ = SendBatch(a, batch, Protobuf) }()
+	for _, want := range []string{"one", "two", "three"} {
+		msg, err := b.Receive()
+		if err != nil {
+			t.Fatalf("Receive: %v", err)
+		}
+		if msg.Text != want {
+			t.Errorf("Expected %q, got %+v", want, msg)
+		}
+	}
+	if n := counter.writes.Load(); n != 1 {
+		t.Errorf("Expected a single write, got %d", n)
+	}
+
+	// Other transports are sent the messages one by one
+	p, q := NewPipe()
+	go func() { _ = SendBatch(p, batch[:2], JSON) }()
+	for _, want := range []string{"one", "two"} {
+		msg, err := q.Receive()
+		if err != nil {
+			t.Fatalf("Receive: %v", err)
+		}
+		if msg.Text != want {
+			t.Errorf("Expected %q, got %+v", want, msg)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package messages
+
+import "fmt"
+
+// DecodeError is a message that arrived whole but could not be decoded. The
+// connection is still usable: the next message can be read after it.
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to deserialize message: %v", e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError is a decoded message missing something its type needs, such
+// as an operation without a position
+type ValidationError struct {
+	Type   MessageType
+	Field  string // The field at fault, such as "operations[2].position"
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("invalid message: %s %s", e.Field, e.Reason)
+	}
+	return fmt.Sprintf("invalid %s message: %s %s", e.Type, e.Field, e.Reason)
+}
+
+// Validate checks that a message has the fields its type needs, returning a
+// *ValidationError for the first one missing. Types this build does not know,
+// from newer peers, are left for the receiver to ignore.
+func (m *Message) Validate() error {
+	missing := func(field string) error {
+		return &ValidationError{Type: m.Type, Field: field, Reason: "is missing"}
+	}
+
+	switch m.Type {
+	case "":
+		return missing("type")
+	case MessageTypeOperation:
+		if m.Operation == nil {
+			return missing("operation")
+		}
+		return m.validateOperation("operation", m.Operation)
+	case MessageTypeTransaction, MessageTypeBatch:
+		if len(m.Operations) == 0 {
+			return missing("operations")
+		}
+		fallthrough
+	case MessageTypeCatchUp:
+		for i, op := range m.Operations {
+			if err := m.validateOperation(fmt.Sprintf("operations[%d]", i), op); err != nil {
+				return err
+			}
+		}
+	case MessageTypeSync:
+		if m.Document == nil {
+			return missing("document")
+		}
+	case MessageTypeError:
+		if m.Error == "" {
+			return missing("error")
+		}
+	case MessageTypeAwareness:
+		if len(m.Presence) == 0 {
+			return missing("presence")
+		}
+	case MessageTypePresence:
+		if m.Event == "" {
+			return missing("event")
+		}
+		if len(m.Presence) == 0 {
+			return missing("presence")
+		}
+	case MessageTypeRoles:
+		if m.Roles == nil {
+			return missing("roles")
+		}
+	case MessageTypePermission:
+		if len(m.Roles) == 0 {
+			return missing("roles")
+		}
+	case MessageTypeMetadata:
+		if m.Metadata == nil {
+			return missing("metadata")
+		}
+	case MessageTypeProbe:
+		if m.ProbeID == 0 {
+			return missing("probe_id")
+		}
+	case MessageTypeHello:
+		if m.Version == 0 {
+			return missing("version")
+		}
+	case MessageTypeProposal, MessageTypeVote:
+		if m.ProposalID == 0 {
+			return missing("proposal_id")
+		}
+	case MessageTypeChat:
+		if m.Text == "" {
+			return missing("text")
+		}
+	case MessageTypeAttachment, MessageTypeAttachmentChunk, MessageTypeAttachmentRequest:
+		if m.Attachment == nil || m.Attachment.ID == "" {
+			return missing("attachment.id")
+		}
+		if m.Type == MessageTypeAttachmentChunk && len(m.Data) == 0 {
+			return missing("data")
+		}
+	case MessageTypeDocMeta:
+		if m.DocMeta == nil {
+			return missing("doc_meta")
+		}
+	case MessageTypeJoinRoom:
+		if m.Room == "" {
+			return missing("room")
+		}
+	case MessageTypeAuth:
+		if m.Token == "" {
+			return missing("token")
+		}
+	case MessageTypeAdmin:
+		if m.Command == "" {
+			return missing("command")
+		}
+		if m.Command == AdminCommandKick && m.Target == 0 {
+			return missing("target")
+		}
+		if m.Command == AdminCommandNotice && m.Text == "" {
+			return missing("text")
+		}
+		if m.Command == AdminCommandImport && m.Room == "" {
+			return missing("room")
+		}
+	case MessageTypeSession, MessageTypeResume:
+		if m.Session == "" {
+			return missing("session")
+		}
+	case MessageTypeOrderRequest:
+		if m.Order <= 0 {
+			return missing("order")
+		}
+	case MessageTypeDocumentAt:
+		if m.Document == nil && m.At == 0 && len(m.Clocks) == 0 {
+			return missing("at")
+		}
+	}
+	return nil
+}
+
+// validateOperation checks one operation of the message
+func (m *Message) validateOperation(field string, op *Operation) error {
+	switch {
+	case op == nil:
+		return &ValidationError{Type: m.Type, Field: field, Reason: "is missing"}
+	case op.Type != OperationTypeInsert && op.Type != OperationTypeDelete:
+		return &ValidationError{Type: m.Type, Field: field + ".type", Reason: fmt.Sprintf("is %q, not insert or delete", op.Type)}
+	case len(op.Position) == 0:
+		return &ValidationError{Type: m.Type, Field: field + ".position", Reason: "is missing"}
+	}
+	return nil
+}
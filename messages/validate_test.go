@@ -0,0 +1,76 @@
+package messages
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/presence"
+)
+
+func TestValidate(t *testing.T) {
+	pos := []crdt.Identifier{{Digit: 1, Node: 1}}
+	tests := []struct {
+		name  string
+		msg   *Message
+		field string // Empty if valid
+	}{
+		{"operation", NewOperationMessage(NewInsertOperation(pos, 'a', 1, 1)), ""},
+		{"no operation", &Message{Type: MessageTypeOperation}, "operation"},
+		{"no position", NewOperationMessage(NewInsertOperation(nil, 'a', 1, 1)), "operation.position"},
+		{"bad operation type", NewOperationMessage(&Operation{Type: "move", Position: pos}), "operation.type"},
+		{"empty transaction", NewTransactionMessage(nil, "", 1, "Alice"), "operations"},
+		{"operation missing from batch", &Message{Type: MessageTypeBatch, Operations: []*Operation{NewInsertOperation(pos, 'a', 1, 1), nil}}, "operations[1]"},
+		{"empty catch-up", NewCatchUpMessage(nil, 1), ""},
+		{"sync", NewSyncMessage(crdt.FromText("abc", 1), 1), ""},
+		{"sync without document", &Message{Type: MessageTypeSync}, "document"},
+		{"awareness", NewAwarenessMessage([]presence.State{{UserID: 1}}, 1), ""},
+		{"empty awareness", NewAwarenessMessage(nil, 1), "presence"},
+		{"hello", NewHelloMessage(1, "Alice", ""), ""},
+		{"hello without version", &Message{Type: MessageTypeHello}, "version"},
+		{"admin lock", NewAdminMessage(AdminCommandLock, 0, 1), ""},
+		{"kick without target", NewAdminMessage(AdminCommandKick, 0, 1), "target"},
+		{"notice", NewNoticeMessage("back in five", 1), ""},
+		{"notice without text", NewAdminMessage(AdminCommandNotice, 0, 1), "text"},
+		{"document at a clock", NewDocumentAtMessage(time.Time{}, map[int]int{1: 3}, 1), ""},
+		{"document at no time", NewDocumentAtMessage(time.Time{}, nil, 1), "at"},
+		{"resume", NewResumeMessage("abc", nil, 1), ""},
+		{"resume without session", NewResumeMessage("", map[int]int{1: 3}, 1), "session"},
+		{"order request", NewOrderRequestMessage(5, 1), ""},
+		{"import", NewImportMessage("restored", ExportFormatText, "hello", 1), ""},
+		{"import without room", NewImportMessage("", ExportFormatText, "hello", 1), "room"},
+		{"order request without order", NewOrderRequestMessage(0, 1), "order"},
+		{"no type", &Message{}, "type"},
+		{"unknown type", &Message{Type: "from_the_future"}, ""},
+	}
+	for _, test := range tests {
+		err := test.msg.Validate()
+		var validationErr *ValidationError
+		switch {
+		case test.field == "" && err != nil:
+			t.Errorf("%s: expected no error, got %v", test.name, err)
+		case test.field == "":
+		case !errors.As(err, &validationErr):
+			t.Errorf("%s: expected a validation error, got %v", test.name, err)
+		case validationErr.Field != test.field:
+			t.Errorf("%s: expected %s to be at fault, got %v", test.name, test.field, err)
+		}
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	conn, remote := net.Pipe()
+	defer conn.Close()
+	go func() { _, _ = remote.Write([]byte("{not json\n" + `{"type":"clip","text":"hi"}` + "\n")) }()
+	reader := NewReader(conn)
+	var decodeErr *DecodeError
+	if _, err := reader.Receive(); !errors.As(err, &decodeErr) {
+		t.Fatalf("Expected a decode error, got %v", err)
+	}
+	// The next message is still read
+	if msg, err := reader.Receive(); err != nil || msg.Text != "hi" {
+		t.Errorf("Expected the clip after it, got %+v (%v)", msg, err)
+	}
+}
@@ -0,0 +1,403 @@
+package messages
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// WebSocketPath is where nodes accept WebSocket connections unless told otherwise
+const WebSocketPath = "/gollaborate"
+
+// websocketGUID is what RFC 6455 has both sides hash a handshake's key with
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// maxControlPayload is the most a WebSocket control frame may carry
+const maxControlPayload = 125
+
+// ErrWebSocketHandshake is returned when a peer does not complete the WebSocket
+// opening handshake, such as when the address is not a Gollaborate endpoint
+var ErrWebSocketHandshake = errors.New("websocket handshake failed")
+
+// WebSocket carries connections over WebSockets, so nodes can connect through
+// proxies and firewalls that only let HTTP through, and browsers can take
+// part. Each message sent is one binary frame. Addresses are host:port, using
+// WebSocketPath, or ws:// URLs.
+var WebSocket Network = websocketNetwork{}
+
+type websocketNetwork struct{}
+
+func (websocketNetwork) Name() string { return "ws" }
+
+func (websocketNetwork) Dial(addr string) (Conn, error) {
+	return DialWebSocket(addr)
+}
+
+func (websocketNetwork) Listen(addr string) (Listener, error) {
+	return ListenWebSocket(addr, WebSocketPath)
+}
+
+// DialWebSocket connects to a node accepting WebSocket connections, given a
+// ws:// URL or a host:port to connect to at WebSocketPath
+func DialWebSocket(addr string) (Conn, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "ws://" + addr + WebSocketPath
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" {
+		return nil, fmt.Errorf("unsupported WebSocket scheme %q", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "80")
+	}
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := websocketHandshake(conn, u)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ws, nil
+}
+
+// websocketHandshake opens a WebSocket over a connection as the client
+func websocketHandshake(conn net.Conn, u *url.URL) (Conn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    u,
+		Host:   u.Host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("%w: %s", ErrWebSocketHandshake, resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		return nil, fmt.Errorf("%w: wrong Sec-WebSocket-Accept", ErrWebSocketHandshake)
+	}
+	return newWebSocketConn(conn, r, true), nil
+}
+
+// websocketAccept returns the Sec-WebSocket-Accept answering a handshake's key
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// WebSocketListener accepts WebSocket connections made to it as an
+// http.Handler. Mount it in an HTTP server to accept nodes alongside other
+// endpoints, or use ListenWebSocket for a server of its own.
+type WebSocketListener struct {
+	addr   net.Addr
+	conns  chan Conn
+	done   chan struct{}
+	once   sync.Once
+	server *http.Server
+}
+
+// NewWebSocketListener creates a WebSocketListener to mount in an HTTP server
+// listening on addr
+func NewWebSocketListener(addr net.Addr) *WebSocketListener {
+	return &WebSocketListener{addr: addr, conns: make(chan Conn), done: make(chan struct{})}
+}
+
+// ListenWebSocket accepts WebSocket connections on a TCP address or Unix
+// socket, at the given path
+func ListenWebSocket(addr, path string) (*WebSocketListener, error) {
+	l, err := listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	ws := NewWebSocketListener(l.Addr())
+	mux := http.NewServeMux()
+	mux.Handle(path, ws)
+	ws.server = &http.Server{Handler: mux}
+	go func() { _ = ws.server.Serve(l) }()
+	return ws, nil
+}
+
+// ServeHTTP completes a WebSocket handshake and hands the connection to Accept
+func (l *WebSocketListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" ||
+		!strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		!headerContains(r.Header, "Connection", "upgrade") {
+		http.Error(w, "Expected a WebSocket connection", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket connections are not supported here", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	_, err = fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", websocketAccept(key))
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	select {
+	case l.conns <- newWebSocketConn(conn, rw.Reader, false):
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// headerContains reports whether a comma separated header lists a token
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Accept waits for the next WebSocket connection
+func (l *WebSocketListener) Accept() (Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections, and the server ListenWebSocket started
+func (l *WebSocketListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	if l.server != nil {
+		return l.server.Close()
+	}
+	return nil
+}
+
+// Addr returns the address connections are accepted on
+func (l *WebSocketListener) Addr() net.Addr {
+	return l.addr
+}
+
+// websocketConn carries a byte stream in WebSocket frames. What is written is
+// sent as one binary frame; what is read is the payload of the data frames
+// received, whatever their type. Pings are answered while reading.
+type websocketConn struct {
+	net.Conn
+	reader *bufio.Reader
+	// Clients mask what they send, servers must not
+	client bool
+
+	readMutex sync.Mutex
+	remaining uint64 // Payload left to read of the current frame
+	mask      []byte // The current frame's mask, nil if it has none
+	maskAt    int
+
+	writeMutex sync.Mutex
+	closed     bool
+}
+
+func newWebSocketConn(conn net.Conn, reader *bufio.Reader, client bool) *websocketConn {
+	return &websocketConn{Conn: conn, reader: reader, client: client}
+}
+
+// Read reads the payload of the data frames received
+func (c *websocketConn) Read(p []byte) (int, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	for c.remaining == 0 {
+		if err := c.nextDataFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.reader.Read(p)
+	c.unmask(p[:n])
+	c.remaining -= uint64(n)
+	return n, err
+}
+
+// nextDataFrame reads frame headers up to the next data frame's payload,
+// handling the control frames before it
+func (c *websocketConn) nextDataFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return err
+	}
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	c.mask, c.maskAt = nil, 0
+	if masked {
+		c.mask = make([]byte, 4)
+		if _, err := io.ReadFull(c.reader, c.mask); err != nil {
+			return err
+		}
+	}
+
+	switch opcode {
+	case wsContinuation, wsText, wsBinary:
+		c.remaining = length
+		return nil
+	case wsClose, wsPing, wsPong:
+		if length > maxControlPayload {
+			return fmt.Errorf("websocket control frame of %d bytes", length)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.reader, payload); err != nil {
+			return err
+		}
+		c.unmask(payload)
+		switch opcode {
+		case wsClose:
+			_ = c.writeFrame(wsClose, nil)
+			return io.EOF
+		case wsPing:
+			return c.writeFrame(wsPong, payload)
+		}
+		return nil
+	default:
+		return fmt.Errorf("websocket frame with unknown opcode %d", opcode)
+	}
+}
+
+// unmask unmasks the next bytes of the current frame's payload
+func (c *websocketConn) unmask(b []byte) {
+	if c.mask == nil {
+		return
+	}
+	for i := range b {
+		b[i] ^= c.mask[c.maskAt%4]
+		c.maskAt++
+	}
+}
+
+// Write sends p as one binary frame
+func (c *websocketConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(wsBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeFrame sends a frame with the given opcode and payload
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	if opcode == wsClose {
+		c.closed = true
+	}
+
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|opcode) // Every frame sent is final
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if !c.client {
+		frame = append(frame, payload...)
+	} else {
+		mask := make([]byte, 4)
+		if _, err := rand.Read(mask); err != nil {
+			return err
+		}
+		frame = append(frame, mask...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+// Close tells the peer the connection is closing and closes it
+func (c *websocketConn) Close() error {
+	_ = c.writeFrame(wsClose, nil)
+	return c.Conn.Close()
+}
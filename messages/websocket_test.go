@@ -0,0 +1,72 @@
+package messages
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWebSocketNetwork(t *testing.T) {
+	listener, err := WebSocket.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+	addr := listener.Addr().String()
+	exchange(t, WebSocket, listener, addr)
+	exchange(t, WebSocket, listener, "ws://"+addr+WebSocketPath)
+
+	// Messages too long for a short frame, read in pieces, arrive whole
+	accepted := make(chan *Message, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+			close(accepted)
+			return
+		}
+		defer conn.Close()
+		reader := NewReader(conn)
+		msg, err := reader.Receive()
+		if err != nil {
+			t.Errorf("Receive: %v", err)
+		}
+		accepted <- msg
+	}()
+	conn, err := DialWebSocket(addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	text := strings.Repeat("abc", 40000)
+	if err := WriteMessage(conn, NewClipMessage(text, 1, "Alice"), Protobuf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if msg := <-accepted; msg == nil || msg.Text != text {
+		t.Error("Expected the long message to arrive whole")
+	}
+
+	// Plain HTTP requests are turned away
+	resp, err := http.Get("http://" + addr + WebSocketPath)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a plain request to be refused, got %s", resp.Status)
+	}
+	if _, err := DialWebSocket("ws://" + addr + "/elsewhere"); err == nil {
+		t.Error("Expected dialing another path to fail")
+	}
+
+	if _, ok := NetworkByName("ws"); !ok {
+		t.Error("Expected the WebSocket network to be chosen by name")
+	}
+}
+
+func TestWebSocketAccept(t *testing.T) {
+	// The example from RFC 6455
+	if got := websocketAccept("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Unexpected accept key %q", got)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gollaborate/messages"
+	"gollaborate/shared"
+)
+
+// defaultPort is the port nodes listen on unless told otherwise
+const defaultPort = 8080
+
+// joinableAddr returns the address with a port, defaultPort unless it has
+// one, and its host bracketed if an IPv6 address, as in [::1]:8080, so that
+// it can be dialed or put in a link
+func joinableAddr(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(defaultPort))
+}
+
+// tlsFlags are the command line settings for securing connections with TLS
+type tlsFlags struct {
+	enabled  *bool
+	certFile *string
+	keyFile  *string
+	caFile   *string
+	insecure *bool
+}
+
+// addTLSFlags defines the TLS flags on a flag set
+func addTLSFlags(flags *flag.FlagSet) tlsFlags {
+	return tlsFlags{
+		enabled:  flags.Bool("tls", false, "Secure connections with TLS (with a generated certificate unless --tls-cert is given)"),
+		certFile: flags.String("tls-cert", "", "TLS certificate file (PEM); generated with --tls-key when neither file exists"),
+		keyFile:  flags.String("tls-key", "", "TLS private key file (PEM)"),
+		caFile:   flags.String("tls-ca", "", "Trust the TLS certificates in this file (PEM), such as a peer's generated one"),
+		insecure: flags.Bool("tls-insecure", false, "Accept any TLS certificate from the node joined: encrypted, but not authenticated"),
+	}
+}
+
+// chooseNetwork returns the network with the given name, secured with TLS
+// when the flags ask for it
+func chooseNetwork(name string, t tlsFlags) (messages.Network, error) {
+	network, ok := messages.NetworkByName(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown transport %q, expected one of %v", name, messages.Networks())
+	}
+	if !*t.enabled && *t.certFile == "" {
+		return network, nil
+	}
+	if network != messages.TCP {
+		return nil, fmt.Errorf("TLS runs over tcp, not %s", name)
+	}
+	config, err := tlsConfig(t)
+	if err != nil {
+		return nil, err
+	}
+	return messages.NewTLSNetwork(config), nil
+}
+
+// tlsConfig builds the TLS config the flags describe, generating a certificate
+// when they name none
+func tlsConfig(t tlsFlags) (*tls.Config, error) {
+	opts := messages.TLSOptions{CertFile: *t.certFile, KeyFile: *t.keyFile, CAFile: *t.caFile, Insecure: *t.insecure}
+	if host, err := os.Hostname(); err == nil {
+		opts.Hosts = []string{host}
+	}
+	if err := generateCertificate(opts); err != nil {
+		return nil, err
+	}
+	config, err := opts.Config()
+	if err != nil {
+		return nil, err
+	}
+	if opts.CertFile == "" {
+		moduleLogger("tls").Warn("Using a generated TLS certificate: peers must join with --tls-insecure")
+	}
+	return config, nil
+}
+
+// generateCertificate writes a self-signed certificate and key to the files the
+// options name when neither exists yet, so peers can trust it with --tls-ca
+func generateCertificate(opts messages.TLSOptions) error {
+	if opts.CertFile == "" || opts.KeyFile == "" || fileExists(opts.CertFile) || fileExists(opts.KeyFile) {
+		return nil
+	}
+	certPEM, keyPEM, err := messages.GenerateSelfSigned(opts.Hosts...)
+	if err != nil {
+		return fmt.Errorf("generating TLS certificate: %w", err)
+	}
+	if err := os.WriteFile(opts.KeyFile, keyPEM, 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(opts.CertFile, certPEM, 0o644); err != nil {
+		return err
+	}
+	moduleLogger("tls").Info("Generated a TLS certificate: peers can trust it with --tls-ca", "file", opts.CertFile)
+	return nil
+}
+
+// sendFlags are the command line settings for peers too slow to keep up
+type sendFlags struct {
+	queue     *int
+	slowPeers *string
+	timeout   *time.Duration
+}
+
+// addSendFlags defines the slow peer flags on a flag set
+func addSendFlags(flags *flag.FlagSet) sendFlags {
+	return sendFlags{
+		queue:     flags.Int("send-queue", shared.DefaultSendQueueLimit, "Edits that may wait to be sent to a peer before it is too slow (0 for no limit)"),
+		slowPeers: flags.String("slow-peers", "disconnect", "What to do with a peer too slow for its edits: disconnect it, or drop the edits"),
+		timeout:   flags.Duration("write-timeout", shared.DefaultWriteTimeout, "Drop a peer that takes longer than this to accept a write (0 waits forever)"),
+	}
+}
+
+// applySendFlags bounds what is sent to each peer as the flags say
+func applySendFlags(editorState *shared.EditorState, s sendFlags) error {
+	var policy shared.OverflowPolicy
+	switch *s.slowPeers {
+	case "disconnect":
+		policy = shared.OverflowDisconnect
+	case "drop":
+		policy = shared.OverflowDrop
+	default:
+		return fmt.Errorf("unknown slow peer policy %q, expected disconnect or drop", *s.slowPeers)
+	}
+	editorState.SetSendQueueLimit(*s.queue, policy)
+	editorState.SetWriteTimeout(*s.timeout)
+	return nil
+}
+
+// fileExists reports whether there is anything at path
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, fs.ErrNotExist)
+}
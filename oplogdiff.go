@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"gollaborate/replay"
+)
+
+// runOpLogDiff compares the operation logs two replicas wrote with --oplog and
+// reports the first operation after which their documents differed
+func runOpLogDiff(args []string) {
+	if len(args) != 2 {
+		log.Fatal("usage: gollaborate oplog-diff FIRST.log SECOND.log")
+	}
+	a, err := replay.LoadOpLog(args[0])
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", args[0], err)
+	}
+	b, err := replay.LoadOpLog(args[1])
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", args[1], err)
+	}
+	d, err := replay.CompareOpLogs(a, b)
+	if err != nil {
+		log.Fatalf("Failed to compare the logs: %v", err)
+	}
+	fmt.Print(d)
+}
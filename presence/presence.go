@@ -0,0 +1,233 @@
+package presence
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"gollaborate/crdt"
+)
+
+// DefaultTimeout is how long a peer's state is kept without being renewed
+const DefaultTimeout = 30 * time.Second
+
+// State is what one participant tells the others about themselves. Each
+// participant only ever writes its own state, bumping Clock on every change, so
+// the state with the highest Clock wins and every peer ends up with the same view.
+type State struct {
+	UserID         int               `json:"user_id"`
+	UserName       string            `json:"user_name,omitempty"`
+	Color          string            `json:"color,omitempty"`           // Hex color for cursor display
+	Cursor         []crdt.Identifier `json:"cursor,omitempty"`          // Character under the cursor, nil at the end
+	SelectionStart []crdt.Identifier `json:"selection_start,omitempty"` // Other end of the selection
+	Selecting      bool              `json:"selecting,omitempty"`       // Whether SelectionStart is set
+	Clock          int               `json:"clock"`
+	Offline        bool              `json:"offline,omitempty"` // The participant left or timed out
+}
+
+// Awareness is the presence of every participant in a session, as last-writer-wins
+// entries keyed by user ID. States that are not renewed within the timeout go
+// offline, so participants that vanish without saying goodbye disappear too.
+type Awareness struct {
+	mutex   sync.Mutex
+	userID  int
+	states  map[int]State
+	seen    map[int]time.Time // When each state last changed, for timeouts
+	timeout time.Duration
+	now     func() time.Time
+}
+
+// New creates the awareness of the participant with the given user ID
+func New(userID int) *Awareness {
+	return &Awareness{
+		userID:  userID,
+		states:  make(map[int]State),
+		seen:    make(map[int]time.Time),
+		timeout: DefaultTimeout,
+		now:     time.Now,
+	}
+}
+
+// SetTimeout changes how long states are kept without being renewed
+func (a *Awareness) SetTimeout(timeout time.Duration) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.timeout = timeout
+}
+
+// SetLocal changes the local participant's state and returns it for broadcasting
+func (a *Awareness) SetLocal(update func(*State)) State {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	state := a.states[a.userID]
+	update(&state)
+	state.UserID = a.userID
+	state.Clock++
+	state.Offline = false
+	a.put(state)
+	return state
+}
+
+// Local returns the local participant's state
+func (a *Awareness) Local() State {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.states[a.userID]
+}
+
+// Leave takes the local participant offline and returns the state for broadcasting
+func (a *Awareness) Leave() State {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	state := a.states[a.userID]
+	state.UserID = a.userID
+	state.Clock++
+	state.Offline = true
+	a.put(state)
+	return state
+}
+
+// Renew bumps the local state's clock when half the timeout has passed since it
+// last changed, so peers do not time it out. ok is false when nothing is due.
+func (a *Awareness) Renew() (state State, ok bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	state, exists := a.states[a.userID]
+	if !exists || state.Offline || a.now().Sub(a.seen[a.userID]) < a.timeout/2 {
+		return State{}, false
+	}
+	state.Clock++
+	a.put(state)
+	return state, true
+}
+
+// Apply merges states received from a peer and returns the ones that changed
+// anything. A state replaces the one held for its user if its clock is newer, or
+// if it has the same clock and takes the user offline. The local participant's
+// own state is never replaced.
+func (a *Awareness) Apply(states []State) []State {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var changed []State
+	for _, state := range states {
+		if state.UserID == a.userID {
+			continue
+		}
+		current, exists := a.states[state.UserID]
+		if exists && state.Clock < current.Clock {
+			continue
+		}
+		if exists && state.Clock == current.Clock && (!state.Offline || current.Offline) {
+			continue
+		}
+		a.put(state)
+		changed = append(changed, state)
+	}
+	return changed
+}
+
+// Remove takes peers offline, such as when the connection they were heard on
+// closes, and returns the states to pass on. The clock is kept, so an older state
+// arriving later cannot bring them back.
+func (a *Awareness) Remove(userIDs ...int) []State {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.remove(userIDs)
+}
+
+// Forget takes peers offline and strips their states of everything but their
+// user ID, such as when a user asks to be forgotten, and returns the stripped
+// states to pass on. Their clocks move on, so peers holding their states,
+// offline or not, replace them too.
+func (a *Awareness) Forget(userIDs ...int) []State {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var forgotten []State
+	for _, userID := range userIDs {
+		state, exists := a.states[userID]
+		if !exists || userID == a.userID {
+			continue
+		}
+		stripped := State{UserID: userID, Clock: state.Clock + 1, Offline: true}
+		a.put(stripped)
+		forgotten = append(forgotten, stripped)
+	}
+	return forgotten
+}
+
+// Expire takes offline every peer whose state was not renewed within the
+// timeout and returns their states
+func (a *Awareness) Expire() []State {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var stale []int
+	for userID, state := range a.states {
+		if userID != a.userID && !state.Offline && a.now().Sub(a.seen[userID]) >= a.timeout {
+			stale = append(stale, userID)
+		}
+	}
+	sort.Ints(stale)
+	return a.remove(stale)
+}
+
+// States returns the state of every online participant, including the local
+// one, sorted by user ID
+func (a *Awareness) States() []State {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var states []State
+	for _, state := range a.sorted() {
+		if !state.Offline {
+			states = append(states, state)
+		}
+	}
+	return states
+}
+
+// All returns every state held, including offline ones, sorted by user ID. It is
+// what a new peer needs to catch up.
+func (a *Awareness) All() []State {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.sorted()
+}
+
+// remove takes the given peers offline. The caller must hold a.mutex.
+func (a *Awareness) remove(userIDs []int) []State {
+	var removed []State
+	for _, userID := range userIDs {
+		state, exists := a.states[userID]
+		if !exists || state.Offline || userID == a.userID {
+			continue
+		}
+		state.Offline = true
+		a.put(state)
+		removed = append(removed, state)
+	}
+	return removed
+}
+
+// put stores a state and notes when it changed. The caller must hold a.mutex.
+func (a *Awareness) put(state State) {
+	a.states[state.UserID] = state
+	a.seen[state.UserID] = a.now()
+}
+
+// sorted returns every state sorted by user ID. The caller must hold a.mutex.
+func (a *Awareness) sorted() []State {
+	states := make([]State, 0, len(a.states))
+	for _, state := range a.states {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].UserID < states[j].UserID
+	})
+	return states
+}
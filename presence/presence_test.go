@@ -0,0 +1,156 @@
+package presence
+
+import (
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+)
+
+func TestApplyKeepsNewestState(t *testing.T) {
+	a := New(1)
+	cursor := []crdt.Identifier{{Digit: 3, Node: 2}}
+
+	changed := a.Apply([]State{
+		{UserID: 2, UserName: "Alice", Cursor: cursor, Clock: 2},
+		{UserID: 3, UserName: "Bob", Clock: 1},
+	})
+	if len(changed) != 2 {
+		t.Fatalf("Expected both states to be new, got %d", len(changed))
+	}
+
+	// An older or repeated state changes nothing
+	if changed := a.Apply([]State{{UserID: 2, UserName: "Old", Clock: 1}, {UserID: 3, UserName: "Bob", Clock: 1}}); len(changed) != 0 {
+		t.Errorf("Expected stale states to be ignored, got %v", changed)
+	}
+
+	states := a.States()
+	if len(states) != 2 || states[0].UserName != "Alice" || states[1].UserName != "Bob" {
+		t.Fatalf("Expected Alice and Bob sorted by user ID, got %v", states)
+	}
+	if len(states[0].Cursor) != 1 || states[0].Cursor[0] != cursor[0] {
+		t.Errorf("Expected Alice's cursor to be kept, got %v", states[0].Cursor)
+	}
+}
+
+func TestApplyConvergesInAnyOrder(t *testing.T) {
+	updates := []State{
+		{UserID: 2, UserName: "a", Clock: 1},
+		{UserID: 2, UserName: "b", Clock: 2},
+		{UserID: 2, UserName: "b", Clock: 2, Offline: true},
+		{UserID: 2, UserName: "c", Clock: 3},
+	}
+
+	forward, backward := New(1), New(1)
+	for i := range updates {
+		forward.Apply(updates[i : i+1])
+		backward.Apply(updates[len(updates)-1-i : len(updates)-i])
+	}
+	f, b := forward.All(), backward.All()
+	if len(f) != 1 || len(b) != 1 || f[0].UserName != "c" || b[0].UserName != "c" || f[0].Offline != b[0].Offline {
+		t.Errorf("Expected both to settle on c, got %v and %v", f, b)
+	}
+}
+
+func TestLocalStateIsNotOverwritten(t *testing.T) {
+	a := New(1)
+	local := a.SetLocal(func(s *State) { s.UserName = "Me" })
+	if local.UserID != 1 || local.Clock != 1 {
+		t.Errorf("Expected local state for user 1 at clock 1, got %+v", local)
+	}
+
+	a.Apply([]State{{UserID: 1, UserName: "Impostor", Clock: 10}})
+	if states := a.States(); len(states) != 1 || states[0].UserName != "Me" {
+		t.Errorf("Expected the local state to be kept, got %v", states)
+	}
+
+	left := a.Leave()
+	if !left.Offline || left.Clock != 2 {
+		t.Errorf("Expected leaving to bump the clock and go offline, got %+v", left)
+	}
+	if states := a.States(); len(states) != 0 {
+		t.Errorf("Expected no online states after leaving, got %v", states)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	now := time.Unix(1000, 0)
+	a := New(1)
+	a.now = func() time.Time { return now }
+	a.SetTimeout(10 * time.Second)
+
+	a.SetLocal(func(s *State) { s.UserName = "Me" })
+	a.Apply([]State{{UserID: 2, Clock: 1}, {UserID: 3, Clock: 1}})
+
+	if _, ok := a.Renew(); ok {
+		t.Error("Expected no renewal right after a change")
+	}
+
+	now = now.Add(6 * time.Second)
+	a.Apply([]State{{UserID: 3, Clock: 2}})
+	renewed, ok := a.Renew()
+	if !ok || renewed.Clock != 2 {
+		t.Errorf("Expected renewal after half the timeout, got %+v (ok=%v)", renewed, ok)
+	}
+
+	now = now.Add(5 * time.Second)
+	expired := a.Expire()
+	if len(expired) != 1 || expired[0].UserID != 2 || !expired[0].Offline {
+		t.Fatalf("Expected only user 2 to expire, got %v", expired)
+	}
+	if states := a.States(); len(states) != 2 || states[0].UserID != 1 || states[1].UserID != 3 {
+		t.Errorf("Expected users 1 and 3 to stay online, got %v", states)
+	}
+
+	// An expired peer comes back with its next update
+	if changed := a.Apply([]State{{UserID: 2, Clock: 2}}); len(changed) != 1 {
+		t.Errorf("Expected a newer state to bring user 2 back, got %v", changed)
+	}
+}
+
+func TestRemoveKeepsClock(t *testing.T) {
+	a := New(1)
+	a.Apply([]State{{UserID: 2, Clock: 4}})
+
+	removed := a.Remove(2, 5)
+	if len(removed) != 1 || removed[0].Clock != 4 || !removed[0].Offline {
+		t.Fatalf("Expected user 2 to go offline at clock 4, got %v", removed)
+	}
+	if changed := a.Apply([]State{{UserID: 2, Clock: 4}}); len(changed) != 0 {
+		t.Errorf("Expected a delayed state not to bring user 2 back, got %v", changed)
+	}
+
+	// A peer that learns of the removal agrees
+	b := New(3)
+	b.Apply([]State{{UserID: 2, Clock: 4}})
+	b.Apply(removed)
+	if states := b.States(); len(states) != 0 {
+		t.Errorf("Expected user 2 to be offline for the peer, got %v", states)
+	}
+}
+
+func TestForgetStripsState(t *testing.T) {
+	a := New(1)
+	a.Apply([]State{{UserID: 2, UserName: "grace", Color: "#ff0000", Clock: 4}})
+	a.Remove(2)
+
+	// Even offline, what is known of the user goes
+	forgotten := a.Forget(2, 5)
+	want := State{UserID: 2, Clock: 5, Offline: true}
+	if len(forgotten) != 1 || forgotten[0].UserName != "" || forgotten[0].Clock != want.Clock || !forgotten[0].Offline {
+		t.Fatalf("Expected %+v, got %+v", want, forgotten)
+	}
+	if all := a.All(); len(all) != 1 || all[0].UserName != "" || all[0].Color != "" {
+		t.Errorf("Expected only user 2's ID kept, got %+v", all)
+	}
+
+	// Peers that took the user offline already forget them too
+	b := New(3)
+	b.Apply([]State{{UserID: 2, UserName: "grace", Clock: 4, Offline: true}})
+	if changed := b.Apply(forgotten); len(changed) != 1 {
+		t.Errorf("Expected the peer to forget user 2, got %+v", changed)
+	}
+	if all := b.All(); len(all) != 1 || all[0].UserName != "" {
+		t.Errorf("Expected only user 2's ID kept by the peer, got %+v", all)
+	}
+}
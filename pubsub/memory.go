@@ -0,0 +1,67 @@
+package pubsub
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrClosed is returned by a broker used after it was closed
+var ErrClosed = errors.New("broker closed")
+
+// Memory is a broker whose subscribers are all in this process, such as
+// several servers in a test. Publishing delivers to every subscriber before
+// returning.
+type Memory struct {
+	mutex    sync.Mutex
+	handlers map[string][]*memorySubscriber
+	closed   bool
+}
+
+// memorySubscriber keeps deliveries to one handler one at a time and in order
+type memorySubscriber struct {
+	mutex   sync.Mutex
+	handler Handler
+}
+
+// NewMemory creates a broker within this process
+func NewMemory() *Memory {
+	return &Memory{handlers: make(map[string][]*memorySubscriber)}
+}
+
+// Publish sends a copy of data to the subscribers of a topic
+func (m *Memory) Publish(topic string, data []byte) error {
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return ErrClosed
+	}
+	subscribers := m.handlers[topic]
+	m.mutex.Unlock()
+
+	for _, sub := range subscribers {
+		sub.mutex.Lock()
+		sub.handler(append([]byte(nil), data...), nil)
+		sub.mutex.Unlock()
+	}
+	return nil
+}
+
+// Subscribe calls the handler with everything published on a topic from now on
+func (m *Memory) Subscribe(topic string, handler Handler) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	m.handlers[topic] = append(m.handlers[topic], &memorySubscriber{handler: handler})
+	return nil
+}
+
+// Close ends every subscription
+func (m *Memory) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.closed = true
+	m.handlers = nil
+	return nil
+}
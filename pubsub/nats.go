@@ -0,0 +1,258 @@
+package pubsub
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxNATSPayload is the largest message accepted from NATS, as its own default
+const maxNATSPayload = 64 << 20
+
+// NATS is a broker using NATS core publish/subscribe. Publishing and every
+// subscription share one connection, which is made again if it fails.
+type NATS struct {
+	addr string
+	auth *url.Userinfo
+
+	mutex  sync.Mutex
+	conn   net.Conn
+	w      *bufio.Writer
+	subs   map[int]*natsSubscription // By subscription ID
+	lastID int
+	done   chan struct{} // Closed with the broker
+	closed bool
+}
+
+// natsSubscription is a topic subscribed to
+type natsSubscription struct {
+	topic   string
+	handler Handler
+}
+
+// natsConnect is what a client tells a NATS server as it connects
+type natsConnect struct {
+	Verbose   bool   `json:"verbose"`
+	Pedantic  bool   `json:"pedantic"`
+	Name      string `json:"name"`
+	Lang      string `json:"lang"`
+	Version   string `json:"version"`
+	User      string `json:"user,omitempty"`
+	Pass      string `json:"pass,omitempty"`
+	AuthToken string `json:"auth_token,omitempty"`
+}
+
+// DialNATS connects to the NATS server at addr. With a user and password they
+// are presented as such; a user alone is taken to be a token.
+func DialNATS(addr string, auth *url.Userinfo) (*NATS, error) {
+	n := &NATS{addr: addr, auth: auth, subs: make(map[int]*natsSubscription), done: make(chan struct{})}
+	n.mutex.Lock()
+	r, err := n.connect()
+	n.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	go n.receive(r)
+	return n, nil
+}
+
+// connect makes the connection, renewing every subscription on it, and
+// returns what reads it. The caller must hold n.mutex.
+func (n *NATS) connect() (*bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", n.addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	fail := func(err error) (*bufio.Reader, error) {
+		_ = conn.Close()
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	// The server speaks first, describing itself
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return fail(err)
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fail(fmt.Errorf("nats: expected INFO, got %q", strings.TrimSpace(line)))
+	}
+
+	hello := natsConnect{Name: "gollaborate", Lang: "go", Version: "1"}
+	if n.auth != nil {
+		if pass, ok := n.auth.Password(); ok {
+			hello.User, hello.Pass = n.auth.Username(), pass
+		} else {
+			hello.AuthToken = n.auth.Username()
+		}
+	}
+	data, err := json.Marshal(hello)
+	if err != nil {
+		return fail(err)
+	}
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "CONNECT %s\r\n", data)
+	for id, sub := range n.subs {
+		fmt.Fprintf(w, "SUB %s %d\r\n", sub.topic, id)
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	n.conn, n.w = conn, w
+	return r, nil
+}
+
+// Publish sends data to the subscribers of a topic
+func (n *NATS) Publish(topic string, data []byte) error {
+	if err := validSubject(topic); err != nil {
+		return err
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.closed {
+		return ErrClosed
+	}
+	if n.conn == nil {
+		return errors.New("nats: reconnecting")
+	}
+	fmt.Fprintf(n.w, "PUB %s %d\r\n", topic, len(data))
+	_, _ = n.w.Write(data)
+	_, _ = n.w.WriteString("\r\n")
+	return n.w.Flush()
+}
+
+// Subscribe calls the handler with everything published on a topic from now on
+func (n *NATS) Subscribe(topic string, handler Handler) error {
+	if err := validSubject(topic); err != nil {
+		return err
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.closed {
+		return ErrClosed
+	}
+	n.lastID++
+	n.subs[n.lastID] = &natsSubscription{topic: topic, handler: handler}
+	if n.conn == nil {
+		// Subscribed once reconnected
+		return nil
+	}
+	fmt.Fprintf(n.w, "SUB %s %d\r\n", topic, n.lastID)
+	return n.w.Flush()
+}
+
+// validSubject checks that a topic is a NATS subject without wildcards, which
+// would subscribe to other topics too
+func validSubject(topic string) error {
+	if topic == "" || strings.ContainsAny(topic, " \t\r\n*>") {
+		return fmt.Errorf("nats: invalid subject %q", topic)
+	}
+	return nil
+}
+
+// Close ends every subscription and closes the connection
+func (n *NATS) Close() error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.closed {
+		return nil
+	}
+	n.closed = true
+	close(n.done)
+	if n.conn == nil {
+		return nil
+	}
+	return n.conn.Close()
+}
+
+// receive handles what the server sends, passing published messages to their
+// subscription's handler. Each time the connection fails it connects again and
+// tells every handler that messages were missed, until the broker is closed.
+func (n *NATS) receive(r *bufio.Reader) {
+	for {
+		n.mutex.Lock()
+		conn := n.conn
+		n.mutex.Unlock()
+		broke := n.read(conn, r)
+		_ = conn.Close()
+		n.mutex.Lock()
+		n.conn, n.w = nil, nil
+		n.mutex.Unlock()
+
+		renewed := redial(n.done, func() (err error) {
+			n.mutex.Lock()
+			defer n.mutex.Unlock()
+			if n.closed {
+				return ErrClosed
+			}
+			r, err = n.connect()
+			return err
+		})
+		if !renewed {
+			return
+		}
+		n.mutex.Lock()
+		subs := make([]*natsSubscription, 0, len(n.subs))
+		for _, sub := range n.subs {
+			subs = append(subs, sub)
+		}
+		n.mutex.Unlock()
+		for _, sub := range subs {
+			sub.handler(nil, broke)
+		}
+	}
+}
+
+// read handles what the server sends on a connection until reading fails
+func (n *NATS) read(conn net.Conn, r *bufio.Reader) error {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "PING":
+			n.mutex.Lock()
+			if n.conn == conn {
+				_, _ = n.w.WriteString("PONG\r\n")
+				err = n.w.Flush()
+			}
+			n.mutex.Unlock()
+			if err != nil {
+				return err
+			}
+		case "-ERR":
+			return fmt.Errorf("nats: %s", strings.TrimSpace(strings.TrimPrefix(line, fields[0])))
+		case "MSG":
+			// MSG subject id [reply-to] size, then the payload
+			if len(fields) < 4 {
+				return fmt.Errorf("nats: malformed %q", strings.TrimSpace(line))
+			}
+			id, err := strconv.Atoi(fields[2])
+			size, err2 := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || err2 != nil || size < 0 || size > maxNATSPayload {
+				return fmt.Errorf("nats: malformed %q", strings.TrimSpace(line))
+			}
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return err
+			}
+			n.mutex.Lock()
+			sub, ok := n.subs[id]
+			n.mutex.Unlock()
+			if ok {
+				sub.handler(data[:size], nil)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Package pubsub passes messages between the servers of a deployment through a
+// publish/subscribe broker, such as Redis or NATS, so several servers can host
+// the same documents.
+package pubsub
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Broker publishes messages on topics and delivers them to every subscriber,
+// on whatever server it runs. Brokers are safe to use from several goroutines.
+type Broker interface {
+	// Publish sends data to the subscribers of a topic
+	Publish(topic string, data []byte) error
+	// Subscribe calls the handler with everything published on a topic from
+	// now on, the broker's own publications included, until it is closed
+	Subscribe(topic string, handler Handler) error
+	// Close ends every subscription; the broker cannot be used afterwards
+	Close() error
+}
+
+// Handler is called with each message published on a topic, one at a time and
+// in order. If the subscription breaks, the broker renews it, then calls the
+// handler with the error that broke it and no data, as whatever was published
+// meanwhile was missed.
+type Handler func(data []byte, err error)
+
+// Default ports of the brokers, used when an address names none
+const (
+	RedisPort = "6379"
+	NATSPort  = "4222"
+)
+
+const (
+	// dialTimeout is how long connecting to a broker may take
+	dialTimeout = 10 * time.Second
+	// maxRedialWait caps the wait between attempts to renew a broken
+	// subscription, which doubles from a tenth of a second with each failure
+	maxRedialWait = 10 * time.Second
+)
+
+// Schemes returns the URL schemes Open accepts
+func Schemes() []string {
+	return []string{"redis", "nats"}
+}
+
+// Open connects to the broker a URL names: redis://[:PASSWORD@]HOST[:PORT] or
+// nats://[USER:PASSWORD@ or TOKEN@]HOST[:PORT]
+func Open(rawURL string) (Broker, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis":
+		password, _ := u.User.Password()
+		return DialRedis(withPort(u.Host, RedisPort), password)
+	case "nats":
+		return DialNATS(withPort(u.Host, NATSPort), u.User)
+	}
+	return nil, fmt.Errorf("unknown pub/sub broker %q, expected a URL starting with one of %v", rawURL, Schemes())
+}
+
+// withPort adds the default port to an address that has none
+func withPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, port)
+}
+
+// redial calls dial until it succeeds, waiting longer after each failure, and
+// reports whether it did before done was closed
+func redial(done <-chan struct{}, dial func() error) bool {
+	wait := maxRedialWait / 100
+	for {
+		select {
+		case <-done:
+			return false
+		case <-time.After(wait):
+		}
+		if dial() == nil {
+			return true
+		}
+		wait = min(wait*2, maxRedialWait)
+	}
+}
@@ -0,0 +1,290 @@
+package pubsub
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// delivery is what a handler was called with
+type delivery struct {
+	data string
+	err  error
+}
+
+// collect returns a handler passing what it is called with to a channel
+func collect() (Handler, chan delivery) {
+	received := make(chan delivery, 16)
+	return func(data []byte, err error) {
+		received <- delivery{string(data), err}
+	}, received
+}
+
+// expectDelivery waits for a handler to be called with the given data, or with
+// an error if want is empty
+func expectDelivery(t *testing.T, received chan delivery, want string) {
+	t.Helper()
+
+	select {
+	case got := <-received:
+		if want == "" && got.err == nil {
+			t.Errorf("Expected to be told of the broken subscription, got %q", got.data)
+		}
+		if want != "" && (got.data != want || got.err != nil) {
+			t.Errorf("Expected %q, got %q (%v)", want, got.data, got.err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for %q", want)
+	}
+}
+
+// fakeServer accepts connections for a fake broker, handing each to serve,
+// and can drop every connection it has
+type fakeServer struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	conns    []net.Conn
+	// Subscriptions by topic, each with what to send it a message
+	subs map[string]map[any]func(data []byte)
+}
+
+// startFake starts a fake broker on a random local port
+func startFake(t *testing.T, serve func(f *fakeServer, conn net.Conn)) *fakeServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	f := &fakeServer{listener: listener, subs: make(map[string]map[any]func([]byte))}
+	t.Cleanup(func() {
+		_ = listener.Close()
+		f.drop()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			f.mutex.Lock()
+			f.conns = append(f.conns, conn)
+			f.mutex.Unlock()
+			go serve(f, conn)
+		}
+	}()
+	return f
+}
+
+// subscribe sends a subscription what is published on a topic
+func (f *fakeServer) subscribe(topic string, sub any, send func(data []byte)) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.subs[topic] == nil {
+		f.subs[topic] = make(map[any]func([]byte))
+	}
+	f.subs[topic][sub] = send
+}
+
+// publish sends data to the subscribers of a topic, returning how many there are
+func (f *fakeServer) publish(topic string, data []byte) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, send := range f.subs[topic] {
+		send(data)
+	}
+	return len(f.subs[topic])
+}
+
+// drop closes every connection, as a broker restarting would
+func (f *fakeServer) drop() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, conn := range f.conns {
+		_ = conn.Close()
+	}
+	f.conns = nil
+	f.subs = make(map[string]map[any]func([]byte))
+}
+
+// serveRedis answers the commands of the Redis protocol brokers use
+func serveRedis(password string) func(f *fakeServer, conn net.Conn) {
+	return func(f *fakeServer, conn net.Conn) {
+		c := &redisConn{Conn: conn, r: bufio.NewReader(conn)}
+		var writeMutex sync.Mutex
+		write := func(format string, args ...any) {
+			writeMutex.Lock()
+			defer writeMutex.Unlock()
+			fmt.Fprintf(conn, format, args...)
+		}
+		authed := password == ""
+		for {
+			reply, err := c.read()
+			if err != nil {
+				return
+			}
+			var args []string
+			for _, arg := range reply.([]any) {
+				args = append(args, string(arg.([]byte)))
+			}
+			switch {
+			case args[0] == "AUTH" && args[1] == password:
+				authed = true
+				write("+OK\r\n")
+			case args[0] == "AUTH":
+				write("-WRONGPASS invalid password\r\n")
+			case !authed:
+				write("-NOAUTH Authentication required\r\n")
+			case args[0] == "SUBSCRIBE":
+				topic := args[1]
+				f.subscribe(topic, conn, func(data []byte) {
+					write("*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(topic), topic, len(data), data)
+				})
+				write("*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(topic), topic)
+			case args[0] == "PUBLISH":
+				write(":%d\r\n", f.publish(args[1], []byte(args[2])))
+			default:
+				write("-ERR unknown command\r\n")
+			}
+		}
+	}
+}
+
+// serveNATS answers the NATS protocol brokers use
+func serveNATS(f *fakeServer, conn net.Conn) {
+	r := bufio.NewReader(conn)
+	var writeMutex sync.Mutex
+	write := func(format string, args ...any) {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		fmt.Fprintf(conn, format, args...)
+	}
+	write("INFO {\"server_id\":\"fake\"}\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "SUB":
+			topic, id := fields[1], fields[2]
+			f.subscribe(topic, [2]any{conn, id}, func(data []byte) {
+				write("MSG %s %s %d\r\n%s\r\n", topic, id, len(data), data)
+			})
+		case "PUB":
+			size, _ := strconv.Atoi(fields[2])
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			f.publish(fields[1], data[:size])
+		case "PING":
+			write("PONG\r\n")
+		}
+	}
+}
+
+// testBroker checks that a broker delivers what is published to every
+// subscriber, and renews subscriptions when the server drops them
+func testBroker(t *testing.T, broker Broker, f *fakeServer) {
+	t.Helper()
+	t.Cleanup(func() { _ = broker.Close() })
+
+	first, firstReceived := collect()
+	second, secondReceived := collect()
+	for _, handler := range []Handler{first, second} {
+		if err := broker.Subscribe("gollaborate.docs", handler); err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+	}
+	// Subscriptions are made as Subscribe returns, but the fake may still be
+	// taking them in
+	time.Sleep(50 * time.Millisecond)
+	if err := broker.Publish("gollaborate.docs", []byte("hello\r\nworld")); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	expectDelivery(t, firstReceived, "hello\r\nworld")
+	expectDelivery(t, secondReceived, "hello\r\nworld")
+
+	f.drop()
+	expectDelivery(t, firstReceived, "")
+	expectDelivery(t, secondReceived, "")
+	time.Sleep(50 * time.Millisecond)
+	deadline := time.Now().Add(2 * time.Second)
+	for broker.Publish("gollaborate.docs", []byte("again")) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected publishing to work again after reconnecting")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	expectDelivery(t, firstReceived, "again")
+
+	if err := broker.Close(); err != nil {
+		t.Errorf("Failed to close: %v", err)
+	}
+	if err := broker.Publish("gollaborate.docs", []byte("late")); err != ErrClosed {
+		t.Errorf("Expected ErrClosed after closing, got %v", err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	broker := NewMemory()
+	handler, received := collect()
+	if err := broker.Subscribe("docs", handler); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	if err := broker.Publish("other", []byte("elsewhere")); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	if err := broker.Publish("docs", []byte("here")); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	expectDelivery(t, received, "here")
+
+	_ = broker.Close()
+	if err := broker.Publish("docs", []byte("late")); err != ErrClosed {
+		t.Errorf("Expected ErrClosed after closing, got %v", err)
+	}
+}
+
+func TestRedis(t *testing.T) {
+	f := startFake(t, serveRedis("s3cret"))
+	addr := f.listener.Addr().String()
+
+	if _, err := DialRedis(addr, "wrong"); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("Expected the wrong password refused, got %v", err)
+	}
+	broker, err := Open("redis://:s3cret@" + addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	testBroker(t, broker, f)
+}
+
+func TestNATS(t *testing.T) {
+	f := startFake(t, serveNATS)
+	broker, err := Open("nats://token@" + f.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	if err := broker.Subscribe("docs.*", func([]byte, error) {}); err == nil {
+		t.Error("Expected a wildcard subject refused")
+	}
+	testBroker(t, broker, f)
+}
+
+func TestOpenUnknownBroker(t *testing.T) {
+	if _, err := Open("kafka://localhost:9092"); err == nil {
+		t.Error("Expected an unknown broker refused")
+	}
+	if got := withPort("localhost", RedisPort); got != "localhost:6379" {
+		t.Errorf("Expected the default port added, got %q", got)
+	}
+}
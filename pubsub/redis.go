@@ -0,0 +1,261 @@
+package pubsub
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// maxRedisBulk is the largest string accepted from Redis, as its own limit
+const maxRedisBulk = 512 << 20
+
+// Redis is a broker using Redis pub/sub. Publishing shares one connection;
+// each subscription has its own, as Redis takes no other commands on it.
+type Redis struct {
+	addr     string
+	password string
+
+	mutex  sync.Mutex
+	pub    *redisConn          // For publishing, nil until needed again after failing
+	subs   map[*redisConn]bool // Those of the subscriptions
+	done   chan struct{}       // Closed with the broker
+	closed bool
+}
+
+// redisConn is a connection to Redis
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// redisError is an error Redis answered a command with
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// DialRedis connects to the Redis server at addr, authenticating with the
+// password unless it is empty
+func DialRedis(addr, password string) (*Redis, error) {
+	r := &Redis{addr: addr, password: password, subs: make(map[*redisConn]bool), done: make(chan struct{})}
+	conn, err := r.dial()
+	if err != nil {
+		return nil, err
+	}
+	r.pub = conn
+	return r, nil
+}
+
+// dial makes a new connection, authenticated
+func (r *Redis) dial() (*redisConn, error) {
+	nc, err := net.DialTimeout("tcp", r.addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+	if r.password != "" {
+		if _, err := conn.do("AUTH", r.password); err != nil {
+			_ = nc.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Publish sends data to the subscribers of a topic. A connection that failed
+// is replaced the next time.
+func (r *Redis) Publish(topic string, data []byte) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return ErrClosed
+	}
+	if r.pub == nil {
+		conn, err := r.dial()
+		if err != nil {
+			return err
+		}
+		r.pub = conn
+	}
+	if _, err := r.pub.do("PUBLISH", topic, string(data)); err != nil {
+		var answered redisError
+		if !errors.As(err, &answered) {
+			_ = r.pub.Close()
+			r.pub = nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Subscribe calls the handler with everything published on a topic from now
+// on, on a connection of its own
+func (r *Redis) Subscribe(topic string, handler Handler) error {
+	conn, err := r.subscribe(topic)
+	if err != nil {
+		return err
+	}
+	go r.receive(topic, conn, handler)
+	return nil
+}
+
+// subscribe dials a connection subscribed to a topic
+func (r *Redis) subscribe(topic string) (*redisConn, error) {
+	conn, err := r.dial()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.do("SUBSCRIBE", topic); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		_ = conn.Close()
+		return nil, ErrClosed
+	}
+	r.subs[conn] = true
+	return conn, nil
+}
+
+// receive passes what is published on a subscription's connection to the
+// handler, renewing the subscription whenever the connection fails, until the
+// broker is closed
+func (r *Redis) receive(topic string, conn *redisConn, handler Handler) {
+	for {
+		broke := conn.messages(handler)
+		r.mutex.Lock()
+		delete(r.subs, conn)
+		r.mutex.Unlock()
+		_ = conn.Close()
+
+		renewed := redial(r.done, func() (err error) {
+			conn, err = r.subscribe(topic)
+			return err
+		})
+		if !renewed {
+			return
+		}
+		handler(nil, broke)
+	}
+}
+
+// messages passes the messages pushed on a subscribed connection to the
+// handler until reading fails
+func (c *redisConn) messages(handler Handler) error {
+	for {
+		reply, err := c.read()
+		if err != nil {
+			return err
+		}
+		// Pushes are arrays: message, topic, data
+		push, ok := reply.([]any)
+		if !ok || len(push) != 3 {
+			continue
+		}
+		if kind, _ := push[0].([]byte); string(kind) != "message" {
+			continue
+		}
+		if data, ok := push[2].([]byte); ok {
+			handler(data, nil)
+		}
+	}
+}
+
+// Close ends every subscription and closes every connection
+func (r *Redis) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	close(r.done)
+	var err error
+	if r.pub != nil {
+		err = r.pub.Close()
+	}
+	for conn := range r.subs {
+		err = errors.Join(err, conn.Close())
+	}
+	return err
+}
+
+// do sends a command and reads the reply, which is an error if Redis answered
+// with one
+func (c *redisConn) do(args ...string) (any, error) {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, "\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := c.Write(buf); err != nil {
+		return nil, err
+	}
+	reply, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(redisError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// read reads a reply in the Redis protocol: a string, error, integer, bulk
+// string or array of any of these. Bulk strings are returned as bytes, nil
+// for a null one.
+func (c *redisConn) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return redisError(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n > maxRedisBulk {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", body)
+		}
+		if n < 0 {
+			return []byte(nil), nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length %q", body)
+		}
+		items := make([]any, 0, max(n, 0))
+		for range n {
+			item, err := c.read()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply %q", line)
+}
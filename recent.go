@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"gollaborate/recent"
+)
+
+// runRecent lists the recently used documents and peers, numbered for --resume
+func runRecent(args []string) {
+	list, err := openRecent()
+	if err != nil {
+		log.Fatalf("Failed to read recent list: %v", err)
+	}
+	if len(list.Entries) == 0 {
+		fmt.Println("No recent documents or peers")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for i, e := range list.Entries {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s ago\n", i+1, e.Kind, e.Target, time.Since(e.Time).Truncate(time.Minute))
+	}
+	_ = w.Flush()
+	fmt.Println("\nResume one with: gollaborate --resume <number>")
+}
+
+// openRecent opens the current user's recent list
+func openRecent() (*recent.List, error) {
+	path, err := recent.DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	return recent.Open(path)
+}
+
+// resumeRecent fills in --file or --join from the nth recent entry
+func resumeRecent(n int) {
+	list, err := openRecent()
+	if err != nil {
+		log.Fatalf("Failed to read recent list: %v", err)
+	}
+	e, err := list.Get(n)
+	if err != nil {
+		log.Fatalf("Cannot resume: %v", err)
+	}
+	switch e.Kind {
+	case recent.File:
+		*textFile = e.Target
+	case recent.Peer:
+		*join = e.Target
+	}
+	moduleLogger("recent").Info("Resuming", "kind", e.Kind, "target", e.Target)
+}
+
+// rememberRecent moves a document or peer to the front of the recent list. Failing
+// to update the list is logged but never stops the editor.
+func rememberRecent(kind recent.Kind, target string) {
+	if kind == recent.File {
+		if abs, err := filepath.Abs(target); err == nil {
+			target = abs
+		}
+	}
+	list, err := openRecent()
+	if err == nil {
+		list.Add(kind, target)
+		err = list.Save()
+	}
+	if err != nil {
+		moduleLogger("recent").Warn("Error updating recent list", "err", err)
+	}
+}
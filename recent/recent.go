@@ -0,0 +1,103 @@
+package recent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MaxEntries is how many documents and peers a List remembers
+const MaxEntries = 20
+
+// Kind says what an Entry refers to
+type Kind string
+
+const (
+	File Kind = "file" // A document loaded with --file
+	Peer Kind = "peer" // An address joined with --join
+)
+
+// Entry is one recently used document or peer
+type Entry struct {
+	Kind   Kind      `json:"kind"`
+	Target string    `json:"target"` // Absolute file path or host:port
+	Time   time.Time `json:"time"`
+}
+
+// List is the most recently used documents and peers, newest first, kept in a
+// file so a session can be resumed later
+type List struct {
+	Entries []Entry `json:"entries"`
+
+	path string
+	now  func() time.Time
+}
+
+// DefaultPath returns where the list is kept for the current user
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gollaborate", "recent.json"), nil
+}
+
+// Open reads the list kept at path. A missing file is an empty list.
+func Open(path string) (*List, error) {
+	l := &List{path: path, now: time.Now}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, fmt.Errorf("invalid recent list %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// Add moves a document or peer to the front of the list, dropping the oldest
+// entry once there are more than MaxEntries
+func (l *List) Add(kind Kind, target string) {
+	entries := []Entry{{Kind: kind, Target: target, Time: l.now()}}
+	for _, e := range l.Entries {
+		if e.Kind != kind || e.Target != target {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) > MaxEntries {
+		entries = entries[:MaxEntries]
+	}
+	l.Entries = entries
+}
+
+// Get returns the nth most recent entry, counting from 1 as shown by the recent command
+func (l *List) Get(n int) (Entry, error) {
+	if n < 1 || n > len(l.Entries) {
+		return Entry{}, fmt.Errorf("no recent entry %d (have %d)", n, len(l.Entries))
+	}
+	return l.Entries[n-1], nil
+}
+
+// Save writes the list back to the file it was opened from
+func (l *List) Save() error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write a temporary file first so a crash cannot leave a truncated list
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
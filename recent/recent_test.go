@@ -0,0 +1,74 @@
+package recent
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAddKeepsNewestFirst(t *testing.T) {
+	l, err := Open(filepath.Join(t.TempDir(), "recent.json"))
+	if err != nil {
+		t.Fatalf("Failed to open missing list: %v", err)
+	}
+	if len(l.Entries) != 0 {
+		t.Fatalf("Expected an empty list, got %v", l.Entries)
+	}
+
+	l.Add(File, "/tmp/a.go")
+	l.Add(Peer, "localhost:8080")
+	l.Add(File, "/tmp/a.go")
+
+	if len(l.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %v", l.Entries)
+	}
+	if e, _ := l.Get(1); e.Kind != File || e.Target != "/tmp/a.go" {
+		t.Errorf("Expected the re-added file first, got %+v", e)
+	}
+	if e, _ := l.Get(2); e.Kind != Peer || e.Target != "localhost:8080" {
+		t.Errorf("Expected the peer second, got %+v", e)
+	}
+	if _, err := l.Get(3); err == nil {
+		t.Error("Expected an error for an entry past the end")
+	}
+
+	// The same target as a different kind is a separate entry
+	l.Add(Peer, "/tmp/a.go")
+	if len(l.Entries) != 3 {
+		t.Errorf("Expected 3 entries, got %d", len(l.Entries))
+	}
+}
+
+func TestAddDropsOldest(t *testing.T) {
+	l, _ := Open(filepath.Join(t.TempDir(), "recent.json"))
+	for i := 0; i < MaxEntries+5; i++ {
+		l.Add(Peer, fmt.Sprintf("host:%d", i))
+	}
+	if len(l.Entries) != MaxEntries {
+		t.Fatalf("Expected %d entries, got %d", MaxEntries, len(l.Entries))
+	}
+	if e, _ := l.Get(MaxEntries); e.Target != "host:5" {
+		t.Errorf("Expected the oldest kept entry to be host:5, got %s", e.Target)
+	}
+}
+
+func TestSaveAndOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "recent.json")
+	l, _ := Open(path)
+	when := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return when }
+	l.Add(File, "/home/me/notes.md")
+	if err := l.Save(); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+
+	loaded, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open saved list: %v", err)
+	}
+	e, err := loaded.Get(1)
+	if err != nil || e.Target != "/home/me/notes.md" || !e.Time.Equal(when) {
+		t.Errorf("Expected the saved entry back, got %+v (%v)", e, err)
+	}
+}
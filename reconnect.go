@@ -0,0 +1,150 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"gollaborate/messages"
+	"gollaborate/shared"
+)
+
+const (
+	// dropCheckInterval is how often the connection to a server is checked
+	dropCheckInterval = 500 * time.Millisecond
+	// maxReconnectWait caps the wait between attempts to reconnect, which
+	// doubles from a second with every failed one
+	maxReconnectWait = 30 * time.Second
+)
+
+// serverLink keeps the editor joined to the node it joined. A server that
+// gives the editor a session is reconnected to whenever the connection drops,
+// resuming the session so the editor stays the same user and is sent only
+// what it missed.
+type serverLink struct {
+	editorState *shared.EditorState
+	network     messages.Network
+	addr        string
+	room        string
+	template    string
+	spectate    bool
+	token       string
+	joinCode    string
+	userName    string
+	nodeID      int
+	logger      *slog.Logger
+
+	mutex   sync.Mutex
+	session string // Given by the server, empty if none or it expired
+}
+
+// newServerLink follows the sessions the node at addr gives the editor
+func newServerLink(editorState *shared.EditorState, network messages.Network, addr string) *serverLink {
+	l := &serverLink{
+		editorState: editorState,
+		network:     network,
+		addr:        addr,
+		room:        *roomName,
+		template:    *templateName,
+		spectate:    *spectate,
+		token:       *token,
+		joinCode:    *joinCode,
+		userName:    *username,
+		nodeID:      editorState.NodeID(),
+		logger:      moduleLogger("peer"),
+	}
+	editorState.AddMessageListener(func(msg *messages.Message) {
+		switch {
+		case msg.Type == messages.MessageTypeSession:
+			l.setSession(msg.Session)
+		case msg.Type == messages.MessageTypeError && msg.Code == messages.ErrorCodeSessionExpired:
+			l.logger.Warn("The session expired; join again to carry on", "addr", l.addr)
+			l.setSession("")
+		case msg.Type == messages.MessageTypeError && msg.Code == messages.ErrorCodeIdle:
+			// Reconnecting would only keep the place the server freed
+			l.logger.Warn("Disconnected after being idle; join again to carry on", "addr", l.addr)
+			l.setSession("")
+		}
+	})
+	return l
+}
+
+// setSession remembers the session to resume
+func (l *serverLink) setSession(session string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.session = session
+}
+
+// currentSession returns the session to resume, if any
+func (l *serverLink) currentSession() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.session
+}
+
+// connect connects to the node and says hello. A server asking for
+// credentials takes them, or a join code, first, then the room from a server
+// hosting several documents, or whichever a spectator watches; one that gave
+// the editor a session takes it before all that.
+func (l *serverLink) connect() (messages.Transport, error) {
+	conn, err := l.network.Dial(l.addr)
+	if err != nil {
+		return nil, err
+	}
+	var first []*messages.Message
+	if session := l.currentSession(); session != "" {
+		first = append(first, messages.NewResumeMessage(session, l.editorState.Clocks(), l.nodeID))
+	}
+	switch {
+	case l.token != "":
+		first = append(first, messages.NewAuthMessage(l.token, l.userName, l.nodeID))
+	case l.joinCode != "":
+		first = append(first, messages.NewJoinCodeMessage(l.joinCode, l.userName, l.nodeID))
+	}
+	switch {
+	case l.spectate:
+		first = append(first, messages.NewSpectateMessage(l.room, l.nodeID))
+	case l.room != "":
+		first = append(first, messages.NewCreateRoomMessage(l.room, l.template, l.nodeID))
+	}
+	for _, msg := range first {
+		if err := messages.SendMessage(conn, msg); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	peer := l.editorState.AddConn(conn)
+	l.editorState.Hello(peer)
+	return peer, nil
+}
+
+// stayConnected reconnects each time the connection drops, for as long as
+// there is a session to resume
+func (l *serverLink) stayConnected(peer messages.Transport) {
+	for {
+		for slices.Contains(l.editorState.Connections(), peer) {
+			time.Sleep(dropCheckInterval)
+		}
+		if l.currentSession() == "" {
+			return
+		}
+		l.logger.Warn("Lost the connection, reconnecting", "addr", l.addr)
+
+		wait := time.Second
+		for {
+			time.Sleep(wait)
+			if l.currentSession() == "" {
+				return
+			}
+			var err error
+			if peer, err = l.connect(); err == nil {
+				break
+			}
+			l.logger.Warn("Failed to reconnect", "addr", l.addr, "err", err)
+			wait = min(wait*2, maxReconnectWait)
+		}
+		l.logger.Info("Reconnected", "addr", l.addr)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"gollaborate/replay"
+)
+
+// runReplay exports a session recorded with --record as an animated replay
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	in := fs.String("in", "", "Recording written by --record")
+	out := fs.String("out", "", "Output file (defaults to the recording name with a .cast extension)")
+	width := fs.Int("width", replay.DefaultCastOptions.Width, "Terminal columns")
+	height := fs.Int("height", replay.DefaultCastOptions.Height, "Terminal rows")
+	maxIdle := fs.Duration("max-idle", replay.DefaultCastOptions.MaxIdle, "Shorten pauses longer than this (0 keeps them)")
+	speed := fs.Float64("speed", replay.DefaultCastOptions.Speed, "Playback speed multiplier")
+	_ = fs.Parse(args)
+
+	if *in == "" {
+		log.Fatal("replay: --in is required")
+	}
+	session, err := replay.Load(*in)
+	if err != nil {
+		log.Fatalf("Failed to load recording: %v", err)
+	}
+
+	output := *out
+	if output == "" {
+		output = strings.TrimSuffix(*in, ".json") + ".cast"
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		log.Fatalf("Failed to create %s: %v", output, err)
+	}
+	defer f.Close()
+
+	opts := replay.CastOptions{Width: *width, Height: *height, MaxIdle: *maxIdle, Speed: *speed}
+	if err := replay.WriteAsciicast(f, session, opts); err != nil {
+		log.Fatalf("Failed to export replay: %v", err)
+	}
+	log.Printf("Wrote %d frames to %s (play with: asciinema play %s)", len(session.Frames), output, output)
+}
@@ -0,0 +1,101 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// CastOptions controls how a session is rendered as an asciinema cast
+type CastOptions struct {
+	Width   int           // Terminal columns; longer lines are cut off
+	Height  int           // Terminal rows, including the status line
+	MaxIdle time.Duration // Pauses longer than this are shortened to it (0 keeps them)
+	Speed   float64       // Playback speed multiplier (0 means 1)
+}
+
+// DefaultCastOptions are sensible settings for sharing a recording
+var DefaultCastOptions = CastOptions{Width: 80, Height: 24, MaxIdle: 2 * time.Second, Speed: 1}
+
+// castHeader is the first line of an asciicast v2 file
+type castHeader struct {
+	Version   int    `json:"version"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Timestamp int64  `json:"timestamp"`
+	Title     string `json:"title,omitempty"`
+}
+
+// WriteAsciicast renders a session as an asciicast v2 recording, redrawing the
+// screen with the document text for each frame
+func WriteAsciicast(w io.Writer, session *Session, opts CastOptions) error {
+	if opts.Width <= 0 || opts.Height <= 1 {
+		return fmt.Errorf("cast must be at least 1x2, got %dx%d", opts.Width, opts.Height)
+	}
+	speed := opts.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+
+	enc := json.NewEncoder(w)
+	err := enc.Encode(castHeader{
+		Version:   2,
+		Width:     opts.Width,
+		Height:    opts.Height,
+		Timestamp: session.Started.Unix(),
+		Title:     session.Name,
+	})
+	if err != nil {
+		return err
+	}
+
+	var elapsed, previous time.Duration
+	for i, frame := range session.Frames {
+		gap := frame.Offset - previous
+		if i == 0 {
+			gap = 0
+		}
+		if opts.MaxIdle > 0 && gap > opts.MaxIdle {
+			gap = opts.MaxIdle
+		}
+		previous = frame.Offset
+		elapsed += time.Duration(float64(gap) / speed)
+
+		status := fmt.Sprintf("%s  frame %d/%d  %s", session.Name, i+1, len(session.Frames), frame.Offset.Truncate(time.Second))
+		event := []interface{}{elapsed.Seconds(), "o", renderScreen(frame.Text, status, opts)}
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderScreen clears the terminal and draws as much of the text as fits above a status line
+func renderScreen(text, status string, opts CastOptions) string {
+	var b strings.Builder
+	b.WriteString("\x1b[2J\x1b[H")
+
+	lines := strings.Split(text, "\n")
+	if len(lines) > opts.Height-1 {
+		lines = lines[:opts.Height-1]
+	}
+	for _, line := range lines {
+		b.WriteString(truncate(line, opts.Width))
+		b.WriteString("\r\n")
+	}
+
+	// Status line on the bottom row, in reverse video
+	fmt.Fprintf(&b, "\x1b[%d;1H\x1b[7m%s\x1b[0m", opts.Height, truncate(status, opts.Width))
+	return b.String()
+}
+
+// truncate cuts a string to at most width runes
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return string(runes[:width])
+}
@@ -0,0 +1,72 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteAsciicast(t *testing.T) {
+	session := &Session{
+		Name:    "demo",
+		Started: time.Unix(1700000000, 0),
+		Frames: []Frame{
+			{Offset: 0, Text: "a"},
+			{Offset: time.Second, Text: "ab"},
+			{Offset: time.Minute, Text: "ab\n" + strings.Repeat("x", 100)},
+		},
+	}
+
+	var out bytes.Buffer
+	opts := CastOptions{Width: 40, Height: 10, MaxIdle: 2 * time.Second, Speed: 2}
+	if err := WriteAsciicast(&out, session, opts); err != nil {
+		t.Fatalf("Failed to write cast: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected a header and 3 events, got %d lines", len(lines))
+	}
+
+	var header castHeader
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil {
+		t.Fatalf("Invalid header: %v", err)
+	}
+	if header.Version != 2 || header.Width != 40 || header.Height != 10 || header.Timestamp != 1700000000 {
+		t.Errorf("Unexpected header: %+v", header)
+	}
+
+	// 1s at double speed, then a minute-long pause capped at 2s and halved
+	expectedTimes := []float64{0, 0.5, 1.5}
+	for i, line := range lines[1:] {
+		var event []interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("Invalid event %d: %v", i, err)
+		}
+		if event[0].(float64) != expectedTimes[i] {
+			t.Errorf("Event %d: expected time %v, got %v", i, expectedTimes[i], event[0])
+		}
+		if event[1] != "o" {
+			t.Errorf("Event %d: expected output event, got %v", i, event[1])
+		}
+	}
+
+	var last []interface{}
+	_ = json.Unmarshal([]byte(lines[3]), &last)
+	screen := last[2].(string)
+	if !strings.Contains(screen, "ab\r\n"+strings.Repeat("x", 40)+"\r\n") {
+		t.Errorf("Expected text with long lines cut to the width, got %q", screen)
+	}
+	if strings.Contains(screen, strings.Repeat("x", 41)) {
+		t.Error("Expected long line to be truncated")
+	}
+}
+
+func TestWriteAsciicastRejectsTinyTerminal(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteAsciicast(&out, &Session{}, CastOptions{Width: 80, Height: 1}); err == nil {
+		t.Error("Expected an error for a terminal with no room for text")
+	}
+}
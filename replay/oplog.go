@@ -0,0 +1,288 @@
+package replay
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"gollaborate/crdt"
+)
+
+// Kinds of operation log entries
+const (
+	OpInsert = "insert"
+	OpDelete = "delete"
+	// OpBase is text the replica took wholesale rather than edit by edit: where
+	// logging started, or a sync from a peer
+	OpBase = "base"
+)
+
+// OpLogEntry is a change a replica applied to its document, in the order it was
+// applied, with a hash of the text after it
+type OpLogEntry struct {
+	N     int    `json:"n"` // 1 for the first entry
+	Kind  string `json:"kind"`
+	Pos   string `json:"pos,omitempty"`
+	Clock int    `json:"clock,omitempty"`
+	Char  string `json:"char,omitempty"`
+	Hash  string `json:"hash"`
+}
+
+// key identifies the operation an entry applied, the same on every replica
+func (e OpLogEntry) key() string {
+	return e.Kind + " " + e.Pos + " " + fmt.Sprint(e.Clock)
+}
+
+func (e OpLogEntry) String() string {
+	if e.Kind == OpBase {
+		return fmt.Sprintf("#%d base text %s", e.N, e.Hash)
+	}
+	return fmt.Sprintf("#%d %s %q at %s clock %d", e.N, e.Kind, e.Char, e.Pos, e.Clock)
+}
+
+// OpLogger writes an operation log, one JSON entry per line. Replicas of the
+// same document each write one; CompareOpLogs then finds where they diverged.
+type OpLogger struct {
+	mutex sync.Mutex
+	w     io.Writer
+	n     int
+}
+
+// NewOpLogger writes an operation log to w
+func NewOpLogger(w io.Writer) *OpLogger {
+	return &OpLogger{w: w}
+}
+
+// Change logs a character inserted or deleted, given the text after it
+func (l *OpLogger) Change(c crdt.Change, text string) {
+	kind := OpInsert
+	if c.Delete {
+		kind = OpDelete
+	}
+	l.write(OpLogEntry{Kind: kind, Pos: formatPosition(c.Char.Pos), Clock: c.Char.Clock, Char: string(c.Char.Value), Hash: hashText(text)})
+}
+
+// Base logs text the replica took wholesale
+func (l *OpLogger) Base(text string) {
+	l.write(OpLogEntry{Kind: OpBase, Hash: hashText(text)})
+}
+
+func (l *OpLogger) write(entry OpLogEntry) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.n++
+	entry.N = l.n
+	data, _ := json.Marshal(entry)
+	// A debugging aid should not break editing; a short log shows the failure
+	_, _ = l.w.Write(append(data, '\n'))
+}
+
+// ReadOpLog reads an operation log written by OpLogger
+func ReadOpLog(r io.Reader) ([]OpLogEntry, error) {
+	var entries []OpLogEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var entry OpLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", len(entries)+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// LoadOpLog reads an operation log file
+func LoadOpLog(path string) ([]OpLogEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadOpLog(f)
+}
+
+// Divergence is what CompareOpLogs found
+type Divergence struct {
+	// Whether the replicas had different text after applying the same operations
+	Diverged bool
+	// The last entry of each log after which the replicas agreed, 0 if none
+	AgreedA, AgreedB int
+	// The entries of each log from just after they agreed to where they first
+	// disagreed. With the same order on both sides this is the one operation
+	// after which they diverged.
+	SuspectsA, SuspectsB []OpLogEntry
+	// Operations only one replica applied, as when a message was lost
+	OnlyA, OnlyB []OpLogEntry
+}
+
+// CompareOpLogs compares the operation logs of two replicas of a document. The
+// replicas may apply concurrent operations in different orders, so text is
+// compared wherever both have applied the same set of operations. A log that
+// starts from a sync is lined up with the other where that had the same text.
+func CompareOpLogs(a, b []OpLogEntry) (Divergence, error) {
+	startA, startB, err := alignOpLogs(a, b)
+	if err != nil {
+		return Divergence{}, err
+	}
+	d := Divergence{}
+	if startA > 0 {
+		d.AgreedA = a[startA-1].N
+	}
+	if startB > 0 {
+		d.AgreedB = b[startB-1].N
+	}
+	a, b = a[startA:], b[startB:]
+
+	// Operations both applied; the others are reported and set aside
+	keysA, keysB := make(map[string]bool), make(map[string]bool)
+	for _, e := range a {
+		keysA[e.key()] = true
+	}
+	for _, e := range b {
+		keysB[e.key()] = true
+	}
+	a = sharedOps(a, keysB, &d.OnlyA)
+	b = sharedOps(b, keysA, &d.OnlyB)
+
+	// Walk both logs, advancing whichever has applied fewer of the other's
+	// operations, and compare hashes each time both have applied the same set
+	onlyA, onlyB := make(map[string]bool), make(map[string]bool)
+	i, j := 0, 0
+	lastI, lastJ := 0, 0
+	for i < len(a) || j < len(b) {
+		if j < len(b) && (i == len(a) || len(onlyA) >= len(onlyB)) {
+			k := b[j].key()
+			if onlyA[k] {
+				delete(onlyA, k)
+			} else {
+				onlyB[k] = true
+			}
+			j++
+		} else {
+			k := a[i].key()
+			if onlyB[k] {
+				delete(onlyB, k)
+			} else {
+				onlyA[k] = true
+			}
+			i++
+		}
+		if len(onlyA) > 0 || len(onlyB) > 0 {
+			continue
+		}
+		if a[i-1].Hash != b[j-1].Hash {
+			d.Diverged = true
+			d.SuspectsA = a[lastI:i]
+			d.SuspectsB = b[lastJ:j]
+			return d, nil
+		}
+		d.AgreedA, d.AgreedB = a[i-1].N, b[j-1].N
+		lastI, lastJ = i, j
+	}
+	return d, nil
+}
+
+// alignOpLogs returns where to start comparing each log: after its last base
+// entry, moved on in the other log to where that had the same text
+func alignOpLogs(a, b []OpLogEntry) (startA, startB int, err error) {
+	startA, hashA := afterBase(a)
+	startB, hashB := afterBase(b)
+	if hashA == hashB {
+		return startA, startB, nil
+	}
+	for i := startA; i < len(a); i++ {
+		if a[i].Hash == hashB {
+			return i + 1, startB, nil
+		}
+	}
+	for j := startB; j < len(b); j++ {
+		if b[j].Hash == hashA {
+			return startA, j + 1, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("the logs never had the same text to start comparing from")
+}
+
+// afterBase returns the index after a log's last base entry and the hash of the
+// text there, or 0 and the empty text's hash without one
+func afterBase(log []OpLogEntry) (int, string) {
+	for i := len(log) - 1; i >= 0; i-- {
+		if log[i].Kind == OpBase {
+			return i + 1, log[i].Hash
+		}
+	}
+	return 0, hashText("")
+}
+
+// sharedOps returns the entries whose operation the other log has too, adding
+// the rest to only
+func sharedOps(log []OpLogEntry, other map[string]bool, only *[]OpLogEntry) []OpLogEntry {
+	var shared []OpLogEntry
+	for _, e := range log {
+		if e.Kind == OpBase {
+			continue
+		}
+		if other[e.key()] {
+			shared = append(shared, e)
+		} else {
+			*only = append(*only, e)
+		}
+	}
+	return shared
+}
+
+func (d Divergence) String() string {
+	var b strings.Builder
+	if !d.Diverged {
+		fmt.Fprintf(&b, "The replicas agree (through entry %d of the first log and %d of the second)\n", d.AgreedA, d.AgreedB)
+	} else {
+		fmt.Fprintf(&b, "The replicas diverged after agreeing through entry %d of the first log and %d of the second\n", d.AgreedA, d.AgreedB)
+		if len(d.SuspectsA) == 1 && len(d.SuspectsB) == 1 {
+			fmt.Fprintf(&b, "First operation after which they differ: %s\n", d.SuspectsA[0])
+		} else {
+			b.WriteString("Operations applied in between, in the first log:\n")
+			for _, e := range d.SuspectsA {
+				fmt.Fprintf(&b, "  %s\n", e)
+			}
+			b.WriteString("and in the second:\n")
+			for _, e := range d.SuspectsB {
+				fmt.Fprintf(&b, "  %s\n", e)
+			}
+		}
+	}
+	for _, only := range []struct {
+		name string
+		ops  []OpLogEntry
+	}{{"first", d.OnlyA}, {"second", d.OnlyB}} {
+		if len(only.ops) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "%d operation(s) only the %s replica applied, such as %s\n", len(only.ops), only.name, only.ops[0])
+	}
+	return b.String()
+}
+
+// formatPosition prints a position as digit.node pairs
+func formatPosition(pos []crdt.Identifier) string {
+	parts := make([]string, len(pos))
+	for i, ident := range pos {
+		parts[i] = fmt.Sprintf("%d.%d", ident.Digit, ident.Node)
+	}
+	return strings.Join(parts, ",")
+}
+
+// hashText returns a short hash identifying a document's text
+func hashText(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,169 @@
+package replay
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gollaborate/crdt"
+)
+
+// insert returns a change inserting a character at a one-digit position
+func insert(digit, node, clock int, value rune) crdt.Change {
+	pos := []crdt.Identifier{{Digit: digit, Node: node}}
+	return crdt.Change{Char: crdt.Character{Pos: pos, Clock: clock, Value: value}}
+}
+
+// readBack reads what a logger wrote
+func readBack(t *testing.T, buf *bytes.Buffer) []OpLogEntry {
+	t.Helper()
+	entries, err := ReadOpLog(buf)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	return entries
+}
+
+func TestCompareOpLogsConcurrentOrder(t *testing.T) {
+	// Two replicas each insert a character and then apply the other's
+	docA, docB := crdt.FromText("", 1), crdt.FromText("", 2)
+	var bufA, bufB bytes.Buffer
+	for _, r := range []struct {
+		doc *crdt.Document
+		log *OpLogger
+	}{{docA, NewOpLogger(&bufA)}, {docB, NewOpLogger(&bufB)}} {
+		doc, log := r.doc, r.log
+		log.Base(doc.ToText())
+		doc.AddChangeListener(func(c crdt.Change) { log.Change(c, doc.ToText()) })
+	}
+
+	posA, err := docA.InsertAtOffset(0, 'x', 1, 1)
+	if err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	posB, err := docB.InsertAtOffset(0, 'y', 2, 1)
+	if err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	if err := docA.InsertCharacter('y', posB, 1); err != nil {
+		t.Fatalf("Failed to apply: %v", err)
+	}
+	if err := docB.InsertCharacter('x', posA, 1); err != nil {
+		t.Fatalf("Failed to apply: %v", err)
+	}
+
+	d, err := CompareOpLogs(readBack(t, &bufA), readBack(t, &bufB))
+	if err != nil {
+		t.Fatalf("Failed to compare: %v", err)
+	}
+	if d.Diverged || d.AgreedA != 3 || d.AgreedB != 3 {
+		t.Errorf("Expected the replicas to agree through both logs, got %+v", d)
+	}
+	if !strings.Contains(d.String(), "agree") {
+		t.Errorf("Unexpected report: %s", d)
+	}
+}
+
+func TestCompareOpLogsDivergence(t *testing.T) {
+	var bufA, bufB bytes.Buffer
+	a, b := NewOpLogger(&bufA), NewOpLogger(&bufB)
+	a.Base("")
+	a.Change(insert(10, 1, 1, 'a'), "a")
+	a.Change(insert(20, 1, 2, 'b'), "ab")
+	a.Change(insert(30, 1, 3, 'c'), "abc")
+	b.Base("")
+	b.Change(insert(10, 1, 1, 'a'), "a")
+	b.Change(insert(20, 1, 2, 'b'), "ba") // Applied in the wrong place
+	b.Change(insert(30, 1, 3, 'c'), "bac")
+
+	d, err := CompareOpLogs(readBack(t, &bufA), readBack(t, &bufB))
+	if err != nil {
+		t.Fatalf("Failed to compare: %v", err)
+	}
+	if !d.Diverged || d.AgreedA != 2 || d.AgreedB != 2 {
+		t.Fatalf("Expected divergence after entry 2, got %+v", d)
+	}
+	if len(d.SuspectsA) != 1 || d.SuspectsA[0].N != 3 || d.SuspectsA[0].Char != "b" {
+		t.Errorf("Expected the insert of b to be pinpointed, got %v", d.SuspectsA)
+	}
+	if !strings.Contains(d.String(), `First operation after which they differ: #3 insert "b" at 20.1 clock 2`) {
+		t.Errorf("Unexpected report: %s", d)
+	}
+}
+
+func TestCompareOpLogsMissingOperation(t *testing.T) {
+	var bufA, bufB bytes.Buffer
+	a, b := NewOpLogger(&bufA), NewOpLogger(&bufB)
+	a.Base("")
+	a.Change(insert(10, 1, 1, 'a'), "a")
+	a.Change(insert(20, 2, 1, 'b'), "ab")
+	a.Change(insert(30, 1, 2, 'c'), "abc")
+	b.Base("")
+	b.Change(insert(10, 1, 1, 'a'), "a")
+	b.Change(insert(30, 1, 2, 'c'), "ac")
+
+	d, err := CompareOpLogs(readBack(t, &bufA), readBack(t, &bufB))
+	if err != nil {
+		t.Fatalf("Failed to compare: %v", err)
+	}
+	if len(d.OnlyA) != 1 || d.OnlyA[0].Char != "b" || len(d.OnlyB) != 0 {
+		t.Errorf("Expected b to be applied only by the first replica, got %v and %v", d.OnlyA, d.OnlyB)
+	}
+	if !d.Diverged || d.AgreedA != 2 {
+		t.Errorf("Expected divergence after the first insert, got %+v", d)
+	}
+}
+
+func TestCompareOpLogsFromSync(t *testing.T) {
+	var bufA, bufB bytes.Buffer
+	a, b := NewOpLogger(&bufA), NewOpLogger(&bufB)
+	a.Base("")
+	a.Change(insert(10, 1, 1, 'a'), "a")
+	a.Change(insert(20, 1, 2, 'b'), "ab")
+	a.Change(insert(30, 1, 3, 'c'), "abc")
+	// The second replica joined once the text was "ab"
+	b.Base("")
+	b.Base("ab")
+	b.Change(insert(30, 1, 3, 'c'), "abc")
+
+	d, err := CompareOpLogs(readBack(t, &bufA), readBack(t, &bufB))
+	if err != nil {
+		t.Fatalf("Failed to compare: %v", err)
+	}
+	if d.Diverged || d.AgreedA != 4 || d.AgreedB != 3 || len(d.OnlyA) != 0 {
+		t.Errorf("Expected the replicas to agree from the sync on, got %+v", d)
+	}
+
+	var bufC bytes.Buffer
+	c := NewOpLogger(&bufC)
+	c.Base("unrelated")
+	if _, err := CompareOpLogs(readBack(t, &bufC), d.SuspectsA); err == nil {
+		t.Error("Expected logs that never had the same text not to be compared")
+	}
+}
+
+func TestReadOpLog(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewOpLogger(&buf)
+	l.Base("")
+	c := insert(5, 3, 7, 'é')
+	c.Char.Pos = append(c.Char.Pos, crdt.Identifier{Digit: 9, Node: 4})
+	l.Change(c, "é")
+	l.Change(crdt.Change{Delete: true, Char: c.Char}, "")
+
+	entries := readBack(t, &buf)
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	want := OpLogEntry{N: 2, Kind: OpInsert, Pos: "5.3,9.4", Clock: 7, Char: "é", Hash: hashText("é")}
+	if entries[1] != want {
+		t.Errorf("Expected %+v, got %+v", want, entries[1])
+	}
+	if entries[2].Kind != OpDelete || entries[2].Hash != entries[0].Hash {
+		t.Errorf("Expected a delete back to the empty text, got %+v", entries[2])
+	}
+
+	if _, err := ReadOpLog(strings.NewReader("{not json\n")); err == nil {
+		t.Error("Expected an error for a malformed entry")
+	}
+}
@@ -0,0 +1,85 @@
+// Package replay records how a document changes over a session and exports the
+// recording for playback.
+package replay
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Frame is the full document text at a moment in the session
+type Frame struct {
+	Offset time.Duration `json:"offset"` // Time since the session started
+	Text   string        `json:"text"`
+}
+
+// Session is a recorded editing session
+type Session struct {
+	Name    string    `json:"name"`
+	Started time.Time `json:"started"`
+	Frames  []Frame   `json:"frames"`
+}
+
+// Recorder captures a frame each time the document text changes
+type Recorder struct {
+	mutex   sync.Mutex
+	session Session
+	now     func() time.Time
+}
+
+// NewRecorder starts recording a session with the given name
+func NewRecorder(name string) *Recorder {
+	return &Recorder{
+		session: Session{Name: name, Started: time.Now()},
+		now:     time.Now,
+	}
+}
+
+// Capture records the document text if it differs from the last frame
+func (r *Recorder) Capture(text string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	frames := r.session.Frames
+	if len(frames) > 0 && frames[len(frames)-1].Text == text {
+		return
+	}
+	r.session.Frames = append(frames, Frame{
+		Offset: r.now().Sub(r.session.Started),
+		Text:   text,
+	})
+}
+
+// Session returns a copy of everything recorded so far
+func (r *Recorder) Session() *Session {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	session := r.session
+	session.Frames = append([]Frame(nil), r.session.Frames...)
+	return &session
+}
+
+// Save writes the recording to a file as JSON
+func (r *Recorder) Save(path string) error {
+	data, err := json.Marshal(r.Session())
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Load reads a recording written by Save
+func Load(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
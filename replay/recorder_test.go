@@ -0,0 +1,58 @@
+package replay
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecorderCapturesChanges(t *testing.T) {
+	rec := NewRecorder("notes.txt")
+	clock := rec.session.Started
+	rec.now = func() time.Time { return clock }
+
+	rec.Capture("H")
+	clock = clock.Add(time.Second)
+	rec.Capture("H")
+	clock = clock.Add(time.Second)
+	rec.Capture("Hi")
+
+	session := rec.Session()
+	if len(session.Frames) != 2 {
+		t.Fatalf("Expected 2 frames (unchanged text skipped), got %d", len(session.Frames))
+	}
+	if session.Frames[1].Text != "Hi" {
+		t.Errorf("Expected second frame 'Hi', got %q", session.Frames[1].Text)
+	}
+	if session.Frames[1].Offset != 2*time.Second {
+		t.Errorf("Expected second frame at 2s, got %v", session.Frames[1].Offset)
+	}
+
+	// The returned session is a copy
+	session.Frames[0].Text = "changed"
+	if rec.Session().Frames[0].Text != "H" {
+		t.Error("Modifying the returned session should not affect the recorder")
+	}
+}
+
+func TestRecorderSaveAndLoad(t *testing.T) {
+	rec := NewRecorder("notes.txt")
+	rec.Capture("one")
+	rec.Capture("one\ntwo")
+
+	path := filepath.Join(t.TempDir(), "session.json")
+	if err := rec.Save(path); err != nil {
+		t.Fatalf("Failed to save recording: %v", err)
+	}
+
+	session, err := Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load recording: %v", err)
+	}
+	if session.Name != "notes.txt" {
+		t.Errorf("Expected name 'notes.txt', got %q", session.Name)
+	}
+	if len(session.Frames) != 2 || session.Frames[1].Text != "one\ntwo" {
+		t.Errorf("Frames did not survive a round trip: %+v", session.Frames)
+	}
+}
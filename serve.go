@@ -0,0 +1,412 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"gollaborate/autosave"
+	"gollaborate/config"
+	"gollaborate/crdt"
+	"gollaborate/gollabserver"
+	"gollaborate/messages"
+	"gollaborate/pubsub"
+	"gollaborate/replay"
+	"gollaborate/server"
+	"gollaborate/shared"
+	"gollaborate/storage"
+	core "gollaborate/tui"
+	"gollaborate/users"
+)
+
+// runServe runs a headless node that hosts a document and relays edits between clients
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configFile := fs.String("config", "", "Read settings from this YAML, TOML or JSON file, named as these flags are, such as port: 9000 or max-clients: 200; flags given here take precedence")
+	servePort := fs.Int("port", defaultPort, "Port to listen on, on every interface, unless --listen is given")
+	listenAddrs := fs.String("listen", "", "Listen on each of these comma-separated addresses instead of --port, such as 0.0.0.0:8080,[::1]:8080,unix:/run/gollaborate.sock")
+	serveNode := fs.Int("node", 0, "Node ID (0 for random)")
+	serveFile := fs.String("file", "", "Text file to host (optional)")
+	reloadEvery := fs.Duration("reload-interval", 2*time.Second, "Check --file this often for changes made on disk, such as by git pull, and send them to clients (0 never does)")
+	serveLang := fs.String("lang", "", "Default document language (detected from --file when empty)")
+	parkAfter := fs.Duration("park-after", 0, "Snapshot and unload the document after this long without clients (0 disables)")
+	parkDir := fs.String("park-dir", os.TempDir(), "Directory for parked document snapshots")
+	adminTUI := fs.Bool("admin-tui", false, "Show a live dashboard of users, throughput and errors")
+	maxHistory := fs.Int("max-history", 0, "Keep at most this many edits in the history (0 keeps all)")
+	maxTombstones := fs.Int("max-tombstones", 0, "Remember at most this many deleted characters for merging (0 keeps all)")
+	codecName := fs.String("codec", "json", "Message encoding to use with clients that support it (json, protobuf, msgpack)")
+	transportName := fs.String("transport", "tcp", "How clients connect (tcp or ws)")
+	tlsSettings := addTLSFlags(fs)
+	sendSettings := addSendFlags(fs)
+	grpcAddr := fs.String("grpc", "", "Also serve the gRPC API on this address, such as :8443 (always over TLS)")
+	wsAddr := fs.String("ws", "", "Also accept clients over WebSockets on this address, such as :8081, at "+messages.WebSocketPath+" (over TLS with --tls)")
+	themeName := fs.String("theme", "", "Color theme of the admin TUI: color, high-contrast or no-color (no-color when NO_COLOR is set)")
+	noColor := fs.Bool("no-color", false, "Use no colors in the admin TUI, same as --theme no-color")
+	crashDir := fs.String("crash-dir", os.TempDir(), "Directory for crash reports and the document saved with them")
+	restoreQuorum := fs.Float64("restore-quorum", 0, "Fraction of the connected editors who must approve restoring an old version (0 needs no approval)")
+	logSettings := addLogFlags(fs, "Also append diagnostics to this file (the only place they go while the admin TUI runs)")
+	quotaOps := fs.Int("quota-ops", 0, "Operations each user may send per minute (0 for no limit)")
+	quotaPaste := fs.Int("quota-paste", 0, "Characters each user may insert with a single edit, such as a paste (0 for no limit)")
+	opLogFile := fs.String("oplog", "", "Log the order operations are applied in to this file, to compare with 'oplog-diff'")
+	trace := fs.Bool("trace", false, "Log every message sent to and received from clients")
+	storeKind := fs.String("store", "", fmt.Sprintf("Keep documents between runs in a store: %s (none when empty)", strings.Join(storage.Kinds(), " or ")))
+	storePath := fs.String("store-path", "gollaborate-data", "Directory, or database file, the store is kept in")
+	autosaveSettings := addAutosaveFlags(fs, "Save a document to the --store once the last client editing it leaves")
+	authToken := fs.String("auth-token", "", "Admit only clients presenting this token, with --token (anyone when empty)")
+	authUsers := fs.String("auth-users", "", "Admit only the users listed in this file, one name:token or name:token:role per line, as well as those with --auth-token")
+	authRole := fs.String("auth-role", "editor", "Role of clients without one of their own: viewer, editor or admin")
+	sessionGrace := fs.Duration("session-grace", server.DefaultSessionGrace, "How long a client that lost its connection may reconnect as the same user, sent only what it missed (0 disables)")
+	digestEvery := fs.Duration("resync-interval", shared.DefaultDigestInterval, "How often clients are sent a digest of the document, by which those whose copy diverged resync (0 disables)")
+	idleAfter := fs.Duration("idle-after", 0, "Tell everyone when a client has done nothing for this long, such as 10m (0 leaves it to the client)")
+	evictAfter := fs.Duration("evict-after", 0, "Disconnect clients that have done nothing for this long, such as 1h (0 never does)")
+	maxClients := fs.Int("max-clients", 0, "Clients the server takes at once, over every document (0 for no limit)")
+	maxRoomClients := fs.Int("max-room-clients", 0, "Clients the server takes at once in any one document (0 for no limit)")
+	maxWaiting := fs.Int("max-waiting", 0, "Clients that may wait for a place once the server is full, rather than be refused at once")
+	maxWait := fs.Duration("max-wait", server.DefaultMaxWait, "How long clients wait for a place with --max-waiting")
+	templateDir := fs.String("template-dir", "", "Offer the files in this directory as templates clients can start new documents from with --template, besides the built-in meeting-notes and scratchpad")
+	healthAddr := fs.String("health-addr", "", "Answer health checks at "+server.LivenessPath+" and "+server.ReadinessPath+" on this address, such as :8082, for load balancers and Kubernetes")
+	adminSocket := fs.String("admin-socket", "", "Take commands from 'gollaborate admin' on this Unix socket, which only the current user may use")
+	adminAddr := fs.String("admin-addr", "", "Take commands from 'gollaborate admin' on this TCP address, such as 127.0.0.1:8090, with --admin-token")
+	adminToken := fs.String("admin-token", "", "Token 'gollaborate admin' must present over --admin-addr")
+	pubsubURL := fs.String("pubsub", "", "Host the same documents as every server using this broker, such as redis://HOST:6379 or nats://HOST:4222; give each server the same --store and its own --node")
+	pubsubTopic := fs.String("pubsub-topic", server.DefaultClusterTopic, "Topic servers sharing documents through --pubsub publish on")
+	webhookSettings := addWebhookFlags(fs)
+	_ = fs.Parse(args)
+	if *configFile != "" {
+		settings, err := config.Load(*configFile)
+		if err == nil {
+			err = settings.Apply(fs)
+		}
+		if err != nil {
+			log.Fatalf("Failed to read the config file: %v", err)
+		}
+	}
+
+	logs, err := setupLogging(*logSettings)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	defer logs.Close()
+	logger := moduleLogger("server")
+	if *trace {
+		messages.Use(messages.DebugLog(nil))
+	}
+
+	codec, ok := messages.CodecByName(*codecName)
+	if !ok {
+		fatal(logger, "Unknown codec", "codec", *codecName, "codecs", messages.Codecs())
+	}
+	network, err := chooseNetwork(*transportName, tlsSettings)
+	if err != nil {
+		fatal(logger, "Failed to set up the network", "err", err)
+	}
+
+	serverNodeID := *serveNode
+	if serverNodeID == 0 {
+		serverNodeID = rand.Intn(999) + 1
+	}
+
+	name := "untitled"
+	doc := crdt.FromText("", serverNodeID)
+	if *serveFile != "" {
+		name = filepath.Base(*serveFile)
+		loaded, err := loadDocument(*serveFile, serverNodeID)
+		if err != nil {
+			logger.Warn("Failed to load file, starting with empty document", "file", *serveFile, "err", err)
+		} else {
+			doc = loaded
+			logger.Info("Loaded document", "file", *serveFile)
+		}
+	}
+
+	doc.Metadata.Language = documentLanguage(*serveLang, *serveFile)
+
+	// Without a file, carry on with the document the store kept from the last run
+	var store storage.Store
+	if *storeKind != "" {
+		store, err = storage.Open(*storeKind, *storePath)
+		if err != nil {
+			fatal(logger, "Failed to open the store", "err", err)
+		}
+		if *serveFile == "" {
+			stored, err := store.LoadDocument(name)
+			switch {
+			case err == nil:
+				doc = stored
+				// With the edits made since it was last saved
+				if logged, err := store.Ops(name); err != nil {
+					logger.Warn("Failed to load the edits from the store", "room", name, "err", err)
+				} else {
+					storage.ApplyOps(doc, logged)
+				}
+				logger.Info("Loaded document from the store", "room", name)
+			case !errors.Is(err, storage.ErrNotFound):
+				logger.Warn("Failed to load from the store, starting with empty document", "room", name, "err", err)
+			}
+		}
+	}
+
+	auth := server.Auth{Token: *authToken}
+	if auth.DefaultRole, err = users.ParseRole(*authRole); err != nil {
+		fatal(logger, "Invalid role", "err", err)
+	}
+	if *authUsers != "" {
+		if auth.Users, auth.Roles, err = loadAuthUsers(*authUsers); err != nil {
+			fatal(logger, "Failed to load users", "file", *authUsers, "err", err)
+		}
+	}
+	hooks, err := webhookSettings.hooks()
+	if err != nil {
+		fatal(logger, "Invalid webhook settings", "err", err)
+	}
+	policy := autosaveSettings.policy()
+	if policy.Enabled() && store == nil {
+		logger.Warn("Autosaving needs a --store to save to, ignoring --autosave flags")
+		policy = autosave.Policy{}
+	}
+	var broker pubsub.Broker
+	if *pubsubURL != "" {
+		if broker, err = pubsub.Open(*pubsubURL); err != nil {
+			fatal(logger, "Failed to connect to the pub/sub broker", "err", err)
+		}
+		logger.Info("Sharing documents with other servers", "broker", *pubsubURL)
+	}
+	digestInterval := *digestEvery
+	if digestInterval == 0 {
+		digestInterval = -1
+	}
+
+	srv, err := gollabserver.New(gollabserver.Config{
+		Name:     name,
+		NodeID:   serverNodeID,
+		Document: doc,
+		Logger:   logger,
+		Codec:    codec,
+		Limits:   crdt.Limits{MaxHistory: *maxHistory, MaxTombstones: *maxTombstones},
+		Store:    store,
+		Autosave: policy,
+		Auth:     auth,
+		Quotas:   server.Quotas{OpsPerMinute: *quotaOps, MaxPasteSize: *quotaPaste},
+		Capacity: server.Capacity{MaxClients: *maxClients, MaxRoomClients: *maxRoomClients, MaxWaiting: *maxWaiting, MaxWait: *maxWait},
+		// Every room a client opens is set up alike
+		ConfigureRoom: func(_ string, state *shared.EditorState) {
+			_ = applySendFlags(state, sendSettings) // Checked below
+			state.RequireApproval(messages.TransactionActionRestore, *restoreQuorum)
+		},
+		SessionGrace:   *sessionGrace,
+		DigestInterval: digestInterval,
+		IdleAfter:      *idleAfter,
+		EvictAfter:     *evictAfter,
+		TemplateDir:    *templateDir,
+		Webhooks:       hooks,
+		WebhookIdle:    *webhookSettings.idle,
+		Broker:         broker,
+		Topic:          *pubsubTopic,
+	})
+	if err != nil {
+		fatal(logger, "Failed to start the server", "err", err)
+	}
+	if err := applySendFlags(srv.State(), sendSettings); err != nil {
+		fatal(logger, "Invalid send settings", "err", err)
+	}
+	newCrashReporter(*crashDir, srv.State())
+	if *opLogFile != "" {
+		f, err := os.Create(*opLogFile)
+		if err != nil {
+			fatal(logger, "Failed to create the operation log", "file", *opLogFile, "err", err)
+		}
+		defer f.Close()
+		srv.State().LogChanges(replay.NewOpLogger(f))
+	}
+	if *parkAfter > 0 {
+		srv.EnableParking(*parkAfter, *parkDir)
+	}
+	if *serveFile != "" && *reloadEvery > 0 {
+		srv.WatchFile(*serveFile, *reloadEvery)
+	}
+
+	addrs := []string{fmt.Sprintf(":%d", *servePort)}
+	if *listenAddrs != "" {
+		addrs = strings.Split(*listenAddrs, ",")
+	}
+	for _, addr := range addrs {
+		listener, err := listenClients(network, strings.TrimSpace(addr))
+		if err != nil {
+			fatal(logger, "Failed to start listener", "addr", addr, "err", err)
+		}
+		logger.Info("Serving", "room", name, "addr", listener.Addr())
+		go func() {
+			if err := srv.Serve(listener); err != nil {
+				logger.Error("Server stopped", "err", err)
+			}
+		}()
+	}
+	if *grpcAddr != "" {
+		serveGRPC(srv.Server, *grpcAddr, tlsSettings)
+	}
+	if *wsAddr != "" {
+		serveWebSocket(srv.Server, *wsAddr, tlsSettings)
+	}
+	if *healthAddr != "" {
+		serveHealth(srv.Server, *healthAddr)
+	}
+	if *adminSocket != "" {
+		serveControl(srv.Server, "unix", *adminSocket, "")
+	}
+	if *adminAddr != "" {
+		if *adminToken == "" {
+			fatal(logger, "--admin-addr needs an --admin-token, as anyone reaching the address could run admin commands")
+		}
+		serveControl(srv.Server, "tcp", *adminAddr, *adminToken)
+	}
+
+	// Save the document on the way out if it came from a file
+	shutdown := func() {
+		if err := srv.Close(); err != nil {
+			logger.Error("Error closing the server", "err", err)
+		}
+		if broker != nil {
+			if err := broker.Close(); err != nil {
+				logger.Error("Error closing the pub/sub broker", "err", err)
+			}
+		}
+		if store != nil {
+			if err := store.Close(); err != nil {
+				logger.Error("Error closing the store", "err", err)
+			}
+		}
+		if *serveFile != "" {
+			doc, err := srv.Document()
+			if err != nil {
+				logger.Error("Error saving document", "file", *serveFile, "err", err)
+				return
+			}
+			if err := saveDocument(*serveFile, doc); err != nil {
+				logger.Error("Error saving document", "file", *serveFile, "err", err)
+			} else {
+				logger.Info("Document saved", "file", *serveFile)
+			}
+		}
+	}
+
+	if *adminTUI {
+		core.SetTheme(chooseTheme(*themeName, *noColor))
+		logs.SetTUI(true)
+		err := core.StartAdminTUI(srv.Server)
+		logs.SetTUI(false)
+		if err != nil {
+			logger.Error("Error running admin TUI", "err", err)
+		}
+		shutdown()
+		return
+	}
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	<-c
+	logger.Info("Shutting down")
+	shutdown()
+}
+
+// serveGRPC serves the server's gRPC API on an address, over TLS
+func serveGRPC(srv *server.Server, addr string, t tlsFlags) {
+	logger := moduleLogger("grpc")
+	config, err := tlsConfig(t)
+	if err != nil {
+		fatal(logger, "Failed to set up TLS for gRPC", "err", err)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal(logger, "Failed to start gRPC listener", "err", err)
+	}
+	logger.Info("Serving the gRPC API", "addr", listener.Addr())
+	go func() {
+		if err := srv.ServeGRPC(listener, config); err != nil {
+			logger.Error("gRPC server stopped", "err", err)
+		}
+	}()
+}
+
+// serveHealth answers health checks on an address
+func serveHealth(srv *server.Server, addr string) {
+	logger := moduleLogger("health")
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal(logger, "Failed to start health listener", "err", err)
+	}
+	logger.Info("Answering health checks", "addr", listener.Addr())
+	go func() {
+		if err := srv.ServeHealth(listener); err != nil {
+			logger.Error("Health listener stopped", "err", err)
+		}
+	}()
+}
+
+// listenClients listens for clients on an address of the network, replacing a
+// Unix socket left over from an earlier run
+func listenClients(network messages.Network, addr string) (messages.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, messages.UnixPrefix); ok {
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(path)
+		}
+	}
+	return network.Listen(addr)
+}
+
+// serveControl takes admin commands on an address, from those presenting the
+// token if there is one. A Unix socket left over from an earlier run is
+// replaced, and only the current user may use it.
+func serveControl(srv *server.Server, network, addr, token string) {
+	logger := moduleLogger("admin")
+	if network == "unix" {
+		_ = os.Remove(addr)
+	}
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		fatal(logger, "Failed to start admin listener", "err", err)
+	}
+	if network == "unix" {
+		if err := os.Chmod(addr, 0o600); err != nil {
+			fatal(logger, "Failed to restrict the admin socket", "err", err)
+		}
+	}
+	logger.Info("Taking admin commands", "addr", listener.Addr())
+	go func() {
+		if err := srv.ServeControl(listener, token); err != nil {
+			logger.Error("Admin listener stopped", "err", err)
+		}
+	}()
+}
+
+// serveWebSocket accepts clients over WebSockets on an address, over TLS when
+// the flags ask for it
+func serveWebSocket(srv *server.Server, addr string, t tlsFlags) {
+	logger := moduleLogger("websocket")
+	var config *tls.Config
+	if *t.enabled || *t.certFile != "" {
+		var err error
+		if config, err = tlsConfig(t); err != nil {
+			fatal(logger, "Failed to set up TLS for WebSockets", "err", err)
+		}
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal(logger, "Failed to start WebSocket listener", "err", err)
+	}
+	logger.Info("Accepting WebSocket clients", "addr", listener.Addr(), "path", messages.WebSocketPath)
+	go func() {
+		if err := srv.ServeWebSocket(listener, config); err != nil {
+			logger.Error("WebSocket server stopped", "err", err)
+		}
+	}()
+}
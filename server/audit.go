@@ -0,0 +1,109 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gollaborate/messages"
+	"gollaborate/storage"
+)
+
+// auditEntries sums up edits by who made them: an entry per user and name, in
+// the order they first edited. Clients' edits carry the user ID of the
+// connection they arrived on, and the name it went by when they were taken;
+// others are named as their users are connected to the room if they are. The
+// caller must hold s.mutex.
+func (s *Server) auditEntries(r *room, ops []storage.LoggedOp) []storage.AuditEntry {
+	names := make(map[int]string)
+	for _, c := range s.clients {
+		if c.room == r && c.userName != "" {
+			names[c.userID] = c.userName
+		}
+	}
+
+	type author struct {
+		userID   int
+		userName string
+	}
+	var entries []storage.AuditEntry
+	index := make(map[author]int)
+	for _, logged := range ops {
+		name := logged.Author
+		if name == "" {
+			name = names[logged.Op.UserID]
+		}
+		key := author{logged.Op.UserID, name}
+		i, ok := index[key]
+		if !ok {
+			i = len(entries)
+			index[key] = i
+			entries = append(entries, storage.AuditEntry{Time: logged.Time, UserID: key.userID, UserName: name})
+		}
+		if logged.Op.Type == messages.OperationTypeDelete {
+			entries[i].Deleted++
+		} else {
+			entries[i].Inserted++
+		}
+	}
+	return entries
+}
+
+// audit adds to a room's audit trail who made the edits appended to its
+// history: to the store's trail, or without a store to the room's own
+func (s *Server) audit(r *room, store storage.Store, ops []storage.LoggedOp) error {
+	s.mutex.Lock()
+	entries := s.auditEntries(r, ops)
+	if store == nil {
+		r.audit = append(r.audit, entries...)
+	}
+	s.mutex.Unlock()
+	if store == nil {
+		return nil
+	}
+	return store.AppendAudit(r.name, entries)
+}
+
+// Audit returns a document's audit trail, oldest first: who changed it, when
+// and how much, summed up over each second of editing. The empty name is the
+// server's own document. The trail is kept in the store, for good and apart
+// from the document; a server without one keeps the trail of each open room
+// for as long as it is open.
+func (s *Server) Audit(name string) ([]storage.AuditEntry, error) {
+	s.mutex.Lock()
+	if name == "" {
+		name = s.name
+	}
+	r, ok := s.rooms[name]
+	store := s.store
+	var trail []storage.AuditEntry
+	if ok {
+		trail = append(trail, r.audit...)
+	}
+	s.mutex.Unlock()
+
+	if store != nil {
+		return store.Audit(name)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrRoomNotOpen, name)
+	}
+	return trail, nil
+}
+
+// auditReport lists the last entries of an audit trail, or all of them if
+// last is zero, one per line
+func auditReport(trail []storage.AuditEntry, last int) string {
+	if last > 0 && len(trail) > last {
+		trail = trail[len(trail)-last:]
+	}
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tUSER\tNAME\tCHANGE")
+	for _, entry := range trail {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", entry.Time.Format(time.DateTime), entry.UserID, entry.UserName, entry.Summary())
+	}
+	_ = w.Flush()
+	return b.String()
+}
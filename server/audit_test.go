@@ -0,0 +1,176 @@
+package server
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/storage"
+)
+
+// flushAudit appends the edits made in every room to their logs and audit
+// trails, as the server does every historyFlushInterval
+func flushAudit(t *testing.T, srv *Server) {
+	t.Helper()
+
+	srv.mutex.Lock()
+	rooms := srv.roomList()
+	srv.mutex.Unlock()
+	if err := srv.appendHistory(rooms); err != nil {
+		t.Fatalf("Failed to log edits: %v", err)
+	}
+}
+
+func TestServerAudit(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	alice := dialTestClient(t, addr)
+	if err := messages.SendMessage(alice, messages.NewHelloMessage(3, "alice", "")); err != nil {
+		t.Fatalf("Failed to say hello: %v", err)
+	}
+	expectRoster(t, alice, messages.RosterEntry{UserID: 3, UserName: "alice", Role: "editor"})
+
+	a := []crdt.Identifier{{Digit: 5, Node: 3}}
+	for _, op := range []*messages.Operation{
+		messages.NewInsertOperation(a, 'a', 3, 1),
+		messages.NewInsertOperation([]crdt.Identifier{{Digit: 6, Node: 3}}, 'b', 3, 2),
+		messages.NewDeleteOperation(a, 3, 3),
+	} {
+		if err := messages.SendOperation(alice, op); err != nil {
+			t.Fatalf("Failed to send operation: %v", err)
+		}
+	}
+	waitForHistory(t, srv, "", "b")
+	flushAudit(t, srv)
+
+	// Without a store, the open room keeps its trail
+	trail, err := srv.Audit("")
+	if err != nil || len(trail) != 1 {
+		t.Fatalf("Expected alice's edits in one entry, got %+v (%v)", trail, err)
+	}
+	if entry := trail[0]; entry.UserID != 3 || entry.UserName != "alice" || entry.Inserted != 2 || entry.Deleted != 1 {
+		t.Errorf("Expected alice's edits summed up, got %+v", entry)
+	}
+	if _, err := srv.Audit("lecture"); !errors.Is(err, ErrRoomNotOpen) {
+		t.Errorf("Expected no trail for a room never opened, got %v", err)
+	}
+
+	// Admins query it over the admin channel
+	ctl := dialControl(t, "tcp", startTestControl(t, srv, "tcp", ""))
+	reply := runControl(t, ctl, messages.NewAdminMessage(messages.AdminCommandAudit, 0, 0))
+	if reply.Type != messages.MessageTypeAdmin || !strings.Contains(reply.Text, "alice") || !strings.Contains(reply.Text, "inserted 2 and deleted 1 character") {
+		t.Errorf("Expected alice's edits listed, got %+v", reply)
+	}
+
+	// A store keeps the trail for good, whether or not the room is open
+	store, err := storage.OpenBoltStore(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+	srv.SetStore(store)
+	bob := dialRoomClient(t, addr, "notes")
+	if err := messages.SendOperation(bob, messages.NewInsertOperation([]crdt.Identifier{{Digit: 5, Node: 4}}, 'n', 4, 1)); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	waitForHistory(t, srv, "notes", "n")
+	flushAudit(t, srv)
+	if trail, err := store.Audit("notes"); err != nil || len(trail) != 1 || trail[0].UserID != 4 || trail[0].Inserted != 1 {
+		t.Errorf("Expected bob's edit in the store's trail, got %+v (%v)", trail, err)
+	}
+	notes := messages.NewAdminMessage(messages.AdminCommandAudit, 1, 0)
+	notes.Room = "notes"
+	if reply := runControl(t, ctl, notes); !strings.Contains(reply.Text, "inserted 1 character") {
+		t.Errorf("Expected bob's edit listed, got %+v", reply)
+	}
+	if err := store.AppendAudit("archived", []storage.AuditEntry{{Time: time.Now(), UserID: 5, UserName: "carol", Deleted: 4}}); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	archived := messages.NewAdminMessage(messages.AdminCommandAudit, 0, 0)
+	archived.Room = "archived"
+	if reply := runControl(t, ctl, archived); !strings.Contains(reply.Text, "carol") {
+		t.Errorf("Expected the trail of a room not open, got %+v", reply)
+	}
+}
+
+func TestServerAuditCreditsSender(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	alice := dialTestClient(t, addr)
+	bob := dialTestClient(t, addr)
+	for conn, hello := range map[*testClient]*messages.Message{
+		alice: messages.NewHelloMessage(3, "alice", ""),
+		bob:   messages.NewHelloMessage(4, "bob", ""),
+	} {
+		if err := messages.SendMessage(conn, hello); err != nil {
+			t.Fatalf("Failed to say hello: %v", err)
+		}
+	}
+	bobEntry := messages.RosterEntry{UserID: 4, UserName: "bob", Role: "editor"}
+	expectRoster(t, bob, messages.RosterEntry{UserID: 3, UserName: "alice", Role: "editor"}, bobEntry)
+
+	// Bob cannot slip an edit into the trail as alice
+	if err := messages.SendOperation(bob, messages.NewInsertOperation([]crdt.Identifier{{Digit: 4, Node: 3}}, 'x', 3, 1)); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	if msg := receiveError(t, bob); msg.Code != messages.ErrorCodeForbidden {
+		t.Errorf("Expected bob's edit as alice forbidden, got %+v", msg)
+	}
+	if err := messages.SendOperation(alice, messages.NewInsertOperation([]crdt.Identifier{{Digit: 5, Node: 3}}, 'a', 3, 1)); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	waitForHistory(t, srv, "", "a")
+
+	// Alice's edit stays hers after she leaves and someone else takes her ID
+	_ = alice.Close()
+	waitForClients(t, srv, 1)
+	mallory := dialTestClient(t, addr)
+	if err := messages.SendMessage(mallory, messages.NewHelloMessage(3, "mallory", "")); err != nil {
+		t.Fatalf("Failed to say hello: %v", err)
+	}
+	expectRoster(t, mallory, messages.RosterEntry{UserID: 3, UserName: "mallory", Role: "editor"}, bobEntry)
+	flushAudit(t, srv)
+
+	trail, err := srv.Audit("")
+	if err != nil || len(trail) != 1 {
+		t.Fatalf("Expected only alice's edit in the trail, got %+v (%v)", trail, err)
+	}
+	if entry := trail[0]; entry.UserID != 3 || entry.UserName != "alice" || entry.Inserted != 1 {
+		t.Errorf("Expected alice's edit credited to her, got %+v", entry)
+	}
+}
+
+func TestServerAuditNamesEachEdit(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	alice := dialTestClient(t, addr)
+	mallory := dialTestClient(t, addr)
+
+	// Without sign-in, two connections can go by the same ID under different names
+	if err := messages.SendMessage(alice, messages.NewHelloMessage(3, "alice", "")); err != nil {
+		t.Fatalf("Failed to say hello: %v", err)
+	}
+	if err := messages.SendOperation(alice, messages.NewInsertOperation([]crdt.Identifier{{Digit: 5, Node: 3}}, 'a', 3, 1)); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	waitForHistory(t, srv, "", "a")
+	if err := messages.SendMessage(mallory, messages.NewHelloMessage(3, "mallory", "")); err != nil {
+		t.Fatalf("Failed to say hello: %v", err)
+	}
+	if err := messages.SendOperation(mallory, messages.NewInsertOperation([]crdt.Identifier{{Digit: 6, Node: 3}}, 'm', 3, 2)); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	waitForHistory(t, srv, "", "am")
+	flushAudit(t, srv)
+
+	trail, err := srv.Audit("")
+	if err != nil || len(trail) != 2 {
+		t.Fatalf("Expected an entry for each name, got %+v (%v)", trail, err)
+	}
+	for i, name := range []string{"alice", "mallory"} {
+		if entry := trail[i]; entry.UserID != 3 || entry.UserName != name || entry.Inserted != 1 {
+			t.Errorf("Expected an edit credited to %s, got %+v", name, entry)
+		}
+	}
+}
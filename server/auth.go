@@ -0,0 +1,167 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gollaborate/messages"
+	"gollaborate/storage"
+	"gollaborate/users"
+)
+
+// authWait is how long a server asking for credentials waits for a new
+// connection to present them
+var authWait = 5 * time.Second
+
+// ErrUnauthorized refuses a client without valid credentials
+var ErrUnauthorized = errors.New("unauthorized: a valid token is needed")
+
+// Auth says which clients a server admits. With neither a token nor users it
+// admits everyone, as servers do unless told otherwise.
+type Auth struct {
+	// Token is a secret every client may present
+	Token string
+	// Users gives each user a token of their own, by user name. Their names
+	// cannot be claimed with the shared token.
+	Users map[string]string
+	// Roles gives users a role, by user name. Those not listed have
+	// DefaultRole, or are editors if it is empty.
+	Roles       map[string]users.Role
+	DefaultRole users.Role
+}
+
+// Required reports whether clients must present credentials
+func (a Auth) Required() bool {
+	return a.Token != "" || len(a.Users) > 0
+}
+
+// identify returns the name of the user a token belongs to. A client naming
+// no one may present any user's token; one naming someone without a token of
+// their own may present the shared one.
+func (a Auth) identify(name, token string) (string, bool) {
+	if own, ok := a.Users[name]; ok {
+		return name, tokenEqual(own, token)
+	}
+	if name == "" {
+		for user, own := range a.Users {
+			if tokenEqual(own, token) {
+				return user, true
+			}
+		}
+	}
+	return name, a.Token != "" && tokenEqual(a.Token, token)
+}
+
+// role returns the role of the named user
+func (a Auth) role(name string) users.Role {
+	if role, ok := a.Roles[name]; ok {
+		return role
+	}
+	if a.DefaultRole != "" {
+		return a.DefaultRole
+	}
+	return users.RoleEditor
+}
+
+// tokenEqual compares tokens in constant time, so timing gives none away
+func tokenEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// SetAuth makes the server refuse clients without valid credentials. Clients
+// present them with an auth message before joining a room, gRPC clients as a
+// bearer token. Clients with a join code from MintJoinCode are admitted
+// without them. Call it before Serve.
+func (s *Server) SetAuth(auth Auth) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.auth = auth
+}
+
+// authenticate checks the credentials a client presented, returning the
+// user they identify, or nil if the server asks for none. The user's profile
+// is kept in the store, if the server has one, keeping the color it was given
+// before; a client claiming the ID of another user is refused.
+func (s *Server) authenticate(auth Auth, msg *messages.Message) (*users.User, error) {
+	if !auth.Required() {
+		return nil, nil
+	}
+	name, ok := auth.identify(msg.UserName, msg.Token)
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+	user := &users.User{ID: msg.UserID, Name: name, Role: auth.role(name)}
+	if err := s.loadProfile(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// loadProfile ties the ID of a user who was admitted to their name, and keeps
+// their profile in the store, if the server has one, giving them the color
+// they were given before. The user ID comes from the client, so it is refused
+// if another user was admitted with it, or another user's profile has it,
+// rather than that user impersonated or their profile overwritten.
+func (s *Server) loadProfile(user *users.User) error {
+	if user.ID == 0 {
+		return nil
+	}
+	taken := fmt.Errorf("%w: user ID %d belongs to someone else", ErrUnauthorized, user.ID)
+	s.mutex.Lock()
+	store := s.store
+	s.mutex.Unlock()
+	save := store != nil
+	if store != nil {
+		known, err := store.Profile(user.ID)
+		switch {
+		case err == nil && known.Name != user.Name:
+			return taken
+		case err == nil:
+			user.Color = known.Color
+		case !errors.Is(err, storage.ErrNotFound):
+			// Without knowing whose the ID is, the profile is left as it is
+			s.recordError(fmt.Errorf("loading profile of %s: %w", user.Name, err))
+			save = false
+		}
+	}
+	if !s.bindUserID(user.ID, user.Name) {
+		return taken
+	}
+	if save {
+		if err := store.SaveProfile(*user); err != nil {
+			s.recordError(fmt.Errorf("saving profile of %s: %w", user.Name, err))
+		}
+	}
+	return nil
+}
+
+// bindUserID ties a user ID to the name of the first user admitted with it,
+// for as long as the server runs, reporting whether it is tied to that name
+func (s *Server) bindUserID(userID int, name string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if bound, ok := s.userNames[userID]; ok {
+		return bound == name
+	}
+	s.userNames[userID] = name
+	return true
+}
+
+// authenticateBearer checks a gRPC call's authorization header, returning the
+// user its token identifies
+func (s *Server) authenticateBearer(header string) (*users.User, error) {
+	s.mutex.Lock()
+	auth := s.auth
+	s.mutex.Unlock()
+	if !auth.Required() {
+		return nil, nil
+	}
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+	return s.authenticate(auth, messages.NewAuthMessage(token, "", 0))
+}
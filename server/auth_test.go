@@ -0,0 +1,201 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/storage"
+	"gollaborate/users"
+)
+
+// dialAuthClient connects to the server and presents a token, without
+// reading the answer
+func dialAuthClient(t *testing.T, addr, token, name string) *testClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	if err := messages.SendMessage(conn, messages.NewAuthMessage(token, name, 7)); err != nil {
+		t.Fatalf("Failed to authenticate: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	return &testClient{Conn: conn, Reader: messages.NewReader(conn)}
+}
+
+// expectRefused checks that the server refused a client as unauthorized and
+// closed its connection
+func expectRefused(t *testing.T, conn *testClient) {
+	t.Helper()
+
+	msg, err := conn.Receive()
+	if err != nil || msg.Type != messages.MessageTypeError || msg.Code != messages.ErrorCodeUnauthorized {
+		t.Fatalf("Expected the client refused as unauthorized, got %+v (%v)", msg, err)
+	}
+	if _, err := conn.Receive(); err == nil {
+		t.Error("Expected the connection closed after the refusal")
+	}
+}
+
+func TestServerAuthSharedToken(t *testing.T) {
+	srv, addr := startTestServer(t, "secret text")
+	srv.SetAuth(Auth{Token: "s3cret"})
+
+	alice := dialAuthClient(t, addr, "s3cret", "alice")
+	msg, err := alice.Receive()
+	if err != nil || msg.Type != messages.MessageTypeSync || msg.Document.ToText() != "secret text" {
+		t.Fatalf("Expected the document after authenticating, got %+v (%v)", msg, err)
+	}
+	stats := waitForClients(t, srv, 1)
+	if user := stats.Clients[0].User; user == nil || user.Name != "alice" || user.ID != 7 {
+		t.Errorf("Expected the client known as alice, got %+v", user)
+	}
+
+	expectRefused(t, dialAuthClient(t, addr, "guess", "mallory"))
+	if stats := srv.Stats(); len(stats.Clients) != 1 {
+		t.Errorf("Expected only the authenticated client, got %+v", stats.Clients)
+	}
+}
+
+func TestServerAuthUsers(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	srv.SetAuth(Auth{Token: "shared", Users: map[string]string{"ada": "ada-token"}})
+
+	// A user's own token names them, whatever name the client gives
+	ada := dialAuthClient(t, addr, "ada-token", "")
+	if msg, err := ada.Receive(); err != nil || msg.Type != messages.MessageTypeSync {
+		t.Fatalf("Expected the document, got %+v (%v)", msg, err)
+	}
+	stats := waitForClients(t, srv, 1)
+	if user := stats.Clients[0].User; user == nil || user.Name != "ada" {
+		t.Errorf("Expected the client known as ada, got %+v", user)
+	}
+	if stats.Clients[0].UserName != "ada" {
+		t.Errorf("Expected the client listed as ada, got %q", stats.Clients[0].UserName)
+	}
+
+	// Nor can the shared token claim their name
+	expectRefused(t, dialAuthClient(t, addr, "shared", "ada"))
+}
+
+func TestServerAuthKeepsProfiles(t *testing.T) {
+	store, err := storage.OpenFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if err := store.SaveProfile(users.User{ID: 7, Name: "ada", Color: "green"}); err != nil {
+		t.Fatalf("Failed to save profile: %v", err)
+	}
+	srv, addr := startTestServer(t, "")
+	srv.SetStore(store)
+	srv.SetAuth(Auth{Users: map[string]string{"ada": "ada-token", "eve": "eve-token"}})
+
+	// Eve cannot take ada's ID, which would overwrite her profile
+	expectRefused(t, dialAuthClient(t, addr, "eve-token", ""))
+	if user, err := store.Profile(7); err != nil || user.Name != "ada" || user.Color != "green" {
+		t.Errorf("Expected ada's profile kept, got %+v (%v)", user, err)
+	}
+
+	// Ada goes on with hers
+	ada := dialAuthClient(t, addr, "ada-token", "")
+	if msg, err := ada.Receive(); err != nil || msg.Type != messages.MessageTypeSync {
+		t.Fatalf("Expected the document, got %+v (%v)", msg, err)
+	}
+	if user := waitForClients(t, srv, 1).Clients[0].User; user == nil || user.Color != "green" {
+		t.Errorf("Expected ada given her color, got %+v", user)
+	}
+}
+
+func TestServerAuthBindsUserIDs(t *testing.T) {
+	srv, addr := startRolesServer(t, "")
+	eve := dialUserClient(t, addr, "eve-token", 4)
+
+	// Even without a store, viewer vic cannot take the ID eve was admitted with
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	if err := messages.SendMessage(conn, messages.NewAuthMessage("vic-token", "", 4)); err != nil {
+		t.Fatalf("Failed to authenticate: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	expectRefused(t, &testClient{Conn: conn, Reader: messages.NewReader(conn)})
+
+	// With an ID of his own, only his connection is read-only
+	dialUserClient(t, addr, "vic-token", 3)
+	for _, c := range waitForClients(t, srv, 2).Clients {
+		if c.User.Name == "vic" && !c.ReadOnly {
+			t.Errorf("Expected vic listed as read-only, got %+v", c)
+		}
+	}
+	if role := srv.State().Role(3); role == messages.RoleReadOnly {
+		t.Errorf("Expected no one told user 3 is read-only, got %s", role)
+	}
+	if err := messages.SendOperation(eve, messages.NewInsertOperation([]crdt.Identifier{{Digit: 5, Node: 4}}, 'e', 4, 1)); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	waitForHistory(t, srv, "", "e")
+}
+
+func TestServerWithoutAuthIgnoresToken(t *testing.T) {
+	srv, addr := startTestServer(t, "open")
+
+	alice := dialAuthClient(t, addr, "anything", "alice")
+	if msg, err := alice.Receive(); err != nil || msg.Type != messages.MessageTypeSync {
+		t.Fatalf("Expected the document from a server asking for no token, got %+v (%v)", msg, err)
+	}
+	if stats := waitForClients(t, srv, 1); stats.Clients[0].User != nil {
+		t.Errorf("Expected no authenticated user, got %+v", stats.Clients[0].User)
+	}
+}
+
+func TestServerAuthRefusesSilentClient(t *testing.T) {
+	authWait = 100 * time.Millisecond
+	t.Cleanup(func() { authWait = 5 * time.Second })
+	srv, addr := startTestServer(t, "")
+	srv.SetAuth(Auth{Token: "s3cret"})
+
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = raw.Close() })
+	_ = raw.SetReadDeadline(time.Now().Add(2 * time.Second))
+	expectRefused(t, &testClient{Conn: raw, Reader: messages.NewReader(raw)})
+
+	// Nor does a room join stand in for credentials
+	raw, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = raw.Close() })
+	if err := messages.SendMessage(raw, messages.NewJoinRoomMessage("lecture", 1)); err != nil {
+		t.Fatalf("Failed to join: %v", err)
+	}
+	_ = raw.SetReadDeadline(time.Now().Add(2 * time.Second))
+	expectRefused(t, &testClient{Conn: raw, Reader: messages.NewReader(raw)})
+	if rooms := srv.Rooms(); len(rooms) != 1 {
+		t.Errorf("Expected no room opened for a refused client, got %+v", rooms)
+	}
+}
+
+func TestGRPCAuth(t *testing.T) {
+	srv, _ := startTestServer(t, "hello")
+	srv.SetAuth(Auth{Token: "s3cret"})
+	client, url := startTestGRPC(t, srv)
+
+	if _, status := callGRPC(t, client, url+"GetDocument", nil); status != "16" {
+		t.Errorf("Expected a call without a token unauthenticated, got status %s", status)
+	}
+
+	reply, _ := callGRPCWithToken(t, client, url+"GetDocument", nil, "s3cret")
+	if info, err := messages.UnmarshalDocumentInfo(reply); err != nil || info.Text != "hello" {
+		t.Errorf("Expected the document with a valid token, got %+v (%v)", info, err)
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"fmt"
+
+	"gollaborate/autosave"
+)
+
+// SetAutosave saves the document of every room to the store while it is
+// edited: on the policy's interval, after its number of edits and once the
+// room's last client leaves. Every save keeps a snapshot for DocumentAt, and
+// only the latest policy.Keep snapshots of a document are kept, whether saved
+// automatically or not. Without a store there is nothing to save to. Call it
+// before Serve.
+func (s *Server) SetAutosave(policy autosave.Policy) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.autosave = policy
+	for _, r := range s.rooms {
+		s.startAutosave(r)
+	}
+}
+
+// startAutosave starts saving a room by the server's autosave policy, if it
+// has one. The caller must hold s.mutex.
+func (s *Server) startAutosave(r *room) {
+	if !s.autosave.Enabled() || r.saver != nil {
+		return
+	}
+	r.saver = autosave.New(s.autosave, func() error {
+		return s.saveRooms([]*room{r})
+	}, func(err error) {
+		s.recordError(fmt.Errorf("autosaving %s: %w", r.name, err))
+	})
+	r.history.notify(r.saver)
+}
+
+// stopAutosave stops saving rooms, waiting for saves under way
+func (s *Server) stopAutosave(rooms []*room) {
+	s.mutex.Lock()
+	var savers []*autosave.Saver
+	for _, r := range rooms {
+		if r.saver != nil {
+			savers = append(savers, r.saver)
+		}
+	}
+	s.mutex.Unlock()
+	for _, saver := range savers {
+		saver.Close()
+	}
+}
+
+// roomEmptied tells a room's saver its last client left. The caller must
+// hold s.mutex.
+func (s *Server) roomEmptied(r *room) {
+	if r.saver == nil {
+		return
+	}
+	for _, c := range s.clients {
+		if c.room == r {
+			return
+		}
+	}
+	r.saver.LastLeft()
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"gollaborate/autosave"
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/storage"
+)
+
+// waitForSaved waits until the store holds a document with the given text
+func waitForSaved(t *testing.T, store storage.Store, name, want string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		doc, err := store.LoadDocument(name)
+		if err == nil && doc.ToText() == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %s saved as '%s', got %v (%v)", name, want, doc, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerAutosave(t *testing.T) {
+	store, err := storage.OpenFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	srv, addr := startTestServer(t, "")
+	srv.SetStore(store)
+	srv.SetAutosave(autosave.Policy{Ops: 2, OnLastLeave: true, Keep: 2})
+	alice := dialTestClient(t, addr)
+	waitForClients(t, srv, 1)
+
+	// Saved after every two edits
+	text := ""
+	for i, char := range "abcde" {
+		id := []crdt.Identifier{{Digit: 5 + i, Node: 3}}
+		if err := messages.SendOperation(alice, messages.NewInsertOperation(id, char, 3, i+1)); err != nil {
+			t.Fatalf("Failed to send operation: %v", err)
+		}
+		text += string(char)
+		waitForHistory(t, srv, "", text)
+		if i%2 == 1 {
+			waitForSaved(t, store, "test", text)
+		}
+	}
+
+	// And once the last client leaves, the edit since too
+	_ = alice.Close()
+	waitForClients(t, srv, 0)
+	waitForSaved(t, store, "test", "abcde")
+
+	// Closing waits for saves under way, and saves once more
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Failed to close server: %v", err)
+	}
+	if times, err := store.Snapshots("test"); err != nil || len(times) != 2 {
+		t.Errorf("Expected the latest 2 snapshots kept, got %v (%v)", times, err)
+	}
+}
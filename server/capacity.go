@@ -0,0 +1,135 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gollaborate/messages"
+	"gollaborate/users"
+)
+
+// DefaultMaxWait is how long clients wait for a place when Capacity.MaxWait is zero
+const DefaultMaxWait = 30 * time.Second
+
+// fullRetryAfter is how long clients refused a place are told to wait before
+// trying again
+const fullRetryAfter = 10 * time.Second
+
+// ErrServerFull is returned for a client the server, or the document it
+// joins, has no place for
+var ErrServerFull = errors.New("server full")
+
+// Capacity caps how many clients a server takes, so that those connected keep
+// their latency rather than everyone's degrading. Zero leaves a cap off.
+type Capacity struct {
+	MaxClients     int // Connected to the server, over every room
+	MaxRoomClients int // Connected to any one room
+	// Clients joining when there is no place may wait for one, up to
+	// MaxWaiting of them at once for MaxWait each; the rest are refused at
+	// once. Zero MaxWaiting refuses every client there is no place for.
+	MaxWaiting int
+	MaxWait    time.Duration
+}
+
+// SetCapacity caps how many clients the server takes. Clients beyond the caps
+// are refused with an error coded messages.ErrorCodeFull, having waited for a
+// place first if the server lets them. Those already connected are kept. Call
+// it before Serve.
+func (s *Server) SetCapacity(capacity Capacity) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.capacity = capacity
+}
+
+// checkCapacity returns ErrServerFull if a room, or the server, has no place
+// for another client. The caller must hold s.mutex.
+func (s *Server) checkCapacity(r *room) error {
+	if s.capacity.MaxClients > 0 && len(s.clients) >= s.capacity.MaxClients {
+		return fmt.Errorf("%w: %d clients connected", ErrServerFull, len(s.clients))
+	}
+	if s.capacity.MaxRoomClients <= 0 {
+		return nil
+	}
+	inRoom := 0
+	for _, c := range s.clients {
+		if c.room == r {
+			inRoom++
+		}
+	}
+	if inRoom >= s.capacity.MaxRoomClients {
+		return fmt.Errorf("%w: %d clients in %s", ErrServerFull, inRoom, r.name)
+	}
+	return nil
+}
+
+// placeFreed tells the clients waiting for a place that one was freed. The
+// caller must hold s.mutex.
+func (s *Server) placeFreed() {
+	if s.freed != nil {
+		close(s.freed)
+		s.freed = nil
+	}
+}
+
+// join adds a client to a room, first waiting for a place if there is none
+// and the server lets clients wait, or refuses it
+func (s *Server) join(r *room, conn messages.Transport, user *users.User, resumed *session, spectate bool) {
+	err := s.addClient(r, conn, user, resumed, spectate)
+	if err == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	capacity := s.capacity
+	wait := capacity.MaxWaiting > 0 && s.waiting < capacity.MaxWaiting
+	if wait {
+		s.waiting++
+	}
+	s.mutex.Unlock()
+	if wait {
+		err = s.waitForPlace(r, conn, user, resumed, spectate, capacity.MaxWait)
+		s.mutex.Lock()
+		s.waiting--
+		s.mutex.Unlock()
+		if err == nil {
+			return
+		}
+	}
+
+	s.recordError(fmt.Errorf("%s: %w", r.describe(conn), err))
+	_ = conn.Send(messages.NewLimitErrorMessage(err.Error(), messages.ErrorCodeFull, fullRetryAfter, s.nodeID))
+	_ = conn.Close()
+}
+
+// waitForPlace adds a client to a room as soon as a place is freed for it,
+// returning ErrServerFull if none is within the wait
+func (s *Server) waitForPlace(r *room, conn messages.Transport, user *users.User, resumed *session, spectate bool, wait time.Duration) error {
+	if wait <= 0 {
+		wait = DefaultMaxWait
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		s.mutex.Lock()
+		if s.freed == nil {
+			s.freed = make(chan struct{})
+		}
+		freed := s.freed
+		s.mutex.Unlock()
+		// Tried after taking the channel, so a place freed meanwhile is not missed
+		if err := s.addClient(r, conn, user, resumed, spectate); !errors.Is(err, ErrServerFull) {
+			return err
+		}
+
+		select {
+		case <-freed:
+		case <-timer.C:
+			return fmt.Errorf("%w: no place within %s", ErrServerFull, wait)
+		case <-s.done:
+			_ = conn.Close()
+			return nil
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"gollaborate/messages"
+)
+
+// dialFull connects to a server expecting to be refused for want of a place
+func dialFull(t *testing.T, addr string) *messages.Message {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msg, err := messages.NewReader(conn).Receive()
+	if err != nil {
+		t.Fatalf("Expected to be told the server is full: %v", err)
+	}
+	return msg
+}
+
+func TestServerCapacity(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	srv.SetCapacity(Capacity{MaxClients: 3, MaxRoomClients: 1})
+	dialTestClient(t, addr)
+	waitForClients(t, srv, 1)
+
+	if msg := dialFull(t, addr); msg.Type != messages.MessageTypeError || msg.Code != messages.ErrorCodeFull || msg.RetryAfter == 0 {
+		t.Errorf("Expected a full room to refuse a client, got %+v", msg)
+	}
+	dialRoomClient(t, addr, "lecture")
+	dialRoomClient(t, addr, "notes")
+	waitForClients(t, srv, 3)
+	if err := srv.addClient(srv.defaultRoom, nil, nil, nil, false); !errors.Is(err, ErrServerFull) {
+		t.Errorf("Expected a full server to refuse a client, got %v", err)
+	}
+}
+
+func TestServerCapacityQueue(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	srv.SetCapacity(Capacity{MaxClients: 1, MaxWaiting: 1, MaxWait: 50 * time.Millisecond})
+	alice := dialTestClient(t, addr)
+	waitForClients(t, srv, 1)
+
+	// Once the wait is over, the client is refused
+	if msg := dialFull(t, addr); msg.Code != messages.ErrorCodeFull {
+		t.Errorf("Expected a client refused after waiting, got %+v", msg)
+	}
+
+	// A client waiting takes the place of one leaving
+	srv.SetCapacity(Capacity{MaxClients: 1, MaxWaiting: 1, MaxWait: 5 * time.Second})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		srv.mutex.Lock()
+		queued := srv.waiting
+		srv.mutex.Unlock()
+		if queued == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a client waiting for a place")
+		}
+	}
+	_ = alice.Close()
+	receiveMessage(t, &testClient{conn, messages.NewReader(conn)}, messages.MessageTypeSync)
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+
+	"gollaborate/messages"
+	"gollaborate/pubsub"
+)
+
+// DefaultClusterTopic is the topic servers share documents on unless told otherwise
+const DefaultClusterTopic = "gollaborate"
+
+// clusterBacklog is how many messages from other servers may wait for a room
+// to handle them before the broker is held up
+const clusterBacklog = 1024
+
+// envelope is what servers publish: a message in a room, and which server sent
+// it, so that each can skip its own
+type envelope struct {
+	Server  string            `json:"server"`
+	Room    string            `json:"room"`
+	Message *messages.Message `json:"message"`
+}
+
+// clusterLink carries a room's messages to and from the other servers hosting
+// it. The room's editor state treats it as one more peer, relaying to it what
+// the room's clients send and what it receives to them.
+type clusterLink struct {
+	broker   pubsub.Broker
+	topic    string
+	instance string
+	room     string
+	incoming chan *messages.Message
+	done     chan struct{}
+	once     sync.Once
+}
+
+// SetBroker shares the server's rooms with every other server using the same
+// broker and topic, so clients of any of them can edit the same documents and
+// a load balancer can send them to any. What the clients of a room send is
+// published to the other servers hosting it, which pass it on to theirs; a
+// room opening catches up on the edits made to it elsewhere. Rooms start from
+// the store's copy of their document, so servers sharing a broker should share
+// a store too, and each needs a node ID of its own. Documents shared this way
+// are never parked. Call it before Serve.
+func (s *Server) SetBroker(broker pubsub.Broker, topic string) error {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.broker, s.topic, s.instance = broker, topic, hex.EncodeToString(b)
+	s.mutex.Unlock()
+
+	if err := broker.Subscribe(topic, s.fromCluster); err != nil {
+		return fmt.Errorf("subscribing to %s: %w", topic, err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, r := range s.rooms {
+		s.link(r)
+	}
+	return nil
+}
+
+// link connects a room to the other servers, unless it is already, and asks
+// them for the edits it lacks. The caller must hold s.mutex.
+func (s *Server) link(r *room) *clusterLink {
+	if r.link != nil && !r.link.closed() {
+		return r.link
+	}
+	l := &clusterLink{
+		broker:   s.broker,
+		topic:    s.topic,
+		instance: s.instance,
+		room:     r.name,
+		incoming: make(chan *messages.Message, clusterBacklog),
+		done:     make(chan struct{}),
+	}
+	r.link = l
+	r.state.AddTransport(l)
+	go s.catchUp(r, l)
+	return l
+}
+
+// catchUp asks the other servers hosting a room for the edits it lacks
+func (s *Server) catchUp(r *room, l *clusterLink) {
+	if err := r.state.RequestCatchUp(l); err != nil {
+		s.recordError(fmt.Errorf("%s: asking other servers to catch up: %w", r.name, err))
+	}
+}
+
+// fromCluster passes a message another server published to the room it is
+// in, if this server hosts that room. After missing messages, every room
+// catches up again.
+func (s *Server) fromCluster(data []byte, err error) {
+	if err != nil {
+		s.recordError(fmt.Errorf("pub/sub: %w", err))
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		for _, r := range s.rooms {
+			if r.link != nil {
+				go s.catchUp(r, r.link)
+			}
+		}
+		return
+	}
+
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil || env.Message == nil {
+		s.recordError(fmt.Errorf("pub/sub: malformed message: %v", err))
+		return
+	}
+	select {
+	case <-s.done:
+		// Rooms closing are not linked again
+		return
+	default:
+	}
+	s.mutex.Lock()
+	r, ok := s.rooms[env.Room]
+	if env.Server == s.instance || !ok {
+		s.mutex.Unlock()
+		return
+	}
+	l := s.link(r)
+	s.mutex.Unlock()
+
+	select {
+	case l.incoming <- env.Message:
+	case <-l.done:
+	}
+}
+
+// Send publishes a message to the other servers
+func (l *clusterLink) Send(msg *messages.Message) error {
+	if l.closed() {
+		return net.ErrClosed
+	}
+	data, err := json.Marshal(envelope{Server: l.instance, Room: l.room, Message: msg})
+	if err != nil {
+		return err
+	}
+	return l.broker.Publish(l.topic, data)
+}
+
+// Receive waits for the next message from the other servers
+func (l *clusterLink) Receive() (*messages.Message, error) {
+	select {
+	case msg := <-l.incoming:
+		return msg, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the room hearing from the other servers, until linked again
+func (l *clusterLink) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+// closed reports whether the link was closed
+func (l *clusterLink) closed() bool {
+	select {
+	case <-l.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// RemoteID names the link in logs and dashboards
+func (l *clusterLink) RemoteID() string {
+	return "pub/sub"
+}
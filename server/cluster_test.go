@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/pubsub"
+)
+
+// startClusterServer starts a server sharing its documents through a broker
+func startClusterServer(t *testing.T, broker pubsub.Broker, nodeID int) (*Server, string) {
+	t.Helper()
+
+	srv := New(crdt.FromText("", nodeID), nodeID, "test")
+	if err := srv.SetBroker(broker, DefaultClusterTopic); err != nil {
+		t.Fatalf("Failed to set the broker: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return srv, listener.Addr().String()
+}
+
+func TestServerCluster(t *testing.T) {
+	broker := pubsub.NewMemory()
+	first, firstAddr := startClusterServer(t, broker, 101)
+	second, secondAddr := startClusterServer(t, broker, 102)
+
+	// Clients of either server edit the same document
+	alice := dialTestClient(t, firstAddr)
+	bob := dialTestClient(t, secondAddr)
+	op := messages.NewInsertOperation([]crdt.Identifier{{Digit: 5, Node: 1}}, 'a', 1, 2)
+	if err := messages.SendOperation(alice, op); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	if msg := receiveMessage(t, bob, messages.MessageTypeOperation); msg.Operation.Character != 'a' {
+		t.Errorf("Expected alice's edit passed on by the other server, got %+v", msg)
+	}
+	waitForHistory(t, second, "", "a")
+	for _, srv := range []*Server{first, second} {
+		if rooms := srv.Rooms(); len(rooms) != 1 || rooms[0].Users != 1 {
+			t.Errorf("Expected one user in the room, not counting the other server, got %+v", rooms)
+		}
+	}
+
+	// However many users edit through the other server
+	dave := dialTestClient(t, firstAddr)
+	op = messages.NewInsertOperation([]crdt.Identifier{{Digit: 6, Node: 5}}, 'd', 5, 1)
+	if err := messages.SendOperation(dave, op); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	if msg := receiveMessage(t, bob, messages.MessageTypeOperation); msg.Operation.Character != 'd' {
+		t.Errorf("Expected dave's edit passed on by the other server, got %+v", msg)
+	}
+	waitForHistory(t, second, "", "ad")
+
+	// A room opened after edits elsewhere catches up on them
+	carol := dialRoomClient(t, firstAddr, "notes")
+	op = messages.NewInsertOperation([]crdt.Identifier{{Digit: 5, Node: 3}}, 'n', 3, 2)
+	if err := messages.SendOperation(carol, op); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	waitForHistory(t, first, "notes", "n")
+	conn, err := net.Dial("tcp", secondAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	if err := messages.SendMessage(conn, messages.NewJoinRoomMessage("notes", 4)); err != nil {
+		t.Fatalf("Failed to join: %v", err)
+	}
+	waitForHistory(t, second, "notes", "n")
+	waitForHistory(t, second, "", "ad")
+}
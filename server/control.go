@@ -0,0 +1,163 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gollaborate/messages"
+)
+
+// noticeName is who notices appear to come from in the chat
+const noticeName = "Notice"
+
+// ServeControl accepts admin connections on the listener until the server is
+// closed, such as on a Unix socket only the server's operator can reach. Admin
+// connections send admin messages naming the room they are about, and get an
+// answer to each in turn; they may run every command, whatever the roles of
+// the server's users. With a token, as anyone may reach a TCP listener, they
+// must first present it in an auth message.
+func (s *Server) ServeControl(listener net.Listener, token string) error {
+	s.mutex.Lock()
+	select {
+	case <-s.done:
+		s.mutex.Unlock()
+		_ = listener.Close()
+		return nil
+	default:
+	}
+	s.listeners = append(s.listeners, listener)
+	s.mutex.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			s.recordError(fmt.Errorf("admin accept: %w", err))
+			continue
+		}
+		go s.control(conn, token)
+	}
+}
+
+// control answers the admin commands sent over an admin connection until it
+// is closed
+func (s *Server) control(conn net.Conn, token string) {
+	defer conn.Close()
+	reader := messages.NewReader(conn)
+
+	if token != "" {
+		_ = conn.SetReadDeadline(time.Now().Add(authWait))
+		msg, err := reader.Receive()
+		if err != nil || msg.Type != messages.MessageTypeAuth || !tokenEqual(msg.Token, token) {
+			s.recordError(fmt.Errorf("admin %s: %w", conn.RemoteAddr(), ErrUnauthorized))
+			_ = messages.SendMessage(conn, messages.NewCodedErrorMessage(ErrUnauthorized.Error(), messages.ErrorCodeUnauthorized, s.nodeID))
+			return
+		}
+		_ = conn.SetReadDeadline(time.Time{})
+	}
+
+	for {
+		msg, err := reader.Receive()
+		if err != nil {
+			return
+		}
+		if err := messages.SendMessage(conn, s.controlCommand(msg)); err != nil {
+			return
+		}
+	}
+}
+
+// controlCommand carries out a command sent over an admin connection,
+// returning the answer to send back
+func (s *Server) controlCommand(msg *messages.Message) *messages.Message {
+	if msg.Type != messages.MessageTypeAdmin {
+		return messages.NewErrorMessage(fmt.Sprintf("expected an admin command, got %s", msg.Type), s.nodeID)
+	}
+
+	s.mutex.Lock()
+	name := msg.Room
+	if name == "" {
+		name = s.name
+	}
+	r, ok := s.rooms[name]
+	s.mutex.Unlock()
+	if msg.Command == messages.AdminCommandImport {
+		// The room it names is the one to create
+		r, ok = nil, true
+	}
+	if msg.Command == messages.AdminCommandAudit && !ok {
+		// The store keeps the trails of rooms not open
+		r, ok = nil, true
+	}
+	if msg.Command == messages.AdminCommandInvite && !ok {
+		// Whoever joins with the code opens it
+		r, ok = nil, true
+	}
+	if msg.Command == messages.AdminCommandForget && !ok {
+		// Users are forgotten in every room
+		r, ok = nil, true
+	}
+	if !ok {
+		return messages.NewErrorMessage(fmt.Sprintf("%s failed: %v: %q", msg.Command, ErrRoomNotOpen, name), s.nodeID)
+	}
+
+	text, err := s.adminCommand(r, msg)
+	if err != nil {
+		s.recordError(fmt.Errorf("admin %s in %s: %w", msg.Command, name, err))
+		return messages.NewErrorMessage(fmt.Sprintf("%s failed: %v", msg.Command, err), s.nodeID)
+	}
+	return messages.NewAdminReplyMessage(msg.Command, text, s.nodeID)
+}
+
+// notice shows a notice to everyone connected to the server, in every room, as
+// a chat message
+func (s *Server) notice(text string) {
+	s.mutex.Lock()
+	rooms := s.roomList()
+	s.mutex.Unlock()
+
+	now := time.Now()
+	for _, r := range rooms {
+		r.state.BroadcastMessage(messages.NewChatMessage(text, now, s.nodeID, noticeName))
+	}
+}
+
+// clientsReport lists the connected clients, one per line
+func clientsReport(stats Stats) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tNAME\tROOM\tADDRESS\tOPS\tCONNECTED")
+	for _, c := range stats.Clients {
+		access := ""
+		if c.ReadOnly {
+			access = " (read-only)"
+		}
+		fmt.Fprintf(w, "%d\t%s%s\t%s\t%s\t%d\t%s ago\n", c.UserID, c.UserName, access, c.Room, c.Addr, c.Ops, time.Since(c.ConnectedAt).Truncate(time.Second))
+	}
+	_ = w.Flush()
+	return b.String()
+}
+
+// statsReport describes the server's state, one figure per line
+func statsReport(stats Stats) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", stats.Name)
+	fmt.Fprintf(w, "Uptime:\t%s\n", stats.Uptime.Truncate(time.Second))
+	fmt.Fprintf(w, "Rooms:\t%d\n", len(stats.Rooms))
+	fmt.Fprintf(w, "Clients:\t%d\n", len(stats.Clients))
+	fmt.Fprintf(w, "Operations:\t%d\n", stats.OpsTotal)
+	fmt.Fprintf(w, "Characters:\t%d\n", stats.Characters)
+	fmt.Fprintf(w, "Read-only:\t%t\n", stats.Locked)
+	fmt.Fprintf(w, "Frozen:\t%t\n", stats.Frozen)
+	fmt.Fprintf(w, "Parked:\t%t\n", stats.Parked)
+	fmt.Fprintf(w, "Errors:\t%d\n", len(stats.Errors))
+	_ = w.Flush()
+	return b.String()
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gollaborate/messages"
+)
+
+// startTestControl serves admin commands for the server on a listener of the
+// given network, returning its address
+func startTestControl(t *testing.T, srv *Server, network, token string) string {
+	t.Helper()
+
+	addr := "127.0.0.1:0"
+	if network == "unix" {
+		// Kept short, as socket paths are limited to about a hundred bytes
+		dir, err := os.MkdirTemp("", "ctl")
+		if err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		t.Cleanup(func() { _ = os.RemoveAll(dir) })
+		addr = filepath.Join(dir, "admin.sock")
+	}
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() { _ = srv.ServeControl(listener, token) }()
+	return listener.Addr().String()
+}
+
+// dialControl connects to an admin listener
+func dialControl(t *testing.T, network, addr string) *testClient {
+	t.Helper()
+
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	return &testClient{Conn: conn, Reader: messages.NewReader(conn)}
+}
+
+// collapse turns every run of spaces and newlines in a report into one space
+func collapse(report string) string {
+	return strings.Join(strings.Fields(report), " ")
+}
+
+// runControl sends an admin command over an admin connection and returns the answer
+func runControl(t *testing.T, conn *testClient, msg *messages.Message) *messages.Message {
+	t.Helper()
+
+	if err := messages.SendMessage(conn, msg); err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+	reply, err := conn.Receive()
+	if err != nil {
+		t.Fatalf("Expected an answer to %s: %v", msg.Command, err)
+	}
+	return reply
+}
+
+func TestServerControl(t *testing.T) {
+	srv, addr := startTestServer(t, "hello")
+	alice := dialTestClient(t, addr)
+	waitForClients(t, srv, 1)
+	if err := messages.SendMessage(alice, messages.NewUserInfoMessage(3, "alice", "")); err != nil {
+		t.Fatalf("Failed to send user info: %v", err)
+	}
+	for deadline := time.Now().Add(2 * time.Second); srv.Stats().Clients[0].UserName != "alice"; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the client known as alice")
+		}
+	}
+	admin := dialControl(t, "unix", startTestControl(t, srv, "unix", ""))
+
+	if reply := runControl(t, admin, messages.NewAdminMessage(messages.AdminCommandStats, 0, 0)); !strings.Contains(collapse(reply.Text), "Clients: 1 ") {
+		t.Errorf("Expected one client in the statistics, got %+v", reply)
+	}
+	if reply := runControl(t, admin, messages.NewAdminMessage(messages.AdminCommandClients, 0, 0)); !strings.Contains(collapse(reply.Text), "CONNECTED 3 alice ") {
+		t.Errorf("Expected user 3 listed, got %q", reply.Text)
+	}
+
+	runControl(t, admin, messages.NewNoticeMessage("back in five", 0))
+	_ = alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		msg, err := alice.Receive()
+		if err != nil {
+			t.Fatalf("Expected the notice: %v", err)
+		}
+		if msg.Type == messages.MessageTypeChat {
+			if msg.Text != "back in five" || msg.UserName != noticeName {
+				t.Errorf("Expected the notice in the chat, got %+v", msg)
+			}
+			break
+		}
+	}
+
+	if reply := runControl(t, admin, messages.NewAdminMessage(messages.AdminCommandLock, 0, 0)); reply.Type != messages.MessageTypeAdmin || !srv.State().ReadOnly() {
+		t.Errorf("Expected the document locked, got %+v", reply)
+	}
+	if reply := runControl(t, admin, messages.NewAdminMessage(messages.AdminCommandFreeze, 0, 0)); reply.Type != messages.MessageTypeAdmin || !srv.State().Frozen() {
+		t.Errorf("Expected the document frozen, got %+v", reply)
+	}
+	if reply := runControl(t, admin, messages.NewAdminMessage(messages.AdminCommandSave, 0, 0)); reply.Type != messages.MessageTypeError {
+		t.Errorf("Expected saving without a store to fail, got %+v", reply)
+	}
+	lecture := messages.NewAdminMessage(messages.AdminCommandLock, 0, 0)
+	lecture.Room = "lecture"
+	if reply := runControl(t, admin, lecture); reply.Type != messages.MessageTypeError {
+		t.Errorf("Expected no room to lock that was never opened, got %+v", reply)
+	}
+
+	if reply := runControl(t, admin, messages.NewAdminMessage(messages.AdminCommandKick, 3, 0)); reply.Type != messages.MessageTypeAdmin {
+		t.Errorf("Expected user 3 kicked, got %+v", reply)
+	}
+	waitForClients(t, srv, 0)
+}
+
+func TestServerControlToken(t *testing.T) {
+	srv, _ := startTestServer(t, "")
+	addr := startTestControl(t, srv, "tcp", "s3cret")
+
+	intruder := dialControl(t, "tcp", addr)
+	if reply := runControl(t, intruder, messages.NewAdminMessage(messages.AdminCommandStats, 0, 0)); reply.Code != messages.ErrorCodeUnauthorized {
+		t.Errorf("Expected a command without the token refused, got %+v", reply)
+	}
+
+	admin := dialControl(t, "tcp", addr)
+	if err := messages.SendMessage(admin, messages.NewAuthMessage("s3cret", "", 0)); err != nil {
+		t.Fatalf("Failed to authenticate: %v", err)
+	}
+	if reply := runControl(t, admin, messages.NewAdminMessage(messages.AdminCommandStats, 0, 0)); reply.Type != messages.MessageTypeAdmin {
+		t.Errorf("Expected the statistics with the token, got %+v", reply)
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/storage"
+)
+
+// ErrRoomExists is returned for an import into a room that is open or stored
+var ErrRoomExists = errors.New("room already exists")
+
+// Archive is a document's operation log as exported: the document as the log
+// starts, and every edit made to it since with when it was made
+type Archive struct {
+	Room     string             `json:"room"`
+	Exported time.Time          `json:"exported"`
+	Since    time.Time          `json:"since"` // When the document was as Base is
+	Base     *crdt.Document     `json:"base"`
+	Ops      []storage.LoggedOp `json:"ops"`
+}
+
+// archive returns the document the log starts from, when it was, and the
+// edits logged since
+func (h *roomHistory) archive() (*crdt.Document, time.Time, []storage.LoggedOp, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	doc := &crdt.Document{}
+	if err := json.Unmarshal(h.base, doc); err != nil {
+		return nil, time.Time{}, nil, err
+	}
+	return doc, h.since, append([]storage.LoggedOp(nil), h.ops...), nil
+}
+
+// Export writes a room's document in a format: its plain text, a JSON
+// snapshot of it, or an archive of its operation log, as far back as the room
+// keeps it, from which Import can rebuild its history too. The empty name is
+// the server's own document.
+func (s *Server) Export(name string, format messages.ExportFormat) ([]byte, error) {
+	s.mutex.Lock()
+	if name == "" {
+		name = s.name
+	}
+	r, ok := s.rooms[name]
+	s.mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrRoomNotOpen, name)
+	}
+
+	switch format {
+	case messages.ExportFormatText, "":
+		doc, err := r.history.at(time.Time{}, nil)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(doc.ToText()), nil
+	case messages.ExportFormatJSON:
+		doc, err := r.history.at(time.Time{}, nil)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(doc)
+	case messages.ExportFormatArchive:
+		base, since, ops, err := r.history.archive()
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(Archive{Room: name, Exported: time.Now(), Since: since, Base: base, Ops: ops})
+	}
+	return nil, fmt.Errorf("unknown format %q, expected one of %v", format, messages.ExportFormats())
+}
+
+// Import restores a document written by Export into a new room, which must
+// not be open nor in the store. The room opens with it, and it is saved to the
+// store at once if the server has one. A document imported from an archive
+// keeps its history, so DocumentAt can rebuild it as it was.
+func (s *Server) Import(name string, format messages.ExportFormat, data []byte) error {
+	doc, logged, err := s.readExport(format, data)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	if !validRoomName(name) || name == "" {
+		s.mutex.Unlock()
+		return fmt.Errorf("%w: %q", ErrRoomName, name)
+	}
+	if _, ok := s.rooms[name]; ok {
+		s.mutex.Unlock()
+		return fmt.Errorf("%w: %q", ErrRoomExists, name)
+	}
+	if len(s.rooms) >= maxRooms {
+		s.mutex.Unlock()
+		return ErrTooManyRooms
+	}
+	store := s.store
+	if store != nil {
+		if _, err := store.LoadDocument(name); !errors.Is(err, storage.ErrNotFound) {
+			s.mutex.Unlock()
+			if err == nil {
+				err = fmt.Errorf("%w: %q", ErrRoomExists, name)
+			}
+			return err
+		}
+	}
+	r := s.newRoom(name, doc, logged)
+	s.rooms[name] = r
+	s.mutex.Unlock()
+
+	return s.saveRooms([]*room{r})
+}
+
+// readExport reads a document written by Export, returning it with the edits
+// to apply to it
+func (s *Server) readExport(format messages.ExportFormat, data []byte) (*crdt.Document, []storage.LoggedOp, error) {
+	switch format {
+	case messages.ExportFormatText, "":
+		return crdt.FromText(string(data), s.nodeID), nil, nil
+	case messages.ExportFormatJSON:
+		doc := &crdt.Document{}
+		if err := json.Unmarshal(data, doc); err != nil {
+			return nil, nil, fmt.Errorf("reading the snapshot: %w", err)
+		}
+		return doc, nil, nil
+	case messages.ExportFormatArchive:
+		var archive Archive
+		if err := json.Unmarshal(data, &archive); err != nil {
+			return nil, nil, fmt.Errorf("reading the archive: %w", err)
+		}
+		if archive.Base == nil {
+			return nil, nil, errors.New("reading the archive: no document to start from")
+		}
+		for i, logged := range archive.Ops {
+			msg := &messages.Message{Type: messages.MessageTypeOperation, Operation: logged.Op}
+			if err := msg.Validate(); err != nil {
+				return nil, nil, fmt.Errorf("reading the archive: edit %d: %w", i, err)
+			}
+		}
+		return archive.Base, archive.Ops, nil
+	}
+	return nil, nil, fmt.Errorf("unknown format %q, expected one of %v", format, messages.ExportFormats())
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/storage"
+)
+
+func TestServerExportImport(t *testing.T) {
+	store, err := storage.OpenBoltStore(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+	srv, addr := startTestServer(t, "")
+	srv.SetStore(store)
+	alice := dialTestClient(t, addr)
+	waitForClients(t, srv, 1)
+
+	a := []crdt.Identifier{{Digit: 5, Node: 3}}
+	for _, op := range []*messages.Operation{
+		messages.NewInsertOperation(a, 'a', 3, 1),
+		messages.NewInsertOperation([]crdt.Identifier{{Digit: 6, Node: 3}}, 'b', 3, 2),
+	} {
+		if err := messages.SendOperation(alice, op); err != nil {
+			t.Fatalf("Failed to send operation: %v", err)
+		}
+	}
+	waitForHistory(t, srv, "", "ab")
+	before := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	if err := messages.SendOperation(alice, messages.NewDeleteOperation(a, 3, 3)); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	waitForHistory(t, srv, "", "b")
+
+	for _, format := range messages.ExportFormats() {
+		data, err := srv.Export("", format)
+		if err != nil {
+			t.Fatalf("Failed to export as %s: %v", format, err)
+		}
+		if err := srv.Import("from-"+string(format), format, data); err != nil {
+			t.Fatalf("Failed to import from %s: %v", format, err)
+		}
+		if text := srv.Room("from-" + string(format)).Document().ToText(); text != "b" {
+			t.Errorf("Expected the document restored from %s, got '%s'", format, text)
+		}
+		if _, err := store.LoadDocument("from-" + string(format)); err != nil {
+			t.Errorf("Expected the document restored from %s saved: %v", format, err)
+		}
+	}
+	if text, err := srv.Export("", messages.ExportFormatText); err != nil || string(text) != "b" {
+		t.Errorf("Expected the plain text exported, got '%s' (%v)", text, err)
+	}
+
+	// Only the archive keeps the deleted text
+	if doc, err := srv.DocumentAt("from-archive", before, nil); err != nil || doc.ToText() != "ab" {
+		t.Errorf("Expected the archive's history restored, got %v (%v)", doc, err)
+	}
+
+	if err := srv.Import("from-text", messages.ExportFormatText, []byte("again")); !errors.Is(err, ErrRoomExists) {
+		t.Errorf("Expected an open room kept, got %v", err)
+	}
+	if err := store.SaveDocument("stored", crdt.FromText("kept", 7)); err != nil {
+		t.Fatalf("Failed to save document: %v", err)
+	}
+	if err := srv.Import("stored", messages.ExportFormatText, []byte("again")); !errors.Is(err, ErrRoomExists) {
+		t.Errorf("Expected a stored document kept, got %v", err)
+	}
+	if err := srv.Import("broken", messages.ExportFormatArchive, []byte(`{"base":{},"ops":[{"op":{}}]}`)); err == nil {
+		t.Error("Expected an archive with an invalid edit refused")
+	}
+	if _, err := srv.Export("", "pdf"); err == nil {
+		t.Error("Expected an unknown format refused")
+	}
+
+	// Admins do the same over the admin channel
+	ctl := dialControl(t, "tcp", startTestControl(t, srv, "tcp", ""))
+	reply := runControl(t, ctl, messages.NewExportMessage(messages.ExportFormatJSON, 0))
+	if reply.Type != messages.MessageTypeAdmin || reply.Text == "" {
+		t.Fatalf("Expected the snapshot exported, got %+v", reply)
+	}
+	reply = runControl(t, ctl, messages.NewImportMessage("restored", messages.ExportFormatJSON, reply.Text, 0))
+	if reply.Type != messages.MessageTypeAdmin {
+		t.Fatalf("Expected the snapshot imported, got %+v", reply)
+	}
+	waitForHistory(t, srv, "restored", "b")
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// ForgetUser removes what the server keeps of a user, for data-removal
+// requests. Their connections, those that authenticated or said hello as
+// them whatever IDs their messages carry later, are closed without sessions
+// to resume, and their presence is dropped in every room, by every client.
+// Their profile is deleted, and the edits they made stay in the audit trails,
+// but under an anonymous ID, without their name. The ID is negative, so no user has it, and
+// the same in every trail, which it is returned for telling apart.
+func (s *Server) ForgetUser(userID int) (int, error) {
+	if userID == 0 {
+		return 0, errors.New("no user to forget")
+	}
+	s.kickUser(userID)
+
+	anonID := -1 - rand.Intn(1<<30)
+	s.mutex.Lock()
+	// Sessions left behind by connections that went by their ID
+	for id, sess := range s.sessions {
+		if sess.client.userID == userID {
+			delete(s.sessions, id)
+		}
+	}
+	delete(s.userNames, userID)
+	rooms := s.roomList()
+	store := s.store
+	s.mutex.Unlock()
+
+	for _, r := range rooms {
+		r.state.ForgetPresence(userID)
+	}
+	// Their latest edits are audited first, so none escape anonymizing
+	err := s.appendHistory(rooms)
+	s.mutex.Lock()
+	for _, r := range rooms {
+		for i := range r.audit {
+			if r.audit[i].UserID == userID {
+				r.audit[i].UserID, r.audit[i].UserName = anonID, ""
+			}
+		}
+	}
+	s.mutex.Unlock()
+	if store != nil {
+		if forgetErr := store.ForgetUser(userID, anonID); forgetErr != nil {
+			err = errors.Join(err, fmt.Errorf("forgetting user %d in the store: %w", userID, forgetErr))
+		}
+	}
+	return anonID, err
+}
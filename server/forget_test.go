@@ -0,0 +1,126 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/presence"
+	"gollaborate/storage"
+	"gollaborate/users"
+)
+
+func TestServerForgetUser(t *testing.T) {
+	store, err := storage.OpenFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if err := store.SaveProfile(users.User{ID: 3, Name: "alice", Color: "green"}); err != nil {
+		t.Fatalf("Failed to save profile: %v", err)
+	}
+	srv, addr := startTestServer(t, "")
+	srv.SetStore(store)
+	bob := dialTestClient(t, addr)
+	alice := dialTestClient(t, addr)
+	waitForClients(t, srv, 2)
+	for _, msg := range []*messages.Message{
+		messages.NewHelloMessage(3, "alice", ""),
+		messages.NewAwarenessMessage([]presence.State{{UserID: 3, UserName: "alice", Clock: 1}}, 3),
+	} {
+		if err := messages.SendMessage(alice, msg); err != nil {
+			t.Fatalf("Failed to send %s: %v", msg.Type, err)
+		}
+	}
+	if err := messages.SendOperation(alice, messages.NewInsertOperation([]crdt.Identifier{{Digit: 5, Node: 3}}, 'a', 3, 1)); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	waitForHistory(t, srv, "", "a")
+	flushAudit(t, srv)
+
+	ctl := dialControl(t, "tcp", startTestControl(t, srv, "tcp", ""))
+	reply := runControl(t, ctl, messages.NewAdminMessage(messages.AdminCommandForget, 3, 0))
+	if reply.Type != messages.MessageTypeAdmin || !strings.HasPrefix(reply.Text, "Forgot user 3") {
+		t.Fatalf("Expected alice forgotten, got %+v", reply)
+	}
+	waitForClients(t, srv, 1)
+
+	// Her edits stay audited, without her name or ID, and her profile is gone
+	trail, err := store.Audit("test")
+	if err != nil || len(trail) != 1 || trail[0].UserID >= 0 || trail[0].UserName != "" || trail[0].Inserted != 1 {
+		t.Errorf("Expected alice's edit audited anonymously, got %+v (%v)", trail, err)
+	}
+	if _, err := store.Profile(3); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected alice's profile deleted, got %v", err)
+	}
+
+	// Nobody keeps her presence
+	for _, state := range srv.State().Presence() {
+		if state.UserID == 3 {
+			t.Errorf("Expected alice's presence gone, got %+v", state)
+		}
+	}
+	_ = bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		msg, err := bob.Receive()
+		if err != nil {
+			t.Fatalf("Expected alice's presence dropped: %v", err)
+		}
+		if msg.Type != messages.MessageTypeAwareness || len(msg.Presence) != 1 || msg.Presence[0].UserName != "" {
+			continue
+		}
+		if state := msg.Presence[0]; state.UserID != 3 || !state.Offline {
+			t.Errorf("Expected alice's presence dropped, got %+v", state)
+		}
+		break
+	}
+}
+
+func TestServerForgetUserKeepsToIdentity(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	srv.SetAuth(Auth{Token: "s3cret"})
+	ada := dialAuthClient(t, addr, "s3cret", "ada")
+	if msg, err := ada.Receive(); err != nil || msg.Type != messages.MessageTypeSync {
+		t.Fatalf("Expected the document, got %+v (%v)", msg, err)
+	}
+	bob, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = bob.Close() })
+	if err := messages.SendMessage(bob, messages.NewAuthMessage("s3cret", "bob", 9)); err != nil {
+		t.Fatalf("Failed to authenticate: %v", err)
+	}
+	waitForClients(t, srv, 2)
+	for conn, hello := range map[net.Conn]*messages.Message{
+		ada: messages.NewHelloMessage(7, "ada", ""),
+		bob: messages.NewHelloMessage(9, "bob", ""),
+	} {
+		if err := messages.SendMessage(conn, hello); err != nil {
+			t.Fatalf("Failed to say hello: %v", err)
+		}
+	}
+	bobEntry := messages.RosterEntry{UserID: 9, UserName: "bob", Role: "editor"}
+	expectRoster(t, ada, messages.RosterEntry{UserID: 7, UserName: "ada", Role: "editor"}, bobEntry)
+
+	// Having authenticated as user 7, ada goes on to claim bob's ID
+	if err := messages.SendMessage(ada, messages.NewUserInfoMessage(9, "ada", "#123456")); err != nil {
+		t.Fatalf("Failed to send user info: %v", err)
+	}
+	expectRoster(t, ada, messages.RosterEntry{UserID: 7, UserName: "ada", Color: "#123456", Role: "editor"}, bobEntry)
+
+	// Forgetting bob closes his connection only, and forgetting ada hers
+	if _, err := srv.ForgetUser(9); err != nil {
+		t.Fatalf("Failed to forget bob: %v", err)
+	}
+	if stats := waitForClients(t, srv, 1); stats.Clients[0].UserName != "ada" {
+		t.Errorf("Expected ada still connected, got %+v", stats.Clients)
+	}
+	if _, err := srv.ForgetUser(7); err != nil {
+		t.Fatalf("Failed to forget ada: %v", err)
+	}
+	waitForClients(t, srv, 0)
+}
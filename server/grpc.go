@@ -0,0 +1,292 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gollaborate/messages"
+	"gollaborate/users"
+)
+
+// gRPC status codes the service answers with
+const (
+	grpcOK                 = 0
+	grpcInvalidArgument    = 3
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnauthenticated    = 16
+)
+
+// grpcError is a failed call, with the gRPC status to answer it with
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return e.message
+}
+
+// ServeGRPC serves the Collaboration gRPC service described in messages.proto
+// on the listener until the server is closed. gRPC runs over HTTP/2, which
+// needs TLS here, so the config must hold a certificate.
+func (s *Server) ServeGRPC(listener net.Listener, config *tls.Config) error {
+	config = config.Clone()
+	config.NextProtos = []string{"h2"}
+	srv := &http.Server{Handler: s.GRPCHandler(), TLSConfig: config}
+
+	s.mutex.Lock()
+	s.httpServers = append(s.httpServers, srv)
+	s.serving++
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		s.serving--
+		s.mutex.Unlock()
+	}()
+
+	err := srv.ServeTLS(listener, "", "")
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// GRPCHandler returns a handler for the Collaboration gRPC service, to mount in
+// an HTTP/2 server of one's own
+func (s *Server) GRPCHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "Expected a gRPC call", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		service, method, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if service != messages.GRPCService {
+			writeGRPCStatus(w, &grpcError{grpcUnimplemented, "unknown service " + service})
+			return
+		}
+		user, err := s.authenticateBearer(r.Header.Get("Authorization"))
+		if err != nil {
+			writeGRPCStatus(w, &grpcError{grpcUnauthenticated, err.Error()})
+			return
+		}
+		switch method {
+		case "Session":
+			s.grpcSession(w, r, user)
+		case "GetDocument":
+			s.grpcUnary(w, r, s.grpcGetDocument)
+		case "RestoreDocument":
+			if grpcPermitted(w, user, users.Role.CanEdit) {
+				s.grpcUnary(w, r, s.grpcRestoreDocument)
+			}
+		case "SetLocked":
+			if grpcPermitted(w, user, users.Role.CanAdminister) {
+				s.grpcUnary(w, r, s.grpcSetLocked)
+			}
+		default:
+			writeGRPCStatus(w, &grpcError{grpcUnimplemented, "unknown method " + method})
+		}
+	})
+}
+
+// grpcPermitted reports whether a caller's role allows a call, answering it
+// with a permission error if not. Callers of a server asking for no
+// credentials may make every call.
+func grpcPermitted(w http.ResponseWriter, user *users.User, allowed func(users.Role) bool) bool {
+	if user == nil || allowed(user.Role) {
+		return true
+	}
+	writeGRPCStatus(w, &grpcError{grpcPermissionDenied, fmt.Sprintf("the %s role does not allow this call", user.Role)})
+	return false
+}
+
+// grpcUnary answers a call taking one message and returning one
+func (s *Server) grpcUnary(w http.ResponseWriter, r *http.Request, call func([]byte) ([]byte, error)) {
+	req, err := messages.ReadGRPCFrame(r.Body)
+	if err != nil {
+		writeGRPCStatus(w, &grpcError{grpcInvalidArgument, fmt.Sprintf("reading request: %v", err)})
+		return
+	}
+	reply, err := call(req)
+	if err == nil {
+		err = messages.WriteGRPCFrame(w, reply)
+	}
+	writeGRPCStatus(w, err)
+}
+
+// writeGRPCStatus ends a call with the status for err, OK if it is nil
+func writeGRPCStatus(w http.ResponseWriter, err error) {
+	code, message := grpcOK, ""
+	if err != nil {
+		code, message = grpcInternal, err.Error()
+		var grpcErr *grpcError
+		if errors.As(err, &grpcErr) {
+			code = grpcErr.code
+		}
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", message)
+	}
+}
+
+func (s *Server) grpcGetDocument([]byte) ([]byte, error) {
+	return s.documentInfo()
+}
+
+func (s *Server) grpcRestoreDocument(data []byte) ([]byte, error) {
+	req, err := messages.UnmarshalRestoreRequest(data)
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	changed, err := s.RestoreTo(req.Time)
+	if err != nil {
+		return nil, &grpcError{grpcFailedPrecondition, err.Error()}
+	}
+	return messages.RestoreReply{Changed: changed}.Marshal(), nil
+}
+
+func (s *Server) grpcSetLocked(data []byte) ([]byte, error) {
+	req, err := messages.UnmarshalSetLockedRequest(data)
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	s.SetLocked(req.Locked)
+	return s.documentInfo()
+}
+
+// documentInfo describes the hosted document for the gRPC service
+func (s *Server) documentInfo() ([]byte, error) {
+	doc, err := s.Document()
+	if err != nil {
+		return nil, err
+	}
+	info := messages.DocumentInfo{
+		Text:       doc.ToText(),
+		Language:   doc.Metadata.Language,
+		Characters: doc.Len(),
+		Locked:     s.state.ReadOnly(),
+	}
+	return info.Marshal(), nil
+}
+
+// grpcSession carries a Session call: the client joins as it would over TCP,
+// with the call as its transport, as the user it authenticated as if any
+func (s *Server) grpcSession(w http.ResponseWriter, r *http.Request, user *users.User) {
+	flusher, ok := w.(http.Flusher)
+	if !ok || r.ProtoMajor < 2 {
+		writeGRPCStatus(w, &grpcError{grpcInternal, "streaming needs HTTP/2"})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	t := &grpcTransport{w: w, flusher: flusher, body: r.Body, addr: r.RemoteAddr, done: make(chan struct{})}
+	if err := s.addClient(s.defaultRoom, t, user, nil, false); err != nil {
+		s.recordError(fmt.Errorf("%s: %w", t.addr, err))
+		writeGRPCStatus(w, &grpcError{grpcResourceExhausted, err.Error()})
+		return
+	}
+	select {
+	case <-t.done:
+	case <-r.Context().Done():
+	}
+	s.state.RemoveConn(t)
+	writeGRPCStatus(w, t.err())
+}
+
+// grpcTransport carries the messages of a Session call. Messages are sent as
+// Protobuf whatever codec the client asked for, since that is what gRPC speaks.
+type grpcTransport struct {
+	w       io.Writer
+	flusher http.Flusher
+	body    io.Reader
+	addr    string
+
+	mutex   sync.Mutex
+	closed  bool
+	done    chan struct{}
+	failure error // Why the client's messages stopped, nil if it ended the call
+}
+
+func (t *grpcTransport) Send(msg *messages.Message) error {
+	if msg = messages.Intercept(messages.Outbound, msg, t); msg == nil {
+		return nil
+	}
+	data, err := messages.Protobuf.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	// The response may not be written once the handler has returned
+	if t.closed {
+		return net.ErrClosed
+	}
+	if err := messages.WriteGRPCFrame(t.w, data); err != nil {
+		return err
+	}
+	t.flusher.Flush()
+	return nil
+}
+
+func (t *grpcTransport) Receive() (*messages.Message, error) {
+	for {
+		data, err := messages.ReadGRPCFrame(t.body)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.fail(err)
+			}
+			return nil, err
+		}
+		msg, err := messages.Protobuf.Unmarshal(data)
+		if err != nil {
+			t.fail(&grpcError{grpcInvalidArgument, err.Error()})
+			return nil, err
+		}
+		if msg = messages.Intercept(messages.Inbound, msg, t); msg != nil {
+			return msg, nil
+		}
+	}
+}
+
+// fail records why the call is ending
+func (t *grpcTransport) fail(err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.failure == nil && !t.closed {
+		t.failure = err
+	}
+}
+
+func (t *grpcTransport) err() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.failure
+}
+
+// Close ends the call
+func (t *grpcTransport) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !t.closed {
+		t.closed = true
+		close(t.done)
+	}
+	return nil
+}
+
+func (t *grpcTransport) RemoteID() string {
+	return t.addr
+}
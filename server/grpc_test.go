@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"gollaborate/messages"
+)
+
+// startTestGRPC serves the gRPC service of a test server, returning a client for it
+func startTestGRPC(t *testing.T, srv *Server) (*http.Client, string) {
+	t.Helper()
+
+	config, err := messages.TLSOptions{}.Config()
+	if err != nil {
+		t.Fatalf("Config: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() { _ = srv.ServeGRPC(listener, config) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	t.Cleanup(client.CloseIdleConnections)
+	return client, "https://" + listener.Addr().String() + "/" + messages.GRPCService + "/"
+}
+
+// callGRPC makes a unary call, returning the reply and the gRPC status
+func callGRPC(t *testing.T, client *http.Client, url string, req []byte) ([]byte, string) {
+	t.Helper()
+	return callGRPCWithToken(t, client, url, req, "")
+}
+
+// callGRPCWithToken makes a unary call presenting a bearer token, unless it is empty
+func callGRPCWithToken(t *testing.T, client *http.Client, url string, req []byte, token string) ([]byte, string) {
+	t.Helper()
+
+	var body bytes.Buffer
+	_ = messages.WriteGRPCFrame(&body, req)
+	httpReq, _ := http.NewRequest(http.MethodPost, url, &body)
+	httpReq.Header.Set("Content-Type", "application/grpc")
+	if token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	defer resp.Body.Close()
+	reply, _ := messages.ReadGRPCFrame(resp.Body)
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return reply, resp.Trailer.Get("Grpc-Status")
+}
+
+func TestGRPCDocumentCalls(t *testing.T) {
+	srv, _ := startTestServer(t, "hello")
+	client, url := startTestGRPC(t, srv)
+
+	reply, status := callGRPC(t, client, url+"GetDocument", nil)
+	info, err := messages.UnmarshalDocumentInfo(reply)
+	if status != "0" || err != nil || info.Text != "hello" || info.Characters != 5 || info.Locked {
+		t.Fatalf("Unexpected GetDocument answer %+v (status %s, %v)", info, status, err)
+	}
+
+	reply, status = callGRPC(t, client, url+"SetLocked", messages.SetLockedRequest{Locked: true}.Marshal())
+	if info, _ := messages.UnmarshalDocumentInfo(reply); status != "0" || !info.Locked || !srv.State().ReadOnly() {
+		t.Errorf("Expected the document locked, got %+v (status %s)", info, status)
+	}
+
+	if _, status := callGRPC(t, client, url+"Rename", nil); status != "12" {
+		t.Errorf("Expected an unknown method to be unimplemented, got status %s", status)
+	}
+}
+
+func TestGRPCSession(t *testing.T) {
+	srv, _ := startTestServer(t, "ab")
+	client, url := startTestGRPC(t, srv)
+
+	requests, send := io.Pipe()
+	req, _ := http.NewRequest(http.MethodPost, url+"Session", requests)
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Session: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The client is sent the document first, as over TCP
+	data, err := messages.ReadGRPCFrame(resp.Body)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	msg, err := messages.Protobuf.Unmarshal(data)
+	if err != nil || msg.Type != messages.MessageTypeSync || msg.Document.ToText() != "ab" {
+		t.Fatalf("Expected the document sync, got %+v (%v)", msg, err)
+	}
+	if stats := waitForClients(t, srv, 1); stats.Clients[0].Addr == "unknown" {
+		t.Error("Expected the client's address to be known")
+	}
+
+	// Its edits reach the document
+	pos, _ := msg.Document.GeneratePositionAt(1, 3, 7)
+	data, _ = messages.Protobuf.Marshal(messages.NewOperationMessage(messages.NewInsertOperation(pos, 'c', 7, 1)))
+	if err := messages.WriteGRPCFrame(send, data); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.Stats().OpsTotal < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the edit to be applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if doc, _ := srv.Document(); doc.ToText() != "abc" {
+		t.Errorf("Expected the edit applied, got %q", doc.ToText())
+	}
+
+	// Ending the stream leaves the session
+	send.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if status := resp.Trailer.Get("Grpc-Status"); status != "0" {
+		t.Errorf("Expected the session to end OK, got status %q", status)
+	}
+	waitForClients(t, srv, 0)
+}
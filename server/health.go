@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Paths of the health endpoints, as load balancers and Kubernetes probe them
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+// Health is what the health endpoints report of the server
+type Health struct {
+	Status    string     `json:"status"` // ok, or why the server cannot take clients
+	Uptime    string     `json:"uptime"`
+	Listeners int        `json:"listeners"`       // Accepting clients
+	Store     string     `json:"store,omitempty"` // ok, or the error reaching the store, if there is one
+	Clients   int        `json:"clients"`
+	Rooms     []RoomLoad `json:"rooms,omitempty"`
+}
+
+// RoomLoad is how busy one of the server's documents is
+type RoomLoad struct {
+	Name       string `json:"name"`
+	Clients    int    `json:"clients"`
+	Ops        int    `json:"ops"`        // Sent by the clients connected
+	Characters int    `json:"characters"` // In the document
+	Parked     bool   `json:"parked,omitempty"`
+}
+
+// Health reports whether the server can take clients, and how busy each of its
+// documents is. It cannot if it was closed, serves no listener, cannot reach
+// its store or has as many clients as it takes.
+func (s *Server) Health() Health {
+	s.mutex.Lock()
+	h := Health{
+		Status:    "ok",
+		Uptime:    time.Since(s.started).Round(time.Second).String(),
+		Listeners: s.serving,
+		Clients:   len(s.clients),
+	}
+	store := s.store
+	full := s.capacity.MaxClients > 0 && len(s.clients) >= s.capacity.MaxClients
+	rooms := s.roomList()
+	h.Rooms = make([]RoomLoad, len(rooms))
+	loads := make(map[*room]*RoomLoad, len(rooms))
+	for i, r := range rooms {
+		h.Rooms[i] = RoomLoad{Name: r.name, Parked: r.main && s.parked}
+		if doc := r.state.Document(); doc != nil {
+			h.Rooms[i].Characters = doc.Len()
+		}
+		loads[r] = &h.Rooms[i]
+	}
+	for _, c := range s.clients {
+		if load := loads[c.room]; load != nil {
+			load.Clients++
+			load.Ops += c.ops
+		}
+	}
+	s.mutex.Unlock()
+
+	if store != nil {
+		h.Store = "ok"
+		if _, err := store.Documents(); err != nil {
+			h.Store = err.Error()
+		}
+	}
+	select {
+	case <-s.done:
+		h.Status = "closed"
+	default:
+		switch {
+		case h.Listeners == 0:
+			h.Status = "not serving"
+		case h.Store != "" && h.Store != "ok":
+			h.Status = "store unavailable"
+		case full:
+			h.Status = "full"
+		}
+	}
+	return h
+}
+
+// HealthHandler returns a handler answering the health endpoints: LivenessPath
+// with 200 while the server runs, and ReadinessPath with 200 while it can take
+// clients, 503 otherwise. Both describe the server's health in JSON.
+func (s *Server) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(LivenessPath, func(w http.ResponseWriter, r *http.Request) {
+		h := s.Health()
+		live := h.Status != "closed"
+		// Liveness is only whether the server runs, whatever it is waiting for
+		if live {
+			h.Status = "ok"
+		}
+		writeHealth(w, h, live)
+	})
+	mux.HandleFunc(ReadinessPath, func(w http.ResponseWriter, r *http.Request) {
+		h := s.Health()
+		writeHealth(w, h, h.Status == "ok")
+	})
+	return mux
+}
+
+// writeHealth answers a health check
+func writeHealth(w http.ResponseWriter, h Health, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(h)
+}
+
+// ServeHealth answers the health endpoints on the listener until the server is
+// closed
+func (s *Server) ServeHealth(listener net.Listener) error {
+	srv := &http.Server{Handler: s.HealthHandler(), ReadHeaderTimeout: 10 * time.Second}
+
+	s.mutex.Lock()
+	s.httpServers = append(s.httpServers, srv)
+	s.mutex.Unlock()
+
+	err := srv.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
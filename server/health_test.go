@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gollaborate/storage"
+)
+
+// failingStore is a store that cannot be reached
+type failingStore struct {
+	storage.Store
+}
+
+func (failingStore) Documents() ([]string, error) {
+	return nil, errors.New("disk unavailable")
+}
+
+// checkHealth asks a health endpoint how the server is
+func checkHealth(t *testing.T, addr, path string) (int, Health) {
+	t.Helper()
+
+	resp, err := http.Get("http://" + addr + path)
+	if err != nil {
+		t.Fatalf("Failed to check %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	var h Health
+	if err := json.NewDecoder(resp.Body).Decode(&h); err != nil {
+		t.Fatalf("Failed to decode %s: %v", path, err)
+	}
+	return resp.StatusCode, h
+}
+
+func TestServerHealth(t *testing.T) {
+	srv, addr := startTestServer(t, "hello")
+	store, err := storage.OpenBoltStore(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+	srv.SetStore(store)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() { _ = srv.ServeHealth(listener) }()
+	health := listener.Addr().String()
+
+	dialTestClient(t, addr)
+	dialRoomClient(t, addr, "notes")
+	waitForClients(t, srv, 2)
+	code, h := checkHealth(t, health, ReadinessPath)
+	if code != http.StatusOK || h.Status != "ok" || h.Store != "ok" || h.Listeners != 1 || h.Clients != 2 {
+		t.Errorf("Expected the server ready, got %d %+v", code, h)
+	}
+	if len(h.Rooms) != 2 || h.Rooms[0].Name != "notes" || h.Rooms[0].Clients != 1 || h.Rooms[1].Characters != 5 {
+		t.Errorf("Expected the load of both documents, got %+v", h.Rooms)
+	}
+
+	// A full server, or one that cannot reach its store, is alive but not ready
+	srv.SetCapacity(Capacity{MaxClients: 2})
+	if code, h := checkHealth(t, health, ReadinessPath); code != http.StatusServiceUnavailable || h.Status != "full" {
+		t.Errorf("Expected a full server not ready, got %d %+v", code, h)
+	}
+	srv.SetCapacity(Capacity{})
+	srv.SetStore(failingStore{store})
+	if code, h := checkHealth(t, health, ReadinessPath); code != http.StatusServiceUnavailable || h.Store != "disk unavailable" {
+		t.Errorf("Expected a server without its store not ready, got %d %+v", code, h)
+	}
+	if code, h := checkHealth(t, health, LivenessPath); code != http.StatusOK || h.Status != "ok" {
+		t.Errorf("Expected the server alive, got %d %+v", code, h)
+	}
+	srv.SetStore(store)
+
+	// Once it stops taking clients, it is no longer ready
+	srv.mutex.Lock()
+	listeners := srv.listeners
+	srv.mutex.Unlock()
+	_ = listeners[0].Close()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if code, h := checkHealth(t, health, ReadinessPath); code == http.StatusServiceUnavailable && h.Status == "not serving" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a server serving no listener not ready")
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gollaborate/autosave"
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/storage"
+)
+
+// historyFlushInterval is how often the edits made in rooms are appended to
+// the store's operation logs
+var historyFlushInterval = time.Second
+
+// maxHistoryOps caps the edits a room keeps in memory to rebuild its past
+// from; the oldest are folded into the document the rest start from
+const maxHistoryOps = 100000
+
+var (
+	// ErrBeforeHistory is returned for a time before the earliest a document
+	// can be rebuilt at
+	ErrBeforeHistory = errors.New("no history that far back")
+	// ErrRoomNotOpen is returned for the history of a room no client opened
+	ErrRoomNotOpen = errors.New("room is not open")
+)
+
+// roomHistory logs the edits made to a room's document, from which the
+// document can be rebuilt as it was at any time since the log starts. The
+// edits are also appended to the store's operation log, so a document whose
+// latest edits were not saved gets them back when its room opens again.
+type roomHistory struct {
+	mutex    sync.Mutex
+	base     []byte    // The document before the logged edits, as JSON
+	since    time.Time // When the base was the document
+	ops      []storage.LoggedOp
+	pending  []storage.LoggedOp             // Not yet appended to the store's log
+	saver    *autosave.Saver                // Told how many edits are recorded, if set
+	edited   time.Time                      // When the latest edits were recorded, zero before any
+	credited map[*messages.Operation]string // Who sent the edits about to be recorded, by edit
+}
+
+// newRoomHistory starts a log of the edits made to a document after the
+// logged ones, which the document is brought up to date with. A document that
+// cannot be encoded has no past to rebuild.
+func newRoomHistory(doc *crdt.Document, logged []storage.LoggedOp) *roomHistory {
+	base, _ := json.Marshal(doc)
+	h := &roomHistory{base: base, since: time.Now(), ops: logged}
+	if len(logged) > 0 {
+		h.since = logged[0].Time
+	}
+	storage.ApplyOps(doc, logged)
+	return h
+}
+
+// record logs operations applied to the document. It is the room's operation
+// recorder, so it runs with the editor state locked.
+func (h *roomHistory) record(ops []*messages.Operation) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := time.Now()
+	h.edited = now
+	for _, op := range ops {
+		logged := storage.LoggedOp{Time: now, Op: op, Author: h.credited[op]}
+		h.ops = append(h.ops, logged)
+		h.pending = append(h.pending, logged)
+	}
+	// Credited edits are recorded in the same turn or not at all
+	h.credited = nil
+	if len(h.ops) > maxHistoryOps {
+		h.fold(len(h.ops) - maxHistoryOps/2)
+	}
+	if h.saver != nil {
+		h.saver.Edited(len(ops))
+	}
+}
+
+// credit notes who sent edits about to be recorded, named as the connection
+// they arrived on is, so each is logged with its author for the audit trail.
+// It runs with the editor state locked.
+func (h *roomHistory) credit(ops []*messages.Operation, userName string) {
+	if userName == "" {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.credited == nil {
+		h.credited = make(map[*messages.Operation]string)
+	}
+	for _, op := range ops {
+		h.credited[op] = userName
+	}
+}
+
+// lastEdited returns when the latest edits were recorded, zero before any
+func (h *roomHistory) lastEdited() time.Time {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.edited
+}
+
+// notify tells a saver how many edits are recorded from now on
+func (h *roomHistory) notify(saver *autosave.Saver) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.saver = saver
+}
+
+// fold applies the first n logged edits to the base and drops them. The
+// caller must hold h.mutex.
+func (h *roomHistory) fold(n int) {
+	doc := &crdt.Document{}
+	if err := json.Unmarshal(h.base, doc); err != nil {
+		return
+	}
+	storage.ApplyOps(doc, h.ops[:n])
+	base, err := json.Marshal(doc)
+	if err != nil {
+		return
+	}
+	h.base = base
+	h.since = h.ops[n-1].Time
+	h.ops = append([]storage.LoggedOp(nil), h.ops[n:]...)
+}
+
+// at rebuilds the document as it was at a time, or with the latest edits if
+// the time is zero. With clocks, only each user's edits up to their clock count.
+func (h *roomHistory) at(at time.Time, clocks map[int]int) (*crdt.Document, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !at.IsZero() && at.Before(h.since) {
+		return nil, ErrBeforeHistory
+	}
+	doc := &crdt.Document{}
+	if err := json.Unmarshal(h.base, doc); err != nil {
+		return nil, err
+	}
+	var ops []storage.LoggedOp
+	for _, logged := range h.ops {
+		if !at.IsZero() && logged.Time.After(at) {
+			break
+		}
+		if clock, ok := clocks[logged.Op.UserID]; clocks != nil && (!ok || logged.Op.Clock > clock) {
+			continue
+		}
+		ops = append(ops, logged)
+	}
+	storage.ApplyOps(doc, ops)
+	return doc, nil
+}
+
+// takePending returns the edits not yet appended to the store's log
+func (h *roomHistory) takePending() []storage.LoggedOp {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	pending := h.pending
+	h.pending = nil
+	return pending
+}
+
+// DocumentAt rebuilds a room's document as it was at a time, or with the
+// latest edits if the time is zero, so content deleted earlier can be
+// recovered. With clocks, only each user's edits up to their clock count, as
+// in catch-up requests. The empty name is the server's own document.
+//
+// Rooms keep every edit since they were opened. Earlier times are served from
+// the snapshots the store keeps each time a document is saved, if the server
+// has a store.
+func (s *Server) DocumentAt(name string, at time.Time, clocks map[int]int) (*crdt.Document, error) {
+	s.mutex.Lock()
+	if name == "" {
+		name = s.name
+	}
+	r, ok := s.rooms[name]
+	store := s.store
+	s.mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrRoomNotOpen, name)
+	}
+
+	doc, err := r.history.at(at, clocks)
+	if errors.Is(err, ErrBeforeHistory) && store != nil {
+		return snapshotAt(store, name, at)
+	}
+	return doc, err
+}
+
+// snapshotAt returns the latest snapshot of a document taken at or before a time
+func snapshotAt(store storage.Store, name string, at time.Time) (*crdt.Document, error) {
+	times, err := store.Snapshots(name)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(times) - 1; i >= 0; i-- {
+		if !times[i].After(at) {
+			return store.LoadSnapshot(name, times[i])
+		}
+	}
+	return nil, ErrBeforeHistory
+}
+
+// flushHistory regularly appends the edits made in every room to the
+// store's operation logs, until the server closes
+func (s *Server) flushHistory() {
+	ticker := time.NewTicker(historyFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.mutex.Lock()
+			rooms := s.roomList()
+			s.mutex.Unlock()
+			if err := s.appendHistory(rooms); err != nil {
+				s.recordError(err)
+			}
+		}
+	}
+}
+
+// appendHistory appends the edits made in rooms since last time to the
+// store's operation logs, and who made them to their audit trails. Without a
+// store the edits are dropped; see audit.go for the trails.
+func (s *Server) appendHistory(rooms []*room) error {
+	s.mutex.Lock()
+	store := s.store
+	s.mutex.Unlock()
+
+	var errs []error
+	for _, r := range rooms {
+		pending := r.history.takePending()
+		if len(pending) == 0 {
+			continue
+		}
+		if err := s.audit(r, store, pending); err != nil {
+			errs = append(errs, fmt.Errorf("auditing edits to %s: %w", r.name, err))
+		}
+		if store == nil {
+			continue
+		}
+		if err := store.AppendOps(r.name, pending); err != nil {
+			errs = append(errs, fmt.Errorf("logging edits to %s: %w", r.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/storage"
+)
+
+// waitForHistory waits until a room's latest document, as its history
+// rebuilds it, has the given text
+func waitForHistory(t *testing.T, srv *Server, name, want string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		doc, err := srv.DocumentAt(name, time.Time{}, nil)
+		if err == nil && doc.ToText() == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected '%s' in the history of %q, got %v (%v)", want, name, doc, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerDocumentAt(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	alice := dialTestClient(t, addr)
+	waitForClients(t, srv, 1)
+
+	a := []crdt.Identifier{{Digit: 5, Node: 3}}
+	for _, op := range []*messages.Operation{
+		messages.NewInsertOperation(a, 'a', 3, 1),
+		messages.NewInsertOperation([]crdt.Identifier{{Digit: 6, Node: 3}}, 'b', 3, 2),
+	} {
+		if err := messages.SendOperation(alice, op); err != nil {
+			t.Fatalf("Failed to send operation: %v", err)
+		}
+	}
+	waitForHistory(t, srv, "", "ab")
+	before := time.Now()
+	time.Sleep(5 * time.Millisecond)
+
+	if err := messages.SendOperation(alice, messages.NewDeleteOperation(a, 3, 3)); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	waitForHistory(t, srv, "", "b")
+
+	if doc, err := srv.DocumentAt("", before, nil); err != nil || doc.ToText() != "ab" {
+		t.Errorf("Expected the deleted text back as of before the delete, got %v (%v)", doc, err)
+	}
+	if doc, err := srv.DocumentAt("", time.Time{}, map[int]int{3: 1}); err != nil || doc.ToText() != "a" {
+		t.Errorf("Expected only the edits up to clock 1, got %v (%v)", doc, err)
+	}
+
+	// Clients ask with a message, answered to them alone
+	if err := messages.SendMessage(alice, messages.NewDocumentAtMessage(before, nil, 3)); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	_ = alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		msg, err := alice.Receive()
+		if err != nil {
+			t.Fatalf("Expected the document as of before the delete: %v", err)
+		}
+		if msg.Type == messages.MessageTypeDocumentAt {
+			if msg.Document == nil || msg.Document.ToText() != "ab" || msg.At != before.UnixMilli() {
+				t.Errorf("Expected the document as of before the delete, got %+v", msg)
+			}
+			break
+		}
+	}
+
+	// Without a store, nothing before the server started is kept
+	if _, err := srv.DocumentAt("", before.Add(-time.Hour), nil); !errors.Is(err, ErrBeforeHistory) {
+		t.Errorf("Expected no history an hour back, got %v", err)
+	}
+	if _, err := srv.DocumentAt("lecture", time.Time{}, nil); !errors.Is(err, ErrRoomNotOpen) {
+		t.Errorf("Expected no history for a room never opened, got %v", err)
+	}
+}
+
+func TestServerReplaysLoggedEdits(t *testing.T) {
+	store, err := storage.OpenBoltStore(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+	saved := time.Now().Add(-time.Hour)
+	if err := store.SaveDocument("lecture", crdt.FromText("x", 7)); err != nil {
+		t.Fatalf("Failed to save document: %v", err)
+	}
+	if err := store.SaveSnapshot("lecture", saved, crdt.FromText("x", 7)); err != nil {
+		t.Fatalf("Failed to save snapshot: %v", err)
+	}
+
+	srv, addr := startTestServer(t, "")
+	srv.SetStore(store)
+	alice := dialRoomClient(t, addr, "lecture")
+	waitForClients(t, srv, 1)
+	if err := messages.SendOperation(alice, messages.NewInsertOperation([]crdt.Identifier{{Digit: 9, Node: 3}}, 'y', 3, 1)); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	waitForHistory(t, srv, "lecture", "xy")
+	srv.mutex.Lock()
+	rooms := srv.roomList()
+	srv.mutex.Unlock()
+	if err := srv.appendHistory(rooms); err != nil {
+		t.Fatalf("Failed to log edits: %v", err)
+	}
+
+	// A server starting after a crash, without the document saved, gets the
+	// logged edits back
+	restarted, addr := startTestServer(t, "")
+	restarted.SetStore(store)
+	dialRoomClient(t, addr, "lecture")
+	waitForClients(t, restarted, 1)
+	if text := restarted.Room("lecture").Document().ToText(); text != "xy" {
+		t.Errorf("Expected the logged edit replayed, got '%s'", text)
+	}
+
+	// Times before the logged edits come from the store's snapshots
+	if doc, err := restarted.DocumentAt("lecture", saved.Add(time.Minute), nil); err != nil || doc.ToText() != "x" {
+		t.Errorf("Expected the snapshot before the edit, got %v (%v)", doc, err)
+	}
+	if _, err := restarted.DocumentAt("lecture", saved.Add(-time.Minute), nil); !errors.Is(err, ErrBeforeHistory) {
+		t.Errorf("Expected no history before the first snapshot, got %v", err)
+	}
+}
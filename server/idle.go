@@ -0,0 +1,121 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gollaborate/messages"
+	"gollaborate/presence"
+)
+
+// idleCheckInterval is how often clients are checked for having gone silent
+var idleCheckInterval = time.Second
+
+// ErrEvicted is what a client disconnected for being silent too long is told
+var ErrEvicted = errors.New("disconnected after being idle too long")
+
+// SetIdleTimeouts has the server tell a room when one of its clients has done
+// nothing for idleAfter, as it would if the client said so itself, and
+// disconnect clients that have done nothing for evictAfter, freeing their
+// place in the room and its roster. Keeping a connection alive is not doing
+// something; editing, moving the cursor, chatting and the like are. Zero
+// turns either off, as servers start. Call it before Serve.
+func (s *Server) SetIdleTimeouts(idleAfter, evictAfter time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.idleAfter, s.evictAfter = idleAfter, evictAfter
+}
+
+// isActivity reports whether a message shows its client's user doing
+// something, rather than their client keeping the connection alive. Cursor
+// updates are activity only if the cursor moved, as clients renew them
+// regularly. The caller must hold s.mutex.
+func (c *client) isActivity(msg *messages.Message) bool {
+	switch msg.Type {
+	case messages.MessageTypePing, messages.MessageTypePong, messages.MessageTypeAck,
+		messages.MessageTypeHello, messages.MessageTypeCatchUpRequest, messages.MessageTypeOrderRequest:
+		return false
+	case messages.MessageTypePresence:
+		return msg.Event == messages.PresenceActive
+	case messages.MessageTypeAwareness:
+		for _, state := range msg.Presence {
+			if state.UserID != msg.UserID {
+				continue
+			}
+			cursor := fmt.Sprint(state.Cursor, state.SelectionStart, state.Selecting)
+			moved := c.cursor != "" && cursor != c.cursor
+			c.cursor = cursor
+			return moved
+		}
+		return false
+	}
+	return true
+}
+
+// watchActivity regularly tells rooms which of their clients went idle and
+// disconnects those silent too long, until the server closes
+func (s *Server) watchActivity() {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.checkActivity(time.Now())
+		}
+	}
+}
+
+// checkActivity marks the clients silent for the idle timeout as idle, telling
+// their room, and evicts those silent for the eviction timeout
+func (s *Server) checkActivity(now time.Time) {
+	s.mutex.Lock()
+	idleAfter, evictAfter := s.idleAfter, s.evictAfter
+	if idleAfter <= 0 && evictAfter <= 0 {
+		s.mutex.Unlock()
+		return
+	}
+	idle := make(map[*room][]presence.State)
+	evicted := make(map[messages.Transport]*room)
+	for conn, c := range s.clients {
+		silent := now.Sub(c.lastActive)
+		switch {
+		case evictAfter > 0 && silent >= evictAfter:
+			evicted[conn] = c.room
+		case idleAfter > 0 && silent >= idleAfter && !c.silent:
+			c.silent = true
+			if c.userID != 0 {
+				idle[c.room] = append(idle[c.room], presence.State{UserID: c.userID, UserName: c.userName, Color: c.color})
+			}
+		}
+	}
+	s.mutex.Unlock()
+
+	for r, states := range idle {
+		for _, state := range states {
+			r.state.BroadcastMessage(messages.NewPresenceMessage(messages.PresenceIdle, state, s.nodeID))
+		}
+		s.updateRoster(r)
+	}
+	if len(evicted) == 0 {
+		return
+	}
+	conns := make(map[messages.Transport]bool, len(evicted))
+	for conn := range evicted {
+		conns[conn] = true
+	}
+	s.endSessions(conns)
+	for conn, r := range evicted {
+		s.recordError(fmt.Errorf("%s: %w", r.describe(conn), ErrEvicted))
+		_ = r.state.SendTo(conn, messages.NewCodedErrorMessage(ErrEvicted.Error(), messages.ErrorCodeIdle, s.nodeID))
+		r.state.RemoveConn(conn)
+	}
+}
+
+// wake tells a room that a client the server marked idle is back
+func (s *Server) wake(r *room, state presence.State) {
+	r.state.BroadcastMessage(messages.NewPresenceMessage(messages.PresenceActive, state, s.nodeID))
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+)
+
+// expectIdle waits for a client to be told a user went idle or came back,
+// both with a presence event and in the roster, skipping other messages
+func expectIdle(t *testing.T, conn *testClient, userID int, event messages.PresenceEvent, roster ...messages.RosterEntry) {
+	t.Helper()
+
+	told, listed := false, false
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for !told || !listed {
+		msg, err := conn.Receive()
+		if err != nil {
+			t.Fatalf("Expected user %d to be %s (told %t, listed %t): %v", userID, event, told, listed, err)
+		}
+		switch msg.Type {
+		case messages.MessageTypePresence:
+			told = told || msg.Event == event && msg.Presence[0].UserID == userID
+		case messages.MessageTypeRoster:
+			listed = slices.Equal(msg.Roster, roster)
+		}
+	}
+}
+
+func TestServerIdleClients(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	srv.SetIdleTimeouts(time.Minute, time.Hour)
+	alice := dialTestClient(t, addr)
+	if err := messages.SendMessage(alice, messages.NewHelloMessage(1, "alice", "")); err != nil {
+		t.Fatalf("Failed to say hello: %v", err)
+	}
+	aliceEntry := messages.RosterEntry{UserID: 1, UserName: "alice", Role: "editor"}
+	expectRoster(t, alice, aliceEntry)
+	bob := dialTestClient(t, addr)
+	if err := messages.SendMessage(bob, messages.NewHelloMessage(2, "bob", "")); err != nil {
+		t.Fatalf("Failed to say hello: %v", err)
+	}
+	bobEntry := messages.RosterEntry{UserID: 2, UserName: "bob", Role: "editor"}
+	expectRoster(t, alice, aliceEntry, bobEntry)
+
+	// Keeping the connection alive is not doing something
+	if err := messages.SendMessage(bob, messages.NewPingMessage(2)); err != nil {
+		t.Fatalf("Failed to ping: %v", err)
+	}
+	srv.checkActivity(time.Now().Add(2 * time.Minute))
+	aliceEntry.Idle, bobEntry.Idle = true, true
+	expectIdle(t, alice, 2, messages.PresenceIdle, aliceEntry, bobEntry)
+
+	// Editing is
+	op := messages.NewInsertOperation([]crdt.Identifier{{Digit: 5, Node: 1}}, 'a', 1, 1)
+	if err := messages.SendOperation(alice, op); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	aliceEntry.Idle = false
+	expectIdle(t, bob, 1, messages.PresenceActive, aliceEntry, bobEntry)
+
+	// Only those silent past the eviction timeout are disconnected
+	srv.mutex.Lock()
+	for _, c := range srv.clients {
+		if c.userID == 2 {
+			c.lastActive = c.lastActive.Add(-2 * time.Hour)
+		}
+	}
+	srv.mutex.Unlock()
+	srv.checkActivity(time.Now())
+	if msg := receiveMessage(t, bob, messages.MessageTypeError); msg.Code != messages.ErrorCodeIdle {
+		t.Errorf("Expected bob told why he was disconnected, got %+v", msg)
+	}
+	expectRoster(t, alice, aliceEntry)
+	waitForClients(t, srv, 1)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gollaborate/messages"
+	"gollaborate/users"
+)
+
+// DefaultJoinCodeLifetime is how long a join code admits clients unless
+// minted for longer or shorter
+const DefaultJoinCodeLifetime = 15 * time.Minute
+
+// joinCodeBytes is how much randomness a join code carries: 8 characters
+const joinCodeBytes = 5
+
+// ErrJoinCodeInvalid refuses a client presenting a join code the server did
+// not mint, or that expired
+var ErrJoinCodeInvalid = errors.New("unauthorized: the join code is unknown or expired")
+
+// joinCode is what a join code admits its holders to, and until when
+type joinCode struct {
+	room    string
+	role    users.Role
+	expires time.Time
+}
+
+// MintJoinCode returns a new join code, which admits whoever presents it to
+// the named room, the server's own document if empty, with the given role
+// until the lifetime is up, without the server's credentials. Codes are
+// short, such as K7QH-3MZP, for reading out, and may be presented by as many
+// clients as are given them.
+func (s *Server) MintJoinCode(room string, role users.Role, lifetime time.Duration) (string, error) {
+	if room == "" {
+		room = s.name
+	}
+	if !validRoomName(room) {
+		return "", fmt.Errorf("%w: %q", ErrRoomName, room)
+	}
+	if role == "" {
+		role = users.RoleEditor
+	}
+	if lifetime <= 0 {
+		lifetime = DefaultJoinCodeLifetime
+	}
+	b := make([]byte, joinCodeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := base32.StdEncoding.EncodeToString(b)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	for c, minted := range s.joinCodes {
+		if now.After(minted.expires) {
+			delete(s.joinCodes, c)
+		}
+	}
+	s.joinCodes[code] = joinCode{room: room, role: role, expires: now.Add(lifetime)}
+	return code[:4] + "-" + code[4:], nil
+}
+
+// redeemJoinCode checks the join code a client presented, returning the user
+// it admits, kept to the code's room however they come back. Names that have
+// a token of their own cannot be claimed with a code.
+func (s *Server) redeemJoinCode(auth Auth, msg *messages.Message) (*users.User, error) {
+	code := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(msg.JoinCode))
+	s.mutex.Lock()
+	minted, ok := s.joinCodes[code]
+	if ok && time.Now().After(minted.expires) {
+		delete(s.joinCodes, code)
+		ok = false
+	}
+	s.mutex.Unlock()
+	if !ok {
+		return nil, ErrJoinCodeInvalid
+	}
+	if _, own := auth.Users[msg.UserName]; own {
+		return nil, fmt.Errorf("%w: %s has a token of their own", ErrUnauthorized, msg.UserName)
+	}
+	user := &users.User{ID: msg.UserID, Name: msg.UserName, Role: minted.role}
+	if err := s.loadProfile(user); err != nil {
+		return nil, err
+	}
+	user.Room = minted.room
+	return user, nil
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/users"
+)
+
+// dialJoinCode connects to the server and presents a join code, then sends
+// the other messages given, without reading the answer
+func dialJoinCode(t *testing.T, addr, code, name string, then ...*messages.Message) *testClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	for _, msg := range append([]*messages.Message{messages.NewJoinCodeMessage(code, name, 8)}, then...) {
+		if err := messages.SendMessage(conn, msg); err != nil {
+			t.Fatalf("Failed to send %s: %v", msg.Type, err)
+		}
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	return &testClient{Conn: conn, Reader: messages.NewReader(conn)}
+}
+
+func TestServerJoinCodes(t *testing.T) {
+	srv, addr := startRolesServer(t, "")
+	srv.SetSessionGrace(time.Minute)
+	code, err := srv.MintJoinCode("notes", users.RoleViewer, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to mint a join code: %v", err)
+	}
+
+	// The code admits its holder to its room, as a viewer, without a token;
+	// how it is written does not matter
+	grace := dialAdmitted(t, addr, messages.NewJoinCodeMessage(strings.ToLower(code), "grace", 8))
+	session := receiveMessage(t, grace, messages.MessageTypeSession).Session
+	stats := waitForClients(t, srv, 1)
+	if c := stats.Clients[0]; c.Room != "notes" || c.User == nil || c.User.Name != "grace" || c.User.Role != users.RoleViewer {
+		t.Errorf("Expected grace in notes as a viewer, got %+v", c)
+	}
+	if err := messages.SendOperation(grace, messages.NewInsertOperation([]crdt.Identifier{{Digit: 5, Node: 8}}, 'g', 8, 1)); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	if msg := receiveError(t, grace); msg.Code != messages.ErrorCodeForbidden {
+		t.Errorf("Expected the viewer's edit forbidden, got %+v", msg)
+	}
+
+	// But to no other room, nor as a user with a token of their own
+	expectRefused(t, dialJoinCode(t, addr, code, "grace", messages.NewJoinRoomMessage("elsewhere", 8)))
+	expectRefused(t, dialJoinCode(t, addr, code, "ada"))
+	expectRefused(t, dialJoinCode(t, addr, "AAAA-AAAA", "mallory"))
+
+	// Resuming their session keeps them to it too
+	resumed := dialResume(t, addr, session, nil)
+	if err := messages.SendMessage(resumed, messages.NewJoinRoomMessage("secret", 8)); err != nil {
+		t.Fatalf("Failed to join room: %v", err)
+	}
+	expectRefused(t, resumed)
+	dialResume(t, addr, session, nil)
+	if c := waitForClients(t, srv, 1).Clients[0]; c.Room != "notes" || c.User == nil || c.User.Name != "grace" {
+		t.Errorf("Expected grace back in notes, got %+v", c)
+	}
+
+	// Nor once it expired
+	expired, err := srv.MintJoinCode("notes", users.RoleEditor, time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to mint a join code: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	expectRefused(t, dialJoinCode(t, addr, expired, "grace"))
+
+	// Admins mint codes over the admin channel, for rooms not open yet too
+	ctl := dialControl(t, "tcp", startTestControl(t, srv, "tcp", ""))
+	invite := messages.NewInviteMessage("editor", 60, 0)
+	invite.Room = "fresh"
+	reply := runControl(t, ctl, invite)
+	if reply.Type != messages.MessageTypeAdmin || len(reply.Text) != 9 {
+		t.Fatalf("Expected a join code, got %+v", reply)
+	}
+	dialAdmitted(t, addr, messages.NewJoinCodeMessage(reply.Text, "henry", 9), messages.NewJoinRoomMessage("fresh", 9))
+	if rooms := srv.Rooms(); len(rooms) != 3 {
+		t.Errorf("Expected the code's room opened, got %+v", rooms)
+	}
+	if reply := runControl(t, ctl, messages.NewInviteMessage("owner", 0, 0)); reply.Type != messages.MessageTypeError {
+		t.Errorf("Expected an unknown role refused, got %+v", reply)
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+)
+
+// receiveOrdered waits for the given number of edits, skipping the rest
+func receiveOrdered(t *testing.T, conn *testClient, count int) []*messages.Message {
+	t.Helper()
+
+	var edits []*messages.Message
+	for len(edits) < count {
+		edits = append(edits, receiveMessage(t, conn, messages.MessageTypeOperation))
+	}
+	return edits
+}
+
+func TestServerOrdersEdits(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	alice := dialTestClient(t, addr)
+	if err := messages.SendMessage(alice, messages.NewHelloMessage(1, "alice", "")); err != nil {
+		t.Fatalf("Failed to say hello: %v", err)
+	}
+	receiveMessage(t, alice, messages.MessageTypeHello)
+	bob := dialTestClient(t, addr)
+	waitForClients(t, srv, 2)
+
+	insert := func(conn *testClient, char rune, node int) {
+		t.Helper()
+		op := messages.NewInsertOperation([]crdt.Identifier{{Digit: int(char), Node: node}}, char, node, 2)
+		if err := messages.SendOperation(conn, op); err != nil {
+			t.Fatalf("Failed to send operation: %v", err)
+		}
+	}
+	insert(alice, 'a', 1)
+	if edit := receiveMessage(t, bob, messages.MessageTypeOperation); edit.Order != 1 {
+		t.Errorf("Expected alice's edit ordered first, got %+v", edit)
+	}
+	insert(bob, 'b', 2)
+
+	// Alice speaks the protocol that orders edits, so hers come back to her
+	// numbered too, and her log matches the server's
+	edits := receiveOrdered(t, alice, 2)
+	logged := srv.State().OrderedOps(1)
+	if len(logged) != 2 || srv.State().LastOrder() != 2 {
+		t.Fatalf("Expected two edits ordered, got %d up to %d", len(logged), srv.State().LastOrder())
+	}
+	for i, edit := range edits {
+		if edit.Order != int64(i+1) || edit.Operation.Character != logged[i].Character {
+			t.Errorf("Expected edit %d to be %q, got %+v", i+1, logged[i].Character, edit)
+		}
+	}
+
+	// Bob missed nothing, but may ask for the edits again from any number
+	if err := messages.SendMessage(bob, messages.NewOrderRequestMessage(1, 2)); err != nil {
+		t.Fatalf("Failed to ask for edits: %v", err)
+	}
+	for i, edit := range receiveOrdered(t, bob, 2) {
+		if edit.Order != int64(i+1) || edit.Operation.Character != logged[i].Character {
+			t.Errorf("Expected edit %d to be %q again, got %+v", i+1, logged[i].Character, edit)
+		}
+	}
+
+	// Those joining are told where their log starts
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	carol := &testClient{Conn: conn, Reader: messages.NewReader(conn)}
+	if sync := receiveMessage(t, carol, messages.MessageTypeSync); sync.Order != 3 || sync.Document.ToText() != "ab" {
+		t.Errorf("Expected the document synced up to edit 3, got order %d", sync.Order)
+	}
+}
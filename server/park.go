@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gollaborate/crdt"
+)
+
+// EnableParking makes the server snapshot its document to dir, or to its store
+// if it has one, and unload it from memory once no clients have been connected
+// for the idle duration. The document is reloaded from the snapshot when the
+// next client connects.
+func (s *Server) EnableParking(idle time.Duration, dir string) {
+	s.mutex.Lock()
+	s.parkIdle = idle
+	s.parkPath = filepath.Join(dir, s.name+".snapshot.json")
+	s.mutex.Unlock()
+
+	go s.watchIdle(idle)
+}
+
+// watchIdle parks the document after it has had no clients for the idle duration
+func (s *Server) watchIdle(idle time.Duration) {
+	interval := min(max(idle/4, time.Millisecond), time.Minute)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var emptySince time.Time
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			if len(s.state.Connections()) > 0 {
+				emptySince = time.Time{}
+				continue
+			}
+			if emptySince.IsZero() {
+				emptySince = now
+				continue
+			}
+			if now.Sub(emptySince) >= idle {
+				if err := s.park(); err != nil {
+					s.recordError(fmt.Errorf("parking document: %w", err))
+				}
+			}
+		}
+	}
+}
+
+// park writes the document to its snapshot file and releases it
+func (s *Server) park() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.parked || len(s.state.Connections()) > 0 {
+		return nil
+	}
+
+	if err := s.writeParked(s.state.Document()); err != nil {
+		return err
+	}
+
+	s.state.SetDocument(nil)
+	s.parked = true
+	return nil
+}
+
+// writeParked writes a document being parked to the store, or its snapshot
+// file if the server has no store. The caller must hold s.mutex.
+func (s *Server) writeParked(doc *crdt.Document) error {
+	if s.store != nil {
+		return s.store.SaveDocument(s.name, doc)
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so a crash never leaves a partial snapshot
+	tmp := s.parkPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.parkPath)
+}
+
+// unpark reloads a parked document from its snapshot. The caller must hold s.mutex.
+func (s *Server) unpark() error {
+	if !s.parked {
+		return nil
+	}
+
+	doc := &crdt.Document{}
+	if s.store != nil {
+		var err error
+		if doc, err = s.store.LoadDocument(s.name); err != nil {
+			return err
+		}
+	} else {
+		data, err := os.ReadFile(s.parkPath)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, doc); err != nil {
+			return err
+		}
+	}
+
+	doc.EnableHistory()
+	doc.SetLimits(s.limits)
+	s.state.SetDocument(doc)
+	s.parked = false
+	return nil
+}
+
+// Document returns the hosted document, reloading it if it was parked
+func (s *Server) Document() (*crdt.Document, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.unpark(); err != nil {
+		return nil, fmt.Errorf("reloading parked document: %w", err)
+	}
+	return s.state.Document(), nil
+}
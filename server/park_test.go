@@ -0,0 +1,81 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+)
+
+func TestServerParksIdleDocument(t *testing.T) {
+	srv, addr := startTestServer(t, "parked text")
+	dir := t.TempDir()
+	srv.EnableParking(50*time.Millisecond, dir)
+
+	// With no clients the document is snapshotted and unloaded
+	deadline := time.Now().Add(2 * time.Second)
+	for !srv.Stats().Parked {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected idle document to be parked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if srv.State().Document() != nil {
+		t.Error("Expected parked document to be released")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "test.snapshot.json")); err != nil {
+		t.Errorf("Expected snapshot file: %v", err)
+	}
+
+	// A new client reloads it and receives the full text
+	dialTestClient(t, addr)
+	if srv.Stats().Parked {
+		t.Error("Expected document to be reloaded when a client connects")
+	}
+	doc, err := srv.Document()
+	if err != nil {
+		t.Fatalf("Failed to get document: %v", err)
+	}
+	if doc.ToText() != "parked text" {
+		t.Errorf("Expected reloaded text 'parked text', got '%s'", doc.ToText())
+	}
+}
+
+func TestServerDoesNotParkWithClients(t *testing.T) {
+	srv, addr := startTestServer(t, "busy")
+	dialTestClient(t, addr)
+	waitForClients(t, srv, 1)
+
+	srv.EnableParking(20*time.Millisecond, t.TempDir())
+	time.Sleep(150 * time.Millisecond)
+
+	if srv.Stats().Parked {
+		t.Error("Expected document with a connected client to stay loaded")
+	}
+}
+
+func TestServerLimitsSurviveParking(t *testing.T) {
+	srv, addr := startTestServer(t, "limited")
+	limits := crdt.Limits{MaxHistory: 100, MaxTombstones: 50}
+	srv.SetLimits(limits)
+	srv.EnableParking(50*time.Millisecond, t.TempDir())
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !srv.Stats().Parked {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected idle document to be parked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	dialTestClient(t, addr)
+	doc, err := srv.Document()
+	if err != nil {
+		t.Fatalf("Failed to get document: %v", err)
+	}
+	if doc.Limits() != limits {
+		t.Errorf("Expected limits %+v after reloading, got %+v", limits, doc.Limits())
+	}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"gollaborate/messages"
+	"gollaborate/shared"
+)
+
+// Quotas limit what each user may send, so one runaway client cannot swamp a
+// shared server such as a classroom's. Zero means no limit.
+type Quotas struct {
+	// Operations a user may send per minute; more are refused until the minute is up
+	OpsPerMinute int
+	// Characters a user may insert with a single edit, such as a paste
+	MaxPasteSize int
+}
+
+// Usage is what a user has contributed since the server started
+type Usage struct {
+	UserID    int
+	Ops       int
+	Bytes     int // Of the characters inserted, in UTF-8
+	Throttled int // Edits refused for exceeding a quota
+}
+
+// userUsage is a user's usage and their operations in the current minute
+type userUsage struct {
+	Usage
+	windowStart time.Time
+	windowOps   int
+}
+
+// SetQuotas sets the per-user quotas, taking effect from the next edit
+func (s *Server) SetQuotas(quotas Quotas) {
+	s.quotaMutex.Lock()
+	defer s.quotaMutex.Unlock()
+	s.quotas = quotas
+}
+
+// Quotas returns the per-user quotas
+func (s *Server) Quotas() Quotas {
+	s.quotaMutex.Lock()
+	defer s.quotaMutex.Unlock()
+	return s.quotas
+}
+
+// Usage returns what each user has contributed, by user ID
+func (s *Server) Usage() []Usage {
+	s.quotaMutex.Lock()
+	defer s.quotaMutex.Unlock()
+
+	usage := make([]Usage, 0, len(s.usage))
+	for _, u := range s.usage {
+		usage = append(usage, u.Usage)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].UserID < usage[j].UserID
+	})
+	return usage
+}
+
+// limitEdits refuses viewers' edits, counts a user's operations and refuses
+// those beyond the quotas. The user is who the connection goes by, and
+// operations carrying any other user's ID are refused, so a client cannot
+// escape its quotas by changing the ID it gives. The edits it lets through are
+// credited to the user in the room's history. Other servers pass on the edits
+// of many users, limited where they were made, so theirs are taken as they
+// come. It is the room's edit limiter, so it runs with the editor state locked.
+func (s *Server) limitEdits(r *room, conn messages.Transport, peer shared.PeerInfo, ops []*messages.Operation) *shared.LimitError {
+	if _, ok := conn.(*clusterLink); ok {
+		return nil
+	}
+	if limitErr := s.refuseViewer(conn); limitErr != nil {
+		return limitErr
+	}
+	claimed := peer.UserID
+	if claimed == 0 {
+		claimed = ops[0].UserID
+	}
+	id := s.claimIdentity(conn, claimed)
+	userID := id.userID
+	for _, op := range ops {
+		if op.UserID != userID {
+			return &shared.LimitError{
+				Code:   messages.ErrorCodeForbidden,
+				Reason: fmt.Sprintf("user %d cannot send the operations of user %d", userID, op.UserID),
+			}
+		}
+	}
+
+	s.quotaMutex.Lock()
+	defer s.quotaMutex.Unlock()
+
+	u, ok := s.usage[userID]
+	if !ok {
+		u = &userUsage{Usage: Usage{UserID: userID}}
+		s.usage[userID] = u
+	}
+	now := time.Now()
+	if now.Sub(u.windowStart) >= time.Minute {
+		u.windowStart = now
+		u.windowOps = 0
+	}
+
+	inserted, bytes := 0, 0
+	for _, op := range ops {
+		if op.Type == messages.OperationTypeInsert {
+			inserted++
+			bytes += max(utf8.RuneLen(op.Character), 0)
+		}
+	}
+
+	quotas := s.quotas
+	switch {
+	case quotas.MaxPasteSize > 0 && inserted > quotas.MaxPasteSize:
+		u.Throttled++
+		return &shared.LimitError{
+			Code:   messages.ErrorCodeTooLarge,
+			Reason: fmt.Sprintf("edit of %d characters is over the limit of %d", inserted, quotas.MaxPasteSize),
+		}
+	case quotas.OpsPerMinute > 0 && len(ops) > quotas.OpsPerMinute:
+		u.Throttled++
+		return &shared.LimitError{
+			Code:   messages.ErrorCodeTooLarge,
+			Reason: fmt.Sprintf("edit of %d operations is over the limit of %d per minute", len(ops), quotas.OpsPerMinute),
+		}
+	case quotas.OpsPerMinute > 0 && u.windowOps+len(ops) > quotas.OpsPerMinute:
+		u.Throttled++
+		return &shared.LimitError{
+			Code:       messages.ErrorCodeThrottled,
+			Reason:     fmt.Sprintf("over the limit of %d operations per minute", quotas.OpsPerMinute),
+			RetryAfter: u.windowStart.Add(time.Minute).Sub(now),
+		}
+	}
+
+	u.windowOps += len(ops)
+	u.Ops += len(ops)
+	u.Bytes += bytes
+	// Until its client is observed, a connection goes by its hello's name
+	if id.userName == "" {
+		id.userName = peer.UserName
+	}
+	r.history.credit(ops, id.userName)
+	return nil
+}
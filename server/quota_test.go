@@ -0,0 +1,119 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+)
+
+// receiveError waits for an error message, skipping others such as presence
+func receiveError(t *testing.T, conn *testClient) *messages.Message {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		msg, err := conn.Receive()
+		if err != nil {
+			t.Fatalf("Expected an error message: %v", err)
+		}
+		if msg.Type == messages.MessageTypeError {
+			return msg
+		}
+	}
+}
+
+func TestServerQuotas(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	srv.SetQuotas(Quotas{OpsPerMinute: 3, MaxPasteSize: 2})
+	alice := dialTestClient(t, addr)
+	waitForClients(t, srv, 1)
+
+	insert := func(digit int, char rune) *messages.Operation {
+		return messages.NewInsertOperation([]crdt.Identifier{{Digit: digit, Node: 1}}, char, 1, digit)
+	}
+
+	// A paste over the size quota is refused outright
+	paste := []*messages.Operation{insert(1, 'a'), insert(2, 'b'), insert(3, 'c')}
+	if err := messages.SendMessage(alice, messages.NewTransactionMessage(paste, messages.TransactionActionPaste, 1, "Alice")); err != nil {
+		t.Fatalf("Failed to send paste: %v", err)
+	}
+	if msg := receiveError(t, alice); msg.Code != messages.ErrorCodeTooLarge || msg.RetryAfter != 0 {
+		t.Errorf("Expected a too-large error, got %+v", msg)
+	}
+
+	// Edits within the rate quota are applied, the next is throttled
+	for i, char := range "héy" {
+		if err := messages.SendOperation(alice, insert(10+i, char)); err != nil {
+			t.Fatalf("Failed to send operation: %v", err)
+		}
+	}
+	if err := messages.SendOperation(alice, insert(20, '!')); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	msg := receiveError(t, alice)
+	if msg.Code != messages.ErrorCodeThrottled || msg.RetryAfter <= 0 || msg.RetryAfter > time.Minute.Milliseconds() {
+		t.Errorf("Expected a throttled error with a retry delay, got %+v", msg)
+	}
+	if text := srv.State().Document().ToText(); text != "héy" {
+		t.Errorf("Expected only the edits within quota, got %q", text)
+	}
+
+	usage := srv.Usage()
+	if len(usage) != 1 || usage[0] != (Usage{UserID: 1, Ops: 3, Bytes: 4, Throttled: 2}) {
+		t.Errorf("Unexpected usage: %+v", usage)
+	}
+	// Clients are identified as their messages are observed
+	stats := srv.Stats()
+	for deadline := time.Now().Add(2 * time.Second); stats.Clients[0].UserID != 1 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		stats = srv.Stats()
+	}
+	if stats.Quotas.OpsPerMinute != 3 || stats.Clients[0].Bytes != 4 || stats.Clients[0].Throttled != 2 {
+		t.Errorf("Expected quotas and usage in stats, got %+v", stats)
+	}
+}
+
+func TestServerRefusesOthersOperations(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	alice := dialTestClient(t, addr)
+	bob := dialTestClient(t, addr)
+	for i, conn := range []*testClient{alice, bob} {
+		if err := messages.SendMessage(conn, messages.NewHelloMessage(i+1, "", "")); err != nil {
+			t.Fatalf("Failed to send hello: %v", err)
+		}
+	}
+	insert := func(digit int, char rune, userID int) *messages.Operation {
+		return messages.NewInsertOperation([]crdt.Identifier{{Digit: digit, Node: userID}}, char, userID, digit)
+	}
+	bobs := insert(5, 'b', 2)
+	if err := messages.SendOperation(bob, bobs); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	waitForHistory(t, srv, "", "b")
+
+	// Alice cannot edit as bob, alone or amid her own edits
+	for _, msg := range []*messages.Message{
+		messages.NewOperationMessage(insert(6, 'x', 2)),
+		messages.NewTransactionMessage([]*messages.Operation{insert(1, 'a', 1), insert(7, 'x', 2)}, messages.TransactionActionPaste, 1, ""),
+		messages.NewCatchUpMessage([]*messages.Operation{insert(8, 'x', 2)}, 1),
+	} {
+		if err := messages.SendMessage(alice, msg); err != nil {
+			t.Fatalf("Failed to send %s: %v", msg.Type, err)
+		}
+		if msg := receiveError(t, alice); msg.Code != messages.ErrorCodeForbidden {
+			t.Errorf("Expected bob's edit forbidden, got %+v", msg)
+		}
+	}
+
+	// But her catch-up may carry his edits the server already has
+	if err := messages.SendMessage(alice, messages.NewCatchUpMessage([]*messages.Operation{bobs, insert(1, 'a', 1)}, 1)); err != nil {
+		t.Fatalf("Failed to send catch-up: %v", err)
+	}
+	waitForHistory(t, srv, "", "ab")
+	usage := srv.Usage()
+	if len(usage) != 2 || usage[0] != (Usage{UserID: 1, Ops: 1, Bytes: 1}) || usage[1] != (Usage{UserID: 2, Ops: 1, Bytes: 1}) {
+		t.Errorf("Expected each edit counted to its sender, got %+v", usage)
+	}
+}
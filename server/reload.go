@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gollaborate/messages"
+)
+
+// WatchFile reloads the server's own document from the text file at path
+// whenever the file changes on disk, such as after a git pull, rather than
+// serve a stale copy. The difference between the two is applied as edits by
+// the server, which clients are sent as one transaction; edits made here that
+// the file lacks are undone. The file is checked every interval until the
+// server closes, and may not exist yet. Call it before Serve.
+func (s *Server) WatchFile(path string, interval time.Duration) {
+	info, _ := os.Stat(path)
+	go s.watchFile(path, interval, info)
+}
+
+// watchFile reloads the document whenever the file's size or modification
+// time differs from the last seen
+func (s *Server) watchFile(path string, interval time.Duration, last os.FileInfo) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			// Such as while the file is being replaced
+			continue
+		}
+		if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+			continue
+		}
+		last = info
+		if err := s.reloadFile(path); err != nil {
+			s.recordError(fmt.Errorf("reloading %s: %w", path, err))
+		}
+	}
+}
+
+// reloadFile edits the server's own document into the file's text
+func (s *Server) reloadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.unpark(); err != nil {
+		return fmt.Errorf("reloading parked document: %w", err)
+	}
+	changed, err := s.state.ReplaceText(string(data), messages.TransactionActionReload)
+	if changed > 0 {
+		s.logger.Info("Reloaded the document from disk", "file", path, "changes", changed)
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+)
+
+func TestServerWatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(path, []byte("hello world"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	srv, addr := startTestServer(t, "hello world")
+	srv.WatchFile(path, 10*time.Millisecond)
+	alice := dialTestClient(t, addr)
+	waitForClients(t, srv, 1)
+
+	// Changed on disk, as by a git pull
+	if err := os.WriteFile(path, []byte("help, world!\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	waitForHistory(t, srv, "", "help, world!\n")
+
+	// Clients are sent the difference as one transaction by the server
+	_ = alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		msg, err := alice.Receive()
+		if err != nil {
+			t.Fatalf("Expected the reload sent: %v", err)
+		}
+		if msg.Type != messages.MessageTypeTransaction {
+			continue
+		}
+		if msg.Action != messages.TransactionActionReload || msg.UserID != 100 {
+			t.Errorf("Expected a reload by the server, got %+v", msg)
+		}
+		doc := crdt.FromText("hello world", 100)
+		for _, op := range msg.Operations {
+			if op.Type == messages.OperationTypeInsert {
+				_ = doc.InsertCharacter(op.Character, op.Position, op.Clock)
+			} else {
+				_ = doc.DeleteCharacter(op.Position)
+			}
+		}
+		if text := doc.ToText(); text != "help, world!\n" {
+			t.Errorf("Expected the client's copy reloaded, got '%s'", text)
+		}
+		break
+	}
+}
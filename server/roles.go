@@ -0,0 +1,191 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gollaborate/messages"
+	"gollaborate/shared"
+	"gollaborate/storage"
+	"gollaborate/users"
+)
+
+// setAccess records the role a connection's user has, for checks made with an
+// editor state locked
+func (s *Server) setAccess(conn messages.Transport, role users.Role) {
+	s.accessMutex.Lock()
+	defer s.accessMutex.Unlock()
+	s.access[conn] = role
+}
+
+// accessOf returns the role of a connection's user. Clients that did not
+// authenticate are editors.
+func (s *Server) accessOf(conn messages.Transport) users.Role {
+	s.accessMutex.Lock()
+	defer s.accessMutex.Unlock()
+	return s.access[conn]
+}
+
+// identity is who a connection's client is, for checks made with an editor
+// state locked
+type identity struct {
+	userID   int
+	userName string // As the client last gave it, or authenticated with
+}
+
+// claimIdentity returns who a connection's client is. Its user ID is the one
+// the client authenticated or resumed its session as, or else the one it gave
+// in its hello, or the first it gave if it said none. The ID given is taken
+// only if the connection has none yet.
+func (s *Server) claimIdentity(conn messages.Transport, userID int) identity {
+	s.accessMutex.Lock()
+	defer s.accessMutex.Unlock()
+	id := s.identities[conn]
+	if id.userID == 0 && userID != 0 {
+		id.userID = userID
+		s.identities[conn] = id
+	}
+	return id
+}
+
+// nameIdentity records the name a connection's client goes by
+func (s *Server) nameIdentity(conn messages.Transport, userName string) {
+	s.accessMutex.Lock()
+	defer s.accessMutex.Unlock()
+	id := s.identities[conn]
+	id.userName = userName
+	s.identities[conn] = id
+}
+
+// forgetAccess drops the roles and user IDs of connections that are gone
+func (s *Server) forgetAccess(live map[messages.Transport]bool) {
+	s.accessMutex.Lock()
+	defer s.accessMutex.Unlock()
+	for conn := range s.access {
+		if !live[conn] {
+			delete(s.access, conn)
+		}
+	}
+	for conn := range s.identities {
+		if !live[conn] {
+			delete(s.identities, conn)
+		}
+	}
+}
+
+// refuseViewer refuses the edits of viewers. It runs as part of the editor
+// state's edit limiter, with the state locked.
+func (s *Server) refuseViewer(conn messages.Transport) *shared.LimitError {
+	if s.accessOf(conn).CanEdit() {
+		return nil
+	}
+	return &shared.LimitError{Code: messages.ErrorCodeForbidden, Reason: "viewers cannot edit the document"}
+}
+
+// runAdminCommand carries out an admin command a client sent from a room,
+// returning the answer to send it
+func (s *Server) runAdminCommand(r *room, conn messages.Transport, msg *messages.Message) *messages.Message {
+	if !s.accessOf(conn).CanAdminister() {
+		s.recordError(fmt.Errorf("%s: refused %s from a non-admin", r.describe(conn), msg.Command))
+		return messages.NewCodedErrorMessage(fmt.Sprintf("only admins may %s", msg.Command), messages.ErrorCodeForbidden, s.nodeID)
+	}
+	text, err := s.adminCommand(r, msg)
+	if err != nil {
+		s.recordError(fmt.Errorf("%s: %s: %w", r.describe(conn), msg.Command, err))
+		return messages.NewErrorMessage(fmt.Sprintf("%s failed: %v", msg.Command, err), s.nodeID)
+	}
+	return messages.NewAdminReplyMessage(msg.Command, text, s.nodeID)
+}
+
+// adminCommand carries out an admin command on a room, returning the text the
+// answer carries. Whoever sent it must have been checked to be allowed to.
+func (s *Server) adminCommand(r *room, msg *messages.Message) (string, error) {
+	text := ""
+	var err error
+	switch msg.Command {
+	case messages.AdminCommandKick:
+		if s.kickUser(msg.Target) == 0 {
+			err = fmt.Errorf("user %d is not connected", msg.Target)
+		}
+	case messages.AdminCommandLock, messages.AdminCommandUnlock:
+		r.state.SetReadOnly(msg.Command == messages.AdminCommandLock)
+	case messages.AdminCommandFreeze, messages.AdminCommandUnfreeze:
+		r.state.SetFrozen(msg.Command == messages.AdminCommandFreeze)
+	case messages.AdminCommandSave:
+		s.mutex.Lock()
+		stored := s.store != nil
+		s.mutex.Unlock()
+		if !stored {
+			err = errors.New("the server keeps no store to save to")
+		} else {
+			err = s.saveRooms([]*room{r})
+		}
+	case messages.AdminCommandExport:
+		var data []byte
+		if data, err = s.Export(r.name, msg.Format); err == nil {
+			text = string(data)
+		}
+	case messages.AdminCommandImport:
+		err = s.Import(msg.Room, msg.Format, []byte(msg.Text))
+	case messages.AdminCommandNotice:
+		s.notice(msg.Text)
+	case messages.AdminCommandClients:
+		text = clientsReport(s.Stats())
+	case messages.AdminCommandStats:
+		text = statsReport(s.Stats())
+	case messages.AdminCommandAudit:
+		name := msg.Room
+		if r != nil {
+			name = r.name
+		}
+		var trail []storage.AuditEntry
+		if trail, err = s.Audit(name); err == nil {
+			text = auditReport(trail, msg.Target)
+		}
+	case messages.AdminCommandInvite:
+		name := msg.Room
+		if r != nil {
+			name = r.name
+		}
+		role := users.RoleEditor
+		if msg.Text != "" {
+			role, err = users.ParseRole(msg.Text)
+		}
+		if err == nil {
+			text, err = s.MintJoinCode(name, role, time.Duration(msg.Target)*time.Second)
+		}
+	case messages.AdminCommandForget:
+		var anonID int
+		if anonID, err = s.ForgetUser(msg.Target); err == nil {
+			text = fmt.Sprintf("Forgot user %d, whose edits are audited as user %d", msg.Target, anonID)
+		}
+	default:
+		err = fmt.Errorf("unknown admin command %q", msg.Command)
+	}
+	return text, err
+}
+
+// kickUser disconnects every connection of a user, in every room, returning
+// how many there were: those that go by the user's ID, whatever IDs their
+// messages carry since. None of them can resume their session.
+func (s *Server) kickUser(userID int) int {
+	s.mutex.Lock()
+	kicked := make(map[messages.Transport]*room)
+	for conn, c := range s.clients {
+		if c.userID == userID {
+			kicked[conn] = c.room
+		}
+	}
+	s.mutex.Unlock()
+
+	conns := make(map[messages.Transport]bool, len(kicked))
+	for conn := range kicked {
+		conns[conn] = true
+	}
+	s.endSessions(conns)
+	for conn, r := range kicked {
+		r.state.RemoveConn(conn)
+	}
+	return len(kicked)
+}
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/users"
+)
+
+// startRolesServer starts a test server admitting a viewer, an editor and an admin
+func startRolesServer(t *testing.T, text string) (*Server, string) {
+	t.Helper()
+
+	srv, addr := startTestServer(t, text)
+	srv.SetAuth(Auth{
+		Users: map[string]string{"vic": "vic-token", "eve": "eve-token", "ada": "ada-token"},
+		Roles: map[string]users.Role{"vic": users.RoleViewer, "ada": users.RoleAdmin},
+	})
+	return srv, addr
+}
+
+// dialUserClient connects to the server as a user with their token and
+// consumes everything up to the document description
+func dialUserClient(t *testing.T, addr, token string, userID int) *testClient {
+	t.Helper()
+	return dialAdmitted(t, addr, messages.NewAuthMessage(token, "", userID))
+}
+
+// dialSpectator connects to the server as a user with their token, to watch
+// its own document as a spectator
+func dialSpectator(t *testing.T, addr, token string, userID int) *testClient {
+	t.Helper()
+	return dialAdmitted(t, addr, messages.NewAuthMessage(token, "", userID), messages.NewSpectateMessage("", userID))
+}
+
+// dialAdmitted connects to the server, sends the messages it takes before
+// admitting clients and consumes everything up to the document description
+func dialAdmitted(t *testing.T, addr string, first ...*messages.Message) *testClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	for _, msg := range first {
+		if err := messages.SendMessage(conn, msg); err != nil {
+			t.Fatalf("Failed to send %s: %v", msg.Type, err)
+		}
+	}
+	client := &testClient{Conn: conn, Reader: messages.NewReader(conn)}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		msg, err := client.Receive()
+		if err != nil {
+			t.Fatalf("Expected to be admitted: %v", err)
+		}
+		if msg.Type == messages.MessageTypeDocMeta {
+			return client
+		}
+	}
+}
+
+// receiveAdmin waits for the answer to an admin command, or an error
+func receiveAdmin(t *testing.T, conn *testClient) *messages.Message {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		msg, err := conn.Receive()
+		if err != nil {
+			t.Fatalf("Expected an answer to the admin command: %v", err)
+		}
+		if msg.Type == messages.MessageTypeAdmin || msg.Type == messages.MessageTypeError {
+			return msg
+		}
+	}
+}
+
+func TestServerRefusesViewerEdits(t *testing.T) {
+	srv, addr := startRolesServer(t, "")
+	vic := dialUserClient(t, addr, "vic-token", 3)
+	eve := dialUserClient(t, addr, "eve-token", 4)
+
+	// Whether they send edits as themselves or as someone else
+	pos := []crdt.Identifier{{Digit: 5, Node: 3}}
+	for _, userID := range []int{3, 5} {
+		if err := messages.SendOperation(vic, messages.NewInsertOperation(pos, 'v', userID, 1)); err != nil {
+			t.Fatalf("Failed to send operation: %v", err)
+		}
+		if msg := receiveError(t, vic); msg.Code != messages.ErrorCodeForbidden {
+			t.Errorf("Expected the viewer's edit as user %d forbidden, got %+v", userID, msg)
+		}
+	}
+
+	if err := messages.SendOperation(eve, messages.NewInsertOperation([]crdt.Identifier{{Digit: 5, Node: 4}}, 'e', 4, 1)); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	_ = vic.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		msg, err := vic.Receive()
+		if err != nil {
+			t.Fatalf("Expected the editor's edit relayed to the viewer: %v", err)
+		}
+		if msg.Type == messages.MessageTypeOperation {
+			if msg.Operation.Character != 'e' {
+				t.Errorf("Expected the editor's edit, got %+v", msg.Operation)
+			}
+			break
+		}
+	}
+
+	for _, c := range waitForClients(t, srv, 2).Clients {
+		if c.User.Name == "vic" && (c.User.Role != users.RoleViewer || !c.ReadOnly) {
+			t.Errorf("Expected vic listed as a read-only viewer, got %+v", c)
+		}
+	}
+}
+
+func TestServerRefusesEditsAsItself(t *testing.T) {
+	srv, addr := startRolesServer(t, "")
+	vic := dialUserClient(t, addr, "vic-token", 3)
+	eve := dialUserClient(t, addr, "eve-token", 4)
+
+	// Edits passing for the server's own (node 100) would skip its checks
+	insert := func(digit int, char rune, userID int) *messages.Operation {
+		return messages.NewInsertOperation([]crdt.Identifier{{Digit: digit, Node: userID}}, char, userID, digit)
+	}
+	for _, forged := range []struct {
+		conn *testClient
+		msg  *messages.Message
+	}{
+		{vic, messages.NewOperationMessage(insert(5, 'v', 100))},
+		{eve, messages.NewOperationMessage(insert(6, 'x', 100))},
+		{eve, messages.NewTransactionMessage([]*messages.Operation{insert(7, 'e', 4), insert(8, 'x', 100)}, messages.TransactionActionPaste, 4, "")},
+	} {
+		if err := messages.SendMessage(forged.conn, forged.msg); err != nil {
+			t.Fatalf("Failed to send %s: %v", forged.msg.Type, err)
+		}
+		if msg := receiveError(t, forged.conn); msg.Code != messages.ErrorCodeForbidden {
+			t.Errorf("Expected the edit as the server forbidden, got %+v", msg)
+		}
+	}
+
+	// None of them reached the document or the other clients
+	if err := messages.SendOperation(eve, insert(9, 'e', 4)); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	if msg := receiveMessage(t, vic, messages.MessageTypeOperation); msg.Operation.Character != 'e' {
+		t.Errorf("Expected only the editor's own edit relayed, got %+v", msg.Operation)
+	}
+	waitForHistory(t, srv, "", "e")
+}
+
+func TestServerRefusesViewerSync(t *testing.T) {
+	srv, addr := startRolesServer(t, "orig")
+	vic := dialUserClient(t, addr, "vic-token", 3)
+
+	// A document of their own would otherwise be merged into the server's
+	if err := messages.SendMessage(vic, messages.NewSyncMessage(crdt.FromText("pwned", 3), 3)); err != nil {
+		t.Fatalf("Failed to send sync: %v", err)
+	}
+	if msg := receiveError(t, vic); msg.Code != messages.ErrorCodeForbidden {
+		t.Errorf("Expected the viewer's sync forbidden, got %+v", msg)
+	}
+	if text := srv.State().Document().ToText(); text != "orig" {
+		t.Errorf("Expected the document to stay 'orig', got %q", text)
+	}
+}
+
+func TestServerRefusesSpectatorEdits(t *testing.T) {
+	srv, addr := startRolesServer(t, "")
+	// Even an admin, who could otherwise edit
+	ada := dialSpectator(t, addr, "ada-token", 7)
+	eve := dialUserClient(t, addr, "eve-token", 4)
+
+	if err := messages.SendOperation(ada, messages.NewInsertOperation([]crdt.Identifier{{Digit: 5, Node: 7}}, 'a', 7, 1)); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	if msg := receiveError(t, ada); msg.Code != messages.ErrorCodeForbidden {
+		t.Errorf("Expected the spectator's edit forbidden, got %+v", msg)
+	}
+
+	if err := messages.SendOperation(eve, messages.NewInsertOperation([]crdt.Identifier{{Digit: 5, Node: 4}}, 'e', 4, 1)); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	if msg := receiveMessage(t, ada, messages.MessageTypeOperation); msg.Operation.Character != 'e' {
+		t.Errorf("Expected the editor's edit relayed to the spectator, got %+v", msg.Operation)
+	}
+	waitForHistory(t, srv, "", "e")
+
+	for _, c := range waitForClients(t, srv, 2).Clients {
+		if c.User.Name == "ada" && !c.Spectator {
+			t.Errorf("Expected ada listed as a spectator, got %+v", c)
+		}
+	}
+	srv.mutex.Lock()
+	roster := srv.roster(srv.defaultRoom)
+	srv.mutex.Unlock()
+	if i := slices.IndexFunc(roster, func(e messages.RosterEntry) bool { return e.UserName == "ada" }); i < 0 || roster[i].Role != string(users.RoleViewer) {
+		t.Errorf("Expected ada on the roster as a viewer, got %+v", roster)
+	}
+}
+
+func TestServerAdminCommands(t *testing.T) {
+	srv, addr := startRolesServer(t, "hello")
+	eve := dialUserClient(t, addr, "eve-token", 4)
+	ada := dialUserClient(t, addr, "ada-token", 7)
+
+	if err := messages.SendMessage(eve, messages.NewAdminMessage(messages.AdminCommandLock, 0, 4)); err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+	if msg := receiveAdmin(t, eve); msg.Type != messages.MessageTypeError || msg.Code != messages.ErrorCodeForbidden {
+		t.Errorf("Expected an editor's command forbidden, got %+v", msg)
+	}
+	if srv.State().ReadOnly() {
+		t.Fatal("Expected the document still writable")
+	}
+
+	if err := messages.SendMessage(ada, messages.NewAdminMessage(messages.AdminCommandLock, 0, 7)); err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+	if msg := receiveAdmin(t, ada); msg.Type != messages.MessageTypeAdmin || msg.Command != messages.AdminCommandLock || !srv.State().ReadOnly() {
+		t.Errorf("Expected the admin to lock the document, got %+v", msg)
+	}
+
+	if err := messages.SendMessage(ada, messages.NewAdminMessage(messages.AdminCommandExport, 0, 7)); err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+	if msg := receiveAdmin(t, ada); msg.Type != messages.MessageTypeAdmin || msg.Text != "hello" {
+		t.Errorf("Expected the document exported, got %+v", msg)
+	}
+
+	// Without a store there is nowhere to save to
+	if err := messages.SendMessage(ada, messages.NewAdminMessage(messages.AdminCommandSave, 0, 7)); err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+	if msg := receiveAdmin(t, ada); msg.Type != messages.MessageTypeError {
+		t.Errorf("Expected saving without a store to fail, got %+v", msg)
+	}
+
+	if err := messages.SendMessage(ada, messages.NewAdminMessage(messages.AdminCommandKick, 4, 7)); err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+	if msg := receiveAdmin(t, ada); msg.Type != messages.MessageTypeAdmin {
+		t.Errorf("Expected the editor kicked, got %+v", msg)
+	}
+	_ = eve.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, err := eve.Receive(); err != nil {
+			break
+		}
+	}
+	if stats := waitForClients(t, srv, 1); stats.Clients[0].User.Name != "ada" {
+		t.Errorf("Expected only the admin left, got %+v", stats.Clients)
+	}
+}
+
+func TestServerKeepsClientIdentity(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	eve := dialTestClient(t, addr)
+	ada := dialTestClient(t, addr)
+	for _, hello := range []struct {
+		conn *testClient
+		msg  *messages.Message
+	}{{eve, messages.NewHelloMessage(4, "eve", "")}, {ada, messages.NewHelloMessage(7, "ada", "")}} {
+		if err := messages.SendMessage(hello.conn, hello.msg); err != nil {
+			t.Fatalf("Failed to send hello: %v", err)
+		}
+	}
+
+	// Eve may rename herself, but not take ada's ID
+	waitForClient := func(name string) ClientInfo {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			stats := srv.Stats()
+			if i := slices.IndexFunc(stats.Clients, func(c ClientInfo) bool { return c.UserName == name }); i >= 0 {
+				return stats.Clients[i]
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %s connected, got %+v", name, stats.Clients)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitForClient("eve")
+	if err := messages.SendMessage(eve, messages.NewUserInfoMessage(7, "mallory", "")); err != nil {
+		t.Fatalf("Failed to send user info: %v", err)
+	}
+	if c := waitForClient("mallory"); c.UserID != 4 {
+		t.Errorf("Expected the client to stay user 4, got %d", c.UserID)
+	}
+
+	// So kicking ada leaves eve connected
+	if kicked := srv.kickUser(7); kicked != 1 {
+		t.Errorf("Expected ada's one connection kicked, got %d", kicked)
+	}
+	if stats := waitForClients(t, srv, 1); stats.Clients[0].UserID != 4 {
+		t.Errorf("Expected eve left, got %+v", stats.Clients)
+	}
+}
+
+func TestGRPCRoles(t *testing.T) {
+	srv, _ := startTestServer(t, "hello")
+	srv.SetAuth(Auth{Token: "s3cret", DefaultRole: users.RoleViewer})
+	client, url := startTestGRPC(t, srv)
+
+	req := messages.SetLockedRequest{Locked: true}.Marshal()
+	if _, status := callGRPCWithToken(t, client, url+"SetLocked", req, "s3cret"); status != "7" {
+		t.Errorf("Expected a viewer's call to lock denied, got status %s", status)
+	}
+	if srv.State().ReadOnly() {
+		t.Error("Expected the document still writable")
+	}
+}
@@ -0,0 +1,423 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"unicode"
+
+	"gollaborate/autosave"
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/presence"
+	"gollaborate/shared"
+	"gollaborate/storage"
+	"gollaborate/users"
+)
+
+const (
+	// maxRooms caps how many rooms a server hosts, so clients cannot use up
+	// its memory by naming ever more of them
+	maxRooms = 256
+	// maxRoomName is the longest room name accepted, in bytes
+	maxRoomName = 64
+)
+
+// joinWait is how long a new connection has to name its room before it joins
+// the server's own document
+var joinWait = 200 * time.Millisecond
+
+var (
+	// ErrRoomName is returned for a room name that is too long or unprintable
+	ErrRoomName = errors.New("invalid room name")
+	// ErrTooManyRooms is returned when a client names a new room on a server
+	// already hosting as many as it may
+	ErrTooManyRooms = errors.New("too many rooms")
+)
+
+// room is one of the documents the server hosts, with the clients editing it.
+// Edits, presence and sync stay within a room.
+type room struct {
+	name    string
+	state   *shared.EditorState
+	history *roomHistory           // See history.go
+	main    bool                   // Whether this is the server's own document, named as the server is
+	link    *clusterLink           // To the other servers hosting the room, if any; see cluster.go
+	roster  []messages.RosterEntry // As last sent to the room's clients, see roster.go
+	audit   []storage.AuditEntry   // Who edited the room, if the server has no store to keep it; see audit.go
+	saver   *autosave.Saver        // Saving the room's document while it is edited, if set; see autosave.go
+	// The last edit before the room's document went idle, as told to webhooks; see webhook.go
+	idleSince time.Time
+}
+
+// newRoom sets up a room hosting the given document, after the logged edits
+// the store had for it. The caller must hold s.mutex, except while the server
+// is being created.
+func (s *Server) newRoom(name string, doc *crdt.Document, logged []storage.LoggedOp) *room {
+	history := newRoomHistory(doc, logged)
+	doc.EnableHistory()
+	doc.SetLimits(s.limits)
+	r := &room{name: name, state: shared.NewEditorState(doc, s.nodeID), history: history}
+	r.state.RecordOps(history.record)
+	r.state.SetRelay(true)
+	r.state.SetOrdering(true)
+	r.state.SetEditLimiter(func(conn messages.Transport, peer shared.PeerInfo, ops []*messages.Operation) *shared.LimitError {
+		return s.limitEdits(r, conn, peer, ops)
+	})
+	r.state.AddConnListener(func(conn messages.Transport, msg *messages.Message) {
+		s.observe(r, conn, msg)
+	})
+	r.state.AddCloseListener(func(conn messages.Transport) {
+		s.dropClient(r, conn)
+	})
+	r.state.SetErrorHandler(func(conn messages.Transport, err error) {
+		s.recordError(fmt.Errorf("%s: %w", r.describe(conn), err))
+	})
+	r.state.EnableHeartbeat(shared.DefaultHeartbeatInterval, shared.DefaultHeartbeatMisses)
+	if s.digestInterval > 0 {
+		r.state.EnableDigests(s.digestInterval)
+	}
+	r.state.SetTitle(name)
+	for _, configure := range s.configure {
+		configure(name, r.state)
+	}
+	if s.broker != nil {
+		s.link(r)
+	}
+	s.startAutosave(r)
+	return r
+}
+
+// describe names a connection in the room for error lines. Those in the
+// server's own document are named by address alone, as before there were rooms.
+func (r *room) describe(conn messages.Transport) string {
+	if r.main {
+		return remoteAddr(conn)
+	}
+	return fmt.Sprintf("%s in %s", remoteAddr(conn), r.name)
+}
+
+// ConfigureRooms calls fn with the editor state of every room, both those open
+// now and those clients open later, so settings such as the codec or approval
+// rules apply to them all. The server's own document is the room named as the
+// server is.
+func (s *Server) ConfigureRooms(fn func(name string, state *shared.EditorState)) {
+	s.mutex.Lock()
+	s.configure = append(s.configure, fn)
+	rooms := s.roomList()
+	s.mutex.Unlock()
+
+	for _, r := range rooms {
+		fn(r.name, r.state)
+	}
+}
+
+// SetDigestInterval sets how often every room sends its clients a digest of
+// its document, by which those whose copy diverged, such as through a lost
+// message, resync; shared.DefaultDigestInterval unless set. Zero sends none.
+// Call it before Serve.
+func (s *Server) SetDigestInterval(interval time.Duration) {
+	s.mutex.Lock()
+	s.digestInterval = interval
+	rooms := s.roomList()
+	s.mutex.Unlock()
+
+	for _, r := range rooms {
+		if interval > 0 {
+			r.state.EnableDigests(interval)
+		} else {
+			r.state.DisableDigests()
+		}
+	}
+}
+
+// Room returns the editor state of the named room, or nil if no client has
+// opened it. The empty name is the server's own document, which State returns.
+func (s *Server) Room(name string) *shared.EditorState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if name == "" {
+		return s.state
+	}
+	if r, ok := s.rooms[name]; ok {
+		return r.state
+	}
+	return nil
+}
+
+// Rooms describes every room the server hosts, by name
+func (s *Server) Rooms() []messages.RoomInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.roomInfo()
+}
+
+// room returns the named room, opening it if need be with the store's copy of
+// its document, or else a new one started from the named template or empty.
+// The empty name is the server's own document. The caller must hold s.mutex.
+func (s *Server) room(name, template string) (*room, error) {
+	if name == "" {
+		name = s.name
+	}
+	if r, ok := s.rooms[name]; ok {
+		return r, nil
+	}
+	if !validRoomName(name) {
+		return nil, fmt.Errorf("%w: %q", ErrRoomName, name)
+	}
+	if len(s.rooms) >= maxRooms {
+		return nil, ErrTooManyRooms
+	}
+	doc, logged, err := s.storedDocument(name, template)
+	if err != nil {
+		return nil, err
+	}
+	r := s.newRoom(name, doc, logged)
+	s.rooms[name] = r
+	return r, nil
+}
+
+// validRoomName reports whether a room name is short enough and printable
+// UTF-8, so dashboards and room lists can show it
+func validRoomName(name string) bool {
+	if len(name) > maxRoomName {
+		return false
+	}
+	for _, r := range name {
+		if r == unicode.ReplacementChar || !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// roomList returns every room. The caller must hold s.mutex.
+func (s *Server) roomList() []*room {
+	rooms := make([]*room, 0, len(s.rooms))
+	for _, r := range s.rooms {
+		rooms = append(rooms, r)
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].name < rooms[j].name
+	})
+	return rooms
+}
+
+// roomInfo describes every room. The caller must hold s.mutex.
+func (s *Server) roomInfo() []messages.RoomInfo {
+	rooms := s.roomList()
+	info := make([]messages.RoomInfo, len(rooms))
+	for i, r := range rooms {
+		users := 0
+		for _, conn := range r.state.Connections() {
+			if conn != messages.Transport(r.link) {
+				users++
+			}
+		}
+		info[i] = messages.RoomInfo{Name: r.name, Users: users}
+	}
+	return info
+}
+
+// admit puts a new connection in the room it names, once it presented its
+// credentials if the server asks for them. Clients first send a resume
+// message if reconnecting, then an auth message, if need be, then a room join.
+// Those naming no room, or saying nothing for joinWait, join the server's own
+// document, where whatever they sent is handled like the rest. Those with a
+// join code join the room it was minted for, whether they name it or not.
+func (s *Server) admit(conn messages.Transport) {
+	t := &roomTransport{Transport: conn, first: make(chan firstMessage, 1)}
+	t.readAhead()
+
+	s.mutex.Lock()
+	auth := s.auth
+	s.mutex.Unlock()
+	wait := joinWait
+	if auth.Required() {
+		wait = authWait
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	var user *users.User
+	var resumed *session
+	name, template, spectate := "", "", false
+admission:
+	for {
+		select {
+		case first := <-t.first:
+			switch {
+			case first.err == nil && first.msg.Type == messages.MessageTypeResume && user == nil && resumed == nil:
+				if resumed = s.resumeSession(first.msg); resumed == nil {
+					s.refuse(conn, ErrSessionExpired, messages.ErrorCodeSessionExpired)
+					return
+				}
+				user = resumed.client.user
+				timer.Reset(joinWait)
+				t.readAhead()
+			case first.err == nil && first.msg.Type == messages.MessageTypeAuth && resumed != nil:
+				// Credentials are already known from the session
+				t.readAhead()
+			case first.err == nil && first.msg.Type == messages.MessageTypeAuth && user == nil && first.msg.JoinCode != "":
+				var err error
+				if user, err = s.redeemJoinCode(auth, first.msg); err != nil {
+					s.refuse(conn, err, messages.ErrorCodeUnauthorized)
+					return
+				}
+				timer.Reset(joinWait)
+				t.readAhead()
+			case first.err == nil && first.msg.Type == messages.MessageTypeAuth && user == nil:
+				var err error
+				if user, err = s.authenticate(auth, first.msg); err != nil {
+					s.refuse(conn, err, messages.ErrorCodeUnauthorized)
+					return
+				}
+				timer.Reset(joinWait)
+				t.readAhead()
+			case first.err == nil && first.msg.Type == messages.MessageTypeJoinRoom:
+				name, template, spectate = first.msg.Room, first.msg.Template, first.msg.Spectate
+				t.taken = true
+				break admission
+			default:
+				t.first <- first
+				break admission
+			}
+		case <-timer.C:
+			break admission
+		case <-s.done:
+			_ = conn.Close()
+			return
+		}
+	}
+	if auth.Required() && user == nil && resumed == nil {
+		s.refuse(conn, ErrUnauthorized, messages.ErrorCodeUnauthorized)
+		return
+	}
+	// Clients a join code admitted, and sessions they resume, stay in its room
+	if user != nil && user.Room != "" {
+		if name != "" && name != user.Room {
+			s.refuse(conn, fmt.Errorf("%w: the join code is for %q", ErrUnauthorized, user.Room), messages.ErrorCodeUnauthorized)
+			return
+		}
+		name = user.Room
+	}
+
+	s.mutex.Lock()
+	r, err := s.room(name, template)
+	s.mutex.Unlock()
+	if err != nil {
+		s.refuse(conn, fmt.Errorf("joining room: %w", err), "")
+		return
+	}
+	s.join(r, t, user, resumed, spectate)
+}
+
+// refuse tells a connection why it is not admitted and closes it
+func (s *Server) refuse(conn messages.Transport, err error, code messages.ErrorCode) {
+	s.recordError(fmt.Errorf("%s: %w", remoteAddr(conn), err))
+	_ = conn.Send(messages.NewCodedErrorMessage(err.Error(), code, s.nodeID))
+	_ = conn.Close()
+}
+
+// answerRoomMessage answers the room messages a client sends once in a room,
+// reporting whether the message was one
+func (s *Server) answerRoomMessage(r *room, conn messages.Transport, msg *messages.Message) bool {
+	var reply *messages.Message
+	switch {
+	case msg.Type == messages.MessageTypeListRooms && len(msg.Rooms) == 0:
+		s.mutex.Lock()
+		reply = messages.NewRoomListMessage(s.roomInfo(), s.nodeID)
+		s.mutex.Unlock()
+	case msg.Type == messages.MessageTypeJoinRoom:
+		reply = messages.NewErrorMessage(fmt.Sprintf("already in room %q: reconnect to join %q", r.name, msg.Room), s.nodeID)
+	case msg.Type == messages.MessageTypeAdmin:
+		reply = s.runAdminCommand(r, conn, msg)
+	case msg.Type == messages.MessageTypeDocumentAt && msg.Document == nil:
+		at := time.Time{}
+		if msg.At != 0 {
+			at = time.UnixMilli(msg.At)
+		}
+		doc, err := s.DocumentAt(r.name, at, msg.Clocks)
+		if err != nil {
+			reply = messages.NewErrorMessage(fmt.Sprintf("rebuilding the document: %v", err), s.nodeID)
+		} else {
+			reply = messages.NewDocumentAtReplyMessage(doc, msg.At, s.nodeID)
+		}
+	default:
+		return false
+	}
+	if err := r.state.SendTo(conn, reply); err != nil {
+		s.recordError(fmt.Errorf("%s: answering %s: %w", r.describe(conn), msg.Type, err))
+	}
+	return true
+}
+
+// expirePresence regularly takes clients that stopped renewing their presence
+// offline in every room, so new clients are not told about them
+func (s *Server) expirePresence() {
+	ticker := time.NewTicker(presence.DefaultTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.mutex.Lock()
+			rooms := s.roomList()
+			s.mutex.Unlock()
+			for _, r := range rooms {
+				r.state.RenewPresence()
+			}
+		}
+	}
+}
+
+// firstMessage is what a connection sent first, or why it could not be read
+type firstMessage struct {
+	msg *messages.Message
+	err error
+}
+
+// roomTransport is a connection read for its credentials and room name before
+// it joined the room. The message after them is handed to the room.
+type roomTransport struct {
+	messages.Transport
+	first chan firstMessage
+	taken bool // Whether the message read ahead was taken; only Receive reads it afterwards
+}
+
+// readAhead reads the next message for admit to look at
+func (t *roomTransport) readAhead() {
+	go func() {
+		msg, err := t.Transport.Receive()
+		t.first <- firstMessage{msg: msg, err: err}
+	}()
+}
+
+func (t *roomTransport) Receive() (*messages.Message, error) {
+	if !t.taken {
+		t.taken = true
+		first := <-t.first
+		return first.msg, first.err
+	}
+	return t.Transport.Receive()
+}
+
+func (t *roomTransport) SendWith(msg *messages.Message, codec messages.Codec) error {
+	return messages.SendWith(t.Transport, msg, codec)
+}
+
+func (t *roomTransport) SendBatch(msgs []*messages.Message, codec messages.Codec) error {
+	return messages.SendBatch(t.Transport, msgs, codec)
+}
+
+// SetWriteDeadline gives writes a deadline, if the connection supports one
+func (t *roomTransport) SetWriteDeadline(deadline time.Time) error {
+	if d, ok := t.Transport.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return d.SetWriteDeadline(deadline)
+	}
+	return nil
+}
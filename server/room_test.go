@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+)
+
+func TestServerRooms(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	alice := dialRoomClient(t, addr, "lecture")
+	bob := dialRoomClient(t, addr, "lecture")
+	dialTestClient(t, addr)
+	stats := waitForClients(t, srv, 3)
+
+	inLecture := 0
+	for _, c := range stats.Clients {
+		if c.Room == "lecture" {
+			inLecture++
+		}
+	}
+	if inLecture != 2 {
+		t.Errorf("Expected 2 clients in the lecture room, got %d: %+v", inLecture, stats.Clients)
+	}
+
+	op := messages.NewInsertOperation([]crdt.Identifier{{Digit: 5, Node: 1}}, 'A', 1, 1)
+	if err := messages.SendOperation(alice, op); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	_ = bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		msg, err := bob.Receive()
+		if err != nil {
+			t.Fatalf("Expected the edit relayed within the room: %v", err)
+		}
+		if msg.Type == messages.MessageTypeOperation {
+			break
+		}
+	}
+
+	lecture := srv.Room("lecture")
+	if lecture == nil {
+		t.Fatal("Expected the lecture room to be open")
+	}
+	if text := lecture.Document().ToText(); text != "A" {
+		t.Errorf("Expected the lecture document 'A', got '%s'", text)
+	}
+	if text := srv.State().Document().ToText(); text != "" {
+		t.Errorf("Expected the server's own document untouched, got '%s'", text)
+	}
+	if srv.Room("seminar") != nil {
+		t.Error("Expected no room nobody opened")
+	}
+}
+
+func TestListRooms(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	dialRoomClient(t, addr, "lecture")
+	alice := dialTestClient(t, addr)
+	waitForClients(t, srv, 2)
+
+	if err := messages.SendMessage(alice, messages.NewListRoomsMessage(1)); err != nil {
+		t.Fatalf("Failed to list rooms: %v", err)
+	}
+	_ = alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		msg, err := alice.Receive()
+		if err != nil {
+			t.Fatalf("Expected the room list: %v", err)
+		}
+		if msg.Type != messages.MessageTypeListRooms {
+			continue
+		}
+		want := []messages.RoomInfo{{Name: "lecture", Users: 1}, {Name: "test", Users: 1}}
+		if len(msg.Rooms) != len(want) || msg.Rooms[0] != want[0] || msg.Rooms[1] != want[1] {
+			t.Errorf("Expected rooms %+v, got %+v", want, msg.Rooms)
+		}
+		return
+	}
+}
+
+func TestInvalidRoomName(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = raw.Close() })
+	conn := &testClient{Conn: raw, Reader: messages.NewReader(raw)}
+	if err := messages.SendMessage(conn, messages.NewJoinRoomMessage(strings.Repeat("x", maxRoomName+1), 1)); err != nil {
+		t.Fatalf("Failed to send join: %v", err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msg, err := conn.Receive()
+	if err != nil || msg.Type != messages.MessageTypeError {
+		t.Fatalf("Expected the join refused, got %+v (%v)", msg, err)
+	}
+	if _, err := conn.Receive(); err == nil {
+		t.Error("Expected the connection closed after the refusal")
+	}
+	if rooms := srv.Rooms(); len(rooms) != 1 {
+		t.Errorf("Expected only the server's own room, got %+v", rooms)
+	}
+	if validRoomName("bad\x00name") {
+		t.Error("Expected a name with a control character refused")
+	}
+}
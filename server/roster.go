@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"gollaborate/messages"
+	"gollaborate/users"
+	"gollaborate/webhook"
+)
+
+// roster lists the users in a room, each once however many connections they
+// have, by ID. Clients that have not said who they are yet are left out. The
+// caller must hold s.mutex.
+func (s *Server) roster(r *room) []messages.RosterEntry {
+	byID := make(map[int]*messages.RosterEntry)
+	for _, c := range s.clients {
+		if c.room != r || c.userID == 0 {
+			continue
+		}
+		entry, ok := byID[c.userID]
+		if !ok {
+			entry = &messages.RosterEntry{UserID: c.userID, Role: string(s.rosterRole(r, c)), Idle: true}
+			byID[c.userID] = entry
+		}
+		if entry.UserName == "" {
+			entry.UserName = c.userName
+		}
+		if entry.Color == "" {
+			entry.Color = c.color
+		}
+		// Idle only if idle everywhere
+		entry.Idle = entry.Idle && (c.idle || c.silent)
+	}
+
+	roster := make([]messages.RosterEntry, 0, len(byID))
+	for _, entry := range byID {
+		roster = append(roster, *entry)
+	}
+	sort.Slice(roster, func(i, j int) bool {
+		return roster[i].UserID < roster[j].UserID
+	})
+	return roster
+}
+
+// rosterRole is the role a client's user has in a room: the one they
+// authenticated with, or that of an editor, either way a viewer's if their
+// write access was revoked or they are spectating. The caller must hold
+// s.mutex.
+func (s *Server) rosterRole(r *room, c *client) users.Role {
+	if c.spectator {
+		return users.RoleViewer
+	}
+	role := users.RoleEditor
+	if c.user != nil {
+		role = c.user.Role
+	}
+	if role == users.RoleEditor && r.state.Role(c.userID) == messages.RoleReadOnly {
+		role = users.RoleViewer
+	}
+	return role
+}
+
+// updateRoster sends a room's roster to its clients if it changed since last
+// sent, reporting whether it did
+func (s *Server) updateRoster(r *room) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	roster := s.roster(r)
+	if slices.Equal(roster, r.roster) {
+		return false
+	}
+	r.roster = roster
+	r.state.BroadcastRoster(roster)
+	return true
+}
+
+// sendRoster sends a client joining a room its roster, with them in it
+func (s *Server) sendRoster(r *room, conn messages.Transport) {
+	if s.updateRoster(r) {
+		return
+	}
+	s.mutex.Lock()
+	roster := r.roster
+	s.mutex.Unlock()
+	if err := r.state.SendRoster(conn, roster); err != nil {
+		s.recordError(fmt.Errorf("%s: sending roster: %w", r.describe(conn), err))
+	}
+}
+
+// dropClient forgets a client whose connection to a room was dropped, telling
+// the rest of the room it left
+func (s *Server) dropClient(r *room, conn messages.Transport) {
+	s.mutex.Lock()
+	c, ok := s.clients[conn]
+	ok = ok && c.room == r
+	if ok {
+		delete(s.clients, conn)
+		s.placeFreed()
+		s.logger.Info("Client left", "room", r.name, "addr", c.addr, "user", c.userName)
+		s.roomEmptied(r)
+	}
+	s.mutex.Unlock()
+	s.updateRoster(r)
+	if ok {
+		s.fire(r, webhook.EventLeft, c.userID, c.userName)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"slices"
+	"testing"
+
+	"gollaborate/messages"
+	"gollaborate/presence"
+)
+
+// expectRoster waits for a roster listing the given users, skipping the rest
+func expectRoster(t *testing.T, conn *testClient, want ...messages.RosterEntry) {
+	t.Helper()
+
+	for {
+		msg := receiveMessage(t, conn, messages.MessageTypeRoster)
+		if slices.Equal(msg.Roster, want) {
+			return
+		}
+	}
+}
+
+func TestServerRoster(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	alice := dialTestClient(t, addr)
+	if err := messages.SendMessage(alice, messages.NewHelloMessage(1, "alice", "#ff0000")); err != nil {
+		t.Fatalf("Failed to say hello: %v", err)
+	}
+	aliceEntry := messages.RosterEntry{UserID: 1, UserName: "alice", Color: "#ff0000", Role: "editor"}
+	expectRoster(t, alice, aliceEntry)
+
+	bob := dialTestClient(t, addr)
+	if err := messages.SendMessage(bob, messages.NewHelloMessage(2, "bob", "")); err != nil {
+		t.Fatalf("Failed to say hello: %v", err)
+	}
+	bobEntry := messages.RosterEntry{UserID: 2, UserName: "bob", Role: "editor"}
+	expectRoster(t, alice, aliceEntry, bobEntry)
+	expectRoster(t, bob, aliceEntry, bobEntry)
+
+	// Going idle, and losing write access, show in the roster
+	idle := messages.NewPresenceMessage(messages.PresenceIdle, presence.State{UserID: 2, UserName: "bob"}, 2)
+	if err := messages.SendMessage(bob, idle); err != nil {
+		t.Fatalf("Failed to send presence: %v", err)
+	}
+	bobEntry.Idle = true
+	expectRoster(t, alice, aliceEntry, bobEntry)
+	for _, info := range srv.Stats().Clients {
+		if info.UserID == 2 {
+			if err := srv.SetWriteAccess(info, false); err != nil {
+				t.Fatalf("Failed to revoke write access: %v", err)
+			}
+		}
+	}
+	bobEntry.Role = "viewer"
+	expectRoster(t, alice, aliceEntry, bobEntry)
+
+	_ = bob.Close()
+	expectRoster(t, alice, aliceEntry)
+}
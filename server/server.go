@@ -0,0 +1,635 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"gollaborate/autosave"
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/presence"
+	"gollaborate/pubsub"
+	"gollaborate/shared"
+	"gollaborate/storage"
+	"gollaborate/users"
+	"gollaborate/webhook"
+)
+
+// maxRecentErrors is how many error lines the server keeps for display
+const maxRecentErrors = 50
+
+// Server hosts shared documents and relays messages between the clients that
+// join them. Each document is a room, named by clients as they connect; those
+// naming none join the server's own document, named as the server is.
+type Server struct {
+	state     *shared.EditorState // Of the server's own document, see room.go
+	listeners []messages.Listener // Every listener being served
+	serving   int                 // How many listeners are still accepting clients, see health.go
+	// Serving the gRPC service and WebSockets, see grpc.go and websocket.go
+	httpServers []*http.Server
+	name        string
+	nodeID      int
+	started     time.Time
+
+	mutex       sync.Mutex
+	rooms       map[string]*room
+	defaultRoom *room
+	configure   []func(name string, state *shared.EditorState) // Applied to every room, see ConfigureRooms
+	clients     map[messages.Transport]*client
+	opsTotal    int
+	errors      []string
+	logger      *slog.Logger // Told of clients coming and going, and of errors
+
+	// Idle documents are parked in a snapshot file to free memory
+	parkIdle time.Duration
+	parkPath string
+	parked   bool
+
+	// Caps on the document's history and tombstones, kept across parking
+	limits crdt.Limits
+
+	// Where documents are kept between runs, if anywhere; see store.go
+	store storage.Store
+	// When documents are saved to it while edited; see autosave.go
+	autosave autosave.Policy
+	// Files new documents may start from, besides the built-in templates; see template.go
+	templateDir string
+	// Which clients are admitted and the name each user ID was admitted
+	// with, see auth.go, and the join codes admitting them to one room each,
+	// see joincode.go
+	auth      Auth
+	userNames map[int]string
+	joinCodes map[string]joinCode
+	// The role of each authenticated connection's user, and the user ID each
+	// connection goes by, see roles.go. Checked with editor states locked, so
+	// accessMutex is never held while calling into them.
+	accessMutex sync.Mutex
+	access      map[messages.Transport]users.Role
+	identities  map[messages.Transport]identity
+
+	// Per-user quotas and usage, see quota.go. Checked with the editor state
+	// locked, so quotaMutex is never held while calling into it.
+	quotaMutex sync.Mutex
+	quotas     Quotas
+	usage      map[int]*userUsage
+
+	// Sessions clients may resume after reconnecting, by ID; see session.go
+	sessions     map[string]*session
+	sessionGrace time.Duration
+	// How often rooms send their clients digests of the document; see room.go
+	digestInterval time.Duration
+
+	// How many clients may join, and how many wait for a place; see capacity.go
+	capacity Capacity
+	waiting  int
+	freed    chan struct{} // Closed when a client leaves, nil until waited on
+
+	// Told when documents are saved or go idle, and clients join or leave
+	// them, if set, and how long documents go without edits to be idle; see
+	// webhook.go
+	webhooks    *webhook.Dispatcher
+	webhookIdle time.Duration
+
+	// Clients doing nothing for so long are marked idle, or disconnected;
+	// see idle.go
+	idleAfter  time.Duration
+	evictAfter time.Duration
+
+	// Other servers hosting the same documents are reached through the
+	// broker, on the topic, and tell this one by its instance ID; see cluster.go
+	broker   pubsub.Broker
+	topic    string
+	instance string
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// client tracks what the server knows about a single connection
+type client struct {
+	room        *room
+	user        *users.User // Who the client authenticated as, if the server asked
+	addr        string
+	userID      int
+	userName    string
+	color       string
+	idle        bool // Whether the client said its user is idle
+	ops         int
+	connectedAt time.Time
+	// When the client last did something, whether the server marked it idle
+	// for doing nothing since, and where its cursor was; see idle.go
+	lastActive time.Time
+	silent     bool
+	cursor     string
+	// Whether the client joined as a spectator, whose edits are refused
+	// whatever its user's role
+	spectator bool
+}
+
+// ClientInfo is a snapshot of a connected client
+type ClientInfo struct {
+	Room        string
+	Addr        string
+	UserID      int
+	UserName    string
+	Ops         int
+	ConnectedAt time.Time
+	ReadOnly    bool        // Whether the client's user had write access revoked
+	Spectator   bool        // Whether the client joined as a spectator
+	User        *users.User // Who the client authenticated as, if the server asked
+	// What the client's user has contributed and had refused, over all their connections
+	Bytes     int
+	Throttled int
+
+	conn  messages.Transport
+	state *shared.EditorState // Of the client's room
+}
+
+// Stats is a snapshot of the server's state for dashboards
+type Stats struct {
+	Name       string
+	Uptime     time.Duration
+	Clients    []ClientInfo
+	OpsTotal   int
+	Errors     []string
+	Locked     bool
+	Frozen     bool
+	Characters int
+	Language   string
+	Parked     bool
+	Quotas     Quotas
+	Rooms      []messages.RoomInfo
+}
+
+// New creates a server hosting the given document. The name is shown to
+// administrators, and names the room clients join if they name none. The server
+// keeps a history of edits to every document for RestoreTo.
+func New(doc *crdt.Document, nodeID int, name string) *Server {
+	s := &Server{
+		name:    name,
+		nodeID:  nodeID,
+		started: time.Now(),
+		rooms:   make(map[string]*room),
+		clients: make(map[messages.Transport]*client),
+		access:  make(map[messages.Transport]users.Role),
+		usage:   make(map[int]*userUsage),
+		done:    make(chan struct{}),
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+
+		identities:     make(map[messages.Transport]identity),
+		userNames:      make(map[int]string),
+		joinCodes:      make(map[string]joinCode),
+		sessions:       make(map[string]*session),
+		digestInterval: shared.DefaultDigestInterval,
+	}
+	s.defaultRoom = s.newRoom(name, doc, nil)
+	s.defaultRoom.main = true
+	s.rooms[name] = s.defaultRoom
+	s.state = s.defaultRoom.state
+	go s.expirePresence()
+	go s.flushHistory()
+	go s.expireSessions(sessionCheckInterval)
+	go s.watchActivity()
+	go s.watchIdleDocuments()
+	return s
+}
+
+// SetLimits caps the history and tombstones the hosted documents keep, so a
+// document edited for days does not grow without bound. Call it before Serve.
+func (s *Server) SetLimits(limits crdt.Limits) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.limits = limits
+	for _, r := range s.rooms {
+		if doc := r.state.Document(); doc != nil {
+			doc.SetLimits(limits)
+		}
+	}
+}
+
+// SetLogger logs clients joining and leaving rooms, and the errors the admin
+// dashboard shows, to a logger. Servers log nothing until given one. Call it
+// before Serve.
+func (s *Server) SetLogger(logger *slog.Logger) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.logger = logger
+}
+
+// State returns the editor state backing the server's own document
+func (s *Server) State() *shared.EditorState {
+	return s.state
+}
+
+// Serve accepts connections on the listener until it is closed. Serve several
+// listeners at once to accept clients on each, such as over TCP and WebSockets.
+func (s *Server) Serve(listener messages.Listener) error {
+	s.mutex.Lock()
+	select {
+	case <-s.done:
+		s.mutex.Unlock()
+		_ = listener.Close()
+		return nil
+	default:
+	}
+	s.listeners = append(s.listeners, listener)
+	s.serving++
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		s.serving--
+		s.mutex.Unlock()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			s.recordError(fmt.Errorf("accept: %w", err))
+			continue
+		}
+		go s.admit(messages.NewConnTransport(conn))
+	}
+}
+
+// Close stops accepting connections, disconnects every client and saves every
+// room's document and its latest edits to the store, if the server has one
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+
+	s.mutex.Lock()
+	listeners := s.listeners
+	httpServers := s.httpServers
+	rooms := s.roomList()
+	s.mutex.Unlock()
+
+	for _, srv := range httpServers {
+		_ = srv.Close()
+	}
+	// Every room is saved below, clients leaving or not
+	s.stopAutosave(rooms)
+	for _, r := range rooms {
+		r.state.DisableHeartbeat()
+		r.state.DisableDigests()
+		for _, conn := range r.state.Connections() {
+			r.state.RemoveConn(conn)
+		}
+	}
+	err := errors.Join(s.appendHistory(rooms), s.saveRooms(rooms))
+	for _, listener := range listeners {
+		err = errors.Join(err, listener.Close())
+	}
+	s.closeWebhooks()
+	return err
+}
+
+// Kick disconnects a client, which cannot resume its session
+func (s *Server) Kick(info ClientInfo) {
+	s.endSessions(map[messages.Transport]bool{info.conn: true})
+	s.clientState(info).RemoveConn(info.conn)
+}
+
+// RestoreTo returns the document to how it was at the given time, sending the
+// change to every client, and returns the number of characters changed. Parking
+// restarts the history, so times before the document was last parked cannot be restored.
+func (s *Server) RestoreTo(t time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.unpark(); err != nil {
+		return 0, fmt.Errorf("reloading parked document: %w", err)
+	}
+	return s.state.RestoreTo(t)
+}
+
+// SetWriteAccess grants a client's user write access, or revokes it, telling
+// every client. Edits from users without it are refused.
+func (s *Server) SetWriteAccess(info ClientInfo, canWrite bool) error {
+	if info.UserID == 0 {
+		return fmt.Errorf("%s has not said who it is yet", info.Addr)
+	}
+	if err := s.clientState(info).SetPermission(info.UserID, canWrite); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	c, ok := s.clients[info.conn]
+	s.mutex.Unlock()
+	if ok {
+		s.updateRoster(c.room)
+	}
+	return nil
+}
+
+// clientState returns the editor state of a client's room
+func (s *Server) clientState(info ClientInfo) *shared.EditorState {
+	if info.state == nil {
+		return s.state
+	}
+	return info.state
+}
+
+// SetLocked controls whether clients may edit the server's own document
+func (s *Server) SetLocked(locked bool) {
+	s.state.SetReadOnly(locked)
+}
+
+// SetFrozen freezes the server's own document as final, refusing every
+// further edit with an error coded messages.ErrorCodeFrozen, or thaws it
+func (s *Server) SetFrozen(frozen bool) {
+	s.state.SetFrozen(frozen)
+}
+
+// Stats returns a snapshot of the server's clients, counters and recent
+// errors. The document's own figures are of the server's own document.
+func (s *Server) Stats() Stats {
+	doc := s.state.Document()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Forget clients whose connection has gone away
+	live := make(map[messages.Transport]bool)
+	for _, r := range s.rooms {
+		for _, conn := range r.state.Connections() {
+			live[conn] = true
+		}
+	}
+	for conn := range s.clients {
+		if !live[conn] {
+			delete(s.clients, conn)
+		}
+	}
+	s.forgetAccess(live)
+
+	clients := make([]ClientInfo, 0, len(s.clients))
+	for conn, c := range s.clients {
+		var user *users.User
+		if c.user != nil {
+			copied := *c.user
+			user = &copied
+		}
+		clients = append(clients, ClientInfo{
+			Room:        c.room.name,
+			Addr:        c.addr,
+			UserID:      c.userID,
+			UserName:    c.userName,
+			Ops:         c.ops,
+			ConnectedAt: c.connectedAt,
+			ReadOnly:    c.room.state.Role(c.userID) == messages.RoleReadOnly || (c.user != nil && !c.user.Role.CanEdit()),
+			Spectator:   c.spectator,
+			User:        user,
+			conn:        conn,
+			state:       c.room.state,
+		})
+	}
+	// Editor states hold their lock while checking quotas, so take quotaMutex
+	// only once done with them
+	s.quotaMutex.Lock()
+	quotas := s.quotas
+	for i := range clients {
+		if u, ok := s.usage[clients[i].UserID]; ok {
+			clients[i].Bytes, clients[i].Throttled = u.Bytes, u.Throttled
+		}
+	}
+	s.quotaMutex.Unlock()
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ConnectedAt.Before(clients[j].ConnectedAt)
+	})
+
+	characters := 0
+	lang := ""
+	if doc != nil {
+		lang = doc.Metadata.Language
+		for _, line := range doc.Lines {
+			characters += len(line.Characters)
+		}
+	}
+
+	errorsCopy := make([]string, len(s.errors))
+	copy(errorsCopy, s.errors)
+
+	return Stats{
+		Name:       s.name,
+		Uptime:     time.Since(s.started),
+		Clients:    clients,
+		OpsTotal:   s.opsTotal,
+		Errors:     errorsCopy,
+		Locked:     s.state.ReadOnly(),
+		Frozen:     s.state.Frozen(),
+		Characters: characters,
+		Language:   lang,
+		Parked:     s.parked,
+		Quotas:     quotas,
+		Rooms:      s.roomInfo(),
+	}
+}
+
+// addClient registers a new connection in a room and sends it the room's
+// document, or what it missed if it resumed a session. The user is who the
+// client authenticated as, if anyone; a spectator's edits are refused whatever
+// its role. It returns ErrServerFull, and does nothing, if there is no place
+// for the client; see capacity.go.
+func (s *Server) addClient(r *room, conn messages.Transport, user *users.User, resumed *session, spectate bool) error {
+	s.mutex.Lock()
+	if err := s.checkCapacity(r); err != nil {
+		s.mutex.Unlock()
+		return err
+	}
+	if r.main {
+		if err := s.unpark(); err != nil {
+			s.mutex.Unlock()
+			s.recordError(fmt.Errorf("%s: reloading parked document: %w", remoteAddr(conn), err))
+			_ = conn.Send(messages.NewErrorMessage("document is unavailable", s.nodeID))
+			_ = conn.Close()
+			return nil
+		}
+	}
+	c := &client{
+		room:        r,
+		user:        user,
+		addr:        remoteAddr(conn),
+		connectedAt: time.Now(),
+		spectator:   spectate,
+	}
+	c.lastActive = c.connectedAt
+	if user != nil {
+		c.userID, c.userName = user.ID, user.Name
+	}
+	if resumed != nil {
+		c.userID, c.userName, c.color = resumed.client.userID, resumed.client.userName, resumed.client.color
+		c.spectator = c.spectator || resumed.client.spectator
+	}
+	if c.userID != 0 {
+		s.claimIdentity(conn, c.userID)
+		s.nameIdentity(conn, c.userName)
+	}
+	s.clients[conn] = c
+	sessionID := s.startSession(r, conn, c, resumed)
+	spectator := c.spectator
+	s.logger.Info("Client joined", "room", r.name, "addr", c.addr, "user", c.userName, "resumed", resumed != nil, "spectator", spectator)
+	s.mutex.Unlock()
+
+	if spectator {
+		// Only this connection watches: the user's others may still edit
+		s.setAccess(conn, users.RoleViewer)
+	} else if user != nil {
+		s.setAccess(conn, user.Role)
+	}
+	// The server refuses viewers' edits by connection, whatever IDs they carry
+	viewer := user != nil && !user.Role.CanEdit() && user.ID != 0 && !spectator
+	r.state.AddTransport(conn)
+	s.fire(r, webhook.EventJoined, c.userID, c.userName)
+	// Syncing may wait for the client's hello
+	go func() {
+		var err error
+		if resumed != nil {
+			_, err = r.state.ResumePeer(conn, resumed.clocks)
+		} else {
+			_, err = r.state.SyncPeer(conn)
+		}
+		if err != nil {
+			s.recordError(fmt.Errorf("%s: sending document sync: %w", r.describe(conn), err))
+		}
+		if err := r.state.SendRoles(conn); err != nil {
+			s.recordError(fmt.Errorf("%s: sending roles: %w", r.describe(conn), err))
+		}
+		if viewer {
+			// Their editor stops taking edits; no one else's is told
+			if err := r.state.SendTo(conn, messages.NewPermissionMessage(user.ID, messages.RoleReadOnly, s.nodeID)); err != nil {
+				s.recordError(fmt.Errorf("%s: making viewer read-only: %w", r.describe(conn), err))
+			}
+		}
+		if err := r.state.SendDocMeta(conn); err != nil {
+			s.recordError(fmt.Errorf("%s: sending document description: %w", r.describe(conn), err))
+		}
+		if err := r.state.SendPresence(conn); err != nil {
+			s.recordError(fmt.Errorf("%s: sending presence: %w", r.describe(conn), err))
+		}
+		s.sendRoster(r, conn)
+		if sessionID != "" {
+			if err := r.state.SendTo(conn, messages.NewSessionMessage(sessionID, s.nodeID)); err != nil {
+				s.recordError(fmt.Errorf("%s: sending session: %w", r.describe(conn), err))
+			}
+		}
+	}()
+	return nil
+}
+
+// observe updates per-client information from a message received in a room
+func (s *Server) observe(r *room, conn messages.Transport, msg *messages.Message) {
+	if s.answerRoomMessage(r, conn, msg) {
+		return
+	}
+
+	changed, woke := s.observeClient(conn, msg)
+	if woke != nil {
+		s.wake(r, *woke)
+	}
+	if changed {
+		s.updateRoster(r)
+	}
+}
+
+// observeClient updates what the server knows about a client from a message
+// it sent, reporting whether anything the room's roster shows changed, and
+// who is back if the server had marked the client idle
+func (s *Server) observeClient(conn messages.Transport, msg *messages.Message) (bool, *presence.State) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c, ok := s.clients[conn]
+	if !ok {
+		return false, nil
+	}
+	before := *c
+	// Later messages cannot change who the client is, so none passes for
+	// another user mid-session. Its hello was handled before any message
+	// after it, while these are observed in no particular order.
+	claimed := msg.UserID
+	if peer, ok := c.room.state.Peer(conn); ok && peer.UserID != 0 {
+		claimed = peer.UserID
+	}
+	c.userID = s.claimIdentity(conn, claimed).userID
+	var woke *presence.State
+	if c.isActivity(msg) {
+		c.lastActive = time.Now()
+		if c.silent {
+			c.silent = false
+			woke = &presence.State{UserID: c.userID, UserName: c.userName, Color: c.color}
+		}
+	}
+	switch msg.Type {
+	case messages.MessageTypeOperation:
+		c.ops++
+		s.opsTotal++
+	case messages.MessageTypeTransaction, messages.MessageTypeBatch:
+		c.ops += len(msg.Operations)
+		s.opsTotal += len(msg.Operations)
+	case messages.MessageTypeHello, messages.MessageTypeUserInfo:
+		// Authenticated clients go by the name they authenticated with
+		if msg.UserName != "" && c.user == nil {
+			c.userName = msg.UserName
+		}
+		if msg.Color != "" {
+			c.color = msg.Color
+		}
+	case messages.MessageTypeAwareness:
+		for _, state := range msg.Presence {
+			if state.UserID == c.userID && state.UserName != "" && c.user == nil {
+				c.userName = state.UserName
+			}
+			if state.UserID == c.userID && state.Color != "" {
+				c.color = state.Color
+			}
+		}
+	case messages.MessageTypePresence:
+		if len(msg.Presence) > 0 && msg.Presence[0].UserID == c.userID {
+			switch msg.Event {
+			case messages.PresenceIdle:
+				c.idle = true
+			case messages.PresenceActive:
+				c.idle = false
+			}
+		}
+	case messages.MessageTypeRoles, messages.MessageTypePermission:
+		// Write access changed, for this client's user or another's
+		return true, woke
+	}
+	if c.userName != before.userName {
+		s.nameIdentity(conn, c.userName)
+	}
+	changed := c.userID != before.userID || c.userName != before.userName || c.color != before.color ||
+		c.idle != before.idle || c.silent != before.silent
+	return changed, woke
+}
+
+// recordError keeps an error for display, dropping the oldest beyond the limit
+func (s *Server) recordError(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.logger.Warn("Error", "err", err)
+	line := fmt.Sprintf("%s %v", time.Now().Format("15:04:05"), err)
+	s.errors = append(s.errors, line)
+	if len(s.errors) > maxRecentErrors {
+		s.errors = s.errors[len(s.errors)-maxRecentErrors:]
+	}
+}
+
+// remoteAddr returns a printable address for a connection
+func remoteAddr(conn messages.Transport) string {
+	if conn == nil {
+		return "unknown"
+	}
+	return conn.RemoteID()
+}
@@ -0,0 +1,509 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/presence"
+)
+
+// startTestServer starts a server on a random local port
+func startTestServer(t *testing.T, text string) (*Server, string) {
+	t.Helper()
+
+	srv := New(crdt.FromText(text, 100), 100, "test")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	return srv, listener.Addr().String()
+}
+
+// testClient is a connection to the server and the one reader reading it
+type testClient struct {
+	net.Conn
+	*messages.Reader
+}
+
+// dialTestClient connects to the server and consumes the initial sync
+func dialTestClient(t *testing.T, addr string) *testClient {
+	t.Helper()
+	return dialRoomClient(t, addr, "")
+}
+
+// dialRoomClient connects to the server, joins the named room unless it is
+// empty, and consumes the initial sync
+func dialRoomClient(t *testing.T, addr string, room string) *testClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	if room != "" {
+		if err := messages.SendMessage(conn, messages.NewJoinRoomMessage(room, 1)); err != nil {
+			t.Fatalf("Failed to join room %s: %v", room, err)
+		}
+	}
+
+	// The sync and the description after it may arrive together
+	reader := messages.NewReader(conn)
+	msg, err := reader.Receive()
+	if err != nil {
+		t.Fatalf("Failed to receive initial sync: %v", err)
+	}
+	if msg.Type != messages.MessageTypeSync {
+		t.Fatalf("Expected initial sync message, got %s", msg.Type)
+	}
+	msg, err = reader.Receive()
+	if err != nil || msg.Type != messages.MessageTypeDocMeta {
+		t.Fatalf("Expected the document description after the sync, got %+v (%v)", msg, err)
+	}
+	return &testClient{Conn: conn, Reader: reader}
+}
+
+// waitForClients waits until the server reports the expected number of clients
+func waitForClients(t *testing.T, srv *Server, count int) Stats {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		stats := srv.Stats()
+		if len(stats.Clients) == count {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d clients, got %d", count, len(stats.Clients))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerRelaysOperations(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	alice := dialTestClient(t, addr)
+	bob := dialTestClient(t, addr)
+	waitForClients(t, srv, 2)
+
+	op := messages.NewInsertOperation([]crdt.Identifier{{Digit: 5, Node: 1}}, 'A', 1, 2)
+	if err := messages.SendOperation(alice, op); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+
+	_ = bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msg, err := bob.Receive()
+	if err != nil {
+		t.Fatalf("Expected relayed operation: %v", err)
+	}
+	if msg.Type != messages.MessageTypeOperation || msg.Operation.Character != 'A' {
+		t.Errorf("Expected relayed insert of 'A', got %+v", msg)
+	}
+
+	if text := srv.State().Document().ToText(); text != "A" {
+		t.Errorf("Expected server document 'A', got '%s'", text)
+	}
+
+	stats := srv.Stats()
+	if stats.OpsTotal != 1 {
+		t.Errorf("Expected 1 operation counted, got %d", stats.OpsTotal)
+	}
+}
+
+func TestServerOverMemoryNetwork(t *testing.T) {
+	srv := New(crdt.FromText("in memory", 100), 100, "test")
+	network := messages.NewMemoryNetwork()
+	listener, err := network.Listen("room")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	conn, err := network.Dial("room")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	msg, err := messages.NewReader(conn).Receive()
+	if err != nil {
+		t.Fatalf("Failed to receive initial sync: %v", err)
+	}
+	if msg.Type != messages.MessageTypeSync || msg.Document.ToText() != "in memory" {
+		t.Errorf("Expected the document, got %+v", msg)
+	}
+	waitForClients(t, srv, 1)
+}
+
+func TestServerLockRejectsOperations(t *testing.T) {
+	srv, addr := startTestServer(t, "Hi")
+	alice := dialTestClient(t, addr)
+	waitForClients(t, srv, 1)
+
+	srv.SetLocked(true)
+	if !srv.Stats().Locked {
+		t.Fatal("Expected server to report locked")
+	}
+
+	op := messages.NewInsertOperation([]crdt.Identifier{{Digit: 50, Node: 1}}, '!', 1, 2)
+	if err := messages.SendOperation(alice, op); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+
+	// Clients are told the document is locked, and edits are refused
+	_ = alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msg, err := alice.Receive()
+	if err != nil || msg.Type != messages.MessageTypeDocMeta || !msg.DocMeta.ReadOnly {
+		t.Fatalf("Expected the document described as read-only, got %+v (%v)", msg, err)
+	}
+	msg, err = alice.Receive()
+	if err != nil {
+		t.Fatalf("Expected error reply: %v", err)
+	}
+	if msg.Type != messages.MessageTypeError {
+		t.Errorf("Expected error message, got %s", msg.Type)
+	}
+	if text := srv.State().Document().ToText(); text != "Hi" {
+		t.Errorf("Expected locked document to stay 'Hi', got '%s'", text)
+	}
+}
+
+func TestServerFreezeRejectsOperations(t *testing.T) {
+	srv, addr := startTestServer(t, "Hi")
+	alice := dialTestClient(t, addr)
+	waitForClients(t, srv, 1)
+
+	srv.SetFrozen(true)
+	if !srv.Stats().Frozen {
+		t.Fatal("Expected the server to report the document frozen")
+	}
+	if msg := receiveMessage(t, alice, messages.MessageTypeMetadata); msg.Metadata == nil || !msg.Metadata.Frozen {
+		t.Fatalf("Expected the document announced as frozen, got %+v", msg)
+	}
+
+	// Edits are refused with a typed error until the document is unfrozen
+	op := messages.NewInsertOperation([]crdt.Identifier{{Digit: 50, Node: 1}}, '!', 1, 2)
+	if err := messages.SendOperation(alice, op); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	if msg := receiveMessage(t, alice, messages.MessageTypeError); msg.Code != messages.ErrorCodeFrozen {
+		t.Errorf("Expected the edit refused as frozen, got %+v", msg)
+	}
+	if err := messages.SendMessage(alice, messages.NewSyncMessage(crdt.FromText("pwned", 1), 1)); err != nil {
+		t.Fatalf("Failed to send sync: %v", err)
+	}
+	if msg := receiveMessage(t, alice, messages.MessageTypeError); msg.Code != messages.ErrorCodeFrozen {
+		t.Errorf("Expected the sync refused as frozen, got %+v", msg)
+	}
+	if text := srv.State().Document().ToText(); text != "Hi" {
+		t.Errorf("Expected the frozen document to stay 'Hi', got '%s'", text)
+	}
+
+	srv.SetFrozen(false)
+	if msg := receiveMessage(t, alice, messages.MessageTypeMetadata); msg.Metadata == nil || msg.Metadata.Frozen {
+		t.Fatalf("Expected the document announced as unfrozen, got %+v", msg)
+	}
+	op = messages.NewInsertOperation([]crdt.Identifier{{Digit: 50, Node: 1}}, '!', 1, 3)
+	if err := messages.SendOperation(alice, op); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	waitForHistory(t, srv, "", "Hi!")
+}
+
+func TestServerEnforcesPermissions(t *testing.T) {
+	srv, addr := startTestServer(t, "Hi")
+	alice := dialTestClient(t, addr)
+	bob := dialTestClient(t, addr)
+	_ = bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+	receive := func(want messages.MessageType) *messages.Message {
+		t.Helper()
+		for {
+			msg, err := bob.Receive()
+			if err != nil {
+				t.Fatalf("Expected a %s message: %v", want, err)
+			}
+			if msg.Type == want {
+				return msg
+			}
+		}
+	}
+	_ = messages.SendMessage(alice, messages.NewHelloMessage(1, "Alice", ""))
+	_ = messages.SendMessage(bob, messages.NewHelloMessage(2, "Bob", ""))
+
+	// A host demotes a collaborator mid-session, and the server enforces it
+	if err := messages.SendMessage(alice, messages.NewPermissionMessage(2, messages.RoleReadOnly, 1)); err != nil {
+		t.Fatalf("Failed to send permission: %v", err)
+	}
+	if msg := receive(messages.MessageTypePermission); msg.Roles[2] != messages.RoleReadOnly {
+		t.Errorf("Expected Bob told he is read-only, got %+v", msg.Roles)
+	}
+	edit := func() {
+		t.Helper()
+		op := messages.NewInsertOperation([]crdt.Identifier{{Digit: 50, Node: 2}}, '!', 2, 2)
+		if err := messages.SendOperation(bob, op); err != nil {
+			t.Fatalf("Failed to send operation: %v", err)
+		}
+		receive(messages.MessageTypeError)
+	}
+	edit()
+
+	// Nor can he grant himself access again
+	_ = messages.SendMessage(bob, messages.NewPermissionMessage(2, messages.RoleEditor, 2))
+	edit()
+	if text := srv.State().Document().ToText(); text != "Hi" {
+		t.Errorf("Expected the document to stay 'Hi', got %q", text)
+	}
+	info := clientNamed(srv.Stats(), "Bob")
+	if info == nil || !info.ReadOnly {
+		t.Fatalf("Expected Bob to be listed as read-only, got %+v", info)
+	}
+
+	if err := srv.SetWriteAccess(*info, true); err != nil {
+		t.Fatalf("SetWriteAccess: %v", err)
+	}
+	if msg := receive(messages.MessageTypePermission); msg.Roles[2] != messages.RoleEditor {
+		t.Errorf("Expected Bob told he may edit, got %+v", msg.Roles)
+	}
+	if srv.State().Role(2) != messages.RoleEditor {
+		t.Errorf("Expected Bob to be an editor again")
+	}
+}
+
+func TestServerKick(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	alice := dialTestClient(t, addr)
+	stats := waitForClients(t, srv, 1)
+
+	srv.Kick(stats.Clients[0])
+	waitForClients(t, srv, 0)
+
+	_ = alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := alice.Receive(); err == nil {
+		t.Error("Expected kicked client's connection to be closed")
+	}
+}
+
+func TestServerRestoreTo(t *testing.T) {
+	srv, addr := startTestServer(t, "hi")
+	alice := dialTestClient(t, addr)
+	waitForClients(t, srv, 1)
+
+	before := time.Now()
+	doc, _ := srv.Document()
+	pos, _ := doc.GeneratePositionAt(1, 3, 1)
+	if err := messages.SendOperation(alice, messages.NewInsertOperation(pos, '!', 1, 2)); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.Stats().OpsTotal < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the edit to be applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	changed, err := srv.RestoreTo(before)
+	if err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	if changed != 1 {
+		t.Errorf("Expected 1 character changed, got %d", changed)
+	}
+	if doc, _ := srv.Document(); doc.ToText() != "hi" {
+		t.Errorf("Expected 'hi' after restore, got %q", doc.ToText())
+	}
+
+	_ = alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msg, err := alice.Receive()
+	if err != nil {
+		t.Fatalf("Expected restore transaction: %v", err)
+	}
+	if msg.Type != messages.MessageTypeTransaction || msg.Action != messages.TransactionActionRestore {
+		t.Fatalf("Expected restore transaction, got %s %s", msg.Type, msg.Action)
+	}
+	if len(msg.Operations) != 1 || msg.Operations[0].Type != messages.OperationTypeDelete {
+		t.Errorf("Expected a single delete, got %+v", msg.Operations)
+	}
+}
+
+func TestServerRelaysPresence(t *testing.T) {
+	srv, addr := startTestServer(t, "hello")
+	alice := dialTestClient(t, addr)
+	bob := dialTestClient(t, addr)
+	waitForClients(t, srv, 2)
+
+	state := presence.State{UserID: 1, UserName: "Alice", Color: "#00FF00", Clock: 1}
+	if err := messages.SendAwareness(alice, []presence.State{state}, 1); err != nil {
+		t.Fatalf("Failed to send presence: %v", err)
+	}
+
+	_ = bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msg, err := bob.Receive()
+	if err != nil {
+		t.Fatalf("Expected relayed presence: %v", err)
+	}
+	if msg.Type != messages.MessageTypeAwareness || len(msg.Presence) != 1 || msg.Presence[0].UserName != "Alice" {
+		t.Fatalf("Expected Alice's presence, got %+v", msg)
+	}
+	if stats := srv.Stats(); clientNamed(stats, "Alice") == nil {
+		t.Errorf("Expected the server to learn Alice's name, got %+v", stats.Clients)
+	}
+
+	// A client joining later is told who is already here, right after the sync
+	carol, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer carol.Close()
+	_ = carol.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader := messages.NewReader(carol)
+	if msg, err = reader.Receive(); err != nil || msg.Type != messages.MessageTypeSync {
+		t.Fatalf("Expected initial sync, got %+v (%v)", msg, err)
+	}
+	if msg, err = reader.Receive(); err != nil || msg.Type != messages.MessageTypeDocMeta || msg.DocMeta.Title != "test" {
+		t.Fatalf("Expected the document description, got %+v (%v)", msg, err)
+	}
+	msg, err = reader.Receive()
+	if err != nil {
+		t.Fatalf("Expected presence after the sync: %v", err)
+	}
+	if msg.Type != messages.MessageTypeAwareness || len(msg.Presence) != 1 || msg.Presence[0].UserID != 1 {
+		t.Fatalf("Expected Alice's presence, got %+v", msg)
+	}
+
+	// Alice's presence goes when her connection does
+	_ = alice.Close()
+	msg, err = bob.Receive()
+	if err != nil {
+		t.Fatalf("Expected Alice to be taken offline: %v", err)
+	}
+	if msg.Type != messages.MessageTypeAwareness || len(msg.Presence) != 1 || !msg.Presence[0].Offline {
+		t.Errorf("Expected Alice's presence to go offline, got %+v", msg)
+	}
+}
+
+func TestServerAnswersHello(t *testing.T) {
+	srv, addr := startTestServer(t, "hello")
+	alice := dialTestClient(t, addr)
+
+	if err := messages.SendMessage(alice, messages.NewHelloMessage(1, "Alice", "32")); err != nil {
+		t.Fatalf("Failed to say hello: %v", err)
+	}
+	_ = alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		msg, err := alice.Receive()
+		if err != nil {
+			t.Fatalf("Expected the server's hello: %v", err)
+		}
+		if msg.Type == messages.MessageTypeHello {
+			if msg.UserID != 100 || msg.Version != messages.ProtocolVersion {
+				t.Errorf("Expected the server's hello, got %+v", msg)
+			}
+			break
+		}
+	}
+	if stats := waitForClients(t, srv, 1); clientNamed(stats, "Alice") == nil {
+		t.Errorf("Expected the server to learn Alice's name, got %+v", stats.Clients)
+	}
+}
+
+func TestServerAnnouncesPresenceEvents(t *testing.T) {
+	srv, addr := startTestServer(t, "hello")
+	bob := dialTestClient(t, addr)
+	alice := dialTestClient(t, addr)
+	waitForClients(t, srv, 2)
+
+	_ = bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+	nextEvent := func() *messages.Message {
+		t.Helper()
+		for {
+			msg, err := bob.Receive()
+			if err != nil {
+				t.Fatalf("Expected a presence event: %v", err)
+			}
+			if msg.Type == messages.MessageTypePresence {
+				return msg
+			}
+		}
+	}
+
+	// The server announces Alice when she says hello, and passes on her own events
+	if err := messages.SendMessage(alice, messages.NewHelloMessage(1, "Alice", "32")); err != nil {
+		t.Fatalf("Failed to say hello: %v", err)
+	}
+	if msg := nextEvent(); msg.Event != messages.PresenceJoin || msg.Presence[0].UserName != "Alice" || msg.UserID != 100 {
+		t.Errorf("Expected the server to announce Alice joining, got %+v", msg)
+	}
+	idle := messages.NewPresenceMessage(messages.PresenceIdle, presence.State{UserID: 1, UserName: "Alice"}, 1)
+	if err := messages.SendMessage(alice, idle); err != nil {
+		t.Fatalf("Failed to go idle: %v", err)
+	}
+	if msg := nextEvent(); msg.Event != messages.PresenceIdle || msg.Presence[0].UserID != 1 {
+		t.Errorf("Expected Alice to go idle, got %+v", msg)
+	}
+	if !srv.State().IsIdle(1) {
+		t.Error("Expected the server to know Alice is idle")
+	}
+
+	// Leaving without a word is announced too
+	_ = alice.Close()
+	if msg := nextEvent(); msg.Event != messages.PresenceLeave || msg.Presence[0].UserID != 1 {
+		t.Errorf("Expected the server to announce Alice leaving, got %+v", msg)
+	}
+}
+
+// clientNamed returns the client with the given user name, or nil
+func clientNamed(stats Stats, name string) *ClientInfo {
+	for i := range stats.Clients {
+		if stats.Clients[i].UserName == name {
+			return &stats.Clients[i]
+		}
+	}
+	return nil
+}
+
+// recordWriter passes each log record written to it on to a channel
+type recordWriter chan string
+
+func (w recordWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestServerLogger(t *testing.T) {
+	srv, addr := startTestServer(t, "")
+	records := make(recordWriter, 16)
+	srv.SetLogger(slog.New(slog.NewJSONHandler(records, nil)))
+	// Errors from the connection closing may be logged as well
+	expectRecord := func(want string) {
+		t.Helper()
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case record := <-records:
+				if strings.Contains(record, want) {
+					if !strings.Contains(record, `"room":"test"`) {
+						t.Errorf("Expected %q logged with the room, got %s", want, record)
+					}
+					return
+				}
+			case <-timeout:
+				t.Fatalf("Timed out waiting for %q", want)
+			}
+		}
+	}
+
+	alice := dialTestClient(t, addr)
+	expectRecord(`"msg":"Client joined"`)
+	_ = alice.Close()
+	expectRecord(`"msg":"Client left"`)
+}
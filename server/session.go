@@ -0,0 +1,144 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"gollaborate/messages"
+)
+
+// DefaultSessionGrace is a reasonable time for SetSessionGrace: long enough
+// for a laptop to change networks or a server to restart behind a proxy
+const DefaultSessionGrace = 2 * time.Minute
+
+// ErrSessionExpired is returned to a client resuming a session the server no
+// longer has
+var ErrSessionExpired = errors.New("session expired")
+
+// sessionCheckInterval is how often sessions are checked for lost connections
+var sessionCheckInterval = time.Second
+
+// session is what the server keeps about a client between its connections, so
+// that after reconnecting it is still the same user, with the same role, and
+// is sent only what it missed
+type session struct {
+	id     string
+	room   *room
+	client *client            // What the server knew of the client on its latest connection
+	conn   messages.Transport // The client's latest connection, nil while it resumes
+	left   time.Time          // When the connection was found gone, zero until then
+	clocks map[int]int        // What the client had seen when it resumed
+}
+
+// SetSessionGrace gives every client a session, which it may resume for this
+// long after losing its connection. Zero, as servers start, gives none. Call
+// it before Serve.
+func (s *Server) SetSessionGrace(grace time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sessionGrace = grace
+}
+
+// newSessionID returns a new, unguessable session ID. The ID is all a client
+// needs to resume a session, credentials included.
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// resumeSession takes up the session a client presents after reconnecting,
+// returning nil if it is unknown or expired. A connection the session still has, such as one that went
+// silent without closing, is dropped. A session whose client fails to join
+// again expires in time, like one never resumed.
+func (s *Server) resumeSession(msg *messages.Message) *session {
+	s.mutex.Lock()
+	sess, ok := s.sessions[msg.Session]
+	if ok && !sess.left.IsZero() && time.Since(sess.left) > s.sessionGrace {
+		delete(s.sessions, msg.Session)
+		ok = false
+	}
+	if !ok {
+		s.mutex.Unlock()
+		return nil
+	}
+	old := sess.conn
+	sess.conn, sess.clocks = nil, msg.Clocks
+	s.mutex.Unlock()
+
+	if old != nil {
+		sess.room.state.RemoveConn(old)
+	}
+	return sess
+}
+
+// startSession gives a client just admitted a session, or hands it the one it
+// resumed, returning its ID. It returns the empty string if the server gives
+// no sessions. The caller must hold s.mutex.
+func (s *Server) startSession(r *room, conn messages.Transport, c *client, resumed *session) string {
+	if resumed != nil {
+		resumed.room, resumed.client, resumed.conn, resumed.left = r, c, conn, time.Time{}
+		return resumed.id
+	}
+	if s.sessionGrace <= 0 {
+		return ""
+	}
+	id, err := newSessionID()
+	if err != nil {
+		go s.recordError(fmt.Errorf("%s: starting session: %w", r.describe(conn), err))
+		return ""
+	}
+	s.sessions[id] = &session{id: id, room: r, client: c, conn: conn}
+	return id
+}
+
+// endSessions ends the sessions of a user's connections, so that those kicked
+// cannot come straight back
+func (s *Server) endSessions(conns map[messages.Transport]bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, sess := range s.sessions {
+		if conns[sess.conn] {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// expireSessions regularly notes which sessions lost their connection, and
+// forgets those not resumed in time, until the server closes
+func (s *Server) expireSessions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.mutex.Lock()
+			live := make(map[messages.Transport]bool)
+			for _, r := range s.rooms {
+				for _, conn := range r.state.Connections() {
+					live[conn] = true
+				}
+			}
+			now := time.Now()
+			for id, sess := range s.sessions {
+				switch {
+				case live[sess.conn]:
+					// Still connected
+				case sess.left.IsZero():
+					sess.left = now
+				case now.Sub(sess.left) > s.sessionGrace:
+					delete(s.sessions, id)
+				}
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
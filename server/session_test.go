@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/users"
+)
+
+// receiveMessage waits for a message of the given type, skipping the rest
+func receiveMessage(t *testing.T, conn *testClient, msgType messages.MessageType) *messages.Message {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		msg, err := conn.Receive()
+		if err != nil {
+			t.Fatalf("Expected a %s message: %v", msgType, err)
+		}
+		if msg.Type == msgType {
+			return msg
+		}
+	}
+}
+
+// dialResume reconnects to the server, resuming a session
+func dialResume(t *testing.T, addr, session string, clocks map[int]int) *testClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	if err := messages.SendMessage(conn, messages.NewResumeMessage(session, clocks, 3)); err != nil {
+		t.Fatalf("Failed to resume: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	return &testClient{Conn: conn, Reader: messages.NewReader(conn)}
+}
+
+func TestServerResumesSession(t *testing.T) {
+	srv, addr := startRolesServer(t, "")
+	srv.SetSessionGrace(time.Minute)
+	vic := dialUserClient(t, addr, "vic-token", 3)
+	session := receiveMessage(t, vic, messages.MessageTypeSession).Session
+	eve := dialUserClient(t, addr, "eve-token", 4)
+
+	insert := func(digit int, char rune, clock int) {
+		t.Helper()
+		op := messages.NewInsertOperation([]crdt.Identifier{{Digit: digit, Node: 4}}, char, 4, clock)
+		if err := messages.SendOperation(eve, op); err != nil {
+			t.Fatalf("Failed to send operation: %v", err)
+		}
+	}
+	insert(5, 'a', 1)
+	receiveMessage(t, vic, messages.MessageTypeOperation)
+	_ = vic.Close()
+	waitForClients(t, srv, 1)
+	insert(6, 'b', 2)
+
+	// Back within the grace window, with no credentials, the viewer is sent
+	// only what they missed
+	vic = dialResume(t, addr, session, map[int]int{4: 1})
+	msg, err := vic.Receive()
+	for err == nil && msg.Type != messages.MessageTypeCatchUp {
+		if msg.Type == messages.MessageTypeSync {
+			t.Fatal("Expected a catch-up rather than the whole document")
+		}
+		msg, err = vic.Receive()
+	}
+	if err != nil || len(msg.Operations) == 0 || msg.Operations[len(msg.Operations)-1].Character != 'b' {
+		t.Fatalf("Expected the missed edit caught up, got %+v (%v)", msg, err)
+	}
+	if again := receiveMessage(t, vic, messages.MessageTypeSession); again.Session != session {
+		t.Errorf("Expected the same session, got %q", again.Session)
+	}
+	for _, c := range waitForClients(t, srv, 2).Clients {
+		if c.UserID == 3 && (c.User == nil || c.User.Name != "vic" || c.User.Role != users.RoleViewer) {
+			t.Errorf("Expected the viewer back as vic, got %+v", c)
+		}
+	}
+
+	// Those kicked cannot come straight back
+	srv.kickUser(3)
+	waitForClients(t, srv, 1)
+	expectSessionExpired(t, dialResume(t, addr, session, nil))
+}
+
+func TestServerExpiresSessions(t *testing.T) {
+	sessionCheckInterval = 10 * time.Millisecond
+	t.Cleanup(func() { sessionCheckInterval = time.Second })
+	srv, addr := startTestServer(t, "")
+	srv.SetSessionGrace(50 * time.Millisecond)
+
+	alice := dialTestClient(t, addr)
+	session := receiveMessage(t, alice, messages.MessageTypeSession).Session
+	_ = alice.Close()
+	waitForClients(t, srv, 0)
+	time.Sleep(200 * time.Millisecond)
+	expectSessionExpired(t, dialResume(t, addr, session, nil))
+
+	expectSessionExpired(t, dialResume(t, addr, "made-up", nil))
+}
+
+// expectSessionExpired checks that the server refused a resumed session and
+// closed the connection
+func expectSessionExpired(t *testing.T, conn *testClient) {
+	t.Helper()
+
+	msg, err := conn.Receive()
+	if err != nil || msg.Type != messages.MessageTypeError || msg.Code != messages.ErrorCodeSessionExpired {
+		t.Fatalf("Expected the session refused as expired, got %+v (%v)", msg, err)
+	}
+	if _, err := conn.Receive(); err == nil {
+		t.Error("Expected the connection closed after the refusal")
+	}
+}
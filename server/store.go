@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/storage"
+	"gollaborate/webhook"
+)
+
+// SetStore keeps the server's documents in a store. Rooms clients open start
+// from the store's copy of their document, if it has one, parked documents go
+// to it rather than to snapshot files, and every room's document is saved to
+// it as the server closes. Call it before Serve.
+func (s *Server) SetStore(store storage.Store) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.store = store
+}
+
+// storedDocument returns the store's copy of a room's document, with the
+// edits logged since it was saved, or a new document started from the named
+// template if there is no store or it has none. The caller must hold s.mutex.
+func (s *Server) storedDocument(name, template string) (*crdt.Document, []storage.LoggedOp, error) {
+	if s.store == nil {
+		doc, err := s.newDocument(name, template)
+		return doc, nil, err
+	}
+	doc, err := s.store.LoadDocument(name)
+	if errors.Is(err, storage.ErrNotFound) {
+		doc, err = s.newDocument(name, template)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading %s: %w", name, err)
+	}
+	logged, err := s.store.Ops(name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading the edits to %s: %w", name, err)
+	}
+	return doc, logged, nil
+}
+
+// saveRooms saves the document of every room to the store, if there is one,
+// keeping a snapshot of it for DocumentAt, and only as many snapshots as the
+// autosave policy keeps. A parked document is already there.
+func (s *Server) saveRooms(rooms []*room) error {
+	s.mutex.Lock()
+	store := s.store
+	keep := s.autosave.Keep
+	s.mutex.Unlock()
+	if store == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, r := range rooms {
+		doc := r.state.Document()
+		if doc == nil {
+			continue
+		}
+		if err := store.SaveDocument(r.name, doc); err != nil {
+			errs = append(errs, fmt.Errorf("saving %s: %w", r.name, err))
+			continue
+		}
+		if err := store.SaveSnapshot(r.name, time.Now(), doc); err != nil {
+			errs = append(errs, fmt.Errorf("saving a snapshot of %s: %w", r.name, err))
+			continue
+		}
+		if err := storage.PruneSnapshots(store, r.name, keep); err != nil {
+			errs = append(errs, fmt.Errorf("pruning the snapshots of %s: %w", r.name, err))
+		}
+		s.fire(r, webhook.EventSaved, 0, "")
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/storage"
+)
+
+func TestServerRoomsFromStore(t *testing.T) {
+	store, err := storage.OpenBoltStore(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+	if err := store.SaveDocument("lecture", crdt.FromText("from the store", 7)); err != nil {
+		t.Fatalf("Failed to save document: %v", err)
+	}
+
+	srv, addr := startTestServer(t, "own")
+	srv.SetStore(store)
+	dialRoomClient(t, addr, "lecture")
+	waitForClients(t, srv, 1)
+	if text := srv.Room("lecture").Document().ToText(); text != "from the store" {
+		t.Errorf("Expected the room opened with the stored document, got '%s'", text)
+	}
+
+	// Closing saves every room, the server's own document too
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Failed to close server: %v", err)
+	}
+	for name, want := range map[string]string{"lecture": "from the store", "test": "own"} {
+		doc, err := store.LoadDocument(name)
+		if err != nil || doc.ToText() != want {
+			t.Errorf("Expected %s saved as '%s', got %v (%v)", name, want, doc, err)
+		}
+	}
+}
+
+func TestServerParksToStore(t *testing.T) {
+	store, err := storage.OpenFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	srv, addr := startTestServer(t, "parked in the store")
+	srv.SetStore(store)
+	srv.EnableParking(50*time.Millisecond, t.TempDir())
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !srv.Stats().Parked {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected idle document to be parked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if doc, err := store.LoadDocument("test"); err != nil || doc.ToText() != "parked in the store" {
+		t.Errorf("Expected the parked document in the store, got %v (%v)", doc, err)
+	}
+
+	dialTestClient(t, addr)
+	doc, err := srv.Document()
+	if err != nil || doc.ToText() != "parked in the store" {
+		t.Errorf("Expected the document reloaded from the store, got %v (%v)", doc, err)
+	}
+}
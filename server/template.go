@@ -0,0 +1,123 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/language"
+)
+
+// ErrUnknownTemplate is returned for a room to be started from a template the
+// server does not have
+var ErrUnknownTemplate = errors.New("unknown template")
+
+// template is what a new document starts as
+type template struct {
+	text     string
+	language string
+}
+
+// builtinTemplates are the templates every server has, unless its template
+// directory has one of the same name
+var builtinTemplates = map[string]template{
+	"meeting-notes": {language: "markdown", text: `# {{room}}
+
+Date: {{date}}
+Attendees:
+
+## Agenda
+
+1.
+
+## Notes
+
+## Action items
+
+- [ ]
+`},
+	"scratchpad": {language: "text", text: "Scratchpad for {{room}}: anything goes, nothing is kept for long.\n\n"},
+}
+
+// SetTemplateDir offers the files in a directory as templates for new
+// documents, each named as its file is without the extension, which gives the
+// document's language. They are read as rooms open, so files added later are
+// offered too, and take the place of built-in templates of the same name.
+// In templates, {{room}} is replaced with the room's name and {{date}} with
+// the date it was created. Servers sharing documents through a broker should
+// be given the same templates; a document is only started from one by the
+// first server to open it while the others have not. Call it before Serve.
+func (s *Server) SetTemplateDir(dir string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.templateDir = dir
+}
+
+// Templates returns the names of the templates new documents can start from,
+// sorted
+func (s *Server) Templates() []string {
+	s.mutex.Lock()
+	dir := s.templateDir
+	s.mutex.Unlock()
+
+	var names []string
+	for name := range builtinTemplates {
+		names = append(names, name)
+	}
+	if dir != "" {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+			if entry.Type().IsRegular() && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// findTemplate returns the named template, from the template directory if it
+// has one by that name. The caller must hold s.mutex.
+func (s *Server) findTemplate(name string) (template, error) {
+	if s.templateDir != "" {
+		// Only the files listed are looked at, so names cannot reach outside
+		entries, _ := os.ReadDir(s.templateDir)
+		for _, entry := range entries {
+			file := entry.Name()
+			if !entry.Type().IsRegular() || strings.TrimSuffix(file, filepath.Ext(file)) != name {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(s.templateDir, file))
+			if err != nil {
+				return template{}, fmt.Errorf("reading template %s: %w", name, err)
+			}
+			return template{text: string(data), language: language.Detect(file)}, nil
+		}
+	}
+	if t, ok := builtinTemplates[name]; ok {
+		return t, nil
+	}
+	return template{}, fmt.Errorf("%w %q", ErrUnknownTemplate, name)
+}
+
+// newDocument starts the document of a new room, from the named template
+// unless the name is empty. The caller must hold s.mutex.
+func (s *Server) newDocument(room, templateName string) (*crdt.Document, error) {
+	if templateName == "" {
+		return crdt.FromText("", s.nodeID), nil
+	}
+	t, err := s.findTemplate(templateName)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.NewReplacer("{{room}}", room, "{{date}}", time.Now().Format(time.DateOnly)).Replace(t.text)
+	doc := crdt.FromText(text, s.nodeID)
+	doc.Metadata.Language = t.language
+	return doc, nil
+}
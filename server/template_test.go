@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gollaborate/messages"
+)
+
+// createRoom asks for a room started from a template, returning the first
+// thing the server answers
+func createRoom(t *testing.T, addr, room, template string) *messages.Message {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	if err := messages.SendMessage(conn, messages.NewCreateRoomMessage(room, template, 1)); err != nil {
+		t.Fatalf("Failed to join room %s: %v", room, err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msg, err := messages.NewReader(conn).Receive()
+	if err != nil {
+		t.Fatalf("Failed to receive an answer: %v", err)
+	}
+	return msg
+}
+
+func TestServerTemplates(t *testing.T) {
+	dir := t.TempDir()
+	for name, text := range map[string]string{
+		"retro.md":   "# Retro for {{room}}\n",
+		"scratchpad": "Ours instead\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0o644); err != nil {
+			t.Fatalf("Failed to write template: %v", err)
+		}
+	}
+	srv, addr := startTestServer(t, "")
+	srv.SetTemplateDir(dir)
+	if names := srv.Templates(); !slices.Equal(names, []string{"meeting-notes", "retro", "scratchpad"}) {
+		t.Errorf("Expected the built-in and directory templates, got %v", names)
+	}
+
+	msg := createRoom(t, addr, "standup", "meeting-notes")
+	if msg.Type != messages.MessageTypeSync || !strings.HasPrefix(msg.Document.ToText(), "# standup\n\nDate: "+time.Now().Format(time.DateOnly)) {
+		t.Errorf("Expected the built-in template filled in, got %+v", msg)
+	}
+	if lang := srv.Room("standup").Document().Metadata.Language; lang != "markdown" {
+		t.Errorf("Expected the template's language, got %q", lang)
+	}
+	if msg := createRoom(t, addr, "friday", "retro"); msg.Document.ToText() != "# Retro for friday\n" {
+		t.Errorf("Expected the template from the directory, got %+v", msg)
+	}
+	if msg := createRoom(t, addr, "ideas", "scratchpad"); msg.Document.ToText() != "Ours instead\n" {
+		t.Errorf("Expected the directory's template to win over the built-in one, got %+v", msg)
+	}
+
+	// A room the server has already keeps its document
+	if msg := createRoom(t, addr, "standup", "scratchpad"); !strings.HasPrefix(msg.Document.ToText(), "# standup") {
+		t.Errorf("Expected the open room kept, got %+v", msg)
+	}
+	for _, template := range []string{"minutes", "../retro"} {
+		if msg := createRoom(t, addr, "review", template); msg.Type != messages.MessageTypeError || !strings.Contains(msg.Error, "unknown template") {
+			t.Errorf("Expected %q refused, got %+v", template, msg)
+		}
+	}
+}
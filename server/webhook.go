@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"gollaborate/webhook"
+)
+
+// webhookCheckInterval is how often rooms are checked for having gone idle
+var webhookCheckInterval = time.Second
+
+// SetWebhooks posts the events of every room to the hooks: when its document
+// is saved, when a client joins or leaves it and, if idleAfter is set, once it
+// goes idleAfter without edits. Each payload carries the document's text as
+// it was then. Endpoints are posted to in the background, so a slow one never
+// holds up a room. Call it before Serve.
+func (s *Server) SetWebhooks(hooks []webhook.Hook, idleAfter time.Duration) {
+	hooked := webhook.New(hooks, func(err error) {
+		s.recordError(fmt.Errorf("webhook %w", err))
+	})
+	s.mutex.Lock()
+	old := s.webhooks
+	s.webhooks, s.webhookIdle = hooked, idleAfter
+	s.mutex.Unlock()
+	// Closing waits for its payloads, whose errors are recorded under s.mutex
+	if old != nil {
+		old.Close()
+	}
+}
+
+// fire posts an event of a room to the webhooks, if there are any, with who
+// it concerns, if anyone
+func (s *Server) fire(r *room, event webhook.Event, userID int, userName string) {
+	s.mutex.Lock()
+	hooks := s.webhooks
+	s.mutex.Unlock()
+	if hooks == nil {
+		return
+	}
+	doc, err := r.state.Fork()
+	if err != nil {
+		s.recordError(fmt.Errorf("webhook: reading %s: %w", r.name, err))
+		return
+	}
+	hooks.Fire(webhook.Payload{
+		Event:    event,
+		Document: r.name,
+		Time:     time.Now(),
+		UserID:   userID,
+		UserName: userName,
+		Text:     doc.ToText(),
+	})
+}
+
+// watchIdleDocuments regularly fires the idle event of rooms whose document
+// went without edits for as long as the webhooks were told, until the server
+// closes
+func (s *Server) watchIdleDocuments() {
+	ticker := time.NewTicker(webhookCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.checkIdleDocuments(now)
+		}
+	}
+}
+
+// checkIdleDocuments fires the idle event of every room last edited at least
+// the webhooks' idle time ago, once for each run of edits
+func (s *Server) checkIdleDocuments(now time.Time) {
+	s.mutex.Lock()
+	idleAfter := s.webhookIdle
+	if s.webhooks == nil || idleAfter <= 0 {
+		s.mutex.Unlock()
+		return
+	}
+	var idle []*room
+	for _, r := range s.rooms {
+		edited := r.history.lastEdited()
+		if edited.IsZero() || now.Sub(edited) < idleAfter || edited.Equal(r.idleSince) {
+			continue
+		}
+		r.idleSince = edited
+		idle = append(idle, r)
+	}
+	s.mutex.Unlock()
+
+	for _, r := range idle {
+		s.fire(r, webhook.EventIdle, 0, "")
+	}
+}
+
+// closeWebhooks waits for the payloads fired to be posted
+func (s *Server) closeWebhooks() {
+	s.mutex.Lock()
+	hooks := s.webhooks
+	s.mutex.Unlock()
+	if hooks != nil {
+		hooks.Close()
+	}
+}
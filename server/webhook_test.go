@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/storage"
+	"gollaborate/webhook"
+)
+
+// startWebhookEndpoint serves an endpoint telling a channel of each payload
+func startWebhookEndpoint(t *testing.T) (string, chan webhook.Payload) {
+	t.Helper()
+
+	payloads := make(chan webhook.Payload, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p webhook.Payload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("Failed to decode payload: %v", err)
+		}
+		payloads <- p
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, payloads
+}
+
+// expectPayload waits for the endpoint's next payload, which should be of the
+// given event and document text
+func expectPayload(t *testing.T, payloads chan webhook.Payload, event webhook.Event, text string) webhook.Payload {
+	t.Helper()
+
+	select {
+	case p := <-payloads:
+		if p.Event != event || p.Document != "test" || p.Text != text {
+			t.Errorf("Expected %s with '%s', got %+v", event, text, p)
+		}
+		return p
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected %s posted", event)
+	}
+	return webhook.Payload{}
+}
+
+func TestServerWebhooks(t *testing.T) {
+	webhookCheckInterval = 10 * time.Millisecond
+	url, payloads := startWebhookEndpoint(t)
+	store, err := storage.OpenFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	srv, addr := startTestServer(t, "")
+	srv.SetStore(store)
+	srv.SetWebhooks([]webhook.Hook{{URL: url}}, 50*time.Millisecond)
+
+	alice := dialTestClient(t, addr)
+	waitForClients(t, srv, 1)
+	expectPayload(t, payloads, webhook.EventJoined, "")
+
+	// Idle once after a run of edits, not again until edited once more
+	for i, char := range "hi" {
+		id := []crdt.Identifier{{Digit: 5 + i, Node: 3}}
+		if err := messages.SendOperation(alice, messages.NewInsertOperation(id, char, 3, i+1)); err != nil {
+			t.Fatalf("Failed to send operation: %v", err)
+		}
+	}
+	waitForHistory(t, srv, "", "hi")
+	expectPayload(t, payloads, webhook.EventIdle, "hi")
+	select {
+	case p := <-payloads:
+		t.Errorf("Expected idle posted once, got %+v", p)
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	_ = alice.Close()
+	waitForClients(t, srv, 0)
+	expectPayload(t, payloads, webhook.EventLeft, "hi")
+
+	// Closing saves, and waits for that to be posted
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Failed to close server: %v", err)
+	}
+	expectPayload(t, payloads, webhook.EventSaved, "hi")
+}
+
+func TestServerReplacesFailingWebhooks(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(failing.Close)
+	url, payloads := startWebhookEndpoint(t)
+	srv, addr := startTestServer(t, "")
+	srv.SetWebhooks([]webhook.Hook{{URL: failing.URL}}, 0)
+	dialTestClient(t, addr)
+	waitForClients(t, srv, 1)
+
+	// The old hooks are let finish failing, and told of, without holding the server up
+	replaced := make(chan struct{})
+	go func() {
+		srv.SetWebhooks([]webhook.Hook{{URL: url}}, 0)
+		close(replaced)
+	}()
+	select {
+	case <-replaced:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the webhooks replaced")
+	}
+	if errs := srv.Stats().Errors; len(errs) == 0 {
+		t.Error("Expected the failing hook reported")
+	}
+	dialTestClient(t, addr)
+	expectPayload(t, payloads, webhook.EventJoined, "")
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+
+	"gollaborate/messages"
+)
+
+// ServeWebSocket accepts clients over WebSockets at messages.WebSocketPath on
+// the listener until the server is closed, over TLS if config is not nil.
+// Clients speak the same protocol as over TCP, framed in WebSocket messages,
+// so they can connect through HTTP proxies and load balancers.
+func (s *Server) ServeWebSocket(listener net.Listener, config *tls.Config) error {
+	mux := http.NewServeMux()
+	mux.Handle(messages.WebSocketPath, s.WebSocketHandler())
+	srv := &http.Server{Handler: mux, TLSConfig: config}
+
+	s.mutex.Lock()
+	s.httpServers = append(s.httpServers, srv)
+	s.mutex.Unlock()
+
+	var err error
+	if config != nil {
+		err = srv.ServeTLS(listener, "", "")
+	} else {
+		err = srv.Serve(listener)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// WebSocketHandler returns a handler accepting clients over WebSockets, to
+// mount at a path of one's choosing in an HTTP server of one's own, such as
+// one behind a reverse proxy terminating HTTPS. It stops accepting them when
+// the server is closed.
+func (s *Server) WebSocketHandler() http.Handler {
+	ws := messages.NewWebSocketListener(nil)
+	go func() {
+		if err := s.Serve(ws); err != nil {
+			s.recordError(err)
+		}
+	}()
+	return ws
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+)
+
+// receiveSync reads the initial sync and description from a new client's connection
+func receiveSync(t *testing.T, reader *messages.Reader) *messages.Message {
+	t.Helper()
+
+	sync, err := reader.Receive()
+	if err != nil || sync.Type != messages.MessageTypeSync {
+		t.Fatalf("Expected the initial sync, got %+v (%v)", sync, err)
+	}
+	if msg, err := reader.Receive(); err != nil || msg.Type != messages.MessageTypeDocMeta {
+		t.Fatalf("Expected the document description, got %+v (%v)", msg, err)
+	}
+	return sync
+}
+
+func TestServerOverWebSocket(t *testing.T) {
+	srv, addr := startTestServer(t, "bridged")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() { _ = srv.ServeWebSocket(listener, nil) }()
+
+	conn, err := messages.DialWebSocket(listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect over WebSocket: %v", err)
+	}
+	defer conn.Close()
+	reader := messages.NewReader(conn)
+	if sync := receiveSync(t, reader); sync.Document.ToText() != "bridged" {
+		t.Errorf("Expected the document over the WebSocket, got '%s'", sync.Document.ToText())
+	}
+
+	// Clients over TCP and WebSockets edit the same document
+	tcp := dialTestClient(t, addr)
+	waitForClients(t, srv, 2)
+	op := messages.NewInsertOperation([]crdt.Identifier{{Digit: 1, Node: 1}}, '>', 1, 1)
+	if err := messages.SendOperation(conn, op); err != nil {
+		t.Fatalf("Failed to send operation: %v", err)
+	}
+	_ = tcp.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		msg, err := tcp.Receive()
+		if err != nil {
+			t.Fatalf("Expected the edit relayed to the TCP client: %v", err)
+		}
+		if msg.Type == messages.MessageTypeOperation && msg.Operation.Character == '>' {
+			break
+		}
+	}
+}
+
+func TestWebSocketHandlerOnOwnPath(t *testing.T) {
+	srv, _ := startTestServer(t, "mounted")
+	mux := http.NewServeMux()
+	mux.Handle("/collab/ws", srv.WebSocketHandler())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	httpServer := &http.Server{Handler: mux}
+	go func() { _ = httpServer.Serve(listener) }()
+	t.Cleanup(func() { _ = httpServer.Close() })
+
+	conn, err := messages.DialWebSocket("ws://" + listener.Addr().String() + "/collab/ws")
+	if err != nil {
+		t.Fatalf("Failed to connect over WebSocket: %v", err)
+	}
+	defer conn.Close()
+	if err := messages.SendMessage(conn, messages.NewJoinRoomMessage("lecture", 1)); err != nil {
+		t.Fatalf("Failed to join room: %v", err)
+	}
+	receiveSync(t, messages.NewReader(conn))
+	if stats := waitForClients(t, srv, 1); stats.Clients[0].Room != "lecture" {
+		t.Errorf("Expected the WebSocket client in the lecture room, got %+v", stats.Clients[0])
+	}
+}
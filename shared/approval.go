@@ -0,0 +1,136 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"gollaborate/messages"
+)
+
+// DefaultApprovalTimeout is how long a proposal waits for enough votes
+const DefaultApprovalTimeout = 30 * time.Second
+
+// ErrNotApproved is returned when the other editors do not approve an action
+var ErrNotApproved = errors.New("not approved by enough editors")
+
+// proposal is an action of ours waiting for the other editors' votes
+type proposal struct {
+	required int          // Approvals needed
+	voters   int          // Editors who may vote
+	votes    map[int]bool // By user ID, true for approvals
+	decided  chan bool    // Receives the outcome, once
+}
+
+// RequireApproval makes an action wait for a quorum of the other editors in the
+// session to approve it: quorum is the fraction of them, rounded up, who must
+// vote for it. Zero lifts the requirement, as does being the only editor.
+// Restoring an old version is the only action that asks so far.
+func (e *EditorState) RequireApproval(action messages.TransactionAction, quorum float64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if quorum <= 0 {
+		delete(e.quorums, action)
+		return
+	}
+	e.quorums[action] = min(quorum, 1)
+}
+
+// SetApprovalTimeout sets how long proposals wait for votes before they fail
+func (e *EditorState) SetApprovalTimeout(timeout time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.approvalTimeout = timeout
+}
+
+// Vote approves or rejects a peer's proposal
+func (e *EditorState) Vote(proposalID int64, approve bool) {
+	e.BroadcastMessage(messages.NewVoteMessage(proposalID, approve, e.nodeID))
+}
+
+// approve asks the other editors to approve an action if it needs approval, and
+// waits for their votes. It returns nil once enough approve, or ErrNotApproved
+// once too many reject or the votes do not come in time.
+func (e *EditorState) approve(action messages.TransactionAction, description string) error {
+	e.mutex.Lock()
+	voters := e.otherEditors()
+	required := int(math.Ceil(e.quorums[action] * float64(voters)))
+	if required == 0 {
+		e.mutex.Unlock()
+		return nil
+	}
+	e.nextProposal++
+	id := int64(e.nodeID)<<32 | e.nextProposal
+	p := &proposal{required: required, voters: voters, votes: make(map[int]bool), decided: make(chan bool, 1)}
+	e.proposals[id] = p
+	timeout := e.approvalTimeout
+	e.mutex.Unlock()
+
+	defer func() {
+		e.mutex.Lock()
+		delete(e.proposals, id)
+		e.mutex.Unlock()
+	}()
+
+	e.BroadcastMessage(messages.NewProposalMessage(id, action, description, e.nodeID, e.awareness.Local().UserName))
+	select {
+	case approved := <-p.decided:
+		if !approved {
+			return fmt.Errorf("%w: rejected by too many of %d", ErrNotApproved, voters)
+		}
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("%w: %d of %d approvals within %s", ErrNotApproved, p.approvals(), required, timeout)
+	}
+}
+
+// approvals counts the votes in favor
+func (p *proposal) approvals() int {
+	n := 0
+	for _, approve := range p.votes {
+		if approve {
+			n++
+		}
+	}
+	return n
+}
+
+// otherEditors counts the other participants online who may edit. The caller
+// must hold e.mutex.
+func (e *EditorState) otherEditors() int {
+	n := 0
+	for _, state := range e.awareness.States() {
+		if state.UserID != e.nodeID && e.roleOf(state.UserID) != messages.RoleReadOnly {
+			n++
+		}
+	}
+	return n
+}
+
+// handleVote counts a vote for one of our proposals, reporting whether the vote
+// is for someone else's and should be passed on. The caller must hold e.mutex.
+func (e *EditorState) handleVote(msg *messages.Message) bool {
+	p, ok := e.proposals[msg.ProposalID]
+	if !ok {
+		return msg.ProposalID>>32 != int64(e.nodeID)
+	}
+	if _, voted := p.votes[msg.UserID]; voted || msg.UserID == e.nodeID || e.roleOf(msg.UserID) == messages.RoleReadOnly {
+		return false
+	}
+	p.votes[msg.UserID] = msg.Approve
+
+	approvals := p.approvals()
+	rejections := len(p.votes) - approvals
+	switch {
+	case approvals >= p.required:
+		p.decided <- true
+	case rejections > p.voters-p.required:
+		p.decided <- false
+	default:
+		return false
+	}
+	// Decided; later votes find no proposal
+	delete(e.proposals, msg.ProposalID)
+	return false
+}
@@ -0,0 +1,257 @@
+package shared
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"gollaborate/messages"
+)
+
+const (
+	// AttachmentChunkSize is how much of a shared file each chunk carries
+	AttachmentChunkSize = 32 << 10
+	// MaxAttachmentSize is the largest file, in bytes, that can be shared
+	MaxAttachmentSize = 16 << 20
+	// maxAttachmentChunk is the largest chunk accepted from peers, which may
+	// chunk files differently
+	maxAttachmentChunk = 1 << 20
+	// maxAttachments is how many shared files the session keeps
+	maxAttachments = 16
+)
+
+var (
+	// ErrEmptyAttachment is returned when sharing an empty file
+	ErrEmptyAttachment = errors.New("nothing to share")
+	// ErrAttachmentTooLarge is returned when sharing a file larger than MaxAttachmentSize
+	ErrAttachmentTooLarge = errors.New("file too large to share")
+	// ErrNoAttachment is returned for a shared file the session does not have
+	ErrNoAttachment = errors.New("no such shared file")
+	// ErrAttachmentIncomplete is returned when reading a shared file still arriving
+	ErrAttachmentIncomplete = errors.New("shared file has not fully arrived")
+	// ErrAttachmentCorrupt is reported when a shared file arrived whole but not
+	// as it was sent; it is then fetched again from the start
+	ErrAttachmentCorrupt = errors.New("shared file arrived corrupt")
+)
+
+// Attachment is a file shared in the session alongside the document, such as
+// an image the document refers to
+type Attachment struct {
+	messages.AttachmentInfo
+	UserID   int
+	UserName string
+	Received int64 // How many bytes have arrived so far
+}
+
+// Complete reports whether the whole file has arrived
+func (a Attachment) Complete() bool {
+	return a.Received == a.Size
+}
+
+// Progress returns how much of the file has arrived, from 0 to 1
+func (a Attachment) Progress() float64 {
+	if a.Size == 0 {
+		return 1
+	}
+	return float64(a.Received) / float64(a.Size)
+}
+
+// attachment is a shared file and the chunks of it that have arrived
+type attachment struct {
+	Attachment
+	chunks [][]byte
+}
+
+// missing returns the chunks that have not arrived
+func (a *attachment) missing() []int {
+	var missing []int
+	for i, chunk := range a.chunks {
+		if chunk == nil {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// ShareAttachment shares a file with every participant. The file is offered
+// first and then sent in chunks of AttachmentChunkSize, behind edits and
+// presence, so a large file does not hold up the session. Listeners hear about
+// files shared by peers as MessageTypeAttachment messages, and about each chunk
+// arriving as a MessageTypeAttachmentChunk message.
+func (e *EditorState) ShareAttachment(name string, data []byte) (Attachment, error) {
+	if len(data) == 0 {
+		return Attachment{}, ErrEmptyAttachment
+	}
+	if len(data) > MaxAttachmentSize {
+		return Attachment{}, ErrAttachmentTooLarge
+	}
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	local := e.awareness.Local()
+	a := &attachment{
+		Attachment: Attachment{
+			AttachmentInfo: messages.AttachmentInfo{
+				ID:        digest[:16],
+				Name:      filepath.Base(name),
+				Size:      int64(len(data)),
+				ChunkSize: AttachmentChunkSize,
+				SHA256:    digest,
+			},
+			UserID:   e.nodeID,
+			UserName: local.UserName,
+			Received: int64(len(data)),
+		},
+	}
+	for start := 0; start < len(data); start += AttachmentChunkSize {
+		a.chunks = append(a.chunks, bytes.Clone(data[start:min(start+AttachmentChunkSize, len(data))]))
+	}
+
+	e.mutex.Lock()
+	e.addAttachment(a)
+	e.mutex.Unlock()
+
+	e.BroadcastMessage(messages.NewAttachmentMessage(a.AttachmentInfo, e.nodeID, local.UserName))
+	for i, chunk := range a.chunks {
+		e.BroadcastMessage(messages.NewAttachmentChunkMessage(a.ID, i, chunk, e.nodeID))
+	}
+	return a.Attachment, nil
+}
+
+// Attachments returns the files shared in the session, oldest first, including
+// those still arriving
+func (e *EditorState) Attachments() []Attachment {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	files := make([]Attachment, len(e.attachments))
+	for i, a := range e.attachments {
+		files[i] = a.Attachment
+	}
+	return files
+}
+
+// AttachmentData returns the contents of a shared file that has fully arrived
+func (e *EditorState) AttachmentData(id string) ([]byte, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	a := e.attachment(id)
+	if a == nil {
+		return nil, ErrNoAttachment
+	}
+	if !a.Complete() {
+		return nil, ErrAttachmentIncomplete
+	}
+	return bytes.Join(a.chunks, nil), nil
+}
+
+// ResumeAttachment asks the peers for the chunks of a shared file that have
+// not arrived, such as those lost when a connection dropped. Any peer with them
+// sends them again; those already here are not.
+func (e *EditorState) ResumeAttachment(id string) error {
+	e.mutex.Lock()
+	a := e.attachment(id)
+	var missing []int
+	if a != nil {
+		missing = a.missing()
+	}
+	e.mutex.Unlock()
+	if a == nil {
+		return ErrNoAttachment
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	e.BroadcastMessage(messages.NewAttachmentRequestMessage(id, missing, e.nodeID))
+	return nil
+}
+
+// attachment returns the shared file with the ID, nil if there is none. The
+// caller must hold e.mutex.
+func (e *EditorState) attachment(id string) *attachment {
+	for _, a := range e.attachments {
+		if a.ID == id {
+			return a
+		}
+	}
+	return nil
+}
+
+// addAttachment adds a shared file, replacing one with the same ID and
+// forgetting the oldest past maxAttachments. The caller must hold e.mutex.
+func (e *EditorState) addAttachment(a *attachment) {
+	for i, old := range e.attachments {
+		if old.ID == a.ID {
+			e.attachments = append(e.attachments[:i:i], e.attachments[i+1:]...)
+			break
+		}
+	}
+	e.attachments = append(e.attachments, a)
+	if len(e.attachments) > maxAttachments {
+		e.attachments = append([]*attachment(nil), e.attachments[len(e.attachments)-maxAttachments:]...)
+	}
+}
+
+// handleAttachment takes note of a file a peer offers, reporting whether it is
+// new. The caller must hold e.mutex.
+func (e *EditorState) handleAttachment(msg *messages.Message) bool {
+	info := *msg.Attachment
+	if msg.UserID == e.nodeID || e.attachment(info.ID) != nil {
+		return false
+	}
+	if info.Size <= 0 || info.Size > MaxAttachmentSize || info.ChunkSize <= 0 || info.ChunkSize > maxAttachmentChunk {
+		return false
+	}
+	info.Name = filepath.Base(info.Name)
+	e.addAttachment(&attachment{
+		Attachment: Attachment{AttachmentInfo: info, UserID: msg.UserID, UserName: msg.UserName},
+		chunks:     make([][]byte, info.ChunkCount()),
+	})
+	return true
+}
+
+// handleAttachmentChunk stores a chunk of a shared file, reporting whether it
+// was new. Once the last chunk arrives the file is checked against its digest;
+// if it does not match, every chunk is dropped to be fetched again. The caller
+// must hold e.mutex.
+func (e *EditorState) handleAttachmentChunk(conn messages.Transport, msg *messages.Message) bool {
+	a := e.attachment(msg.Attachment.ID)
+	if a == nil || msg.Chunk < 0 || msg.Chunk >= len(a.chunks) || a.chunks[msg.Chunk] != nil {
+		return false
+	}
+	want := int64(a.ChunkSize)
+	if msg.Chunk == len(a.chunks)-1 {
+		want = a.Size - int64(msg.Chunk)*want
+	}
+	if int64(len(msg.Data)) != want {
+		return false
+	}
+	a.chunks[msg.Chunk] = bytes.Clone(msg.Data)
+	a.Received += want
+	if !a.Complete() {
+		return true
+	}
+
+	sum := sha256.Sum256(bytes.Join(a.chunks, nil))
+	if hex.EncodeToString(sum[:]) != a.SHA256 {
+		a.chunks = make([][]byte, len(a.chunks))
+		a.Received = 0
+		go e.reportError(conn, fmt.Errorf("%w: %s", ErrAttachmentCorrupt, a.Name))
+	}
+	return true
+}
+
+// handleAttachmentRequest sends a peer the chunks it asks for that we have.
+// The caller must hold e.mutex.
+func (e *EditorState) handleAttachmentRequest(conn messages.Transport, msg *messages.Message) {
+	a := e.attachment(msg.Attachment.ID)
+	if a == nil {
+		return
+	}
+	for _, i := range msg.Chunks {
+		if i >= 0 && i < len(a.chunks) && a.chunks[i] != nil {
+			e.send(conn, messages.NewAttachmentChunkMessage(a.ID, i, a.chunks[i], e.nodeID))
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package shared
+
+import (
+	"time"
+
+	"gollaborate/messages"
+)
+
+const (
+	// DefaultBatchWindow is how long a local operation may wait for others to
+	// share its message
+	DefaultBatchWindow = 20 * time.Millisecond
+	// DefaultBatchSize is how many operations fill a batch, which is then sent at once
+	DefaultBatchSize = 32
+)
+
+// batchVersion is the protocol version from which peers read batches. Older
+// peers get the operations of a batch one message each.
+const batchVersion = 5
+
+// SetBatching sets how long local operations wait to be sent together and how
+// many make a full batch. Fast typing and pastes then cost one message per
+// batch rather than one per character. A window of zero sends each operation
+// at once.
+func (e *EditorState) SetBatching(window time.Duration, size int) {
+	e.batchMutex.Lock()
+	defer e.batchMutex.Unlock()
+	e.batchWindow = window
+	e.batchSize = max(1, size)
+	e.flushBatch()
+}
+
+// batchOperation adds a local operation message to the pending batch, reporting
+// whether it did. Other messages are not batched.
+func (e *EditorState) batchOperation(msg *messages.Message) bool {
+	if msg.Type != messages.MessageTypeOperation || msg.UserID != e.nodeID {
+		return false
+	}
+
+	e.batchMutex.Lock()
+	defer e.batchMutex.Unlock()
+	if e.batchWindow <= 0 {
+		return false
+	}
+	e.batch = append(e.batch, msg.Operation)
+	if len(e.batch) >= e.batchSize {
+		e.flushBatch()
+	} else if e.batchTimer == nil {
+		e.batchTimer = time.AfterFunc(e.batchWindow, func() {
+			e.batchMutex.Lock()
+			defer e.batchMutex.Unlock()
+			e.flushBatch()
+		})
+	}
+	return true
+}
+
+// flushBatch sends the pending operations: one on its own, more as a batch. The
+// caller must hold e.batchMutex.
+func (e *EditorState) flushBatch() {
+	if e.batchTimer != nil {
+		e.batchTimer.Stop()
+		e.batchTimer = nil
+	}
+	switch len(e.batch) {
+	case 0:
+		return
+	case 1:
+		e.broadcastExcept(nil, messages.NewOperationMessage(e.batch[0]))
+	default:
+		e.broadcastExcept(nil, messages.NewBatchMessage(e.batch, e.nodeID))
+	}
+	e.batch = nil
+}
+
+// unbatch returns the messages to send a peer that cannot read batches in place
+// of msg: the operations of a batch one by one, or msg itself
+func unbatch(msg *messages.Message) []*messages.Message {
+	if msg.Type != messages.MessageTypeBatch {
+		return []*messages.Message{msg}
+	}
+	msgs := make([]*messages.Message, len(msg.Operations))
+	for i, op := range msg.Operations {
+		msgs[i] = messages.NewOperationMessage(op)
+	}
+	return msgs
+}
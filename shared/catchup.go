@@ -0,0 +1,193 @@
+package shared
+
+import (
+	"slices"
+	"sync"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+)
+
+// maxLoggedOps is how many of the latest operations are kept to answer catch-up
+// requests. Peers that missed more get the whole document.
+const maxLoggedOps = 10000
+
+// opLog keeps the latest operations this node applied or sent, so a peer that
+// was briefly disconnected can be sent just the ones it missed. Every user's
+// clock only grows, so what a peer has seen is the latest clock from each user.
+type opLog struct {
+	mutex    sync.Mutex
+	ops      []*messages.Operation
+	seen     map[int]int // Latest clock logged from each user
+	trimmed  map[int]int // Latest clock among the operations dropped from each user
+	recorder OpRecorder  // Told about every operation logged, see RecordOps
+}
+
+// OpRecorder is told about the operations applied to the document or sent to
+// peers, in order, such as to keep them in a store. It runs with the log
+// locked, and sometimes the editor state too, so it must not call back into
+// the editor state.
+type OpRecorder func(ops []*messages.Operation)
+
+// RecordOps makes the editor state tell a recorder about every operation it
+// applies from peers or sends them. nil stops recording.
+func (e *EditorState) RecordOps(recorder OpRecorder) {
+	e.oplog.mutex.Lock()
+	defer e.oplog.mutex.Unlock()
+	e.oplog.recorder = recorder
+}
+
+// add logs operations
+func (l *opLog) add(ops []*messages.Operation) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.seen == nil {
+		l.seen = make(map[int]int)
+		l.trimmed = make(map[int]int)
+	}
+	for _, op := range ops {
+		l.seen[op.UserID] = max(l.seen[op.UserID], op.Clock)
+	}
+	if l.recorder != nil {
+		l.recorder(ops)
+	}
+	l.ops = append(l.ops, ops...)
+	if excess := len(l.ops) - maxLoggedOps; excess > 0 {
+		for _, op := range l.ops[:excess] {
+			l.trimmed[op.UserID] = max(l.trimmed[op.UserID], op.Clock)
+		}
+		l.ops = append([]*messages.Operation(nil), l.ops[excess:]...)
+	}
+}
+
+// clocks returns the latest clock logged from each user
+func (l *opLog) clocks() map[int]int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	clocks := make(map[int]int, len(l.seen))
+	for userID, clock := range l.seen {
+		clocks[userID] = clock
+	}
+	return clocks
+}
+
+// since returns the logged operations a peer that saw the given clocks may
+// lack, oldest first. Operations at a clock the peer saw are included, since a
+// delete can share its clock with an insert; applying either twice is harmless.
+// ok is false if some of them were already dropped from the log.
+func (l *opLog) since(clocks map[int]int) (ops []*messages.Operation, ok bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for userID, trimmed := range l.trimmed {
+		if clock, seen := clocks[userID]; !seen || clock <= trimmed {
+			return nil, false
+		}
+	}
+	for _, op := range l.ops {
+		if clock, seen := clocks[op.UserID]; !seen || op.Clock >= clock {
+			ops = append(ops, op)
+		}
+	}
+	return ops, true
+}
+
+// RequestCatchUp asks the peer on a connection for the operations this node
+// missed, such as after reconnecting following a brief outage. The peer answers
+// with just those operations, or with the whole document if it no longer has
+// them all.
+func (e *EditorState) RequestCatchUp(conn messages.Transport) error {
+	return <-e.send(conn, messages.NewCatchUpRequestMessage(e.oplog.clocks(), e.nodeID)).sent
+}
+
+// Clocks returns the latest clock this node saw from each user, which a peer
+// resuming a session is told so it sends only what this node missed
+func (e *EditorState) Clocks() map[int]int {
+	return e.oplog.clocks()
+}
+
+// ResumePeer brings a peer taking up its session again after reconnecting up
+// to date with just the operations it missed, as the clocks it resumed with
+// say, without waiting for its hello, and asks it for those it made meanwhile.
+// A peer whose missed operations were forgotten gets the whole document. It
+// returns the strategy used and any error sending.
+func (e *EditorState) ResumePeer(conn messages.Transport, clocks map[int]int) (messages.SyncStrategy, error) {
+	e.mutex.Lock()
+	delete(e.syncOffers, conn)
+	ops, ok := e.oplog.since(clocks)
+	ours := e.oplog.clocks()
+	next := e.nextOrder()
+	var doc *crdt.Document
+	var err error
+	if !ok {
+		doc, err = e.documentCopy()
+	}
+	e.mutex.Unlock()
+	if err != nil {
+		return messages.SyncFull, err
+	}
+
+	if !ok {
+		sync := messages.NewSyncMessage(doc, e.nodeID)
+		sync.Order = next
+		return messages.SyncFull, <-e.send(conn, sync).sent
+	}
+	e.send(conn, messages.NewCatchUpRequestMessage(ours, e.nodeID))
+	catchUp := messages.NewCatchUpMessage(ops, e.nodeID)
+	catchUp.Order = next
+	return messages.SyncMerge, <-e.send(conn, catchUp).sent
+}
+
+// logOperations logs the operations of a message for catch-up requests
+func (e *EditorState) logOperations(msg *messages.Message) {
+	switch msg.Type {
+	case messages.MessageTypeOperation, messages.MessageTypeTransaction, messages.MessageTypeBatch:
+		e.oplog.add(operationsOf(msg))
+	}
+}
+
+// handleCatchUpRequest answers a peer's catch-up request, with the whole
+// document if it asked for that. The caller must hold e.mutex.
+func (e *EditorState) handleCatchUpRequest(conn messages.Transport, msg *messages.Message) {
+	full := slices.Equal(msg.Strategies, []messages.SyncStrategy{messages.SyncFull})
+	if ops, ok := e.oplog.since(msg.Clocks); ok && !full {
+		catchUp := messages.NewCatchUpMessage(ops, e.nodeID)
+		catchUp.Order = e.nextOrder()
+		e.send(conn, catchUp)
+		return
+	}
+
+	doc, err := e.documentCopy()
+	if err != nil {
+		go e.reportError(conn, err)
+		return
+	}
+	sync := messages.NewSyncMessage(doc, e.nodeID)
+	sync.Order = e.nextOrder()
+	e.send(conn, sync)
+}
+
+// handleCatchUp applies the operations a peer sent in answer to our catch-up
+// request, reporting whether any were new. Those new to the document go
+// through the edit limiter, like any other edits. The caller must hold e.mutex.
+func (e *EditorState) handleCatchUp(conn messages.Transport, msg *messages.Message) bool {
+	if e.editLimiter != nil && !e.limitEdits(conn, e.unapplied(msg.Operations)) {
+		return false
+	}
+	var applied []*messages.Operation
+	for _, op := range msg.Operations {
+		if e.applyOperation(op) {
+			applied = append(applied, op)
+		}
+	}
+	if len(applied) == 0 {
+		return false
+	}
+	e.oplog.add(applied)
+	e.flagProtected(conn, applied)
+	// A hub passes on what the peer did while apart, such as the edits of a
+	// client that resumed its session after editing offline
+	if e.relay {
+		e.broadcastExcept(conn, messages.NewBatchMessage(applied, e.nodeID))
+	}
+	return true
+}
@@ -0,0 +1,38 @@
+package shared
+
+import "gollaborate/crdt"
+
+// ChangeLogger is told about every change applied to the document, with the
+// text after it, for finding where replicas diverge; see replay.OpLogger
+type ChangeLogger interface {
+	// Change is called for each character inserted or deleted, by anyone
+	Change(c crdt.Change, text string)
+	// Base is called with the text when logging starts and after a sync is merged
+	Base(text string)
+}
+
+// LogChanges starts telling a logger about every change applied to the
+// document, in the order they are applied
+func (e *EditorState) LogChanges(logger ChangeLogger) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.changeLogger = logger
+	e.watchChanges()
+}
+
+// watchChanges logs the changes to the current document, starting with its
+// text. The caller must hold e.mutex.
+func (e *EditorState) watchChanges() {
+	logger, doc := e.changeLogger, e.document
+	if logger == nil || doc == nil {
+		return
+	}
+	logger.Base(doc.ToText())
+	if e.loggedDoc == doc {
+		return
+	}
+	e.loggedDoc = doc
+	doc.AddChangeListener(func(c crdt.Change) {
+		logger.Change(c, doc.ToText())
+	})
+}
@@ -0,0 +1,83 @@
+package shared
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gollaborate/messages"
+)
+
+const (
+	// MaxChatLength is the longest chat message, in bytes, that can be sent
+	MaxChatLength = 2000
+	// maxChat is how many chat messages the session keeps
+	maxChat = 200
+)
+
+var (
+	// ErrEmptyChat is returned when sending a blank chat message
+	ErrEmptyChat = errors.New("nothing to say")
+	// ErrChatTooLong is returned when sending a chat message longer than MaxChatLength
+	ErrChatTooLong = errors.New("chat message too long")
+)
+
+// ChatMessage is a message in the session chat
+type ChatMessage struct {
+	UserID   int
+	UserName string
+	Text     string
+	SentAt   time.Time // As told by the sender's clock
+}
+
+// SendChat sends a short message to every participant, without touching the
+// document. It is added to the local chat too. Listeners hear about chat from
+// peers as MessageTypeChat messages.
+func (e *EditorState) SendChat(text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return ErrEmptyChat
+	}
+	if len(text) > MaxChatLength {
+		return ErrChatTooLong
+	}
+	local := e.awareness.Local()
+	now := time.Now()
+
+	e.mutex.Lock()
+	e.addChat(ChatMessage{UserID: e.nodeID, UserName: local.UserName, Text: text, SentAt: now})
+	e.mutex.Unlock()
+
+	e.BroadcastMessage(messages.NewChatMessage(text, now, e.nodeID, local.UserName))
+	return nil
+}
+
+// Chat returns the session's chat messages, oldest first
+func (e *EditorState) Chat() []ChatMessage {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]ChatMessage(nil), e.chat...)
+}
+
+// addChat adds a message to the chat, forgetting the oldest past maxChat. The
+// caller must hold e.mutex.
+func (e *EditorState) addChat(chat ChatMessage) {
+	e.chat = append(e.chat, chat)
+	if len(e.chat) > maxChat {
+		e.chat = append([]ChatMessage(nil), e.chat[len(e.chat)-maxChat:]...)
+	}
+}
+
+// handleChat adds a peer's chat message to the chat, reporting whether it was
+// accepted. The caller must hold e.mutex.
+func (e *EditorState) handleChat(msg *messages.Message) bool {
+	if msg.UserID == e.nodeID || msg.Text == "" || len(msg.Text) > MaxChatLength {
+		return false
+	}
+	sentAt := time.UnixMilli(msg.SentAt)
+	if msg.SentAt == 0 {
+		sentAt = time.Now()
+	}
+	e.addChat(ChatMessage{UserID: msg.UserID, UserName: msg.UserName, Text: msg.Text, SentAt: sentAt})
+	return true
+}
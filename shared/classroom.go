@@ -0,0 +1,101 @@
+package shared
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+)
+
+const (
+	// MaxSubmissionSize is the largest piece of work, in bytes, that can be handed in
+	MaxSubmissionSize = 256 << 10
+	// maxSubmissions is how many submissions the presenter keeps
+	maxSubmissions = 200
+)
+
+// ErrEmptySubmission is returned when handing in nothing
+var ErrEmptySubmission = errors.New("nothing to submit")
+
+// ErrSubmissionTooLarge is returned when handing in more than MaxSubmissionSize
+var ErrSubmissionTooLarge = errors.New("submission too large")
+
+// Submission is work a student handed in to the presenter
+type Submission struct {
+	UserID   int
+	UserName string
+	Text     string
+	Time     time.Time // When it arrived
+}
+
+// Present makes this node the presenter of a class: it becomes the session
+// originator, everyone who joins can only watch, and it keeps the work students
+// hand in from their private forks of the document
+func (e *EditorState) Present() {
+	e.SetJoinerRole(messages.RoleReadOnly)
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.presenting = true
+}
+
+// Presenting reports whether this node is presenting to a class
+func (e *EditorState) Presenting() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.presenting
+}
+
+// Fork returns a private copy of the document, to edit without sharing the
+// changes, such as a student trying out the presenter's example
+func (e *EditorState) Fork() (*crdt.Document, error) {
+	e.mutex.Lock()
+	data, err := json.Marshal(e.document)
+	e.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	doc := &crdt.Document{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Submit hands in work to the presenter. Only the presenter keeps it; hubs
+// pass it on like other messages.
+func (e *EditorState) Submit(text string) error {
+	if text == "" {
+		return ErrEmptySubmission
+	}
+	if len(text) > MaxSubmissionSize {
+		return ErrSubmissionTooLarge
+	}
+	e.BroadcastMessage(messages.NewSubmissionMessage(text, e.nodeID, e.awareness.Local().UserName))
+	return nil
+}
+
+// Submissions returns the work handed in to the presenter, oldest first
+func (e *EditorState) Submissions() []Submission {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]Submission(nil), e.submissions...)
+}
+
+// handleSubmission keeps a student's work if presenting, reporting whether the
+// message should go on. The caller must hold e.mutex.
+func (e *EditorState) handleSubmission(msg *messages.Message) bool {
+	if msg.UserID == e.nodeID || msg.Text == "" || len(msg.Text) > MaxSubmissionSize {
+		return false
+	}
+	if !e.presenting {
+		return true
+	}
+	e.submissions = append(e.submissions, Submission{UserID: msg.UserID, UserName: msg.UserName, Text: msg.Text, Time: time.Now()})
+	if len(e.submissions) > maxSubmissions {
+		e.submissions = append([]Submission(nil), e.submissions[len(e.submissions)-maxSubmissions:]...)
+	}
+	return true
+}
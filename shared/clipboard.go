@@ -0,0 +1,76 @@
+package shared
+
+import (
+	"errors"
+	"time"
+
+	"gollaborate/messages"
+)
+
+const (
+	// MaxClipSize is the longest snippet, in bytes, that can be shared
+	MaxClipSize = 64 << 10
+	// maxClips is how many shared snippets the session clipboard keeps
+	maxClips = 16
+)
+
+var (
+	// ErrEmptyClip is returned when sharing an empty snippet
+	ErrEmptyClip = errors.New("nothing to share")
+	// ErrClipTooLarge is returned when sharing a snippet longer than MaxClipSize
+	ErrClipTooLarge = errors.New("snippet too large to share")
+)
+
+// Clip is a snippet on the session clipboard
+type Clip struct {
+	UserID   int
+	UserName string
+	Text     string
+	Time     time.Time // When it arrived, or was shared from here
+}
+
+// ShareClip puts a snippet on every participant's session clipboard, so it can
+// be handed over without pasting it into the document. Nothing is shared
+// unless a participant asks. The snippet is added to the local clipboard too.
+func (e *EditorState) ShareClip(text string) error {
+	if text == "" {
+		return ErrEmptyClip
+	}
+	if len(text) > MaxClipSize {
+		return ErrClipTooLarge
+	}
+	local := e.awareness.Local()
+
+	e.mutex.Lock()
+	e.addClip(Clip{UserID: e.nodeID, UserName: local.UserName, Text: text, Time: time.Now()})
+	e.mutex.Unlock()
+
+	e.BroadcastMessage(messages.NewClipMessage(text, e.nodeID, local.UserName))
+	return nil
+}
+
+// Clips returns the snippets on the session clipboard, newest last
+func (e *EditorState) Clips() []Clip {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]Clip(nil), e.clips...)
+}
+
+// addClip adds a snippet to the session clipboard, forgetting the oldest past
+// maxClips. The caller must hold e.mutex.
+func (e *EditorState) addClip(clip Clip) {
+	e.clips = append(e.clips, clip)
+	if len(e.clips) > maxClips {
+		e.clips = append([]Clip(nil), e.clips[len(e.clips)-maxClips:]...)
+	}
+}
+
+// handleClip adds a peer's snippet to the session clipboard, reporting whether
+// it was accepted. The caller must hold e.mutex.
+func (e *EditorState) handleClip(msg *messages.Message) bool {
+	if msg.UserID == e.nodeID || msg.Text == "" || len(msg.Text) > MaxClipSize {
+		return false
+	}
+	e.addClip(Clip{UserID: msg.UserID, UserName: msg.UserName, Text: msg.Text, Time: time.Now()})
+	return true
+}
@@ -0,0 +1,25 @@
+package shared
+
+import (
+	"gollaborate/messages"
+)
+
+// SetCodec sets the codec used with peers that can read it; others get JSON.
+// Peers learn what each other can read from their hellos, see Hello. Call it
+// before adding connections.
+func (e *EditorState) SetCodec(codec messages.Codec) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.codec = codec
+}
+
+// negotiateCodec picks the codec for writing to a peer from the codecs it reads
+func (e *EditorState) negotiateCodec(q *sendQueue, peerCodecs []string) {
+	preferred := e.codec
+	if preferred == nil {
+		preferred = messages.JSON
+	}
+	q.mutex.Lock()
+	q.codec = messages.Negotiate(preferred, peerCodecs)
+	q.mutex.Unlock()
+}
@@ -0,0 +1,75 @@
+package shared
+
+import (
+	"time"
+
+	"gollaborate/messages"
+)
+
+// dedupeWindow is how many of each node's latest broadcasts are remembered.
+// Older ones arriving now are taken to be duplicates: a copy that wandered the
+// mesh this long after the first has already been handled.
+const dedupeWindow = 1024
+
+// seenMessages remembers the broadcasts received from one node
+type seenMessages struct {
+	latest int64
+	seen   map[int64]bool
+}
+
+// stamp gives a broadcast this node starts its ID, on a copy since the message
+// may be in use elsewhere. Numbers start from the clock, so a node that
+// restarts does not reuse the numbers of its last run.
+func (e *EditorState) stamp(msg *messages.Message) *messages.Message {
+	if _, ok := msg.ID(); ok {
+		return msg
+	}
+	e.queueMutex.Lock()
+	if e.lastMessageSeq == 0 {
+		e.lastMessageSeq = time.Now().UnixMicro()
+	}
+	e.lastMessageSeq++
+	seq := e.lastMessageSeq
+	e.queueMutex.Unlock()
+
+	stamped := *msg
+	stamped.Origin, stamped.OriginSeq = e.nodeID, seq
+	return &stamped
+}
+
+// duplicate reports whether a message was already received, by another path
+// through the mesh or sent twice, or is one of this node's own coming back.
+// Messages without an ID are never duplicates.
+func (e *EditorState) duplicate(msg *messages.Message) bool {
+	id, ok := msg.ID()
+	if !ok {
+		return false
+	}
+	if id.Node == e.nodeID {
+		return true
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	s, ok := e.seenMessages[id.Node]
+	if !ok {
+		s = &seenMessages{seen: make(map[int64]bool)}
+		e.seenMessages[id.Node] = s
+	}
+	if s.seen[id.Seq] || id.Seq <= s.latest-dedupeWindow {
+		return true
+	}
+	s.seen[id.Seq] = true
+	if id.Seq > s.latest {
+		s.latest = id.Seq
+	}
+	// Forget what fell out of the window, now and then rather than every time
+	if len(s.seen) > 2*dedupeWindow {
+		for seq := range s.seen {
+			if seq <= s.latest-dedupeWindow {
+				delete(s.seen, seq)
+			}
+		}
+	}
+	return false
+}
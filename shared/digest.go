@@ -0,0 +1,137 @@
+package shared
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"gollaborate/messages"
+)
+
+// DefaultDigestInterval is how often a server sends its clients digests of
+// the document
+const DefaultDigestInterval = 30 * time.Second
+
+// digestVersion is the protocol version from which peers are sent digests
+const digestVersion = 8
+
+// EnableDigests sends every peer a digest of the document each interval: its
+// hash, and the latest clock seen from each user. A peer whose copy hashes
+// differently after the same edits has diverged, such as through a lost
+// message, and resyncs. Only peers whose hello says they read digests are
+// sent them.
+func (e *EditorState) EnableDigests(interval time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.digestStop != nil {
+		close(e.digestStop)
+	}
+	e.digestStop = make(chan struct{})
+	go e.digests(interval, e.digestStop)
+}
+
+// DisableDigests stops sending peers digests
+func (e *EditorState) DisableDigests() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.digestStop != nil {
+		close(e.digestStop)
+		e.digestStop = nil
+	}
+}
+
+// digests sends peers digests until stopped
+func (e *EditorState) digests(interval time.Duration, stop chan struct{}) {
+	defer e.crashReporter().Recover("digests")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			e.sendDigests()
+		}
+	}
+}
+
+// sendDigests sends a digest to every peer that reads them. It is queued with
+// the state locked, behind every edit it covers.
+func (e *EditorState) sendDigests() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.document == nil {
+		return
+	}
+	var msg *messages.Message
+	for _, conn := range e.conns {
+		if e.hellos[conn].Version < digestVersion {
+			continue
+		}
+		if msg == nil {
+			msg = messages.NewDigestMessage(e.digest(), e.oplog.clocks(), e.nodeID)
+		}
+		e.send(conn, msg)
+	}
+}
+
+// digest hashes the document's text. The caller must hold e.mutex.
+func (e *EditorState) digest() string {
+	sum := sha256.Sum256([]byte(e.document.ToText()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// handleDigest compares a peer's digest with our document, resyncing if they
+// differ. Edits the peer made before it are already here, as it queued the
+// digest behind them, so if it saw edits we lack we ask for them at once. A
+// difference otherwise may be our own edits on their way, so only one seen in
+// the next digest too is acted on: we send the peer our edits it lacks and ask
+// for those we lack, and if it persists after that, ask for the whole
+// document. The caller must hold e.mutex.
+func (e *EditorState) handleDigest(conn messages.Transport, msg *messages.Message) {
+	if e.document == nil {
+		return
+	}
+	if e.digest() == msg.Digest {
+		delete(e.diverged, conn)
+		return
+	}
+
+	ours := e.oplog.clocks()
+	e.diverged[conn]++
+	switch e.diverged[conn] {
+	case 1:
+		for userID, clock := range msg.Clocks {
+			if clock > ours[userID] {
+				e.send(conn, messages.NewCatchUpRequestMessage(ours, e.nodeID))
+				break
+			}
+		}
+	case 2:
+		e.sendOwnEdits(conn, msg.Clocks)
+		e.send(conn, messages.NewCatchUpRequestMessage(ours, e.nodeID))
+	default:
+		e.sendOwnEdits(conn, msg.Clocks)
+		e.send(conn, messages.NewResyncRequestMessage(e.nodeID))
+		delete(e.diverged, conn)
+	}
+}
+
+// sendOwnEdits sends a peer the edits this node made that it may lack, as the
+// clocks it saw say. The caller must hold e.mutex.
+func (e *EditorState) sendOwnEdits(conn messages.Transport, clocks map[int]int) {
+	logged, ok := e.oplog.since(clocks)
+	if !ok {
+		return
+	}
+	var ops []*messages.Operation
+	for _, op := range logged {
+		if op.UserID == e.nodeID {
+			ops = append(ops, op)
+		}
+	}
+	if len(ops) > 0 {
+		e.send(conn, messages.NewCatchUpMessage(ops, e.nodeID))
+	}
+}
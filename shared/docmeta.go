@@ -0,0 +1,79 @@
+package shared
+
+import (
+	"time"
+
+	"gollaborate/messages"
+)
+
+// DocMeta describes the document for title bars: its title, language, whether
+// the host refuses edits and when it was last saved. Peers are told about it
+// when they join and whenever it changes.
+func (e *EditorState) DocMeta() messages.DocMeta {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.docMetaLocked()
+}
+
+// SetTitle sets the document's title and announces it to every peer
+func (e *EditorState) SetTitle(title string) {
+	e.mutex.Lock()
+	e.docMeta.Title = title
+	meta := e.docMetaLocked()
+	e.mutex.Unlock()
+
+	e.BroadcastMessage(messages.NewDocMetaMessage(meta, e.nodeID))
+}
+
+// MarkSaved records when the document was saved and announces it to every peer
+func (e *EditorState) MarkSaved(at time.Time) {
+	e.mutex.Lock()
+	e.docMeta.SavedAt = at.UnixMilli()
+	meta := e.docMetaLocked()
+	e.mutex.Unlock()
+
+	e.BroadcastMessage(messages.NewDocMetaMessage(meta, e.nodeID))
+}
+
+// SendDocMeta sends the document's description to one connection, so a peer
+// that just joined can show it
+func (e *EditorState) SendDocMeta(conn messages.Transport) error {
+	return <-e.send(conn, messages.NewDocMetaMessage(e.DocMeta(), e.nodeID)).sent
+}
+
+// docMetaLocked describes the document. The caller must hold e.mutex.
+func (e *EditorState) docMetaLocked() messages.DocMeta {
+	meta := e.docMeta
+	if e.document != nil && e.document.Metadata.Language != "" {
+		meta.Language = e.document.Metadata.Language
+	}
+	meta.ReadOnly = meta.ReadOnly || e.readOnly
+	return meta
+}
+
+// handleDocMeta takes a peer's description of the document, reporting whether
+// it changed anything. The language is only a hint, taken if we have none or
+// do not originate the session. The caller must hold e.mutex.
+func (e *EditorState) handleDocMeta(msg *messages.Message) bool {
+	if msg.UserID == e.nodeID {
+		return false
+	}
+	meta := *msg.DocMeta
+	meta.SavedAt = max(meta.SavedAt, e.docMeta.SavedAt)
+	changed := false
+	if lang := meta.Language; lang != "" && e.document != nil && lang != e.document.Metadata.Language &&
+		(e.document.Metadata.Language == "" || !e.rolesAuthority) {
+		e.document.Metadata.Language = lang
+		changed = true
+	}
+	meta.Language = ""
+	if e.readOnly {
+		// We refuse edits ourselves, and say so whatever peers repeat back
+		meta.ReadOnly = false
+	}
+	if meta == e.docMeta && !changed {
+		return false
+	}
+	e.docMeta = meta
+	return true
+}
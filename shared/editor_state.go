@@ -1,23 +1,151 @@
 package shared
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net"
 	"sync"
+	"time"
 
+	"gollaborate/crash"
 	"gollaborate/crdt"
 	"gollaborate/messages"
+	"gollaborate/presence"
 )
 
 // MessageListener is a function that receives messages
 type MessageListener func(*messages.Message)
 
+// ConnListener is a function that receives messages along with the connection they arrived on
+type ConnListener func(messages.Transport, *messages.Message)
+
+// ErrorHandler is a function that is told about connection and protocol errors
+type ErrorHandler func(messages.Transport, error)
+
+// CloseListener is a function told about each connection once it is dropped
+type CloseListener func(messages.Transport)
+
 type EditorState struct {
-	document   *crdt.Document
-	nodeID     int
-	conns      []net.Conn
-	mutex      sync.Mutex
-	listeners  []MessageListener
-	currentClock int
+	document      *crdt.Document
+	nodeID        int
+	conns         []messages.Transport
+	mutex         sync.Mutex
+	listeners     []MessageListener
+	connListeners []ConnListener
+	closeListeners []CloseListener
+	errorHandler  ErrorHandler
+	currentClock  int
+
+	// relay forwards messages received from one connection to all others
+	relay bool
+	// readOnly rejects operations received from peers
+	readOnly bool
+	// spectating refuses local edits, see spectate.go
+	spectating bool
+	// The document's title and when it was saved, as set here or told by
+	// peers, see docmeta.go
+	docMeta messages.DocMeta
+
+	// Participant roles and protected regions, assigned by the session originator
+	roles          map[int]messages.Role
+	joinerRole     messages.Role
+	rolesAuthority bool
+
+	// Checks run on every operation before it is applied, see AddValidator
+	validators []Validator
+
+	// Every participant's cursor, selection, name and color, see presence.go
+	awareness *presence.Awareness
+	// The connection each peer's presence arrived on
+	presenceConns map[int]messages.Transport
+	// The peers known to be in the session, and whether each is idle, and the
+	// same for this participant, see participants.go
+	present     map[int]bool
+	idle        bool
+	idleTimeout time.Duration
+	lastActive  time.Time
+
+	// Messages waiting to be sent to each connection, and the least time
+	// between presence messages to each, see queue.go
+	queueMutex       sync.Mutex
+	queues           map[messages.Transport]*sendQueue
+	presenceInterval time.Duration
+	// What bounds each connection's queue, see overflow.go
+	sendLimit      int
+	overflowPolicy OverflowPolicy
+	// The goroutines writing queued messages to peers, and how long a write
+	// may take, see writers.go
+	writers      writerPool
+	writeTimeout time.Duration
+	// The number of the last broadcast this node started, and the broadcasts
+	// received from each node, see dedupe.go
+	lastMessageSeq int64
+	seenMessages   map[int]*seenMessages
+	// Preferred encoding for peers that can read it, see codec.go
+	codec messages.Codec
+	// What each peer said in its hello, see hello.go
+	hellos map[messages.Transport]PeerInfo
+	// The names and colors peers changed to, see userinfo.go
+	userInfo map[int]PeerInfo
+	// When each connection was last heard from, see heartbeat.go
+	lastSeen      map[messages.Transport]time.Time
+	heartbeatStop chan struct{}
+	// Digests of the document sent to peers, and how many in a row from each
+	// peer differed from ours, see digest.go
+	digestStop chan struct{}
+	diverged   map[messages.Transport]int
+	// How long edits may go unacknowledged, and the edits received on each
+	// connection, see sequence.go
+	retransmitAfter time.Duration
+	inbound         map[messages.Transport]*inbound
+
+	// Recovers panics in the goroutines handling connections, nil to let them crash
+	crashes *crash.Reporter
+
+	// Local operations waiting to be sent together, see batch.go
+	batchMutex  sync.Mutex
+	batch       []*messages.Operation
+	batchTimer  *time.Timer
+	batchWindow time.Duration
+	batchSize   int
+
+	// The latest operations, for peers catching up, see catchup.go
+	oplog opLog
+	// The edits in the order a hub gave them, see order.go
+	orders orderLog
+	// Everyone in the room, as the server last listed them, see roster.go
+	roster []messages.RosterEntry
+	// How peers joining are synced, and what their hellos said about it, see sync.go
+	syncCoordinator SyncCoordinator
+	syncOffers      map[messages.Transport]*syncOffer
+
+	// Refuses edits from peers beyond their quotas, see limit.go
+	editLimiter EditLimiter
+	// Told about every change to the document, see changelog.go
+	changeLogger ChangeLogger
+	loggedDoc    *crdt.Document
+
+	// Snippets shared by participants, oldest first, see clipboard.go
+	clips []Clip
+	// The session chat, oldest first, see chat.go
+	chat []ChatMessage
+	// Files shared in the session, oldest first, see attachment.go
+	attachments []*attachment
+	// Whether presenting to a class, and the work students handed in, see classroom.go
+	presenting  bool
+	submissions []Submission
+
+	// Actions that need the other editors' approval, and our proposals waiting
+	// for votes, see approval.go
+	quorums         map[messages.TransactionAction]float64
+	approvalTimeout time.Duration
+	proposals       map[int64]*proposal
+	nextProposal    int64
+
+	// Latency probes waiting for an answer, by probe ID, see probe.go
+	probes    map[int64]chan probeAck
+	nextProbe int64
 }
 
 // For testing purposes
@@ -25,15 +153,44 @@ func (e *EditorState) SetDocument(doc *crdt.Document) {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
 	e.document = doc
+	e.watchChanges()
 }
 
 func NewEditorState(doc *crdt.Document, nodeID int) *EditorState {
 	return &EditorState{
-		document:   doc,
-		nodeID:     nodeID,
-		conns:      []net.Conn{},
-		listeners:  []MessageListener{},
+		document:     doc,
+		nodeID:       nodeID,
+		conns:        []messages.Transport{},
+		listeners:    []MessageListener{},
 		currentClock: 1,
+		roles:        make(map[int]messages.Role),
+
+		awareness:     presence.New(nodeID),
+		presenceConns: make(map[int]messages.Transport),
+		present:       make(map[int]bool),
+		idleTimeout:   DefaultIdleTimeout,
+		lastActive:    time.Now(),
+		queues:        make(map[messages.Transport]*sendQueue),
+		hellos:        make(map[messages.Transport]PeerInfo),
+		userInfo:      make(map[int]PeerInfo),
+		lastSeen:      make(map[messages.Transport]time.Time),
+		diverged:      make(map[messages.Transport]int),
+		seenMessages:  make(map[int]*seenMessages),
+		syncOffers:    make(map[messages.Transport]*syncOffer),
+		inbound:       make(map[messages.Transport]*inbound),
+		probes:        make(map[int64]chan probeAck),
+		quorums:       make(map[messages.TransactionAction]float64),
+		proposals:     make(map[int64]*proposal),
+
+		retransmitAfter:  DefaultRetransmitTimeout,
+		presenceInterval: time.Second / DefaultPresenceRate,
+		batchWindow:      DefaultBatchWindow,
+		batchSize:        DefaultBatchSize,
+		approvalTimeout:  DefaultApprovalTimeout,
+		syncCoordinator:  SyncCoordinator{FullSyncSize: DefaultFullSyncSize},
+		sendLimit:        DefaultSendQueueLimit,
+		writers:          writerPool{max: DefaultWriters},
+		writeTimeout:     DefaultWriteTimeout,
 	}
 }
 
@@ -47,25 +204,52 @@ func (e *EditorState) NodeID() int {
 	return e.nodeID
 }
 
-func (e *EditorState) AddConn(conn net.Conn) {
+// AddConn starts exchanging messages with a peer over a connection. The
+// transport returned stands for the peer in the other methods.
+func (e *EditorState) AddConn(conn messages.Conn) messages.Transport {
+	t := messages.NewConnTransport(conn)
+	e.AddTransport(t)
+	return t
+}
+
+// AddTransport starts exchanging messages with a peer over a transport
+func (e *EditorState) AddTransport(conn messages.Transport) {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
 	e.conns = append(e.conns, conn)
+	e.lastSeen[conn] = time.Now()
+	e.syncOffers[conn] = &syncOffer{said: make(chan struct{})}
+
+	var q *sendQueue
+	q = newSendQueue(func() { e.scheduleWrite(conn, q) }, func() {
+		e.reportError(conn, ErrSlowPeer)
+		e.removeConnection(conn)
+	})
+	e.queueMutex.Lock()
+	q.presenceInterval = e.presenceInterval
+	q.limit, q.policy = e.sendLimit, e.overflowPolicy
+	e.queues[conn] = q
+	e.queueMutex.Unlock()
 	
 	// Start listening for messages from this connection
 	go e.listenForMessages(conn)
 }
 
-func (e *EditorState) Connections() []net.Conn {
+func (e *EditorState) Connections() []messages.Transport {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
 	
 	// Return a copy to avoid concurrent modification issues
-	connsCopy := make([]net.Conn, len(e.conns))
+	connsCopy := make([]messages.Transport, len(e.conns))
 	copy(connsCopy, e.conns)
 	return connsCopy
 }
 
+// RemoveConn closes a connection and stops tracking it
+func (e *EditorState) RemoveConn(conn messages.Transport) {
+	e.removeConnection(conn)
+}
+
 // AddMessageListener adds a function to be called when a message is received
 func (e *EditorState) AddMessageListener(listener MessageListener) {
 	e.mutex.Lock()
@@ -73,38 +257,156 @@ func (e *EditorState) AddMessageListener(listener MessageListener) {
 	e.listeners = append(e.listeners, listener)
 }
 
-// BroadcastMessage sends a message to all connected peers
+// AddConnListener adds a function to be called with each received message and its source connection
+func (e *EditorState) AddConnListener(listener ConnListener) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.connListeners = append(e.connListeners, listener)
+}
+
+// AddCloseListener adds a function to be called with each connection once it
+// was closed and stopped being tracked
+func (e *EditorState) AddCloseListener(listener CloseListener) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.closeListeners = append(e.closeListeners, listener)
+}
+
+// SetCrashReporter makes a panic while handling a connection report a crash and
+// drop that connection rather than end the process
+func (e *EditorState) SetCrashReporter(reporter *crash.Reporter) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.crashes = reporter
+}
+
+// crashReporter returns the reporter set with SetCrashReporter
+func (e *EditorState) crashReporter() *crash.Reporter {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.crashes
+}
+
+// SetErrorHandler sets the function told about connection and protocol errors
+func (e *EditorState) SetErrorHandler(handler ErrorHandler) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.errorHandler = handler
+}
+
+// SetRelay enables forwarding of received messages to every other connection,
+// which lets a node act as a hub for peers that are not connected to each other
+func (e *EditorState) SetRelay(relay bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.relay = relay
+}
+
+// SetReadOnly controls whether operations received from peers are rejected
+// and tells peers when that changes
+func (e *EditorState) SetReadOnly(readOnly bool) {
+	e.mutex.Lock()
+	changed := e.readOnly != readOnly
+	e.readOnly = readOnly
+	meta := e.docMetaLocked()
+	e.mutex.Unlock()
+
+	if changed {
+		e.BroadcastMessage(messages.NewDocMetaMessage(meta, e.nodeID))
+	}
+}
+
+// ReadOnly reports whether operations received from peers are rejected
+func (e *EditorState) ReadOnly() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.readOnly
+}
+
+// BroadcastMessage queues a message for all connected peers. It does not wait
+// for the message to be sent; failed connections are reported and removed.
 func (e *EditorState) BroadcastMessage(msg *messages.Message) {
-	conns := e.Connections()
-	for _, conn := range conns {
-		err := messages.SendMessage(conn, msg)
-		if err != nil {
-			// Handle error, maybe remove the connection
-			e.removeConnection(conn)
+	e.logOperations(msg)
+	if e.batchOperation(msg) {
+		return
+	}
+	// Nothing overtakes the operations waiting in a batch
+	e.batchMutex.Lock()
+	defer e.batchMutex.Unlock()
+	e.flushBatch()
+	e.broadcastExcept(nil, msg)
+}
+
+// broadcastExcept queues a message for all connected peers other than the source
+func (e *EditorState) broadcastExcept(source messages.Transport, msg *messages.Message) []*queuedMessage {
+	if source == nil {
+		msg = e.stamp(msg)
+	}
+	e.queueMutex.Lock()
+	defer e.queueMutex.Unlock()
+	msg = e.order(msg)
+
+	var queued []*queuedMessage
+	for conn, q := range e.queues {
+		// An ordered edit goes back to its source too, to log where it fell
+		if conn != source || (msg.Order != 0 && q.takesOrder()) {
+			queued = append(queued, q.push(msg))
 		}
 	}
+	return queued
+}
+
+// reportError passes an error to the error handler, if one is set
+func (e *EditorState) reportError(conn messages.Transport, err error) {
+	e.mutex.Lock()
+	handler := e.errorHandler
+	e.mutex.Unlock()
+
+	if handler != nil {
+		handler(conn, err)
+	}
+}
+
+// refuse tells a peer why its message was refused, with a code for the kind of
+// error, and reports it. The connection stays up.
+func (e *EditorState) refuse(conn messages.Transport, code messages.ErrorCode, err error) {
+	e.send(conn, messages.NewCodedErrorMessage(err.Error(), code, e.nodeID))
+	e.reportError(conn, err)
 }
 
 // InsertCharacter inserts a character into the document and broadcasts the operation
 func (e *EditorState) InsertCharacter(char rune, pos []crdt.Identifier) error {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
+	return e.insertCharacter(char, pos)
+}
+
+// insertCharacter is InsertCharacter for callers that hold e.mutex
+func (e *EditorState) insertCharacter(char rune, pos []crdt.Identifier) error {
+	if e.roleOf(e.nodeID) == messages.RoleReadOnly {
+		return ErrReadOnly
+	}
 	
 	// Update local clock
 	e.currentClock++
 	clock := e.currentClock
 	
+	// Create the operation and let validators check it
+	op := messages.NewInsertOperation(pos, char, e.nodeID, clock)
+	if err := e.validateLocal(op); err != nil {
+		return err
+	}
+	
 	// Apply to local document
-	err := e.document.InsertCharacter(char, pos, clock)
+	err := e.document.InsertCharacter(op.Character, op.Position, op.Clock)
 	if err != nil {
 		return err
 	}
 	
-	// Create and broadcast operation
-	op := messages.NewInsertOperation(pos, char, e.nodeID, clock)
+	// Broadcast operation
 	msg := messages.NewOperationMessage(op)
 	
-	go e.BroadcastMessage(msg)
+	e.BroadcastMessage(msg)
 	return nil
 }
 
@@ -112,22 +414,35 @@ func (e *EditorState) InsertCharacter(char rune, pos []crdt.Identifier) error {
 func (e *EditorState) DeleteCharacter(pos []crdt.Identifier) error {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
+	return e.deleteCharacter(pos)
+}
+
+// deleteCharacter is DeleteCharacter for callers that hold e.mutex
+func (e *EditorState) deleteCharacter(pos []crdt.Identifier) error {
+	if e.roleOf(e.nodeID) == messages.RoleReadOnly {
+		return ErrReadOnly
+	}
 	
 	// Update local clock
 	e.currentClock++
 	clock := e.currentClock
 	
+	// Create the operation and let validators check it
+	op := messages.NewDeleteOperation(pos, e.nodeID, clock)
+	if err := e.validateLocal(op); err != nil {
+		return err
+	}
+	
 	// Apply to local document
-	err := e.document.DeleteCharacter(pos)
+	err := e.document.DeleteCharacter(op.Position)
 	if err != nil {
 		return err
 	}
 	
-	// Create and broadcast operation
-	op := messages.NewDeleteOperation(pos, e.nodeID, clock)
+	// Broadcast operation
 	msg := messages.NewOperationMessage(op)
 	
-	go e.BroadcastMessage(msg)
+	e.BroadcastMessage(msg)
 	return nil
 }
 
@@ -138,54 +453,304 @@ func (e *EditorState) SyncDocument() {
 	e.mutex.Unlock()
 	
 	msg := messages.NewSyncMessage(doc, e.nodeID)
-	go e.BroadcastMessage(msg)
+	e.BroadcastMessage(msg)
 }
 
 // listenForMessages continuously listens for messages from a connection
-func (e *EditorState) listenForMessages(conn net.Conn) {
+func (e *EditorState) listenForMessages(conn messages.Transport) {
 	for {
-		msg, err := messages.ReceiveMessage(conn)
+		msg, err := conn.Receive()
+		var decodeErr *messages.DecodeError
+		if errors.As(err, &decodeErr) {
+			// Garbled, but the messages after it can still be read
+			e.refuse(conn, messages.ErrorCodeMalformed, err)
+			continue
+		}
 		if err != nil {
-			// Connection likely closed
+			// Connection likely closed; only unexpected failures are worth reporting
+			if !isClosedError(err) {
+				e.reportError(conn, err)
+			}
 			e.removeConnection(conn)
 			return
 		}
 		
-		// Handle the message
-		e.handleMessage(msg)
+		// Handle the message, and any held back waiting for it
+		for _, msg := range e.sequence(conn, msg) {
+			if err := msg.Validate(); err != nil {
+				e.refuse(conn, messages.ErrorCodeInvalid, err)
+				continue
+			}
+			// Logged in the hub's order even if it turns out to be a duplicate
+			e.trackOrder(conn, msg)
+			if e.duplicate(msg) {
+				continue
+			}
+			if !e.handleSafely(conn, msg) {
+				return
+			}
+		}
 	}
 }
 
+// handleSafely handles a message, dropping the connection if that panics. It
+// reports whether the connection is still usable.
+func (e *EditorState) handleSafely(conn messages.Transport, msg *messages.Message) (ok bool) {
+	reporter := e.crashReporter()
+	defer func() {
+		if reporter.Handle(fmt.Sprintf("handling %s message from user %d", msg.Type, msg.UserID), recover()) {
+			e.removeConnection(conn)
+			ok = false
+		}
+	}()
+	e.handleMessage(conn, msg)
+	return true
+}
+
 // handleMessage processes incoming messages and updates state
-func (e *EditorState) handleMessage(msg *messages.Message) {
+func (e *EditorState) handleMessage(conn messages.Transport, msg *messages.Message) {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
+	if _, ok := e.lastSeen[conn]; ok {
+		e.lastSeen[conn] = time.Now()
+	}
 	
+	// The originator assigns a role to each participant when first heard from
+	if roles := e.noteParticipant(msg.UserID); roles != nil {
+		e.BroadcastMessage(messages.NewRolesMessage(roles, e.nodeID))
+	}
+
 	switch msg.Type {
-	case messages.MessageTypeOperation:
-		if msg.Operation != nil && msg.Operation.UserID != e.nodeID {
-			op := msg.Operation
-			switch op.Type {
-			case messages.OperationTypeInsert:
-				_ = e.document.InsertCharacter(op.Character, op.Position, op.Clock)
-			case messages.OperationTypeDelete:
-				_ = e.document.DeleteCharacter(op.Position)
+	case messages.MessageTypeOperation, messages.MessageTypeTransaction, messages.MessageTypeBatch:
+		ops := operationsOf(msg)
+		if e.refuseForgedOwn(conn, ops) {
+			return
+		}
+		// What is left carrying this node's ID is its own edits, echoed back
+		if len(ops) > 0 && ops[0].UserID != e.nodeID {
+			if !e.acceptEdits(conn, ops) {
+				return
+			}
+			// Transactions are applied under a single lock so no one sees them half done
+			applied := false
+			for _, op := range ops {
+				if e.applyOperation(op) {
+					applied = true
+				}
 			}
+			if !applied {
+				// A retransmission, or edits a sync already delivered; nothing to pass on
+				return
+			}
+			e.oplog.add(ops)
+			e.flagProtected(conn, ops)
+		}
+	case messages.MessageTypeCatchUpRequest:
+		e.handleCatchUpRequest(conn, msg)
+		return
+	case messages.MessageTypeDigest:
+		e.handleDigest(conn, msg)
+		return
+	case messages.MessageTypeCatchUp:
+		if !e.handleCatchUp(conn, msg) {
+			return
 		}
+	case messages.MessageTypeOrderRequest:
+		e.handleOrderRequest(conn, msg)
+		return
+	case messages.MessageTypeRoster:
+		e.roster = msg.Roster
 	case messages.MessageTypeSync:
 		if msg.Document != nil && msg.UserID != e.nodeID {
-			e.document = msg.Document
+			// A hub checks what a sync would change as edits from its sender
+			if (e.relay || e.rolesAuthority) && e.document != nil && !e.acceptEdits(conn, e.syncOperations(msg)) {
+				return
+			}
+			// Merge rather than replace so local edits that were not broadcast yet survive
+			if e.document == nil {
+				e.document = msg.Document
+			} else {
+				e.document.Merge(msg.Document)
+			}
+			e.watchChanges()
+		}
+	case messages.MessageTypeRoles:
+		if msg.UserID != e.nodeID {
+			e.applyRoles(msg.Roles)
+		}
+	case messages.MessageTypePermission:
+		if !e.handlePermission(msg) {
+			return
+		}
+	case messages.MessageTypeMetadata:
+		if msg.UserID != e.nodeID {
+			e.applyMetadata(msg.Metadata)
+		}
+	case messages.MessageTypeHello:
+		// Listeners learn who the peer is, but other peers have their own hellos
+		if !e.handleHello(conn, msg) {
+			return
+		}
+	case messages.MessageTypePing:
+		e.send(conn, messages.NewPongMessage(e.nodeID))
+		return
+	case messages.MessageTypePong:
+		// Only shows the peer is alive
+		return
+	case messages.MessageTypeProbe:
+		// Echo at once; probes are between two connected peers and go no further
+		e.send(conn, messages.NewProbeAckMessage(msg.ProbeID, e.nodeID))
+		return
+	case messages.MessageTypeAck:
+		if msg.ProbeID != 0 {
+			e.answerProbe(msg)
+			return
+		}
+		if msg.Seq != 0 {
+			e.handleSeqAck(conn, msg.Seq)
+			return
+		}
+	case messages.MessageTypeClip:
+		if !e.handleClip(msg) {
+			return
+		}
+	case messages.MessageTypeSubmission:
+		if !e.handleSubmission(msg) {
+			return
+		}
+	case messages.MessageTypeChat:
+		if !e.handleChat(msg) {
+			return
+		}
+	case messages.MessageTypeAttachment:
+		if !e.handleAttachment(msg) {
+			return
+		}
+	case messages.MessageTypeAttachmentChunk:
+		if !e.handleAttachmentChunk(conn, msg) {
+			return
+		}
+	case messages.MessageTypeAttachmentRequest:
+		// Answered by whoever has the chunks; the request goes no further
+		e.handleAttachmentRequest(conn, msg)
+		return
+	case messages.MessageTypeDocMeta:
+		if !e.handleDocMeta(msg) {
+			return
+		}
+	case messages.MessageTypeUserInfo:
+		if !e.handleUserInfo(conn, msg) {
+			return
+		}
+	case messages.MessageTypePresence:
+		if !e.handlePresenceEvent(msg) {
+			return
+		}
+	case messages.MessageTypeProposal:
+		if msg.UserID == e.nodeID {
+			return
+		}
+	case messages.MessageTypeVote:
+		if !e.handleVote(msg) {
+			return
+		}
+	case messages.MessageTypeAwareness:
+		if msg.UserID != e.nodeID {
+			changed := e.applyPresence(conn, msg.Presence)
+			if len(changed) == 0 {
+				// Already known; stopping here keeps presence from circling a mesh forever
+				return
+			}
+			msg.Presence = changed
 		}
 	}
 	
+	// Forward to the other peers when acting as a hub
+	if e.relay && isRelayed(msg.Type) {
+		e.broadcastExcept(conn, msg)
+	}
+
 	// Notify listeners
 	for _, listener := range e.listeners {
 		go listener(msg)
 	}
+	for _, listener := range e.connListeners {
+		go listener(conn, msg)
+	}
+}
+
+// acceptEdits checks a peer's operations before they reach the document,
+// refusing them all, and telling the peer why, if the document or the sender
+// may not be edited or any operation is invalid. The caller must hold e.mutex.
+func (e *EditorState) acceptEdits(conn messages.Transport, ops []*messages.Operation) bool {
+	if len(ops) == 0 {
+		return true
+	}
+	// Only whoever froze the document refuses edits, as those it took
+	// before freezing may reach its peers after the news
+	if e.rolesAuthority && e.frozen() {
+		go func() {
+			e.send(conn, messages.NewCodedErrorMessage(ErrFrozen.Error(), messages.ErrorCodeFrozen, e.nodeID))
+			e.reportError(conn, fmt.Errorf("rejected operation from user %d: document is frozen", ops[0].UserID))
+		}()
+		return false
+	}
+	if e.readOnly {
+		// Reject the operations and tell the sender why
+		go func() {
+			e.send(conn, messages.NewErrorMessage("document is read-only", e.nodeID))
+			e.reportError(conn, fmt.Errorf("rejected operation from user %d: document is read-only", ops[0].UserID))
+		}()
+		return false
+	}
+	for _, op := range ops {
+		if e.roleOf(op.UserID) == messages.RoleReadOnly {
+			// Read-only participants should never send edits; drop any that arrive
+			go func() {
+				e.send(conn, messages.NewCodedErrorMessage(ErrReadOnly.Error(), messages.ErrorCodeForbidden, e.nodeID))
+				e.reportError(conn, fmt.Errorf("rejected operation from read-only user %d", op.UserID))
+			}()
+			return false
+		}
+	}
+	if !e.limitEdits(conn, ops) {
+		return false
+	}
+	for _, op := range ops {
+		if err := e.validate(op); err != nil {
+			// Reject the whole message so a transaction is never half applied
+			go func() {
+				e.send(conn, messages.NewErrorMessage(err.Error(), e.nodeID))
+				e.reportError(conn, fmt.Errorf("rejected operation from user %d: %w", op.UserID, err))
+			}()
+			return false
+		}
+	}
+	return true
+}
+
+// applyOperation applies a single remote operation to the document, reporting
+// whether it changed anything
+func (e *EditorState) applyOperation(op *messages.Operation) bool {
+	switch op.Type {
+	case messages.OperationTypeInsert:
+		return e.document.InsertCharacter(op.Character, op.Position, op.Clock) == nil
+	case messages.OperationTypeDelete:
+		return e.document.DeleteCharacter(op.Position) == nil
+	}
+	return false
+}
+
+// operationsOf returns the operations carried by an operation, transaction or batch message
+func operationsOf(msg *messages.Message) []*messages.Operation {
+	if msg.Operation != nil {
+		return []*messages.Operation{msg.Operation}
+	}
+	return msg.Operations
 }
 
 // removeConnection removes a connection from the connection list
-func (e *EditorState) removeConnection(conn net.Conn) {
+func (e *EditorState) removeConnection(conn messages.Transport) {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
 	
@@ -195,7 +760,45 @@ func (e *EditorState) removeConnection(conn net.Conn) {
 			_ = conn.Close()
 			// Remove from slice
 			e.conns = append(e.conns[:i], e.conns[i+1:]...)
+			// Whoever was present through this connection has gone
+			e.dropPresence(conn)
+			e.peerLeft(conn)
+			delete(e.hellos, conn)
+			e.offerSync(conn, &messages.Message{})
+			delete(e.syncOffers, conn)
+			delete(e.lastSeen, conn)
+			delete(e.diverged, conn)
+			delete(e.inbound, conn)
+			for _, listener := range e.closeListeners {
+				go listener(conn)
+			}
 			break
 		}
 	}
-}
\ No newline at end of file
+
+	e.queueMutex.Lock()
+	defer e.queueMutex.Unlock()
+	if q, ok := e.queues[conn]; ok {
+		q.close()
+		delete(e.queues, conn)
+	}
+}
+
+// isClosedError reports whether an error just means the connection was closed
+func isClosedError(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe)
+}
+
+// isRelayed reports whether a hub forwards messages of the given type to other peers.
+// Sync, init and error messages are addressed to a single connection and stay local.
+func isRelayed(msgType messages.MessageType) bool {
+	switch msgType {
+	case messages.MessageTypeOperation, messages.MessageTypeTransaction, messages.MessageTypeBatch,
+		messages.MessageTypeAwareness, messages.MessageTypeRoles, messages.MessageTypeMetadata,
+		messages.MessageTypeClip, messages.MessageTypeChat, messages.MessageTypeUserInfo, messages.MessageTypePresence, messages.MessageTypeProposal, messages.MessageTypeVote,
+		messages.MessageTypeSubmission, messages.MessageTypeAttachment, messages.MessageTypeAttachmentChunk, messages.MessageTypeDocMeta,
+		messages.MessageTypePermission:
+		return true
+	}
+	return false
+}
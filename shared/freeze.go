@@ -0,0 +1,34 @@
+package shared
+
+import (
+	"errors"
+
+	"gollaborate/crdt"
+)
+
+// ErrFrozen is returned for edits to a frozen document
+var ErrFrozen = errors.New("the document is frozen: it is final and takes no more edits")
+
+// SetFrozen freezes the document, or thaws it again. A frozen document is
+// final: every edit to it is refused, ours included, and peers' with an error
+// coded messages.ErrorCodeFrozen. Being part of the document's metadata, it is
+// announced to peers, which refuse edits themselves, and kept when the
+// document is saved.
+func (e *EditorState) SetFrozen(frozen bool) {
+	e.updateMetadata(func(d *crdt.Document) {
+		d.Metadata.Frozen = frozen
+	})
+}
+
+// Frozen reports whether the document is frozen, here or by the session's
+// originator
+func (e *EditorState) Frozen() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.frozen()
+}
+
+// frozen is Frozen for callers that hold e.mutex
+func (e *EditorState) frozen() bool {
+	return e.document != nil && e.document.Metadata.Frozen
+}
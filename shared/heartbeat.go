@@ -0,0 +1,92 @@
+package shared
+
+import (
+	"errors"
+	"time"
+
+	"gollaborate/messages"
+)
+
+const (
+	// DefaultHeartbeatInterval is how often peers are pinged
+	DefaultHeartbeatInterval = 5 * time.Second
+	// DefaultHeartbeatMisses is how many intervals a peer may stay silent before it is dropped
+	DefaultHeartbeatMisses = 3
+)
+
+// heartbeatVersion is the protocol version from which peers answer pings
+const heartbeatVersion = 3
+
+// ErrPeerTimedOut is reported for a peer dropped for missing its heartbeats
+var ErrPeerTimedOut = errors.New("peer missed its heartbeats")
+
+// EnableHeartbeat pings peers every interval and drops those not heard from in
+// misses intervals, so a dead connection does not linger as connected. Any
+// message counts as hearing from a peer. Only peers whose hello says they
+// answer pings are pinged or dropped.
+func (e *EditorState) EnableHeartbeat(interval time.Duration, misses int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.heartbeatStop != nil {
+		close(e.heartbeatStop)
+	}
+	e.heartbeatStop = make(chan struct{})
+	go e.heartbeat(interval, time.Duration(misses)*interval, e.heartbeatStop)
+}
+
+// DisableHeartbeat stops pinging peers
+func (e *EditorState) DisableHeartbeat() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.heartbeatStop != nil {
+		close(e.heartbeatStop)
+		e.heartbeatStop = nil
+	}
+}
+
+// LastSeen returns when a message last arrived on a connection, or when it was
+// added if none has
+func (e *EditorState) LastSeen(conn messages.Transport) (time.Time, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	seen, ok := e.lastSeen[conn]
+	return seen, ok
+}
+
+// heartbeat pings peers and drops silent ones until stopped
+func (e *EditorState) heartbeat(interval, timeout time.Duration, stop chan struct{}) {
+	defer e.crashReporter().Recover("heartbeat")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			for _, conn := range e.heartbeatPeers() {
+				seen, ok := e.LastSeen(conn)
+				if ok && now.Sub(seen) > timeout {
+					e.reportError(conn, ErrPeerTimedOut)
+					e.removeConnection(conn)
+					continue
+				}
+				e.send(conn, messages.NewPingMessage(e.nodeID))
+			}
+		}
+	}
+}
+
+// heartbeatPeers returns the connections whose peers answer pings
+func (e *EditorState) heartbeatPeers() []messages.Transport {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var conns []messages.Transport
+	for _, conn := range e.conns {
+		if e.hellos[conn].Version >= heartbeatVersion {
+			conns = append(conns, conn)
+		}
+	}
+	return conns
+}
@@ -0,0 +1,99 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+
+	"gollaborate/messages"
+)
+
+// ErrIncompatible is reported for a peer that speaks no protocol version we do
+var ErrIncompatible = errors.New("incompatible protocol version")
+
+// PeerInfo is what a peer said about itself in its hello
+type PeerInfo struct {
+	Version  int // The protocol version agreed with the peer
+	UserID   int
+	UserName string
+	Color    string
+}
+
+// Hello introduces this participant to a peer: the protocol versions and codecs
+// this build speaks, and the name and color set with SetPresence. The side that
+// dials a connection says hello after adding it; the other side answers. Peers
+// that never say hello are taken to speak protocol version 1 and JSON.
+func (e *EditorState) Hello(conn messages.Transport) {
+	e.queueMutex.Lock()
+	q, ok := e.queues[conn]
+	e.queueMutex.Unlock()
+	if ok {
+		e.greet(q)
+	}
+}
+
+// Peer returns what the peer on a connection said in its hello, if it said one
+func (e *EditorState) Peer(conn messages.Transport) (PeerInfo, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	info, ok := e.hellos[conn]
+	return info, ok
+}
+
+// greet queues our hello for a peer unless it already has one, reporting whether it was queued
+func (e *EditorState) greet(q *sendQueue) bool {
+	q.mutex.Lock()
+	greeted := q.greeted
+	q.greeted = true
+	q.mutex.Unlock()
+	if greeted {
+		return false
+	}
+
+	local := e.awareness.Local()
+	hello := messages.NewHelloMessage(e.nodeID, local.UserName, local.Color)
+	hello.Strategies, hello.Clocks = syncStrategies, e.oplog.clocks()
+	q.push(hello)
+	return true
+}
+
+// handleHello answers a peer's hello and settles the protocol version and codec
+// to use with it. A peer with no version in common is told why and disconnected.
+// It reports whether the peer was accepted. The caller must hold e.mutex.
+func (e *EditorState) handleHello(conn messages.Transport, msg *messages.Message) bool {
+	e.queueMutex.Lock()
+	q, ok := e.queues[conn]
+	e.queueMutex.Unlock()
+	if !ok {
+		return false
+	}
+	// Our hello tells the peer what we speak, even if we go on to refuse it
+	e.greet(q)
+
+	version, ok := messages.AgreeVersion(msg.Version, msg.MinVersion)
+	if !ok {
+		err := fmt.Errorf("%w: peer speaks %d to %d, we speak %d to %d", ErrIncompatible,
+			max(msg.MinVersion, 1), max(msg.Version, 1), messages.MinProtocolVersion, messages.ProtocolVersion)
+		refusal := q.push(messages.NewErrorMessage(err.Error(), e.nodeID))
+		go func() {
+			<-refusal.sent
+			e.reportError(conn, err)
+			e.removeConnection(conn)
+		}()
+		return false
+	}
+
+	e.negotiateCodec(q, msg.Codecs)
+	e.offerSync(conn, msg)
+	if version >= sequenceVersion {
+		e.startSequencing(conn, q)
+	}
+	q.mutex.Lock()
+	q.batches = version >= batchVersion
+	q.ordered = version >= orderVersion
+	q.rosters = version >= rosterVersion
+	q.mutex.Unlock()
+	info := PeerInfo{Version: version, UserID: msg.UserID, UserName: msg.UserName, Color: msg.Color}
+	e.hellos[conn] = info
+	e.peerJoined(conn, info)
+	return true
+}
@@ -0,0 +1,49 @@
+package shared
+
+import (
+	"errors"
+	"time"
+
+	"gollaborate/messages"
+)
+
+// ErrNoHistory is returned when restoring a document that does not keep a history
+var ErrNoHistory = errors.New("document has no history")
+
+// RestoreTo edits the document back to how it was at the given time and sends
+// the change to every peer as a single transaction. It returns the number of
+// characters inserted and deleted. If restoring needs approval, see
+// RequireApproval, it first waits for the other editors' votes.
+func (e *EditorState) RestoreTo(t time.Time) (int, error) {
+	e.mutex.Lock()
+	hasHistory := e.document != nil && e.document.History() != nil
+	e.mutex.Unlock()
+	if !hasHistory {
+		return 0, ErrNoHistory
+	}
+	if err := e.approve(messages.TransactionActionRestore, "restore the document to how it was at "+t.Format("15:04:05")); err != nil {
+		return 0, err
+	}
+
+	e.mutex.Lock()
+	if e.document == nil || e.document.History() == nil {
+		e.mutex.Unlock()
+		return 0, ErrNoHistory
+	}
+	past := e.document.History().ReplayTo(t)
+	inserted, deleted := e.document.RevertTo(past)
+
+	ops := make([]*messages.Operation, 0, len(inserted)+len(deleted))
+	for _, char := range deleted {
+		ops = append(ops, messages.NewDeleteOperation(char.Pos, e.nodeID, char.Clock))
+	}
+	for _, char := range inserted {
+		ops = append(ops, messages.NewInsertOperation(char.Pos, char.Value, e.nodeID, char.Clock))
+	}
+	e.mutex.Unlock()
+
+	if len(ops) > 0 {
+		go e.BroadcastMessage(messages.NewTransactionMessage(ops, messages.TransactionActionRestore, e.nodeID, ""))
+	}
+	return len(ops), nil
+}
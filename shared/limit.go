@@ -0,0 +1,101 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gollaborate/messages"
+)
+
+// ErrForgedOwn is returned for operations a peer sends as this node's own
+var ErrForgedOwn = errors.New("operations carry the user ID of the node they are sent to")
+
+// LimitError refuses edits beyond a quota. Its code and retry delay are sent to
+// the peer, so the client can tell throttling apart from other refusals.
+type LimitError struct {
+	Code       messages.ErrorCode
+	Reason     string
+	RetryAfter time.Duration // For throttled edits, when they may be sent again
+}
+
+func (e *LimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%s; retry in %s", e.Reason, e.RetryAfter.Round(time.Second))
+	}
+	return e.Reason
+}
+
+// EditLimiter decides whether to accept the operations of a message from a
+// peer, returning a *LimitError to refuse them all. The peer is what the sender
+// said in its hello, zero if it said none; the user IDs the operations carry
+// are the sender's say too. It runs before validators and the operations reach
+// the document, with the state locked, so it must not call back into the
+// editor state.
+type EditLimiter func(conn messages.Transport, peer PeerInfo, ops []*messages.Operation) *LimitError
+
+// SetEditLimiter sets the limiter for edits from peers, such as a server's
+// per-user quotas. nil accepts everything.
+func (e *EditorState) SetEditLimiter(limiter EditLimiter) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.editLimiter = limiter
+}
+
+// limitEdits checks remote operations against the limiter, refusing them with
+// a limit error to the sender. The caller must hold e.mutex.
+func (e *EditorState) limitEdits(conn messages.Transport, ops []*messages.Operation) bool {
+	if e.editLimiter == nil || len(ops) == 0 {
+		return true
+	}
+	limitErr := e.editLimiter(conn, e.hellos[conn], ops)
+	if limitErr == nil {
+		return true
+	}
+	go func() {
+		e.send(conn, messages.NewLimitErrorMessage(limitErr.Error(), limitErr.Code, limitErr.RetryAfter, e.nodeID))
+		e.reportError(conn, fmt.Errorf("refused %d operation(s): %w", len(ops), limitErr))
+	}()
+	return false
+}
+
+// refuseForgedOwn refuses operations that carry this node's own user ID but
+// cannot be its edits coming back: a hub sends out its edits and never takes
+// them in again, and no peer mixes them in with others. Taken in, they would
+// pass for the node's own echoes and skip every check. It reports whether it
+// refused them. The caller must hold e.mutex.
+func (e *EditorState) refuseForgedOwn(conn messages.Transport, ops []*messages.Operation) bool {
+	own := 0
+	for _, op := range ops {
+		if op.UserID == e.nodeID {
+			own++
+		}
+	}
+	if own == 0 || (own == len(ops) && !e.relay) {
+		return false
+	}
+	go func() {
+		e.send(conn, messages.NewCodedErrorMessage(ErrForgedOwn.Error(), messages.ErrorCodeForbidden, e.nodeID))
+		e.reportError(conn, fmt.Errorf("refused %d operation(s): %w", len(ops), ErrForgedOwn))
+	}()
+	return true
+}
+
+// unapplied returns the operations that would change the document, leaving
+// out those it already has. The caller must hold e.mutex.
+func (e *EditorState) unapplied(ops []*messages.Operation) []*messages.Operation {
+	var fresh []*messages.Operation
+	for _, op := range ops {
+		switch op.Type {
+		case messages.OperationTypeInsert:
+			if !e.document.HasCharacter(op.Position, op.Clock) {
+				fresh = append(fresh, op)
+			}
+		case messages.OperationTypeDelete:
+			if _, err := e.document.OffsetOfPosition(op.Position); err == nil {
+				fresh = append(fresh, op)
+			}
+		}
+	}
+	return fresh
+}
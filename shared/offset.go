@@ -0,0 +1,25 @@
+package shared
+
+// InsertAtOffset inserts a character at an offset in the document, counted in
+// characters from 0, and broadcasts the operation. See crdt.Document.PositionAtOffset.
+func (e *EditorState) InsertAtOffset(offset int, char rune) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	pos, err := e.document.GeneratePositionAtOffset(offset, e.nodeID)
+	if err != nil {
+		return err
+	}
+	return e.insertCharacter(char, pos)
+}
+
+// DeleteAtOffset deletes the character at an offset in the document and
+// broadcasts the operation
+func (e *EditorState) DeleteAtOffset(offset int) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	pos, err := e.document.PositionAtOffset(offset)
+	if err != nil {
+		return err
+	}
+	return e.deleteCharacter(pos)
+}
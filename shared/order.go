@@ -0,0 +1,248 @@
+package shared
+
+import (
+	"sync"
+	"time"
+
+	"gollaborate/messages"
+)
+
+// orderVersion is the protocol version from which peers are sent back the
+// edits they sent a hub, ordered, so that their log has them in the same place
+// as everyone else's
+const orderVersion = 6
+
+// orderRequestWait is how long a client waits for the edits it asked a hub for
+// before asking again
+const orderRequestWait = time.Second
+
+// orderLog keeps edits in the order a hub put them in. A hub numbers every edit
+// it passes on; its clients follow the numbers, asking again for any they miss,
+// so every log holds the same edits in the same order.
+type orderLog struct {
+	mutex    sync.Mutex
+	ordering bool                        // Whether this node numbers edits, as a hub
+	started  bool                        // Whether a client was told where the numbers start
+	last     int64                       // The last number given, or received without a gap
+	known    int64                       // The last number a client knows the hub gave
+	msgs     []*messages.Message         // The latest edits, numbered up to last
+	held     map[int64]*messages.Message // Arrived ahead of a gap, by number
+	asked    int64                       // The number last asked for, and when
+	askedAt  time.Time
+}
+
+// SetOrdering makes a hub number every edit it passes on, so that its clients
+// can tell when they missed one and all log edits in the same order. Call it
+// before adding connections.
+func (e *EditorState) SetOrdering(ordering bool) {
+	e.orders.mutex.Lock()
+	defer e.orders.mutex.Unlock()
+	e.orders.ordering = ordering
+}
+
+// LastOrder returns the number of the last edit a hub ordered, or of the last
+// a client received from it with none missing before it
+func (e *EditorState) LastOrder() int64 {
+	e.orders.mutex.Lock()
+	defer e.orders.mutex.Unlock()
+	return e.orders.last
+}
+
+// OrderedOps returns the operations of the logged edits numbered from order
+// on, in order. A client's log starts when it joined, and every log keeps only
+// the latest edits.
+func (e *EditorState) OrderedOps(order int64) []*messages.Operation {
+	msgs, _ := e.orders.from(order)
+	var ops []*messages.Operation
+	for _, msg := range msgs {
+		ops = append(ops, operationsOf(msg)...)
+	}
+	return ops
+}
+
+// order numbers an edit a hub passes on, on a copy since the message may be in
+// use elsewhere, and logs it. Other messages, and those passed on by nodes that
+// are not ordering, are returned as they are. The caller must hold e.queueMutex,
+// so that edits are queued for every peer in the order they were numbered.
+func (e *EditorState) order(msg *messages.Message) *messages.Message {
+	l := &e.orders
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.ordering || !isSequenced(msg.Type) {
+		return msg
+	}
+	ordered := *msg
+	l.last++
+	ordered.Order = l.last
+	l.log(&ordered)
+	return &ordered
+}
+
+// takesOrder reports whether the peer is sent back the edits it sent, ordered
+func (q *sendQueue) takesOrder() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.ordered
+}
+
+// log adds the next edit to the log. The caller must hold l.mutex.
+func (l *orderLog) log(msg *messages.Message) {
+	l.msgs = append(l.msgs, msg)
+	if excess := len(l.msgs) - maxLoggedOps; excess > 0 {
+		l.msgs = append([]*messages.Message(nil), l.msgs[excess:]...)
+	}
+}
+
+// from returns the logged edits numbered from order on. ok is false if some of
+// them were already dropped from the log.
+func (l *orderLog) from(order int64) (msgs []*messages.Message, ok bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	first := l.last - int64(len(l.msgs)) + 1
+	if order < first {
+		return append([]*messages.Message(nil), l.msgs...), false
+	}
+	if order > l.last {
+		return nil, true
+	}
+	return append([]*messages.Message(nil), l.msgs[order-first:]...), true
+}
+
+// nextOrder returns the number of the next edit a hub will order, or zero if
+// this node does not order edits. Syncs and catch-ups carry it to tell the
+// client where its log starts. The caller must hold e.mutex, so that the edits
+// already ordered are those sent.
+func (e *EditorState) nextOrder() int64 {
+	e.orders.mutex.Lock()
+	defer e.orders.mutex.Unlock()
+	if !e.orders.ordering {
+		return 0
+	}
+	return e.orders.last + 1
+}
+
+// trackOrder follows the numbers of the edits, syncs and catch-ups a hub sends,
+// asking it again for edits that went missing
+func (e *EditorState) trackOrder(conn messages.Transport, msg *messages.Message) {
+	if msg.Order == 0 {
+		return
+	}
+	l := &e.orders
+	l.mutex.Lock()
+	if l.ordering {
+		// We give the numbers
+		l.mutex.Unlock()
+		return
+	}
+	switch msg.Type {
+	case messages.MessageTypeSync:
+		// The whole document: the log starts over after it
+		l.restart(msg.Order)
+	case messages.MessageTypeCatchUp:
+		if !l.started {
+			l.restart(msg.Order)
+		} else {
+			// Caught up on the edits but maybe not their order, such as
+			// after reconnecting; any gap is asked for below
+			l.known = max(l.known, msg.Order-1)
+		}
+	case messages.MessageTypeOperation, messages.MessageTypeTransaction, messages.MessageTypeBatch:
+		l.receive(msg)
+	default:
+		l.mutex.Unlock()
+		return
+	}
+	missing := l.missing()
+	l.mutex.Unlock()
+
+	if missing != 0 {
+		e.send(conn, messages.NewOrderRequestMessage(missing, e.nodeID))
+	}
+}
+
+// restart starts the log again before the edit numbered next. The caller must
+// hold l.mutex.
+func (l *orderLog) restart(next int64) {
+	l.started, l.last, l.msgs = true, next-1, nil
+	l.known = max(l.known, l.last)
+	for order := range l.held {
+		if order < next {
+			delete(l.held, order)
+		}
+	}
+	l.drain()
+}
+
+// receive logs an edit if it is the next one, or holds it until those before
+// it arrive. The caller must hold l.mutex.
+func (l *orderLog) receive(msg *messages.Message) {
+	if l.held == nil {
+		l.held = make(map[int64]*messages.Message)
+	}
+	l.known = max(l.known, msg.Order)
+	switch {
+	case l.started && msg.Order <= l.last:
+		// Logged already
+	case l.started && msg.Order == l.last+1:
+		l.last++
+		l.log(msg)
+		l.drain()
+	case len(l.held) < maxHeld:
+		l.held[msg.Order] = msg
+	}
+}
+
+// drain logs the held edits that follow on without a gap. The caller must hold
+// l.mutex.
+func (l *orderLog) drain() {
+	for {
+		msg, ok := l.held[l.last+1]
+		if !ok {
+			return
+		}
+		delete(l.held, l.last+1)
+		l.last++
+		l.log(msg)
+	}
+}
+
+// missing returns the number of the first edit missing, if it is time to ask
+// for it, or zero. The caller must hold l.mutex.
+func (l *orderLog) missing() int64 {
+	if !l.started || l.known <= l.last {
+		return 0
+	}
+	if l.asked == l.last+1 && time.Since(l.askedAt) < orderRequestWait {
+		return 0
+	}
+	l.asked, l.askedAt = l.last+1, time.Now()
+	return l.asked
+}
+
+// handleOrderRequest sends a client the edits it missed, from the number it
+// asked for on, or the whole document if they are no longer logged. The caller
+// must hold e.mutex.
+func (e *EditorState) handleOrderRequest(conn messages.Transport, msg *messages.Message) {
+	e.orders.mutex.Lock()
+	ordering := e.orders.ordering
+	e.orders.mutex.Unlock()
+	if !ordering {
+		return
+	}
+	msgs, ok := e.orders.from(msg.Order)
+	if ok {
+		for _, msg := range msgs {
+			e.send(conn, msg)
+		}
+		return
+	}
+
+	doc, err := e.documentCopy()
+	if err != nil {
+		go e.reportError(conn, err)
+		return
+	}
+	sync := messages.NewSyncMessage(doc, e.nodeID)
+	sync.Order = e.nextOrder()
+	e.send(conn, sync)
+}
@@ -0,0 +1,73 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// OverflowPolicy says what happens to a peer whose send queue is full, because
+// it takes messages more slowly than the session makes them
+type OverflowPolicy int
+
+const (
+	// OverflowDisconnect drops the peer, which can then rejoin and be synced
+	// afresh. Nothing it was sent is lost without it knowing.
+	OverflowDisconnect OverflowPolicy = iota
+	// OverflowDrop keeps the peer and drops the messages that do not fit. The
+	// peer misses those edits until it is next synced, so this suits peers that
+	// only watch, such as a dashboard.
+	OverflowDrop
+)
+
+const (
+	// DefaultSendQueueLimit is how many edits, and separately how many
+	// attachment chunks, may wait to be sent to a peer at once
+	DefaultSendQueueLimit = 4096
+	// DefaultWriteTimeout is how long a write to a peer may take before the
+	// peer is taken to have stalled and is dropped
+	DefaultWriteTimeout = 10 * time.Second
+)
+
+// ErrSlowPeer is reported for a peer dropped because it could not keep up
+var ErrSlowPeer = errors.New("peer is not keeping up")
+
+// writeDeadliner is a transport whose writes can be given a deadline, such as
+// one over a network connection
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// SetSendQueueLimit bounds the messages waiting to be sent to each peer, so a
+// peer whose connection stalled cannot make them pile up without end. Past
+// limit edits, the policy decides whether the peer is dropped or the edits
+// are; attachment chunks past it are always dropped, and peers fetch them
+// again with ResumeAttachment. Presence has its own smaller bound, past which
+// the oldest is dropped. Zero or less means no limit.
+func (e *EditorState) SetSendQueueLimit(limit int, policy OverflowPolicy) {
+	e.queueMutex.Lock()
+	defer e.queueMutex.Unlock()
+	e.sendLimit, e.overflowPolicy = limit, policy
+	for _, q := range e.queues {
+		q.mutex.Lock()
+		q.limit, q.policy = limit, policy
+		q.mutex.Unlock()
+	}
+}
+
+// SetWriteTimeout sets how long a write to a peer may take before the peer is
+// dropped as stalled. Zero or less waits as long as the write takes.
+func (e *EditorState) SetWriteTimeout(timeout time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.writeTimeout = timeout
+}
+
+// slowPeerError says a write failed because the peer stalled, if it did
+func slowPeerError(err error) error {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrSlowPeer, err)
+	}
+	return err
+}
@@ -0,0 +1,135 @@
+package shared
+
+import (
+	"time"
+
+	"gollaborate/messages"
+	"gollaborate/presence"
+)
+
+// DefaultIdleTimeout is how long the local participant may do nothing before
+// peers are told they are idle
+const DefaultIdleTimeout = 5 * time.Minute
+
+// SetIdleTimeout sets how long the local participant may do nothing before
+// peers are told they are idle. Zero never goes idle.
+func (e *EditorState) SetIdleTimeout(timeout time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.idleTimeout = timeout
+}
+
+// Active records that the local participant did something, such as press a key.
+// If they were idle, peers are told they are back.
+func (e *EditorState) Active() {
+	e.mutex.Lock()
+	e.lastActive = time.Now()
+	wasIdle := e.idle
+	e.idle = false
+	e.mutex.Unlock()
+
+	if wasIdle {
+		e.announcePresence(messages.PresenceActive)
+	}
+}
+
+// IsIdle reports whether a participant, possibly this one, is idle
+func (e *EditorState) IsIdle(userID int) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if userID == e.nodeID {
+		return e.idle
+	}
+	return e.present[userID]
+}
+
+// checkIdle tells peers the local participant is idle once they have done
+// nothing for the idle timeout
+func (e *EditorState) checkIdle() {
+	e.mutex.Lock()
+	if e.idle || e.idleTimeout <= 0 || time.Since(e.lastActive) < e.idleTimeout {
+		e.mutex.Unlock()
+		return
+	}
+	e.idle = true
+	e.mutex.Unlock()
+
+	e.announcePresence(messages.PresenceIdle)
+}
+
+// announcePresence tells every peer about a presence event of the local participant
+func (e *EditorState) announcePresence(event messages.PresenceEvent) {
+	e.BroadcastMessage(messages.NewPresenceMessage(event, e.awareness.Local(), e.nodeID))
+}
+
+// peerJoined announces that the peer which said hello on a connection joined.
+// A hub passes this on, since its peers only hear of each other through it.
+// The caller must hold e.mutex.
+func (e *EditorState) peerJoined(conn messages.Transport, info PeerInfo) {
+	if _, ok := e.present[info.UserID]; ok || info.UserID == e.nodeID {
+		return
+	}
+	e.present[info.UserID] = false
+	e.emitPresence(conn, messages.PresenceJoin, info)
+}
+
+// peerLeft announces that the peer which said hello on a closed connection
+// left, unless it said so itself. The caller must hold e.mutex.
+func (e *EditorState) peerLeft(conn messages.Transport) {
+	info, ok := e.hellos[conn]
+	if !ok {
+		return
+	}
+	if _, ok := e.present[info.UserID]; !ok {
+		return
+	}
+	delete(e.present, info.UserID)
+	e.emitPresence(conn, messages.PresenceLeave, info)
+}
+
+// emitPresence tells message listeners, and the other peers when acting as a
+// hub, about a presence event this node saw on a connection. The caller must
+// hold e.mutex.
+func (e *EditorState) emitPresence(conn messages.Transport, event messages.PresenceEvent, info PeerInfo) {
+	state := presence.State{UserID: info.UserID, UserName: info.UserName, Color: info.Color}
+	msg := messages.NewPresenceMessage(event, state, e.nodeID)
+	if e.relay {
+		e.broadcastExcept(conn, msg)
+	}
+	e.notifyPresence(msg)
+}
+
+// handlePresenceEvent records a presence event from a peer, reporting whether it
+// told us anything new. The caller must hold e.mutex.
+func (e *EditorState) handlePresenceEvent(msg *messages.Message) bool {
+	if len(msg.Presence) == 0 || msg.Presence[0].UserID == e.nodeID {
+		return false
+	}
+	userID := msg.Presence[0].UserID
+	idle, known := e.present[userID]
+	switch msg.Event {
+	case messages.PresenceJoin:
+		if known {
+			return false
+		}
+		e.present[userID] = false
+	case messages.PresenceLeave:
+		if !known {
+			return false
+		}
+		delete(e.present, userID)
+	case messages.PresenceIdle:
+		if known && idle {
+			return false
+		}
+		e.present[userID] = true
+	case messages.PresenceActive:
+		if known && !idle {
+			return false
+		}
+		e.present[userID] = false
+	default:
+		return false
+	}
+	return true
+}
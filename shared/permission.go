@@ -0,0 +1,48 @@
+package shared
+
+import (
+	"gollaborate/messages"
+)
+
+// SetPermission grants a participant write access, or revokes it, mid-session,
+// and tells every peer. Hosts and servers enforce it by refusing the
+// participant's edits; the participant's own editor stops taking them too.
+// Read-only participants cannot change anyone's access.
+func (e *EditorState) SetPermission(userID int, canWrite bool) error {
+	role := messages.RoleReadOnly
+	if canWrite {
+		role = messages.RoleEditor
+	}
+
+	e.mutex.Lock()
+	if e.roleOf(e.nodeID) == messages.RoleReadOnly {
+		e.mutex.Unlock()
+		return ErrReadOnly
+	}
+	e.roles[userID] = role
+	e.mutex.Unlock()
+
+	e.BroadcastMessage(messages.NewPermissionMessage(userID, role, e.nodeID))
+	return nil
+}
+
+// handlePermission applies a peer's grant or revocation of write access,
+// reporting whether it changed anything. Like role announcements, it is
+// ignored by the session originator, which assigns roles itself, and it is
+// ignored from participants without write access. The caller must hold e.mutex.
+func (e *EditorState) handlePermission(msg *messages.Message) bool {
+	if msg.UserID == e.nodeID || e.rolesAuthority || e.roleOf(msg.UserID) == messages.RoleReadOnly {
+		return false
+	}
+	changed := false
+	for userID, role := range msg.Roles {
+		if role != messages.RoleEditor && role != messages.RoleReadOnly {
+			continue
+		}
+		if e.roleOf(userID) != role {
+			e.roles[userID] = role
+			changed = true
+		}
+	}
+	return changed
+}
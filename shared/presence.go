@@ -0,0 +1,138 @@
+package shared
+
+import (
+	"time"
+
+	"gollaborate/messages"
+	"gollaborate/presence"
+)
+
+// leaveTimeout is how long LeavePresence waits for slow peers
+const leaveTimeout = time.Second
+
+// SetPresence changes what this participant tells peers about themselves, such
+// as their cursor or name, and broadcasts it. Presence is sent after any edits
+// waiting for the same peer, so peers know the characters it refers to.
+func (e *EditorState) SetPresence(update func(*presence.State)) {
+	state := e.awareness.SetLocal(update)
+	e.BroadcastMessage(messages.NewAwarenessMessage([]presence.State{state}, e.nodeID))
+}
+
+// LeavePresence tells every peer this participant is leaving. It waits, up to
+// leaveTimeout, for the message to be sent, so call it just before closing connections.
+func (e *EditorState) LeavePresence() {
+	e.announcePresence(messages.PresenceLeave)
+	state := e.awareness.Leave()
+	queued := e.broadcastExcept(nil, messages.NewAwarenessMessage([]presence.State{state}, e.nodeID))
+
+	timeout := time.After(leaveTimeout)
+	for _, item := range queued {
+		select {
+		case <-item.sent:
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// Presence returns the state of every online participant, including this one,
+// sorted by user ID
+func (e *EditorState) Presence() []presence.State {
+	return e.awareness.States()
+}
+
+// LocalPresence returns what this participant tells peers about themselves
+func (e *EditorState) LocalPresence() presence.State {
+	return e.awareness.Local()
+}
+
+// RenewPresence keeps this participant's presence from timing out at peers,
+// takes offline the peers that have not been heard from within the timeout and
+// tells peers if this participant went idle. Call it regularly, more often than
+// every half timeout.
+func (e *EditorState) RenewPresence() {
+	e.checkIdle()
+	if state, ok := e.awareness.Renew(); ok {
+		go e.BroadcastMessage(messages.NewAwarenessMessage([]presence.State{state}, e.nodeID))
+	}
+
+	// Every peer times out stale states on its own, so this is not broadcast
+	if expired := e.awareness.Expire(); len(expired) > 0 {
+		e.mutex.Lock()
+		e.notifyPresence(messages.NewAwarenessMessage(expired, e.nodeID))
+		e.mutex.Unlock()
+	}
+}
+
+// SendPresence sends every presence state this node knows to one connection, so
+// a new peer sees who is already here
+func (e *EditorState) SendPresence(conn messages.Transport) error {
+	states := e.awareness.All()
+	if len(states) == 0 {
+		return nil
+	}
+	return <-e.send(conn, messages.NewAwarenessMessage(states, e.nodeID)).sent
+}
+
+// applyPresence merges presence states received on a connection and returns the
+// ones that changed anything. The caller must hold e.mutex.
+func (e *EditorState) applyPresence(conn messages.Transport, states []presence.State) []presence.State {
+	changed := e.awareness.Apply(states)
+	for _, state := range changed {
+		if state.Offline {
+			delete(e.presenceConns, state.UserID)
+		} else {
+			e.presenceConns[state.UserID] = conn
+		}
+	}
+	return changed
+}
+
+// dropPresence takes offline the peers whose presence arrived on a closed
+// connection. A hub passes this on, since its peers only hear of each other
+// through it. The caller must hold e.mutex.
+func (e *EditorState) dropPresence(conn messages.Transport) {
+	var userIDs []int
+	for userID, c := range e.presenceConns {
+		if c == conn {
+			userIDs = append(userIDs, userID)
+			delete(e.presenceConns, userID)
+		}
+	}
+	removed := e.awareness.Remove(userIDs...)
+	if len(removed) == 0 {
+		return
+	}
+
+	msg := messages.NewAwarenessMessage(removed, e.nodeID)
+	if e.relay {
+		go e.BroadcastMessage(msg)
+	}
+	e.notifyPresence(msg)
+}
+
+// ForgetPresence takes users offline, wherever their presence was heard, and
+// has every peer keep nothing of them but their IDs
+func (e *EditorState) ForgetPresence(userIDs ...int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, userID := range userIDs {
+		delete(e.presenceConns, userID)
+	}
+	forgotten := e.awareness.Forget(userIDs...)
+	if len(forgotten) == 0 {
+		return
+	}
+
+	msg := messages.NewAwarenessMessage(forgotten, e.nodeID)
+	go e.BroadcastMessage(msg)
+	e.notifyPresence(msg)
+}
+
+// notifyPresence tells message listeners about presence changes this node made
+// up itself. The caller must hold e.mutex.
+func (e *EditorState) notifyPresence(msg *messages.Message) {
+	for _, listener := range e.listeners {
+		go listener(msg)
+	}
+}
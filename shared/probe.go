@@ -0,0 +1,89 @@
+package shared
+
+import (
+	"errors"
+	"time"
+
+	"gollaborate/messages"
+)
+
+// ErrNoAnswer is the result for a peer that did not echo a probe in time
+var ErrNoAnswer = errors.New("no answer")
+
+// ProbeResult is the measured round trip to one connected peer
+type ProbeResult struct {
+	Conn   messages.Transport
+	UserID int // The peer that answered, 0 if none did
+	RTT    time.Duration
+	Err    error
+}
+
+// probeAck is the answer to a probe and when it arrived
+type probeAck struct {
+	userID int
+	at     time.Time
+}
+
+// Probe measures the round trip to every connected peer by sending each a probe
+// and timing the acknowledgment. Probes wait behind queued edits like an edit
+// would, so the result is the delay users experience. Probe waits up to timeout
+// and returns one result per connection, in the order of Connections.
+func (e *EditorState) Probe(timeout time.Duration) []ProbeResult {
+	conns := e.Connections()
+	ids := make([]int64, len(conns))
+	acks := make([]chan probeAck, len(conns))
+
+	e.mutex.Lock()
+	for i := range conns {
+		e.nextProbe++
+		ids[i] = e.nextProbe
+		acks[i] = make(chan probeAck, 1)
+		e.probes[ids[i]] = acks[i]
+	}
+	e.mutex.Unlock()
+
+	start := time.Now()
+	for i, conn := range conns {
+		e.send(conn, messages.NewProbeMessage(ids[i], e.nodeID))
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	expired := false
+	results := make([]ProbeResult, len(conns))
+	for i, conn := range conns {
+		results[i] = ProbeResult{Conn: conn, Err: ErrNoAnswer}
+		var ack probeAck
+		var ok bool
+		if expired {
+			select {
+			case ack, ok = <-acks[i]:
+			default:
+			}
+		} else {
+			select {
+			case ack, ok = <-acks[i]:
+			case <-timer.C:
+				expired = true
+			}
+		}
+		if ok {
+			results[i] = ProbeResult{Conn: conn, UserID: ack.userID, RTT: ack.at.Sub(start)}
+		}
+	}
+
+	e.mutex.Lock()
+	for _, id := range ids {
+		delete(e.probes, id)
+	}
+	e.mutex.Unlock()
+	return results
+}
+
+// answerProbe records the acknowledgment of one of our probes. The caller must hold e.mutex.
+func (e *EditorState) answerProbe(msg *messages.Message) {
+	if ack, ok := e.probes[msg.ProbeID]; ok {
+		ack <- probeAck{userID: msg.UserID, at: time.Now()}
+		delete(e.probes, msg.ProbeID)
+	}
+}
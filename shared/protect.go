@@ -0,0 +1,78 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+)
+
+// ErrProtected is returned, wrapped with the region's name, for local edits to a
+// protected region
+var ErrProtected = errors.New("text is protected")
+
+// Protect marks the characters from first to last as a protected region and
+// announces it to every peer. Local edits inside protected regions are refused;
+// edits from peers are applied but reported to the error handler. Like assigning
+// roles, this is for the session originator, which then ignores metadata announced by others.
+func (e *EditorState) Protect(name string, first, last []crdt.Identifier) {
+	e.updateMetadata(func(doc *crdt.Document) {
+		doc.Protect(name, first, last)
+	})
+}
+
+// Unprotect removes a protected region and announces the change to every peer
+func (e *EditorState) Unprotect(name string) {
+	e.updateMetadata(func(doc *crdt.Document) {
+		doc.Unprotect(name)
+	})
+}
+
+// updateMetadata changes the document metadata as the session originator and
+// broadcasts the result
+func (e *EditorState) updateMetadata(update func(*crdt.Document)) {
+	e.mutex.Lock()
+	if e.document == nil {
+		e.mutex.Unlock()
+		return
+	}
+	e.rolesAuthority = true
+	update(e.document)
+	msg := messages.NewMetadataMessage(e.document.Metadata, e.nodeID)
+	e.mutex.Unlock()
+
+	go e.BroadcastMessage(msg)
+}
+
+// applyMetadata replaces the document metadata with an announcement from the
+// originator. The caller must hold e.mutex.
+func (e *EditorState) applyMetadata(metadata *crdt.Metadata) {
+	if e.rolesAuthority || metadata == nil || e.document == nil {
+		return
+	}
+	e.document.Metadata = *metadata
+}
+
+// checkProtected refuses an operation inside a protected region. The caller must hold e.mutex.
+func (e *EditorState) checkProtected(op *messages.Operation) error {
+	if e.document == nil {
+		return nil
+	}
+	if r, ok := e.document.ProtectedAt(op.Position); ok {
+		return fmt.Errorf("%w: %q", ErrProtected, r.Name)
+	}
+	return nil
+}
+
+// flagProtected reports remote operations that changed a protected region. The
+// caller must hold e.mutex.
+func (e *EditorState) flagProtected(conn messages.Transport, ops []*messages.Operation) {
+	for _, op := range ops {
+		if r, ok := e.document.ProtectedAt(op.Position); ok {
+			err := fmt.Errorf("user %d edited protected region %q", op.UserID, r.Name)
+			go e.reportError(conn, err)
+			return
+		}
+	}
+}
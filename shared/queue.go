@@ -0,0 +1,339 @@
+package shared
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"gollaborate/messages"
+	"gollaborate/presence"
+)
+
+// Priority says which lane of a peer's send queue a message waits in
+type Priority int
+
+const (
+	// PriorityHigh is for edits and document state, which peers need to stay in sync
+	PriorityHigh Priority = iota
+	// PriorityLow is for presence and cursors, which the next update supersedes anyway
+	PriorityLow
+	// PriorityBulk is for attachments, which may be large and can wait behind
+	// everything else without holding up the session
+	PriorityBulk
+)
+
+// DefaultPresenceRate is how many presence messages, such as cursor moves, each
+// peer is sent per second at most
+const DefaultPresenceRate = 20
+
+// maxLowQueued caps the low priority lane of each peer's send queue. Past it the
+// oldest messages are dropped; presence is renewed regularly, so peers catch up.
+const maxLowQueued = 256
+
+// errDropped is the result of a message dropped from a full low priority lane
+var errDropped = errors.New("dropped from a full send queue")
+
+// PriorityOf returns the lane messages of a type are sent in
+func PriorityOf(msgType messages.MessageType) Priority {
+	switch msgType {
+	case messages.MessageTypeAwareness, messages.MessageTypePresence:
+		return PriorityLow
+	case messages.MessageTypeAttachment, messages.MessageTypeAttachmentChunk:
+		return PriorityBulk
+	}
+	return PriorityHigh
+}
+
+// queuedMessage is a message waiting to be sent
+type queuedMessage struct {
+	msg      *messages.Message
+	sent     chan error // Receives the result of sending, once
+	numbered bool       // A retransmission, which keeps its sequence number
+	// Messages coalesced into this one, which are sent when it is
+	coalesced []*queuedMessage
+}
+
+// finish reports the result of sending the message, and of those coalesced into it
+func (item *queuedMessage) finish(err error) {
+	item.sent <- err
+	for _, other := range item.coalesced {
+		other.finish(err)
+	}
+}
+
+// sendQueue holds the messages waiting to be sent to one peer. Messages leave
+// each lane in the order they were queued, and the high priority lane is always
+// emptied first, so edits get through before presence on a slow connection,
+// and both before attachments.
+// Presence queued behind presence is merged into it, so however fast cursors
+// move, only their latest positions wait to be sent, and presence held back by
+// the rate limit keeps taking in the latest until it goes.
+// A queue with messages ready is handed to the writer pool, see writers.go.
+type sendQueue struct {
+	mutex  sync.Mutex
+	lanes  [PriorityBulk + 1][]*queuedMessage
+	closed bool
+	// Hands the queue to a writer, and whether it is with one already
+	schedule  func()
+	scheduled bool
+	// How many edits and attachment chunks may wait, what happens past that,
+	// and what drops the peer, see overflow.go
+	limit    int
+	policy   OverflowPolicy
+	overflow func()
+
+	// The least time between presence messages, when the last was sent, and
+	// the timer scheduling the queue once the next may go
+	presenceInterval time.Duration
+	lastPresence     time.Time
+	presenceTimer    *time.Timer
+
+	// How messages are encoded for this peer, and whether we said hello to it
+	codec   messages.Codec
+	greeted bool
+
+	// Whether edits are numbered for this peer, the last number given and the
+	// edits it has not acknowledged, see sequence.go
+	sequenced bool
+	lastSeq   int64
+	unacked   []*unackedMessage
+	// Whether the peer reads batches, see batch.go
+	batches bool
+	// Whether the peer is sent back the edits it sent, ordered, see order.go
+	ordered bool
+	// Whether the peer reads rosters, see roster.go
+	rosters bool
+}
+
+func newSendQueue(schedule, overflow func()) *sendQueue {
+	return &sendQueue{codec: messages.JSON, schedule: schedule, overflow: overflow}
+}
+
+// push adds a message to the lane for its type. A batch is split into its
+// operations for a peer that cannot read batches; the item returned is then
+// the last of them, sent after the others.
+func (q *sendQueue) push(msg *messages.Message) *queuedMessage {
+	q.mutex.Lock()
+	batches := q.batches
+	q.mutex.Unlock()
+	if batches {
+		return q.add(&queuedMessage{msg: msg, sent: make(chan error, 1)})
+	}
+
+	var item *queuedMessage
+	for _, msg := range unbatch(msg) {
+		item = q.add(&queuedMessage{msg: msg, sent: make(chan error, 1)})
+	}
+	return item
+}
+
+// resend queues a numbered message again, keeping its number
+func (q *sendQueue) resend(msg *messages.Message) *queuedMessage {
+	return q.add(&queuedMessage{msg: msg, sent: make(chan error, 1), numbered: true})
+}
+
+// add puts an item in the lane for its message's type
+func (q *sendQueue) add(item *queuedMessage) *queuedMessage {
+	msg := item.msg
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		item.sent <- net.ErrClosed
+		return item
+	}
+
+	lane := PriorityOf(msg.Type)
+	if lane == PriorityLow && q.coalesce(item) {
+		return item
+	}
+	switch {
+	case lane == PriorityLow && len(q.lanes[lane]) >= maxLowQueued:
+		q.lanes[lane][0].finish(errDropped)
+		q.lanes[lane] = q.lanes[lane][1:]
+	case lane != PriorityLow && q.limit > 0 && len(q.lanes[lane]) >= q.limit:
+		if lane == PriorityHigh && q.policy == OverflowDisconnect {
+			q.stop(ErrSlowPeer)
+			item.sent <- ErrSlowPeer
+			go q.overflow()
+			return item
+		}
+		item.sent <- errDropped
+		return item
+	}
+	q.lanes[lane] = append(q.lanes[lane], item)
+	q.wake()
+	return item
+}
+
+// coalesce merges a presence message into the one last queued, if that is
+// presence from the same node too, keeping the latest state of each user. Only
+// the last is merged into, so presence never overtakes a join or leave queued
+// before it. Of two states of a user, the newer is kept, as presence.Apply
+// would keep it, since presence may be queued out of order. The caller must
+// hold q.mutex.
+func (q *sendQueue) coalesce(item *queuedMessage) bool {
+	lane := q.lanes[PriorityLow]
+	if item.msg.Type != messages.MessageTypeAwareness || len(lane) == 0 {
+		return false
+	}
+	last := lane[len(lane)-1]
+	if last.msg.Type != messages.MessageTypeAwareness || last.msg.UserID != item.msg.UserID {
+		return false
+	}
+
+	// Messages are shared between peers' queues, so the merge is a copy
+	merged := *last.msg
+	merged.Presence = append([]presence.State(nil), last.msg.Presence...)
+	for _, state := range item.msg.Presence {
+		i := slices.IndexFunc(merged.Presence, func(s presence.State) bool { return s.UserID == state.UserID })
+		switch {
+		case i < 0:
+			merged.Presence = append(merged.Presence, state)
+		case newerPresence(state, merged.Presence[i]):
+			merged.Presence[i] = state
+		}
+	}
+	last.msg = &merged
+	last.coalesced = append(last.coalesced, item)
+	return true
+}
+
+// newerPresence reports whether a state of a user replaces the one held
+func newerPresence(state, held presence.State) bool {
+	return state.Clock > held.Clock || (state.Clock == held.Clock && (state.Offline || !held.Offline))
+}
+
+// wake hands the queue to a writer, unless one has it already. The caller must
+// hold q.mutex.
+func (q *sendQueue) wake() {
+	if q.scheduled || q.closed {
+		return
+	}
+	q.scheduled = true
+	q.schedule()
+}
+
+// take removes up to max messages to send, highest priority first, and returns
+// them with the codec to encode them with. When none may be sent now, the
+// queue leaves its writer until there are.
+func (q *sendQueue) take(max int) (items []*queuedMessage, codec messages.Codec) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for !q.closed && len(items) < max {
+		item := q.next()
+		if item == nil {
+			break
+		}
+		q.number(item)
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		q.scheduled = false
+	}
+	return items, q.codec
+}
+
+// next removes the next message that may be sent, nil if there is none. The
+// caller must hold q.mutex.
+func (q *sendQueue) next() *queuedMessage {
+	for lane := range q.lanes {
+		if len(q.lanes[lane]) > 0 {
+			item := q.lanes[lane][0]
+			if q.throttled(item) {
+				// Attachments may go while presence waits
+				continue
+			}
+			q.lanes[lane] = q.lanes[lane][1:]
+			return item
+		}
+	}
+	return nil
+}
+
+// throttled reports whether a message is presence that must wait for the rate
+// limit, and if so has the queue scheduled when it may go. The caller must
+// hold q.mutex.
+func (q *sendQueue) throttled(item *queuedMessage) bool {
+	if item.msg.Type != messages.MessageTypeAwareness || q.presenceInterval <= 0 {
+		return false
+	}
+	wait := q.presenceInterval - time.Since(q.lastPresence)
+	if wait <= 0 {
+		q.lastPresence = time.Now()
+		return false
+	}
+	if q.presenceTimer == nil {
+		q.presenceTimer = time.AfterFunc(wait, func() {
+			q.mutex.Lock()
+			defer q.mutex.Unlock()
+			q.presenceTimer = nil
+			q.wake()
+		})
+	}
+	return true
+}
+
+// close stops the queue, failing every message still waiting in it
+func (q *sendQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.stop(net.ErrClosed)
+}
+
+// stop closes the queue, failing every message still waiting in it with err.
+// The caller must hold q.mutex.
+func (q *sendQueue) stop(err error) {
+	q.closed = true
+	if q.presenceTimer != nil {
+		q.presenceTimer.Stop()
+	}
+	for lane := range q.lanes {
+		for _, item := range q.lanes[lane] {
+			item.finish(err)
+		}
+		q.lanes[lane] = nil
+	}
+}
+
+// SetPresenceRate sets how many presence messages, such as cursor moves, each
+// peer is sent per second at most. Presence waiting its turn is merged, so the
+// peer gets the latest. Zero or less sends presence as fast as it changes.
+func (e *EditorState) SetPresenceRate(perSecond int) {
+	var interval time.Duration
+	if perSecond > 0 {
+		interval = time.Second / time.Duration(perSecond)
+	}
+
+	e.queueMutex.Lock()
+	defer e.queueMutex.Unlock()
+	e.presenceInterval = interval
+	for _, q := range e.queues {
+		q.mutex.Lock()
+		q.presenceInterval = interval
+		q.wake()
+		q.mutex.Unlock()
+	}
+}
+
+// SendTo sends a message to one connection, in turn with everything else
+// queued for it, and waits until it is written
+func (e *EditorState) SendTo(conn messages.Transport, msg *messages.Message) error {
+	return <-e.send(conn, msg).sent
+}
+
+// send queues a message for one connection
+func (e *EditorState) send(conn messages.Transport, msg *messages.Message) *queuedMessage {
+	e.queueMutex.Lock()
+	q, ok := e.queues[conn]
+	e.queueMutex.Unlock()
+
+	if !ok {
+		item := &queuedMessage{msg: msg, sent: make(chan error, 1)}
+		item.sent <- net.ErrClosed
+		return item
+	}
+	return q.push(msg)
+}
@@ -0,0 +1,54 @@
+package shared
+
+import (
+	"errors"
+
+	"gollaborate/crdt"
+	"gollaborate/diff"
+	"gollaborate/messages"
+)
+
+// ErrNoDocument is returned for an edit to an editor without a document
+var ErrNoDocument = errors.New("no document to edit")
+
+// ReplaceText edits the document into the given text, making only the
+// insertions and deletions between the two, as this node, and sends the
+// change to every peer as a single transaction of the given action. It
+// returns the number of characters inserted and deleted.
+func (e *EditorState) ReplaceText(text string, action messages.TransactionAction) (int, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.document == nil {
+		return 0, ErrNoDocument
+	}
+	edits := diff.Default.Diff(e.document.ToText(), text)
+
+	// From the end, so the offsets of the edits before stay those of the old
+	// text. What was edited before failing is still sent.
+	var ops []*messages.Operation
+	var err error
+edits:
+	for i := len(edits) - 1; i >= 0; i-- {
+		edit := edits[i]
+		for j, char := range []rune(edit.Text) {
+			e.currentClock++
+			if edit.Op == diff.Delete {
+				var deleted crdt.Character
+				if deleted, err = e.document.DeleteAtOffset(edit.Offset); err != nil {
+					break edits
+				}
+				ops = append(ops, messages.NewDeleteOperation(deleted.Pos, e.nodeID, e.currentClock))
+				continue
+			}
+			var pos []crdt.Identifier
+			if pos, err = e.document.InsertAtOffset(edit.Offset+j, char, e.nodeID, e.currentClock); err != nil {
+				break edits
+			}
+			ops = append(ops, messages.NewInsertOperation(pos, char, e.nodeID, e.currentClock))
+		}
+	}
+	if len(ops) > 0 {
+		e.BroadcastMessage(messages.NewTransactionMessage(ops, action, e.nodeID, ""))
+	}
+	return len(ops), err
+}
@@ -0,0 +1,107 @@
+package shared
+
+import (
+	"errors"
+
+	"gollaborate/messages"
+)
+
+// ErrReadOnly is returned when a participant without edit rights tries to edit
+var ErrReadOnly = errors.New("you have read-only access to this document")
+
+// SetPeerRole assigns a role to a participant and announces the new roles to
+// every peer. Only the session originator should assign roles; a node that does
+// so stops accepting role announcements from others.
+func (e *EditorState) SetPeerRole(userID int, role messages.Role) {
+	e.mutex.Lock()
+	e.rolesAuthority = true
+	e.roles[userID] = role
+	roles := e.copyRoles()
+	e.mutex.Unlock()
+
+	go e.BroadcastMessage(messages.NewRolesMessage(roles, e.nodeID))
+}
+
+// SetJoinerRole makes this node the session originator and assigns the given
+// role to every peer the first time it is heard from
+func (e *EditorState) SetJoinerRole(role messages.Role) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.rolesAuthority = true
+	e.joinerRole = role
+}
+
+// Role returns a participant's role. Participants without an assigned role are editors.
+func (e *EditorState) Role(userID int) messages.Role {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.roleOf(userID)
+}
+
+// Roles returns a copy of every assigned role, keyed by user ID
+func (e *EditorState) Roles() map[int]messages.Role {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.copyRoles()
+}
+
+// CanEdit reports whether the local participant may edit the document
+func (e *EditorState) CanEdit() bool {
+	return e.Role(e.nodeID) != messages.RoleReadOnly
+}
+
+// SendRoles sends the assigned roles to one connection, if this node assigns
+// roles or knows of any, such as write access revoked mid-session
+func (e *EditorState) SendRoles(conn messages.Transport) error {
+	e.mutex.Lock()
+	authority := e.rolesAuthority
+	roles := e.copyRoles()
+	e.mutex.Unlock()
+
+	if !authority && len(roles) == 0 {
+		return nil
+	}
+	return <-e.send(conn, messages.NewRolesMessage(roles, e.nodeID)).sent
+}
+
+// noteParticipant assigns the joiner role to a newly seen participant and
+// returns the updated roles to announce, or nil. The caller must hold e.mutex.
+func (e *EditorState) noteParticipant(userID int) map[int]messages.Role {
+	if e.joinerRole == "" || userID == 0 || userID == e.nodeID {
+		return nil
+	}
+	if _, known := e.roles[userID]; known {
+		return nil
+	}
+	e.roles[userID] = e.joinerRole
+	return e.copyRoles()
+}
+
+// applyRoles replaces the known roles with an announcement from the originator.
+// The caller must hold e.mutex.
+func (e *EditorState) applyRoles(roles map[int]messages.Role) {
+	if e.rolesAuthority {
+		return
+	}
+	e.roles = make(map[int]messages.Role, len(roles))
+	for userID, role := range roles {
+		e.roles[userID] = role
+	}
+}
+
+// roleOf returns a participant's role. The caller must hold e.mutex.
+func (e *EditorState) roleOf(userID int) messages.Role {
+	if role, ok := e.roles[userID]; ok {
+		return role
+	}
+	return messages.RoleEditor
+}
+
+// copyRoles returns a copy of the roles map. The caller must hold e.mutex.
+func (e *EditorState) copyRoles() map[int]messages.Role {
+	roles := make(map[int]messages.Role, len(e.roles))
+	for userID, role := range e.roles {
+		roles[userID] = role
+	}
+	return roles
+}
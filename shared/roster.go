@@ -0,0 +1,57 @@
+package shared
+
+import (
+	"net"
+
+	"gollaborate/messages"
+)
+
+// rosterVersion is the protocol version from which peers are sent rosters;
+// older ones only know each other from their presence
+const rosterVersion = 7
+
+// Roster returns everyone in the room as the server last listed them, or nil
+// if no server sent a roster, in which case peers are only known from their
+// presence
+func (e *EditorState) Roster() []messages.RosterEntry {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.roster == nil {
+		return nil
+	}
+	return append([]messages.RosterEntry{}, e.roster...)
+}
+
+// SendRoster sends a roster to one peer, if it reads rosters
+func (e *EditorState) SendRoster(conn messages.Transport, roster []messages.RosterEntry) error {
+	e.queueMutex.Lock()
+	q, ok := e.queues[conn]
+	e.queueMutex.Unlock()
+	if !ok {
+		return net.ErrClosed
+	}
+	if !q.takesRosters() {
+		return nil
+	}
+	return <-q.push(messages.NewRosterMessage(roster, e.nodeID)).sent
+}
+
+// BroadcastRoster sends a roster to every peer that reads rosters, without
+// waiting for it to be sent
+func (e *EditorState) BroadcastRoster(roster []messages.RosterEntry) {
+	msg := messages.NewRosterMessage(roster, e.nodeID)
+	e.queueMutex.Lock()
+	defer e.queueMutex.Unlock()
+	for _, q := range e.queues {
+		if q.takesRosters() {
+			q.push(msg)
+		}
+	}
+}
+
+// takesRosters reports whether the peer reads rosters
+func (q *sendQueue) takesRosters() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.rosters
+}
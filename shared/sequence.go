@@ -0,0 +1,194 @@
+package shared
+
+import (
+	"errors"
+	"time"
+
+	"gollaborate/messages"
+)
+
+// DefaultRetransmitTimeout is how long an edit may go unacknowledged before it
+// is sent again
+const DefaultRetransmitTimeout = 2 * time.Second
+
+// sequenceVersion is the protocol version from which peers number their edits
+// and acknowledge ours
+const sequenceVersion = 4
+
+const (
+	// maxUnacked is how many edits a peer may leave unacknowledged before it is
+	// dropped; it has fallen too far behind to catch up by retransmission
+	maxUnacked = 4096
+	// maxHeld is how many edits that arrived ahead of a gap are kept for when the
+	// gap is filled. Past it, the retransmission fills the gap and the rest again.
+	maxHeld = 1024
+)
+
+// ErrTooManyUnacked is reported for a peer dropped for leaving too many edits unacknowledged
+var ErrTooManyUnacked = errors.New("peer stopped acknowledging edits")
+
+// isSequenced reports whether messages of a type are numbered, acknowledged and
+// retransmitted for peers that support it. Everything else is either superseded
+// by the next message of its kind or only matters while the connection is up.
+func isSequenced(msgType messages.MessageType) bool {
+	switch msgType {
+	case messages.MessageTypeOperation, messages.MessageTypeTransaction, messages.MessageTypeBatch:
+		return true
+	}
+	return false
+}
+
+// unackedMessage is a numbered message the peer has not acknowledged yet
+type unackedMessage struct {
+	msg    *messages.Message
+	sentAt time.Time
+}
+
+// inbound tracks the numbered messages received on one connection
+type inbound struct {
+	next int64                       // The sequence number to deliver next
+	held map[int64]*messages.Message // Arrived ahead of a gap, by sequence number
+}
+
+// SetRetransmitTimeout sets how long edits may go unacknowledged before they are
+// sent again. Call it before adding connections.
+func (e *EditorState) SetRetransmitTimeout(timeout time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.retransmitAfter = timeout
+}
+
+// startSequencing numbers the edits sent to a peer from now on and retransmits
+// those it does not acknowledge, until the connection is removed. The caller
+// must hold e.mutex.
+func (e *EditorState) startSequencing(conn messages.Transport, q *sendQueue) {
+	q.mutex.Lock()
+	started := q.sequenced
+	q.sequenced = true
+	q.mutex.Unlock()
+	if !started {
+		go e.retransmit(conn, q, e.retransmitAfter)
+	}
+}
+
+// number gives a message about to be written its sequence number, if it is
+// sequenced for this peer, and keeps it until acknowledged. The caller must
+// hold q.mutex.
+func (q *sendQueue) number(item *queuedMessage) {
+	if !q.sequenced || item.numbered || !isSequenced(item.msg.Type) {
+		return
+	}
+	// The message may be on its way to other peers too, so number a copy
+	numbered := *item.msg
+	q.lastSeq++
+	numbered.Seq = q.lastSeq
+	item.msg = &numbered
+	q.unacked = append(q.unacked, &unackedMessage{msg: &numbered, sentAt: time.Now()})
+}
+
+// acknowledge forgets the messages up to and including seq
+func (q *sendQueue) acknowledge(seq int64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	i := 0
+	for i < len(q.unacked) && q.unacked[i].msg.Seq <= seq {
+		i++
+	}
+	q.unacked = q.unacked[i:]
+}
+
+// retransmit sends edits again when they go unacknowledged for timeout, until
+// the queue is closed
+func (e *EditorState) retransmit(conn messages.Transport, q *sendQueue, timeout time.Duration) {
+	defer e.crashReporter().Recover("retransmitting edits")
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		q.mutex.Lock()
+		if q.closed {
+			q.mutex.Unlock()
+			return
+		}
+		if len(q.unacked) > maxUnacked {
+			q.mutex.Unlock()
+			e.reportError(conn, ErrTooManyUnacked)
+			e.removeConnection(conn)
+			return
+		}
+		var due []*messages.Message
+		for _, u := range q.unacked {
+			if now.Sub(u.sentAt) >= timeout {
+				due = append(due, u.msg)
+				u.sentAt = now
+			}
+		}
+		q.mutex.Unlock()
+
+		for _, msg := range due {
+			q.resend(msg)
+		}
+	}
+}
+
+// sequence takes a message received on a connection and returns the messages
+// now ready to handle, in order. Unnumbered messages are ready at once. A
+// numbered one is dropped if it was already delivered and held if it arrived
+// ahead of one still missing; either way the peer is told how far it got.
+func (e *EditorState) sequence(conn messages.Transport, msg *messages.Message) []*messages.Message {
+	if msg.Seq == 0 || msg.Type == messages.MessageTypeAck {
+		return []*messages.Message{msg}
+	}
+
+	e.mutex.Lock()
+	in, ok := e.inbound[conn]
+	if !ok {
+		if _, added := e.lastSeen[conn]; !added {
+			// The connection was removed meanwhile
+			e.mutex.Unlock()
+			return nil
+		}
+		in = &inbound{next: 1, held: make(map[int64]*messages.Message)}
+		e.inbound[conn] = in
+	}
+	var ready []*messages.Message
+	switch {
+	case msg.Seq < in.next:
+		// A retransmission of something already delivered
+	case msg.Seq == in.next:
+		ready = append(ready, msg)
+		in.next++
+		for {
+			held, ok := in.held[in.next]
+			if !ok {
+				break
+			}
+			delete(in.held, in.next)
+			ready = append(ready, held)
+			in.next++
+		}
+	case len(in.held) < maxHeld:
+		in.held[msg.Seq] = msg
+	}
+	acked := in.next - 1
+	e.mutex.Unlock()
+
+	if acked > 0 {
+		e.send(conn, messages.NewSeqAckMessage(acked, e.nodeID))
+	}
+	// The numbers belong to this connection; relayed copies get their own
+	for _, msg := range ready {
+		msg.Seq = 0
+	}
+	return ready
+}
+
+// handleSeqAck forgets the edits a peer acknowledged. The caller may hold e.mutex.
+func (e *EditorState) handleSeqAck(conn messages.Transport, seq int64) {
+	e.queueMutex.Lock()
+	q, ok := e.queues[conn]
+	e.queueMutex.Unlock()
+	if ok {
+		q.acknowledge(seq)
+	}
+}
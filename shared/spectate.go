@@ -0,0 +1,22 @@
+package shared
+
+import "errors"
+
+// ErrSpectating is returned for edits made while spectating
+var ErrSpectating = errors.New("you are spectating: watching the document, not editing it")
+
+// Spectate makes this editor a spectator's: it follows the document, its edits
+// and who is there, but refuses to change it, as the server it joined as a
+// spectator refuses its edits
+func (e *EditorState) Spectate() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spectating = true
+}
+
+// Spectating reports whether this editor is a spectator's
+func (e *EditorState) Spectating() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.spectating
+}
@@ -0,0 +1,170 @@
+package shared
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+)
+
+// DefaultFullSyncSize is the size, in characters, up to which documents are
+// always sent whole: small enough that a delta would save little
+const DefaultFullSyncSize = 4096
+
+// syncHelloWait is how long SyncPeer waits for a new peer's hello, which says
+// how it may be synced. Peers that say none get the whole document after it.
+const syncHelloWait = 250 * time.Millisecond
+
+// syncStrategies are the strategies this build accepts, most preferred first
+var syncStrategies = []messages.SyncStrategy{messages.SyncMerge, messages.SyncDelta, messages.SyncFull}
+
+// SyncCoordinator decides how to bring a peer joining a session up to date.
+// Servers and peers hosting a session both sync joiners through it.
+type SyncCoordinator struct {
+	// Documents of at most this many characters are always sent whole
+	FullSyncSize int
+}
+
+// SyncRequest is what the coordinator decides on
+type SyncRequest struct {
+	Characters int                     // The size of our document
+	Strategies []messages.SyncStrategy // What the peer accepts; it always accepts a full sync
+	PeerClocks map[int]int             // The latest clock the peer has seen from each user
+	Clocks     map[int]int             // The same for us
+	HaveDelta  bool                    // Whether we still have every operation the peer lacks
+}
+
+// Choose returns how to sync the peer. A peer that shares no history with us,
+// or whose missing operations were forgotten, gets the whole document, as does
+// one joining a small document. Otherwise it is sent only what it lacks, and
+// asked for what it has that we lack, if it edited while apart.
+func (c SyncCoordinator) Choose(r SyncRequest) messages.SyncStrategy {
+	if r.Characters <= c.FullSyncSize || !r.HaveDelta {
+		return messages.SyncFull
+	}
+	common, ahead := false, false
+	for userID, clock := range r.PeerClocks {
+		seen, ok := r.Clocks[userID]
+		common = common || ok
+		ahead = ahead || clock > seen
+	}
+	if !common {
+		return messages.SyncFull
+	}
+	switch {
+	case ahead && slices.Contains(r.Strategies, messages.SyncMerge):
+		return messages.SyncMerge
+	case !ahead && slices.Contains(r.Strategies, messages.SyncDelta):
+		return messages.SyncDelta
+	}
+	return messages.SyncFull
+}
+
+// syncOffer is what a peer's hello said about syncing it
+type syncOffer struct {
+	said       chan struct{} // Closed once the peer said hello, or left
+	strategies []messages.SyncStrategy
+	clocks     map[int]int
+}
+
+// SetSyncCoordinator sets how peers joining through SyncPeer are synced
+func (e *EditorState) SetSyncCoordinator(c SyncCoordinator) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.syncCoordinator = c
+}
+
+// SyncPeer brings a peer that just joined up to date with the document, in the
+// way the coordinator chooses from what its hello said. It waits briefly for
+// the hello, then returns the strategy used and any error sending.
+func (e *EditorState) SyncPeer(conn messages.Transport) (messages.SyncStrategy, error) {
+	e.mutex.Lock()
+	offer, ok := e.syncOffers[conn]
+	e.mutex.Unlock()
+	if ok {
+		select {
+		case <-offer.said:
+		case <-time.After(syncHelloWait):
+		}
+	}
+
+	e.mutex.Lock()
+	delete(e.syncOffers, conn)
+	req := SyncRequest{Characters: e.document.Len(), Clocks: e.oplog.clocks()}
+	if ok {
+		req.Strategies, req.PeerClocks = offer.strategies, offer.clocks
+	}
+	var ops []*messages.Operation
+	ops, req.HaveDelta = e.oplog.since(req.PeerClocks)
+	strategy := e.syncCoordinator.Choose(req)
+	next := e.nextOrder()
+	var doc *crdt.Document
+	var err error
+	if strategy == messages.SyncFull {
+		doc, err = e.documentCopy()
+	}
+	e.mutex.Unlock()
+	if err != nil {
+		return strategy, err
+	}
+
+	switch strategy {
+	case messages.SyncMerge:
+		e.send(conn, messages.NewCatchUpRequestMessage(req.Clocks, e.nodeID))
+		fallthrough
+	case messages.SyncDelta:
+		catchUp := messages.NewCatchUpMessage(ops, e.nodeID)
+		catchUp.Order = next
+		return strategy, <-e.send(conn, catchUp).sent
+	}
+	sync := messages.NewSyncMessage(doc, e.nodeID)
+	sync.Order = next
+	return strategy, <-e.send(conn, sync).sent
+}
+
+// offerSync records how a peer said in its hello it may be synced. The caller
+// must hold e.mutex.
+func (e *EditorState) offerSync(conn messages.Transport, msg *messages.Message) {
+	offer, ok := e.syncOffers[conn]
+	if !ok {
+		return
+	}
+	select {
+	case <-offer.said:
+		// Said hello before
+	default:
+		offer.strategies, offer.clocks = msg.Strategies, msg.Clocks
+		close(offer.said)
+	}
+}
+
+// documentCopy copies the document, so that edits made while a copy waits to
+// be sent do not race with encoding it. The caller must hold e.mutex.
+func (e *EditorState) documentCopy() (*crdt.Document, error) {
+	data, err := json.Marshal(e.document)
+	if err != nil {
+		return nil, err
+	}
+	doc := &crdt.Document{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// syncOperations returns the edits merging a peer's document would make to
+// ours, as that peer's: an insert for each character it has that ours lacks,
+// and a delete for each of ours it deleted. The caller must hold e.mutex.
+func (e *EditorState) syncOperations(msg *messages.Message) []*messages.Operation {
+	inserted, deleted := e.document.MergeChanges(msg.Document)
+	ops := make([]*messages.Operation, 0, len(inserted)+len(deleted))
+	for _, char := range inserted {
+		ops = append(ops, messages.NewInsertOperation(char.Pos, char.Value, msg.UserID, char.Clock))
+	}
+	for _, char := range deleted {
+		ops = append(ops, messages.NewDeleteOperation(char.Pos, msg.UserID, char.Clock))
+	}
+	return ops
+}
@@ -0,0 +1,54 @@
+package shared
+
+import (
+	"errors"
+	"strings"
+
+	"gollaborate/messages"
+	"gollaborate/presence"
+)
+
+// MaxUserNameLength is the longest name, in bytes, a participant can take
+const MaxUserNameLength = 64
+
+// ErrInvalidUserName is returned when taking an empty or overlong name
+var ErrInvalidUserName = errors.New("name must be 1 to 64 characters")
+
+// SetUserInfo changes the local participant's name and color mid-session and
+// tells every peer, who see it as a MessageTypeUserInfo message. An empty color
+// keeps the current one.
+func (e *EditorState) SetUserInfo(name string, color string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > MaxUserNameLength {
+		return ErrInvalidUserName
+	}
+	state := e.awareness.SetLocal(func(s *presence.State) {
+		s.UserName = name
+		if color != "" {
+			s.Color = color
+		}
+	})
+
+	e.BroadcastMessage(messages.NewUserInfoMessage(e.nodeID, state.UserName, state.Color))
+	e.BroadcastMessage(messages.NewAwarenessMessage([]presence.State{state}, e.nodeID))
+	return nil
+}
+
+// handleUserInfo records a peer's new name and color, reporting whether it was
+// news. The peer's hello is updated to match, so Peer reports the new name. The
+// caller must hold e.mutex.
+func (e *EditorState) handleUserInfo(conn messages.Transport, msg *messages.Message) bool {
+	if msg.UserID == e.nodeID || msg.UserName == "" || len(msg.UserName) > MaxUserNameLength {
+		return false
+	}
+	if info, ok := e.hellos[conn]; ok && info.UserID == msg.UserID {
+		info.UserName, info.Color = msg.UserName, msg.Color
+		e.hellos[conn] = info
+	}
+	known, ok := e.userInfo[msg.UserID]
+	if ok && known.UserName == msg.UserName && known.Color == msg.Color {
+		return false
+	}
+	e.userInfo[msg.UserID] = PeerInfo{UserID: msg.UserID, UserName: msg.UserName, Color: msg.Color}
+	return true
+}
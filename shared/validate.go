@@ -0,0 +1,63 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+
+	"gollaborate/messages"
+)
+
+// ErrRejected is returned, wrapping the validator's error, when a validator
+// rejects an operation
+var ErrRejected = errors.New("operation rejected")
+
+// Validator checks an operation before it reaches the document or the network.
+// It may change the operation in place, such as replacing the inserted character,
+// or return an error to reject it.
+//
+// Changing a remote operation only affects this node and the peers it relays to;
+// the sender keeps its own version. Prefer rejecting remote operations to changing them.
+type Validator func(op *messages.Operation) error
+
+// AddValidator registers a validator for every operation, both local edits and
+// operations received from peers. Validators run in the order they were added.
+// A remote transaction is rejected as a whole if any of its operations is.
+func (e *EditorState) AddValidator(validator Validator) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.validators = append(e.validators, validator)
+}
+
+// Validate checks a local operation before it is applied, for editors that apply
+// their own edits to the document. A spectator's edits are refused with
+// ErrSpectating, edits to a frozen document with ErrFrozen and edits to
+// protected regions with ErrProtected, then the validators run.
+func (e *EditorState) Validate(op *messages.Operation) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.validateLocal(op)
+}
+
+// validateLocal checks a local operation. The caller must hold e.mutex.
+func (e *EditorState) validateLocal(op *messages.Operation) error {
+	if e.spectating {
+		return ErrSpectating
+	}
+	if e.frozen() {
+		return ErrFrozen
+	}
+	if err := e.checkProtected(op); err != nil {
+		return err
+	}
+	return e.validate(op)
+}
+
+// validate runs the validators over an operation. The caller must hold e.mutex.
+func (e *EditorState) validate(op *messages.Operation) error {
+	for _, validator := range e.validators {
+		if err := validator(op); err != nil {
+			return fmt.Errorf("%w: %w", ErrRejected, err)
+		}
+	}
+	return nil
+}
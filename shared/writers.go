@@ -0,0 +1,130 @@
+package shared
+
+import (
+	"sync"
+	"time"
+
+	"gollaborate/messages"
+)
+
+// DefaultWriters is how many goroutines write to peers at most, between them
+const DefaultWriters = 16
+
+// maxWriteBatch is the most messages written to a peer at once. A peer with
+// more waiting goes to the back of the line, so the others get their turn.
+const maxWriteBatch = 64
+
+// writerPool shares a few goroutines between every peer's send queue. A queue
+// with messages waiting joins the line, and the next free writer sends them as
+// one batch. A peer slow to take its messages ties up only the writer sending
+// to it, so broadcasts keep reaching everyone else.
+type writerPool struct {
+	mutex   sync.Mutex
+	ready   []writeJob // Queues waiting for a writer, in turn
+	workers int
+	max     int
+}
+
+// writeJob is a peer's send queue waiting for a writer
+type writeJob struct {
+	conn messages.Transport
+	q    *sendQueue
+}
+
+// SetWriters sets how many goroutines write to peers at most. Each peer is
+// written to by one at a time, so more writers keep more slow peers from
+// holding up the rest. Zero or less restores DefaultWriters.
+func (e *EditorState) SetWriters(n int) {
+	if n <= 0 {
+		n = DefaultWriters
+	}
+	p := &e.writers
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.max = n
+	for p.workers < p.max && p.workers < len(p.ready) {
+		p.workers++
+		go e.runWriter()
+	}
+}
+
+// scheduleWrite puts a peer's send queue in line for a writer, starting one
+// if there are fewer than the most allowed. The caller must hold q.mutex.
+func (e *EditorState) scheduleWrite(conn messages.Transport, q *sendQueue) {
+	p := &e.writers
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.ready = append(p.ready, writeJob{conn: conn, q: q})
+	if p.workers < p.max {
+		p.workers++
+		go e.runWriter()
+	}
+}
+
+// runWriter writes to peers in turn until none have messages waiting
+func (e *EditorState) runWriter() {
+	p := &e.writers
+	for {
+		p.mutex.Lock()
+		if len(p.ready) == 0 || p.workers > p.max {
+			p.workers--
+			p.mutex.Unlock()
+			return
+		}
+		job := p.ready[0]
+		p.ready = p.ready[1:]
+		p.mutex.Unlock()
+
+		if e.writeBatch(job) {
+			// More may be waiting: back of the line
+			p.mutex.Lock()
+			p.ready = append(p.ready, job)
+			p.mutex.Unlock()
+		}
+	}
+}
+
+// writeBatch sends the messages waiting for a peer, up to maxWriteBatch, and
+// reports whether the queue should stay in line. A peer that cannot be written
+// to, or that takes longer than the write timeout, is dropped.
+func (e *EditorState) writeBatch(job writeJob) (again bool) {
+	reporter := e.crashReporter()
+	e.mutex.Lock()
+	timeout := e.writeTimeout
+	e.mutex.Unlock()
+	defer func() {
+		if reporter.Handle("sending messages", recover()) {
+			e.removeConnection(job.conn)
+			again = false
+		}
+	}()
+
+	items, codec := job.q.take(maxWriteBatch)
+	if len(items) == 0 {
+		return false
+	}
+	msgs := make([]*messages.Message, len(items))
+	for i, item := range items {
+		msgs[i] = item.msg
+	}
+	d, deadline := job.conn.(writeDeadliner)
+	deadline = deadline && timeout > 0
+	if deadline {
+		_ = d.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	err := messages.SendBatch(job.conn, msgs, codec)
+	if deadline {
+		_ = d.SetWriteDeadline(time.Time{})
+	}
+	for _, item := range items {
+		item.finish(err)
+	}
+	if err != nil {
+		if !isClosedError(err) {
+			e.reportError(job.conn, slowPeerError(err))
+		}
+		e.removeConnection(job.conn)
+		return false
+	}
+	return true
+}
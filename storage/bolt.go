@@ -0,0 +1,331 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/users"
+
+	"go.etcd.io/bbolt"
+)
+
+// Buckets of a BoltStore. Snapshots, operation logs and audit trails hold a
+// bucket per document, keyed by snapshot time and by position in the log.
+var (
+	documentsBucket = []byte("documents")
+	snapshotsBucket = []byte("snapshots")
+	oplogsBucket    = []byte("oplogs")
+	auditBucket     = []byte("audit")
+	profilesBucket  = []byte("profiles")
+)
+
+// boltOpenTimeout is how long OpenBoltStore waits for another process to let
+// go of the database
+const boltOpenTimeout = time.Second
+
+// BoltStore keeps a store in one BoltDB database file. Each change is a
+// transaction, so saving a document and starting its log afresh happen together.
+type BoltStore struct {
+	db *bbolt.DB
+}
+
+// OpenBoltStore opens the store in a database file, creating it if need be.
+// Only one process can have it open at a time.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bbolt.Open(path, 0644, &bbolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{documentsBucket, snapshotsBucket, oplogsBucket, auditBucket, profilesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// uint64Key encodes a number so keys sort in its order
+func uint64Key(n uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, n)
+}
+
+func (s *BoltStore) LoadDocument(name string) (*crdt.Document, error) {
+	var doc *crdt.Document
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		doc, err = unmarshalDocument(tx.Bucket(documentsBucket).Get([]byte(name)))
+		return err
+	})
+	return doc, err
+}
+
+func (s *BoltStore) SaveDocument(name string, doc *crdt.Document) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(documentsBucket).Put([]byte(name), data); err != nil {
+			return err
+		}
+		return deleteBucket(tx.Bucket(oplogsBucket), []byte(name))
+	})
+}
+
+func (s *BoltStore) Documents() ([]string, error) {
+	var names []string
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(documentsBucket).ForEach(func(k, _ []byte) error {
+			names = append(names, string(k))
+			return nil
+		})
+	})
+	return names, err
+}
+
+func (s *BoltStore) DeleteDocument(name string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(documentsBucket).Delete([]byte(name)); err != nil {
+			return err
+		}
+		if err := deleteBucket(tx.Bucket(snapshotsBucket), []byte(name)); err != nil {
+			return err
+		}
+		return deleteBucket(tx.Bucket(oplogsBucket), []byte(name))
+	})
+}
+
+func (s *BoltStore) SaveSnapshot(name string, at time.Time, doc *crdt.Document) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.Bucket(snapshotsBucket).CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		return b.Put(uint64Key(uint64(snapshotKey(at))), data)
+	})
+}
+
+func (s *BoltStore) Snapshots(name string) ([]time.Time, error) {
+	var times []time.Time
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(snapshotsBucket).Bucket([]byte(name))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, _ []byte) error {
+			times = append(times, snapshotTime(int64(binary.BigEndian.Uint64(k))))
+			return nil
+		})
+	})
+	return times, err
+}
+
+func (s *BoltStore) LoadSnapshot(name string, at time.Time) (*crdt.Document, error) {
+	var doc *crdt.Document
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(snapshotsBucket).Bucket([]byte(name))
+		if b == nil {
+			return ErrNotFound
+		}
+		var err error
+		doc, err = unmarshalDocument(b.Get(uint64Key(uint64(snapshotKey(at)))))
+		return err
+	})
+	return doc, err
+}
+
+func (s *BoltStore) DeleteSnapshot(name string, at time.Time) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(snapshotsBucket).Bucket([]byte(name))
+		if b == nil {
+			return nil
+		}
+		return b.Delete(uint64Key(uint64(snapshotKey(at))))
+	})
+}
+
+func (s *BoltStore) AppendOps(name string, ops []LoggedOp) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return appendValues(tx.Bucket(oplogsBucket), name, ops)
+	})
+}
+
+func (s *BoltStore) Ops(name string) ([]LoggedOp, error) {
+	var ops []LoggedOp
+	err := s.db.View(func(tx *bbolt.Tx) (err error) {
+		ops, err = readValues[LoggedOp](tx.Bucket(oplogsBucket), name)
+		return err
+	})
+	return ops, err
+}
+
+func (s *BoltStore) AppendAudit(name string, entries []AuditEntry) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return appendValues(tx.Bucket(auditBucket), name, entries)
+	})
+}
+
+func (s *BoltStore) Audit(name string) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := s.db.View(func(tx *bbolt.Tx) (err error) {
+		entries, err = readValues[AuditEntry](tx.Bucket(auditBucket), name)
+		return err
+	})
+	return entries, err
+}
+
+// appendValues adds values as JSON to the end of a document's bucket within
+// a parent bucket, creating it if need be
+func appendValues[T any](parent *bbolt.Bucket, name string, values []T) error {
+	b, err := parent.CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		n, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := b.Put(uint64Key(n), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readValues returns the values in a document's bucket within a parent
+// bucket, in the order they were appended
+func readValues[T any](parent *bbolt.Bucket, name string) ([]T, error) {
+	b := parent.Bucket([]byte(name))
+	if b == nil {
+		return nil, nil
+	}
+	var values []T
+	err := b.ForEach(func(_, data []byte) error {
+		var v T
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		values = append(values, v)
+		return nil
+	})
+	return values, err
+}
+
+func (s *BoltStore) SaveProfile(user users.User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(profilesBucket).Put(uint64Key(uint64(user.ID)), data)
+	})
+}
+
+func (s *BoltStore) Profile(id int) (users.User, error) {
+	var user users.User
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(profilesBucket).Get(uint64Key(uint64(id)))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &user)
+	})
+	return user, err
+}
+
+func (s *BoltStore) Profiles() ([]users.User, error) {
+	var profiles []users.User
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(profilesBucket).ForEach(func(_, v []byte) error {
+			var user users.User
+			if err := json.Unmarshal(v, &user); err != nil {
+				return err
+			}
+			profiles = append(profiles, user)
+			return nil
+		})
+	})
+	return profiles, err
+}
+
+func (s *BoltStore) ForgetUser(id, anonID int) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(profilesBucket).Delete(uint64Key(uint64(id))); err != nil {
+			return err
+		}
+		trails := tx.Bucket(auditBucket)
+		return trails.ForEachBucket(func(name []byte) error {
+			b := trails.Bucket(name)
+			// Collected first, as a bucket cannot change while iterated
+			changed := make(map[string][]byte)
+			err := b.ForEach(func(key, data []byte) error {
+				var entry AuditEntry
+				if err := json.Unmarshal(data, &entry); err != nil {
+					return err
+				}
+				entries := []AuditEntry{entry}
+				if !anonymize(entries, id, anonID) {
+					return nil
+				}
+				data, err := json.Marshal(entries[0])
+				changed[string(key)] = data
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			for key, data := range changed {
+				if err := b.Put([]byte(key), data); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// Close closes the database file
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// unmarshalDocument decodes a stored document, or returns ErrNotFound for none
+func unmarshalDocument(data []byte) (*crdt.Document, error) {
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	doc := &crdt.Document{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("invalid document: %w", err)
+	}
+	return doc, nil
+}
+
+// deleteBucket removes a bucket within another, if it is there
+func deleteBucket(parent *bbolt.Bucket, name []byte) error {
+	err := parent.DeleteBucket(name)
+	if errors.Is(err, bbolt.ErrBucketNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,344 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/users"
+)
+
+// FileStore keeps a store in a directory: documents/NAME.json,
+// snapshots/NAME/TIME.json, oplogs/NAME.jsonl with an operation per line,
+// audit/NAME.jsonl with an entry per line, and profiles/ID.json. Names are escaped, so any name makes a single file.
+type FileStore struct {
+	dir string
+	// Writes go through a temporary file and a rename, so a crash never
+	// leaves a partial file; the mutex keeps logs and saves in order
+	mutex sync.Mutex
+}
+
+// OpenFileStore opens the store in a directory, creating it if need be
+func OpenFileStore(dir string) (*FileStore, error) {
+	for _, sub := range []string{"documents", "snapshots", "oplogs", "audit", "profiles"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// escapeName makes a document name safe to use as a file name
+func escapeName(name string) string {
+	escaped := url.PathEscape(name)
+	if strings.HasPrefix(escaped, ".") || escaped == "" {
+		// Neither hidden nor "." and ".."
+		escaped = "%2E" + strings.TrimPrefix(escaped, ".")
+	}
+	return escaped
+}
+
+func (s *FileStore) documentPath(name string) string {
+	return filepath.Join(s.dir, "documents", escapeName(name)+".json")
+}
+
+func (s *FileStore) snapshotDir(name string) string {
+	return filepath.Join(s.dir, "snapshots", escapeName(name))
+}
+
+func (s *FileStore) opLogPath(name string) string {
+	return filepath.Join(s.dir, "oplogs", escapeName(name)+".jsonl")
+}
+
+func (s *FileStore) auditPath(name string) string {
+	return filepath.Join(s.dir, "audit", escapeName(name)+".jsonl")
+}
+
+func (s *FileStore) profilePath(id int) string {
+	return filepath.Join(s.dir, "profiles", strconv.Itoa(id)+".json")
+}
+
+func (s *FileStore) LoadDocument(name string) (*crdt.Document, error) {
+	return readDocument(s.documentPath(name))
+}
+
+func (s *FileStore) SaveDocument(name string, doc *crdt.Document) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := writeJSON(s.documentPath(name), doc); err != nil {
+		return err
+	}
+	if err := os.Remove(s.opLogPath(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) Documents() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, "documents"))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		escaped, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		name, err := url.PathUnescape(escaped)
+		if err != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *FileStore) DeleteDocument(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, path := range []string{s.documentPath(name), s.opLogPath(name)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.RemoveAll(s.snapshotDir(name))
+}
+
+func (s *FileStore) SaveSnapshot(name string, at time.Time, doc *crdt.Document) error {
+	dir := s.snapshotDir(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return writeJSON(filepath.Join(dir, strconv.FormatInt(snapshotKey(at), 10)+".json"), doc)
+}
+
+func (s *FileStore) Snapshots(name string) ([]time.Time, error) {
+	entries, err := os.ReadDir(s.snapshotDir(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []int64
+	for _, entry := range entries {
+		key, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), ".json"), 10, 64)
+		if err != nil || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	times := make([]time.Time, len(keys))
+	for i, key := range keys {
+		times[i] = snapshotTime(key)
+	}
+	return times, nil
+}
+
+func (s *FileStore) LoadSnapshot(name string, at time.Time) (*crdt.Document, error) {
+	return readDocument(filepath.Join(s.snapshotDir(name), strconv.FormatInt(snapshotKey(at), 10)+".json"))
+}
+
+func (s *FileStore) DeleteSnapshot(name string, at time.Time) error {
+	err := os.Remove(filepath.Join(s.snapshotDir(name), strconv.FormatInt(snapshotKey(at), 10)+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *FileStore) AppendOps(name string, ops []LoggedOp) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return appendLines(s.opLogPath(name), ops)
+}
+
+func (s *FileStore) Ops(name string) ([]LoggedOp, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return readLines(s.opLogPath(name), func(op LoggedOp) bool { return op.Op != nil })
+}
+
+func (s *FileStore) AppendAudit(name string, entries []AuditEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return appendLines(s.auditPath(name), entries)
+}
+
+func (s *FileStore) Audit(name string) ([]AuditEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return readLines(s.auditPath(name), func(entry AuditEntry) bool { return !entry.Time.IsZero() })
+}
+
+// appendLines appends values to a file of JSON lines, creating it if need be
+func appendLines[T any](path string, values []T) error {
+	var lines bytes.Buffer
+	for _, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		lines.Write(data)
+		lines.WriteByte('\n')
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(lines.Bytes()); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readLines reads the values of a file of JSON lines, none if there is no such
+// file. A line that is not a complete value, as when cut short by a crash,
+// ends the file.
+func readLines[T any](path string, complete func(T) bool) ([]T, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var values []T
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var v T
+		if err := json.Unmarshal(scanner.Bytes(), &v); err != nil || !complete(v) {
+			break
+		}
+		values = append(values, v)
+	}
+	return values, scanner.Err()
+}
+
+func (s *FileStore) SaveProfile(user users.User) error {
+	return writeJSON(s.profilePath(user.ID), user)
+}
+
+func (s *FileStore) Profile(id int) (users.User, error) {
+	var user users.User
+	data, err := os.ReadFile(s.profilePath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return user, ErrNotFound
+	}
+	if err != nil {
+		return user, err
+	}
+	err = json.Unmarshal(data, &user)
+	return user, err
+}
+
+func (s *FileStore) Profiles() ([]users.User, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, "profiles"))
+	if err != nil {
+		return nil, err
+	}
+	var profiles []users.User
+	for _, entry := range entries {
+		id, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		user, err := s.Profile(id)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, user)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].ID < profiles[j].ID })
+	return profiles, nil
+}
+
+func (s *FileStore) ForgetUser(id, anonID int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := os.Remove(s.profilePath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	trails, err := filepath.Glob(filepath.Join(s.dir, "audit", "*.jsonl"))
+	if err != nil {
+		return err
+	}
+	for _, path := range trails {
+		entries, err := readLines(path, func(entry AuditEntry) bool { return !entry.Time.IsZero() })
+		if err != nil {
+			return err
+		}
+		if !anonymize(entries, id, anonID) {
+			continue
+		}
+		// Rewritten through a temporary file, as writeJSON writes
+		tmp := path + ".tmp"
+		if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := appendLines(tmp, entries); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close does nothing: every change is already in its file
+func (s *FileStore) Close() error {
+	return nil
+}
+
+// readDocument reads a document written by writeJSON
+func readDocument(path string) (*crdt.Document, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc := &crdt.Document{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("invalid document %s: %w", path, err)
+	}
+	return doc, nil
+}
+
+// writeJSON writes a value to a file as JSON, first to a temporary file so a
+// crash never leaves a partial one
+func writeJSON(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/users"
+)
+
+// ErrNotFound is returned for a document, snapshot or profile a store does not have
+var ErrNotFound = errors.New("not found")
+
+// Store keeps what a server must not lose when it stops: its documents,
+// snapshots of them, the operations applied to them, who made those and the
+// profiles of their users. Documents are stored by name, such as the room hosting them. Stores
+// are safe to use from several goroutines.
+type Store interface {
+	// LoadDocument returns the named document, or ErrNotFound
+	LoadDocument(name string) (*crdt.Document, error)
+	// SaveDocument replaces the named document. Its operation log starts
+	// afresh, as the operations logged so far are in the document saved.
+	SaveDocument(name string, doc *crdt.Document) error
+	// Documents returns the names of the documents stored, sorted
+	Documents() ([]string, error)
+	// DeleteDocument removes a document with its snapshots and operation log
+	DeleteDocument(name string) error
+
+	// SaveSnapshot keeps a copy of a document as it was at a time, apart from
+	// the document itself
+	SaveSnapshot(name string, at time.Time, doc *crdt.Document) error
+	// Snapshots returns when a document's snapshots were taken, oldest first
+	Snapshots(name string) ([]time.Time, error)
+	// LoadSnapshot returns the snapshot of a document taken at a time, or ErrNotFound
+	LoadSnapshot(name string, at time.Time) (*crdt.Document, error)
+	// DeleteSnapshot removes the snapshot of a document taken at a time
+	DeleteSnapshot(name string, at time.Time) error
+
+	// AppendOps adds operations to the end of a document's operation log
+	AppendOps(name string, ops []LoggedOp) error
+	// Ops returns a document's operation log since it was last saved, in the
+	// order the operations were appended
+	Ops(name string) ([]LoggedOp, error)
+
+	// AppendAudit adds entries to the end of a document's audit trail.
+	// Nothing else changes the trail: it outlives saving and deleting the
+	// document, to account for who changed it.
+	AppendAudit(name string, entries []AuditEntry) error
+	// Audit returns a document's audit trail, oldest first
+	Audit(name string) ([]AuditEntry, error)
+
+	// SaveProfile stores a user's profile, replacing any with the same ID
+	SaveProfile(user users.User) error
+	// Profile returns the profile of the user with the given ID, or ErrNotFound
+	Profile(id int) (users.User, error)
+	// Profiles returns every profile stored, by ID
+	Profiles() ([]users.User, error)
+	// ForgetUser deletes a user's profile and anonymizes their entries in
+	// every audit trail, which keep the changes under anonID without a name.
+	// It is the one change made to audit trails besides appending.
+	ForgetUser(id, anonID int) error
+
+	// Close releases the store; it cannot be used afterwards
+	Close() error
+}
+
+// LoggedOp is an operation in a document's log, with when it was applied.
+// Author, the name the operation was sent under, is known only to the server
+// that took it and is never written to a log.
+type LoggedOp struct {
+	Time   time.Time           `json:"time"`
+	Op     *messages.Operation `json:"op"`
+	Author string              `json:"-"`
+}
+
+// AuditEntry records who changed a document, when and how much: the
+// characters one user inserted and deleted over a moment of editing
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	UserID   int       `json:"user_id"`
+	UserName string    `json:"user_name,omitempty"`
+	Inserted int       `json:"inserted,omitempty"`
+	Deleted  int       `json:"deleted,omitempty"`
+}
+
+// anonymize puts a forgotten user's entries under anonID without a name,
+// reporting whether there were any
+func anonymize(entries []AuditEntry, id, anonID int) bool {
+	found := false
+	for i := range entries {
+		if entries[i].UserID == id {
+			entries[i].UserID, entries[i].UserName = anonID, ""
+			found = true
+		}
+	}
+	return found
+}
+
+// Summary describes the change, such as "inserted 12 and deleted 3 characters"
+func (a AuditEntry) Summary() string {
+	switch {
+	case a.Inserted > 0 && a.Deleted > 0:
+		return fmt.Sprintf("inserted %d and deleted %d %s", a.Inserted, a.Deleted, characters(a.Deleted))
+	case a.Deleted > 0:
+		return fmt.Sprintf("deleted %d %s", a.Deleted, characters(a.Deleted))
+	}
+	return fmt.Sprintf("inserted %d %s", a.Inserted, characters(a.Inserted))
+}
+
+// characters is the noun for a number of characters
+func characters(n int) string {
+	if n == 1 {
+		return "character"
+	}
+	return "characters"
+}
+
+// ApplyOps applies logged operations to a document, such as those logged since
+// it was saved. Operations it has already change nothing.
+func ApplyOps(doc *crdt.Document, ops []LoggedOp) {
+	for _, logged := range ops {
+		switch op := logged.Op; op.Type {
+		case messages.OperationTypeInsert:
+			_ = doc.InsertCharacter(op.Character, op.Position, op.Clock)
+		case messages.OperationTypeDelete:
+			_ = doc.DeleteCharacter(op.Position)
+		}
+	}
+}
+
+// PruneSnapshots deletes all but the latest keep snapshots of a document.
+// A keep of zero keeps every one.
+func PruneSnapshots(store Store, name string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	times, err := store.Snapshots(name)
+	if err != nil {
+		return err
+	}
+	for len(times) > keep {
+		if err := store.DeleteSnapshot(name, times[0]); err != nil {
+			return err
+		}
+		times = times[1:]
+	}
+	return nil
+}
+
+// Kinds of store, as Open takes them
+const (
+	// KindFile keeps each document, snapshot and profile in a file of its own
+	// under a directory, readable and easy to back up
+	KindFile = "file"
+	// KindBolt keeps everything in one BoltDB database file, written in
+	// transactions so a crash never leaves part of a change behind
+	KindBolt = "bolt"
+)
+
+// Kinds returns the kinds of store Open can open
+func Kinds() []string {
+	return []string{KindFile, KindBolt}
+}
+
+// Open opens a store of the given kind at a path: a directory for KindFile, a
+// database file for KindBolt. Either is created if it does not exist.
+func Open(kind, path string) (Store, error) {
+	switch kind {
+	case KindFile:
+		return OpenFileStore(path)
+	case KindBolt:
+		return OpenBoltStore(path)
+	}
+	return nil, fmt.Errorf("unknown store %q, expected one of %v", kind, Kinds())
+}
+
+// snapshotKey numbers a snapshot by when it was taken, keeping the order of
+// times and every bit of their precision
+func snapshotKey(at time.Time) int64 {
+	return at.UnixNano()
+}
+
+// snapshotTime is when the snapshot numbered by snapshotKey was taken
+func snapshotTime(key int64) time.Time {
+	return time.Unix(0, key)
+}
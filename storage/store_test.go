@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/users"
+)
+
+// openTestStores opens one store of every kind in a temporary directory
+func openTestStores(t *testing.T) map[string]Store {
+	t.Helper()
+
+	stores := make(map[string]Store)
+	for _, kind := range Kinds() {
+		store, err := Open(kind, filepath.Join(t.TempDir(), "store"))
+		if err != nil {
+			t.Fatalf("Failed to open %s store: %v", kind, err)
+		}
+		t.Cleanup(func() { _ = store.Close() })
+		stores[kind] = store
+	}
+	return stores
+}
+
+func TestStoreDocuments(t *testing.T) {
+	for kind, store := range openTestStores(t) {
+		t.Run(kind, func(t *testing.T) {
+			if _, err := store.LoadDocument("notes"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Expected ErrNotFound for a missing document, got %v", err)
+			}
+
+			// Names that are not file names are stored all the same
+			for _, name := range []string{"notes", "../escape", ".."} {
+				if err := store.SaveDocument(name, crdt.FromText("text of "+name, 1)); err != nil {
+					t.Fatalf("Failed to save %q: %v", name, err)
+				}
+			}
+			doc, err := store.LoadDocument("../escape")
+			if err != nil {
+				t.Fatalf("Failed to load document: %v", err)
+			}
+			if text := doc.ToText(); text != "text of ../escape" {
+				t.Errorf("Expected the saved text, got %q", text)
+			}
+			names, err := store.Documents()
+			if err != nil || len(names) != 3 || names[0] != ".." || names[1] != "../escape" || names[2] != "notes" {
+				t.Errorf("Expected the three documents sorted, got %q (%v)", names, err)
+			}
+
+			if err := store.DeleteDocument("notes"); err != nil {
+				t.Fatalf("Failed to delete document: %v", err)
+			}
+			if _, err := store.LoadDocument("notes"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected the deleted document gone, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStoreSnapshots(t *testing.T) {
+	for kind, store := range openTestStores(t) {
+		t.Run(kind, func(t *testing.T) {
+			first := time.Date(2024, 3, 1, 9, 0, 0, 123456789, time.UTC)
+			second := first.Add(time.Hour)
+			for _, at := range []time.Time{second, first} {
+				if err := store.SaveSnapshot("notes", at, crdt.FromText(at.Format(time.Kitchen), 1)); err != nil {
+					t.Fatalf("Failed to save snapshot: %v", err)
+				}
+			}
+
+			times, err := store.Snapshots("notes")
+			if err != nil || len(times) != 2 || !times[0].Equal(first) || !times[1].Equal(second) {
+				t.Fatalf("Expected both snapshots, oldest first, got %v (%v)", times, err)
+			}
+			doc, err := store.LoadSnapshot("notes", first)
+			if err != nil || doc.ToText() != "9:00AM" {
+				t.Errorf("Expected the first snapshot, got %v (%v)", doc, err)
+			}
+			if _, err := store.LoadSnapshot("notes", first.Add(time.Minute)); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected ErrNotFound for a time without a snapshot, got %v", err)
+			}
+
+			if err := store.DeleteSnapshot("notes", first); err != nil {
+				t.Fatalf("Failed to delete snapshot: %v", err)
+			}
+			if times, _ := store.Snapshots("notes"); len(times) != 1 {
+				t.Errorf("Expected one snapshot left, got %v", times)
+			}
+			if times, err := store.Snapshots("other"); err != nil || len(times) != 0 {
+				t.Errorf("Expected no snapshots of another document, got %v (%v)", times, err)
+			}
+		})
+	}
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	for kind, store := range openTestStores(t) {
+		t.Run(kind, func(t *testing.T) {
+			at := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+			for i := range 5 {
+				if err := store.SaveSnapshot("notes", at.Add(time.Duration(i)*time.Minute), crdt.FromText("v", 1)); err != nil {
+					t.Fatalf("Failed to save snapshot: %v", err)
+				}
+			}
+			if err := PruneSnapshots(store, "notes", 0); err != nil {
+				t.Fatalf("Failed to prune: %v", err)
+			}
+			if times, _ := store.Snapshots("notes"); len(times) != 5 {
+				t.Errorf("Expected every snapshot kept, got %v", times)
+			}
+			if err := PruneSnapshots(store, "notes", 2); err != nil {
+				t.Fatalf("Failed to prune: %v", err)
+			}
+			times, err := store.Snapshots("notes")
+			if err != nil || len(times) != 2 || !times[0].Equal(at.Add(3*time.Minute)) {
+				t.Errorf("Expected the latest two snapshots kept, got %v (%v)", times, err)
+			}
+		})
+	}
+}
+
+func TestStoreOps(t *testing.T) {
+	for kind, store := range openTestStores(t) {
+		t.Run(kind, func(t *testing.T) {
+			pos := []crdt.Identifier{{Digit: 5, Node: 1}}
+			at := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+			ops := []LoggedOp{
+				{Time: at, Op: messages.NewInsertOperation(pos, 'a', 1, 1)},
+				{Time: at.Add(time.Second), Op: messages.NewDeleteOperation(pos, 1, 2)},
+			}
+			if err := store.AppendOps("notes", ops[:1]); err != nil {
+				t.Fatalf("Failed to append: %v", err)
+			}
+			if err := store.AppendOps("notes", ops[1:]); err != nil {
+				t.Fatalf("Failed to append: %v", err)
+			}
+
+			logged, err := store.Ops("notes")
+			if err != nil || len(logged) != 2 {
+				t.Fatalf("Expected both operations, got %d (%v)", len(logged), err)
+			}
+			if logged[0].Op.Type != messages.OperationTypeInsert || logged[0].Op.Character != 'a' || logged[1].Op.Type != messages.OperationTypeDelete {
+				t.Errorf("Expected the operations in order, got %+v %+v", logged[0].Op, logged[1].Op)
+			}
+			if !logged[0].Time.Equal(at) || !logged[1].Time.Equal(at.Add(time.Second)) {
+				t.Errorf("Expected the times the operations were applied, got %v and %v", logged[0].Time, logged[1].Time)
+			}
+
+			// Saving the document starts its log afresh
+			if err := store.SaveDocument("notes", crdt.FromText("", 1)); err != nil {
+				t.Fatalf("Failed to save document: %v", err)
+			}
+			if logged, err := store.Ops("notes"); err != nil || len(logged) != 0 {
+				t.Errorf("Expected an empty log after saving, got %d (%v)", len(logged), err)
+			}
+		})
+	}
+}
+
+func TestStoreAudit(t *testing.T) {
+	for kind, store := range openTestStores(t) {
+		t.Run(kind, func(t *testing.T) {
+			at := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+			entries := []AuditEntry{
+				{Time: at, UserID: 1, UserName: "alice", Inserted: 12, Deleted: 3},
+				{Time: at.Add(time.Second), UserID: 2, Deleted: 1},
+			}
+			for _, entry := range entries {
+				if err := store.AppendAudit("notes", []AuditEntry{entry}); err != nil {
+					t.Fatalf("Failed to append: %v", err)
+				}
+			}
+
+			// Neither saving nor deleting the document touches its trail
+			if err := store.SaveDocument("notes", crdt.FromText("", 1)); err != nil {
+				t.Fatalf("Failed to save document: %v", err)
+			}
+			if err := store.DeleteDocument("notes"); err != nil {
+				t.Fatalf("Failed to delete document: %v", err)
+			}
+			trail, err := store.Audit("notes")
+			if err != nil || len(trail) != 2 {
+				t.Fatalf("Expected both entries, got %+v (%v)", trail, err)
+			}
+			if trail[0].UserName != "alice" || !trail[1].Time.Equal(at.Add(time.Second)) {
+				t.Errorf("Expected the entries in order, got %+v", trail)
+			}
+			if got := trail[0].Summary(); got != "inserted 12 and deleted 3 characters" {
+				t.Errorf("Expected the change summed up, got %q", got)
+			}
+			if got := trail[1].Summary(); got != "deleted 1 character" {
+				t.Errorf("Expected the change summed up, got %q", got)
+			}
+			if trail, err := store.Audit("other"); err != nil || len(trail) != 0 {
+				t.Errorf("Expected no trail for another document, got %+v (%v)", trail, err)
+			}
+		})
+	}
+}
+
+func TestStoreProfiles(t *testing.T) {
+	for kind, store := range openTestStores(t) {
+		t.Run(kind, func(t *testing.T) {
+			if _, err := store.Profile(7); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Expected ErrNotFound for a missing profile, got %v", err)
+			}
+			for _, user := range []users.User{{ID: 7, Name: "Ada", Color: "green"}, {ID: 3, Name: "Alan"}} {
+				if err := store.SaveProfile(user); err != nil {
+					t.Fatalf("Failed to save profile: %v", err)
+				}
+			}
+			if err := store.SaveProfile(users.User{ID: 7, Name: "Ada L.", Color: "green"}); err != nil {
+				t.Fatalf("Failed to replace profile: %v", err)
+			}
+
+			user, err := store.Profile(7)
+			if err != nil || user.Name != "Ada L." {
+				t.Errorf("Expected the replaced profile, got %+v (%v)", user, err)
+			}
+			profiles, err := store.Profiles()
+			if err != nil || len(profiles) != 2 || profiles[0].ID != 3 || profiles[1].ID != 7 {
+				t.Errorf("Expected both profiles by ID, got %+v (%v)", profiles, err)
+			}
+		})
+	}
+}
+
+func TestStoreForgetUser(t *testing.T) {
+	for kind, store := range openTestStores(t) {
+		t.Run(kind, func(t *testing.T) {
+			at := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+			for _, name := range []string{"notes", "plans"} {
+				entries := []AuditEntry{
+					{Time: at, UserID: 7, UserName: "Ada", Inserted: 4},
+					{Time: at.Add(time.Second), UserID: 3, UserName: "Alan", Deleted: 1},
+				}
+				if err := store.AppendAudit(name, entries); err != nil {
+					t.Fatalf("Failed to append: %v", err)
+				}
+			}
+			for _, user := range []users.User{{ID: 7, Name: "Ada"}, {ID: 3, Name: "Alan"}} {
+				if err := store.SaveProfile(user); err != nil {
+					t.Fatalf("Failed to save profile: %v", err)
+				}
+			}
+
+			if err := store.ForgetUser(7, -42); err != nil {
+				t.Fatalf("Failed to forget user: %v", err)
+			}
+			if _, err := store.Profile(7); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected the profile deleted, got %v", err)
+			}
+			if _, err := store.Profile(3); err != nil {
+				t.Errorf("Expected other profiles kept, got %v", err)
+			}
+			// Every trail keeps the change, under the anonymous ID
+			for _, name := range []string{"notes", "plans"} {
+				trail, err := store.Audit(name)
+				want := []AuditEntry{
+					{Time: at, UserID: -42, Inserted: 4},
+					{Time: at.Add(time.Second), UserID: 3, UserName: "Alan", Deleted: 1},
+				}
+				if err != nil || len(trail) != 2 || trail[0] != want[0] || trail[1] != want[1] {
+					t.Errorf("Expected %+v in %s, got %+v (%v)", want, name, trail, err)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreReopen(t *testing.T) {
+	for _, kind := range Kinds() {
+		t.Run(kind, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "store")
+			store, err := Open(kind, path)
+			if err != nil {
+				t.Fatalf("Failed to open store: %v", err)
+			}
+			if err := store.SaveDocument("notes", crdt.FromText("kept", 1)); err != nil {
+				t.Fatalf("Failed to save: %v", err)
+			}
+			if err := store.Close(); err != nil {
+				t.Fatalf("Failed to close: %v", err)
+			}
+
+			store, err = Open(kind, path)
+			if err != nil {
+				t.Fatalf("Failed to reopen store: %v", err)
+			}
+			defer store.Close()
+			doc, err := store.LoadDocument("notes")
+			if err != nil || doc.ToText() != "kept" {
+				t.Errorf("Expected the document kept across opens, got %v (%v)", doc, err)
+			}
+		})
+	}
+}
+
+func TestOpenUnknownKind(t *testing.T) {
+	if _, err := Open("floppy", t.TempDir()); err == nil {
+		t.Error("Expected an unknown kind of store refused")
+	}
+}
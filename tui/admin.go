@@ -0,0 +1,225 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gollaborate/server"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// adminRefreshInterval is how often the dashboard polls the server
+const adminRefreshInterval = time.Second
+
+// adminTick asks the dashboard to refresh its snapshot
+type adminTick time.Time
+
+type adminModel struct {
+	server   *server.Server
+	stats    server.Stats
+	selected int
+	opsRate  float64
+	lastOps  int
+	lastPoll time.Time
+	status   string
+}
+
+func newAdminModel(srv *server.Server) *adminModel {
+	stats := srv.Stats()
+	return &adminModel{
+		server:   srv,
+		stats:    stats,
+		lastOps:  stats.OpsTotal,
+		lastPoll: time.Now(),
+		status:   "Ready",
+	}
+}
+
+func (m *adminModel) Init() tea.Cmd {
+	return adminTickCmd()
+}
+
+func adminTickCmd() tea.Cmd {
+	return tea.Tick(adminRefreshInterval, func(t time.Time) tea.Msg {
+		return adminTick(t)
+	})
+}
+
+func (m *adminModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c", "ctrl+q", "q":
+			return m, tea.Quit
+		case "up":
+			if m.selected > 0 {
+				m.selected--
+			}
+		case "down":
+			if m.selected < len(m.stats.Clients)-1 {
+				m.selected++
+			}
+		case "k":
+			if m.selected < len(m.stats.Clients) {
+				c := m.stats.Clients[m.selected]
+				m.server.Kick(c)
+				m.status = fmt.Sprintf("Kicked %s", clientLabel(c))
+				m.refresh()
+			}
+		case "w":
+			if m.selected < len(m.stats.Clients) {
+				c := m.stats.Clients[m.selected]
+				if err := m.server.SetWriteAccess(c, c.ReadOnly); err != nil {
+					m.status = fmt.Sprintf("Failed to change write access: %v", err)
+				} else if c.ReadOnly {
+					m.status = fmt.Sprintf("%s may edit again", clientLabel(c))
+				} else {
+					m.status = fmt.Sprintf("%s is now read-only", clientLabel(c))
+				}
+				m.refresh()
+			}
+		case "l":
+			locked := !m.stats.Locked
+			m.server.SetLocked(locked)
+			if locked {
+				m.status = "Document locked"
+			} else {
+				m.status = "Document unlocked"
+			}
+			m.refresh()
+		}
+	case adminTick:
+		m.refresh()
+		return m, adminTickCmd()
+	}
+	return m, nil
+}
+
+// refresh takes a new snapshot and updates the operation rate
+func (m *adminModel) refresh() {
+	m.stats = m.server.Stats()
+
+	now := time.Now()
+	if elapsed := now.Sub(m.lastPoll).Seconds(); elapsed >= adminRefreshInterval.Seconds()/2 {
+		m.opsRate = float64(m.stats.OpsTotal-m.lastOps) / elapsed
+		m.lastOps = m.stats.OpsTotal
+		m.lastPoll = now
+	}
+
+	if m.selected >= len(m.stats.Clients) {
+		m.selected = max(len(m.stats.Clients)-1, 0)
+	}
+}
+
+func (m *adminModel) View() string {
+	boxStyle := lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		Padding(0, 1).
+		BorderForeground(borderColor())
+	titleStyle := lipgloss.NewStyle().Bold(true)
+	highlightStyle := lipgloss.NewStyle().Reverse(true)
+
+	lockState := "unlocked"
+	if m.stats.Locked {
+		lockState = "LOCKED"
+	}
+	if m.stats.Parked {
+		lockState += ", parked"
+	}
+	title := fmt.Sprintf("Room: %s", m.stats.Name)
+	if others := len(m.stats.Rooms) - 1; others > 0 {
+		title += fmt.Sprintf(" (and %d other room(s))", others)
+	}
+	summary := []string{
+		titleStyle.Render(title),
+		fmt.Sprintf("Uptime: %s   Users: %d   Characters: %d   Language: %s   Document: %s",
+			m.stats.Uptime.Truncate(time.Second), len(m.stats.Clients), m.stats.Characters, m.stats.Language, lockState),
+		fmt.Sprintf("Ops/sec: %.1f   Total ops: %d   Quotas: %s", m.opsRate, m.stats.OpsTotal, quotasString(m.stats.Quotas)),
+	}
+
+	users := []string{titleStyle.Render("Users")}
+	if len(m.stats.Clients) == 0 {
+		users = append(users, "  (no clients connected)")
+	}
+	for i, c := range m.stats.Clients {
+		line := fmt.Sprintf("  %-24s %-22s ops: %-6d bytes: %-8d connected %s ago",
+			clientLabel(c), c.Addr, c.Ops, c.Bytes, time.Since(c.ConnectedAt).Truncate(time.Second))
+		if c.Throttled > 0 {
+			line += fmt.Sprintf("   throttled %d time(s)", c.Throttled)
+		}
+		if c.ReadOnly {
+			line += "   read-only"
+		}
+		if c.User != nil && c.User.Role != "" {
+			line += "   " + string(c.User.Role)
+		}
+		if c.Room != m.stats.Name {
+			line += "   in " + c.Room
+		}
+		if i == m.selected {
+			line = highlightStyle.Render(line)
+		}
+		users = append(users, line)
+	}
+
+	errorLines := []string{titleStyle.Render("Recent errors")}
+	recent := m.stats.Errors
+	if len(recent) > 8 {
+		recent = recent[len(recent)-8:]
+	}
+	if len(recent) == 0 {
+		errorLines = append(errorLines, "  (none)")
+	}
+	for _, e := range recent {
+		errorLines = append(errorLines, errorStyle().Render("  "+e))
+	}
+
+	notes := []string{
+		fmt.Sprintf("Status: %s", m.status),
+		"Commands:",
+		"  Up/Down: Select user   K: Kick user   W: Revoke/grant write access   L: Lock/unlock document   Q: Quit",
+	}
+
+	return lipgloss.JoinVertical(lipgloss.Left,
+		boxStyle.Render(lipgloss.JoinVertical(lipgloss.Left, summary...)),
+		boxStyle.Render(lipgloss.JoinVertical(lipgloss.Left, users...)),
+		boxStyle.Render(lipgloss.JoinVertical(lipgloss.Left, errorLines...)),
+		boxStyle.Render(lipgloss.JoinVertical(lipgloss.Left, notes...)),
+	)
+}
+
+// quotasString describes the per-user quotas
+func quotasString(q server.Quotas) string {
+	var parts []string
+	if q.OpsPerMinute > 0 {
+		parts = append(parts, fmt.Sprintf("%d ops/min", q.OpsPerMinute))
+	}
+	if q.MaxPasteSize > 0 {
+		parts = append(parts, fmt.Sprintf("paste %d chars", q.MaxPasteSize))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// clientLabel returns the best available name for a client
+func clientLabel(c server.ClientInfo) string {
+	if c.UserName != "" {
+		return c.UserName
+	}
+	if c.UserID != 0 {
+		return fmt.Sprintf("User-%d", c.UserID)
+	}
+	return "(unidentified)"
+}
+
+// StartAdminTUI runs a live dashboard of a server's users, throughput and errors
+func StartAdminTUI(srv *server.Server) error {
+	p := tea.NewProgram(newAdminModel(srv), tea.WithAltScreen())
+	_, err := p.Run()
+	return err
+}
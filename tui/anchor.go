@@ -0,0 +1,33 @@
+package core
+
+import "gollaborate/crdt"
+
+// cursorAnchors ties the cursor and selection start to characters in the document,
+// so they can be put back in the same place when a sync changes the text around them
+type cursorAnchors struct {
+	cursor   []crdt.Identifier
+	selStart []crdt.Identifier
+	valid    bool
+}
+
+// anchorCursor records the characters under the cursor and selection start
+func (m *model) anchorCursor() {
+	m.anchors = cursorAnchors{
+		cursor: m.doc.AnchorAt(m.cursorY, m.cursorX),
+		valid:  true,
+	}
+	if m.selectionActive {
+		m.anchors.selStart = m.doc.AnchorAt(m.selStartY, m.selStartX)
+	}
+}
+
+// restoreCursor moves the cursor and selection start back to their anchored characters
+func (m *model) restoreCursor() {
+	if !m.anchors.valid {
+		return
+	}
+	m.cursorY, m.cursorX = m.doc.Locate(m.anchors.cursor)
+	if m.selectionActive {
+		m.selStartY, m.selStartX = m.doc.Locate(m.anchors.selStart)
+	}
+}
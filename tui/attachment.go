@@ -0,0 +1,117 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gollaborate/messages"
+	"gollaborate/shared"
+)
+
+// shareFile shares a file from disk with the session
+func (m *model) shareFile(path string) {
+	if path == "" {
+		m.status = "Usage: /attach PATH"
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		m.status = fmt.Sprintf("Attach failed: %v", err)
+		return
+	}
+	a, err := m.editorState.ShareAttachment(path, data)
+	if err != nil {
+		m.status = fmt.Sprintf("Attach failed: %v", err)
+		return
+	}
+	m.status = fmt.Sprintf("Shared %s (%s)", a.Name, formatSize(a.Size))
+}
+
+// saveFile writes a shared file to disk, by default under its own name in the
+// working directory. Existing files are never overwritten.
+func (m *model) saveFile(arg string) {
+	name, path, _ := strings.Cut(arg, " ")
+	a, ok := m.findAttachment(name)
+	if !ok {
+		m.status = fmt.Sprintf("No shared file %q", name)
+		return
+	}
+	data, err := m.editorState.AttachmentData(a.ID)
+	if err != nil {
+		m.status = fmt.Sprintf("Save failed: %v (%.0f%%)", err, 100*a.Progress())
+		return
+	}
+	if path = strings.TrimSpace(path); path == "" {
+		path = a.Name
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err == nil {
+		_, err = f.Write(data)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		m.status = fmt.Sprintf("Save failed: %v", err)
+		return
+	}
+	m.status = fmt.Sprintf("Saved %s to %s", a.Name, path)
+}
+
+// resumeFile asks peers for the rest of a shared file that stopped arriving
+func (m *model) resumeFile(name string) {
+	a, ok := m.findAttachment(name)
+	if !ok {
+		m.status = fmt.Sprintf("No shared file %q", name)
+		return
+	}
+	if err := m.editorState.ResumeAttachment(a.ID); err != nil {
+		m.status = fmt.Sprintf("Resume failed: %v", err)
+		return
+	}
+	m.status = fmt.Sprintf("Fetching the rest of %s (%.0f%%)", a.Name, 100*a.Progress())
+}
+
+// findAttachment finds a shared file by name or ID, the latest shared if
+// several have the name
+func (m *model) findAttachment(name string) (shared.Attachment, bool) {
+	files := m.editorState.Attachments()
+	for i := len(files) - 1; i >= 0; i-- {
+		if files[i].Name == name || files[i].ID == name {
+			return files[i], true
+		}
+	}
+	return shared.Attachment{}, false
+}
+
+// attachmentStatus describes a peer's shared file arriving
+func (m *model) attachmentStatus(msg *messages.Message) {
+	a, ok := m.findAttachment(msg.Attachment.ID)
+	if !ok {
+		return
+	}
+	name := a.UserName
+	if name == "" {
+		name = fmt.Sprintf("User-%d", a.UserID)
+	}
+	switch {
+	case msg.Type == messages.MessageTypeAttachment:
+		m.status = fmt.Sprintf("%s is sharing %s (%s)", name, a.Name, formatSize(a.Size))
+	case a.Complete():
+		m.status = fmt.Sprintf("%s shared %s: /save %s to keep it", name, a.Name, a.Name)
+	default:
+		m.status = fmt.Sprintf("Receiving %s from %s: %.0f%%", a.Name, name, 100*a.Progress())
+	}
+}
+
+// formatSize gives a file size in the largest unit that fits
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"sync"
+
+	"gollaborate/crdt"
+)
+
+// changeQueue collects changes made to the document outside Update, such as
+// operations from peers applied by the editor state, so the cursor can follow
+// them. It has its own lock because the editor state reports changes while
+// holding its mutex, which Update may be waiting for.
+type changeQueue struct {
+	mutex   sync.Mutex
+	changes []crdt.Change
+	watched *crdt.Document
+	// Documents listened to, such as the live document and a private fork;
+	// only changes to the one being watched are queued
+	hooked map[*crdt.Document]bool
+}
+
+// watchDocument starts queueing the changes made to the current document,
+// dropping those queued for another
+func (m *model) watchDocument() {
+	q := m.changes
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if m.doc == nil || q.watched == m.doc {
+		return
+	}
+	q.watched = m.doc
+	q.changes = nil
+	if q.hooked[m.doc] {
+		return
+	}
+	if q.hooked == nil {
+		q.hooked = make(map[*crdt.Document]bool)
+	}
+	q.hooked[m.doc] = true
+	doc := m.doc
+	doc.AddChangeListener(func(c crdt.Change) {
+		q.mutex.Lock()
+		if q.watched == doc {
+			q.changes = append(q.changes, c)
+		}
+		q.mutex.Unlock()
+	})
+}
+
+// take returns the queued changes and clears the queue
+func (q *changeQueue) take() []crdt.Change {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	changes := q.changes
+	q.changes = nil
+	return changes
+}
+
+// followChanges moves the cursor and selection start so they stay on the same
+// text after changes made since the last update
+func (m *model) followChanges() {
+	changes := m.changes.take()
+	m.highlightEdits(changes)
+	for _, c := range changes {
+		m.cursorY, m.cursorX = c.Transform(m.cursorY, m.cursorX)
+		if m.selectionActive {
+			m.selStartY, m.selStartX = c.Transform(m.selStartY, m.selStartX)
+		}
+	}
+}
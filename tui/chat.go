@@ -0,0 +1,287 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gollaborate/messages"
+	"gollaborate/shared"
+	"gollaborate/users"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// chatRows is how many of the latest chat messages the chat view shows
+const chatRows = 15
+
+// chatView is the session chat, with the message being typed
+type chatView struct {
+	draft []rune
+}
+
+// toggleChat opens or closes the session chat
+func (m *model) toggleChat() {
+	if m.chat != nil {
+		m.chat = nil
+		return
+	}
+	m.status = ""
+	m.chat = &chatView{}
+}
+
+// updateChat handles key presses while the session chat is open. Keys type into
+// the draft rather than the document, so read-only participants can chat too.
+func (m *model) updateChat(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC, tea.KeyCtrlQ:
+		return m, tea.Quit
+	case tea.KeyCtrlE, tea.KeyEsc:
+		m.toggleChat()
+	case tea.KeyEnter:
+		if draft := string(m.chat.draft); strings.HasPrefix(draft, "/") {
+			m.chatCommand(draft)
+			m.chat.draft = nil
+			break
+		}
+		if err := m.editorState.SendChat(string(m.chat.draft)); err != nil {
+			m.status = fmt.Sprintf("Chat failed: %v", err)
+			break
+		}
+		m.chat.draft = nil
+	case tea.KeyBackspace, tea.KeyDelete:
+		if n := len(m.chat.draft); n > 0 {
+			m.chat.draft = m.chat.draft[:n-1]
+		}
+	case tea.KeySpace:
+		m.chat.draft = append(m.chat.draft, ' ')
+	case tea.KeyRunes:
+		m.chat.draft = append(m.chat.draft, msg.Runes...)
+	}
+	return m, nil
+}
+
+// chatCommand runs a command typed in the chat: /name to change the user's name
+// and /color to change their color, which every peer is told about, /attach,
+// /save and /resume to share files with the session, /readonly and /writable
+// to revoke and grant a participant's write access, /rooms to list the
+// documents a server hosts, /admin to run a server's admin commands and
+// /history to recover the server's document as it was earlier
+func (m *model) chatCommand(line string) {
+	command, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+	arg = strings.TrimSpace(arg)
+	var err error
+	switch command {
+	case "/name":
+		err = m.editorState.SetUserInfo(arg, "")
+	case "/color":
+		if arg == "" {
+			m.status = "Usage: /color #RRGGBB"
+			return
+		}
+		err = m.editorState.SetUserInfo(m.userName, arg)
+	case "/attach":
+		m.shareFile(arg)
+		return
+	case "/save":
+		m.saveFile(arg)
+		return
+	case "/resume":
+		m.resumeFile(arg)
+		return
+	case "/readonly", "/writable":
+		m.setWriteAccess(arg, command == "/writable")
+		return
+	case "/rooms":
+		m.editorState.BroadcastMessage(messages.NewListRoomsMessage(m.userID))
+		m.status = "Asking the server for its rooms..."
+		return
+	case "/admin":
+		m.adminCommand(arg)
+		return
+	case "/history":
+		m.historyCommand(arg, time.Now())
+		return
+	default:
+		m.status = fmt.Sprintf("Unknown command %s: try /name, /color, /attach, /save, /resume, /readonly, /writable, /rooms, /admin or /history", command)
+		return
+	}
+	if err != nil {
+		m.status = fmt.Sprintf("%s failed: %v", command, err)
+		return
+	}
+	local := m.editorState.LocalPresence()
+	m.userName, m.userColor = local.UserName, local.Color
+	m.status = fmt.Sprintf("You are now %s", m.userName)
+}
+
+// roomsStatus lists the rooms a server hosts, with how many are in each
+func roomsStatus(rooms []messages.RoomInfo) string {
+	names := make([]string, len(rooms))
+	for i, room := range rooms {
+		names[i] = fmt.Sprintf("%s (%d)", room.Name, room.Users)
+	}
+	return "Rooms: " + strings.Join(names, ", ")
+}
+
+// adminUsage lists the admin commands a server runs for admins
+const adminUsage = "Usage: /admin kick USER-ID, /admin lock, /admin unlock, /admin freeze, /admin unfreeze, /admin save, /admin export FILE or /admin invite [viewer|editor|admin]"
+
+// adminCommand asks the server to run an admin command: kick a user by ID,
+// lock or unlock the document, freeze or unfreeze it, save it to the server's store or export it to a
+// local file, or mint a join code for it. The server refuses those who are not admins.
+func (m *model) adminCommand(arg string) {
+	name, rest, _ := strings.Cut(arg, " ")
+	rest = strings.TrimSpace(rest)
+	command := messages.AdminCommand(name)
+	target := 0
+	switch command {
+	case messages.AdminCommandKick:
+		id, err := strconv.Atoi(strings.TrimPrefix(rest, "User-"))
+		if err != nil || id == 0 {
+			m.status = adminUsage
+			return
+		}
+		target = id
+	case messages.AdminCommandLock, messages.AdminCommandUnlock, messages.AdminCommandFreeze,
+		messages.AdminCommandUnfreeze, messages.AdminCommandSave:
+	case messages.AdminCommandExport:
+		if rest == "" {
+			m.status = adminUsage
+			return
+		}
+		m.exportPath = rest
+	case messages.AdminCommandInvite:
+		if _, err := users.ParseRole(rest); rest != "" && err != nil {
+			m.status = adminUsage
+			return
+		}
+		m.editorState.BroadcastMessage(messages.NewInviteMessage(rest, 0, m.userID))
+		m.status = "Asking the server for a join code..."
+		return
+	default:
+		m.status = adminUsage
+		return
+	}
+	m.editorState.BroadcastMessage(messages.NewAdminMessage(command, target, m.userID))
+	m.status = fmt.Sprintf("Asking the server to %s...", command)
+}
+
+// adminDone shows that the server ran an admin command, writing out the
+// document it exported
+func (m *model) adminDone(msg *messages.Message) {
+	if msg.Command == messages.AdminCommandInvite {
+		m.status = fmt.Sprintf("Join code for this document: %s (join with --join-code)", msg.Text)
+		return
+	}
+	if msg.Command != messages.AdminCommandExport {
+		m.status = fmt.Sprintf("The server ran %s", msg.Command)
+		return
+	}
+	if m.exportPath == "" {
+		return
+	}
+	path := m.exportPath
+	m.exportPath = ""
+	if err := os.WriteFile(path, []byte(msg.Text), 0o644); err != nil {
+		m.status = fmt.Sprintf("Export failed: %v", err)
+		return
+	}
+	m.status = fmt.Sprintf("Exported the server's document to %s", path)
+}
+
+// historyUsage explains the /history command
+const historyUsage = "Usage: /history WHEN FILE, where WHEN is how long ago, like 10m, or a time today, like 15:04"
+
+// historyCommand asks the server for its document as it was at a time, to
+// write to a local file, so content deleted earlier can be recovered. The time
+// is either how long ago or a time of day today.
+func (m *model) historyCommand(arg string, now time.Time) {
+	when, path, _ := strings.Cut(arg, " ")
+	path = strings.TrimSpace(path)
+	at, ok := parseHistoryTime(when, now)
+	if !ok || path == "" {
+		m.status = historyUsage
+		return
+	}
+	m.recoverPath = path
+	m.editorState.BroadcastMessage(messages.NewDocumentAtMessage(at, nil, m.userID))
+	m.status = fmt.Sprintf("Asking the server for the document as of %s...", at.Format("15:04:05"))
+}
+
+// parseHistoryTime reads when /history should go back to
+func parseHistoryTime(when string, now time.Time) (time.Time, bool) {
+	if ago, err := time.ParseDuration(when); err == nil && ago >= 0 {
+		return now.Add(-ago), true
+	}
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if clock, err := time.ParseInLocation(layout, when, now.Location()); err == nil {
+			y, mo, d := now.Date()
+			return time.Date(y, mo, d, clock.Hour(), clock.Minute(), clock.Second(), 0, now.Location()), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// historyDone writes out the document the server rebuilt for /history
+func (m *model) historyDone(msg *messages.Message) {
+	if m.recoverPath == "" {
+		return
+	}
+	path := m.recoverPath
+	m.recoverPath = ""
+	if err := os.WriteFile(path, []byte(msg.Document.ToText()), 0o644); err != nil {
+		m.status = fmt.Sprintf("Recovering failed: %v", err)
+		return
+	}
+	m.status = fmt.Sprintf("Wrote the document as of %s to %s", time.UnixMilli(msg.At).Format("15:04:05"), path)
+}
+
+// announceChat shows a peer's chat message while the chat is closed
+func (m *model) announceChat(name string, text string) {
+	if m.chat == nil {
+		m.showBanner(fmt.Sprintf("%s: %s   Ctrl+E to reply", name, text))
+	}
+}
+
+// chatName is how the chat names the sender of a message
+func chatName(chat shared.ChatMessage) string {
+	if chat.UserName == "" {
+		return fmt.Sprintf("User-%d", chat.UserID)
+	}
+	return chat.UserName
+}
+
+// chatViewString renders the session chat
+func (m *model) chatViewString() string {
+	boxStyle := lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		Padding(0, 1).
+		BorderForeground(borderColor())
+
+	chat := m.editorState.Chat()
+	chat = chat[max(0, len(chat)-chatRows):]
+	rows := []string{}
+	for _, c := range chat {
+		rows = append(rows, fmt.Sprintf("%s  %s: %s", c.SentAt.Format("15:04"), chatName(c), c.Text))
+	}
+	if len(rows) == 0 {
+		rows = append(rows, "No messages yet")
+	}
+
+	notes := []string{
+		"> " + string(m.chat.draft) + "_",
+		"Commands:",
+		"  Enter: Send   Esc: Back to editing   /name NAME: Rename yourself   /color #RRGGBB: Change color",
+		"  /attach PATH: Share a file   /save NAME [PATH]: Save a shared file   /resume NAME: Fetch the rest of a file",
+		"  /readonly NAME: Revoke a participant's write access   /writable NAME: Grant it again",
+	}
+	if m.status != "" {
+		notes = append(notes, m.status)
+	}
+	return boxStyle.Render(lipgloss.JoinVertical(lipgloss.Left, rows...)) + "\n" +
+		boxStyle.MarginTop(1).Render(lipgloss.JoinVertical(lipgloss.Left, notes...))
+}
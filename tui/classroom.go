@@ -0,0 +1,169 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"gollaborate/crdt"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// submissionPreviewLines is how much of the chosen submission the list shows
+const submissionPreviewLines = 10
+
+// forkState is the user's private copy of the shared document, kept while they
+// go back and forth between it and the live document
+type forkState struct {
+	doc *crdt.Document
+	// Where the cursor was in the document not being shown
+	away []crdt.Identifier
+}
+
+// submissionView is the list of work handed in to the presenter, newest first
+type submissionView struct {
+	selected int
+}
+
+// canEdit reports whether the user may edit the document being shown: anyone
+// may edit their own fork
+func (m *model) canEdit() bool {
+	return m.forked || m.editorState.CanEdit()
+}
+
+// toggleFork switches between the live document and the user's private fork of
+// it, making the fork the first time. Edits to the fork are never sent to peers.
+func (m *model) toggleFork() {
+	if m.forked {
+		m.forked = false
+		m.showDocument(m.editorState.Document())
+		m.status = "Back to the live document: Ctrl+F to return to your fork"
+		return
+	}
+	if m.fork == nil {
+		doc, err := m.editorState.Fork()
+		if err != nil {
+			m.status = fmt.Sprintf("Fork failed: %v", err)
+			return
+		}
+		doc.EnableWords()
+		m.fork = &forkState{doc: doc, away: m.doc.AnchorAt(m.cursorY, m.cursorX)}
+	}
+	m.forked = true
+	m.showDocument(m.fork.doc)
+	m.status = "Editing your private fork: Ctrl+K to submit it, Ctrl+F for the live document"
+}
+
+// showDocument switches to showing and editing another document, putting the
+// cursor back where it was in that one
+func (m *model) showDocument(doc *crdt.Document) {
+	away := m.doc.AnchorAt(m.cursorY, m.cursorX)
+	m.doc = doc
+	m.cursorY, m.cursorX = doc.Locate(m.fork.away)
+	m.fork.away = away
+	m.selectionActive = false
+	m.completion = nil
+	m.watchDocument()
+}
+
+// submitOrReview hands in the user's fork, or for the presenter opens the work
+// students handed in
+func (m *model) submitOrReview() {
+	switch {
+	case m.forked:
+		if err := m.editorState.Submit(m.doc.ToText()); err != nil {
+			m.status = fmt.Sprintf("Submit failed: %v", err)
+			return
+		}
+		m.status = "Submitted your fork to the presenter"
+	case m.editorState.Presenting():
+		if len(m.editorState.Submissions()) == 0 {
+			m.status = "No work handed in yet"
+			return
+		}
+		m.submissions = &submissionView{}
+	default:
+		m.status = "Fork the document with Ctrl+F to submit your own copy"
+	}
+}
+
+// updateSubmissions handles key presses while reviewing submissions
+func (m *model) updateSubmissions(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	submissions := m.editorState.Submissions()
+	switch msg.String() {
+	case "ctrl+c", "ctrl+q":
+		return m, tea.Quit
+	case "ctrl+k", "esc":
+		m.submissions = nil
+	case "up":
+		m.submissions.selected = max(0, m.submissions.selected-1)
+	case "down":
+		m.submissions.selected = min(len(submissions)-1, m.submissions.selected+1)
+	case "enter":
+		// Show the class a student's work by pasting it into the document
+		if !m.canEdit() {
+			m.status = "Read-only: you cannot edit this document"
+			break
+		}
+		m.pasteText(submissions[len(submissions)-1-m.submissions.selected].Text)
+		m.submissions = nil
+	}
+	return m, nil
+}
+
+// announceSubmission tells the presenter a student handed in their work
+func (m *model) announceSubmission(name string) {
+	m.showBanner(fmt.Sprintf("%s handed in their work: Ctrl+K to review", name))
+}
+
+// submissionsViewString renders the work handed in, with the chosen one's start
+func (m *model) submissionsViewString() string {
+	boxStyle := lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		Padding(0, 1).
+		BorderForeground(borderColor())
+	highlightStyle := lipgloss.NewStyle().Reverse(true)
+
+	submissions := m.editorState.Submissions()
+	var rows []string
+	var preview []string
+	for i := len(submissions) - 1; i >= 0; i-- {
+		s := submissions[i]
+		name := s.UserName
+		if name == "" {
+			name = fmt.Sprintf("User-%d", s.UserID)
+		}
+		lines := strings.Split(strings.TrimSuffix(s.Text, "\n"), "\n")
+		row := fmt.Sprintf("%s  %s (%d line(s))", s.Time.Format("15:04:05"), name, len(lines))
+		if len(rows) == m.submissions.selected {
+			row = highlightStyle.Render(row)
+			preview = lines[:min(len(lines), submissionPreviewLines)]
+			if len(lines) > submissionPreviewLines {
+				preview = append(preview, "...")
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	notes := []string{
+		"Submissions: work students handed in from their forks, newest first",
+		"Commands:",
+		"  Up/Down: Choose   Enter: Paste into the document   Esc: Back to editing",
+	}
+	return boxStyle.Render(lipgloss.JoinVertical(lipgloss.Left, rows...)) + "\n" +
+		boxStyle.Render(lipgloss.JoinVertical(lipgloss.Left, preview...)) + "\n" +
+		boxStyle.MarginTop(1).Render(lipgloss.JoinVertical(lipgloss.Left, notes...))
+}
+
+// classroomNote describes the fork being edited, or the submissions waiting for
+// the presenter, for the notes area
+func (m *model) classroomNote() string {
+	if m.forked {
+		return "Private fork: edits are not shared   Ctrl+F: Live document   Ctrl+K: Submit to presenter"
+	}
+	if m.editorState.Presenting() {
+		return fmt.Sprintf("Presenting: students can only watch   Ctrl+K: Submissions (%d)", len(m.editorState.Submissions()))
+	}
+	return ""
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"fmt"
+
+	"gollaborate/crdt"
+	"gollaborate/cursor"
+	"gollaborate/messages"
+)
+
+// maxKillRing is how many cuts are remembered
+const maxKillRing = 16
+
+// A remote paste at least this many lines or characters long is announced with a banner
+const (
+	largePasteLines = 3
+	largePasteChars = 200
+)
+
+// cutSelection moves the selected text to the kill ring and deletes it as one transaction
+func (m *model) cutSelection() {
+	if !m.selectionActive {
+		m.status = "Nothing selected to cut"
+		return
+	}
+
+	text, err := m.selectionText()
+	if err != nil {
+		m.status = fmt.Sprintf("Cut failed: %v", err)
+		return
+	}
+	if text != "" {
+		m.killRing = append(m.killRing, text)
+		if len(m.killRing) > maxKillRing {
+			m.killRing = m.killRing[len(m.killRing)-maxKillRing:]
+		}
+	}
+
+	m.deleteSelectionAs(messages.TransactionActionCut)
+	m.selectionActive = false
+	m.status = fmt.Sprintf("Cut %d character(s)", len([]rune(text)))
+}
+
+// pasteKillRing inserts the most recent cut at the cursor as one transaction
+func (m *model) pasteKillRing() {
+	if len(m.killRing) == 0 {
+		m.status = "Nothing to paste"
+		return
+	}
+	m.pasteText(m.killRing[len(m.killRing)-1])
+}
+
+// pasteText replaces the selection, if any, with text and sends the insertion as one transaction
+func (m *model) pasteText(text string) {
+	if m.selectionActive {
+		m.deleteSelection()
+		m.selectionActive = false
+	}
+
+	m.sendTransaction(messages.TransactionActionPaste, m.insertText(text))
+	m.status = fmt.Sprintf("Pasted %d character(s)", len([]rune(text)))
+}
+
+// announcePaste shows a banner when a peer pastes a large block, so the sudden
+// change is attributed instead of disorienting
+func (m *model) announcePaste(msg *messages.Message) {
+	var first *messages.Operation
+	chars, lines := 0, 1
+	for _, op := range msg.Operations {
+		if op.Type != messages.OperationTypeInsert {
+			continue
+		}
+		if first == nil {
+			first = op
+		}
+		chars++
+		if op.Character == '\n' {
+			lines++
+		}
+	}
+	if first == nil || (lines < largePasteLines && chars < largePasteChars) {
+		return
+	}
+
+	name := msg.UserName
+	if name == "" {
+		name = fmt.Sprintf("User-%d", msg.UserID)
+	}
+	manager := cursor.NewManager(m.doc, m.userID, m.userName, m.userColor)
+	coords, err := manager.GetTextCoordsFromCRDTPosition(first.Position)
+	if err != nil {
+		return
+	}
+
+	if lines > 1 {
+		m.showBanner(fmt.Sprintf("%s pasted %d lines at line %d", name, lines, coords.Line))
+	} else {
+		m.showBanner(fmt.Sprintf("%s pasted %d characters at line %d", name, chars, coords.Line))
+	}
+}
+
+// insertText inserts text at the cursor, advancing past it, and returns the
+// operations for peers without sending them
+func (m *model) insertText(text string) []*messages.Operation {
+	var ops []*messages.Operation
+	for _, r := range text {
+		op := m.insertAt(m.cursorY, m.cursorX, r)
+		if op == nil {
+			continue
+		}
+		ops = append(ops, op)
+		if op.Character == '\n' {
+			m.cursorY++
+			m.cursorX = 1
+		} else {
+			m.cursorX++
+		}
+	}
+	return ops
+}
+
+// selectionText returns the selected text, from the selection start up to but
+// not including the cursor (or the other way round)
+func (m *model) selectionText() (string, error) {
+	sy, sx := m.selStartY, m.selStartX
+	ey, ex := m.cursorY, m.cursorX
+	if sy > ey || (sy == ey && sx > ex) {
+		sy, sx, ey, ex = ey, ex, sy, sx
+	}
+
+	startPos, ok := m.characterFrom(sy, sx)
+	if !ok {
+		return "", nil
+	}
+	// With no character at or after the end, the selection runs to the end of the document
+	endPos, _ := m.characterFrom(ey, ex)
+	return m.doc.TextBetween(startPos, endPos)
+}
+
+// characterFrom returns the position of the first character at or after the
+// given line and column, or false if there is none
+func (m *model) characterFrom(line, column int) ([]crdt.Identifier, bool) {
+	for y := line; y <= len(m.doc.Lines); y++ {
+		chars := m.doc.Lines[y-1].Characters
+		if column <= len(chars) {
+			return chars[column-1].Pos, true
+		}
+		column = 1
+	}
+	return nil, false
+}
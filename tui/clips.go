@@ -0,0 +1,117 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// clipPreviewLength is how much of a snippet's first line the clip list shows
+const clipPreviewLength = 50
+
+// clipView is the list of snippets on the session clipboard, newest first
+type clipView struct {
+	selected int
+}
+
+// shareSelection puts the selected text on every participant's session clipboard
+func (m *model) shareSelection() {
+	if !m.selectionActive {
+		m.status = "Select text to share it"
+		return
+	}
+	text, err := m.selectionText()
+	if err == nil {
+		err = m.editorState.ShareClip(text)
+	}
+	if err != nil {
+		m.status = fmt.Sprintf("Share failed: %v", err)
+		return
+	}
+	m.selectionActive = false
+	m.status = fmt.Sprintf("Shared %d character(s) with the session", len([]rune(text)))
+}
+
+// toggleClips opens or closes the session clipboard
+func (m *model) toggleClips() {
+	if m.clips != nil {
+		m.clips = nil
+		return
+	}
+	if len(m.editorState.Clips()) == 0 {
+		m.status = "No snippets shared yet"
+		return
+	}
+	m.clips = &clipView{}
+}
+
+// updateClips handles key presses while the session clipboard is open
+func (m *model) updateClips(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	clips := m.editorState.Clips()
+	switch msg.String() {
+	case "ctrl+c", "ctrl+q":
+		return m, tea.Quit
+	case "ctrl+b", "esc":
+		m.toggleClips()
+	case "up":
+		m.clips.selected = max(0, m.clips.selected-1)
+	case "down":
+		m.clips.selected = min(len(clips)-1, m.clips.selected+1)
+	case "enter":
+		if !m.canEdit() {
+			m.status = "Read-only: you cannot edit this document"
+			break
+		}
+		m.pasteText(clips[len(clips)-1-m.clips.selected].Text)
+		m.clips = nil
+	}
+	return m, nil
+}
+
+// announceClip tells the user a peer shared a snippet
+func (m *model) announceClip(name string, text string) {
+	lines := strings.Count(strings.TrimSuffix(text, "\n"), "\n") + 1
+	if lines > 1 {
+		m.showBanner(fmt.Sprintf("%s shared %d lines: Ctrl+B to paste", name, lines))
+	} else {
+		m.showBanner(fmt.Sprintf("%s shared %d characters: Ctrl+B to paste", name, len([]rune(text))))
+	}
+}
+
+// clipsViewString renders the session clipboard
+func (m *model) clipsViewString() string {
+	boxStyle := lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		Padding(0, 1).
+		BorderForeground(borderColor())
+	highlightStyle := lipgloss.NewStyle().Reverse(true)
+
+	clips := m.editorState.Clips()
+	var rows []string
+	for i := len(clips) - 1; i >= 0; i-- {
+		clip := clips[i]
+		name := clip.UserName
+		if name == "" {
+			name = fmt.Sprintf("User-%d", clip.UserID)
+		}
+		preview, _, _ := strings.Cut(clip.Text, "\n")
+		if r := []rune(preview); len(r) > clipPreviewLength {
+			preview = string(r[:clipPreviewLength]) + "..."
+		}
+		row := fmt.Sprintf("%s  %s: %s (%d characters)", clip.Time.Format("15:04:05"), name, preview, len([]rune(clip.Text)))
+		if len(rows) == m.clips.selected {
+			row = highlightStyle.Render(row)
+		}
+		rows = append(rows, row)
+	}
+
+	notes := []string{
+		"Session clipboard: snippets shared by participants, newest first",
+		"Commands:",
+		"  Up/Down: Choose   Enter: Paste   Esc: Back to editing",
+	}
+	return boxStyle.Render(lipgloss.JoinVertical(lipgloss.Left, rows...)) + "\n" +
+		boxStyle.MarginTop(1).Render(lipgloss.JoinVertical(lipgloss.Left, notes...))
+}
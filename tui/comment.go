@@ -0,0 +1,108 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"gollaborate/messages"
+)
+
+// toggleComment comments out the selected lines (or the cursor line), or uncomments
+// them if every non-blank line is already commented. The edits are sent to peers as
+// one transaction so they are applied atomically.
+func (m *model) toggleComment() {
+	lang := m.language()
+	if lang.LineComment == "" {
+		m.status = fmt.Sprintf("No line comments for %s", lang.Name)
+		return
+	}
+
+	first, last := m.cursorY, m.cursorY
+	if m.selectionActive {
+		first, last = min(m.selStartY, m.cursorY), max(m.selStartY, m.cursorY)
+	}
+
+	// Only uncomment when every non-blank line already starts with the comment prefix
+	uncomment := true
+	blank := true
+	for y := first; y <= last; y++ {
+		trimmed := strings.TrimLeft(m.lineText(y), " \t")
+		if trimmed == "" {
+			continue
+		}
+		blank = false
+		if !strings.HasPrefix(trimmed, lang.LineComment) {
+			uncomment = false
+		}
+	}
+	if blank {
+		return
+	}
+
+	var ops []*messages.Operation
+	for y := first; y <= last; y++ {
+		text := []rune(m.lineText(y))
+		indent := 0
+		for indent < len(text) && (text[indent] == ' ' || text[indent] == '\t') {
+			indent++
+		}
+		if indent == len(text) {
+			continue
+		}
+		column := indent + 1
+
+		if uncomment {
+			count := len([]rune(lang.LineComment))
+			if column-1+count < len(text) && text[column-1+count] == ' ' {
+				count++
+			}
+			for i := 0; i < count; i++ {
+				pos := m.doc.Lines[y-1].Characters[column-1].Pos
+				if op := m.deleteAt(pos); op != nil {
+					ops = append(ops, op)
+				}
+			}
+			m.shiftColumns(y, column, -count)
+		} else {
+			prefix := []rune(lang.LineComment + " ")
+			for i, r := range prefix {
+				if op := m.insertAt(y, column+i, r); op != nil {
+					ops = append(ops, op)
+				}
+			}
+			m.shiftColumns(y, column, len(prefix))
+		}
+	}
+
+	m.sendTransaction(messages.TransactionActionComment, ops)
+	if uncomment {
+		m.status = fmt.Sprintf("Uncommented %d line(s)", last-first+1)
+	} else {
+		m.status = fmt.Sprintf("Commented %d line(s)", last-first+1)
+	}
+}
+
+// lineText returns the text of a line (1-based) without its newline
+func (m *model) lineText(line int) string {
+	if line < 1 || line > len(m.doc.Lines) {
+		return ""
+	}
+	var b strings.Builder
+	for _, char := range m.doc.Lines[line-1].Characters {
+		if char.Value != '\n' {
+			b.WriteRune(char.Value)
+		}
+	}
+	return b.String()
+}
+
+// shiftColumns moves the cursor and selection anchor on a line to account for
+// delta characters inserted (or removed, if negative) at the given column
+func (m *model) shiftColumns(line, column, delta int) {
+	if m.cursorY == line && m.cursorX >= column {
+		m.cursorX = max(m.cursorX+delta, column)
+	}
+	if m.selectionActive && m.selStartY == line && m.selStartX >= column {
+		m.selStartX = max(m.selStartX+delta, column)
+	}
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"gollaborate/messages"
+)
+
+// maxCompletions is how many suggestions Ctrl+Space cycles through
+const maxCompletions = 10
+
+// completion is a word completion in progress. Pressing Ctrl+Space or Tab again
+// without moving the cursor replaces it with the next suggestion.
+type completion struct {
+	prefix   string
+	options  []string
+	index    int
+	inserted string // What was typed after the prefix for the current option
+	line     int    // Cursor position after the insertion
+	column   int
+}
+
+// completing reports whether the cursor is still where the last completion left it
+func (m *model) completing() bool {
+	c := m.completion
+	return c != nil && c.line == m.cursorY && c.column == m.cursorX
+}
+
+// complete completes the word before the cursor from words already in the
+// document, or moves on to the next suggestion
+func (m *model) complete() {
+	if m.completing() {
+		m.applyCompletion((m.completion.index + 1) % len(m.completion.options))
+		return
+	}
+
+	prefix := m.wordBeforeCursor()
+	if prefix == "" {
+		m.status = "Nothing to complete"
+		return
+	}
+	options := m.doc.Completions(prefix, maxCompletions)
+	if len(options) == 0 {
+		m.status = fmt.Sprintf("No completions for %q", prefix)
+		return
+	}
+
+	m.completion = &completion{prefix: prefix, options: options}
+	m.applyCompletion(0)
+}
+
+// applyCompletion replaces the current suggestion with the one at index
+func (m *model) applyCompletion(index int) {
+	c := m.completion
+	var ops []*messages.Operation
+	for range []rune(c.inserted) {
+		m.cursorX--
+		pos, err := m.doc.FindPositionAt(m.cursorY, m.cursorX)
+		if err != nil {
+			continue
+		}
+		if op := m.deleteAt(pos); op != nil {
+			ops = append(ops, op)
+		}
+	}
+
+	c.index = index
+	c.inserted = strings.TrimPrefix(c.options[index], c.prefix)
+	ops = append(ops, m.insertText(c.inserted)...)
+	c.line, c.column = m.cursorY, m.cursorX
+	m.sendTransaction(messages.TransactionActionEdit, ops)
+
+	m.status = fmt.Sprintf("Completion %d of %d: %s", index+1, len(c.options), c.options[index])
+	if len(c.options) > 1 {
+		m.status += " (Tab: next)"
+	}
+}
+
+// wordBeforeCursor returns the part of a word that ends at the cursor
+func (m *model) wordBeforeCursor() string {
+	line := []rune(m.lineText(m.cursorY))
+	end := min(m.cursorX-1, len(line))
+	start := end
+	for start > 0 && isWordRune(line[start-1]) {
+		start--
+	}
+	return string(line[start:end])
+}
+
+// isWordRune reports whether a rune can be part of a word or identifier
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,27 @@
+package core
+
+import (
+	"gollaborate/crash"
+)
+
+// crashes recovers panics while the editor updates, see SetCrashReporter
+var crashes *crash.Reporter
+
+// SetCrashReporter makes a panic while the editor handles a key or message write
+// a crash report and keep the editor running, rather than end it. Call it
+// before starting a TUI.
+func SetCrashReporter(reporter *crash.Reporter) {
+	crashes = reporter
+}
+
+// recoverUpdate reports a panic in Update, reporting whether there was one. The
+// update that panicked is abandoned, and the user told.
+func (m *model) recoverUpdate(value any) bool {
+	if !crashes.Handle("editor update", value) {
+		return false
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.status = "Recovered from an internal error; a crash report was written"
+	return true
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"fmt"
+
+	"gollaborate/crdt"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// historyView is the read-only mode that shows the document as it was after
+// some number of the edits in its history
+type historyView struct {
+	step int
+	doc  *crdt.Document
+}
+
+// toggleHistory enters or leaves the history view
+func (m *model) toggleHistory() {
+	if m.history != nil {
+		m.history = nil
+		m.status = "Back to the live document"
+		return
+	}
+	if m.doc.History() == nil {
+		m.status = "This document does not keep a history"
+		return
+	}
+	m.history = &historyView{}
+	m.showHistoryStep(m.doc.History().Len())
+}
+
+// showHistoryStep shows the document after the given number of edits
+func (m *model) showHistoryStep(step int) {
+	h := m.doc.History()
+	m.history.step = max(0, min(step, h.Len()))
+	m.history.doc = h.ReplayFirst(m.history.step)
+}
+
+// updateHistory handles key presses while the history view is open
+func (m *model) updateHistory(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c", "ctrl+q":
+		return m, tea.Quit
+	case "ctrl+t", "esc":
+		m.toggleHistory()
+	case "left":
+		m.showHistoryStep(m.history.step - 1)
+	case "right":
+		m.showHistoryStep(m.history.step + 1)
+	case "up":
+		m.showHistoryStep(m.history.step - 10)
+	case "down":
+		m.showHistoryStep(m.history.step + 10)
+	case "home":
+		m.showHistoryStep(0)
+	case "end":
+		m.showHistoryStep(m.doc.History().Len())
+	case "enter":
+		if !m.editorState.CanEdit() {
+			m.status = "Read-only: you cannot restore this document"
+			break
+		}
+		return m, m.restoreHistoryStep()
+	}
+	return m, nil
+}
+
+// historyStatus describes the point in history being shown
+func (m *model) historyStatus() string {
+	h := m.doc.History()
+	if m.history.step == 0 {
+		return fmt.Sprintf("History: before the first of %d edits", h.Len())
+	}
+	entry := h.Entries[m.history.step-1]
+	return fmt.Sprintf("History: edit %d of %d, at %s", m.history.step, h.Len(), entry.Time.Format("15:04:05"))
+}
+
+// historyViewString renders the history view
+func (m *model) historyViewString() string {
+	boxStyle := lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		Padding(0, 1).
+		BorderForeground(borderColor())
+
+	var textLines []string
+	for _, line := range m.history.doc.Lines {
+		var lineStr string
+		for _, char := range line.Characters {
+			if char.Value != '\n' {
+				lineStr += string(char.Value)
+			}
+		}
+		textLines = append(textLines, lineStr)
+	}
+
+	notes := []string{
+		m.historyStatus(),
+		"Commands:",
+		"  Left/Right: One edit   Up/Down: Ten edits   Home/End: First/Latest   Enter: Restore this version   Esc: Back to editing",
+	}
+	return boxStyle.Render(lipgloss.JoinVertical(lipgloss.Left, textLines...)) + "\n" +
+		boxStyle.MarginTop(1).Render(lipgloss.JoinVertical(lipgloss.Left, notes...))
+}
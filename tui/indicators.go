@@ -0,0 +1,124 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"gollaborate/crdt"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// editHighlightDuration is how long characters peers insert stay highlighted
+const editHighlightDuration = time.Second
+
+// editHighlights marks the characters peers just inserted, in their author's
+// color, so text arriving near the cursor can be followed rather than appearing
+// out of nowhere
+type editHighlights struct {
+	off     bool
+	chars   map[string]editHighlight // By position, see positionKey
+	ticking bool                     // Whether an expiry tick is on its way
+}
+
+// editHighlight is a character a peer inserted
+type editHighlight struct {
+	color string
+	at    time.Time
+}
+
+// editHighlightTick tells the model to drop expired highlights
+type editHighlightTick struct{}
+
+// toggleEditHighlights turns highlighting of peers' edits off or back on
+func (m *model) toggleEditHighlights() {
+	m.highlights.off = !m.highlights.off
+	m.highlights.chars = nil
+	if m.highlights.off {
+		m.status = "Highlighting of peers' edits off: Ctrl+W to turn it back on"
+	} else {
+		m.status = "Highlighting of peers' edits on"
+	}
+}
+
+// highlightEdits highlights the characters peers inserted. The author of an
+// insert is the node that made its position.
+func (m *model) highlightEdits(changes []crdt.Change) {
+	if m.highlights.off || len(changes) == 0 {
+		return
+	}
+	var colors map[int]string
+	now := time.Now()
+	for _, c := range changes {
+		pos := c.Char.Pos
+		if c.Delete || len(pos) == 0 || pos[len(pos)-1].Node == m.userID {
+			continue
+		}
+		if colors == nil {
+			colors = make(map[int]string)
+			for _, p := range m.peers() {
+				colors[p.state.UserID] = p.state.Color
+			}
+		}
+		if m.highlights.chars == nil {
+			m.highlights.chars = make(map[string]editHighlight)
+		}
+		m.highlights.chars[positionKey(pos)] = editHighlight{color: colors[pos[len(pos)-1].Node], at: now}
+	}
+}
+
+// expireEditHighlights drops the highlights that have had their time
+func (m *model) expireEditHighlights() {
+	m.highlights.ticking = false
+	for key, h := range m.highlights.chars {
+		if time.Since(h.at) >= editHighlightDuration {
+			delete(m.highlights.chars, key)
+		}
+	}
+}
+
+// editHighlightCmd schedules the next expiry while there are highlights
+func (m *model) editHighlightCmd() tea.Cmd {
+	if m.highlights.ticking || len(m.highlights.chars) == 0 {
+		return nil
+	}
+	m.highlights.ticking = true
+	return tea.Tick(editHighlightDuration/4, func(time.Time) tea.Msg {
+		return editHighlightTick{}
+	})
+}
+
+// editHighlightAt returns how to draw a character if a peer just inserted it
+func (m *model) editHighlightAt(pos []crdt.Identifier) (lipgloss.Style, bool) {
+	if len(m.highlights.chars) == 0 {
+		return lipgloss.Style{}, false
+	}
+	h, ok := m.highlights.chars[positionKey(pos)]
+	if !ok {
+		return lipgloss.Style{}, false
+	}
+	age := time.Since(h.at)
+	if age >= editHighlightDuration {
+		return lipgloss.Style{}, false
+	}
+	// Fresh edits stand out more, then fade to just the author's color
+	style := lipgloss.NewStyle().Underline(true).Bold(age < editHighlightDuration/2)
+	if !usesMarkers() && h.color != "" {
+		style = style.Foreground(lipgloss.Color(h.color))
+	}
+	return style, true
+}
+
+// positionKey identifies a position in a map
+func positionKey(pos []crdt.Identifier) string {
+	var b strings.Builder
+	for _, ident := range pos {
+		b.WriteString(strconv.Itoa(ident.Digit))
+		b.WriteByte('.')
+		b.WriteString(strconv.Itoa(ident.Node))
+		b.WriteByte(',')
+	}
+	return b.String()
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"strings"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// LogPane keeps the latest lines of diagnostics for the editor's log view, so
+// they can be read without writing over the editor. It is an io.Writer, for
+// log.SetOutput.
+type LogPane struct {
+	mutex   sync.Mutex
+	lines   []string
+	partial string
+	limit   int
+}
+
+// NewLogPane creates a LogPane that keeps at most limit lines
+func NewLogPane(limit int) *LogPane {
+	return &LogPane{limit: max(1, limit)}
+}
+
+// Write adds text to the pane, one line per newline
+func (p *LogPane) Write(b []byte) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	text := p.partial + string(b)
+	lines := strings.Split(text, "\n")
+	p.partial = lines[len(lines)-1]
+	p.lines = append(p.lines, lines[:len(lines)-1]...)
+	if extra := len(p.lines) - p.limit; extra > 0 {
+		p.lines = append([]string(nil), p.lines[extra:]...)
+	}
+	return len(b), nil
+}
+
+// Lines returns the lines in the pane, oldest first
+func (p *LogPane) Lines() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]string(nil), p.lines...)
+}
+
+// logPane is the pane the log view shows, see SetLogPane
+var logPane *LogPane
+
+// SetLogPane sets the pane the editor's log view (Ctrl+L) shows. Call it before
+// starting a TUI.
+func SetLogPane(pane *LogPane) {
+	logPane = pane
+}
+
+// logViewLines is how many of the latest lines the log view shows
+const logViewLines = 20
+
+// toggleLog opens or closes the log view
+func (m *model) toggleLog() {
+	if m.showLog {
+		m.showLog = false
+		m.status = "Back to the document"
+		return
+	}
+	if logPane == nil {
+		m.status = "No log to show"
+		return
+	}
+	m.showLog = true
+}
+
+// updateLog handles key presses while the log view is open
+func (m *model) updateLog(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c", "ctrl+q":
+		return m, tea.Quit
+	case "ctrl+l", "esc":
+		m.toggleLog()
+	}
+	return m, nil
+}
+
+// logViewString renders the log view
+func (m *model) logViewString() string {
+	boxStyle := lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		Padding(0, 1).
+		BorderForeground(borderColor())
+
+	lines := logPane.Lines()
+	if len(lines) > logViewLines {
+		lines = lines[len(lines)-logViewLines:]
+	}
+	if len(lines) == 0 {
+		lines = []string{"Nothing logged yet"}
+	}
+
+	notes := []string{
+		"Log: the latest diagnostics, newest last",
+		"Commands:",
+		"  Esc: Back to editing   Ctrl+Q: Quit",
+	}
+	return boxStyle.Render(lipgloss.JoinVertical(lipgloss.Left, lines...)) + "\n" +
+		boxStyle.MarginTop(1).Render(lipgloss.JoinVertical(lipgloss.Left, notes...))
+}
@@ -0,0 +1,159 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxMacroRepeats is how many times a macro may be replayed at once
+const maxMacroRepeats = 1000
+
+// macroState is the keyboard macro being recorded or kept for replaying
+type macroState struct {
+	recording bool
+	keys      []tea.KeyMsg
+	// The repeat count being typed, nil unless asking for one
+	count []rune
+	// Operations sent by the replay in progress, nil unless replaying
+	ops []*messages.Operation
+	// The changes the last replay made, to undo it
+	replayed []crdt.Change
+}
+
+// macroCommands are the keys that are never recorded: they control macros, or
+// open views and act on the session rather than edit
+var macroCommands = map[string]bool{
+	"ctrl+r": true, "ctrl+g": true, "ctrl+u": true, "ctrl+f": true, "ctrl+k": true, "ctrl+w": true,
+	"ctrl+c": true, "ctrl+q": true, "ctrl+s": true, "ctrl+t": true, "ctrl+l": true, "ctrl+d": true,
+	"ctrl+o": true, "ctrl+n": true, "ctrl+y": true, "ctrl+b": true, "ctrl+e": true, "ctrl+p": true,
+}
+
+// toggleRecording starts recording a macro, replacing the last one, or stops
+func (m *model) toggleRecording() {
+	if m.macro.recording {
+		m.macro.recording = false
+		m.status = fmt.Sprintf("Recorded a macro of %d key(s): Ctrl+G to replay", len(m.macro.keys))
+		return
+	}
+	m.macro.recording = true
+	m.macro.keys = nil
+	m.status = "Recording a macro: Ctrl+R to stop"
+}
+
+// recordKey adds a key press to the macro being recorded
+func (m *model) recordKey(msg tea.KeyMsg) {
+	if m.macro.recording && !macroCommands[msg.String()] {
+		m.macro.keys = append(m.macro.keys, msg)
+	}
+}
+
+// askMacroCount asks how many times to replay the macro
+func (m *model) askMacroCount() {
+	switch {
+	case m.macro.recording:
+		m.status = "Stop recording with Ctrl+R before replaying"
+	case len(m.macro.keys) == 0:
+		m.status = "No macro recorded: Ctrl+R to record one"
+	case !m.canEdit():
+		m.status = "Read-only: you cannot edit this document"
+	default:
+		m.macro.count = []rune{}
+		m.status = "Replay the macro how many times? "
+	}
+}
+
+// updateMacroCount handles key presses while asking how many times to replay
+func (m *model) updateMacroCount(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC, tea.KeyCtrlQ:
+		return m, tea.Quit
+	case tea.KeyEsc:
+		m.macro.count = nil
+		m.status = ""
+		return m, nil
+	case tea.KeyEnter:
+		times := 1
+		if len(m.macro.count) > 0 {
+			times, _ = strconv.Atoi(string(m.macro.count))
+		}
+		m.macro.count = nil
+		m.replayMacro(min(times, maxMacroRepeats))
+		return m, nil
+	case tea.KeyBackspace:
+		if n := len(m.macro.count); n > 0 {
+			m.macro.count = m.macro.count[:n-1]
+		}
+	case tea.KeyRunes:
+		for _, r := range msg.Runes {
+			if r >= '0' && r <= '9' && len(m.macro.count) < 4 {
+				m.macro.count = append(m.macro.count, r)
+			}
+		}
+	}
+	m.status = "Replay the macro how many times? " + string(m.macro.count)
+	return m, nil
+}
+
+// replayMacro replays the recorded keys the given number of times. Everything
+// they change is sent to peers as a single transaction, so peers see one edit
+// and Ctrl+U undoes the whole replay.
+func (m *model) replayMacro(times int) {
+	if times <= 0 {
+		m.status = "Nothing replayed"
+		return
+	}
+	// Changes queued so far were followed at the start of this update
+	m.changes.take()
+	m.macro.ops = []*messages.Operation{}
+	for i := 0; i < times; i++ {
+		for _, key := range m.macro.keys {
+			m.editKey(key)
+		}
+	}
+	ops := m.macro.ops
+	m.macro.ops = nil
+	m.macro.replayed = m.changes.take()
+
+	m.sendTransaction(messages.TransactionActionMacro, ops)
+	m.status = fmt.Sprintf("Replayed the macro %d time(s), %d change(s): Ctrl+U to undo", times, len(ops))
+}
+
+// undoMacro reverts the changes of the last replay, leaving edits made since
+// by anyone else in place. Text it deleted is put back where it was.
+func (m *model) undoMacro() {
+	replayed := m.macro.replayed
+	if len(replayed) == 0 {
+		m.status = "No macro replay to undo"
+		return
+	}
+	m.macro.replayed = nil
+
+	var ops []*messages.Operation
+	for i := len(replayed) - 1; i >= 0; i-- {
+		char := replayed[i].Char
+		if !replayed[i].Delete {
+			if op := m.deleteAt(char.Pos); op != nil {
+				ops = append(ops, op)
+			}
+			continue
+		}
+		m.clock++
+		op := messages.NewInsertOperation(char.Pos, char.Value, m.userID, m.clock)
+		if !m.validate(op) {
+			continue
+		}
+		if m.doc.InsertCharacter(op.Character, op.Position, op.Clock) == nil {
+			ops = append(ops, op)
+		}
+	}
+	// Keep the cursor on the same text
+	m.followChanges()
+
+	m.sendTransaction(messages.TransactionActionUndo, ops)
+	m.status = fmt.Sprintf("Undid the macro replay, %d change(s)", len(ops))
+}
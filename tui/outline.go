@@ -0,0 +1,115 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"gollaborate/language"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// outlineTitleLength is how much of a heading's title the outline panel shows
+const outlineTitleLength = 40
+
+// outlineView is the panel beside the document listing its headings, or its
+// declarations in code, to jump to
+type outlineView struct {
+	lang     string
+	outliner *language.Outliner
+	headings []language.Heading
+	selected int
+}
+
+// toggleOutline opens or closes the outline panel
+func (m *model) toggleOutline() {
+	if m.outline != nil {
+		m.outline = nil
+		m.status = "Back to the document"
+		return
+	}
+	if !language.HasOutline(m.language()) {
+		m.status = fmt.Sprintf("No outline for %s documents", m.language().Name)
+		return
+	}
+	m.outline = &outlineView{}
+	m.refreshOutline()
+	// Start from the heading the cursor is under
+	for i, heading := range m.outline.headings {
+		if heading.Line <= m.cursorY {
+			m.outline.selected = i
+		}
+	}
+}
+
+// refreshOutline brings the outline up to date with edits made by anyone since
+// the last update. Only lines that changed are read again.
+func (m *model) refreshOutline() {
+	if m.outline == nil {
+		return
+	}
+	lang := m.language()
+	if m.outline.outliner == nil || m.outline.lang != lang.Name {
+		m.outline.lang = lang.Name
+		m.outline.outliner = language.NewOutliner(lang)
+	}
+	lines := make([]string, len(m.doc.Lines))
+	for i := range lines {
+		lines[i] = m.lineText(i + 1)
+	}
+	m.outline.headings = m.outline.outliner.Outline(lines)
+	m.outline.selected = max(0, min(m.outline.selected, len(m.outline.headings)-1))
+}
+
+// updateOutline handles key presses while the outline panel is open
+func (m *model) updateOutline(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c", "ctrl+q":
+		return m, tea.Quit
+	case "ctrl+d", "esc":
+		m.toggleOutline()
+	case "up":
+		m.outline.selected = max(0, m.outline.selected-1)
+	case "down":
+		m.outline.selected = min(len(m.outline.headings)-1, m.outline.selected+1)
+	case "enter":
+		if len(m.outline.headings) == 0 {
+			break
+		}
+		heading := m.outline.headings[m.outline.selected]
+		m.cursorY, m.cursorX = heading.Line, 1
+		m.selectionActive = false
+		m.outline = nil
+		m.status = fmt.Sprintf("Line %d: %s", heading.Line, heading.Title)
+	}
+	return m, nil
+}
+
+// outlinePanelString renders the outline panel
+func (m *model) outlinePanelString() string {
+	boxStyle := lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		Padding(0, 1).
+		MarginLeft(1).
+		BorderForeground(borderColor())
+	highlightStyle := lipgloss.NewStyle().Reverse(true)
+
+	rows := []string{"Outline"}
+	for i, heading := range m.outline.headings {
+		title := heading.Title
+		if r := []rune(title); len(r) > outlineTitleLength {
+			title = string(r[:outlineTitleLength]) + "..."
+		}
+		row := strings.Repeat("  ", heading.Level-1) + title
+		if i == m.outline.selected {
+			row = highlightStyle.Render(row)
+		}
+		rows = append(rows, row)
+	}
+	if len(m.outline.headings) == 0 {
+		rows = append(rows, "No headings yet")
+	}
+	rows = append(rows, "", "Up/Down: Choose", "Enter: Go to", "Esc: Close")
+	return boxStyle.Render(lipgloss.JoinVertical(lipgloss.Left, rows...))
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gollaborate/messages"
+)
+
+// setWriteAccess grants or revokes the write access of a participant, named
+// as they appear to others or by user ID
+func (m *model) setWriteAccess(who string, canWrite bool) {
+	if who == "" {
+		m.status = "Usage: /readonly NAME or /writable NAME"
+		return
+	}
+	userID, name, ok := m.findParticipant(who)
+	if !ok {
+		m.status = fmt.Sprintf("No participant %q", who)
+		return
+	}
+	if err := m.editorState.SetPermission(userID, canWrite); err != nil {
+		m.status = fmt.Sprintf("Failed to change %s's access: %v", name, err)
+		return
+	}
+	if canWrite {
+		m.status = fmt.Sprintf("%s may edit again", name)
+	} else {
+		m.status = fmt.Sprintf("%s is now read-only", name)
+	}
+}
+
+// findParticipant finds a participant present in the session by name or user ID
+func (m *model) findParticipant(who string) (int, string, bool) {
+	id, err := strconv.Atoi(strings.TrimPrefix(who, "User-"))
+	for _, state := range m.editorState.Presence() {
+		if state.UserID == m.userID || state.Offline {
+			continue
+		}
+		if (err == nil && state.UserID == id) || strings.EqualFold(state.UserName, who) {
+			return state.UserID, peerName(state), true
+		}
+	}
+	return 0, "", false
+}
+
+// permissionStatus describes a participant's write access changing
+func (m *model) permissionStatus(msg *messages.Message) string {
+	for userID, role := range msg.Roles {
+		switch {
+		case userID == m.userID && role == messages.RoleReadOnly:
+			return fmt.Sprintf("User-%d revoked your write access", msg.UserID)
+		case userID == m.userID:
+			return fmt.Sprintf("User-%d gave you write access", msg.UserID)
+		case role == messages.RoleReadOnly:
+			return fmt.Sprintf("User-%d is now read-only", userID)
+		default:
+			return fmt.Sprintf("User-%d may edit again", userID)
+		}
+	}
+	return ""
+}
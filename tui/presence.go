@@ -0,0 +1,208 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gollaborate/crdt"
+	"gollaborate/messages"
+	"gollaborate/presence"
+	"gollaborate/users"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// presenceInterval is how often presence is renewed and stale peers are dropped
+const presenceInterval = presence.DefaultTimeout / 6
+
+// presenceTick asks the model to renew its presence
+type presenceTick struct{}
+
+func presenceTickCmd() tea.Cmd {
+	return tea.Tick(presenceInterval, func(time.Time) tea.Msg {
+		return presenceTick{}
+	})
+}
+
+// publishedPresence is the cursor and selection last sent to peers
+type publishedPresence struct {
+	cursor    []crdt.Identifier
+	selStart  []crdt.Identifier
+	selecting bool
+	valid     bool
+}
+
+// sendCursorUpdate publishes the cursor, selection, name and color to peers if
+// the cursor or selection moved since they were last told
+func (m *model) sendCursorUpdate() {
+	if m.forked {
+		// The cursor is in a private fork peers cannot see
+		return
+	}
+	current := publishedPresence{
+		cursor:    m.doc.AnchorAt(m.cursorY, m.cursorX),
+		selecting: m.selectionActive,
+		valid:     true,
+	}
+	if m.selectionActive {
+		current.selStart = m.doc.AnchorAt(m.selStartY, m.selStartX)
+	}
+	last := m.published
+	if last.valid && last.selecting == current.selecting &&
+		slices.Equal(last.cursor, current.cursor) && slices.Equal(last.selStart, current.selStart) {
+		return
+	}
+	m.published = current
+
+	m.editorState.SetPresence(func(s *presence.State) {
+		s.UserName = m.userName
+		s.Color = m.userColor
+		s.Cursor = current.cursor
+		s.SelectionStart = current.selStart
+		s.Selecting = current.selecting
+	})
+}
+
+// cell is a (line, column) position on screen, both 1-based
+type cell struct{ line, column int }
+
+// peer is another participant who is present, with the marker that stands for
+// them when the theme does not tell peers apart by color
+type peer struct {
+	state  presence.State
+	marker string
+}
+
+// peers returns the other participants who are present, by user ID
+func (m *model) peers() []peer {
+	var states []presence.State
+	var names []string
+	for _, state := range m.editorState.Presence() {
+		if state.UserID != m.userID {
+			states = append(states, state)
+			names = append(names, peerName(state))
+		}
+	}
+	markers := assignMarkers(names)
+	peers := make([]peer, len(states))
+	for i, state := range states {
+		peers[i] = peer{state: state, marker: markers[i]}
+	}
+	return peers
+}
+
+// peerCursors returns every other participant by where their cursor is drawn.
+// Cursors past the end of a line are drawn just after its last character.
+func (m *model) peerCursors() map[cell]peer {
+	cursors := make(map[cell]peer)
+	for _, p := range m.peers() {
+		line, column := m.doc.Locate(p.state.Cursor)
+		if line <= len(m.doc.Lines) {
+			column = min(column, visibleLength(m.doc.Lines[line-1])+1)
+		}
+		cursors[cell{line, column}] = p
+	}
+	return cursors
+}
+
+// renderCursor draws a peer's cursor on a character: in their color, or with
+// their marker just before it
+func (p peer) renderCursor(char string) string {
+	if usesMarkers() {
+		return markerStyle().Render(p.marker) + char
+	}
+	return peerCursorStyle(p.state.Color).Render(char)
+}
+
+// visibleLength returns the number of characters on a line, not counting its newline
+func visibleLength(line crdt.Line) int {
+	n := len(line.Characters)
+	if n > 0 && line.Characters[n-1].Value == '\n' {
+		n--
+	}
+	return n
+}
+
+// peerCursorStyle draws a peer's cursor in their color
+func peerCursorStyle(color string) lipgloss.Style {
+	return lipgloss.NewStyle().Background(lipgloss.Color(color)).Foreground(lipgloss.Color("0"))
+}
+
+// onlinePeers lists the other participants who are present, for the notes area.
+// A server's roster says who they are; without one, their presence does.
+func (m *model) onlinePeers() string {
+	if roster := m.editorState.Roster(); roster != nil {
+		return m.rosterPeers(roster)
+	}
+	var names []string
+	for _, p := range m.peers() {
+		name := peerName(p.state)
+		if usesMarkers() {
+			name += " (" + p.marker + ")"
+		}
+		if m.editorState.IsIdle(p.state.UserID) {
+			name += " (idle)"
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return "   Online: " + strings.Join(names, ", ")
+}
+
+// rosterPeers lists the other participants in a server's roster, with their
+// marker if they have a cursor in the document and their role unless editor
+func (m *model) rosterPeers(roster []messages.RosterEntry) string {
+	markers := make(map[int]string)
+	for _, p := range m.peers() {
+		markers[p.state.UserID] = p.marker
+	}
+	var names []string
+	for _, entry := range roster {
+		if entry.UserID == m.userID {
+			continue
+		}
+		name := peerName(presence.State{UserID: entry.UserID, UserName: entry.UserName})
+		if marker, ok := markers[entry.UserID]; ok && usesMarkers() {
+			name += " (" + marker + ")"
+		}
+		if entry.Role != "" && entry.Role != string(users.RoleEditor) {
+			name += " (" + entry.Role + ")"
+		}
+		if entry.Idle {
+			name += " (idle)"
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return "   Online: " + strings.Join(names, ", ")
+}
+
+// presenceEventStatus describes a participant's presence event
+func presenceEventStatus(event messages.PresenceEvent, name string) string {
+	switch event {
+	case messages.PresenceJoin:
+		return name + " joined"
+	case messages.PresenceLeave:
+		return name + " left"
+	case messages.PresenceIdle:
+		return name + " is idle"
+	case messages.PresenceActive:
+		return name + " is back"
+	}
+	return name + " " + string(event)
+}
+
+// peerName returns the best available name for a participant
+func peerName(state presence.State) string {
+	if state.UserName != "" {
+		return state.UserName
+	}
+	return fmt.Sprintf("User-%d", state.UserID)
+}
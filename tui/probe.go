@@ -0,0 +1,49 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gollaborate/shared"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// probeTimeout is how long to wait for peers to answer a latency probe
+const probeTimeout = 5 * time.Second
+
+// probeResults carries the outcome of a latency probe back to the update loop
+type probeResults []shared.ProbeResult
+
+// measureLatency probes every connected peer in the background
+func (m *model) measureLatency() tea.Cmd {
+	editorState := m.editorState
+	return func() tea.Msg {
+		return probeResults(editorState.Probe(probeTimeout))
+	}
+}
+
+// formatLatency summarizes probe results for the status line
+func formatLatency(results []shared.ProbeResult) string {
+	if len(results) == 0 {
+		return "Latency: no peers connected"
+	}
+	parts := make([]string, len(results))
+	for i, r := range results {
+		if r.Err != nil {
+			parts[i] = fmt.Sprintf("%s no answer", remoteAddr(r))
+			continue
+		}
+		parts[i] = fmt.Sprintf("User-%d %s", r.UserID, r.RTT.Round(100*time.Microsecond))
+	}
+	return "Latency: " + strings.Join(parts, ", ")
+}
+
+// remoteAddr returns a printable address for a peer that did not answer
+func remoteAddr(r shared.ProbeResult) string {
+	if r.Conn == nil {
+		return "peer"
+	}
+	return r.Conn.RemoteID()
+}
@@ -0,0 +1,20 @@
+package core
+
+import (
+	"gollaborate/replay"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// recordingModel captures the document after every update, so local edits and
+// edits from peers both end up in the recording
+type recordingModel struct {
+	*model
+	recorder *replay.Recorder
+}
+
+func (r *recordingModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	_, cmd := r.model.Update(msg)
+	r.recorder.Capture(r.doc.ToText())
+	return r, cmd
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"os"
+	"unicode"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Theme chooses how the editor uses color
+type Theme int
+
+const (
+	// ThemeColor draws peers' cursors in their colors
+	ThemeColor Theme = iota
+	// ThemeHighContrast uses bright borders and bold reverse markers
+	ThemeHighContrast
+	// ThemeNoColor uses no color at all, only bold, underline and reverse
+	ThemeNoColor
+)
+
+// themeNames maps the names accepted on the command line to themes
+var themeNames = map[string]Theme{
+	"color":         ThemeColor,
+	"high-contrast": ThemeHighContrast,
+	"no-color":      ThemeNoColor,
+}
+
+// theme is the theme every view is drawn with, see SetTheme
+var theme = ThemeColor
+
+// ThemeByName returns the theme with the given name
+func ThemeByName(name string) (Theme, bool) {
+	t, ok := themeNames[name]
+	return t, ok
+}
+
+// DefaultTheme returns ThemeNoColor when the NO_COLOR environment variable is
+// set to anything but the empty string (see no-color.org), and ThemeColor otherwise
+func DefaultTheme() Theme {
+	if os.Getenv("NO_COLOR") != "" {
+		return ThemeNoColor
+	}
+	return ThemeColor
+}
+
+// SetTheme sets the theme for views. Call it before starting a TUI.
+func SetTheme(t Theme) {
+	theme = t
+}
+
+// usesMarkers reports whether peers are told apart by markers rather than colors
+func usesMarkers() bool {
+	return theme != ThemeColor
+}
+
+// borderColor is the color of the boxes around each part of a view
+func borderColor() lipgloss.TerminalColor {
+	switch theme {
+	case ThemeHighContrast:
+		return lipgloss.Color("15")
+	case ThemeNoColor:
+		return lipgloss.NoColor{}
+	}
+	return lipgloss.Color("8")
+}
+
+// errorStyle draws errors, in red when colors are available
+func errorStyle() lipgloss.Style {
+	switch theme {
+	case ThemeHighContrast:
+		return lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("15"))
+	case ThemeNoColor:
+		return lipgloss.NewStyle().Bold(true)
+	}
+	return lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+}
+
+// markerStyle draws the markers shown at peers' cursors
+func markerStyle() lipgloss.Style {
+	if theme == ThemeHighContrast {
+		return lipgloss.NewStyle().Bold(true).Reverse(true)
+	}
+	return lipgloss.NewStyle().Bold(true).Underline(true)
+}
+
+// markerSymbols stand in for peers whose initial is taken or who have none
+var markerSymbols = []rune{'*', '+', '#', '@', '%', '&'}
+
+// assignMarkers gives each participant a one-character marker: the initial of
+// their name where it is free, in the order given, then a symbol
+func assignMarkers(names []string) []string {
+	markers := make([]string, len(names))
+	taken := make(map[rune]bool)
+	for i, name := range names {
+		for _, r := range name {
+			r = unicode.ToUpper(r)
+			if unicode.IsLetter(r) && !taken[r] {
+				markers[i] = string(r)
+				taken[r] = true
+			}
+			break
+		}
+	}
+	for i := range markers {
+		if markers[i] != "" {
+			continue
+		}
+		markers[i] = "?"
+		for _, r := range markerSymbols {
+			if !taken[r] {
+				markers[i] = string(r)
+				taken[r] = true
+				break
+			}
+		}
+	}
+	return markers
+}
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gollaborate/messages"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// titleBar renders the line above the document: its title, language, whether
+// it is locked or frozen, whether we only watch it and when it was last saved, as every participant sees them
+func (m *model) titleBar() string {
+	meta := m.editorState.DocMeta()
+	title := meta.Title
+	if title == "" {
+		title = "untitled"
+	}
+	details := []string{m.language().Name}
+	if meta.ReadOnly {
+		details = append(details, "read-only")
+	}
+	if m.editorState.Frozen() {
+		details = append(details, "frozen")
+	}
+	if m.editorState.Spectating() {
+		details = append(details, "spectating")
+	}
+	if meta.SavedAt != 0 {
+		details = append(details, "saved "+time.UnixMilli(meta.SavedAt).Format("15:04"))
+	}
+	return lipgloss.NewStyle().Bold(true).Render(title) + "   " + strings.Join(details, " · ")
+}
+
+// docMetaStatus describes a change to the document's description by a peer
+func docMetaStatus(msg *messages.Message) string {
+	if msg.DocMeta.ReadOnly {
+		return "The document is now read-only"
+	}
+	return fmt.Sprintf("Document details updated by User-%d", msg.UserID)
+}
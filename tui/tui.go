@@ -2,10 +2,15 @@ package core
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"gollaborate/crdt"
+	"gollaborate/language"
 	"gollaborate/messages"
+	"gollaborate/replay"
 	"gollaborate/shared"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -29,238 +34,515 @@ type model struct {
 	selectionActive bool
 	selStartX       int
 	selStartY       int
+
+	// Text removed by cuts, most recent last
+	killRing []string
+
+	// Transient notice shown above the document
+	banner    string
+	bannerSeq int
+	// Whether the document was frozen when its settings last changed
+	frozen bool
+
+	// Open while viewing the document's history, see history.go
+	history *historyView
+	// Whether the log view is open, see logpane.go
+	showLog bool
+	// Open while choosing a heading to jump to, see outline.go
+	outline *outlineView
+	// Open while choosing a shared snippet to paste, see clips.go
+	clips *clipView
+	// Open while chatting with the other participants, see chat.go
+	chat *chatView
+	// Where to write the document a server exports for /admin export, see chat.go
+	exportPath string
+	// Where to write the document a server rebuilds for /history, see chat.go
+	recoverPath string
+	// A peer's proposal waiting for the user's vote, see vote.go
+	proposal   *messages.Message
+	proposalAt time.Time
+	// Keyboard macro being recorded or ready to replay, see macro.go
+	macro macroState
+	// The user's private fork of the document, and whether it is being edited
+	// rather than the live document; open while reviewing work handed in to the
+	// presenter; see classroom.go
+	fork        *forkState
+	forked      bool
+	submissions *submissionView
+
+	// Where the cursor was at the end of the last update, see anchor.go
+	anchors cursorAnchors
+
+	// Word completion in progress, see complete.go
+	completion *completion
+
+	// Changes made by peers since the last update, see changes.go
+	changes *changeQueue
+	// Characters peers just inserted, see indicators.go
+	highlights editHighlights
+
+	// What peers were last told about the cursor, see presence.go
+	published publishedPresence
 }
 
 func initialModel(editorState *shared.EditorState, userID int, userColor string) *model {
 	// Use the document from the editor state
 	doc := editorState.Document()
-	return &model{
-		doc:         doc,
-		cursorX:     1,
-		cursorY:     1,
-		status:      "Ready",
-		editorState: editorState,
-		userID:      userID,
-		userColor:   userColor,
-		userName:    fmt.Sprintf("User-%d", userID),
-		clock:       1,
-		mutex:       sync.Mutex{},
+	m := &model{
+		doc:             doc,
+		cursorX:         1,
+		cursorY:         1,
+		status:          "Ready",
+		editorState:     editorState,
+		userID:          userID,
+		userColor:       userColor,
+		userName:        fmt.Sprintf("User-%d", userID),
+		clock:           1,
+		mutex:           sync.Mutex{},
 		selectionActive: false,
 		selStartX:       0,
 		selStartY:       0,
+		changes:         &changeQueue{},
 	}
+	if local := editorState.LocalPresence(); local.UserName != "" {
+		m.userName = local.UserName
+	}
+	m.watchDocument()
+	return m
 }
 
 func (m *model) Init() tea.Cmd {
 	// Start message receiver in the background
 	go m.listenForMessages()
-	return nil
+
+	// Let peers know we are here
+	m.mutex.Lock()
+	m.sendCursorUpdate()
+	m.mutex.Unlock()
+	return presenceTickCmd()
 }
 
-func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+func (m *model) Update(msg tea.Msg) (result tea.Model, cmd tea.Cmd) {
+	defer func() {
+		if m.recoverUpdate(recover()) {
+			result, cmd = m, nil
+		}
+	}()
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
+	defer m.anchorCursor()
+	defer func() {
+		// Keep highlights of peers' edits fading for as long as there are any
+		if tick := m.editHighlightCmd(); tick != nil {
+			cmd = tea.Batch(cmd, tick)
+		}
+	}()
+
+	// Follow edits peers made since the last update; anything changed during this
+	// update is the user's own editing, which moves the cursor itself
+	m.followChanges()
+	defer m.changes.take()
+	defer m.refreshOutline()
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
-		switch msg.String() {
-		case "ctrl+c", "ctrl+q":
-			return m, tea.Quit
-		case "ctrl+s":
-			m.status = "Saved"
-		case "backspace", "delete":
-			if m.selectionActive {
-				m.deleteSelection()
-				m.selectionActive = false
-				m.sendCursorUpdate()
-			} else {
-				// Delete character before cursor
-				if m.cursorX > 1 {
-					pos, err := m.doc.FindPositionAt(m.cursorY, m.cursorX-1)
-					if err == nil {
-						_ = m.doc.DeleteCharacter(pos)
-						// Send delete operation to peers
-						m.sendDeleteOperation(pos)
-						m.cursorX--
-						m.sendCursorUpdate()
-					}
-				} else if m.cursorY > 1 {
-					// Handle backspace at start of line (merge lines)
-					prevLineLen := len(m.doc.Lines[m.cursorY-2].Characters)
-					pos, err := m.doc.FindPositionAt(m.cursorY-1, prevLineLen+1)
-					if err == nil {
-						_ = m.doc.DeleteCharacter(pos)
-						// Send delete operation to peers
-						m.sendDeleteOperation(pos)
-						m.cursorY--
-						m.cursorX = prevLineLen + 1
-						m.sendCursorUpdate()
-					}
-				}
-			}
-		case "shift+left":
-			// Start or extend selection to the left
-			if !m.selectionActive {
-				m.selectionActive = true
-				m.selStartX = m.cursorX
-				m.selStartY = m.cursorY
-			}
+		// Tell peers where the cursor ended up, and that the user is not idle
+		defer m.sendCursorUpdate()
+		m.editorState.Active()
+
+		if m.history != nil {
+			return m.updateHistory(msg)
+		}
+		if m.showLog {
+			return m.updateLog(msg)
+		}
+		if m.outline != nil {
+			return m.updateOutline(msg)
+		}
+		if m.clips != nil {
+			return m.updateClips(msg)
+		}
+		if m.chat != nil {
+			return m.updateChat(msg)
+		}
+		if m.submissions != nil {
+			return m.updateSubmissions(msg)
+		}
+		if m.macro.count != nil {
+			return m.updateMacroCount(msg)
+		}
+		m.recordKey(msg)
+		return m.editKey(msg)
+	case networkMessageUpdate:
+		// Handle incoming network messages
+		bannerSeq := m.bannerSeq
+		m.handleMessage(msg.message)
+		// Bubbletea doesn't support Message type as a message, so using our custom handler instead
+		if m.bannerSeq != bannerSeq {
+			return m, clearBannerAfter(m.bannerSeq)
+		}
+	case bannerExpired:
+		// Only clear the banner if no newer one replaced it
+		if msg.seq == m.bannerSeq {
+			m.banner = ""
+		}
+	case editHighlightTick:
+		m.expireEditHighlights()
+	case probeResults:
+		m.status = formatLatency(msg)
+	case restoreResult:
+		m.restored(msg)
+	case presenceTick:
+		m.editorState.RenewPresence()
+		return m, presenceTickCmd()
+	}
+	return m, nil
+}
+
+// editKey handles a key press in the editor, moving the cursor or editing the document
+func (m *model) editKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	// Read-only participants never edit locally, so nothing is sent to peers
+	if isEditKey(msg) && !m.canEdit() {
+		m.status = "Read-only: you cannot edit this document (Ctrl+F to fork a private copy)"
+		return m, nil
+	}
+	if key := msg.String(); key != "ctrl+@" && key != "tab" {
+		m.completion = nil
+	}
+	if msg.Paste {
+		// Terminal (bracketed) paste arrives as one message with all the text
+		m.pasteText(string(msg.Runes))
+		return m, nil
+	}
+	switch msg.String() {
+	case "ctrl+c", "ctrl+q":
+		return m, tea.Quit
+	case "ctrl+s":
+		m.editorState.MarkSaved(time.Now())
+		m.status = "Saved"
+	case "ctrl+t":
+		m.toggleHistory()
+	case "ctrl+l":
+		m.toggleLog()
+	case "ctrl+d":
+		m.toggleOutline()
+	case "ctrl+o":
+		m.vote(true)
+	case "ctrl+n":
+		m.vote(false)
+	case "ctrl+y":
+		m.shareSelection()
+	case "ctrl+b":
+		m.toggleClips()
+	case "ctrl+e":
+		m.toggleChat()
+	case "ctrl+r":
+		m.toggleRecording()
+	case "ctrl+g":
+		m.askMacroCount()
+	case "ctrl+u":
+		m.undoMacro()
+	case "ctrl+w":
+		m.toggleEditHighlights()
+	case "ctrl+f":
+		m.toggleFork()
+	case "ctrl+k":
+		m.submitOrReview()
+	case "ctrl+p":
+		m.status = "Measuring latency..."
+		return m, m.measureLatency()
+	case "backspace", "delete":
+		if m.selectionActive {
+			m.deleteSelection()
+			m.selectionActive = false
+		} else {
+			// Delete character before cursor
 			if m.cursorX > 1 {
-				m.cursorX--
-			}
-		case "shift+right":
-			// Start or extend selection to the right
-			if !m.selectionActive {
-				m.selectionActive = true
-				m.selStartX = m.cursorX
-				m.selStartY = m.cursorY
-			}
-			lineLen := 0
-			if m.cursorY-1 < len(m.doc.Lines) {
-				lineLen = len(m.doc.Lines[m.cursorY-1].Characters)
-			}
-			if m.cursorX <= lineLen {
-				m.cursorX++
-			}
-		case "shift+up":
-			if !m.selectionActive {
-				m.selectionActive = true
-				m.selStartX = m.cursorX
-				m.selStartY = m.cursorY
-			}
-			if m.cursorY > 1 {
-				m.cursorY--
-				lineLen := len(m.doc.Lines[m.cursorY-1].Characters)
-				if m.cursorX > lineLen+1 {
-					m.cursorX = lineLen + 1
+				pos, err := m.doc.FindPositionAt(m.cursorY, m.cursorX-1)
+				if err != nil {
+					break
 				}
-			}
-		case "shift+down":
-			if !m.selectionActive {
-				m.selectionActive = true
-				m.selStartX = m.cursorX
-				m.selStartY = m.cursorY
-			}
-			if m.cursorY < len(m.doc.Lines) {
-				m.cursorY++
-				lineLen := len(m.doc.Lines[m.cursorY-1].Characters)
-				if m.cursorX > lineLen+1 {
-					m.cursorX = lineLen + 1
+				if op := m.deleteAt(pos); op != nil {
+					// Send delete operation to peers
+					m.sendOperation(op)
+					m.cursorX--
+				}
+			} else if m.cursorY > 1 {
+				// Handle backspace at start of line (merge lines)
+				prevLineLen := len(m.doc.Lines[m.cursorY-2].Characters)
+				pos, err := m.doc.FindPositionAt(m.cursorY-1, prevLineLen+1)
+				if err != nil {
+					break
+				}
+				if op := m.deleteAt(pos); op != nil {
+					// Send delete operation to peers
+					m.sendOperation(op)
+					m.cursorY--
+					m.cursorX = prevLineLen + 1
 				}
 			}
-		case "esc":
-			// Clear selection
-			m.selectionActive = false
-		case "left":
-			// Handle cursor movement
-			if m.cursorX > 1 {
-				m.cursorX--
-			}
-			m.selectionActive = false
-		case "right":
-			lineLen := 0
-			if m.cursorY-1 < len(m.doc.Lines) {
-				lineLen = len(m.doc.Lines[m.cursorY-1].Characters)
+		}
+	case "shift+left":
+		// Start or extend selection to the left
+		if !m.selectionActive {
+			m.selectionActive = true
+			m.selStartX = m.cursorX
+			m.selStartY = m.cursorY
+		}
+		if m.cursorX > 1 {
+			m.cursorX--
+		}
+	case "shift+right":
+		// Start or extend selection to the right
+		if !m.selectionActive {
+			m.selectionActive = true
+			m.selStartX = m.cursorX
+			m.selStartY = m.cursorY
+		}
+		lineLen := 0
+		if m.cursorY-1 < len(m.doc.Lines) {
+			lineLen = len(m.doc.Lines[m.cursorY-1].Characters)
+		}
+		if m.cursorX <= lineLen {
+			m.cursorX++
+		}
+	case "shift+up":
+		if !m.selectionActive {
+			m.selectionActive = true
+			m.selStartX = m.cursorX
+			m.selStartY = m.cursorY
+		}
+		if m.cursorY > 1 {
+			m.cursorY--
+			lineLen := len(m.doc.Lines[m.cursorY-1].Characters)
+			if m.cursorX > lineLen+1 {
+				m.cursorX = lineLen + 1
 			}
-			if m.cursorX <= lineLen {
-				m.cursorX++
+		}
+	case "shift+down":
+		if !m.selectionActive {
+			m.selectionActive = true
+			m.selStartX = m.cursorX
+			m.selStartY = m.cursorY
+		}
+		if m.cursorY < len(m.doc.Lines) {
+			m.cursorY++
+			lineLen := len(m.doc.Lines[m.cursorY-1].Characters)
+			if m.cursorX > lineLen+1 {
+				m.cursorX = lineLen + 1
 			}
-			m.selectionActive = false
-		case "up":
-			if m.cursorY > 1 {
-				m.cursorY--
-				lineLen := len(m.doc.Lines[m.cursorY-1].Characters)
-				if m.cursorX > lineLen+1 {
-					m.cursorX = lineLen + 1
-				}
+		}
+	case "esc":
+		// Clear selection
+		m.selectionActive = false
+	case "left":
+		// Handle cursor movement
+		if m.cursorX > 1 {
+			m.cursorX--
+		}
+		m.selectionActive = false
+	case "right":
+		lineLen := 0
+		if m.cursorY-1 < len(m.doc.Lines) {
+			lineLen = len(m.doc.Lines[m.cursorY-1].Characters)
+		}
+		if m.cursorX <= lineLen {
+			m.cursorX++
+		}
+		m.selectionActive = false
+	case "up":
+		if m.cursorY > 1 {
+			m.cursorY--
+			lineLen := len(m.doc.Lines[m.cursorY-1].Characters)
+			if m.cursorX > lineLen+1 {
+				m.cursorX = lineLen + 1
 			}
-			m.selectionActive = false
-		case "down":
-			if m.cursorY < len(m.doc.Lines) {
-				m.cursorY++
-				lineLen := len(m.doc.Lines[m.cursorY-1].Characters)
-				if m.cursorX > lineLen+1 {
-					m.cursorX = lineLen + 1
-				}
+		}
+		m.selectionActive = false
+	case "down":
+		if m.cursorY < len(m.doc.Lines) {
+			m.cursorY++
+			lineLen := len(m.doc.Lines[m.cursorY-1].Characters)
+			if m.cursorX > lineLen+1 {
+				m.cursorX = lineLen + 1
 			}
-			m.selectionActive = false
+		}
+		m.selectionActive = false
 
-		// (handled above, moved for selection support)
-		case "enter":
-			pos, err := m.doc.GeneratePositionAt(m.cursorY, m.cursorX, m.userID)
-			if err == nil {
-				m.clock++
-				_ = m.doc.InsertCharacter('\n', pos, m.clock)
-				// Send insert operation to peers
-				m.sendInsertOperation(pos, '\n')
-				m.cursorY++
-				m.cursorX = 1
-				m.sendCursorUpdate()
-			}
-		default:
-			// Insert printable characters
-			r := []rune(msg.String())
-			if len(r) == 1 && r[0] >= 32 && r[0] != 127 {
-				if m.selectionActive {
-					// Replace selection with character
-					m.deleteSelection()
-					pos, err := m.doc.GeneratePositionAt(m.cursorY, m.cursorX, m.userID)
-					if err == nil {
-						m.clock++
-						_ = m.doc.InsertCharacter(r[0], pos, m.clock)
-						m.sendInsertOperation(pos, r[0])
-						m.cursorX++
-						m.sendCursorUpdate()
-					}
-					m.selectionActive = false
-				} else {
-					pos, err := m.doc.GeneratePositionAt(m.cursorY, m.cursorX, m.userID)
-					if err == nil {
-						m.clock++
-						_ = m.doc.InsertCharacter(r[0], pos, m.clock)
-						// Send insert operation to peers
-						m.sendInsertOperation(pos, r[0])
-						m.cursorX++
-						m.sendCursorUpdate()
-					}
-				}
+	case "ctrl+x":
+		m.cutSelection()
+	case "ctrl+v":
+		m.pasteKillRing()
+	case "ctrl+_", "ctrl+/":
+		// Terminals report Ctrl+/ as Ctrl+_
+		m.toggleComment()
+	case "ctrl+@":
+		// Ctrl+Space
+		m.complete()
+	case "tab":
+		if m.completing() {
+			m.complete()
+			break
+		}
+		// Indent using the document language's convention
+		for _, r := range m.language().Indent {
+			m.insertRune(r)
+		}
+
+	// (handled above, moved for selection support)
+	case "enter":
+		m.insertRune('\n')
+	default:
+		// Insert printable characters
+		r := []rune(msg.String())
+		if len(r) == 1 && r[0] >= 32 && r[0] != 127 {
+			if m.selectionActive {
+				// Replace selection with character
+				m.deleteSelection()
+				m.insertRune(r[0])
+				m.selectionActive = false
+			} else {
+				m.insertRune(r[0])
 			}
 		}
-	case networkMessageUpdate:
-		// Handle incoming network messages
-		m.handleMessage(msg.message)
-		// Bubbletea doesn't support Message type as a message, so using our custom handler instead
 	}
 	return m, nil
 }
 
-func (m *model) sendCursorUpdate() {
-	// Convert cursor position to CRDT position
-	pos, err := m.doc.FindPositionAt(m.cursorY, m.cursorX)
+// isEditKey reports whether a key press would change the document
+func isEditKey(msg tea.KeyMsg) bool {
+	if msg.Paste {
+		return true
+	}
+	switch msg.String() {
+	case "backspace", "delete", "enter", "tab", "ctrl+x", "ctrl+v", "ctrl+_", "ctrl+/", "ctrl+@":
+		return true
+	}
+	r := []rune(msg.String())
+	return len(r) == 1 && r[0] >= 32 && r[0] != 127
+}
+
+// bannerDuration is how long transient banners stay on screen
+const bannerDuration = 4 * time.Second
+
+// bannerExpired tells the model a banner's time is up
+type bannerExpired struct {
+	seq int
+}
+
+// showBanner displays a transient notice above the document
+func (m *model) showBanner(text string) {
+	m.banner = text
+	m.bannerSeq++
+}
+
+// clearBannerAfter schedules removal of the banner with the given sequence number
+func clearBannerAfter(seq int) tea.Cmd {
+	return tea.Tick(bannerDuration, func(time.Time) tea.Msg {
+		return bannerExpired{seq: seq}
+	})
+}
+
+// language returns the editing conventions for the document's language
+func (m *model) language() language.Language {
+	return language.Lookup(m.doc.Metadata.Language)
+}
+
+// insertRune inserts a single character at the cursor and advances past it
+func (m *model) insertRune(r rune) {
+	pos, err := m.doc.GeneratePositionAt(m.cursorY, m.cursorX, m.userID)
 	if err != nil {
 		return
 	}
+	m.clock++
+	op := messages.NewInsertOperation(pos, r, m.userID, m.clock)
+	if !m.validate(op) {
+		return
+	}
+	_ = m.doc.InsertCharacter(op.Character, op.Position, op.Clock)
+	m.sendOperation(op)
+	if op.Character == '\n' {
+		m.cursorY++
+		m.cursorX = 1
+	} else {
+		m.cursorX++
+	}
+}
+
+// insertAt inserts a character locally and returns the operation for peers without sending it
+func (m *model) insertAt(line, column int, r rune) *messages.Operation {
+	pos, err := m.doc.GeneratePositionAt(line, column, m.userID)
+	if err != nil {
+		return nil
+	}
+	m.clock++
+	op := messages.NewInsertOperation(pos, r, m.userID, m.clock)
+	if !m.validate(op) {
+		return nil
+	}
+	_ = m.doc.InsertCharacter(op.Character, op.Position, op.Clock)
+	return op
+}
+
+// deleteAt deletes a character locally and returns the operation for peers without sending it
+func (m *model) deleteAt(pos []crdt.Identifier) *messages.Operation {
+	op := messages.NewDeleteOperation(pos, m.userID, m.clock)
+	if !m.validate(op) {
+		return nil
+	}
+	if err := m.doc.DeleteCharacter(op.Position); err != nil {
+		return nil
+	}
+	return op
+}
 
-	connections := m.editorState.Connections()
-	for _, conn := range connections {
-		_ = messages.SendCursor(conn, pos, m.userID, m.userName, m.userColor)
+// flagProtected warns in the status line when a peer's operations changed a protected region
+func (m *model) flagProtected(ops []*messages.Operation) {
+	for _, op := range ops {
+		if r, ok := m.doc.ProtectedAt(op.Position); ok {
+			m.status = fmt.Sprintf("Warning: User-%d edited protected region %q", op.UserID, r.Name)
+			return
+		}
+	}
+}
+
+// validate runs the editor state's validators over a local operation, showing
+// why it was rejected in the status line
+func (m *model) validate(op *messages.Operation) bool {
+	if m.forked {
+		// A private fork is the user's to change
+		return true
+	}
+	if err := m.editorState.Validate(op); err != nil {
+		m.status = err.Error()
+		return false
 	}
+	return true
 }
 
-func (m *model) sendInsertOperation(pos []crdt.Identifier, char rune) {
-	operation := messages.NewInsertOperation(pos, char, m.userID, m.clock)
-	connections := m.editorState.Connections()
-	for _, conn := range connections {
-		_ = messages.SendOperation(conn, operation)
+// sendOperation sends a single operation to peers
+func (m *model) sendOperation(op *messages.Operation) {
+	if m.forked {
+		return
 	}
+	if m.macro.ops != nil {
+		// Replaying a macro, which sends everything at the end
+		m.macro.ops = append(m.macro.ops, op)
+		return
+	}
+	m.editorState.BroadcastMessage(messages.NewOperationMessage(op))
 }
 
-func (m *model) sendDeleteOperation(pos []crdt.Identifier) {
-	operation := messages.NewDeleteOperation(pos, m.userID, m.clock)
-	connections := m.editorState.Connections()
-	for _, conn := range connections {
-		_ = messages.SendOperation(conn, operation)
+// sendTransaction sends operations to peers as a single transaction
+func (m *model) sendTransaction(action messages.TransactionAction, ops []*messages.Operation) {
+	if len(ops) == 0 || m.forked {
+		return
 	}
+	if m.macro.ops != nil {
+		m.macro.ops = append(m.macro.ops, ops...)
+		return
+	}
+	m.editorState.BroadcastMessage(messages.NewTransactionMessage(ops, action, m.userID, m.userName))
 }
 
 // networkMessageUpdate is a custom message type for tea.Msg
@@ -281,16 +563,12 @@ func (m *model) listenForMessages() {
 
 func (m *model) handleMessage(msg *messages.Message) {
 	switch msg.Type {
-	case messages.MessageTypeCursor:
-		if msg.Cursor.UserID != m.userID {
-			// Convert CRDT position to text coordinates
-			// This would need to be implemented
-			m.status = fmt.Sprintf("Cursor moved by %s", msg.Cursor.UserName)
-		}
-	case messages.MessageTypeSelection:
-		if msg.Selection.UserID != m.userID {
-			m.status = fmt.Sprintf("Selection updated by %s", msg.Selection.UserName)
-			// Handle selection logic here
+	case messages.MessageTypeAwareness:
+		// Peers' cursors are drawn from the editor state's presence; only departures are announced
+		for _, state := range msg.Presence {
+			if state.UserID != m.userID && state.Offline {
+				m.status = fmt.Sprintf("%s left", peerName(state))
+			}
 		}
 	case messages.MessageTypeOperation:
 		if msg.Operation.UserID != m.userID {
@@ -303,30 +581,168 @@ func (m *model) handleMessage(msg *messages.Message) {
 			case messages.OperationTypeDelete:
 				m.status = fmt.Sprintf("Character deleted by User-%d", op.UserID)
 			}
+			m.flagProtected([]*messages.Operation{op})
+		}
+	case messages.MessageTypeTransaction:
+		if msg.UserID != m.userID {
+			m.status = fmt.Sprintf("%d changes applied by User-%d", len(msg.Operations), msg.UserID)
+			if msg.Action == messages.TransactionActionReload {
+				m.status = fmt.Sprintf("The document changed on disk and was reloaded (%d changes)", len(msg.Operations))
+			}
+			if msg.Action == messages.TransactionActionPaste {
+				m.announcePaste(msg)
+			}
+			m.flagProtected(msg.Operations)
+		}
+	case messages.MessageTypeBatch:
+		if msg.UserID != m.userID {
+			m.status = fmt.Sprintf("%d changes applied by User-%d", len(msg.Operations), msg.UserID)
+			m.flagProtected(msg.Operations)
+		}
+	case messages.MessageTypeRoles:
+		if msg.UserID != m.userID {
+			if msg.Roles[m.userID] == messages.RoleReadOnly {
+				m.status = "You have read-only access to this document"
+			} else {
+				m.status = "Participant roles updated"
+			}
+		}
+	case messages.MessageTypeMetadata:
+		frozen := msg.Metadata != nil && msg.Metadata.Frozen
+		switch {
+		case frozen && !m.frozen:
+			m.status = fmt.Sprintf("User-%d froze the document: it takes no more edits", msg.UserID)
+		case !frozen && m.frozen:
+			m.status = fmt.Sprintf("User-%d unfroze the document", msg.UserID)
+		case msg.UserID != m.userID:
+			m.status = fmt.Sprintf("Document settings updated by User-%d", msg.UserID)
+		}
+		m.frozen = frozen
+	case messages.MessageTypePresence:
+		if len(msg.Presence) > 0 && msg.Presence[0].UserID != m.userID {
+			m.status = presenceEventStatus(msg.Event, peerName(msg.Presence[0]))
+		}
+	case messages.MessageTypeProposal:
+		if msg.UserID != m.userID {
+			m.askVote(msg)
+		}
+	case messages.MessageTypeUserInfo:
+		if msg.UserID != m.userID {
+			m.status = fmt.Sprintf("User-%d is now %s", msg.UserID, msg.UserName)
+		}
+	case messages.MessageTypeAttachment, messages.MessageTypeAttachmentChunk:
+		m.attachmentStatus(msg)
+	case messages.MessageTypePermission:
+		if msg.UserID != m.userID {
+			m.status = m.permissionStatus(msg)
+		}
+	case messages.MessageTypeDocMeta:
+		if msg.UserID != m.userID {
+			m.status = docMetaStatus(msg)
+		}
+	case messages.MessageTypeListRooms:
+		if len(msg.Rooms) > 0 {
+			m.status = roomsStatus(msg.Rooms)
+		}
+	case messages.MessageTypeAdmin:
+		m.adminDone(msg)
+	case messages.MessageTypeDocumentAt:
+		if msg.Document != nil {
+			m.historyDone(msg)
+		}
+	case messages.MessageTypeChat:
+		if msg.UserID != m.userID {
+			name := msg.UserName
+			if name == "" {
+				name = fmt.Sprintf("User-%d", msg.UserID)
+			}
+			m.announceChat(name, msg.Text)
 		}
+	case messages.MessageTypeClip:
+		if msg.UserID != m.userID {
+			name := msg.UserName
+			if name == "" {
+				name = fmt.Sprintf("User-%d", msg.UserID)
+			}
+			m.announceClip(name, msg.Text)
+		}
+	case messages.MessageTypeSubmission:
+		if msg.UserID != m.userID && m.editorState.Presenting() {
+			name := msg.UserName
+			if name == "" {
+				name = fmt.Sprintf("User-%d", msg.UserID)
+			}
+			m.announceSubmission(name)
+		}
+	case messages.MessageTypeError:
+		// Edits refused for exceeding a quota stay here but are not shared
+		switch msg.Code {
+		case messages.ErrorCodeThrottled:
+			m.status = fmt.Sprintf("Slow down, edits not shared: %s", msg.Error)
+		case messages.ErrorCodeTooLarge:
+			m.status = fmt.Sprintf("Edit too large, not shared: %s", msg.Error)
+		case messages.ErrorCodeForbidden:
+			m.status = fmt.Sprintf("Not allowed: %s", msg.Error)
+		case messages.ErrorCodeUnauthorized:
+			m.status = fmt.Sprintf("The server refused you: %s", msg.Error)
+		case messages.ErrorCodeSessionExpired:
+			m.status = "Disconnected too long to carry on: join the server again"
+		case messages.ErrorCodeIdle:
+			m.status = "Disconnected after being idle: join the server again"
+		case messages.ErrorCodeFull:
+			m.status = fmt.Sprintf("The server is full: %s", msg.Error)
+		case messages.ErrorCodeFrozen:
+			m.status = "The document is frozen: edits are no longer taken"
+		}
+	case messages.MessageTypeCatchUp:
+		m.status = fmt.Sprintf("Caught up on %d change(s) from User-%d", len(msg.Operations), msg.UserID)
+		m.flagProtected(msg.Operations)
 	case messages.MessageTypeSync:
-		if msg.UserID != m.userID && msg.Document != nil {
-			// Handle document sync
-			m.doc = msg.Document
+		if msg.UserID != m.userID && msg.Document != nil && m.forked {
+			// The live document is picked up when leaving the fork
+			m.status = fmt.Sprintf("Live document synchronized with User-%d", msg.UserID)
+		} else if msg.UserID != m.userID && msg.Document != nil {
+			// The editor state merges the synced document into its own; keep
+			// the cursor on the same text rather than the same coordinates
+			m.doc = m.editorState.Document()
+			m.watchDocument()
+			m.restoreCursor()
 			m.status = fmt.Sprintf("Document synchronized with User-%d", msg.UserID)
 		}
 	}
 }
 
 func (m *model) View() string {
+	if m.history != nil {
+		return m.historyViewString()
+	}
+	if m.showLog {
+		return m.logViewString()
+	}
+	if m.clips != nil {
+		return m.clipsViewString()
+	}
+	if m.chat != nil {
+		return m.chatViewString()
+	}
+	if m.submissions != nil {
+		return m.submissionsViewString()
+	}
+
 	// Lipgloss styles
 	borderStyle := lipgloss.NewStyle().
 		Border(lipgloss.NormalBorder()).
 		Padding(0, 1).
-		BorderForeground(lipgloss.Color("8"))
+		BorderForeground(borderColor())
 	highlightStyle := lipgloss.NewStyle().Reverse(true)
 	notesStyle := lipgloss.NewStyle().
 		Border(lipgloss.NormalBorder()).
 		Padding(0, 1).
 		MarginTop(1).
-		BorderForeground(lipgloss.Color("8"))
+		BorderForeground(borderColor())
 
 	// Build text area
+	peers := m.peerCursors()
 	var textLines []string
 	maxLineLen := 0
 	for y, line := range m.doc.Lines {
@@ -352,8 +768,12 @@ func (m *model) View() string {
 			if m.cursorY == y+1 && m.cursorX == x+1 {
 				lineStr += "_"
 			}
-			if highlight {
+			if p, ok := peers[cell{y + 1, x + 1}]; ok && char.Value != '\n' {
+				lineStr += p.renderCursor(string(char.Value))
+			} else if highlight {
 				lineStr += highlightStyle.Render(string(char.Value))
+			} else if style, ok := m.editHighlightAt(char.Pos); ok && char.Value != '\n' {
+				lineStr += style.Render(string(char.Value))
 			} else {
 				lineStr += string(char.Value)
 			}
@@ -362,6 +782,9 @@ func (m *model) View() string {
 		if m.cursorY == y+1 && m.cursorX == len(line.Characters)+1 {
 			lineStr += "_"
 		}
+		if p, ok := peers[cell{y + 1, visibleLength(line) + 1}]; ok {
+			lineStr += p.renderCursor(" ")
+		}
 		if len(lineStr) > maxLineLen {
 			maxLineLen = len(lineStr)
 		}
@@ -374,18 +797,59 @@ func (m *model) View() string {
 		}
 	}
 	textArea := borderStyle.Render(lipgloss.JoinVertical(lipgloss.Left, textLines...))
+	if m.outline != nil {
+		textArea = lipgloss.JoinHorizontal(lipgloss.Top, textArea, m.outlinePanelString())
+	}
 
 	// Build notes/commands area with fixed width
 	notes := []string{
 		fmt.Sprintf("Status: %s", m.status),
+		fmt.Sprintf("Language: %s   Role: %s%s", m.language().Name, m.editorState.Role(m.userID), m.readOnlyPeers()+m.onlinePeers()),
 		"Commands:",
 		"  Arrows: Move   Shift+Arrows: Select   Esc: Clear Selection",
-		"  Type: Insert   Backspace/Delete: Delete   Enter: Newline",
-		"  Ctrl+S: Save   Ctrl+Q: Quit",
+		"  Type: Insert   Backspace/Delete: Delete   Enter: Newline   Tab: Indent   Ctrl+Space: Complete word",
+		"  Ctrl+X: Cut   Ctrl+V: Paste   Ctrl+Y: Share selection   Ctrl+B: Session clipboard   Ctrl+/: Toggle comment",
+		"  Ctrl+T: History   Ctrl+D: Outline   Ctrl+P: Latency   Ctrl+E: Chat   Ctrl+L: Log   Ctrl+S: Save   Ctrl+Q: Quit",
+		"  Ctrl+R: Record macro   Ctrl+G: Replay macro   Ctrl+U: Undo replay   Ctrl+F: Private fork   Ctrl+K: Submit   Ctrl+W: Edit highlights",
+	}
+	if note := m.classroomNote(); note != "" {
+		notes = append(notes, note)
+	}
+	if m.pendingProposal() != nil {
+		notes = append(notes, "Vote: "+m.proposalPrompt())
 	}
 	notesBlock := notesStyle.Render(lipgloss.JoinVertical(lipgloss.Left, notes...))
 
-	return textArea + "\n" + notesBlock
+	bannerStyle := lipgloss.NewStyle().Bold(true).Reverse(true).Padding(0, 1)
+	if m.banner != "" {
+		textArea = bannerStyle.Render(m.banner) + "\n" + textArea
+	}
+	if m.editorState.Frozen() {
+		// Stays for as long as the document is frozen, unlike transient banners
+		textArea = bannerStyle.Render("Frozen: this document is final and read-only") + "\n" + textArea
+	}
+
+	return m.titleBar() + "\n" + textArea + "\n" + notesBlock
+}
+
+// readOnlyPeers lists the other participants with read-only access, for the notes area
+func (m *model) readOnlyPeers() string {
+	var ids []int
+	for userID, role := range m.editorState.Roles() {
+		if userID != m.userID && role == messages.RoleReadOnly {
+			ids = append(ids, userID)
+		}
+	}
+	if len(ids) == 0 {
+		return ""
+	}
+	sort.Ints(ids)
+
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = fmt.Sprintf("User-%d", id)
+	}
+	return "   Read-only: " + strings.Join(names, ", ")
 }
 
 func repeatRune(s string, count int) string {
@@ -399,8 +863,14 @@ func repeatRune(s string, count int) string {
 	return result
 }
 
-// deleteSelection deletes the currently selected text region
+// deleteSelection deletes the currently selected text region and sends the
+// deletions to peers as one transaction
 func (m *model) deleteSelection() {
+	m.deleteSelectionAs(messages.TransactionActionDelete)
+}
+
+// deleteSelectionAs deletes the selection, labelling the transaction with the given action
+func (m *model) deleteSelectionAs(action messages.TransactionAction) {
 	if !m.selectionActive {
 		return
 	}
@@ -411,6 +881,7 @@ func (m *model) deleteSelection() {
 		sy, sx, ey, ex = ey, ex, sy, sx
 	}
 	// Delete from end to start to avoid messing up positions
+	var ops []*messages.Operation
 	for y := ey; y >= sy; y-- {
 		line := m.doc.Lines[y-1]
 		startX := 1
@@ -426,19 +897,31 @@ func (m *model) deleteSelection() {
 				continue
 			}
 			pos := m.doc.Lines[y-1].Characters[x-1].Pos
-			_ = m.doc.DeleteCharacter(pos)
-			m.sendDeleteOperation(pos)
+			if op := m.deleteAt(pos); op != nil {
+				ops = append(ops, op)
+			}
 		}
 	}
+	m.sendTransaction(action, ops)
 	// Move cursor to start of selection
 	m.cursorX = sx
 	m.cursorY = sy
 }
 
 func StartTUI(editorState *shared.EditorState, userID int, userColor string) error {
+	return StartRecordedTUI(editorState, userID, userColor, nil)
+}
+
+// StartRecordedTUI runs the editor like StartTUI, capturing every change to the document in rec when it is not nil
+func StartRecordedTUI(editorState *shared.EditorState, userID int, userColor string, rec *replay.Recorder) error {
 	// Create model as a pointer to preserve program reference
 	m := initialModel(editorState, userID, userColor)
-	p := tea.NewProgram(m, tea.WithAltScreen())
+	var root tea.Model = m
+	if rec != nil {
+		rec.Capture(m.doc.ToText())
+		root = &recordingModel{model: m, recorder: rec}
+	}
+	p := tea.NewProgram(root, tea.WithAltScreen())
 
 	// Store the program reference for message handling
 	m.program = p
@@ -476,6 +959,43 @@ func (m *MockModel) SetCursorPosition(x, y int) {
 	m.cursorY = y
 }
 
+// SimulatePaste simulates a terminal paste of text for testing
+func (m *MockModel) SimulatePaste(text string) {
+	_, _ = m.model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(text), Paste: true})
+}
+
+// SimulateNetworkMessage delivers a message from a peer as the program would for testing
+func (m *MockModel) SimulateNetworkMessage(msg *messages.Message) {
+	_, _ = m.model.Update(networkMessageUpdate{message: msg})
+}
+
+// GetHistoryText returns the text shown in the history view, or "" when it is closed, for testing
+func (m *MockModel) GetHistoryText() string {
+	if m.history == nil {
+		return ""
+	}
+	return m.history.doc.ToText()
+}
+
+// GetEditHighlights returns the characters highlighted as peers' edits, in
+// document order, for testing
+func (m *MockModel) GetEditHighlights() string {
+	var b strings.Builder
+	for _, line := range m.doc.Lines {
+		for _, char := range line.Characters {
+			if _, ok := m.editHighlightAt(char.Pos); ok {
+				b.WriteRune(char.Value)
+			}
+		}
+	}
+	return b.String()
+}
+
+// GetBanner returns the transient banner text for testing
+func (m *MockModel) GetBanner() string {
+	return m.banner
+}
+
 // SimulateKeyPress simulates pressing a key for testing
 func (m *MockModel) SimulateKeyPress(key string) {
 	// Create a tea.KeyMsg and send it to Update
@@ -492,12 +1012,55 @@ func (m *MockModel) SimulateKeyPress(key string) {
 		msg = tea.KeyMsg{Type: tea.KeyUp}
 	} else if key == "down" {
 		msg = tea.KeyMsg{Type: tea.KeyDown}
+	} else if key == "tab" {
+		msg = tea.KeyMsg{Type: tea.KeyTab}
+	} else if key == "shift+down" {
+		msg = tea.KeyMsg{Type: tea.KeyShiftDown}
+	} else if key == "shift+right" {
+		msg = tea.KeyMsg{Type: tea.KeyShiftRight}
+	} else if key == "ctrl+/" {
+		msg = tea.KeyMsg{Type: tea.KeyCtrlUnderscore}
+	} else if key == "ctrl+x" {
+		msg = tea.KeyMsg{Type: tea.KeyCtrlX}
+	} else if key == "ctrl+v" {
+		msg = tea.KeyMsg{Type: tea.KeyCtrlV}
+	} else if key == "shift+left" {
+		msg = tea.KeyMsg{Type: tea.KeyShiftLeft}
+	} else if key == "ctrl+space" {
+		msg = tea.KeyMsg{Type: tea.KeyCtrlAt}
+	} else if key == "ctrl+t" {
+		msg = tea.KeyMsg{Type: tea.KeyCtrlT}
+	} else if key == "ctrl+l" {
+		msg = tea.KeyMsg{Type: tea.KeyCtrlL}
+	} else if key == "ctrl+d" {
+		msg = tea.KeyMsg{Type: tea.KeyCtrlD}
+	} else if key == "ctrl+y" {
+		msg = tea.KeyMsg{Type: tea.KeyCtrlY}
+	} else if key == "ctrl+b" {
+		msg = tea.KeyMsg{Type: tea.KeyCtrlB}
+	} else if key == "ctrl+o" {
+		msg = tea.KeyMsg{Type: tea.KeyCtrlO}
+	} else if key == "ctrl+n" {
+		msg = tea.KeyMsg{Type: tea.KeyCtrlN}
+	} else if key == "ctrl+e" {
+		msg = tea.KeyMsg{Type: tea.KeyCtrlE}
+	} else if key == "ctrl+r" {
+		msg = tea.KeyMsg{Type: tea.KeyCtrlR}
+	} else if key == "ctrl+g" {
+		msg = tea.KeyMsg{Type: tea.KeyCtrlG}
+	} else if key == "ctrl+u" {
+		msg = tea.KeyMsg{Type: tea.KeyCtrlU}
+	} else if key == "ctrl+w" {
+		msg = tea.KeyMsg{Type: tea.KeyCtrlW}
+	} else if key == "ctrl+f" {
+		msg = tea.KeyMsg{Type: tea.KeyCtrlF}
+	} else if key == "ctrl+k" {
+		msg = tea.KeyMsg{Type: tea.KeyCtrlK}
+	} else if key == "esc" {
+		msg = tea.KeyMsg{Type: tea.KeyEsc}
 	}
 
-	newModel, _ := m.model.Update(msg)
-	// Update should return the same model pointer
-	if newModel != m.model {
-		// In testing, we'll discard the commands and just ensure the model is updated
-		*m.model = *(newModel.(*model))
-	}
+	// Update mutates the model in place and returns the same pointer, so the
+	// returned model and commands can be discarded
+	_, _ = m.model.Update(msg)
 }
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"gollaborate/messages"
+	"gollaborate/shared"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// restoreResult is the outcome of restoring an old version
+type restoreResult struct {
+	changed int
+	err     error
+}
+
+// askVote shows a peer's proposal until the user votes on it
+func (m *model) askVote(msg *messages.Message) {
+	if !m.editorState.CanEdit() {
+		// Read-only participants have no say
+		return
+	}
+	m.proposal = msg
+	m.proposalAt = time.Now()
+	m.status = m.proposalPrompt()
+}
+
+// pendingProposal returns the proposal waiting for the user's vote, or nil once
+// it has been voted on or its proposer stopped waiting
+func (m *model) pendingProposal() *messages.Message {
+	if m.proposal != nil && time.Since(m.proposalAt) >= shared.DefaultApprovalTimeout {
+		m.proposal = nil
+	}
+	return m.proposal
+}
+
+// proposalPrompt describes the pending proposal and how to vote on it
+func (m *model) proposalPrompt() string {
+	name := m.proposal.UserName
+	if name == "" {
+		name = fmt.Sprintf("User-%d", m.proposal.UserID)
+	}
+	return fmt.Sprintf("%s asks to %s   Ctrl+O: Approve   Ctrl+N: Reject", name, m.proposal.Text)
+}
+
+// vote answers the pending proposal
+func (m *model) vote(approve bool) {
+	p := m.pendingProposal()
+	if p == nil {
+		m.status = "Nothing to vote on"
+		return
+	}
+	m.editorState.Vote(p.ProposalID, approve)
+	m.proposal = nil
+	if approve {
+		m.status = "You approved: " + p.Text
+	} else {
+		m.status = "You rejected: " + p.Text
+	}
+}
+
+// restoreHistoryStep restores the document to the point shown in the history
+// view. It may need the other editors' approval, so it runs in the background.
+func (m *model) restoreHistoryStep() tea.Cmd {
+	h := m.doc.History()
+	var t time.Time
+	if m.history.step > 0 {
+		t = h.Entries[m.history.step-1].Time
+	} else if h.Len() > 0 {
+		t = h.Entries[0].Time.Add(-time.Nanosecond)
+	}
+	m.history = nil
+	m.status = "Restoring the version of " + t.Format("15:04:05") + "..."
+
+	editorState := m.editorState
+	return func() tea.Msg {
+		changed, err := editorState.RestoreTo(t)
+		return restoreResult{changed: changed, err: err}
+	}
+}
+
+// restored reports the outcome of a restore
+func (m *model) restored(result restoreResult) {
+	if result.err != nil {
+		m.status = fmt.Sprintf("Restore failed: %v", result.err)
+		return
+	}
+	m.status = fmt.Sprintf("Restored the old version, changing %d character(s)", result.changed)
+}
@@ -10,6 +10,43 @@ type User struct {
 	ID    int    `json:"id"`
 	Name  string `json:"name"`
 	Color string `json:"color"`
+	Role  Role   `json:"role,omitempty"`
+	// The only room a server lets the user join, if a join code admitted
+	// them. Never kept in their profile.
+	Room string `json:"-"`
+}
+
+// Role says what a user may do on a server. Users without one are editors.
+type Role string
+
+const (
+	// RoleViewer may read the document but not edit it
+	RoleViewer Role = "viewer"
+	// RoleEditor may edit the document
+	RoleEditor Role = "editor"
+	// RoleAdmin may edit the document, kick users, lock the document and save
+	// or export it
+	RoleAdmin Role = "admin"
+)
+
+// ParseRole returns the role with the given name
+func ParseRole(name string) (Role, error) {
+	switch role := Role(name); role {
+	case RoleViewer, RoleEditor, RoleAdmin:
+		return role, nil
+	}
+	return "", fmt.Errorf("unknown role %q, expected viewer, editor or admin", name)
+}
+
+// CanEdit reports whether the role may change the document
+func (r Role) CanEdit() bool {
+	return r != RoleViewer
+}
+
+// CanAdminister reports whether the role may kick users, lock the document
+// and save or export it
+func (r Role) CanAdminister() bool {
+	return r == RoleAdmin
 }
 
 // Manager handles user creation and management
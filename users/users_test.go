@@ -284,4 +284,25 @@ func TestColorGeneration(t *testing.T) {
 	if len(colors) < 5 {
 		t.Errorf("Expected at least 5 different colors, got %d", len(colors))
 	}
-}
\ No newline at end of file
+}
+
+func TestRoles(t *testing.T) {
+	for _, name := range []string{"viewer", "editor", "admin"} {
+		if role, err := ParseRole(name); err != nil || string(role) != name {
+			t.Errorf("Expected role %s, got %q (%v)", name, role, err)
+		}
+	}
+	if _, err := ParseRole("owner"); err == nil {
+		t.Error("Expected an unknown role refused")
+	}
+
+	if RoleViewer.CanEdit() || !RoleEditor.CanEdit() || !RoleAdmin.CanEdit() {
+		t.Error("Expected only viewers unable to edit")
+	}
+	if !Role("").CanEdit() || Role("").CanAdminister() {
+		t.Error("Expected users without a role to be editors")
+	}
+	if RoleEditor.CanAdminister() || !RoleAdmin.CanAdminister() {
+		t.Error("Expected only admins to administer")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"gollaborate/webhook"
+)
+
+// webhookFlags are the command line settings for posting document events to
+// webhooks
+type webhookFlags struct {
+	urls   *string
+	events *string
+	diff   *bool
+	format *string
+	secret *string
+	idle   *time.Duration
+}
+
+// addWebhookFlags defines the webhook flags on a flag set
+func addWebhookFlags(flags *flag.FlagSet) webhookFlags {
+	var events []string
+	for _, event := range webhook.Events() {
+		events = append(events, string(event))
+	}
+	return webhookFlags{
+		urls:   flags.String("webhook", "", "Post document events as JSON to these URLs, separated by commas, such as a Slack incoming webhook or a CI trigger"),
+		events: flags.String("webhook-events", strings.Join(events, ","), "Which events to post, separated by commas"),
+		diff:   flags.Bool("webhook-diff", false, "Post what changed since the last payload about a document rather than its whole text"),
+		format: flags.String("webhook-format", string(webhook.FormatJSON), "How to post events: json, or slack for a message summarizing each"),
+		secret: flags.String("webhook-secret", "", "Sign payloads with this secret, in the "+webhook.SignatureHeader+" header"),
+		idle:   flags.Duration("webhook-idle", 5*time.Minute, "Post the idle event once a document has gone this long without edits (0 never does)"),
+	}
+}
+
+// hooks returns the webhooks the flags give, none without URLs
+func (w webhookFlags) hooks() ([]webhook.Hook, error) {
+	if *w.urls == "" {
+		return nil, nil
+	}
+	format := webhook.Format(*w.format)
+	if format != webhook.FormatJSON && format != webhook.FormatSlack {
+		return nil, fmt.Errorf("unknown webhook format %q, expected json or slack", *w.format)
+	}
+	var events []webhook.Event
+	for _, name := range strings.Split(*w.events, ",") {
+		event, err := webhook.ParseEvent(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	var hooks []webhook.Hook
+	for _, url := range strings.Split(*w.urls, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			hooks = append(hooks, webhook.Hook{URL: url, Events: events, Diff: *w.diff, Format: format, Secret: *w.secret})
+		}
+	}
+	return hooks, nil
+}
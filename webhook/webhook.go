@@ -0,0 +1,287 @@
+// Package webhook posts document events, such as a document being saved or
+// someone joining it, to HTTP endpoints, for chat and CI integrations.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gollaborate/diff"
+)
+
+// Event is something that happened to a document
+type Event string
+
+const (
+	// EventSaved is fired when a document was saved to the store
+	EventSaved Event = "saved"
+	// EventJoined and EventLeft are fired when a client joins or leaves a document
+	EventJoined Event = "joined"
+	EventLeft   Event = "left"
+	// EventIdle is fired once a document went a while without edits
+	EventIdle Event = "idle"
+)
+
+// Events returns every event hooks may be posted
+func Events() []Event {
+	return []Event{EventSaved, EventJoined, EventLeft, EventIdle}
+}
+
+// ParseEvent returns the event with the given name
+func ParseEvent(name string) (Event, error) {
+	for _, event := range Events() {
+		if Event(name) == event {
+			return event, nil
+		}
+	}
+	return "", fmt.Errorf("unknown event %q, expected one of %v", name, Events())
+}
+
+// Format is how payloads are written for a hook
+type Format string
+
+const (
+	// FormatJSON posts the Payload as it is
+	FormatJSON Format = "json"
+	// FormatSlack posts a Slack message summarizing the event, as Slack's
+	// incoming webhooks take
+	FormatSlack Format = "slack"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of a payload, in hex, keyed with the
+// hook's secret, so the endpoint can tell the payload came from the server
+const SignatureHeader = "X-Gollaborate-Signature"
+
+const (
+	// backlog is how many payloads may wait for a hook before new ones are dropped
+	backlog = 256
+	// attempts is how many times a payload is posted before it is given up on
+	attempts = 3
+	// postTimeout is how long an endpoint has to answer
+	postTimeout = 10 * time.Second
+)
+
+// retryWait is how long the first retry waits; each after waits twice as long
+var retryWait = time.Second
+
+// Hook is an endpoint and what is posted to it
+type Hook struct {
+	URL    string
+	Events []Event // Those posted, every one if empty
+	// Diff posts the edits made to the document since the hook's last
+	// payload about it, rather than its whole text, after the first
+	Diff   bool
+	Format Format // FormatJSON if empty
+	Secret string // Signs each payload if set, see SignatureHeader
+}
+
+// wants reports whether an event is posted to the hook
+func (h Hook) wants(event Event) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Payload is what is posted about an event
+type Payload struct {
+	Event    Event     `json:"event"`
+	Document string    `json:"document"`
+	Time     time.Time `json:"time"`
+	UserID   int       `json:"user_id,omitempty"`   // Who joined or left
+	UserName string    `json:"user_name,omitempty"` // Who joined or left
+	Text     string    `json:"text,omitempty"`      // The document's text, unless the hook takes diffs
+	Changes  []Change  `json:"changes,omitempty"`   // For hooks taking diffs, the edits since the last payload
+}
+
+// Change is an edit to a document's text: an insert adds Text before the
+// character at Offset of the text the last payload had, counted in
+// characters from 0, and a delete removes the characters of Text from there
+type Change struct {
+	Op     string `json:"op"`
+	Offset int    `json:"offset"`
+	Text   string `json:"text"`
+}
+
+// Summary describes the event in a line, for chat
+func (p Payload) Summary() string {
+	who := p.UserName
+	if who == "" {
+		who = fmt.Sprintf("User-%d", p.UserID)
+	}
+	switch p.Event {
+	case EventSaved:
+		return fmt.Sprintf("%s was saved", p.Document)
+	case EventJoined:
+		return fmt.Sprintf("%s joined %s", who, p.Document)
+	case EventLeft:
+		return fmt.Sprintf("%s left %s", who, p.Document)
+	case EventIdle:
+		return fmt.Sprintf("%s went idle", p.Document)
+	}
+	return fmt.Sprintf("%s: %s", p.Document, p.Event)
+}
+
+// Dispatcher posts payloads to hooks in the background, in order for each
+// hook, so that firing an event never waits for an endpoint
+type Dispatcher struct {
+	queues  []*queue
+	client  *http.Client
+	onError func(error)
+	wg      sync.WaitGroup
+
+	mutex  sync.Mutex
+	closed bool
+}
+
+// queue is the payloads waiting for one hook, and the text it was last sent
+// of each document, for diffs
+type queue struct {
+	hook    Hook
+	pending chan Payload
+	texts   map[string]string
+}
+
+// New starts posting to hooks, telling onError why a payload was dropped or
+// could not be delivered
+func New(hooks []Hook, onError func(error)) *Dispatcher {
+	d := &Dispatcher{client: &http.Client{Timeout: postTimeout}, onError: onError}
+	for _, hook := range hooks {
+		q := &queue{hook: hook, pending: make(chan Payload, backlog), texts: make(map[string]string)}
+		d.queues = append(d.queues, q)
+		d.wg.Add(1)
+		go d.run(q)
+	}
+	return d
+}
+
+// Fire posts a payload to every hook that takes its event, without waiting
+func (d *Dispatcher) Fire(p Payload) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.closed {
+		return
+	}
+	for _, q := range d.queues {
+		if !q.hook.wants(p.Event) {
+			continue
+		}
+		select {
+		case q.pending <- p:
+		default:
+			d.onError(fmt.Errorf("%s: dropped %s of %s: too many payloads waiting", q.hook.URL, p.Event, p.Document))
+		}
+	}
+}
+
+// Close stops taking payloads, and waits for those fired to be delivered
+func (d *Dispatcher) Close() {
+	d.mutex.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, q := range d.queues {
+			close(q.pending)
+		}
+	}
+	d.mutex.Unlock()
+	d.wg.Wait()
+}
+
+// run delivers a hook's payloads until the dispatcher closes
+func (d *Dispatcher) run(q *queue) {
+	defer d.wg.Done()
+	for p := range q.pending {
+		body, err := q.body(p)
+		if err == nil {
+			err = d.post(q.hook, body)
+		}
+		if err != nil {
+			d.onError(fmt.Errorf("%s: posting %s of %s: %w", q.hook.URL, p.Event, p.Document, err))
+			continue
+		}
+		q.delivered(p)
+	}
+}
+
+// body writes a payload as the hook takes it, for diffs against the text the
+// hook was last sent
+func (q *queue) body(p Payload) ([]byte, error) {
+	if q.hook.Diff {
+		if old, seen := q.texts[p.Document]; seen {
+			p.Changes = changes(old, p.Text)
+			p.Text = ""
+		}
+	}
+	if q.hook.Format == FormatSlack {
+		return json.Marshal(map[string]string{"text": p.Summary()})
+	}
+	return json.Marshal(p)
+}
+
+// delivered notes the text a hook now has of a payload's document. Only
+// payloads it got count, so after a failure the next diff still covers the
+// edits the failed one carried.
+func (q *queue) delivered(p Payload) {
+	if q.hook.Diff {
+		q.texts[p.Document] = p.Text
+	}
+}
+
+// changes returns the edits that turn old into new
+func changes(old, new string) []Change {
+	var changes []Change
+	for _, edit := range diff.Default.Diff(old, new) {
+		changes = append(changes, Change{Op: edit.Op.String(), Offset: edit.Offset, Text: edit.Text})
+	}
+	return changes
+}
+
+// post posts a body to a hook, trying again after failing, waiting longer
+// each time
+func (d *Dispatcher) post(hook Hook, body []byte) error {
+	wait := retryWait
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = d.postOnce(hook, body); err == nil || attempt == attempts {
+			return err
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// postOnce posts a body to a hook once, signed if it has a secret
+func (d *Dispatcher) postOnce(hook Hook, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("answered %s", strings.TrimSpace(resp.Status))
+	}
+	return nil
+}
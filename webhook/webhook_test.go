@@ -0,0 +1,169 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// post is a request an endpoint received
+type post struct {
+	body      []byte
+	signature string
+}
+
+// startEndpoint serves an endpoint telling a channel of each post, failing
+// the first so many
+func startEndpoint(t *testing.T, failures int) (string, chan post) {
+	t.Helper()
+
+	var mutex sync.Mutex
+	posts := make(chan post, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if failures > 0 {
+			failures--
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		posts <- post{body: body, signature: r.Header.Get(SignatureHeader)}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, posts
+}
+
+// receive waits for an endpoint's next post, decoded into v
+func receive(t *testing.T, posts chan post, v any) post {
+	t.Helper()
+
+	select {
+	case p := <-posts:
+		if err := json.Unmarshal(p.body, v); err != nil {
+			t.Fatalf("Failed to decode %s: %v", p.body, err)
+		}
+		return p
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a post")
+	}
+	return post{}
+}
+
+func TestDispatcher(t *testing.T) {
+	retryWait = time.Millisecond
+	all, allPosts := startEndpoint(t, 1)
+	saves, savesPosts := startEndpoint(t, 0)
+	d := New([]Hook{
+		{URL: all, Secret: "s3cret"},
+		{URL: saves, Events: []Event{EventSaved}, Diff: true},
+	}, func(err error) { t.Errorf("Unexpected error: %v", err) })
+
+	d.Fire(Payload{Event: EventJoined, Document: "notes", UserID: 3, UserName: "grace", Text: "hello"})
+	d.Fire(Payload{Event: EventSaved, Document: "notes", Text: "hello"})
+	d.Fire(Payload{Event: EventSaved, Document: "notes", Text: "help"})
+
+	// Every event is posted to the first hook, signed, despite it failing once
+	var got Payload
+	p := receive(t, allPosts, &got)
+	if got.Event != EventJoined || got.UserName != "grace" || got.Text != "hello" {
+		t.Errorf("Expected grace joining, got %+v", got)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(p.body)
+	if p.signature != hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("Expected the payload signed, got %q", p.signature)
+	}
+	for range 2 {
+		if receive(t, allPosts, &got); got.Event != EventSaved {
+			t.Errorf("Expected a save, got %+v", got)
+		}
+	}
+
+	// Only saves to the second, the text once then what changed
+	if receive(t, savesPosts, &got); got.Text != "hello" || got.Changes != nil {
+		t.Errorf("Expected the whole text first, got %+v", got)
+	}
+	got = Payload{}
+	receive(t, savesPosts, &got)
+	want := []Change{{Op: "delete", Offset: 3, Text: "lo"}, {Op: "insert", Offset: 5, Text: "p"}}
+	if got.Text != "" || !reflect.DeepEqual(got.Changes, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	d.Close()
+	d.Fire(Payload{Event: EventSaved, Document: "notes"})
+	select {
+	case p := <-savesPosts:
+		t.Errorf("Expected nothing posted after closing, got %s", p.body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDispatcherSlack(t *testing.T) {
+	url, posts := startEndpoint(t, 0)
+	d := New([]Hook{{URL: url, Format: FormatSlack}}, nil)
+	defer d.Close()
+
+	d.Fire(Payload{Event: EventLeft, Document: "notes", UserName: "grace"})
+	var got map[string]string
+	if receive(t, posts, &got); got["text"] != "grace left notes" {
+		t.Errorf("Expected a Slack message, got %v", got)
+	}
+}
+
+func TestDispatcherFailing(t *testing.T) {
+	retryWait = time.Millisecond
+	url, _ := startEndpoint(t, attempts)
+	errs := make(chan error, 1)
+	d := New([]Hook{{URL: url}}, func(err error) { errs <- err })
+	defer d.Close()
+
+	d.Fire(Payload{Event: EventIdle, Document: "notes"})
+	select {
+	case err := <-errs:
+		if err == nil || errors.Unwrap(err) == nil {
+			t.Errorf("Expected why the post failed, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the failure reported")
+	}
+}
+
+func TestDispatcherDiffAfterFailure(t *testing.T) {
+	retryWait = time.Millisecond
+	url, posts := startEndpoint(t, attempts)
+	errs := make(chan error, 1)
+	d := New([]Hook{{URL: url, Diff: true}}, func(err error) { errs <- err })
+	defer d.Close()
+
+	// The endpoint never got the first text, so the next is sent whole
+	d.Fire(Payload{Event: EventSaved, Document: "notes", Text: "hello"})
+	d.Fire(Payload{Event: EventSaved, Document: "notes", Text: "help"})
+	select {
+	case <-errs:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the failure reported")
+	}
+	var got Payload
+	if receive(t, posts, &got); got.Text != "help" || got.Changes != nil {
+		t.Errorf("Expected the whole text after a failure, got %+v", got)
+	}
+
+	// And the diffs after it are from what it got
+	d.Fire(Payload{Event: EventSaved, Document: "notes", Text: "helps"})
+	got = Payload{}
+	receive(t, posts, &got)
+	if want := []Change{{Op: "insert", Offset: 4, Text: "s"}}; got.Text != "" || !reflect.DeepEqual(got.Changes, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}